@@ -7,6 +7,8 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"net"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -120,3 +122,157 @@ func BenchmarkConnReadWrite(b *testing.B) {
 		benchmarkConn(b, n)
 	}
 }
+
+func BenchmarkReadThroughput(b *testing.B) {
+	for _, n := range []int{64, 512, 1200} {
+		n := n
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			benchmarkReadThroughput(b, n, false)
+		})
+		b.Run(fmt.Sprintf("%d/ReadBuffer", n), func(b *testing.B) {
+			benchmarkReadThroughput(b, n, true)
+		})
+	}
+}
+
+func benchmarkReadThroughput(b *testing.B, n int, readBuffer bool) {
+	ctx := context.Background()
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	clientRes := make(chan result)
+	go func() {
+		client, cErr := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
+		clientRes <- result{client, cErr}
+	}()
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+		Certificates: []tls.Certificate{certificate},
+	}, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	res := <-clientRes
+	if res.err != nil {
+		b.Fatal(res.err)
+	}
+	client := res.c
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		payload := make([]byte, n)
+		for {
+			if _, wErr := client.Write(payload); wErr != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, inboundBufferSize)
+	b.ReportAllocs()
+	b.SetBytes(int64(n))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if readBuffer {
+			_, err = server.ReadBuffer()
+		} else {
+			_, err = server.Read(buf)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	_ = client.Close()
+	<-writerDone
+	_ = server.Close()
+}
+
+// countingPacketConn counts the datagrams written to the wrapped PacketConn
+type countingPacketConn struct {
+	net.PacketConn
+	writes int64
+}
+
+func (c *countingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	atomic.AddInt64(&c.writes, 1)
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func BenchmarkWriteBuffer(b *testing.B) {
+	for _, size := range []int{0, 1024} {
+		size := size
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			ctx := context.Background()
+			certificate, err := selfsign.GenerateSelfSigned()
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			ca, cb := dpipe.Pipe()
+			clientConn := &countingPacketConn{PacketConn: dtlsnet.PacketConnFromConn(ca)}
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result)
+			go func() {
+				client, cErr := testClient(ctx, clientConn, ca.RemoteAddr(), &Config{}, false)
+				clientRes <- result{client, cErr}
+			}()
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				Certificates: []tls.Certificate{certificate},
+			}, false)
+			if err != nil {
+				b.Fatal(err)
+			}
+			res := <-clientRes
+			if res.err != nil {
+				b.Fatal(res.err)
+			}
+			client := res.c
+			if err := client.SetWriteBufferSize(size); err != nil {
+				b.Fatal(err)
+			}
+
+			readerDone := make(chan struct{})
+			go func() {
+				defer close(readerDone)
+				buf := make([]byte, inboundBufferSize)
+				for {
+					if _, rErr := server.Read(buf); rErr != nil {
+						return
+					}
+				}
+			}()
+
+			payload := make([]byte, 32)
+			b.ReportAllocs()
+			b.SetBytes(int64(len(payload)))
+			writes := atomic.LoadInt64(&clientConn.writes)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Write(payload); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := client.Flush(); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(atomic.LoadInt64(&clientConn.writes)-writes)/float64(b.N), "datagrams/op")
+
+			_ = client.Close()
+			_ = server.Close()
+			<-readerDone
+		})
+	}
+}
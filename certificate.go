@@ -9,6 +9,14 @@ import (
 	"crypto/x509"
 	"fmt"
 	"strings"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
+	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"golang.org/x/crypto/cryptobyte"
 )
 
 // ClientHelloInfo contains information from a ClientHello message in order to
@@ -20,8 +28,114 @@ type ClientHelloInfo struct {
 	ServerName string
 
 	// CipherSuites lists the CipherSuites supported by the client (e.g.
-	// TLS_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256).
+	// TLS_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+	// in its order of preference.
 	CipherSuites []CipherSuiteID
+
+	// SupportedCurves lists the elliptic curves supported by the client, in
+	// its order of preference. It is empty if the client did not send the
+	// supported_groups extension.
+	SupportedCurves []elliptic.Curve
+
+	// SupportedPoints lists the point formats supported by the client. It
+	// is empty if the client did not send the ec_point_formats extension.
+	SupportedPoints []elliptic.CurvePointFormat
+
+	// SupportedProtocols lists the application protocols offered by the
+	// client with ALPN (see RFC 7301). It is empty if the client did not
+	// send the application_layer_protocol_negotiation extension.
+	SupportedProtocols []string
+
+	// Extensions holds the data of every extension of the ClientHello by
+	// its type, including extensions this package does not understand.
+	Extensions map[extension.TypeValue][]byte
+
+	// TrustedAuthorities lists the certificate authorities the client
+	// advertised in the trusted_ca_keys extension (see RFC 6066, Section 6).
+	TrustedAuthorities []extension.TrustedAuthority
+
+	// SignatureSchemes lists the signature and hash algorithms that the
+	// client is willing to verify. It is empty if the client did not send
+	// the signature_algorithms extension.
+	SignatureSchemes []tls.SignatureScheme
+
+	// CertificateSignatureSchemes lists the signature and hash algorithms
+	// that the client accepts in certificates, from the
+	// signature_algorithms_cert extension. It is empty if the client did
+	// not send the extension.
+	CertificateSignatureSchemes []tls.SignatureScheme
+
+	// selectedCipherSuite, if set, is the cipher suite chosen by the
+	// server, a certificate must authenticate it rather than any of
+	// CipherSuites
+	selectedCipherSuite *CipherSuiteID
+}
+
+// newClientHelloInfo returns the ClientHelloInfo of the last ClientHello of
+// epoch in cache
+func newClientHelloInfo(cache *handshakeCache, epoch uint16) (*ClientHelloInfo, error) {
+	item := cache.pull(handshakeCachePullRule{handshake.TypeClientHello, epoch, true, false})[0]
+	if item == nil || len(item.data) < handshake.HeaderLength {
+		return nil, errClientHelloNotCached
+	}
+	body := item.data[handshake.HeaderLength:]
+	clientHello := &handshake.MessageClientHello{}
+	if err := clientHello.Unmarshal(body); err != nil {
+		return nil, err
+	}
+
+	info := &ClientHelloInfo{Extensions: rawClientHelloExtensions(body)}
+	for _, id := range clientHello.CipherSuiteIDs {
+		info.CipherSuites = append(info.CipherSuites, CipherSuiteID(id))
+	}
+	for _, val := range clientHello.Extensions {
+		switch e := val.(type) {
+		case *extension.ServerName:
+			info.ServerName = e.ServerName
+		case *extension.SupportedEllipticCurves:
+			info.SupportedCurves = e.EllipticCurves
+		case *extension.SupportedPointFormats:
+			info.SupportedPoints = e.PointFormats
+		case *extension.SupportedSignatureAlgorithms:
+			for _, a := range e.SignatureHashAlgorithms {
+				info.SignatureSchemes = append(info.SignatureSchemes, tls.SignatureScheme(uint16(a.Hash)<<8|uint16(a.Signature)))
+			}
+		case *extension.SignatureAlgorithmsCert:
+			for _, a := range e.SignatureHashAlgorithms {
+				info.CertificateSignatureSchemes = append(info.CertificateSignatureSchemes, tls.SignatureScheme(uint16(a.Hash)<<8|uint16(a.Signature)))
+			}
+		case *extension.ALPN:
+			info.SupportedProtocols = e.ProtocolNameList
+		case *extension.TrustedCAKeys:
+			info.TrustedAuthorities = e.TrustedAuthorities
+		}
+	}
+	return info, nil
+}
+
+// rawClientHelloExtensions returns the data of the extensions of a
+// ClientHello body by type, nil if it has none or they are malformed
+func rawClientHelloExtensions(body []byte) map[extension.TypeValue][]byte {
+	s := cryptobyte.String(body)
+	var sessionID, cookie, cipherSuites, compressionMethods, extensions cryptobyte.String
+	if !s.Skip(2+handshake.RandomLength) ||
+		!s.ReadUint8LengthPrefixed(&sessionID) ||
+		!s.ReadUint8LengthPrefixed(&cookie) ||
+		!s.ReadUint16LengthPrefixed(&cipherSuites) ||
+		!s.ReadUint8LengthPrefixed(&compressionMethods) ||
+		!s.ReadUint16LengthPrefixed(&extensions) {
+		return nil
+	}
+	raw := map[extension.TypeValue][]byte{}
+	for !extensions.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !extensions.ReadUint16(&typ) || !extensions.ReadUint16LengthPrefixed(&data) {
+			return nil
+		}
+		raw[extension.TypeValue(typ)] = append([]byte{}, data...)
+	}
+	return raw
 }
 
 // CertificateRequestInfo contains information from a server's
@@ -33,6 +147,20 @@ type CertificateRequestInfo struct {
 	// that the server wishes the returned certificate to be signed by. An
 	// empty slice indicates that the server has no preference.
 	AcceptableCAs [][]byte
+
+	// SignatureSchemes lists the signature and hash algorithms that the
+	// server is willing to verify, in the order of its preference.
+	SignatureSchemes []tls.SignatureScheme
+}
+
+// newCertificateRequestInfo returns the CertificateRequestInfo of a
+// CertificateRequest message
+func newCertificateRequestInfo(r *handshake.MessageCertificateRequest) *CertificateRequestInfo {
+	cri := &CertificateRequestInfo{AcceptableCAs: r.CertificateAuthoritiesNames}
+	for _, a := range r.SignatureHashAlgorithms {
+		cri.SignatureSchemes = append(cri.SignatureSchemes, tls.SignatureScheme(uint16(a.Hash)<<8|uint16(a.Signature)))
+	}
+	return cri
 }
 
 // SupportsCertificate returns nil if the provided certificate is supported by
@@ -90,8 +218,9 @@ func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.localGetCertificate != nil &&
-		(len(c.localCertificates) == 0 || len(clientHelloInfo.ServerName) > 0) {
+	// The callback takes precedence over Certificates, these are only used if
+	// it does not return a certificate
+	if c.localGetCertificate != nil {
 		cert, err := c.localGetCertificate(clientHelloInfo)
 		if cert != nil || err != nil {
 			return cert, err
@@ -111,13 +240,35 @@ func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls
 		return &c.localCertificates[0], nil
 	}
 
+	// Only consider the certificates that work with the cipher suites and
+	// signature schemes of the client, e.g. the RSA certificate of a server
+	// that also holds an ECDSA one for a client without ECDSA support
+	candidates := c.supportedCertificatesLocked(clientHelloInfo)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	// Prefer a chain the client trusts, as long as it is valid for the name
+	// the client asked for
+	if len(clientHelloInfo.TrustedAuthorities) > 0 {
+		for _, cert := range candidates {
+			if !matchesTrustedAuthorities(cert, clientHelloInfo.TrustedAuthorities) {
+				continue
+			}
+			if len(clientHelloInfo.ServerName) > 0 && !certificateMatchesHostname(cert, clientHelloInfo.ServerName) {
+				continue
+			}
+			return cert, nil
+		}
+	}
+
 	if len(clientHelloInfo.ServerName) == 0 {
-		return &c.localCertificates[0], nil
+		return candidates[0], nil
 	}
 
 	name := strings.TrimRight(strings.ToLower(clientHelloInfo.ServerName), ".")
 
-	if cert, ok := c.nameToCertificate[name]; ok {
+	if cert, ok := c.nameToCertificate[name]; ok && containsCertificate(candidates, cert) {
 		return cert, nil
 	}
 
@@ -127,13 +278,209 @@ func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls
 	for i := range labels {
 		labels[i] = "*"
 		candidate := strings.Join(labels, ".")
-		if cert, ok := c.nameToCertificate[candidate]; ok {
+		if cert, ok := c.nameToCertificate[candidate]; ok && containsCertificate(candidates, cert) {
+			return cert, nil
+		}
+	}
+
+	// The name may belong to a certificate that is shadowed in
+	// nameToCertificate by one the client doesn't support
+	for _, cert := range candidates {
+		if certificateMatchesHostname(cert, clientHelloInfo.ServerName) {
 			return cert, nil
 		}
 	}
 
 	// If nothing matches, return the first certificate.
-	return &c.localCertificates[0], nil
+	return candidates[0], nil
+}
+
+// canAuthenticate reports whether one of Certificates or RawPublicKey can
+// authenticate a certificate cipher suite and sign with one of
+// remoteSchemes. It is always true with GetCertificate, which may return any
+// certificate.
+func (c *handshakeConfig) canAuthenticate(suite CipherSuite, remoteSchemes []signaturehash.Algorithm) bool {
+	if suite.AuthenticationType() != CipherSuiteAuthenticationTypeCertificate || c.localGetCertificate != nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.localCertificates) == 0 {
+		return true
+	}
+	schemes := offeredSignatureSchemes(c.localSignatureSchemes, remoteSchemes)
+	candidates := make([]*tls.Certificate, 0, len(c.localCertificates)+1)
+	for i := range c.localCertificates {
+		candidates = append(candidates, &c.localCertificates[i])
+	}
+	if c.localRawPublicKey != nil {
+		candidates = append(candidates, &tls.Certificate{PrivateKey: c.localRawPublicKey})
+	}
+	for _, cert := range candidates {
+		if certificateType(cert) != suite.CertificateType() {
+			continue
+		}
+		if _, err := signaturehash.SelectSignatureScheme(schemes, cert.PrivateKey); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedCertificatesLocked returns the certificates that can authenticate
+// one of the cipher suites of clientHelloInfo and sign with one of its
+// signature schemes, or all certificates if none of them can.
+func (c *handshakeConfig) supportedCertificatesLocked(clientHelloInfo *ClientHelloInfo) []*tls.Certificate {
+	schemes := offeredSignatureSchemes(c.localSignatureSchemes, signatureHashAlgorithms(clientHelloInfo.SignatureSchemes))
+	suites := clientHelloInfo.CipherSuites
+	if clientHelloInfo.selectedCipherSuite != nil {
+		suites = []CipherSuiteID{*clientHelloInfo.selectedCipherSuite}
+	}
+
+	all := make([]*tls.Certificate, 0, len(c.localCertificates))
+	supported := []*tls.Certificate{}
+	for i := range c.localCertificates {
+		cert := &c.localCertificates[i]
+		all = append(all, cert)
+		if c.supportsCipherSuites(cert, suites) {
+			if _, err := signaturehash.SelectSignatureScheme(schemes, cert.PrivateKey); err == nil {
+				supported = append(supported, cert)
+			}
+		}
+	}
+	if len(supported) == 0 {
+		return all
+	}
+
+	// Prefer the chains signed with schemes the client accepts in
+	// certificates
+	if len(clientHelloInfo.CertificateSignatureSchemes) > 0 {
+		certSchemes := signatureHashAlgorithms(clientHelloInfo.CertificateSignatureSchemes)
+		acceptable := []*tls.Certificate{}
+		for _, cert := range supported {
+			if verifyCertificateSignatureSchemes(cert.Certificate, certSchemes) == nil {
+				acceptable = append(acceptable, cert)
+			}
+		}
+		if len(acceptable) > 0 {
+			return acceptable
+		}
+	}
+	return supported
+}
+
+// signatureHashAlgorithms translates the schemes of a ClientHelloInfo
+func signatureHashAlgorithms(schemes []tls.SignatureScheme) []signaturehash.Algorithm {
+	var algorithms []signaturehash.Algorithm
+	for _, s := range schemes {
+		algorithms = append(algorithms, signaturehash.Algorithm{
+			Hash:      hash.Algorithm(s >> 8),
+			Signature: signature.Algorithm(s & 0xff),
+		})
+	}
+	return algorithms
+}
+
+// certificateSignatureScheme returns the scheme cert is signed with, false if
+// it has no TLS equivalent
+func certificateSignatureScheme(cert *x509.Certificate) (signaturehash.Algorithm, bool) {
+	switch cert.SignatureAlgorithm {
+	case x509.SHA1WithRSA:
+		return signaturehash.Algorithm{Hash: hash.SHA1, Signature: signature.RSA}, true
+	case x509.SHA256WithRSA:
+		return signaturehash.Algorithm{Hash: hash.SHA256, Signature: signature.RSA}, true
+	case x509.SHA384WithRSA:
+		return signaturehash.Algorithm{Hash: hash.SHA384, Signature: signature.RSA}, true
+	case x509.SHA512WithRSA:
+		return signaturehash.Algorithm{Hash: hash.SHA512, Signature: signature.RSA}, true
+	case x509.SHA256WithRSAPSS:
+		return signaturehash.Algorithm{Hash: hash.Ed25519, Signature: signature.RSA_PSS_RSAE_SHA256}, true
+	case x509.SHA384WithRSAPSS:
+		return signaturehash.Algorithm{Hash: hash.Ed25519, Signature: signature.RSA_PSS_RSAE_SHA384}, true
+	case x509.SHA512WithRSAPSS:
+		return signaturehash.Algorithm{Hash: hash.Ed25519, Signature: signature.RSA_PSS_RSAE_SHA512}, true
+	case x509.ECDSAWithSHA1:
+		return signaturehash.Algorithm{Hash: hash.SHA1, Signature: signature.ECDSA}, true
+	case x509.ECDSAWithSHA256:
+		return signaturehash.Algorithm{Hash: hash.SHA256, Signature: signature.ECDSA}, true
+	case x509.ECDSAWithSHA384:
+		return signaturehash.Algorithm{Hash: hash.SHA384, Signature: signature.ECDSA}, true
+	case x509.ECDSAWithSHA512:
+		return signaturehash.Algorithm{Hash: hash.SHA512, Signature: signature.ECDSA}, true
+	case x509.PureEd25519:
+		return signaturehash.Algorithm{Hash: hash.Ed25519, Signature: signature.Ed25519}, true
+	default:
+		return signaturehash.Algorithm{}, false
+	}
+}
+
+// verifyCertificateSignatureSchemes checks that the certificates of a chain
+// are signed with one of schemes. A self-signed CA after the leaf is skipped,
+// its signature is not used to build the path.
+// https://tools.ietf.org/html/rfc8446#section-4.2.3
+func verifyCertificateSignatureSchemes(rawCertificates [][]byte, schemes []signaturehash.Algorithm) error {
+	for i, raw := range rawCertificates {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		if i > 0 && cert.IsCA && bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			continue
+		}
+		scheme, ok := certificateSignatureScheme(cert)
+		if !ok || !containsSignatureScheme(schemes, scheme) {
+			return errCertificateSignatureScheme
+		}
+	}
+	return nil
+}
+
+func containsSignatureScheme(schemes []signaturehash.Algorithm, scheme signaturehash.Algorithm) bool {
+	for _, s := range schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsCipherSuites reports whether cert can authenticate one of the
+// certificate cipher suites in ids, any certificate is fine without those
+func (c *handshakeConfig) supportsCipherSuites(cert *tls.Certificate, ids []CipherSuiteID) bool {
+	found := false
+	for _, id := range ids {
+		suite := cipherSuiteForID(id, c.customCipherSuites)
+		if suite == nil || suite.AuthenticationType() != CipherSuiteAuthenticationTypeCertificate {
+			continue
+		}
+		if suite.CertificateType() == certificateType(cert) {
+			return true
+		}
+		found = true
+	}
+	return !found
+}
+
+func containsCertificate(certs []*tls.Certificate, cert *tls.Certificate) bool {
+	for _, c := range certs {
+		if c == cert {
+			return true
+		}
+	}
+	return false
+}
+
+// certificateMatchesHostname reports whether the leaf of cert is valid for
+// name
+func certificateMatchesHostname(cert *tls.Certificate, name string) bool {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false
+		}
+	}
+	return leaf.VerifyHostname(name) == nil
 }
 
 // NOTE: original src: https://github.com/golang/go/blob/29b9a328d268d53833d2cc063d1d8b4bf6852675/src/crypto/tls/handshake_client.go#L974
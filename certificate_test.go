@@ -5,10 +5,19 @@ package dtls
 
 import (
 	"crypto/tls"
+	"encoding/binary"
+	"errors"
 	"reflect"
 	"testing"
 
+	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
+	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
 	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 )
 
 func TestGetCertificate(t *testing.T) {
@@ -82,6 +91,28 @@ func TestGetCertificate(t *testing.T) {
 			},
 			expectedCertificate: certificateTest,
 		},
+		{
+			desc: "Callback takes precedence over Certificates",
+			localCertificates: []tls.Certificate{
+				certificateRandom,
+			},
+			getCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) {
+				return &certificateTest, nil
+			},
+			expectedCertificate: certificateTest,
+		},
+		{
+			desc: "Callback returns nil",
+			localCertificates: []tls.Certificate{
+				certificateRandom,
+				certificateTest,
+			},
+			serverName: "test.test",
+			getCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) {
+				return nil, nil
+			},
+			expectedCertificate: certificateTest,
+		},
 	}
 
 	for _, test := range testCases {
@@ -102,3 +133,78 @@ func TestGetCertificate(t *testing.T) {
 		})
 	}
 }
+
+func TestNewClientHelloInfo(t *testing.T) {
+	extensions := []extension.Extension{
+		&extension.ServerName{ServerName: "example.com"},
+		&extension.SupportedEllipticCurves{EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256}},
+		&extension.SupportedPointFormats{PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed}},
+		&extension.SupportedSignatureAlgorithms{SignatureHashAlgorithms: []signaturehash.Algorithm{
+			{Hash: hash.SHA256, Signature: signature.ECDSA},
+			{Hash: hash.Ed25519, Signature: signature.Ed25519},
+		}},
+		&extension.ALPN{ProtocolNameList: []string{"coap", "h2"}},
+	}
+	clientHello := &handshake.MessageClientHello{
+		Version:            protocol.Version1_2,
+		Cookie:             []byte{},
+		CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), uint16(TLS_PSK_WITH_AES_128_CCM_8)},
+		CompressionMethods: defaultCompressionMethods(),
+		Extensions:         extensions,
+	}
+	body, err := clientHello.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An extension this package does not parse, extending the length of
+	// the extensions
+	unknown := []byte{0x0a, 0x0a, 0x00, 0x01, 0x00}
+	extensionsLen := 2 // The length prefix
+	for _, e := range extensions {
+		raw, err := e.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		extensionsLen += len(raw)
+	}
+	lengthOffset := len(body) - extensionsLen
+	binary.BigEndian.PutUint16(body[lengthOffset:], uint16(extensionsLen-2+len(unknown)))
+	body = append(body, unknown...)
+
+	header := &handshake.Header{Type: handshake.TypeClientHello, Length: uint32(len(body)), FragmentLength: uint32(len(body))}
+	rawHeader, err := header.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newHandshakeCache()
+	cache.push(append(rawHeader, body...), 0, 0, handshake.TypeClientHello, true)
+
+	info, err := newClientHelloInfo(cache, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ClientHelloInfo{
+		ServerName:         "example.com",
+		CipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_CCM_8},
+		SupportedCurves:    []elliptic.Curve{elliptic.X25519, elliptic.P256},
+		SupportedPoints:    []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+		SupportedProtocols: []string{"coap", "h2"},
+		SignatureSchemes:   []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.Ed25519},
+		Extensions:         map[extension.TypeValue][]byte{0x0a0a: {0x00}},
+	}
+	for _, e := range extensions {
+		raw, err := e.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected.Extensions[e.TypeValue()] = raw[4:]
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("ClientHelloInfo mismatch\nwant: %+v\ngot: %+v", expected, info)
+	}
+
+	if _, err := newClientHelloInfo(newHandshakeCache(), 0); !errors.Is(err, errClientHelloNotCached) {
+		t.Errorf("Expected error '%v', got '%v'", errClientHelloNotCached, err)
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// oidSignedCertificateTimestampList is the X.509v3 extension carrying SCTs
+// embedded by the issuing CA
+// https://tools.ietf.org/html/rfc6962#section-3.3
+var oidSignedCertificateTimestampList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// embeddedSCTs returns the SCTs embedded in cert, if any.
+func embeddedSCTs(cert *x509.Certificate) ([][]byte, error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSignedCertificateTimestampList) {
+			continue
+		}
+
+		// The extension value is an OCTET STRING wrapping the TLS encoded
+		// SignedCertificateTimestampList
+		var raw []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &raw); err != nil || len(rest) != 0 {
+			return nil, errInvalidSCTList
+		}
+
+		val := cryptobyte.String(raw)
+		var list cryptobyte.String
+		if !val.ReadUint16LengthPrefixed(&list) || !val.Empty() {
+			return nil, errInvalidSCTList
+		}
+		var scts [][]byte
+		for !list.Empty() {
+			var sct cryptobyte.String
+			if !list.ReadUint16LengthPrefixed(&sct) || sct.Empty() {
+				return nil, errInvalidSCTList
+			}
+			scts = append(scts, sct)
+		}
+		return scts, nil
+	}
+	return nil, nil
+}
+
+// peerSCTs returns the SCTs the peer presented. The ones delivered in the
+// signed_certificate_timestamp extension take precedence over the ones
+// embedded in its leaf certificate.
+func peerSCTs(rawCertificates [][]byte, extensionSCTs [][]byte) ([][]byte, error) {
+	if len(extensionSCTs) > 0 {
+		return extensionSCTs, nil
+	}
+	if len(rawCertificates) == 0 {
+		return nil, nil
+	}
+	certificate, err := x509.ParseCertificate(rawCertificates[0])
+	if err != nil {
+		return nil, err
+	}
+	return embeddedSCTs(certificate)
+}
+
+// verifySCTs checks that the peer presented at least one SCT, either in the
+// signed_certificate_timestamp extension or embedded in its leaf certificate.
+func verifySCTs(rawCertificates [][]byte, extensionSCTs [][]byte) error {
+	scts, err := peerSCTs(rawCertificates, extensionSCTs)
+	if err != nil {
+		return err
+	}
+	if len(scts) == 0 {
+		return errNoSCT
+	}
+	return nil
+}
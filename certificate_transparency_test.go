@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// generateSCTCertificate creates a self-signed certificate with the raw
+// SignedCertificateTimestampList embedded, or none if sctList is nil.
+func generateSCTCertificate(sctList []byte) (tls.Certificate, error) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(0, 1, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	if sctList != nil {
+		value, err := asn1.Marshal(sctList)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: oidSignedCertificateTimestampList, Value: value}}
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: priv}, nil
+}
+
+func TestEmbeddedSCTs(t *testing.T) {
+	for name, tt := range map[string]struct {
+		sctList  []byte
+		expected [][]byte
+		err      error
+	}{
+		"None": {},
+		"Valid": {
+			sctList:  []byte{0x00, 0x07, 0x00, 0x02, 0x01, 0x02, 0x00, 0x01, 0x03},
+			expected: [][]byte{{0x01, 0x02}, {0x03}},
+		},
+		"Truncated": {
+			sctList: []byte{0x00, 0x04, 0x00, 0x05, 0x01, 0x02},
+			err:     errInvalidSCTList,
+		},
+		"TrailingData": {
+			sctList: []byte{0x00, 0x03, 0x00, 0x01, 0x01, 0xff},
+			err:     errInvalidSCTList,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			cert, err := generateSCTCertificate(tt.sctList)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			scts, err := embeddedSCTs(parsed)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Expected error %v, got %v", tt.err, err)
+			}
+			if !reflect.DeepEqual(scts, tt.expected) {
+				t.Fatalf("Expected SCTs %v, got %v", tt.expected, scts)
+			}
+		})
+	}
+}
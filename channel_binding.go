@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+// Channel binding types supported by GetChannelBinding
+const (
+	// ChannelBindingTLSUnique is the verify_data of the first Finished
+	// message of the last handshake https://tools.ietf.org/html/rfc5929#section-3
+	ChannelBindingTLSUnique = "tls-unique"
+	// ChannelBindingTLSExporter is exported keying material
+	// https://tools.ietf.org/html/rfc9266#section-2
+	ChannelBindingTLSExporter = "tls-exporter"
+
+	tlsExporterLabel  = "EXPORTER-Channel-Binding"
+	tlsExporterLength = 32
+)
+
+// GetChannelBinding returns the channel binding of type cbType, which binds
+// authentication at the application layer to this connection. It fails until
+// the handshake completed and while the connection is renegotiated.
+//
+// tls-exporter requires the extended master secret, without it the exported
+// value is not unique to the connection.
+func (c *Conn) GetChannelBinding(cbType string) ([]byte, error) {
+	if !c.isHandshakeCompletedSuccessfully() || c.isRenegotiating() {
+		return nil, errHandshakeInProgress
+	}
+
+	switch cbType {
+	case ChannelBindingTLSUnique:
+		c.lock.RLock()
+		defer c.lock.RUnlock()
+		// The server sends the first Finished message when a session is
+		// resumed
+		verifyData := c.state.clientVerifyData
+		if c.state.resumed {
+			verifyData = c.state.serverVerifyData
+		}
+		return append([]byte{}, verifyData...), nil
+	case ChannelBindingTLSExporter:
+		state := c.ConnectionState()
+		if !state.extendedMasterSecret {
+			return nil, errChannelBindingRequiresEMS
+		}
+		return state.ExportKeyingMaterial(tlsExporterLabel, []byte{}, tlsExporterLength)
+	default:
+		return nil, errUnsupportedChannelBinding
+	}
+}
@@ -4,6 +4,8 @@
 package dtls
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
@@ -36,6 +38,10 @@ const (
 	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA CipherSuiteID = ciphersuite.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA //nolint:revive,stylecheck
 	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA   CipherSuiteID = ciphersuite.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA   //nolint:revive,stylecheck
 
+	// ChaCha20-Poly1305-SHA256
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 CipherSuiteID = ciphersuite.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 //nolint:revive,stylecheck
+	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256   CipherSuiteID = ciphersuite.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256   //nolint:revive,stylecheck
+
 	TLS_PSK_WITH_AES_128_CCM        CipherSuiteID = ciphersuite.TLS_PSK_WITH_AES_128_CCM        //nolint:revive,stylecheck
 	TLS_PSK_WITH_AES_128_CCM_8      CipherSuiteID = ciphersuite.TLS_PSK_WITH_AES_128_CCM_8      //nolint:revive,stylecheck
 	TLS_PSK_WITH_AES_256_CCM_8      CipherSuiteID = ciphersuite.TLS_PSK_WITH_AES_256_CCM_8      //nolint:revive,stylecheck
@@ -43,6 +49,7 @@ const (
 	TLS_PSK_WITH_AES_128_CBC_SHA256 CipherSuiteID = ciphersuite.TLS_PSK_WITH_AES_128_CBC_SHA256 //nolint:revive,stylecheck
 
 	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 CipherSuiteID = ciphersuite.TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 //nolint:revive,stylecheck
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 CipherSuiteID = ciphersuite.TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 //nolint:revive,stylecheck
 )
 
 // CipherSuiteAuthenticationType controls what authentication method is using during the handshake for a CipherSuite
@@ -98,6 +105,72 @@ type CipherSuite interface {
 	Decrypt(h recordlayer.Header, in []byte) ([]byte, error)
 }
 
+// encryptThenMACCipherSuite is implemented by the CBC cipher suites, which
+// support the encrypt_then_mac extension
+type encryptThenMACCipherSuite interface {
+	CipherSuite
+	SetEncryptThenMAC(enabled bool)
+}
+
+func supportsEncryptThenMAC(c CipherSuite) bool {
+	_, ok := c.(encryptThenMACCipherSuite)
+	return ok
+}
+
+// keyLengthsCipherSuite is implemented by the cipher suites of this package,
+// it reports the lengths of the keys derived from the master secret
+type keyLengthsCipherSuite interface {
+	CipherSuite
+	KeyLengths() (macLen, keyLen, ivLen int)
+}
+
+// prfHashFuncCipherSuite is implemented by the cipher suites of this package,
+// it reports the hash Init derives the keys with. A custom suite embedding one
+// of them and replacing HashFunc has to replace Init as well.
+type prfHashFuncCipherSuite interface {
+	CipherSuite
+	PRFHashFunc() func() hash.Hash
+}
+
+// checkCipherSuiteHash makes sure the hash of the handshake transcript and the
+// master secret, HashFunc, is the one the keys are derived with
+func checkCipherSuiteHash(c CipherSuite) error {
+	p, ok := c.(prfHashFuncCipherSuite)
+	if !ok {
+		return nil
+	}
+	if !bytes.Equal(c.HashFunc()().Sum(nil), p.PRFHashFunc()().Sum(nil)) {
+		return fmt.Errorf("%w: %s", errCipherSuiteHashMismatch, c)
+	}
+	return nil
+}
+
+// prfHash identifies the hash returned by HashFunc by hashing empty input
+// with each candidate
+func prfHash(hashFunc func() hash.Hash) (crypto.Hash, bool) {
+	sum := hashFunc().Sum(nil)
+	for _, h := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512, crypto.SHA1} {
+		if h.Available() && bytes.Equal(h.New().Sum(nil), sum) {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+// offersEncryptThenMAC returns if a ClientHello offers encrypt_then_mac,
+// which is only useful with a CBC cipher suite
+func offersEncryptThenMAC(cfg *handshakeConfig) bool {
+	if cfg.disableEncryptThenMAC {
+		return false
+	}
+	for _, c := range cfg.localCipherSuites {
+		if supportsEncryptThenMAC(c) {
+			return true
+		}
+	}
+	return false
+}
+
 // CipherSuiteName provides the same functionality as tls.CipherSuiteName
 // that appeared first in Go 1.14.
 //
@@ -113,8 +186,18 @@ func CipherSuiteName(id CipherSuiteID) string {
 
 // Taken from https://www.iana.org/assignments/tls-parameters/tls-parameters.xml
 // A cipherSuite is a specific combination of key agreement, cipher and MAC
-// function.
+// function. A custom cipher suite takes precedence over the built-in one of
+// the same ID, so the negotiated ID always selects the PRF hash and key
+// derivation of the suite the Config enabled.
 func cipherSuiteForID(id CipherSuiteID, customCiphers func() []CipherSuite) CipherSuite {
+	if customCiphers != nil {
+		for _, c := range customCiphers() {
+			if c.ID() == id {
+				return c
+			}
+		}
+	}
+
 	switch id { //nolint:exhaustive
 	case TLS_ECDHE_ECDSA_WITH_AES_128_CCM:
 		return ciphersuite.NewTLSEcdheEcdsaWithAes128Ccm()
@@ -144,14 +227,12 @@ func cipherSuiteForID(id CipherSuiteID, customCiphers func() []CipherSuite) Ciph
 		return &ciphersuite.TLSEcdheRsaWithAes256GcmSha384{}
 	case TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
 		return ciphersuite.NewTLSEcdhePskWithAes128CbcSha256()
-	}
-
-	if customCiphers != nil {
-		for _, c := range customCiphers() {
-			if c.ID() == id {
-				return c
-			}
-		}
+	case TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256:
+		return &ciphersuite.TLSEcdhePskWithAes128GcmSha256{}
+	case TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
+		return &ciphersuite.TLSEcdheEcdsaWithChaCha20Poly1305Sha256{}
+	case TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:
+		return &ciphersuite.TLSEcdheRsaWithChaCha20Poly1305Sha256{}
 	}
 
 	return nil
@@ -162,6 +243,8 @@ func defaultCipherSuites() []CipherSuite {
 	return []CipherSuite{
 		&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
 		&ciphersuite.TLSEcdheRsaWithAes128GcmSha256{},
+		&ciphersuite.TLSEcdheEcdsaWithChaCha20Poly1305Sha256{},
+		&ciphersuite.TLSEcdheRsaWithChaCha20Poly1305Sha256{},
 		&ciphersuite.TLSEcdheEcdsaWithAes256CbcSha{},
 		&ciphersuite.TLSEcdheRsaWithAes256CbcSha{},
 		&ciphersuite.TLSEcdheEcdsaWithAes256GcmSha384{},
@@ -183,7 +266,25 @@ func allCipherSuites() []CipherSuite {
 		&ciphersuite.TLSPskWithAes128GcmSha256{},
 		&ciphersuite.TLSEcdheEcdsaWithAes256GcmSha384{},
 		&ciphersuite.TLSEcdheRsaWithAes256GcmSha384{},
+		&ciphersuite.TLSEcdheEcdsaWithChaCha20Poly1305Sha256{},
+		&ciphersuite.TLSEcdheRsaWithChaCha20Poly1305Sha256{},
+	}
+}
+
+// SupportedCipherSuites returns the IDs of all cipher suites implemented by
+// this package, including the ones that are not enabled by default. They can
+// be passed to Config.CipherSuites, CipherSuiteName returns their names.
+func SupportedCipherSuites() []CipherSuiteID {
+	ids := []CipherSuiteID{}
+	for _, c := range allCipherSuites() {
+		ids = append(ids, c.ID())
 	}
+	// Implemented, but left out of allCipherSuites
+	return append(ids,
+		TLS_PSK_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+	)
 }
 
 func cipherSuiteIDs(cipherSuites []CipherSuite) []uint16 {
@@ -194,6 +295,15 @@ func cipherSuiteIDs(cipherSuites []CipherSuite) []uint16 {
 	return rtrn
 }
 
+func containsCipherSuite(id CipherSuiteID, cipherSuites []CipherSuite) bool {
+	for _, c := range cipherSuites {
+		if c.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
 func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites func() []CipherSuite, includeCertificateSuites, includePSKSuites bool) ([]CipherSuite, error) {
 	cipherSuitesForIDs := func(ids []CipherSuiteID) ([]CipherSuite, error) {
 		cipherSuites := []CipherSuite{}
@@ -221,9 +331,22 @@ func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites fu
 		cipherSuites = defaultCipherSuites()
 	}
 
-	// Put CustomCipherSuites before ID selected suites
+	// Put CustomCipherSuites before ID selected suites, they replace the
+	// built-in suites of the same ID
 	if customCipherSuites != nil {
-		cipherSuites = append(customCipherSuites(), cipherSuites...)
+		custom := customCipherSuites()
+		for _, c := range custom {
+			if err = checkCipherSuiteHash(c); err != nil {
+				return nil, err
+			}
+		}
+		builtin := cipherSuites
+		cipherSuites = custom
+		for _, c := range builtin {
+			if !containsCipherSuite(c.ID(), custom) {
+				cipherSuites = append(cipherSuites, c)
+			}
+		}
 	}
 
 	var foundCertificateSuite, foundPSKSuite, foundAnonymousSuite bool
@@ -254,23 +377,36 @@ func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites fu
 	return cipherSuites[:i], nil
 }
 
-func filterCipherSuitesForCertificate(cert *tls.Certificate, cipherSuites []CipherSuite) []CipherSuite {
-	if cert == nil || cert.PrivateKey == nil {
-		return cipherSuites
+func filterCipherSuitesForCertificates(certs []*tls.Certificate, cipherSuites []CipherSuite) []CipherSuite {
+	certTypes := map[clientcertificate.Type]bool{}
+	for _, cert := range certs {
+		if cert == nil || cert.PrivateKey == nil {
+			return cipherSuites
+		}
+		certTypes[certificateType(cert)] = true
 	}
-	var certType clientcertificate.Type
-	switch cert.PrivateKey.(type) {
-	case ed25519.PrivateKey, *ecdsa.PrivateKey:
-		certType = clientcertificate.ECDSASign
-	case *rsa.PrivateKey:
-		certType = clientcertificate.RSASign
+	if len(certTypes) == 0 {
+		return cipherSuites
 	}
 
 	filtered := []CipherSuite{}
 	for _, c := range cipherSuites {
-		if c.AuthenticationType() != CipherSuiteAuthenticationTypeCertificate || certType == c.CertificateType() {
+		if c.AuthenticationType() != CipherSuiteAuthenticationTypeCertificate || certTypes[c.CertificateType()] {
 			filtered = append(filtered, c)
 		}
 	}
 	return filtered
 }
+
+// certificateType returns the certificate type of the key of cert, which
+// has to match the CertificateType of the cipher suite it is used with
+func certificateType(cert *tls.Certificate) clientcertificate.Type {
+	switch cert.PrivateKey.(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey:
+		return clientcertificate.ECDSASign
+	case *rsa.PrivateKey:
+		return clientcertificate.RSASign
+	default:
+		return 0
+	}
+}
@@ -5,10 +5,15 @@ package dtls
 
 import (
 	"context"
+	"crypto"
+	"crypto/sha512"
+	"errors"
+	"hash"
 	"testing"
 	"time"
 
 	"github.com/adrian38/dtls/v2/internal/ciphersuite"
+	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	dtlsnet "github.com/adrian38/dtls/v2/pkg/net"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
@@ -38,6 +43,45 @@ func TestAllCipherSuites(t *testing.T) {
 	}
 }
 
+func TestSupportedCipherSuites(t *testing.T) {
+	supported := map[CipherSuiteID]bool{}
+	for _, id := range SupportedCipherSuites() {
+		if supported[id] {
+			t.Fatalf("%s is listed twice", CipherSuiteName(id))
+		}
+		supported[id] = true
+		if suite := cipherSuiteForID(id, nil); suite == nil || CipherSuiteName(id) != suite.String() {
+			t.Fatalf("Unexpected name %s of 0x%04X", CipherSuiteName(id), uint16(id))
+		}
+	}
+	if len(supported) == 0 {
+		t.Fatal("No supported cipher suites")
+	}
+	for _, c := range defaultCipherSuites() {
+		if !supported[c.ID()] {
+			t.Fatalf("Default cipher suite %s is not listed", c)
+		}
+	}
+}
+
+func TestSupportedCurves(t *testing.T) {
+	supported := map[elliptic.Curve]bool{}
+	for _, c := range SupportedCurves() {
+		if _, ok := elliptic.Curves()[c]; !ok {
+			t.Fatalf("Curve %s is not implemented", c)
+		}
+		supported[c] = true
+	}
+	if len(supported) != len(elliptic.Curves()) {
+		t.Fatalf("Expected %d curves, got %d", len(elliptic.Curves()), len(supported))
+	}
+	for _, c := range defaultCurves {
+		if !supported[c] {
+			t.Fatalf("Default curve %s is not listed", c)
+		}
+	}
+}
+
 // CustomCipher that is just used to assert Custom IDs work
 type testCustomCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -110,3 +154,137 @@ func TestCustomCipherSuite(t *testing.T) {
 		})
 	})
 }
+
+// testLegacyCipherSuite reuses the ID of a SHA-384 suite, but derives its
+// keys with the SHA-256 PRF
+type testLegacyCipherSuite struct {
+	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
+}
+
+func (t *testLegacyCipherSuite) ID() CipherSuiteID {
+	return TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384
+}
+
+// testMismatchedHashCipherSuite reports SHA-384 for the transcript and the
+// master secret, but its embedded Init derives the keys with SHA-256
+type testMismatchedHashCipherSuite struct {
+	testLegacyCipherSuite
+}
+
+func (t *testMismatchedHashCipherSuite) HashFunc() func() hash.Hash {
+	return sha512.New384
+}
+
+// Assert that a custom CipherSuite replaces the built-in one of the same ID,
+// and that its PRF hash is used for the whole handshake
+func TestCustomCipherSuitePRFHash(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	legacy := func() []CipherSuite {
+		return []CipherSuite{&testLegacyCipherSuite{}}
+	}
+
+	if _, ok := cipherSuiteForID(TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, legacy).(*testLegacyCipherSuite); !ok {
+		t.Fatal("Expected the custom cipher suite to take precedence")
+	}
+	suites, err := parseCipherSuites([]CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}, legacy, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(suites) != 1 {
+		t.Fatalf("Expected the built-in cipher suite to be replaced, got %v", suites)
+	}
+
+	runTest := func(clientSuites, serverSuites func() []CipherSuite, timeout time.Duration) (*Conn, *Conn, error, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				CipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+				CustomCipherSuites: clientSuites,
+			}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			CipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			CustomCipherSuites: serverSuites,
+		}, true)
+		if err != nil {
+			_ = cb.Close()
+		}
+		clientResult := <-c
+		if clientResult.err != nil {
+			_ = ca.Close()
+		}
+		return clientResult.c, server, clientResult.err, err
+	}
+
+	t.Run("Legacy", func(t *testing.T) {
+		client, server, clientErr, serverErr := runTest(legacy, legacy, 10*time.Second)
+		if clientErr != nil || serverErr != nil {
+			t.Fatalf("Handshake failed, client: %v, server: %v", clientErr, serverErr)
+		}
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		for _, conn := range []*Conn{client, server} {
+			h, err := conn.PRFHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h != crypto.SHA256 {
+				t.Fatalf("Expected PRF hash %v, got %v", crypto.SHA256, h)
+			}
+		}
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		client, server, clientErr, serverErr := runTest(legacy, nil, time.Second)
+		if clientErr == nil {
+			_ = client.Close()
+		}
+		if serverErr == nil {
+			_ = server.Close()
+		}
+		if clientErr == nil && serverErr == nil {
+			t.Fatal("Expected the handshake to fail with different PRF hashes")
+		}
+	})
+	t.Run("HashMismatch", func(t *testing.T) {
+		suites := allCipherSuites()
+		suites = append(suites, ciphersuite.NewTLSEcdhePskWithAes128CbcSha256(), &ciphersuite.TLSPskWithAes128CbcSha256{}, &testLegacyCipherSuite{})
+		for _, c := range suites {
+			if err := checkCipherSuiteHash(c); err != nil {
+				t.Fatalf("Unexpected error for %s: %v", c, err)
+			}
+		}
+
+		mismatched := func() []CipherSuite {
+			return []CipherSuite{&testMismatchedHashCipherSuite{}}
+		}
+		if _, err := parseCipherSuites(nil, mismatched, true, false); !errors.Is(err, errCipherSuiteHashMismatch) {
+			t.Fatalf("Expected error '%v', got '%v'", errCipherSuiteHashMismatch, err)
+		}
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = ca.Close()
+			_ = cb.Close()
+		}()
+		if _, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CustomCipherSuites: mismatched}); !errors.Is(err, errCipherSuiteHashMismatch) {
+			t.Fatalf("Expected error '%v', got '%v'", errCipherSuiteHashMismatch, err)
+		}
+	})
+}
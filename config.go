@@ -5,15 +5,21 @@ package dtls
 
 import (
 	"context"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"io"
+	"net"
 	"time"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
 	"github.com/pion/logging"
 )
 
@@ -25,22 +31,70 @@ type Config struct {
 	// Certificates contains certificate chain to present to the other side of the connection.
 	// Server MUST set this if PSK is non-nil
 	// client SHOULD sets this so CertificateRequests can be handled if PSK is non-nil
+	// A server holding several chains, e.g. an ECDSA and an RSA one, presents
+	// a chain matching the cipher suites and signature_algorithms of the
+	// client, and among those the one matching the requested server name.
 	Certificates []tls.Certificate
 
+	// RawPublicKey, if not nil, is the private key of a raw public key that
+	// is presented instead of a certificate chain to peers supporting it,
+	// see RFC 7250. It must be an ed25519.PrivateKey, *ecdsa.PrivateKey or
+	// *rsa.PrivateKey. A server presents it to clients that accept raw
+	// public keys, a client presents it to a server requesting a client
+	// certificate that accepts them.
+	RawPublicKey crypto.PrivateKey
+
 	// CipherSuites is a list of supported cipher suites.
 	// If CipherSuites is nil, a default list is used
 	CipherSuites []CipherSuiteID
 
 	// CustomCipherSuites is a list of CipherSuites that can be
 	// provided by the user. This allow users to user Ciphers that are reserved
-	// for private usage.
+	// for private usage. A custom suite with the ID of a built-in suite takes
+	// precedence over it wherever the suite is selected by its ID, including
+	// CipherSuites, the negotiation and resumption. This allows to
+	// interoperate with a peer that derives the keys of that suite with a
+	// different PRF hash. A custom suite built on a suite of this package has
+	// to derive its keys with the hash returned by its HashFunc.
 	CustomCipherSuites func() []CipherSuite
 
+	// PreferServerCipherSuites controls whether the server selects the
+	// first suite of its CipherSuites the client offered, rather than the
+	// client's most preferred suite it supports.
+	PreferServerCipherSuites bool
+
+	// EnableFalseStart lets a client return from the handshake as soon as it
+	// sent its Finished, before the server's Finished arrived, to save a
+	// round trip before application data is written. It only applies to
+	// ECDHE cipher suites with an AEAD cipher, see RFC 7918. Data received
+	// meanwhile is returned by Read once the server's Finished is verified.
+	// If that fails the connection is closed and Read returns the
+	// HandshakeError. Ignored by servers.
+	EnableFalseStart bool
+
+	// EnablePostHandshakeAuth makes a client send the post_handshake_auth
+	// extension, telling the server that it answers a CertificateRequest
+	// sent by RequestClientCertificate after the handshake. A client
+	// without it ignores such requests and a server only requests a
+	// certificate from clients that sent the extension.
+	EnablePostHandshakeAuth bool
+
 	// SignatureSchemes contains the signature and hash schemes that the peer requests to verify.
 	SignatureSchemes []tls.SignatureScheme
 
+	// CertificateSignatureSchemes, if set, lists the signature and hash
+	// schemes accepted in the certificates of the peer, when they differ
+	// from the SignatureSchemes of the handshake signatures. Clients
+	// advertise them with the signature_algorithms_cert extension, see
+	// RFC 8446, Section 4.2.3. Every certificate the peer sends must be
+	// signed with one of them, even with InsecureSkipVerify, except a
+	// self-signed CA following the leaf. Certificate signatures are not
+	// restricted if empty.
+	CertificateSignatureSchemes []tls.SignatureScheme
+
 	// SRTPProtectionProfiles are the supported protection profiles
 	// Clients will send this via use_srtp and assert that the server properly responds
+	// Servers select the first of these profiles the client offered
 	// Servers will assert that clients send one of these profiles and will respond as needed
 	SRTPProtectionProfiles []SRTPProtectionProfile
 
@@ -56,11 +110,37 @@ type Config struct {
 	// defaults to time.Second
 	FlightInterval time.Duration
 
+	// RetransmitBackoff returns how long to wait before retransmitting the
+	// current flight. attempt is the number of retransmissions of the flight
+	// so far, starting at zero. If nil or a non-positive value is returned,
+	// FlightInterval is used for every retransmission.
+	// See ExponentialRetransmitBackoff for a ready to use implementation.
+	RetransmitBackoff func(attempt int) time.Duration
+
+	// MaxRetransmits is the number of times a flight is retransmitted without
+	// receiving a response before the handshake fails with a timeout error.
+	// If zero the handshake is only bound by the ConnectContextMaker context.
+	MaxRetransmits int
+
+	// HandshakeDeadline is the time after which a handshake still waiting for
+	// its peer fails with a timeout error. It bounds the handshake of Dial,
+	// Client, Server and Accept, but not Read or renegotiations. If zero the
+	// handshake is only bound by MaxRetransmits and the ConnectContextMaker
+	// context.
+	HandshakeDeadline time.Time
+
 	// PSK sets the pre-shared key used by this DTLS connection
 	// If PSK is non-nil only PSK CipherSuites will be used
 	PSK             PSKCallback
 	PSKIdentityHint []byte
 
+	// PSKIdentity, if not nil, is called by a client with the PSK identity
+	// hint of the server to select the identity sent in the ClientKeyExchange.
+	// This allows a client holding several keys to tell the server which one
+	// PSK returned for the same hint. If nil PSKIdentityHint is sent.
+	// https://tools.ietf.org/html/rfc4279#section-5.2
+	PSKIdentity func(identityHint []byte) ([]byte, error)
+
 	// InsecureSkipVerify controls whether a client verifies the
 	// server's certificate chain and host name.
 	// If InsecureSkipVerify is true, TLS accepts any certificate
@@ -76,8 +156,10 @@ type Config struct {
 	// VerifyPeerCertificate, if not nil, is called after normal
 	// certificate verification by either a client or server. It
 	// receives the certificate provided by the peer and also a flag
-	// that tells if normal verification has succeedded. If it returns a
-	// non-nil error, the handshake is aborted and that error results.
+	// that tells if normal verification has succeedded. rawCerts holds the
+	// DER encoded certificates exactly as the peer sent them, leaf first,
+	// which allows pinning. If it returns a non-nil error, the handshake is
+	// aborted with a bad_certificate alert and that error results.
 	//
 	// If normal verification fails then the handshake will abort before
 	// considering this callback. If normal verification is disabled by
@@ -86,6 +168,22 @@ type Config struct {
 	// be considered but the verifiedChains will always be nil.
 	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
 
+	// VerifyPeerCertificateContext is VerifyPeerCertificate with the context
+	// of the handshake, it takes precedence over VerifyPeerCertificate. The
+	// context carries the values of the context passed to ClientWithContext,
+	// ServerWithContext or the Dialer, and it is canceled once that context
+	// is done or the handshake failed, so a verification that makes network
+	// calls should return when the context is done.
+	VerifyPeerCertificateContext func(ctx context.Context, rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// VerifyRawPublicKey, if not nil, announces that raw public keys of the
+	// peer are accepted and is called with the key the peer authenticated
+	// with. It replaces the certificate verification and
+	// VerifyPeerCertificate for such a peer. If it returns a non-nil error,
+	// the handshake is aborted with a bad_certificate alert and that error
+	// results.
+	VerifyRawPublicKey func(publicKey crypto.PublicKey) error
+
 	// VerifyConnection, if not nil, is called after normal certificate
 	// verification/PSK and after VerifyPeerCertificate by either a TLS client
 	// or server. If it returns a non-nil error, the handshake is aborted
@@ -96,9 +194,36 @@ type Config struct {
 	// regardless of InsecureSkipVerify or ClientAuth settings.
 	VerifyConnection func(*State) error
 
+	// VerifyConnectionContext is VerifyConnection with the context of the
+	// handshake, see VerifyPeerCertificateContext. It takes precedence over
+	// VerifyConnection.
+	VerifyConnectionContext func(ctx context.Context, state *State) error
+
+	// RequireSCT, if true, requires the certificate presented by the peer to
+	// carry Signed Certificate Timestamps, either embedded in the leaf
+	// certificate or (for a client) delivered by the server in the
+	// signed_certificate_timestamp extension. Only the presence of SCTs is
+	// checked, their signatures are not verified against any log.
+	RequireSCT bool
+
+	// SignedCertificateTimestamps are delivered by a server in the
+	// signed_certificate_timestamp extension when the client requests them.
+	// They are only used if the selected certificate carries no
+	// SignedCertificateTimestamps of its own.
+	SignedCertificateTimestamps [][]byte
+
+	// FallbackSCSV adds TLS_FALLBACK_SCSV to the cipher suites of the
+	// ClientHello. It must only be set by a client which retries a failed
+	// handshake with a lower protocol version than it supports, a server
+	// supporting a higher version then aborts the handshake with an
+	// inappropriate_fallback alert.
+	// https://tools.ietf.org/html/rfc7507
+	FallbackSCSV bool
+
 	// RootCAs defines the set of root certificate authorities
 	// that one peer uses when verifying the other peer's certificates.
-	// If RootCAs is nil, TLS uses the host's root CA set.
+	// If RootCAs is nil, TLS uses the host's root CA set. It is loaded
+	// once, by the first verification which needs it.
 	RootCAs *x509.CertPool
 
 	// ClientCAs defines the set of root certificate authorities
@@ -106,6 +231,19 @@ type Config struct {
 	// by the policy in ClientAuth.
 	ClientCAs *x509.CertPool
 
+	// Intermediates are used together with the certificates sent by the
+	// peer to build the chain of its certificate to RootCAs or ClientCAs,
+	// like x509.VerifyOptions.Intermediates. A server also sends the
+	// intermediates its certificate chains to when the certificate holds
+	// nothing but the leaf.
+	Intermediates *x509.CertPool
+
+	// TrustedCAKeys lists the certificate authorities a client advertises
+	// in the trusted_ca_keys extension, so that a server holding several
+	// certificate chains can pick one the client is able to verify.
+	// https://tools.ietf.org/html/rfc6066#section-6
+	TrustedCAKeys []*x509.Certificate
+
 	// ServerName is used to verify the hostname on the returned
 	// certificates unless InsecureSkipVerify is given.
 	ServerName string
@@ -121,45 +259,187 @@ type Config struct {
 	// 	}
 	ConnectContextMaker func() (context.Context, func())
 
+	// OnHandshakeComplete is called once the handshake finished successfully,
+	// including abbreviated handshakes resuming a session.
+	OnHandshakeComplete func(HandshakeStats)
+
+	// OnAlert, if not nil, is called with every alert sent to or received
+	// from the peer, including warnings like close_notify. sent tells the
+	// direction, which distinguishes a close initiated by the peer from one
+	// initiated locally. It is called from the goroutines of the connection
+	// and must not block.
+	OnAlert func(sent bool, level alert.Level, desc alert.Description)
+
+	// OnClientHello, if not nil, is called by a server with every ClientHello
+	// it parses, before any key exchange takes place. It is called again for
+	// the ClientHello answering a HelloVerifyRequest. Returning an error
+	// aborts the handshake with a handshake_failure alert. The message must
+	// not be modified.
+	OnClientHello func(*handshake.MessageClientHello) error
+
+	// CookieGenerator, if not nil, is called by a server to create the cookie
+	// sent in a HelloVerifyRequest to the client at clientAddr. The cookie
+	// must not be longer than 255 bytes. If nil a random cookie is generated
+	// and only accepted by the Conn that sent it.
+	// https://datatracker.ietf.org/doc/html/rfc6347#section-4.2.1
+	CookieGenerator func(clientAddr net.Addr) ([]byte, error)
+
+	// CookieVerifier, if not nil, is called by a server with the cookie of a
+	// ClientHello from clientAddr instead of comparing it with the cookie it
+	// sent. Returning nil accepts the cookie, which makes it possible to use
+	// stateless cookies created by CookieGenerator on another server. A
+	// ClientHello carrying a cookie is then accepted without a preceding
+	// HelloVerifyRequest, a rejected cookie is answered with a new
+	// HelloVerifyRequest if none was sent yet. Otherwise the handshake is
+	// aborted with an access_denied alert.
+	CookieVerifier func(clientAddr net.Addr, cookie []byte) error
+
+	// StatelessCookieSecret, if set on a server, makes the cookies sent in
+	// HelloVerifyRequests carry the time they were issued and an HMAC-SHA256
+	// keyed with the secret over that time, the client address and the client
+	// random. Such a cookie is verified without any per-client state, so a
+	// listener created by Listen or NewListener answers a ClientHello without
+	// a valid cookie itself and only starts a handshake for a ClientHello with
+	// a valid cookie. A flood of ClientHellos from spoofed addresses then
+	// allocates no handshake state. Cookies are valid for CookieLifetime. The
+	// secret must be at least 16 bytes and can be shared by the servers of a
+	// cluster. It can not be combined with CookieGenerator, CookieVerifier or
+	// InsecureSkipVerifyHello.
+	StatelessCookieSecret []byte
+
+	// CookieLifetime is how long a cookie issued with StatelessCookieSecret
+	// is accepted, one minute if zero. A ClientHello with an older cookie is
+	// answered with a HelloVerifyRequest carrying a new one. Servers sharing
+	// the secret should allow for the skew between their clocks.
+	CookieLifetime time.Duration
+
+	// CloseNotifyTimeout is how long Close waits for the peer to answer our
+	// close_notify alert with its own. If the peer does not answer in time
+	// the connection is closed anyway and Close returns ErrCloseNotifyTimeout.
+	// If zero Close does not wait.
+	CloseNotifyTimeout time.Duration
+
+	// IdleTimeout, if not zero, closes the connection when no valid record
+	// was received from the peer for this long, like one which vanished
+	// without sending close_notify. Every record of the handshake or
+	// carrying application data, alerts or heartbeats restarts the timer.
+	// Pending and later reads return ErrIdleTimeout.
+	IdleTimeout time.Duration
+
 	// MTU is the length at which handshake messages will be fragmented to
-	// fit within the maximum transmission unit (default is 1200 bytes)
+	// fit within the maximum transmission unit (default is 1200 bytes). Each
+	// fragment together with its record and handshake headers fits the MTU.
+	// It can be changed on an established Conn with SetMTU. A flight that
+	// goes unanswered is retransmitted with a halved MTU every second
+	// retransmission, down to 548 bytes, in case the path MTU is smaller.
+	// If the handshake fails nonetheless the configured MTU is restored, and
+	// the error matches ErrPathMTU when the peer was still retransmitting.
 	MTU int
 
+	// HandshakeFragmentSize, if positive, is the largest number of bytes of
+	// a handshake message sent in one fragment. Handshake messages are then
+	// fragmented at this boundary even if the MTU allows larger fragments,
+	// which helps to test the reassembly of peers. The MTU and a negotiated
+	// maximum fragment length still apply if they are smaller.
+	HandshakeFragmentSize int
+
 	// ReplayProtectionWindow is the size of the replay attack protection window.
 	// Duplication of the sequence number is checked in this window size.
 	// Packet with sequence number older than this value compared to the latest
 	// accepted packet will be discarded. (default is 64)
+	//
+	// Discarded records are dropped silently and counted by
+	// Conn.DroppedReplays.
 	ReplayProtectionWindow int
 
+	// MaxHandshakeBufferSize bounds the total bytes of handshake fragments
+	// buffered while waiting for the rest of their message. A peer exceeding
+	// it gets an internal_error alert and the connection is aborted.
+	// (default is 64 KiB)
+	MaxHandshakeBufferSize int
+
+	// MaxAppDataQueueBytes bounds the total bytes of application data
+	// records queued because they can not be decrypted yet, like records the
+	// peer sends before our side of the handshake is done. Records beyond it
+	// are dropped. Decrypted records are handed to Read one at a time, the
+	// connection stops reading from the socket until Read takes the pending
+	// one.
+	// (default is 64 KiB)
+	MaxAppDataQueueBytes int
+
 	// KeyLogWriter optionally specifies a destination for TLS master secrets
 	// in NSS key log format that can be used to allow external programs
 	// such as Wireshark to decrypt TLS connections.
 	// See https://developer.mozilla.org/en-US/docs/Mozilla/Projects/NSS/Key_Log_Format.
+	// A CLIENT_RANDOM line is written once per handshake, connections may
+	// share one KeyLogWriter.
 	// Use of KeyLogWriter compromises security and should only be
 	// used for debugging.
 	KeyLogWriter io.Writer
 
 	// SessionStore is the container to store session for resumption.
+	// Clients with a SessionStore also request session tickets and present
+	// them on resumption. NewMemorySessionStore returns a ready to use
+	// in-memory implementation.
 	SessionStore SessionStore
 
+	// SessionTicketKey, when set on a server, enables stateless session
+	// resumption with session tickets (RFC 5077). It must be 32 bytes and is
+	// used to encrypt the session state handed to the client. Replacing the
+	// key invalidates previously issued tickets, clients presenting them fall
+	// back to a full handshake.
+	SessionTicketKey []byte
+
+	// SessionTicketLifetime is how long a session ticket is accepted after it
+	// was issued. If zero, tickets expire after 7 days.
+	SessionTicketLifetime time.Duration
+
 	// List of application protocols the peer supports, for ALPN
 	SupportedProtocols []string
 
+	// ALPNSelector is called by the server with the list of application
+	// protocols offered by the client, in the client's order of preference.
+	// The returned protocol is sent back in the ServerHello, an empty string
+	// means no protocol is selected. If an error is returned the handshake is
+	// aborted with a no_application_protocol alert.
+	// If ALPNSelector is nil the first entry of SupportedProtocols that is
+	// also offered by the client is selected.
+	ALPNSelector func(offered []string) (string, error)
+
+	// ApplicationSettings are the application-layer protocol settings (ALPS)
+	// sent to the peer, keyed by ALPN protocol. The settings of the protocol
+	// ALPN selects are exchanged if both peers have an entry for it, the
+	// settings of the peer are then available in
+	// ConnectionState().PeerApplicationSettings. A peer without ALPS support
+	// ignores the extension and no settings are exchanged.
+	ApplicationSettings map[string][]byte
+
 	// List of Elliptic Curves to use
 	//
 	// If an ECC ciphersuite is configured and EllipticCurves is empty
-	// it will default to X25519, P-256, P-384 in this specific order.
+	// it will default to X25519, P-256, P-384, P-521 in this specific order.
+	// X448 is supported but only used when listed explicitly.
 	EllipticCurves []elliptic.Curve
 
 	// GetCertificate returns a Certificate based on the given
-	// ClientHelloInfo. It will only be called if the client supplies SNI
-	// information or if Certificates is empty.
+	// ClientHelloInfo. It is called for every handshake once the ClientHello,
+	// including its server_name extension, has been parsed and takes
+	// precedence over Certificates.
 	//
 	// If GetCertificate is nil or returns nil, then the certificate is
 	// retrieved from NameToCertificate. If NameToCertificate is nil, the
 	// best element of Certificates will be used.
 	GetCertificate func(*ClientHelloInfo) (*tls.Certificate, error)
 
+	// OCSPStapleProvider, if not nil, is called by a server whose client
+	// requested certificate status with the status_request extension. It
+	// receives the ClientHelloInfo and the certificate chosen for the
+	// handshake and returns the DER encoded OCSP response to staple in the
+	// CertificateStatus message. No response is stapled if it returns nil.
+	//
+	// If OCSPStapleProvider returns an error, the handshake will be aborted.
+	OCSPStapleProvider func(*ClientHelloInfo, *tls.Certificate) ([]byte, error)
+
 	// GetClientCertificate, if not nil, is called when a server requests a
 	// certificate from a client. If set, the contents of Certificates will
 	// be ignored.
@@ -174,7 +454,14 @@ type Config struct {
 
 	// InsecureSkipVerifyHello, if true and when acting as server, allow client to
 	// skip hello verify phase and receive ServerHello after initial ClientHello.
-	// This have implication on DoS attack resistance.
+	// This saves one round-trip, clients sending a cookie are still accepted.
+	// Without the cookie exchange the server answers unverified source
+	// addresses with its full flight, which makes it usable for amplification
+	// attacks and removes protection against spoofed ClientHellos. Only enable
+	// it on networks where the peers are trusted. The hello verify phase is
+	// only skipped for clients sending a non-empty connection ID, which
+	// requires ConnectionIDGenerator, other clients still receive a
+	// HelloVerifyRequest.
 	InsecureSkipVerifyHello bool
 
 	// ConnectionIDGenerator generates connection identifiers that should be
@@ -184,7 +471,9 @@ type Config struct {
 	// connection identifier indicates that the local party supports sending
 	// connection identifiers but does not require the remote party to send
 	// them. A nil ConnectionIDGenerator indicates that connection identifiers
-	// are not supported.
+	// are not supported. The connection identifiers negotiated during the
+	// handshake are kept for the lifetime of the connection, DTLS 1.2 has no
+	// message to update them.
 	// https://datatracker.ietf.org/doc/html/rfc9146
 	ConnectionIDGenerator func() []byte
 
@@ -196,6 +485,119 @@ type Config struct {
 	// If no PaddingLengthGenerator is specified, padding will not be applied.
 	// https://datatracker.ietf.org/doc/html/rfc9146#section-4
 	PaddingLengthGenerator func(uint) uint
+
+	// RecordPadding, if set, returns the length application data of
+	// plaintextLen bytes is padded to before it is encrypted, which hides its
+	// size from observers. DTLS 1.2 records have no padding field, so the
+	// client offers padded records with an extension of the private use range,
+	// which servers of this package accept, and the peer strips the padding.
+	// Lengths below plaintextLen are ignored and no record exceeds the record
+	// size limit. A server without RecordPadding sends the padded format
+	// without padding bytes.
+	RecordPadding func(plaintextLen int) (paddedLen int)
+
+	// DisableEncryptThenMAC stops the client from offering and the server
+	// from accepting the encrypt_then_mac extension. Otherwise records of
+	// CBC cipher suites are MACed after they were encrypted, if the peer
+	// supports it, which rules out padding oracles. AEAD cipher suites are
+	// not affected.
+	// https://datatracker.ietf.org/doc/html/rfc7366
+	DisableEncryptThenMAC bool
+
+	// RecordSizeLimit is the maximum plaintext size of protected records the
+	// peer is allowed to send, advertised with the record_size_limit
+	// extension. It must be between 64 and 16384. If zero the limit is not
+	// advertised, but a limit advertised by the peer is still respected.
+	// https://datatracker.ietf.org/doc/html/rfc8449
+	RecordSizeLimit uint16
+
+	// MaxFragmentLength, if not zero, is requested by a client with the
+	// max_fragment_length extension to limit the plaintext size of every
+	// record both peers send. A server always accepts a valid request unless
+	// the client also offered record_size_limit, which takes precedence.
+	// https://tools.ietf.org/html/rfc6066#section-4
+	MaxFragmentLength FragmentLength
+
+	// RecordLayerVersion, if set, is the version written in the header of
+	// every record we send, independent of the negotiated protocol version.
+	// Some legacy peers expect DTLS 1.0 records, which DTLS 1.2 peers accept.
+	// It must be DTLS 1.0 or 1.2, if zero DTLS 1.2 is used.
+	// https://datatracker.ietf.org/doc/html/rfc6347#section-4.1
+	RecordLayerVersion protocol.Version
+
+	// MinVersion and MaxVersion limit the protocol versions offered by the
+	// peer we accept, a ClientHello or ServerHello outside of the range is
+	// refused with a protocol_version alert. The version of a
+	// HelloVerifyRequest is not checked, servers may send DTLS 1.0 there.
+	// Both must be DTLS 1.0 or 1.2, if zero MinVersion is DTLS 1.0 and
+	// MaxVersion DTLS 1.2. DTLS 1.2 is the only version negotiated, so the
+	// range must include it.
+	MinVersion protocol.Version
+	MaxVersion protocol.Version
+
+	// HeartbeatMode enables the heartbeat extension and tells the peer
+	// whether it may send HeartbeatRequest messages to us. If zero the
+	// extension is not negotiated and Conn.Heartbeat always fails.
+	// https://tools.ietf.org/html/rfc6520
+	HeartbeatMode HeartbeatMode
+
+	// Rand provides the source of entropy for the handshake random, cookies,
+	// session IDs and ephemeral ECDHE keys. If Rand is nil crypto/rand.Reader
+	// is used. Signatures and record layer nonces always use crypto/rand.
+	Rand io.Reader
+
+	// Time returns the current time, it is used to check the validity period
+	// of peer certificates and the expiry of session tickets. If Time is nil
+	// time.Now is used.
+	Time func() time.Time
+
+	// MaxConcurrentHandshakes limits how many handshakes a listener created
+	// by Listen or NewListener runs at the same time. Once the limit is
+	// reached the ClientHellos of new clients are dropped without an answer
+	// and clients are accepted again when one of the running handshakes
+	// completed or failed. If zero the number of handshakes is not limited.
+	//
+	// A handshake takes a slot with the first ClientHello of a client, before
+	// the cookie exchange verified its address, and keeps it until the
+	// handshake ends. ClientHellos from spoofed addresses hold a slot until
+	// the timeout of ConnectContextMaker, but unless InsecureSkipVerifyHello
+	// is set they never reach the key exchange. With StatelessCookieSecret a
+	// slot is only taken once the cookie was verified.
+	MaxConcurrentHandshakes int
+
+	// RateLimitPerSource limits how many new handshakes a listener created by
+	// Listen or NewListener starts with clients of the same IP address in
+	// every RateLimitWindow. ClientHellos above the limit are dropped without
+	// an answer before the cookie exchange, or once the cookie was verified
+	// if StatelessCookieSecret is set. If zero new handshakes are not rate
+	// limited.
+	RateLimitPerSource int
+
+	// RateLimitWindow is the window of RateLimitPerSource, one second if
+	// zero.
+	RateLimitWindow time.Duration
+
+	// HandshakeRateLimiter replaces the limiter of RateLimitPerSource, for
+	// example to share the counts between the servers of a cluster.
+	HandshakeRateLimiter HandshakeRateLimiter
+}
+
+// ExponentialRetransmitBackoff returns a RetransmitBackoff that starts at
+// initial and doubles on every retransmission, never exceeding max.
+func ExponentialRetransmitBackoff(initial, max time.Duration) func(attempt int) time.Duration {
+	return func(attempt int) time.Duration {
+		d := initial
+		for i := 0; i < attempt && d > 0; i++ {
+			if d >= max/2 {
+				return max
+			}
+			d *= 2
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
 }
 
 func defaultConnectContextMaker() (context.Context, func()) {
@@ -210,12 +612,29 @@ func (c *Config) connectContextMaker() (context.Context, func()) {
 }
 
 func (c *Config) includeCertificateSuites() bool {
-	return c.PSK == nil || len(c.Certificates) > 0 || c.GetCertificate != nil || c.GetClientCertificate != nil
+	return c.PSK == nil || len(c.Certificates) > 0 || c.RawPublicKey != nil || c.GetCertificate != nil || c.GetClientCertificate != nil
 }
 
-const defaultMTU = 1200 // bytes
+const (
+	defaultMTU = 1200 // bytes
+
+	// minMTU leaves room for at least one byte of handshake message next
+	// to the record and handshake headers
+	minMTU = recordlayer.FixedHeaderSize + handshake.HeaderLength + 1
+
+	// minAdaptiveMTU is the floor of the MTU reductions after unanswered
+	// flights, the largest UDP payload every IPv4 path has to carry
+	minAdaptiveMTU = 548
+)
 
-var defaultCurves = []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384} //nolint:gochecknoglobals
+var defaultCurves = []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384, elliptic.P521} //nolint:gochecknoglobals
+
+// SupportedCurves returns all elliptic curves implemented by this package,
+// including the ones that are not enabled by default. They can be passed to
+// Config.EllipticCurves.
+func SupportedCurves() []elliptic.Curve {
+	return []elliptic.Curve{elliptic.X25519, elliptic.X448, elliptic.P256, elliptic.P384, elliptic.P521}
+}
 
 // PSKCallback is called once we have the remote's PSKIdentityHint.
 // If the remote provided none it will be nil
@@ -245,12 +664,50 @@ const (
 	DisableExtendedMasterSecret
 )
 
+// isValidVersionLimit reports whether v can be used as MinVersion or
+// MaxVersion
+func isValidVersionLimit(v protocol.Version) bool {
+	return v == (protocol.Version{}) || v.Equal(protocol.Version1_0) || v.Equal(protocol.Version1_2)
+}
+
+// dtlsVersionNumber orders DTLS versions, which are encoded as the one's
+// complement of their number
+func dtlsVersionNumber(v protocol.Version) uint16 {
+	return ^(uint16(v.Major)<<8 | uint16(v.Minor))
+}
+
 func validateConfig(config *Config) error {
 	switch {
 	case config == nil:
 		return errNoConfigProvided
 	case config.PSKIdentityHint != nil && config.PSK == nil:
 		return errIdentityNoPSK
+	case config.RecordSizeLimit != 0 && (config.RecordSizeLimit < minRecordSizeLimit || config.RecordSizeLimit > maxRecordSizeLimit):
+		return errInvalidRecordSizeLimit
+	case config.MTU > 0 && config.MTU < minMTU:
+		return errInvalidMTU
+	case config.HandshakeFragmentSize < 0:
+		return errInvalidHandshakeFragmentSize
+	case config.MaxFragmentLength != 0 && config.MaxFragmentLength.Size() == 0:
+		return errInvalidMaxFragmentLength
+	case len(config.SessionTicketKey) != 0 && len(config.SessionTicketKey) != sessionTicketKeyLength:
+		return errInvalidSessionTicketKey
+	case config.HeartbeatMode != 0 && config.HeartbeatMode != HeartbeatModePeerAllowedToSend && config.HeartbeatMode != HeartbeatModePeerNotAllowedToSend:
+		return errInvalidHeartbeatMode
+	case config.RecordLayerVersion != (protocol.Version{}) && !config.RecordLayerVersion.Equal(protocol.Version1_0) && !config.RecordLayerVersion.Equal(protocol.Version1_2):
+		return errInvalidRecordLayerVersion
+	case !isValidVersionLimit(config.MinVersion) || !isValidVersionLimit(config.MaxVersion) || config.MaxVersion.Equal(protocol.Version1_0):
+		return errInvalidVersionRange
+	case config.MaxConcurrentHandshakes < 0:
+		return errInvalidMaxConcurrentHandshakes
+	case config.RateLimitPerSource < 0 || config.RateLimitWindow < 0:
+		return errInvalidRateLimit
+	case len(config.StatelessCookieSecret) != 0 && len(config.StatelessCookieSecret) < minStatelessCookieSecretLength:
+		return errInvalidStatelessCookieSecret
+	case config.CookieLifetime < 0:
+		return errInvalidCookieLifetime
+	case len(config.StatelessCookieSecret) != 0 && (config.CookieGenerator != nil || config.CookieVerifier != nil || config.InsecureSkipVerifyHello):
+		return errStatelessCookieConflict
 	}
 
 	for _, cert := range config.Certificates {
@@ -268,6 +725,16 @@ func validateConfig(config *Config) error {
 		}
 	}
 
+	if config.RawPublicKey != nil {
+		switch config.RawPublicKey.(type) {
+		case ed25519.PrivateKey:
+		case *ecdsa.PrivateKey:
+		case *rsa.PrivateKey:
+		default:
+			return errInvalidPrivateKey
+		}
+	}
+
 	_, err := parseCipherSuites(config.CipherSuites, config.CustomCipherSuites, config.includeCertificateSuites(), config.PSK != nil)
 	return err
 }
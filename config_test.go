@@ -9,9 +9,13 @@ import (
 	"crypto/rsa"
 	"crypto/tls"
 	"errors"
+	"math"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
 )
 
 func TestValidateConfig(t *testing.T) {
@@ -44,6 +48,82 @@ func TestValidateConfig(t *testing.T) {
 		"Empty config": {
 			expErr: errNoConfigProvided,
 		},
+		"Record size limit too small": {
+			config: &Config{
+				RecordSizeLimit: 63,
+			},
+			expErr: errInvalidRecordSizeLimit,
+		},
+		"Record size limit too large": {
+			config: &Config{
+				RecordSizeLimit: 16385,
+			},
+			expErr: errInvalidRecordSizeLimit,
+		},
+		"Invalid session ticket key": {
+			config: &Config{
+				SessionTicketKey: make([]byte, 16),
+			},
+			expErr: errInvalidSessionTicketKey,
+		},
+		"Invalid heartbeat mode": {
+			config: &Config{
+				HeartbeatMode: 3,
+			},
+			expErr: errInvalidHeartbeatMode,
+		},
+		"Negative handshake fragment size": {
+			config: &Config{
+				HandshakeFragmentSize: -1,
+			},
+			expErr: errInvalidHandshakeFragmentSize,
+		},
+		"Invalid max fragment length": {
+			config: &Config{
+				MaxFragmentLength: 5,
+			},
+			expErr: errInvalidMaxFragmentLength,
+		},
+		"Invalid record layer version": {
+			config: &Config{
+				RecordLayerVersion: protocol.Version{Major: 0x03, Minor: 0x03},
+			},
+			expErr: errInvalidRecordLayerVersion,
+		},
+		"Invalid min version": {
+			config: &Config{
+				MinVersion: protocol.Version{Major: 0x03, Minor: 0x03},
+			},
+			expErr: errInvalidVersionRange,
+		},
+		"Max version excludes DTLS 1.2": {
+			config: &Config{
+				MaxVersion: protocol.Version1_0,
+			},
+			expErr: errInvalidVersionRange,
+		},
+		"Short stateless cookie secret": {
+			config: &Config{
+				StatelessCookieSecret: make([]byte, 8),
+			},
+			expErr: errInvalidStatelessCookieSecret,
+		},
+		"Negative cookie lifetime": {
+			config: &Config{
+				StatelessCookieSecret: make([]byte, 16),
+				CookieLifetime:        -time.Second,
+			},
+			expErr: errInvalidCookieLifetime,
+		},
+		"Stateless cookie secret and cookie verifier": {
+			config: &Config{
+				StatelessCookieSecret: make([]byte, 16),
+				CookieVerifier: func(net.Addr, []byte) error {
+					return nil
+				},
+			},
+			expErr: errStatelessCookieConflict,
+		},
 		"PSK and Certificate, valid cipher suites": {
 			config: &Config{
 				CipherSuites: []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
@@ -138,3 +218,28 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestExponentialRetransmitBackoff(t *testing.T) {
+	backoff := ExponentialRetransmitBackoff(100*time.Millisecond, time.Second)
+
+	for attempt, expected := range []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	} {
+		if actual := backoff(attempt); actual != expected {
+			t.Errorf("TestExponentialRetransmitBackoff: attempt %d expected(%v) actual(%v)", attempt, expected, actual)
+		}
+	}
+
+	// Large attempt counts and maximum must not overflow
+	unbounded := ExponentialRetransmitBackoff(time.Second, math.MaxInt64)
+	for _, attempt := range []int{62, 63, 64, 1000, math.MaxInt32} {
+		if actual := unbounded(attempt); actual <= 0 {
+			t.Errorf("TestExponentialRetransmitBackoff: attempt %d overflowed to %v", attempt, actual)
+		}
+	}
+}
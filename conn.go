@@ -6,6 +6,10 @@ package dtls
 import (
 	"bytes"
 	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -35,6 +39,9 @@ const (
 	inboundBufferSize     = 8192
 	// Default replay protection window is specified by RFC 6347 Section 4.1.2.6
 	defaultReplayProtectionWindow = 64
+	// Default limit of undecryptable application data, see
+	// Config.MaxAppDataQueueBytes
+	defaultMaxAppDataQueueBytes = 64 * 1024
 )
 
 func invalidKeyingLabels() map[string]bool {
@@ -54,27 +61,46 @@ type addrPkt struct {
 // Conn represents a DTLS connection
 type Conn struct {
 	lock           sync.RWMutex      // Internal lock (must not be public)
-	nextConn       netctx.PacketConn // Embedded Conn, typically a udpconn we read/write from
+	nextConn       netctx.PacketConn // Embedded Conn, typically a udpconn we read/write from, see transport
 	fragmentBuffer *fragmentBuffer   // out-of-order and missing fragment handling
 	handshakeCache *handshakeCache   // caching of handshake messages for verifyData generation
 	decrypted      chan interface{}  // Decrypted Application Data or error, pull by calling `Read`
 	rAddr          net.Addr
 	state          State // Internal state
 
-	maximumTransmissionUnit int
+	readBufferLock sync.Mutex
+	lentReadBuffer *[]byte // Buffer returned by ReadBuffer, recycled by the next read
+
+	maximumTransmissionUnit int32 // accessed atomically, changed by SetMTU
+	configuredMTU           int32 // accessed atomically, restored when MTU reductions did not help
+	flightDatagramSize      int32 // accessed atomically, largest datagram of the last flight
+	handshakeFragmentSize   int   // Largest handshake fragment body, zero to fill the MTU
 	paddingLengthGenerator  func(uint) uint
+	recordPadding           func(plaintextLen int) int
+	onAlert                 func(sent bool, level alert.Level, desc alert.Description)
 
 	handshakeCompletedSuccessfully atomic.Value
 
 	encryptedPackets []addrPkt
 
+	// Bytes of application data in encryptedPackets and their limit
+	queuedAppDataBytes   int
+	maxAppDataQueueBytes int
+
 	connectionClosedByUser bool
 	closeLock              sync.Mutex
+	closing                *closer.Closer // Closed once we sent close_notify
 	closed                 *closer.Closer
+	remoteCloseNotify      *closer.Closer // Closed once the peer sent close_notify
+	closeNotifyTimeout     time.Duration
+	idleTimeout            time.Duration
+	idleTimer              *time.Timer // Closes the connection once idleTimeout passed, nil without idleTimeout
+	idleTimedOut           int32       // Set when idleTimer closed the connection, accessed atomically
 	handshakeLoopsFinished sync.WaitGroup
 
-	readDeadline  *deadline.Deadline
-	writeDeadline *deadline.Deadline
+	readDeadline           *deadline.Deadline
+	writeDeadline          *deadline.Deadline
+	handshakeDeadlineTimer *deadline.Deadline // Set from Config.HandshakeDeadline
 
 	log logging.LeveledLogger
 
@@ -86,6 +112,44 @@ type Conn struct {
 	fsm *handshakeFSM
 
 	replayProtectionWindow uint
+	droppedReplays         uint64 // accessed atomically, see DroppedReplays
+
+	// Epoch<<48 | sequence number of the latest record received, valid once
+	// remoteRecordReceived is set, both accessed atomically, see
+	// DebugSequenceState
+	latestRemoteRecord   uint64
+	remoteRecordReceived int32
+
+	recordLayerVersion protocol.Version // zero keeps the version set by the flight
+
+	transportLock sync.RWMutex // Guards nextConn, which SetTransport replaces
+
+	heartbeatLock     sync.Mutex
+	heartbeatResponse chan []byte
+
+	postHandshakeLock     sync.Mutex
+	postHandshakeMessages chan []byte
+	postHandshakeResponse []*packet
+
+	writeBufferLock sync.Mutex
+	writeBuffer     []byte // Application data not sent yet, see SetWriteBufferSize
+	writeBufferSize int32  // accessed atomically, changed with writeBufferLock held
+
+	renegotiationLock   sync.Mutex
+	renegotiating       bool
+	renegotiationDone   chan error   // Result of a renegotiation started by Renegotiate
+	previousCipherSuite atomic.Value // epochCipherSuite the last renegotiation started in
+	resetFragmentBuffer int32        // Set when the fragmentBuffer must be reset before the next record, accessed atomically
+
+	peerRetransmittedFlight int32 // Set when the peer retransmitted the flight we answered last, accessed atomically
+
+	falseStartPending int32         // Set from the client's Finished until the server's is verified, accessed atomically
+	falseStartDone    chan struct{} // Closed once a false started handshake ended, nil without false start
+	falseStartLock    sync.Mutex
+	falseStartData    []*[]byte // Application data received before the server's Finished
+	falseStartErr     error     // The error a false started handshake failed with
+
+	fatalAlertSent atomic.Value // *alert.Alert, the first fatal alert sent to the peer
 }
 
 func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, config *Config, isClient bool, initialState *State) (*Conn, error) {
@@ -106,6 +170,12 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 	if err != nil {
 		return nil, err
 	}
+	var certSignatureSchemes []signaturehash.Algorithm
+	if len(config.CertificateSignatureSchemes) > 0 {
+		if certSignatureSchemes, err = signaturehash.ParseSignatureSchemes(config.CertificateSignatureSchemes, config.InsecureHashes); err != nil {
+			return nil, err
+		}
+	}
 
 	workerInterval := initialTickerInterval
 	if config.FlightInterval != 0 {
@@ -129,6 +199,16 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 		replayProtectionWindow = defaultReplayProtectionWindow
 	}
 
+	maxHandshakeBufferSize := config.MaxHandshakeBufferSize
+	if maxHandshakeBufferSize <= 0 {
+		maxHandshakeBufferSize = defaultFragmentBufferMaxSize
+	}
+
+	maxAppDataQueueBytes := config.MaxAppDataQueueBytes
+	if maxAppDataQueueBytes <= 0 {
+		maxAppDataQueueBytes = defaultMaxAppDataQueueBytes
+	}
+
 	paddingLengthGenerator := config.PaddingLengthGenerator
 	if paddingLengthGenerator == nil {
 		paddingLengthGenerator = func(uint) uint { return 0 }
@@ -137,23 +217,38 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 	c := &Conn{
 		rAddr:                   rAddr,
 		nextConn:                netctx.NewPacketConn(nextConn),
-		fragmentBuffer:          newFragmentBuffer(),
+		fragmentBuffer:          newFragmentBuffer(maxHandshakeBufferSize, logger),
 		handshakeCache:          newHandshakeCache(),
-		maximumTransmissionUnit: mtu,
+		maximumTransmissionUnit: int32(mtu),
+		configuredMTU:           int32(mtu),
+		handshakeFragmentSize:   config.HandshakeFragmentSize,
 		paddingLengthGenerator:  paddingLengthGenerator,
+		recordPadding:           config.RecordPadding,
 
 		decrypted: make(chan interface{}, 1),
 		log:       logger,
 
-		readDeadline:  deadline.New(),
-		writeDeadline: deadline.New(),
+		readDeadline:           deadline.New(),
+		writeDeadline:          deadline.New(),
+		handshakeDeadlineTimer: deadline.New(),
 
-		reading:          make(chan struct{}, 1),
-		handshakeRecv:    make(chan chan struct{}),
-		closed:           closer.NewCloser(),
-		cancelHandshaker: func() {},
+		reading:           make(chan struct{}, 1),
+		handshakeRecv:     make(chan chan struct{}),
+		closing:           closer.NewCloser(),
+		closed:            closer.NewCloser(),
+		remoteCloseNotify: closer.NewCloser(),
+		cancelHandshaker:  func() {},
+
+		closeNotifyTimeout: config.CloseNotifyTimeout,
+		idleTimeout:        config.IdleTimeout,
+		onAlert:            config.OnAlert,
 
 		replayProtectionWindow: uint(replayProtectionWindow),
+		recordLayerVersion:     config.RecordLayerVersion,
+		maxAppDataQueueBytes:   maxAppDataQueueBytes,
+
+		heartbeatResponse:     make(chan []byte, 1),
+		postHandshakeMessages: make(chan []byte, 4),
 
 		state: State{
 			isClient: isClient,
@@ -175,35 +270,113 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 		curves = defaultCurves
 	}
 
+	sessionTicketLifetime := config.SessionTicketLifetime
+	if sessionTicketLifetime == 0 {
+		sessionTicketLifetime = defaultSessionTicketLifetime
+	}
+
+	randReader := config.Rand
+	if randReader == nil {
+		randReader = rand.Reader
+	}
+
+	now := config.Time
+	if now == nil {
+		now = time.Now
+	}
+
 	hsCfg := &handshakeConfig{
 		localPSKCallback:            config.PSK,
 		localPSKIdentityHint:        config.PSKIdentityHint,
+		localPSKIdentityCallback:    config.PSKIdentity,
 		localCipherSuites:           cipherSuites,
+		preferServerCipherSuites:    config.PreferServerCipherSuites,
 		localSignatureSchemes:       signatureSchemes,
+		localCertSignatureSchemes:   certSignatureSchemes,
 		extendedMasterSecret:        config.ExtendedMasterSecret,
 		localSRTPProtectionProfiles: config.SRTPProtectionProfiles,
 		serverName:                  serverName,
 		supportedProtocols:          config.SupportedProtocols,
+		alpnSelector:                config.ALPNSelector,
+		applicationSettings:         config.ApplicationSettings,
 		clientAuth:                  config.ClientAuth,
 		localCertificates:           config.Certificates,
+		localRawPublicKey:           config.RawPublicKey,
+		verifyRawPublicKey:          config.VerifyRawPublicKey,
 		insecureSkipVerify:          config.InsecureSkipVerify,
-		verifyPeerCertificate:       config.VerifyPeerCertificate,
-		verifyConnection:            config.VerifyConnection,
+		requireSCT:                  config.RequireSCT,
+		signedCertificateTimestamps: config.SignedCertificateTimestamps,
+		fallbackSCSV:                config.FallbackSCSV,
 		rootCAs:                     config.RootCAs,
 		clientCAs:                   config.ClientCAs,
+		intermediates:               config.Intermediates,
+		trustedAuthorities:          trustedAuthoritiesFromCertificates(config.TrustedCAKeys),
 		customCipherSuites:          config.CustomCipherSuites,
 		retransmitInterval:          workerInterval,
+		retransmitBackoff:           config.RetransmitBackoff,
+		maxRetransmits:              config.MaxRetransmits,
+		falseStart:                  config.EnableFalseStart && isClient,
+		postHandshakeAuth:           config.EnablePostHandshakeAuth && isClient,
 		log:                         logger,
 		initialEpoch:                0,
 		keyLogWriter:                config.KeyLogWriter,
 		sessionStore:                config.SessionStore,
+		sessionTicketKey:            config.SessionTicketKey,
+		sessionTicketLifetime:       sessionTicketLifetime,
 		ellipticCurves:              curves,
 		localGetCertificate:         config.GetCertificate,
+		ocspStapleProvider:          config.OCSPStapleProvider,
 		localGetClientCertificate:   config.GetClientCertificate,
 		insecureSkipHelloVerify:     config.InsecureSkipVerifyHello,
 		connectionIDGenerator:       config.ConnectionIDGenerator,
+		recordSizeLimit:             config.RecordSizeLimit,
+		maxFragmentLength:           config.MaxFragmentLength,
+		minVersion:                  config.MinVersion,
+		maxVersion:                  config.MaxVersion,
+		heartbeatMode:               config.HeartbeatMode,
+		recordPadding:               config.RecordPadding,
+		disableEncryptThenMAC:       config.DisableEncryptThenMAC,
+		onHandshakeComplete:         config.OnHandshakeComplete,
+		onClientHello:               config.OnClientHello,
+		rand:                        randReader,
+		now:                         now,
+	}
+
+	// Cookies are bound to the address the handshake is running with
+	hsCfg.verifyPeerCertificate = config.VerifyPeerCertificateContext
+	if hsCfg.verifyPeerCertificate == nil && config.VerifyPeerCertificate != nil {
+		hsCfg.verifyPeerCertificate = func(_ context.Context, rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			return config.VerifyPeerCertificate(rawCerts, verifiedChains)
+		}
+	}
+	hsCfg.verifyConnection = config.VerifyConnectionContext
+	if hsCfg.verifyConnection == nil && config.VerifyConnection != nil {
+		hsCfg.verifyConnection = func(_ context.Context, state *State) error {
+			return config.VerifyConnection(state)
+		}
+	}
+	if config.CookieGenerator != nil {
+		hsCfg.cookieGenerator = func() ([]byte, error) {
+			return config.CookieGenerator(rAddr)
+		}
+	}
+	if config.CookieVerifier != nil {
+		hsCfg.cookieVerifier = func(cookie, _ []byte) error {
+			return config.CookieVerifier(rAddr, cookie)
+		}
+	}
+	if len(config.StatelessCookieSecret) > 0 {
+		cookies := newStatelessCookies(config.StatelessCookieSecret, now, config.CookieLifetime)
+		hsCfg.statelessCookieGenerator = func(clientRandom []byte) []byte {
+			return cookies.generate(rAddr, clientRandom)
+		}
+		hsCfg.cookieVerifier = func(cookie, clientRandom []byte) error {
+			return cookies.verify(rAddr, clientRandom, cookie)
+		}
 	}
 
+	c.fragmentBuffer.adoptClientHello = !isClient && hsCfg.cookieVerifier != nil
+
 	// rfc5246#section-7.4.3
 	// In addition, the hash and signature algorithms MUST be compatible
 	// with the key in the server's end-entity certificate.
@@ -212,7 +385,18 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 		if err != nil && !errors.Is(err, errNoCertificates) {
 			return nil, err
 		}
-		hsCfg.localCipherSuites = filterCipherSuitesForCertificate(cert, cipherSuites)
+		certs := []*tls.Certificate{cert}
+		if config.GetCertificate == nil {
+			// Any of the certificates may be selected for a ClientHello
+			certs = certs[:0]
+			for i := range hsCfg.localCertificates {
+				certs = append(certs, &hsCfg.localCertificates[i])
+			}
+		}
+		if config.RawPublicKey != nil {
+			certs = append(certs, &tls.Certificate{PrivateKey: config.RawPublicKey})
+		}
+		hsCfg.localCipherSuites = filterCipherSuitesForCertificates(certs, cipherSuites)
 	}
 
 	var initialFlight flightVal
@@ -235,8 +419,20 @@ func createConn(ctx context.Context, nextConn net.PacketConn, rAddr net.Addr, co
 		}
 		initialFSMState = handshakePreparing
 	}
+	// The handshake counts as activity, the timer runs from its start
+	if c.idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.idleTimeout, c.closeIdle)
+	}
+
 	// Do handshake
-	if err := c.handshake(ctx, hsCfg, initialFlight, initialFSMState); err != nil {
+	c.handshakeDeadlineTimer.Set(config.HandshakeDeadline)
+	err = c.handshake(ctx, hsCfg, initialFlight, initialFSMState)
+	// The deadline only bounds the initial handshake
+	c.handshakeDeadlineTimer.Set(time.Time{})
+	if err != nil {
+		if c.idleTimer != nil {
+			c.idleTimer.Stop()
+		}
 		return nil, err
 	}
 
@@ -294,7 +490,7 @@ func ClientWithContext(ctx context.Context, conn net.PacketConn, rAddr net.Addr,
 	switch {
 	case config == nil:
 		return nil, errNoConfigProvided
-	case config.PSK != nil && config.PSKIdentityHint == nil:
+	case config.PSK != nil && config.PSKIdentityHint == nil && config.PSKIdentity == nil:
 		return nil, errPSKAndIdentityMustBeSetForClient
 	}
 
@@ -312,33 +508,99 @@ func ServerWithContext(ctx context.Context, conn net.PacketConn, rAddr net.Addr,
 
 // Read reads data from the connection.
 func (c *Conn) Read(p []byte) (n int, err error) {
+	c.releaseReadBuffer()
+	val, err := c.nextRecord()
+	if err != nil {
+		return 0, err
+	}
+
+	// The buffer came from poolReadBuffer, recycle it once copied
+	n = copy(p, *val)
+	tooSmall := len(p) < len(*val)
+	putReadBuffer(val)
+	if tooSmall {
+		return 0, errBufferTooSmall
+	}
+	return n, nil
+}
+
+// ReadRecord returns the payload of the next application data record. Unlike
+// Read it never fails because of a short buffer, each call returns exactly one
+// record as it was sent by the peer. Read and ReadRecord may be mixed, every
+// record is returned by only one of them.
+func (c *Conn) ReadRecord() ([]byte, error) {
+	c.releaseReadBuffer()
+	val, err := c.nextRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	record := append([]byte{}, *val...)
+	putReadBuffer(val)
+	return record, nil
+}
+
+// ReadBuffer returns the payload of the next application data record like
+// ReadRecord, but without copying it out of the buffer it was decrypted into.
+// The returned slice is only valid until the next call to Read, ReadRecord or
+// ReadBuffer, which recycles the buffer for later records. ReadBuffer must not
+// be called concurrently with other reads.
+func (c *Conn) ReadBuffer() ([]byte, error) {
+	c.releaseReadBuffer()
+	val, err := c.nextRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	c.readBufferLock.Lock()
+	c.lentReadBuffer = val
+	c.readBufferLock.Unlock()
+	return *val, nil
+}
+
+// releaseReadBuffer recycles the buffer returned by the last ReadBuffer
+func (c *Conn) releaseReadBuffer() {
+	c.readBufferLock.Lock()
+	val := c.lentReadBuffer
+	c.lentReadBuffer = nil
+	c.readBufferLock.Unlock()
+	if val != nil {
+		putReadBuffer(val)
+	}
+}
+
+// nextRecord waits for the next decrypted application data record, the
+// returned buffer must be released with putReadBuffer
+func (c *Conn) nextRecord() (*[]byte, error) {
+	if data, err := c.nextFalseStartRecord(); data != nil || err != nil {
+		return data, err
+	}
 	if !c.isHandshakeCompletedSuccessfully() {
-		return 0, errHandshakeInProgress
+		return nil, errHandshakeInProgress
 	}
 
 	select {
 	case <-c.readDeadline.Done():
-		return 0, errDeadlineExceeded
+		return nil, errDeadlineExceeded
 	default:
 	}
 
 	for {
 		select {
 		case <-c.readDeadline.Done():
-			return 0, errDeadlineExceeded
+			return nil, errDeadlineExceeded
 		case out, ok := <-c.decrypted:
 			if !ok {
-				return 0, io.EOF
+				if atomic.LoadInt32(&c.idleTimedOut) == 1 {
+					return nil, ErrIdleTimeout
+				}
+				return nil, io.EOF
 			}
 			switch val := out.(type) {
-			case ([]byte):
-				if len(p) < len(val) {
-					return 0, errBufferTooSmall
-				}
-				copy(p, val)
-				return len(val), nil
+			case (*[]byte):
+				return val, nil
 			case (error):
-				return 0, val
+				return nil, val
 			}
 		}
 	}
@@ -346,54 +608,255 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 
 // Write writes len(p) bytes from p to the DTLS connection
 func (c *Conn) Write(p []byte) (int, error) {
-	if c.isConnectionClosed() {
-		return 0, ErrConnClosed
+	n, _, err := c.WriteRecords(p)
+	return n, err
+}
+
+// WriteRecords writes p like Write and also returns how many records were
+// sent. Data larger than the record_size_limit or max_fragment_length of the
+// peer is split into several records. With a write buffer, see
+// SetWriteBufferSize, the count includes the records of buffered data sent
+// first and is zero if p was only buffered.
+func (c *Conn) WriteRecords(p []byte) (bytesWritten, recordCount int, err error) {
+	// No application data may follow our close_notify
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return 0, 0, ErrConnClosed
 	}
 
 	select {
 	case <-c.writeDeadline.Done():
-		return 0, errDeadlineExceeded
+		return 0, 0, errDeadlineExceeded
 	default:
 	}
 
-	if !c.isHandshakeCompletedSuccessfully() {
-		return 0, errHandshakeInProgress
+	if !c.isHandshakeCompletedSuccessfully() && !c.isFalseStartPending() {
+		return 0, 0, errHandshakeInProgress
 	}
 
-	return len(p), c.writePackets(c.writeDeadline, []*packet{
-		{
+	// Without buffering the lock is not taken, so a Write blocked on the
+	// transport cannot hold up Close
+	size := int(atomic.LoadInt32(&c.writeBufferSize))
+	if size > 0 {
+		c.writeBufferLock.Lock()
+		defer c.writeBufferLock.Unlock()
+		size = int(atomic.LoadInt32(&c.writeBufferSize))
+	}
+
+	if size > 0 {
+		// Buffered data is sent before p so the order is kept
+		if len(c.writeBuffer)+len(p) > size {
+			if recordCount, err = c.flushRecordsLocked(); err != nil {
+				return 0, recordCount, err
+			}
+		}
+		if len(p) < size {
+			c.writeBuffer = append(c.writeBuffer, p...)
+			return len(p), recordCount, nil
+		}
+	}
+	records, err := c.writeApplicationData(p)
+	return len(p), recordCount + records, err
+}
+
+// SetWriteBufferSize makes Write buffer application data and send it in
+// records of up to size bytes, which coalesces small writes into fewer
+// datagrams. The peer then reads the data of several writes from a single
+// record. Buffered data is sent when the next Write does not fit into the
+// buffer, by Flush and by Close. Sizes above 16384, the largest plaintext of
+// a record, are truncated. A size of zero disables buffering, data already
+// buffered is sent before the size is changed.
+func (c *Conn) SetWriteBufferSize(size int) error {
+	c.writeBufferLock.Lock()
+	defer c.writeBufferLock.Unlock()
+
+	if err := c.flushLocked(); err != nil {
+		return err
+	}
+	if size > maxRecordSizeLimit {
+		size = maxRecordSizeLimit
+	}
+	atomic.StoreInt32(&c.writeBufferSize, int32(size))
+	return nil
+}
+
+// Flush sends the application data buffered by Write, see SetWriteBufferSize.
+func (c *Conn) Flush() error {
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return ErrConnClosed
+	}
+
+	c.writeBufferLock.Lock()
+	defer c.writeBufferLock.Unlock()
+	return c.flushLocked()
+}
+
+func (c *Conn) flushLocked() error {
+	_, err := c.flushRecordsLocked()
+	return err
+}
+
+func (c *Conn) flushRecordsLocked() (int, error) {
+	if len(c.writeBuffer) == 0 {
+		return 0, nil
+	}
+	records, err := c.writeApplicationData(c.writeBuffer)
+	c.writeBuffer = c.writeBuffer[:0]
+	return records, err
+}
+
+// writeApplicationData sends p in as few records as the peer accepts and
+// returns their number
+func (c *Conn) writeApplicationData(p []byte) (int, error) {
+	// Split the data so no record exceeds the record_size_limit or
+	// max_fragment_length of the peer
+	limit := c.state.outgoingRecordLimit()
+	if c.state.recordPadding {
+		// The length prefix and the padding count towards the limit
+		if limit == 0 {
+			limit = maxRecordSizeLimit
+		}
+		limit -= recordPaddingHeaderSize
+	}
+	chunks := [][]byte{p}
+	if limit != 0 && len(p) > limit {
+		chunks = splitBytes(p, limit)
+	}
+
+	pkts := make([]*packet, 0, len(chunks))
+	for _, chunk := range chunks {
+		if c.state.recordPadding {
+			paddedLen := len(chunk)
+			if c.recordPadding != nil {
+				paddedLen = c.recordPadding(len(chunk))
+			}
+			if paddedLen > limit {
+				paddedLen = limit
+			}
+			chunk = padApplicationData(chunk, paddedLen)
+		}
+		pkts = append(pkts, &packet{
 			record: &recordlayer.RecordLayer{
 				Header: recordlayer.Header{
 					Epoch:   c.state.getLocalEpoch(),
 					Version: protocol.Version1_2,
 				},
 				Content: &protocol.ApplicationData{
-					Data: p,
+					Data: chunk,
 				},
 			},
 			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
 			shouldEncrypt: true,
-		},
-	})
+		})
+	}
+
+	return len(pkts), c.writePackets(c.writeDeadline, pkts)
 }
 
 // Close closes the connection.
+// After the handshake a close_notify alert is sent to the peer, if
+// Config.CloseNotifyTimeout is set Close waits for the peer's close_notify
+// and returns ErrCloseNotifyTimeout if it does not arrive in time. Further
+// calls return ErrConnClosed.
 func (c *Conn) Close() error {
 	err := c.close(true) //nolint:contextcheck
 	c.handshakeLoopsFinished.Wait()
 	return err
 }
 
+// FragmentStats returns how many received handshake fragments were
+// reassembled into messages and how many were dropped. A fragment that is
+// counted in neither is still waiting for the rest of its message, which
+// helps to diagnose handshakes stuck on a lost fragment. Enable debug logging
+// to see every fragment.
+func (c *Conn) FragmentStats() FragmentStats {
+	return c.fragmentBuffer.stats()
+}
+
+// DroppedReplays returns how many received records were discarded by the
+// replay protection, either because their sequence number was already seen
+// or because it is older than the window set by Config.ReplayProtectionWindow.
+func (c *Conn) DroppedReplays() uint64 {
+	return atomic.LoadUint64(&c.droppedReplays)
+}
+
+// SetMTU changes the maximum transmission unit handshake messages are
+// fragmented to, for example after an ICMP message reported a smaller path
+// MTU. Flights sent afterwards, including retransmissions of the current one,
+// are fragmented to the new size.
+func (c *Conn) SetMTU(mtu int) error {
+	if mtu < minMTU {
+		return errInvalidMTU
+	}
+	atomic.StoreInt32(&c.configuredMTU, int32(mtu))
+	atomic.StoreInt32(&c.maximumTransmissionUnit, int32(mtu))
+	return nil
+}
+
+func (c *Conn) mtu() int {
+	return int(atomic.LoadInt32(&c.maximumTransmissionUnit))
+}
+
+// reduceMTU halves the MTU, down to minAdaptiveMTU, once a flight carrying
+// datagrams above that floor went unanswered, since they may have been
+// dropped for exceeding the path MTU. It reports whether the MTU changed.
+func (c *Conn) reduceMTU() bool {
+	largest := int(atomic.LoadInt32(&c.flightDatagramSize))
+	mtu := c.mtu()
+	if largest <= minAdaptiveMTU || mtu <= minAdaptiveMTU {
+		return false
+	}
+	if largest < mtu {
+		mtu = largest
+	}
+	mtu /= 2
+	if mtu < minAdaptiveMTU {
+		mtu = minAdaptiveMTU
+	}
+	atomic.StoreInt32(&c.maximumTransmissionUnit, int32(mtu))
+	c.log.Debugf("[handshake:%s] flight with %d byte datagrams unanswered, reducing MTU to %d", srvCliStr(c.state.isClient), largest, mtu)
+	return true
+}
+
 // ConnectionState returns basic DTLS details about the connection.
 // Note that this replaced the `Export` function of v1.
 func (c *Conn) ConnectionState() State {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return *c.state.clone()
+	state := c.state.clone()
+	// The version is only settled once the handshake is done
+	if !c.isHandshakeCompletedSuccessfully() {
+		state.Version = protocol.Version{}
+	}
+	return *state
+}
+
+// MarshalState serializes the cryptographic state of an established
+// connection, the connection can be rebuilt from it with ResumeConn without a
+// new handshake. The state holds the master secret and must be kept secret.
+// The connection should not send anything after the state is exported, or the
+// resumed connection will reuse sequence numbers.
+func (c *Conn) MarshalState() ([]byte, error) {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return nil, errHandshakeInProgress
+	}
+	state := c.ConnectionState()
+	return state.MarshalBinary()
+}
+
+// ExportKeyingMaterial returns length bytes of exported key material as
+// defined in RFC 5705. See State.ExportKeyingMaterial for details.
+func (c *Conn) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
+	state := c.ConnectionState()
+	return state.ExportKeyingMaterial(label, context, length)
 }
 
-// SelectedSRTPProtectionProfile returns the selected SRTPProtectionProfile
+// SelectedSRTPProtectionProfile returns the SRTPProtectionProfile negotiated
+// with the use_srtp extension, false is returned until the handshake completed
+// or if no profile was negotiated
 func (c *Conn) SelectedSRTPProtectionProfile() (SRTPProtectionProfile, bool) {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return 0, false
+	}
 	profile := c.state.getSRTPProtectionProfile()
 	if profile == 0 {
 		return 0, false
@@ -402,14 +865,86 @@ func (c *Conn) SelectedSRTPProtectionProfile() (SRTPProtectionProfile, bool) {
 	return profile, true
 }
 
+// OfferedSRTPProtectionProfiles returns the SRTPProtectionProfiles a client
+// offered with the use_srtp extension, in its order of preference. It is only
+// set on a server once the handshake completed.
+func (c *Conn) OfferedSRTPProtectionProfiles() []SRTPProtectionProfile {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return nil
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]SRTPProtectionProfile{}, c.state.remoteSRTPProtectionProfiles...)
+}
+
+// ExportSRTPKeyingMaterial returns the keying material of the negotiated
+// SRTPProtectionProfile, exported with the "EXTRACTOR-dtls_srtp" label. It is
+// made of the client and server master keys followed by the client and server
+// master salts, their lengths depend on the profile.
+// https://tools.ietf.org/html/rfc5764#section-4.2
+func (c *Conn) ExportSRTPKeyingMaterial() ([]byte, error) {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return nil, errHandshakeInProgress
+	}
+	profile, ok := c.SelectedSRTPProtectionProfile()
+	if !ok {
+		return nil, errNoSRTPProtectionProfile
+	}
+	keyLen, saltLen, ok := srtpKeyingMaterialLength(profile)
+	if !ok {
+		return nil, errNoSRTPProtectionProfile
+	}
+
+	state := c.ConnectionState()
+	return state.ExportKeyingMaterial(srtpKeyingMaterialLabel, nil, 2*(keyLen+saltLen))
+}
+
+// PRFHash returns the hash of the PRF of the negotiated cipher suite, which
+// derives its keys and the exported keying material
+func (c *Conn) PRFHash() (crypto.Hash, error) {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return 0, errHandshakeInProgress
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	h, ok := prfHash(c.state.cipherSuite.HashFunc())
+	if !ok {
+		return 0, errUnknownPRFHash
+	}
+	return h, nil
+}
+
+// CipherSuiteKeyLengths returns the lengths of the MAC key, encryption key and
+// IV the negotiated cipher suite derives for each direction. The MAC key length
+// is zero for AEAD cipher suites. An error is returned for a custom cipher
+// suite that doesn't report its key lengths with a KeyLengths method.
+func (c *Conn) CipherSuiteKeyLengths() (macLen, keyLen, ivLen int, err error) {
+	if !c.isHandshakeCompletedSuccessfully() {
+		return 0, 0, 0, errHandshakeInProgress
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cipherSuite, ok := c.state.cipherSuite.(keyLengthsCipherSuite)
+	if !ok {
+		return 0, 0, 0, errUnknownKeyLengths
+	}
+	macLen, keyLen, ivLen = cipherSuite.KeyLengths()
+	return macLen, keyLen, ivLen, nil
+}
+
 func (c *Conn) writePackets(ctx context.Context, pkts []*packet) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
 	var rawPackets [][]byte
+	isFlight := false
 
 	for _, p := range pkts {
+		if c.recordLayerVersion != (protocol.Version{}) {
+			p.record.Header.Version = c.recordLayerVersion
+		}
 		if h, ok := p.record.Content.(*handshake.Handshake); ok {
+			isFlight = true
 			handshakeRaw, err := p.record.Marshal()
 			if err != nil {
 				return err
@@ -439,8 +974,18 @@ func (c *Conn) writePackets(ctx context.Context, pkts []*packet) error {
 	}
 	compactedRawPackets := c.compactRawPackets(rawPackets)
 
+	if isFlight {
+		largest := 0
+		for _, datagram := range compactedRawPackets {
+			if len(datagram) > largest {
+				largest = len(datagram)
+			}
+		}
+		atomic.StoreInt32(&c.flightDatagramSize, int32(largest))
+	}
+
 	for _, compactedRawPackets := range compactedRawPackets {
-		if _, err := c.nextConn.WriteToContext(ctx, compactedRawPackets, c.rAddr); err != nil {
+		if _, err := c.transport().WriteToContext(ctx, compactedRawPackets, c.rAddr); err != nil {
 			return netError(err)
 		}
 	}
@@ -454,11 +999,12 @@ func (c *Conn) compactRawPackets(rawPackets [][]byte) [][]byte {
 		return rawPackets
 	}
 
+	mtu := c.mtu()
 	combinedRawPackets := make([][]byte, 0)
 	currentCombinedRawPacket := make([]byte, 0)
 
 	for _, rawPacket := range rawPackets {
-		if len(currentCombinedRawPacket) > 0 && len(currentCombinedRawPacket)+len(rawPacket) >= c.maximumTransmissionUnit {
+		if len(currentCombinedRawPacket) > 0 && len(currentCombinedRawPacket)+len(rawPacket) >= mtu {
 			combinedRawPackets = append(combinedRawPackets, currentCombinedRawPacket)
 			currentCombinedRawPacket = []byte{}
 		}
@@ -526,7 +1072,7 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 
 	if p.shouldEncrypt {
 		var err error
-		rawPacket, err = c.state.cipherSuite.Encrypt(p.record, rawPacket)
+		rawPacket, err = c.cipherSuiteForEpoch(epoch).Encrypt(p.record, rawPacket)
 		if err != nil {
 			return nil, err
 		}
@@ -538,7 +1084,12 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]byte, error) {
 	rawPackets := make([][]byte, 0)
 
-	handshakeFragments, err := c.fragmentHandshake(h)
+	// Each fragment is sent in its own record
+	overhead := recordlayer.FixedHeaderSize + handshake.HeaderLength
+	if p.shouldWrapCID {
+		overhead += len(c.state.remoteConnectionID) + 1 // connection ID and real content type
+	}
+	handshakeFragments, err := c.fragmentHandshake(h, overhead)
 	if err != nil {
 		return nil, err
 	}
@@ -598,7 +1149,7 @@ func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]by
 
 		if p.shouldEncrypt {
 			var err error
-			rawPacket, err = c.state.cipherSuite.Encrypt(p.record, rawPacket)
+			rawPacket, err = c.cipherSuiteForEpoch(epoch).Encrypt(p.record, rawPacket)
 			if err != nil {
 				return nil, err
 			}
@@ -610,7 +1161,9 @@ func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]by
 	return rawPackets, nil
 }
 
-func (c *Conn) fragmentHandshake(h *handshake.Handshake) ([][]byte, error) {
+// fragmentHandshake splits h into fragments that fit the MTU once overhead
+// bytes of headers are added, none larger than handshakeFragmentSize
+func (c *Conn) fragmentHandshake(h *handshake.Handshake, overhead int) ([][]byte, error) {
 	content, err := h.Message.Marshal()
 	if err != nil {
 		return nil, err
@@ -618,7 +1171,17 @@ func (c *Conn) fragmentHandshake(h *handshake.Handshake) ([][]byte, error) {
 
 	fragmentedHandshakes := make([][]byte, 0)
 
-	contentFragments := splitBytes(content, c.maximumTransmissionUnit)
+	fragmentLength := c.mtu() - overhead
+	if fragmentLength < 1 {
+		fragmentLength = 1
+	}
+	if c.handshakeFragmentSize > 0 && c.handshakeFragmentSize < fragmentLength {
+		fragmentLength = c.handshakeFragmentSize
+	}
+	if limit := c.state.maxFragmentLength.Size(); limit != 0 && limit-handshake.HeaderLength < fragmentLength {
+		fragmentLength = limit - handshake.HeaderLength
+	}
+	contentFragments := splitBytes(content, fragmentLength)
 	if len(contentFragments) == 0 {
 		contentFragments = [][]byte{
 			{},
@@ -658,6 +1221,13 @@ var poolReadBuffer = sync.Pool{ //nolint:gochecknoglobals
 	},
 }
 
+// putReadBuffer returns b to poolReadBuffer with its full length restored, as
+// buffers handed to Read are truncated to the record payload.
+func putReadBuffer(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	poolReadBuffer.Put(b)
+}
+
 func (c *Conn) readAndBuffer(ctx context.Context) error {
 	bufptr, ok := poolReadBuffer.Get().(*[]byte)
 	if !ok {
@@ -666,8 +1236,15 @@ func (c *Conn) readAndBuffer(ctx context.Context) error {
 	defer poolReadBuffer.Put(bufptr)
 
 	b := *bufptr
-	i, rAddr, err := c.nextConn.ReadFromContext(ctx, b)
+	nextConn := c.transport()
+	i, rAddr, err := nextConn.ReadFromContext(ctx, b)
 	if err != nil {
+		if ctx.Err() == nil && c.transport() != nextConn {
+			// SetTransport interrupted the read, continue with the new
+			// transport
+			_ = nextConn.Conn().SetReadDeadline(time.Time{})
+			return nil
+		}
 		return netError(err)
 	}
 
@@ -687,11 +1264,17 @@ func (c *Conn) readAndBuffer(ctx context.Context) error {
 			}
 		}
 
-		var e *alertError
-		if errors.As(err, &e) && e.IsFatalOrCloseNotify() {
-			return e
-		}
 		if err != nil {
+			var e *alertError
+			if errors.As(err, &e) {
+				if !e.closesConnection(c.isHandshakeCompletedSuccessfully()) {
+					// Warnings are informational, the records after
+					// them are processed as usual
+					c.log.Debugf("%s: ignoring warning alert %s", srvCliStr(c.state.isClient), e.Alert)
+					continue
+				}
+				return e
+			}
 			return err
 		}
 		if hs {
@@ -711,9 +1294,42 @@ func (c *Conn) readAndBuffer(ctx context.Context) error {
 	return nil
 }
 
+// isPrematureChangeCipherSpec reports whether a ChangeCipherSpec received
+// before the cipher suite is initialized can not be explained by reordering.
+// The handshake messages it follows share its epoch.
+// A client sends it after the ServerHello, a server of a full handshake,
+// which ends its flight with ServerHelloDone, after the client Finished.
+// https://datatracker.ietf.org/doc/html/rfc5246#section-7.1
+func (c *Conn) isPrematureChangeCipherSpec(epoch uint16) bool {
+	if c.state.isClient {
+		_, fullHandshake := c.handshakeCache.messageSequence(handshake.TypeServerHelloDone, epoch, false)
+		return fullHandshake
+	}
+	_, sentServerHello := c.handshakeCache.messageSequence(handshake.TypeServerHello, epoch, false)
+	return !sentServerHello
+}
+
+// queueEncryptedPacket keeps a record that can not be decrypted yet until
+// handleQueuedPackets. Application data beyond maxAppDataQueueBytes is
+// dropped, the peer may flood us with it before the handshake is done.
+func (c *Conn) queueEncryptedPacket(rAddr net.Addr, buf []byte, contentType protocol.ContentType) {
+	// The content type of a record with connection ID is encrypted as well
+	if contentType == protocol.ContentTypeApplicationData || contentType == protocol.ContentTypeConnectionID {
+		if c.queuedAppDataBytes+len(buf) > c.maxAppDataQueueBytes {
+			c.log.Debug("application data queue is full, discarding packet")
+			return
+		}
+		c.queuedAppDataBytes += len(buf)
+	}
+	// buf is part of a pooled read buffer that is reused for the next
+	// datagram, queued records need their own copy
+	c.encryptedPackets = append(c.encryptedPackets, addrPkt{rAddr, append([]byte{}, buf...)})
+}
+
 func (c *Conn) handleQueuedPackets(ctx context.Context) error {
 	pkts := c.encryptedPackets
 	c.encryptedPackets = nil
+	c.queuedAppDataBytes = 0
 
 	for _, p := range pkts {
 		_, alert, err := c.handleIncomingPacket(ctx, p.data, p.rAddr, false) // don't re-enqueue
@@ -725,7 +1341,11 @@ func (c *Conn) handleQueuedPackets(ctx context.Context) error {
 			}
 		}
 		var e *alertError
-		if errors.As(err, &e) && e.IsFatalOrCloseNotify() {
+		if errors.As(err, &e) {
+			if !e.closesConnection(c.isHandshakeCompletedSuccessfully()) {
+				c.log.Debugf("%s: ignoring warning alert %s", srvCliStr(c.state.isClient), e.Alert)
+				continue
+			}
 			return e
 		}
 		if err != nil {
@@ -760,7 +1380,7 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 		}
 		if enqueue {
 			c.log.Debug("received packet of next epoch, queuing packet")
-			c.encryptedPackets = append(c.encryptedPackets, addrPkt{rAddr, buf})
+			c.queueEncryptedPacket(rAddr, buf, h.ContentType)
 		}
 		return false, nil, nil
 	}
@@ -771,12 +1391,24 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 			replaydetector.New(c.replayProtectionWindow, recordlayer.MaxSequenceNumber),
 		)
 	}
-	markPacketAsValid, ok := c.state.replayDetector[int(h.Epoch)].Check(h.SequenceNumber)
+	markReplayWindow, ok := c.state.replayDetector[int(h.Epoch)].Check(h.SequenceNumber)
 	if !ok {
 		c.log.Debugf("discarded duplicated packet (epoch: %d, seq: %d)",
 			h.Epoch, h.SequenceNumber,
 		)
-		// return false, nil, nil
+		atomic.AddUint64(&c.droppedReplays, 1)
+		return false, nil, nil
+	}
+	// A record marked as valid is a sign of life of the peer
+	markPacketAsValid := func() bool {
+		if c.idleTimer != nil {
+			c.idleTimer.Reset(c.idleTimeout)
+		}
+		if !markReplayWindow() {
+			return false
+		}
+		c.markLatestRemoteRecord(h.Epoch, h.SequenceNumber)
+		return true
 	}
 
 	// originalCID indicates whether the original record had content type
@@ -785,10 +1417,11 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 
 	// Decrypt
 	if h.Epoch != 0 {
-		if c.state.cipherSuite == nil || !c.state.cipherSuite.IsInitialized() {
+		cipherSuite := c.cipherSuiteForEpoch(h.Epoch)
+		if cipherSuite == nil || !cipherSuite.IsInitialized() {
 			if enqueue {
-				c.encryptedPackets = append(c.encryptedPackets, addrPkt{rAddr, buf})
 				c.log.Debug("handshake not finished, queuing packet")
+				c.queueEncryptedPacket(rAddr, buf, h.ContentType)
 			}
 			return false, nil, nil
 		}
@@ -805,7 +1438,7 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 		if h.ContentType == protocol.ContentTypeConnectionID {
 			hdr.ConnectionID = make([]byte, len(c.state.localConnectionID))
 		}
-		buf, err = c.state.cipherSuite.Decrypt(hdr, buf)
+		buf, err = cipherSuite.Decrypt(hdr, buf)
 		if err != nil {
 			c.log.Debugf("%s: decrypt failed: %s", srvCliStr(c.state.isClient), err)
 			return false, nil, nil
@@ -839,30 +1472,102 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 			c.log.Debug("unexpected connection ID")
 			return false, nil, nil
 		}
+
+		// https://datatracker.ietf.org/doc/html/rfc8449#section-4
+		if limit := c.state.incomingRecordLimit(); limit != 0 && len(buf)-recordlayer.FixedHeaderSize > limit {
+			return false, &alert.Alert{Level: alert.Fatal, Description: alert.RecordOverflow}, errRecordSizeLimitExceeded
+		}
 	}
 
-	isHandshake, err := c.fragmentBuffer.push(append([]byte{}, buf...))
-	if err != nil {
+	if consumed, a := c.handleRenegotiationRecord(buf, h.Epoch); consumed {
+		markPacketAsValid()
+		return false, a, nil
+	}
+	if atomic.CompareAndSwapInt32(&c.resetFragmentBuffer, 1, 0) {
+		c.fragmentBuffer.reset()
+	}
+
+	// Our first record continues the record sequence of a ClientHello
+	// answering a HelloVerifyRequest we did not send, like the
+	// HelloVerifyRequest did
+	// https://datatracker.ietf.org/doc/html/rfc6347#section-4.2.1
+	if c.fragmentBuffer.adoptClientHello && h.Epoch == 0 {
+		for len(c.state.localSequenceNumber) == 0 {
+			c.state.localSequenceNumber = append(c.state.localSequenceNumber, uint64(0))
+		}
+		atomic.StoreUint64(&c.state.localSequenceNumber[0], h.SequenceNumber)
+	}
+
+	// The fragmentBuffer copies what it keeps, buf may be reused afterwards
+	isHandshake, err := c.fragmentBuffer.push(buf)
+	if errors.Is(err, errFragmentBufferOverflow) {
+		return false, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	} else if err != nil {
 		// Decode error must be silently discarded
 		// [RFC6347 Section-4.1.2.7]
 		c.log.Debugf("defragment failed: %s", err)
 		return false, nil, nil
 	} else if isHandshake {
 		markPacketAsValid()
+		if c.fragmentBuffer.retransmittedFlight {
+			c.fragmentBuffer.retransmittedFlight = false
+			if !c.isHandshakeCompletedSuccessfully() || c.isRenegotiating() {
+				atomic.StoreInt32(&c.peerRetransmittedFlight, 1)
+			}
+		}
+		popped, postHandshake := false, false
 		for out, epoch := c.fragmentBuffer.pop(); out != nil; out, epoch = c.fragmentBuffer.pop() {
 			header := &handshake.Header{}
 			if err := header.Unmarshal(out); err != nil {
 				c.log.Debugf("%s: handshake parse failed: %s", srvCliStr(c.state.isClient), err)
 				continue
 			}
+			popped = true
+			// Retransmitted handshake messages are dropped by the fragmentBuffer,
+			// these can only belong to post-handshake authentication
+			if c.isHandshakeCompletedSuccessfully() && !c.isRenegotiating() &&
+				(header.Type == handshake.TypeCertificateRequest || header.Type == handshake.TypeCertificate || header.Type == handshake.TypeCertificateVerify) {
+				postHandshake = true
+				c.handlePostHandshakeMessage(out, epoch, header)
+				continue
+			}
 			c.handshakeCache.push(out, epoch, header.MessageSequence, header.Type, !c.state.isClient)
 		}
+		if postHandshake {
+			return false, nil, nil
+		}
+		if !popped && c.state.isClient && c.isHandshakeCompletedSuccessfully() && !c.isRenegotiating() {
+			// The server did not receive our answer and retransmitted its request
+			c.resendPostHandshakeResponse()
+		}
+		// The flight parsers only see complete messages, a fragment of a
+		// message that is still being reassembled is no reason to wake them,
+		// which would answer the previous flight once more
+		if !popped && c.fragmentBuffer.pending() {
+			return false, nil, nil
+		}
 
 		return true, nil, nil
 	}
 
 	r := &recordlayer.RecordLayer{}
-	if err := r.Unmarshal(buf); err != nil {
+	err = r.Header.Unmarshal(buf)
+	switch {
+	case err == nil && r.Header.ContentType == protocol.ContentTypeApplicationData:
+		// Alias the payload instead of letting protocol.ApplicationData copy
+		// it, it is copied into a pooled buffer below before buf is reused.
+		r.Content = &protocol.ApplicationData{Data: buf[r.Header.Size():]}
+	case err == nil:
+		err = r.Unmarshal(buf)
+	}
+	if err != nil {
+		// A HeartbeatMessage whose payload_length exceeds the record must be
+		// discarded silently
+		// https://tools.ietf.org/html/rfc6520#section-4
+		if h.ContentType == protocol.ContentTypeHeartbeat {
+			c.log.Debugf("discarded broken heartbeat: %v", err)
+			return false, nil, nil
+		}
 		return false, &alert.Alert{Level: alert.Fatal, Description: alert.DecodeError}, err
 	}
 
@@ -870,17 +1575,26 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	switch content := r.Content.(type) {
 	case *alert.Alert:
 		c.log.Tracef("%s: <- %s", srvCliStr(c.state.isClient), content.String())
+		if c.onAlert != nil {
+			c.onAlert(false, content.Level, content.Description)
+		}
 		var a *alert.Alert
 		if content.Description == alert.CloseNotify {
-			// Respond with a close_notify [RFC5246 Section 7.2.1]
-			a = &alert.Alert{Level: alert.Warning, Description: alert.CloseNotify}
+			c.remoteCloseNotify.Close()
+			// Respond with a close_notify unless it answers ours [RFC5246 Section 7.2.1]
+			if !c.isConnectionClosing() {
+				a = &alert.Alert{Level: alert.Warning, Description: alert.CloseNotify}
+			}
 		}
 		_ = markPacketAsValid()
 		return false, a, &alertError{content}
 	case *protocol.ChangeCipherSpec:
 		if c.state.cipherSuite == nil || !c.state.cipherSuite.IsInitialized() {
+			if c.isPrematureChangeCipherSpec(h.Epoch) {
+				return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errUnexpectedChangeCipherSpec
+			}
 			if enqueue {
-				c.encryptedPackets = append(c.encryptedPackets, addrPkt{rAddr, buf})
+				c.encryptedPackets = append(c.encryptedPackets, addrPkt{rAddr, append([]byte{}, buf...)})
 				c.log.Debugf("CipherSuite not initialized, queuing packet")
 			}
 			return false, nil, nil
@@ -892,18 +1606,57 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 		if c.state.getRemoteEpoch()+1 == newRemoteEpoch {
 			c.setRemoteEpoch(newRemoteEpoch)
 			isLatestSeqNum = markPacketAsValid()
+			// The server may have answered the data of a false start before
+			// its ChangeCipherSpec arrived
+			if c.isFalseStartPending() {
+				if err := c.handleQueuedPackets(ctx); err != nil {
+					return false, nil, err
+				}
+			}
 		}
 	case *protocol.ApplicationData:
 		if h.Epoch == 0 {
 			return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errApplicationDataEpochZero
 		}
 
+		payload := content.Data
+		if c.state.recordPadding {
+			var err error
+			if payload, err = unpadApplicationData(payload); err != nil {
+				return false, &alert.Alert{Level: alert.Fatal, Description: alert.DecodeError}, err
+			}
+		}
+
 		isLatestSeqNum = markPacketAsValid()
 
+		data, ok := poolReadBuffer.Get().(*[]byte)
+		if !ok {
+			return false, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, errFailedToAccessPoolReadBuffer
+		}
+		*data = append((*data)[:0], payload...)
+		if c.bufferFalseStartData(data) {
+			break
+		}
+
 		select {
-		case c.decrypted <- content.Data:
+		case c.decrypted <- data:
 		case <-c.closed.Done():
+			putReadBuffer(data)
 		case <-ctx.Done():
+			putReadBuffer(data)
+		}
+	case *protocol.Heartbeat:
+		// Heartbeat messages must not be sent during handshakes
+		// https://tools.ietf.org/html/rfc6520#section-3
+		if h.Epoch == 0 || !c.isHandshakeCompletedSuccessfully() {
+			c.log.Debug("discarded heartbeat during handshake")
+			return false, nil, nil
+		}
+
+		isLatestSeqNum = markPacketAsValid()
+
+		if a, err := c.handleHeartbeat(content); err != nil {
+			return false, a, err
 		}
 
 	default:
@@ -924,11 +1677,20 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	return false, nil, nil
 }
 
+// peerRetransmitted reports whether the peer retransmitted its last flight
+// since the previous call
+func (c *Conn) peerRetransmitted() bool {
+	return atomic.CompareAndSwapInt32(&c.peerRetransmittedFlight, 1, 0)
+}
+
 func (c *Conn) recvHandshake() <-chan chan struct{} {
 	return c.handshakeRecv
 }
 
 func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Description) error {
+	if level == alert.Fatal {
+		c.fatalAlertSent.CompareAndSwap(nil, &alert.Alert{Level: level, Description: desc})
+	}
 	if level == alert.Fatal && len(c.state.SessionID) > 0 {
 		// According to the RFC, we need to delete the stored session.
 		// https://datatracker.ietf.org/doc/html/rfc5246#section-7.2
@@ -939,7 +1701,7 @@ func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Descrip
 			}
 		}
 	}
-	return c.writePackets(ctx, []*packet{
+	if err := c.writePackets(ctx, []*packet{
 		{
 			record: &recordlayer.RecordLayer{
 				Header: recordlayer.Header{
@@ -954,7 +1716,13 @@ func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Descrip
 			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
 			shouldEncrypt: c.isHandshakeCompletedSuccessfully(),
 		},
-	})
+	}); err != nil {
+		return err
+	}
+	if c.onAlert != nil {
+		c.onAlert(true, level, desc)
+	}
+	return nil
 }
 
 func (c *Conn) setHandshakeCompletedSuccessfully() {
@@ -970,16 +1738,39 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 	c.fsm = newHandshakeFSM(&c.state, c.handshakeCache, cfg, initialFlight)
 
 	done := make(chan struct{})
+	falseStarted := make(chan struct{})
+	falseStartChecked := !cfg.falseStart
 	ctxRead, cancelRead := context.WithCancel(context.Background())
 	c.cancelHandshakeReader = cancelRead
-	cfg.onFlightState = func(_ flightVal, s handshakeState) {
+	cfg.onFlightState = func(f flightVal, s handshakeState) {
+		// The client may send application data once its Finished is sent
+		// https://datatracker.ietf.org/doc/html/rfc7918#section-3
+		if !falseStartChecked && f == flight5 && s == handshakeWaiting {
+			falseStartChecked = true
+			if falseStartCipherSuite(c.state.cipherSuite) {
+				c.log.Tracef("[handshake:%s] false start with %s", srvCliStr(c.state.isClient), c.state.cipherSuite)
+				c.startFalseStart()
+				close(falseStarted)
+			}
+		}
 		if s == handshakeFinished && !c.isHandshakeCompletedSuccessfully() {
+			c.saveVerifyData()
 			c.setHandshakeCompletedSuccessfully()
+			c.finishFalseStart(nil)
+			if cfg.onHandshakeComplete != nil {
+				cfg.onHandshakeComplete(c.fsm.stats())
+			}
 			close(done)
 		}
 	}
+	cfg.onRenegotiated = func() {
+		c.saveVerifyData()
+		c.finishRenegotiation(nil)
+	}
 
-	ctxHs, cancel := context.WithCancel(context.Background())
+	// The handshaker outlives ctx to answer retransmissions and
+	// renegotiations, it only keeps the values of ctx for the callbacks
+	ctxHs, cancel := context.WithCancel(valueOnlyContext{ctx})
 	c.cancelHandshaker = cancel
 
 	firstErr := make(chan error, 1)
@@ -991,6 +1782,9 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 	go func() {
 		defer c.handshakeLoopsFinished.Done()
 		err := c.fsm.Run(ctxHs, c, initialState)
+		if c.isRenegotiating() {
+			c.renegotiationFailed(err)
+		}
 		if !errors.Is(err, context.Canceled) {
 			select {
 			case firstErr <- err:
@@ -1010,20 +1804,10 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 		defer c.handshakeLoopsFinished.Done()
 		for {
 			if err := c.readAndBuffer(ctxRead); err != nil {
+				// readAndBuffer returns no alert but those closing
+				// the connection
 				var e *alertError
-				if errors.As(err, &e) {
-					if !e.IsFatalOrCloseNotify() {
-						if c.isHandshakeCompletedSuccessfully() {
-							// Pass the error to Read()
-							select {
-							case c.decrypted <- err:
-							case <-c.closed.Done():
-							case <-ctxRead.Done():
-							}
-						}
-						continue // non-fatal alert must not stop read loop
-					}
-				} else {
+				if !errors.As(err, &e) {
 					switch {
 					case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled), errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
 					case errors.Is(err, recordlayer.ErrInvalidPacketLength):
@@ -1049,9 +1833,10 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 				}
 
 				if e != nil {
-					if e.IsFatalOrCloseNotify() {
-						_ = c.close(false) //nolint:contextcheck
+					if c.isRenegotiating() {
+						c.finishRenegotiation(e)
 					}
+					_ = c.close(false) //nolint:contextcheck
 				}
 				if !c.isConnectionClosed() && errors.Is(err, context.Canceled) {
 					c.log.Trace("handshake timeouts - closing underline connection")
@@ -1075,6 +1860,10 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 		return c.translateHandshakeCtxError(ctx.Err())
 	case <-done:
 		return nil
+	case <-falseStarted:
+		c.handshakeLoopsFinished.Add(1)
+		go c.awaitFalseStart(firstErr, done)
+		return nil
 	}
 }
 
@@ -1085,18 +1874,55 @@ func (c *Conn) translateHandshakeCtxError(err error) error {
 	if errors.Is(err, context.Canceled) && c.isHandshakeCompletedSuccessfully() {
 		return nil
 	}
-	return &HandshakeError{Err: err}
+	handshakeErr := &HandshakeError{}
+	var e *alertError
+	if errors.As(err, &e) {
+		handshakeErr.Alert = e.Alert
+	} else if a, ok := c.fatalAlertSent.Load().(*alert.Alert); ok {
+		handshakeErr.Alert = a
+	}
+	if c.fsm != nil {
+		handshakeErr.Flight = c.fsm.currentFlight.String()
+		if atomic.LoadInt32(&c.fsm.mtuReduced) == 1 {
+			err = &pathMTUError{err: err}
+		}
+	}
+	// The handshake failed even with smaller flights, so the reductions
+	// did not help
+	atomic.StoreInt32(&c.maximumTransmissionUnit, atomic.LoadInt32(&c.configuredMTU))
+	handshakeErr.Err = err
+	return handshakeErr
 }
 
 func (c *Conn) close(byUser bool) error {
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
 	c.cancelHandshaker()
-	c.cancelHandshakeReader()
 
-	if c.isHandshakeCompletedSuccessfully() && byUser {
+	var closeErr error
+	if c.isHandshakeCompletedSuccessfully() && byUser && c.startClosing() {
+		// Keep reading to receive the peer's close_notify
+		if c.closeNotifyTimeout <= 0 {
+			c.cancelHandshakeReader()
+		}
+		// Buffered application data must precede the close_notify. Nothing
+		// is buffered with buffering disabled, skip the lock then so Close
+		// does not wait for a Write blocked on the transport.
+		var flushErr error
+		if atomic.LoadInt32(&c.writeBufferSize) > 0 {
+			c.writeBufferLock.Lock()
+			flushErr = c.flushLocked()
+			c.writeBufferLock.Unlock()
+		}
 		// Discard error from notify() to return non-error on the first user call of Close()
 		// even if the underlying connection is already closed.
 		_ = c.notify(context.Background(), alert.Warning, alert.CloseNotify)
+		if closeErr = c.waitCloseNotify(); closeErr == nil {
+			closeErr = flushErr
+		}
 	}
+	c.cancelHandshakeReader()
 
 	c.closeLock.Lock()
 	// Don't return ErrConnClosed at the first time of the call from user.
@@ -1113,10 +1939,71 @@ func (c *Conn) close(byUser bool) error {
 	}
 
 	if isClosed {
+		return closeErr
+	}
+
+	if err := c.transport().Close(); err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// closeIdle closes the connection once idleTimeout passed without a valid
+// record from the peer
+func (c *Conn) closeIdle() {
+	if c.isConnectionClosed() {
+		return
+	}
+	c.log.Debugf("closing connection idle for %v", c.idleTimeout)
+	atomic.StoreInt32(&c.idleTimedOut, 1)
+	_ = c.close(false)
+}
+
+// startClosing marks the connection as closing. It reports false if the
+// connection already started closing.
+func (c *Conn) startClosing() bool {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+
+	if c.isConnectionClosing() || c.isConnectionClosed() {
+		return false
+	}
+	c.closing.Close()
+	return true
+}
+
+// waitCloseNotify waits up to closeNotifyTimeout for the peer's close_notify.
+func (c *Conn) waitCloseNotify() error {
+	if c.closeNotifyTimeout <= 0 {
 		return nil
 	}
 
-	return c.nextConn.Close()
+	timer := time.NewTimer(c.closeNotifyTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.remoteCloseNotify.Done():
+		return nil
+	case <-c.closed.Done():
+		// The read loop closed the connection, check why
+		select {
+		case <-c.remoteCloseNotify.Done():
+			return nil
+		default:
+			return ErrCloseNotifyTimeout
+		}
+	case <-timer.C:
+		return ErrCloseNotifyTimeout
+	}
+}
+
+func (c *Conn) isConnectionClosing() bool {
+	select {
+	case <-c.closing.Done():
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *Conn) isConnectionClosed() bool {
@@ -1138,7 +2025,47 @@ func (c *Conn) setRemoteEpoch(epoch uint16) {
 
 // LocalAddr implements net.Conn.LocalAddr
 func (c *Conn) LocalAddr() net.Addr {
-	return c.nextConn.LocalAddr()
+	return c.transport().LocalAddr()
+}
+
+func (c *Conn) transport() netctx.PacketConn {
+	c.transportLock.RLock()
+	defer c.transportLock.RUnlock()
+	return c.nextConn
+}
+
+// SetTransport moves the connection to conn, e.g. a new socket of a mobile
+// client whose network changed, without a new handshake. Records are sent
+// and read on conn from then on. The peer keeps matching records by the
+// connection ID it issued and learns the new address from them, so
+// SetTransport requires that the peer sends a connection ID, see
+// Config.ConnectionIDGenerator. The previous PacketConn is neither read nor
+// closed anymore and has to be closed by the caller, its read deadline is
+// used to interrupt the pending read.
+func (c *Conn) SetTransport(conn net.PacketConn) error {
+	if conn == nil {
+		return errNilNextConn
+	}
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	c.lock.RLock()
+	hasConnectionID := len(c.state.remoteConnectionID) > 0
+	c.lock.RUnlock()
+	if !hasConnectionID {
+		return errMigrationWithoutConnectionID
+	}
+
+	c.transportLock.Lock()
+	previous := c.nextConn
+	c.nextConn = netctx.NewPacketConn(conn)
+	c.transportLock.Unlock()
+
+	// Wake the read loop blocked on the previous transport
+	return previous.Conn().SetReadDeadline(time.Unix(1, 0))
 }
 
 // RemoteAddr implements net.Conn.RemoteAddr
@@ -1158,6 +2085,17 @@ func (c *Conn) sessionKey() []byte {
 	return c.state.SessionID
 }
 
+// handshakeDeadline is closed when Config.HandshakeDeadline passes, it stops
+// a handshake waiting on its peer
+func (c *Conn) handshakeDeadline() <-chan struct{} {
+	// The deadline doesn't apply to a renegotiation, nor to a false started
+	// handshake the application already reads from
+	if c.isHandshakeCompletedSuccessfully() || c.isFalseStartPending() {
+		return nil
+	}
+	return c.handshakeDeadlineTimer.Done()
+}
+
 // SetDeadline implements net.Conn.SetDeadline
 func (c *Conn) SetDeadline(t time.Time) error {
 	c.readDeadline.Set(t)
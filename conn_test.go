@@ -7,17 +7,29 @@ import (
 	"bytes"
 	"context"
 	"crypto"
+	"crypto/aes"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	cryptoElliptic "crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
+	mathRand "math/rand"
 	"net"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -25,8 +37,10 @@ import (
 	"time"
 
 	"github.com/adrian38/dtls/v2/internal/ciphersuite"
+	"github.com/adrian38/dtls/v2/internal/ciphersuite/types"
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
+	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
 	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
 	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
 	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
@@ -36,6 +50,7 @@ import (
 	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/adrian38/dtls/v2/pkg/testutil"
 	"github.com/pion/logging"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
@@ -47,6 +62,7 @@ var (
 	errNotExpectedChain       = errors.New("not expected chain")
 	errExpecedChain           = errors.New("expected chain")
 	errWrongCert              = errors.New("wrong cert")
+	errOCSPUnavailable        = errors.New("OCSP response unavailable")
 )
 
 func TestStressDuplex(t *testing.T) {
@@ -373,8 +389,9 @@ func TestHandshakeWithAlert(t *testing.T) {
 				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
 				SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
 			},
-			errServer: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			errClient: errNoAvailableSignatureSchemes,
+			// The server only signs with schemes listed in the client's signature_algorithms
+			errServer: errNoAvailableSignatureSchemes,
+			errClient: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
 		},
 	}
 
@@ -402,6 +419,365 @@ func TestHandshakeWithAlert(t *testing.T) {
 	}
 }
 
+func TestHandshakeErrorFields(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	errVerify := errors.New("untrusted certificate") //nolint:goerr113
+	cases := map[string]struct {
+		configServer, configClient *Config
+		errServer, errClient       error
+		alertServer, alertClient   *alert.Alert
+		flightServer, flightClient string
+	}{
+		"CipherSuiteNoIntersection": {
+			configServer: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			},
+			configClient: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			},
+			errServer:    errCipherSuiteNoIntersection,
+			errClient:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			alertServer:  &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity},
+			alertClient:  &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity},
+			flightServer: "Flight 0",
+			flightClient: "Flight 1",
+		},
+		"CertificateRejected": {
+			configServer: &Config{},
+			configClient: &Config{
+				VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+					return errVerify
+				},
+			},
+			errServer:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}},
+			errClient:    errVerify,
+			alertServer:  &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate},
+			alertClient:  &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate},
+			flightServer: "Flight 4",
+			flightClient: "Flight 5",
+		},
+	}
+
+	for name, testCase := range cases {
+		testCase := testCase
+		t.Run(name, func(t *testing.T) {
+			clientErr := make(chan error, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), testCase.configClient, true)
+				clientErr <- err
+			}()
+
+			_, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), testCase.configServer, true)
+			errClient := <-clientErr
+			for _, side := range []struct {
+				name   string
+				err    error
+				cause  error
+				alert  *alert.Alert
+				flight string
+			}{
+				{"Server", errServer, testCase.errServer, testCase.alertServer, testCase.flightServer},
+				{"Client", errClient, testCase.errClient, testCase.alertClient, testCase.flightClient},
+			} {
+				var handshakeErr *HandshakeError
+				if !errors.As(side.err, &handshakeErr) {
+					t.Fatalf("%s error exp(%T) failed(%v)", side.name, handshakeErr, side.err)
+				}
+				if !errors.Is(handshakeErr.Err, side.cause) {
+					t.Errorf("%s cause exp(%v) failed(%v)", side.name, side.cause, handshakeErr.Err)
+				}
+				if !reflect.DeepEqual(handshakeErr.Alert, side.alert) {
+					t.Errorf("%s alert exp(%v) failed(%v)", side.name, side.alert, handshakeErr.Alert)
+				}
+				if handshakeErr.Flight != side.flight {
+					t.Errorf("%s flight exp(%s) failed(%s)", side.name, side.flight, handshakeErr.Flight)
+				}
+			}
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = cb.Close()
+		}()
+		ctxTimeout, cancelTimeout := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancelTimeout()
+		// Nobody answers the ClientHello
+		_, err := testClient(ctxTimeout, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, true)
+		var handshakeErr *HandshakeError
+		if !errors.As(err, &handshakeErr) {
+			t.Fatalf("Error exp(%T) failed(%v)", handshakeErr, err)
+		}
+		if !handshakeErr.Timeout() {
+			t.Errorf("Expected a timeout, got %v", handshakeErr.Err)
+		}
+		if handshakeErr.Alert != nil {
+			t.Errorf("Expected no alert, got %v", handshakeErr.Alert)
+		}
+		if handshakeErr.Flight != "Flight 1" {
+			t.Errorf("flight exp(Flight 1) failed(%s)", handshakeErr.Flight)
+		}
+		var protocolErr *protocol.HandshakeError
+		if !errors.As(err, &protocolErr) || protocolErr.Err != handshakeErr.Err {
+			t.Errorf("Expected a %T with the same cause, got %v", protocolErr, protocolErr)
+		}
+	})
+}
+
+// alertRecorder collects the alerts reported to Config.OnAlert
+type alertRecorder struct {
+	mu     sync.Mutex
+	alerts []recordedAlert
+}
+
+type recordedAlert struct {
+	sent  bool
+	alert alert.Alert
+}
+
+func (r *alertRecorder) onAlert(sent bool, level alert.Level, desc alert.Description) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.alerts = append(r.alerts, recordedAlert{sent, alert.Alert{Level: level, Description: desc}})
+}
+
+func (r *alertRecorder) recorded() []recordedAlert {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]recordedAlert{}, r.alerts...)
+}
+
+func TestOnAlert(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("Fatal", func(t *testing.T) {
+		var clientAlerts, serverAlerts alertRecorder
+		clientErr := make(chan error, 1)
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				OnAlert:      clientAlerts.onAlert,
+			}, true)
+			clientErr <- err
+		}()
+
+		if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			OnAlert:      serverAlerts.onAlert,
+		}, true); !errors.Is(err, errCipherSuiteNoIntersection) {
+			t.Fatalf("Server error mismatch: expected(%v) actual(%v)", errCipherSuiteNoIntersection, err)
+		}
+		if err := <-clientErr; err == nil {
+			t.Fatal("Expected the client handshake to fail")
+		}
+
+		insufficientSecurity := alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}
+		if alerts := clientAlerts.recorded(); !reflect.DeepEqual(alerts, []recordedAlert{{false, insufficientSecurity}}) {
+			t.Errorf("Client alerts mismatch: expected received %v, got %v", &insufficientSecurity, alerts)
+		}
+		if alerts := serverAlerts.recorded(); !reflect.DeepEqual(alerts, []recordedAlert{{true, insufficientSecurity}}) {
+			t.Errorf("Server alerts mismatch: expected sent %v, got %v", &insufficientSecurity, alerts)
+		}
+	})
+
+	t.Run("CloseNotify", func(t *testing.T) {
+		var clientAlerts, serverAlerts alertRecorder
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result, 1)
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{OnAlert: clientAlerts.onAlert}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{OnAlert: serverAlerts.onAlert}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+
+		// The client closes, the server learns the peer initiated it
+		if err := res.c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Read(make([]byte, 128)); !errors.Is(err, io.EOF) {
+			t.Fatalf("Server error mismatch: expected(%v) actual(%v)", io.EOF, err)
+		}
+		if err := server.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		closeNotify := alert.Alert{Level: alert.Warning, Description: alert.CloseNotify}
+		if alerts := clientAlerts.recorded(); len(alerts) == 0 || alerts[0] != (recordedAlert{true, closeNotify}) {
+			t.Errorf("Client alerts mismatch: expected sent %v first, got %v", &closeNotify, alerts)
+		}
+		if alerts := serverAlerts.recorded(); len(alerts) == 0 || alerts[0] != (recordedAlert{false, closeNotify}) {
+			t.Errorf("Server alerts mismatch: expected received %v first, got %v", &closeNotify, alerts)
+		}
+	})
+}
+
+func TestWarningAlertKeepsConnection(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	client, server, err := pipeConn(ca, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	if err := client.notify(context.Background(), alert.Warning, alert.UnsupportedExtension); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The warning is not reported to Read, the data after it arrives
+	buf := make([]byte, 128)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatalf("Server read failed after a warning alert: %v", err)
+	}
+	if !bytes.Equal(buf[:n], []byte("hello")) {
+		t.Fatalf("Server read %q, expected %q", buf[:n], "hello")
+	}
+
+	// Both directions remain usable
+	if _, err := server.Write([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte("world")) {
+		t.Fatalf("Client read %q, expected %q", buf[:n], "world")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const idleTimeout = 300 * time.Millisecond
+
+	connect := func(t *testing.T) (*Conn, *Conn) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result, 1)
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{IdleTimeout: idleTimeout}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.c, server
+	}
+
+	t.Run("Idle", func(t *testing.T) {
+		client, server := connect(t)
+		defer func() {
+			_ = client.Close()
+		}()
+
+		start := time.Now()
+		_, err := server.Read(make([]byte, 128))
+		if !errors.Is(err, ErrIdleTimeout) {
+			t.Fatalf("Expected error '%v', got '%v'", ErrIdleTimeout, err)
+		}
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Errorf("Expected a timeout error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed < idleTimeout/2 {
+			t.Errorf("Connection closed after %v, before the idle timeout of %v", elapsed, idleTimeout)
+		}
+		if _, err := server.Write([]byte("hello")); !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Expected error '%v', got '%v'", ErrConnClosed, err)
+		}
+	})
+
+	t.Run("Active", func(t *testing.T) {
+		client, server := connect(t)
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		// Traffic for several idle timeouts keeps the connection open
+		buf := make([]byte, 128)
+		for i := 0; i < 10; i++ {
+			time.Sleep(idleTimeout / 3)
+			if _, err := client.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.Read(buf); err != nil {
+				t.Fatalf("Read %d failed: %v", i, err)
+			}
+		}
+	})
+}
+
 func TestHandshakeWithInvalidRecord(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
@@ -497,9 +873,9 @@ func TestExportKeyingMaterial(t *testing.T) {
 
 	c.setLocalEpoch(1)
 	state = c.ConnectionState()
-	_, err = state.ExportKeyingMaterial(exportLabel, []byte{0x00}, 0)
-	if !errors.Is(err, errContextUnsupported) {
-		t.Errorf("ExportKeyingMaterial with context: expected '%s' actual '%s'", errContextUnsupported, err)
+	_, err = state.ExportKeyingMaterial(exportLabel, make([]byte, math.MaxUint16+1), 0)
+	if !errors.Is(err, errContextTooLong) {
+		t.Errorf("ExportKeyingMaterial with long context: expected '%s' actual '%s'", errContextTooLong, err)
 	}
 
 	for k := range invalidKeyingLabels() {
@@ -528,192 +904,130 @@ func TestExportKeyingMaterial(t *testing.T) {
 	}
 }
 
-func TestPSK(t *testing.T) {
-	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
-	defer lim.Stop()
-
-	// Check for leaking routines
-	report := test.CheckRoutines(t)
-	defer report()
+// Expected values generated with OpenSSL's TLS1-PRF, which SSL_export_keying_material
+// uses with a seed of label + client_random + server_random [+ uint16(len(context)) + context]
+func TestExportKeyingMaterialContext(t *testing.T) {
+	var clientRandom, serverRandom [handshake.RandomLength]byte
+	for i := range clientRandom {
+		clientRandom[i] = byte(i)
+		serverRandom[i] = byte(i + 32)
+	}
+	masterSecret := make([]byte, 48)
+	for i := range masterSecret {
+		masterSecret[i] = byte(i + 100)
+	}
+	exportLabel := "EXPERIMENTAL-dtls"
 
 	for _, test := range []struct {
-		Name                   string
-		ServerIdentity         []byte
-		CipherSuites           []CipherSuiteID
-		ClientVerifyConnection func(*State) error
-		ServerVerifyConnection func(*State) error
-		WantFail               bool
-		ExpectedServerErr      string
-		ExpectedClientErr      string
+		Name        string
+		CipherSuite CipherSuite
+		Context     []byte
+		Expected    []byte
 	}{
 		{
-			Name:           "Server identity specified",
-			ServerIdentity: []byte("Test Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		},
-		{
-			Name:           "Server identity specified - Server verify connection fails",
-			ServerIdentity: []byte("Test Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-			ServerVerifyConnection: func(*State) error {
-				return errExample
-			},
-			WantFail:          true,
-			ExpectedServerErr: errExample.Error(),
-			ExpectedClientErr: alert.BadCertificate.String(),
+			Name:        "No context",
+			CipherSuite: &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+			Context:     nil,
+			Expected:    []byte{0xbf, 0x7f, 0x61, 0x62, 0xfe, 0x48, 0xa6, 0xe6, 0x50, 0xa6, 0x57, 0x3a, 0x73, 0x9a, 0xbf, 0xe7, 0xe3, 0xea, 0xbc, 0x1d},
 		},
 		{
-			Name:           "Server identity specified - Client verify connection fails",
-			ServerIdentity: []byte("Test Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-			ClientVerifyConnection: func(*State) error {
-				return errExample
-			},
-			WantFail:          true,
-			ExpectedServerErr: alert.BadCertificate.String(),
-			ExpectedClientErr: errExample.Error(),
+			Name:        "Empty context",
+			CipherSuite: &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+			Context:     []byte{},
+			Expected:    []byte{0xb4, 0x45, 0x34, 0xfa, 0xa9, 0x8c, 0xab, 0xdb, 0xfa, 0x7f, 0xaa, 0x5a, 0x22, 0x6f, 0x49, 0x73, 0xa4, 0x09, 0x2d, 0x7e},
 		},
 		{
-			Name:           "Server identity nil",
-			ServerIdentity: nil,
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			Name:        "Context SHA256",
+			CipherSuite: &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+			Context:     []byte{0xde, 0xad, 0xbe, 0xef},
+			Expected:    []byte{0xb6, 0xbc, 0xee, 0xa6, 0x83, 0x20, 0x96, 0xc6, 0xbf, 0x42, 0x99, 0x8c, 0x91, 0x72, 0x8e, 0x01, 0x8d, 0xfb, 0x36, 0xb7},
 		},
 		{
-			Name:           "TLS_PSK_WITH_AES_128_CBC_SHA256",
-			ServerIdentity: nil,
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CBC_SHA256},
-		},
-		{
-			Name:           "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256",
-			ServerIdentity: nil,
-			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256},
+			Name:        "Context SHA384",
+			CipherSuite: &ciphersuite.TLSEcdheEcdsaWithAes256GcmSha384{},
+			Context:     []byte{0xde, 0xad, 0xbe, 0xef},
+			Expected:    []byte{0x53, 0x0e, 0xae, 0x87, 0xe9, 0xb6, 0x42, 0x33, 0xee, 0xe7, 0x74, 0xa2, 0xea, 0x86, 0xe2, 0x32, 0x79, 0xcd, 0xc3, 0x61},
 		},
 	} {
 		test := test
 		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
-			clientIdentity := []byte("Client Identity")
-			type result struct {
-				c   *Conn
-				err error
-			}
-			clientRes := make(chan result, 1)
-
-			ca, cb := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					PSK: func(hint []byte) ([]byte, error) {
-						if !bytes.Equal(test.ServerIdentity, hint) {
-							return nil, fmt.Errorf("TestPSK: Client got invalid identity expected(% 02x) actual(% 02x)", test.ServerIdentity, hint) //nolint:goerr113
-						}
-
-						return []byte{0xAB, 0xC1, 0x23}, nil
+			for _, isClient := range []bool{true, false} {
+				c := &Conn{
+					state: State{
+						masterSecret:        masterSecret,
+						localSequenceNumber: []uint64{0, 0},
+						cipherSuite:         test.CipherSuite,
+						isClient:            isClient,
 					},
-					PSKIdentityHint:  clientIdentity,
-					CipherSuites:     test.CipherSuites,
-					VerifyConnection: test.ClientVerifyConnection,
-				}
-
-				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
-				clientRes <- result{c, err}
-			}()
-
-			config := &Config{
-				PSK: func(hint []byte) ([]byte, error) {
-					if !bytes.Equal(clientIdentity, hint) {
-						return nil, fmt.Errorf("%w: expected(% 02x) actual(% 02x)", errTestPSKInvalidIdentity, clientIdentity, hint)
-					}
-					return []byte{0xAB, 0xC1, 0x23}, nil
-				},
-				PSKIdentityHint:  test.ServerIdentity,
-				CipherSuites:     test.CipherSuites,
-				VerifyConnection: test.ServerVerifyConnection,
-			}
-
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false)
-			if test.WantFail {
-				res := <-clientRes
-				if err == nil || !strings.Contains(err.Error(), test.ExpectedServerErr) {
-					t.Fatalf("TestPSK: Server expected(%v) actual(%v)", test.ExpectedServerErr, err)
 				}
-				if res.err == nil || !strings.Contains(res.err.Error(), test.ExpectedClientErr) {
-					t.Fatalf("TestPSK: Client expected(%v) actual(%v)", test.ExpectedClientErr, res.err)
+				if isClient {
+					c.state.localRandom.UnmarshalFixed(clientRandom)
+					c.state.remoteRandom.UnmarshalFixed(serverRandom)
+				} else {
+					c.state.localRandom.UnmarshalFixed(serverRandom)
+					c.state.remoteRandom.UnmarshalFixed(clientRandom)
 				}
-				return
-			}
-			if err != nil {
-				t.Fatalf("TestPSK: Server failed(%v)", err)
-			}
-
-			actualPSKIdentityHint := server.ConnectionState().IdentityHint
-			if !bytes.Equal(actualPSKIdentityHint, clientIdentity) {
-				t.Errorf("TestPSK: Server ClientPSKIdentity Mismatch '%s': expected(%v) actual(%v)", test.Name, clientIdentity, actualPSKIdentityHint)
-			}
-
-			defer func() {
-				_ = server.Close()
-			}()
+				c.setLocalEpoch(1)
+				c.setRemoteEpoch(1)
 
-			res := <-clientRes
-			if res.err != nil {
-				t.Fatal(res.err)
+				keyingMaterial, err := c.ExportKeyingMaterial(exportLabel, test.Context, len(test.Expected))
+				if err != nil {
+					t.Fatalf("ExportKeyingMaterial isClient(%t): unexpected error '%s'", isClient, err)
+				} else if !bytes.Equal(keyingMaterial, test.Expected) {
+					t.Errorf("ExportKeyingMaterial isClient(%t): expected (% 02x) actual (% 02x)", isClient, test.Expected, keyingMaterial)
+				}
 			}
-			_ = res.c.Close()
 		})
 	}
 }
 
-func TestPSKHintFail(t *testing.T) {
-	// Check for leaking routines
-	report := test.CheckRoutines(t)
-	defer report()
-
-	serverAlertError := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InternalError}}
-	pskRejected := errPSKRejected
-
+// Expected values computed with the TLS 1.2 PRF (P_SHA256) over the seed
+// "EXTRACTOR-dtls_srtp" + client_random + server_random
+func TestDebugSequenceState(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	clientErr := make(chan error, 1)
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
 	ca, cb := dpipe.Pipe()
-	go func() {
-		conf := &Config{
-			PSK: func([]byte) ([]byte, error) {
-				return nil, pskRejected
-			},
-			PSKIdentityHint: []byte{},
-			CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		}
-
-		_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
-		clientErr <- err
+	client, server, err := pipeConn(ca, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
 	}()
 
-	config := &Config{
-		PSK: func([]byte) ([]byte, error) {
-			return nil, pskRejected
-		},
-		PSKIdentityHint: []byte{},
-		CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+	before := client.DebugSequenceState()
+	if before.LocalEpoch != 1 || before.RemoteEpoch != 1 {
+		t.Fatalf("Expected epoch 1 in both directions, got %+v", before)
 	}
 
-	if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false); !errors.Is(err, serverAlertError) {
-		t.Fatalf("TestPSK: Server error exp(%v) failed(%v)", serverAlertError, err)
-	}
+	buf := make([]byte, 16)
+	for i := uint64(1); i <= 3; i++ {
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Read(buf); err != nil {
+			t.Fatal(err)
+		}
 
-	if err := <-clientErr; !errors.Is(err, pskRejected) {
-		t.Fatalf("TestPSK: Client error exp(%v) failed(%v)", pskRejected, err)
+		local := client.DebugSequenceState()
+		if local.LocalEpoch != 1 || local.LocalSequenceNumber != before.LocalSequenceNumber+i {
+			t.Errorf("Write %d: expected local sequence number %d, got %+v", i, before.LocalSequenceNumber+i, local)
+		}
+		remote := server.DebugSequenceState()
+		if remote.RemoteEpoch != 1 || remote.RemoteSequenceNumber != local.LocalSequenceNumber {
+			t.Errorf("Write %d: expected remote sequence number %d, got %+v", i, local.LocalSequenceNumber, remote)
+		}
 	}
 }
 
-func TestClientTimeout(t *testing.T) {
+func TestPRFHash(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -722,92 +1036,69 @@ func TestClientTimeout(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	if _, err := (&Conn{}).PRFHash(); !errors.Is(err, errHandshakeInProgress) {
+		t.Errorf("PRFHash before handshake: expected '%s' actual '%s'", errHandshakeInProgress, err)
+	}
+	if _, _, _, err := (&Conn{}).CipherSuiteKeyLengths(); !errors.Is(err, errHandshakeInProgress) {
+		t.Errorf("CipherSuiteKeyLengths before handshake: expected '%s' actual '%s'", errHandshakeInProgress, err)
+	}
 
-	clientErr := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	ca, _ := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	ca, cb := dpipe.Pipe()
 	go func() {
-		conf := &Config{}
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}}, true)
+		c <- result{client, err}
+	}()
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+		_ = server.Close()
+	}()
 
-		c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, true)
-		if err == nil {
-			_ = c.Close() //nolint:contextcheck
+	for name, conn := range map[string]*Conn{"client": res.c, "server": server} {
+		h, err := conn.PRFHash()
+		if err != nil {
+			t.Fatalf("%s: PRFHash: %v", name, err)
+		}
+		if h != crypto.SHA384 {
+			t.Errorf("%s: expected PRF hash %v, got %v", name, crypto.SHA384, h)
 		}
-		clientErr <- err
-	}()
 
-	// no server!
-	err := <-clientErr
-	var netErr net.Error
-	if !errors.As(err, &netErr) || !netErr.Timeout() {
-		t.Fatalf("Client error exp(Temporary network error) failed(%v)", err)
+		macLen, keyLen, ivLen, err := conn.CipherSuiteKeyLengths()
+		if err != nil {
+			t.Fatalf("%s: CipherSuiteKeyLengths: %v", name, err)
+		}
+		if macLen != 0 || keyLen != 32 || ivLen != 4 {
+			t.Errorf("%s: expected key lengths 0, 32, 4, got %d, %d, %d", name, macLen, keyLen, ivLen)
+		}
 	}
 }
 
-func TestSRTPConfiguration(t *testing.T) {
+func TestGetChannelBinding(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name            string
-		ClientSRTP      []SRTPProtectionProfile
-		ServerSRTP      []SRTPProtectionProfile
-		ExpectedProfile SRTPProtectionProfile
-		WantClientError error
-		WantServerError error
-	}{
-		{
-			Name:            "No SRTP in use",
-			ClientSRTP:      nil,
-			ServerSRTP:      nil,
-			ExpectedProfile: 0,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "SRTP both ends",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "SRTP client only",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ServerSRTP:      nil,
-			ExpectedProfile: 0,
-			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			WantServerError: errServerNoMatchingSRTPProfile,
-		},
-		{
-			Name:            "SRTP server only",
-			ClientSRTP:      nil,
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: 0,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "Multiple Suites",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "Multiple Suites, Client Chooses",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_32, SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-	} {
+	handshakePair := func(t *testing.T, clientCfg, serverCfg *Config) (*Conn, *Conn) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
@@ -819,798 +1110,7340 @@ func TestSRTPConfiguration(t *testing.T) {
 		c := make(chan result)
 
 		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ClientSRTP}, true)
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), clientCfg, false)
 			c <- result{client, err}
 		}()
 
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ServerSRTP}, true)
-		if !errors.Is(err, test.WantServerError) {
-			t.Errorf("TestSRTPConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
-		}
-		if err == nil {
-			defer func() {
-				_ = server.Close()
-			}()
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), serverCfg, true)
+		if err != nil {
+			t.Fatal(err)
 		}
-
 		res := <-c
-		if res.err == nil {
-			defer func() {
-				_ = res.c.Close()
-			}()
-		}
-		if !errors.Is(res.err, test.WantClientError) {
-			t.Fatalf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+		if res.err != nil {
+			t.Fatal(res.err)
 		}
-		if res.c == nil {
-			return
+		return res.c, server
+	}
+	// finishedVerifyData returns the verify_data of the Finished message
+	// sent by the client or the server
+	finishedVerifyData := func(t *testing.T, c *Conn, fromClient bool) []byte {
+		items := c.handshakeCache.pull(handshakeCachePullRule{handshake.TypeFinished, c.fsm.cfg.initialEpoch + 1, fromClient, false})
+		if items[0] == nil {
+			t.Fatal("Finished message not found")
 		}
-
-		actualClientSRTP, _ := res.c.SelectedSRTPProtectionProfile()
-		if actualClientSRTP != test.ExpectedProfile {
-			t.Errorf("TestSRTPConfiguration: Client SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualClientSRTP)
+		h := &handshake.Handshake{}
+		if err := h.Unmarshal(items[0].data); err != nil {
+			t.Fatal(err)
 		}
-
-		actualServerSRTP, _ := server.SelectedSRTPProtectionProfile()
-		if actualServerSRTP != test.ExpectedProfile {
-			t.Errorf("TestSRTPConfiguration: Server SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualServerSRTP)
+		finished, ok := h.Message.(*handshake.MessageFinished)
+		if !ok {
+			t.Fatalf("Unexpected message %T", h.Message)
 		}
+		return finished.VerifyData
+	}
+
+	t.Run("TLSUnique", func(t *testing.T) {
+		clientStore, serverStore := &memSessStore{}, &memSessStore{}
+		for _, resumed := range []bool{false, true} {
+			client, server := handshakePair(t,
+				&Config{ServerName: "example.com", SessionStore: clientStore},
+				&Config{SessionStore: serverStore},
+			)
+			if client.state.resumed != resumed {
+				t.Fatalf("Expected resumed %v, got %v", resumed, client.state.resumed)
+			}
+
+			// The client's Finished comes first in a full handshake, the
+			// server's when the session is resumed
+			expected := finishedVerifyData(t, client, !resumed)
+			for name, c := range map[string]*Conn{"client": client, "server": server} {
+				actual, err := c.GetChannelBinding(ChannelBindingTLSUnique)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(actual) != 12 || !bytes.Equal(actual, expected) {
+					t.Errorf("%s: tls-unique mismatch (resumed %v): expected(%x) actual(%x)", name, resumed, expected, actual)
+				}
+			}
+			_ = client.Close()
+			_ = server.Close()
+		}
+	})
+
+	t.Run("TLSExporter", func(t *testing.T) {
+		client, server := handshakePair(t, &Config{}, &Config{})
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		clientBinding, err := client.GetChannelBinding(ChannelBindingTLSExporter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverBinding, err := server.GetChannelBinding(ChannelBindingTLSExporter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(clientBinding) != 32 || !bytes.Equal(clientBinding, serverBinding) {
+			t.Fatalf("tls-exporter mismatch: client(%x) server(%x)", clientBinding, serverBinding)
+		}
+		state := client.ConnectionState()
+		expected, err := state.ExportKeyingMaterial("EXPORTER-Channel-Binding", []byte{}, 32)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(clientBinding, expected) {
+			t.Fatalf("tls-exporter mismatch: expected(%x) actual(%x)", expected, clientBinding)
+		}
+
+		if _, err := client.GetChannelBinding("tls-server-end-point"); !errors.Is(err, errUnsupportedChannelBinding) {
+			t.Fatalf("Expected error '%v', got '%v'", errUnsupportedChannelBinding, err)
+		}
+
+		// Pretend the handshake is still in progress
+		client.handshakeCompletedSuccessfully.Store(struct{ bool }{false})
+		for _, cbType := range []string{ChannelBindingTLSUnique, ChannelBindingTLSExporter} {
+			if _, err := client.GetChannelBinding(cbType); !errors.Is(err, errHandshakeInProgress) {
+				t.Errorf("%s: Expected error '%v', got '%v'", cbType, errHandshakeInProgress, err)
+			}
+		}
+		client.handshakeCompletedSuccessfully.Store(struct{ bool }{true})
+	})
+
+	t.Run("TLSExporterWithoutEMS", func(t *testing.T) {
+		client, server := handshakePair(t,
+			&Config{ExtendedMasterSecret: DisableExtendedMasterSecret},
+			&Config{ExtendedMasterSecret: DisableExtendedMasterSecret},
+		)
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		if _, err := client.GetChannelBinding(ChannelBindingTLSExporter); !errors.Is(err, errChannelBindingRequiresEMS) {
+			t.Fatalf("Expected error '%v', got '%v'", errChannelBindingRequiresEMS, err)
+		}
+	})
+}
+
+func TestExportSRTPKeyingMaterial(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	var rand [28]byte
+	masterSecret := make([]byte, 48)
+	for i := range masterSecret {
+		masterSecret[i] = byte(i)
+	}
+
+	for name, tt := range map[string]struct {
+		isClient bool
+		profile  SRTPProtectionProfile
+		expected []byte
+	}{
+		"ServerAES128CM": {
+			profile: SRTP_AES128_CM_HMAC_SHA1_80,
+			expected: []byte{
+				0x2d, 0x10, 0x07, 0x70, 0x0f, 0x13, 0x61, 0x44, 0xa2, 0x22, 0x99, 0xec, 0xd6, 0xd8, 0xe4, 0x0d,
+				0x4e, 0xef, 0x9f, 0x52, 0x51, 0x50, 0x93, 0x98, 0x7b, 0x9c, 0x4a, 0xea, 0x15, 0x00, 0x98, 0x62,
+				0x37, 0xea, 0xce, 0xc8, 0x19, 0xf3, 0xf3, 0xe2, 0xe8, 0x0f, 0x0d, 0x67, 0x71, 0x08, 0xaa, 0xc9,
+				0x98, 0xba, 0x13, 0xf8, 0xcb, 0x2f, 0xb1, 0x20, 0x3a, 0x3e, 0x1c, 0x4f,
+			},
+		},
+		"ClientAEADAES256GCM": {
+			isClient: true,
+			profile:  SRTP_AEAD_AES_256_GCM,
+			expected: []byte{
+				0xf4, 0xd6, 0xd9, 0xf5, 0x60, 0x57, 0xfe, 0xf3, 0x3d, 0xcb, 0x1a, 0x31, 0x8e, 0x98, 0x14, 0x4f,
+				0x26, 0xa1, 0xcd, 0xb2, 0x3b, 0xc8, 0xaa, 0x6e, 0x09, 0xab, 0xc8, 0xd6, 0x15, 0x8f, 0xd3, 0xc2,
+				0x61, 0x6f, 0x4d, 0xe8, 0xbc, 0x25, 0x86, 0x0a, 0xa9, 0x1a, 0xcc, 0x40, 0xf3, 0x57, 0xc1, 0xf8,
+				0x77, 0xcf, 0x9a, 0x24, 0x47, 0xfd, 0xba, 0x6b, 0xc3, 0xa2, 0x16, 0x23, 0x6d, 0x27, 0x48, 0x20,
+				0xc9, 0x4f, 0xdc, 0x9a, 0xa9, 0xa8, 0x6c, 0x7a, 0x65, 0x3a, 0x06, 0x2f, 0xbd, 0xd3, 0x44, 0x87,
+				0xf7, 0x21, 0xd1, 0x81, 0xdc, 0xe4, 0x80, 0x56,
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			c := &Conn{
+				state: State{
+					localRandom:         handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand},
+					remoteRandom:        handshake.Random{GMTUnixTime: time.Unix(1000, 0), RandomBytes: rand},
+					localSequenceNumber: []uint64{0, 0},
+					masterSecret:        masterSecret,
+					cipherSuite:         &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+					isClient:            tt.isClient,
+				},
+			}
+			c.setLocalEpoch(1)
+			c.setRemoteEpoch(1)
+
+			if _, err := c.ExportSRTPKeyingMaterial(); !errors.Is(err, errHandshakeInProgress) {
+				t.Errorf("ExportSRTPKeyingMaterial before handshake: expected '%s' actual '%s'", errHandshakeInProgress, err)
+			}
+
+			c.handshakeCompletedSuccessfully.Store(struct{ bool }{true})
+			if _, err := c.ExportSRTPKeyingMaterial(); !errors.Is(err, errNoSRTPProtectionProfile) {
+				t.Errorf("ExportSRTPKeyingMaterial without profile: expected '%s' actual '%s'", errNoSRTPProtectionProfile, err)
+			}
+			if _, ok := c.SelectedSRTPProtectionProfile(); ok {
+				t.Error("SelectedSRTPProtectionProfile without profile: expected no profile")
+			}
+
+			c.state.setSRTPProtectionProfile(tt.profile)
+			if profile, ok := c.SelectedSRTPProtectionProfile(); !ok || profile != tt.profile {
+				t.Errorf("SelectedSRTPProtectionProfile: expected %v actual %v", tt.profile, profile)
+			}
+			keyingMaterial, err := c.ExportSRTPKeyingMaterial()
+			if err != nil {
+				t.Fatalf("ExportSRTPKeyingMaterial: unexpected error '%s'", err)
+			}
+			if !bytes.Equal(keyingMaterial, tt.expected) {
+				t.Errorf("ExportSRTPKeyingMaterial: expected (% 02x) actual (% 02x)", tt.expected, keyingMaterial)
+			}
+		})
+	}
+}
+
+func TestPSK(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                   string
+		ServerIdentity         []byte
+		CipherSuites           []CipherSuiteID
+		ClientVerifyConnection func(*State) error
+		ServerVerifyConnection func(*State) error
+		WantFail               bool
+		ExpectedServerErr      string
+		ExpectedClientErr      string
+	}{
+		{
+			Name:           "Server identity specified",
+			ServerIdentity: []byte("Test Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		},
+		{
+			Name:           "Server identity specified - Server verify connection fails",
+			ServerIdentity: []byte("Test Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			ServerVerifyConnection: func(*State) error {
+				return errExample
+			},
+			WantFail:          true,
+			ExpectedServerErr: errExample.Error(),
+			ExpectedClientErr: alert.BadCertificate.String(),
+		},
+		{
+			Name:           "Server identity specified - Client verify connection fails",
+			ServerIdentity: []byte("Test Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			ClientVerifyConnection: func(*State) error {
+				return errExample
+			},
+			WantFail:          true,
+			ExpectedServerErr: alert.BadCertificate.String(),
+			ExpectedClientErr: errExample.Error(),
+		},
+		{
+			Name:           "Server identity nil",
+			ServerIdentity: nil,
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		},
+		{
+			Name:           "TLS_PSK_WITH_AES_128_CBC_SHA256",
+			ServerIdentity: nil,
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CBC_SHA256},
+		},
+		{
+			Name:           "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256",
+			ServerIdentity: nil,
+			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256},
+		},
+		{
+			Name:           "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256",
+			ServerIdentity: nil,
+			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			Name:           "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 - Server identity specified",
+			ServerIdentity: []byte("Test Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			clientIdentity := []byte("Client Identity")
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					PSK: func(hint []byte) ([]byte, error) {
+						if !bytes.Equal(test.ServerIdentity, hint) {
+							return nil, fmt.Errorf("TestPSK: Client got invalid identity expected(% 02x) actual(% 02x)", test.ServerIdentity, hint) //nolint:goerr113
+						}
+
+						return []byte{0xAB, 0xC1, 0x23}, nil
+					},
+					PSKIdentityHint:  clientIdentity,
+					CipherSuites:     test.CipherSuites,
+					VerifyConnection: test.ClientVerifyConnection,
+				}
+
+				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+				clientRes <- result{c, err}
+			}()
+
+			config := &Config{
+				PSK: func(hint []byte) ([]byte, error) {
+					if !bytes.Equal(clientIdentity, hint) {
+						return nil, fmt.Errorf("%w: expected(% 02x) actual(% 02x)", errTestPSKInvalidIdentity, clientIdentity, hint)
+					}
+					return []byte{0xAB, 0xC1, 0x23}, nil
+				},
+				PSKIdentityHint:  test.ServerIdentity,
+				CipherSuites:     test.CipherSuites,
+				VerifyConnection: test.ServerVerifyConnection,
+			}
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false)
+			if test.WantFail {
+				res := <-clientRes
+				if err == nil || !strings.Contains(err.Error(), test.ExpectedServerErr) {
+					t.Fatalf("TestPSK: Server expected(%v) actual(%v)", test.ExpectedServerErr, err)
+				}
+				if res.err == nil || !strings.Contains(res.err.Error(), test.ExpectedClientErr) {
+					t.Fatalf("TestPSK: Client expected(%v) actual(%v)", test.ExpectedClientErr, res.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TestPSK: Server failed(%v)", err)
+			}
+
+			actualPSKIdentityHint := server.ConnectionState().IdentityHint
+			if !bytes.Equal(actualPSKIdentityHint, clientIdentity) {
+				t.Errorf("TestPSK: Server ClientPSKIdentity Mismatch '%s': expected(%v) actual(%v)", test.Name, clientIdentity, actualPSKIdentityHint)
+			}
+
+			defer func() {
+				_ = server.Close()
+			}()
+
+			res := <-clientRes
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if !bytes.Equal(server.state.masterSecret, res.c.state.masterSecret) {
+				t.Errorf("TestPSK: Master secret mismatch '%s'", test.Name)
+			}
+			_ = res.c.Close()
+		})
+	}
+}
+
+func TestPSKHintFail(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverAlertError := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InternalError}}
+	pskRejected := errPSKRejected
+
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientErr := make(chan error, 1)
+
+	ca, cb := dpipe.Pipe()
+	go func() {
+		conf := &Config{
+			PSK: func([]byte) ([]byte, error) {
+				return nil, pskRejected
+			},
+			PSKIdentityHint: []byte{},
+			CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		}
+
+		_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+		clientErr <- err
+	}()
+
+	config := &Config{
+		PSK: func([]byte) ([]byte, error) {
+			return nil, pskRejected
+		},
+		PSKIdentityHint: []byte{},
+		CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+	}
+
+	if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false); !errors.Is(err, serverAlertError) {
+		t.Fatalf("TestPSK: Server error exp(%v) failed(%v)", serverAlertError, err)
+	}
+
+	if err := <-clientErr; !errors.Is(err, pskRejected) {
+		t.Fatalf("TestPSK: Client error exp(%v) failed(%v)", pskRejected, err)
+	}
+}
+
+func TestPSKIdentitySelection(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// The client holds a key for each server it talks to and uses the hint
+	// to find out which one it is connected to
+	clientKeys := map[string]struct {
+		identity, key []byte
+	}{
+		"Server A": {[]byte("Client at A"), []byte{0xAA, 0xAA, 0xAA}},
+		"Server B": {[]byte("Client at B"), []byte{0xBB, 0xBB, 0xBB}},
+	}
+
+	for name, hint := range map[string][]byte{
+		"ServerA": []byte("Server A"),
+		"ServerB": []byte("Server B"),
+	} {
+		hint := hint
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					PSK: func(hint []byte) ([]byte, error) {
+						if k, ok := clientKeys[string(hint)]; ok {
+							return k.key, nil
+						}
+						return nil, errPSKRejected
+					},
+					PSKIdentity: func(hint []byte) ([]byte, error) {
+						if k, ok := clientKeys[string(hint)]; ok {
+							return k.identity, nil
+						}
+						return nil, errPSKRejected
+					},
+					CipherSuites: []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+				}
+
+				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+				clientRes <- result{c, err}
+			}()
+
+			expected := clientKeys[string(hint)]
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				PSK: func(identity []byte) ([]byte, error) {
+					if !bytes.Equal(identity, expected.identity) {
+						return nil, errPSKRejected
+					}
+					return expected.key, nil
+				},
+				PSKIdentityHint: hint,
+				CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := <-clientRes
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if actual := server.ConnectionState().IdentityHint; !bytes.Equal(actual, expected.identity) {
+				t.Fatalf("Server received identity %q, expected %q", actual, expected.identity)
+			}
+			if actual := res.c.ConnectionState().IdentityHint; !bytes.Equal(actual, hint) {
+				t.Fatalf("Client received hint %q, expected %q", actual, hint)
+			}
+			_ = res.c.Close()
+			_ = server.Close()
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	clientErr := make(chan error, 1)
+
+	ca, _ := dpipe.Pipe()
+	go func() {
+		conf := &Config{}
+
+		c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, true)
+		if err == nil {
+			_ = c.Close() //nolint:contextcheck
+		}
+		clientErr <- err
+	}()
+
+	// no server!
+	err := <-clientErr
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Client error exp(Temporary network error) failed(%v)", err)
+	}
+}
+
+func TestSRTPConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name            string
+		ClientSRTP      []SRTPProtectionProfile
+		ServerSRTP      []SRTPProtectionProfile
+		ExpectedProfile SRTPProtectionProfile
+		WantClientError error
+		WantServerError error
+	}{
+		{
+			Name:            "No SRTP in use",
+			ClientSRTP:      nil,
+			ServerSRTP:      nil,
+			ExpectedProfile: 0,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "SRTP both ends",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "SRTP client only",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ServerSRTP:      nil,
+			ExpectedProfile: 0,
+			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			WantServerError: errServerNoMatchingSRTPProfile,
+		},
+		{
+			Name:            "SRTP server only",
+			ClientSRTP:      nil,
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: 0,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "Multiple Suites",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "Multiple Suites, Server Chooses",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_32, SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_32,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ClientSRTP}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ServerSRTP}, true)
+		if !errors.Is(err, test.WantServerError) {
+			t.Errorf("TestSRTPConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+		}
+		if err == nil {
+			defer func() {
+				_ = server.Close()
+			}()
+		}
+
+		res := <-c
+		if res.err == nil {
+			defer func() {
+				_ = res.c.Close()
+			}()
+		}
+		if !errors.Is(res.err, test.WantClientError) {
+			t.Fatalf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+		}
+		if res.c == nil {
+			return
+		}
+
+		actualClientSRTP, _ := res.c.SelectedSRTPProtectionProfile()
+		if actualClientSRTP != test.ExpectedProfile {
+			t.Errorf("TestSRTPConfiguration: Client SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualClientSRTP)
+		}
+
+		actualServerSRTP, _ := server.SelectedSRTPProtectionProfile()
+		if actualServerSRTP != test.ExpectedProfile {
+			t.Errorf("TestSRTPConfiguration: Server SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualServerSRTP)
+		}
+
+		if offered := server.OfferedSRTPProtectionProfiles(); len(offered) != len(test.ClientSRTP) || (len(offered) > 0 && !reflect.DeepEqual(offered, test.ClientSRTP)) {
+			t.Errorf("TestSRTPConfiguration: Server OfferedSRTPProtectionProfiles Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ClientSRTP, offered)
+		}
+
+		if test.ExpectedProfile != 0 {
+			clientKeyingMaterial, err := res.c.ExportSRTPKeyingMaterial()
+			if err != nil {
+				t.Fatalf("TestSRTPConfiguration: Client ExportSRTPKeyingMaterial '%s': %v", test.Name, err)
+			}
+			serverKeyingMaterial, err := server.ExportSRTPKeyingMaterial()
+			if err != nil {
+				t.Fatalf("TestSRTPConfiguration: Server ExportSRTPKeyingMaterial '%s': %v", test.Name, err)
+			}
+			if !bytes.Equal(clientKeyingMaterial, serverKeyingMaterial) {
+				t.Errorf("TestSRTPConfiguration: SRTP keying material mismatch '%s'", test.Name)
+			}
+		}
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	srvCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvCAPool := x509.NewCertPool()
+	srvCertificate, err := x509.ParseCertificate(srvCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvCAPool.AddCert(srvCertificate)
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
+		tests := map[string]struct {
+			clientCfg *Config
+			serverCfg *Config
+			wantErr   bool
+		}{
+			"NoClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+				},
+			},
+			"NoClientCert_ServerVerifyConnectionFails": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+					VerifyConnection: func(*State) error {
+						return errExample
+					},
+				},
+				wantErr: true,
+			},
+			"NoClientCert_ClientVerifyConnectionFails": {
+				clientCfg: &Config{RootCAs: srvCAPool, VerifyConnection: func(*State) error {
+					return errExample
+				}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+				},
+				wantErr: true,
+			},
+			"NoClientCert_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+			},
+			"RequestClientCert_cert_sigscheme": { // specify signature algorithm
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
+					Certificates:     []tls.Certificate{srvCert},
+					ClientAuth:       RequestClientCert,
+				},
+			},
+			"RequestClientCert_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequestClientCert,
+				},
+			},
+			"RequestClientCert_no_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequestClientCert,
+					ClientCAs:    caPool,
+				},
+			},
+			"RequireAnyClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+			},
+			"RequireAnyClientCert_error": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+				wantErr: true,
+			},
+			"VerifyClientCertIfGiven_no_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+					ClientCAs:    caPool,
+				},
+			},
+			"VerifyClientCertIfGiven_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+					ClientCAs:    caPool,
+				},
+			},
+			"VerifyClientCertIfGiven_error": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+				},
+				wantErr: true,
+			},
+			"RequireAndVerifyClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}, VerifyConnection: func(s *State) error {
+					if ok := bytes.Equal(s.PeerCertificates[0], srvCertificate.Raw); !ok {
+						return errExample
+					}
+					return nil
+				}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAndVerifyClientCert,
+					ClientCAs:    caPool,
+					VerifyConnection: func(s *State) error {
+						if ok := bytes.Equal(s.PeerCertificates[0], certificate.Raw); !ok {
+							return errExample
+						}
+						return nil
+					},
+				},
+			},
+			"RequireAndVerifyClientCert_callbacks": {
+				clientCfg: &Config{
+					RootCAs: srvCAPool,
+					// Certificates:   []tls.Certificate{cert},
+					GetClientCertificate: func(*CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil },
+				},
+				serverCfg: &Config{
+					GetCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) { return &srvCert, nil },
+					// Certificates:   []tls.Certificate{srvCert},
+					ClientAuth: RequireAndVerifyClientCert,
+					ClientCAs:  caPool,
+				},
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				type result struct {
+					c   *Conn
+					err error
+				}
+				c := make(chan result)
+
+				go func() {
+					client, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+					c <- result{client, err}
+				}()
+
+				server, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+				res := <-c
+				defer func() {
+					if err == nil {
+						_ = server.Close()
+					}
+					if res.err == nil {
+						_ = res.c.Close()
+					}
+				}()
+
+				if tt.wantErr {
+					if err != nil {
+						// Error expected, test succeeded
+						return
+					}
+					t.Error("Error expected")
+				}
+				if err != nil {
+					t.Errorf("Server failed(%v)", err)
+				}
+
+				if res.err != nil {
+					t.Errorf("Client failed(%v)", res.err)
+				}
+
+				actualClientCert := server.ConnectionState().PeerCertificates
+				if tt.serverCfg.ClientAuth == RequireAnyClientCert || tt.serverCfg.ClientAuth == RequireAndVerifyClientCert {
+					if actualClientCert == nil {
+						t.Errorf("Client did not provide a certificate")
+					}
+
+					var cfgCert [][]byte
+					if len(tt.clientCfg.Certificates) > 0 {
+						cfgCert = tt.clientCfg.Certificates[0].Certificate
+					}
+					if tt.clientCfg.GetClientCertificate != nil {
+						crt, err := tt.clientCfg.GetClientCertificate(&CertificateRequestInfo{})
+						if err != nil {
+							t.Errorf("Server configuration did not provide a certificate")
+						}
+						cfgCert = crt.Certificate
+					}
+					if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualClientCert[0]) {
+						t.Errorf("Client certificate was not communicated correctly")
+					}
+				}
+				if tt.serverCfg.ClientAuth == NoClientCert {
+					if actualClientCert != nil {
+						t.Errorf("Client certificate wasn't expected")
+					}
+				}
+
+				actualServerCert := res.c.ConnectionState().PeerCertificates
+				if actualServerCert == nil {
+					t.Errorf("Server did not provide a certificate")
+				}
+				var cfgCert [][]byte
+				if len(tt.serverCfg.Certificates) > 0 {
+					cfgCert = tt.serverCfg.Certificates[0].Certificate
+				}
+				if tt.serverCfg.GetCertificate != nil {
+					crt, err := tt.serverCfg.GetCertificate(&ClientHelloInfo{})
+					if err != nil {
+						t.Errorf("Server configuration did not provide a certificate")
+					}
+					cfgCert = crt.Certificate
+				}
+				if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualServerCert[0]) {
+					t.Errorf("Server certificate was not communicated correctly")
+				}
+			})
+		}
+	})
+}
+
+func TestConnectionID(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	clientCID := []byte{5, 77, 33, 24, 93, 27, 45, 81}
+	serverCID := []byte{64, 24, 73, 2, 17, 96, 38, 59}
+	cidEcho := func(echo []byte) func() []byte {
+		return func() []byte {
+			return echo
+		}
+	}
+	tests := map[string]struct {
+		clientCfg          *Config
+		serverCfg          *Config
+		clientConnectionID []byte
+		serverConnectionID []byte
+	}{
+		"BidirectionalConnectionIDs": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+			clientConnectionID: clientCID,
+			serverConnectionID: serverCID,
+		},
+		"BothSupportOnlyClientSends": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(nil),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+			serverConnectionID: serverCID,
+		},
+		"BothSupportOnlyServerSends": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(nil),
+			},
+			clientConnectionID: clientCID,
+		},
+		"ClientDoesNotSupport": {
+			clientCfg: &Config{},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+		},
+		"ServerDoesNotSupport": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{},
+		},
+		"NeitherSupport": {
+			clientCfg: &Config{},
+			serverCfg: &Config{},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
+			if err != nil {
+				t.Fatalf("Unexpected server error: %v", err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatalf("Unexpected client error: %v", res.err)
+			}
+			defer func() {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+			}()
+
+			if !bytes.Equal(res.c.state.localConnectionID, tt.clientConnectionID) {
+				t.Errorf("Unexpected client local connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, res.c.state.localConnectionID)
+			}
+			if !bytes.Equal(res.c.state.remoteConnectionID, tt.serverConnectionID) {
+				t.Errorf("Unexpected client remote connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, res.c.state.remoteConnectionID)
+			}
+			if !bytes.Equal(server.state.localConnectionID, tt.serverConnectionID) {
+				t.Errorf("Unexpected server local connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, server.state.localConnectionID)
+			}
+			if !bytes.Equal(server.state.remoteConnectionID, tt.clientConnectionID) {
+				t.Errorf("Unexpected server remote connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, server.state.remoteConnectionID)
+			}
+		})
+	}
+}
+
+type rawHeartbeat []byte
+
+func (rawHeartbeat) ContentType() protocol.ContentType { return protocol.ContentTypeHeartbeat }
+func (r rawHeartbeat) Marshal() ([]byte, error)        { return r, nil }
+func (rawHeartbeat) Unmarshal([]byte) error            { return nil }
+
+func TestHeartbeat(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	handshake := func(t *testing.T, clientMode, serverMode HeartbeatMode) (*Conn, *Conn) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{HeartbeatMode: clientMode}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{HeartbeatMode: serverMode}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.c, server
+	}
+
+	t.Run("PeerAllowedToSend", func(t *testing.T) {
+		client, server := handshake(t, HeartbeatModePeerAllowedToSend, HeartbeatModePeerAllowedToSend)
+		defer func() {
+			_ = server.Close()
+			_ = client.Close()
+		}()
+
+		if err := client.Heartbeat([]byte("client ping")); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Heartbeat([]byte("server ping")); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.Heartbeat(make([]byte, maxHeartbeatPayloadLength+1)); !errors.Is(err, errHeartbeatPayloadTooLarge) {
+			t.Fatalf("Error mismatch: expected(%v) actual(%v)", errHeartbeatPayloadTooLarge, err)
+		}
+
+		// A request claiming more payload than the record carries must be
+		// discarded instead of echoing memory beyond the record
+		bogus := append([]byte{byte(protocol.HeartbeatMessageTypeRequest), 0x40, 0x00}, make([]byte, protocol.HeartbeatMinPaddingLength)...)
+		if err := client.writePackets(context.Background(), []*packet{
+			{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Epoch:   client.state.getLocalEpoch(),
+						Version: protocol.Version1_2,
+					},
+					Content: rawHeartbeat(bogus),
+				},
+				shouldEncrypt: true,
+			},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case r := <-client.heartbeatResponse:
+			t.Fatalf("Unexpected heartbeat response of %d bytes", len(r))
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		// The connection is still usable
+		if err := client.Heartbeat([]byte("after bogus")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("PeerNotAllowedToSend", func(t *testing.T) {
+		client, server := handshake(t, HeartbeatModePeerAllowedToSend, HeartbeatModePeerNotAllowedToSend)
+		defer func() {
+			_ = server.Close()
+			_ = client.Close()
+		}()
+
+		if err := client.Heartbeat([]byte("ping")); !errors.Is(err, errHeartbeatNotAllowed) {
+			t.Fatalf("Error mismatch: expected(%v) actual(%v)", errHeartbeatNotAllowed, err)
+		}
+		if err := server.Heartbeat([]byte("ping")); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("NotNegotiated", func(t *testing.T) {
+		client, server := handshake(t, HeartbeatModePeerAllowedToSend, 0)
+		defer func() {
+			_ = server.Close()
+			_ = client.Close()
+		}()
+
+		if client.state.remoteHeartbeatMode != 0 || server.state.localHeartbeatMode != 0 {
+			t.Fatal("Heartbeat must not be negotiated if the server does not enable it")
+		}
+		if err := client.Heartbeat([]byte("ping")); !errors.Is(err, errHeartbeatNotAllowed) {
+			t.Fatalf("Error mismatch: expected(%v) actual(%v)", errHeartbeatNotAllowed, err)
+		}
+	})
+}
+
+func TestRecordSizeLimit(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const recordSizeLimit = 256
+
+	for name, cidGenerator := range map[string]func() []byte{
+		"NoConnectionID": nil,
+		"ConnectionID":   RandomCIDGenerator(8),
+	} {
+		cidGenerator := cidGenerator
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					RecordSizeLimit:       recordSizeLimit,
+					ConnectionIDGenerator: cidGenerator,
+					MTU:                   8192,
+				}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				ConnectionIDGenerator: cidGenerator,
+			}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
+			defer func() {
+				_ = server.Close()
+				_ = client.Close()
+			}()
+
+			if server.state.remoteRecordSizeLimit != recordSizeLimit {
+				t.Fatalf("Server record size limit mismatch: expected(%d) actual(%d)", recordSizeLimit, server.state.remoteRecordSizeLimit)
+			}
+			if client.state.remoteRecordSizeLimit != maxRecordSizeLimit {
+				t.Fatalf("Client record size limit mismatch: expected(%d) actual(%d)", maxRecordSizeLimit, client.state.remoteRecordSizeLimit)
+			}
+
+			// Large writes are split into multiple records
+			data := make([]byte, 1000)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			if n, err := server.Write(data); err != nil {
+				t.Fatal(err)
+			} else if n != len(data) {
+				t.Fatalf("Write length mismatch: expected(%d) actual(%d)", len(data), n)
+			}
+
+			var received []byte
+			buf := make([]byte, 2000)
+			for _, expected := range []int{256, 256, 256, 232} {
+				n, err := client.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n != expected {
+					t.Fatalf("Record size mismatch: expected(%d) actual(%d)", expected, n)
+				}
+				received = append(received, buf[:n]...)
+			}
+			if !bytes.Equal(data, received) {
+				t.Fatal("Received data does not match written data")
+			}
+
+			// Ignore the limit on the server to send an oversized record
+			server.state.remoteRecordSizeLimit = 0
+			if _, err := server.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Read(buf); !errors.Is(err, errRecordSizeLimitExceeded) {
+				t.Fatalf("Client error mismatch: expected(%v) actual(%v)", errRecordSizeLimitExceeded, err)
+			}
+			// The record_overflow alert is fatal and closes the connection
+			if _, err := server.Read(buf); !errors.Is(err, io.EOF) {
+				t.Fatalf("Server error mismatch: expected(%v) actual(%v)", io.EOF, err)
+			}
+		})
+	}
+}
+
+func TestWriteRecords(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			RecordSizeLimit: 256,
+			MTU:             8192,
+		}, true)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	client := res.c
+	defer func() {
+		_ = server.Close()
+		_ = client.Close()
+	}()
+
+	buf := make([]byte, 2000)
+	for _, size := range []int{10, 256, 1000} {
+		n, records, err := server.WriteRecords(make([]byte, size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != size {
+			t.Fatalf("Write length mismatch: expected(%d) actual(%d)", size, n)
+		}
+		if expected := (size + 255) / 256; records != expected {
+			t.Fatalf("Record count mismatch for %d bytes: expected(%d) actual(%d)", size, expected, records)
+		}
+		for i := 0; i < records; i++ {
+			if _, err := client.Read(buf); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMaxHandshakeBufferSize(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			MaxHandshakeBufferSize: 16 * 1024,
+		}, true)
+		serverErr <- err
+	}()
+
+	// Flood the server with fragments of a huge ClientHello, the first
+	// fragment never arrives so none of them can be reassembled
+	const fragmentLength = 1000
+	for i := 0; i < 64; i++ {
+		hsHeader := &handshake.Header{
+			Type:           handshake.TypeClientHello,
+			Length:         1 << 20,
+			FragmentOffset: uint32(fragmentLength * (i + 1)),
+			FragmentLength: fragmentLength,
+		}
+		rawHandshake, err := hsHeader.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rawHandshake = append(rawHandshake, make([]byte, fragmentLength)...)
+
+		rlHeader := &recordlayer.Header{
+			ContentType:    protocol.ContentTypeHandshake,
+			ContentLen:     uint16(len(rawHandshake)),
+			Version:        protocol.Version1_2,
+			SequenceNumber: uint64(i),
+		}
+		raw, err := rlHeader.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ca.Write(append(raw, rawHandshake...)); err != nil {
+			break
+		}
+
+		select {
+		case err := <-serverErr:
+			if !errors.Is(err, errFragmentBufferOverflow) {
+				t.Fatalf("Expected server error %v, got %v", errFragmentBufferOverflow, err)
+			}
+			_ = ca.Close()
+			return
+		default:
+		}
+	}
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, errFragmentBufferOverflow) {
+			t.Fatalf("Expected server error %v, got %v", errFragmentBufferOverflow, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server buffered the fragments instead of aborting")
+	}
+	_ = ca.Close()
+}
+
+func TestMaxFragmentLength(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// An RSA certificate does not fit in a single 512 byte record
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := selfsign.SelfSign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clientRecordSizeLimit uint16
+		expectedLimit         int
+	}{
+		"Negotiated": {
+			expectedLimit: 512,
+		},
+		// record_size_limit takes precedence over max_fragment_length
+		"RecordSizeLimitOffered": {
+			clientRecordSizeLimit: 1024,
+			expectedLimit:         1024,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+
+			var (
+				recordsLock   sync.Mutex
+				largestRecord int
+			)
+			serverConn := &connWithCallback{Conn: cb, onWrite: func(b []byte) {
+				records, err := recordlayer.UnpackDatagram(b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				recordsLock.Lock()
+				defer recordsLock.Unlock()
+				for _, r := range records {
+					h := &recordlayer.Header{}
+					if err := h.Unmarshal(r); err != nil {
+						t.Error(err)
+						return
+					}
+					plaintext := int(h.ContentLen)
+					if h.Epoch != 0 {
+						plaintext -= 8 + 16 // AES-GCM explicit nonce and tag
+					}
+					if plaintext > largestRecord {
+						largestRecord = plaintext
+					}
+				}
+			}}
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:      []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+					MaxFragmentLength: FragmentLength512,
+					RecordSizeLimit:   tt.clientRecordSizeLimit,
+				}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(serverConn), cb.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{serverCert},
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
+			defer func() {
+				_ = server.Close()
+				_ = client.Close()
+			}()
+
+			data := make([]byte, 3000)
+			if _, err := server.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4000)
+			for read := 0; read < len(data); {
+				n, err := client.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n > tt.expectedLimit {
+					t.Fatalf("Record exceeds limit: expected(%d) actual(%d)", tt.expectedLimit, n)
+				}
+				read += n
+			}
+
+			recordsLock.Lock()
+			defer recordsLock.Unlock()
+			if largestRecord > tt.expectedLimit {
+				t.Fatalf("Server sent a record of %d bytes, limit is %d", largestRecord, tt.expectedLimit)
+			}
+			if tt.clientRecordSizeLimit == 0 && client.state.maxFragmentLength != FragmentLength512 {
+				t.Fatalf("Client did not negotiate max_fragment_length: %d", client.state.maxFragmentLength)
+			}
+		})
+	}
+}
+
+func TestExtendedMasterSecret(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	tests := map[string]struct {
+		clientCfg         *Config
+		serverCfg         *Config
+		expectedClientErr error
+		expectedServerErr error
+	}{
+		"Request_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Request_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Request_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: errClientRequiredButNoServerEMS,
+			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+		},
+		"Disable_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Disable_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			expectedServerErr: errServerRequiredButNoClientEMS,
+		},
+		"Disable_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
+			res := <-c
+			defer func() {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+			}()
+
+			if !errors.Is(res.err, tt.expectedClientErr) {
+				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, res.err)
+			}
+
+			if !errors.Is(err, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, err)
+			}
+		})
+	}
+}
+
+func TestGetClientCertificate(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	var certs []tls.Certificate
+	var parsed []*x509.Certificate
+	for _, cn := range []string{"untrusted", "trusted"} {
+		cert, err := selfsign.GenerateSelfSignedWithDNS(cn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, cert)
+		parsed = append(parsed, x509Cert)
+	}
+	// The server only trusts the second certificate
+	caPool := x509.NewCertPool()
+	caPool.AddCert(parsed[1])
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	var info *CertificateRequestInfo
+	go func() {
+		client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			GetClientCertificate: func(cri *CertificateRequestInfo) (*tls.Certificate, error) {
+				info = cri
+				for i := range certs {
+					if err := cri.SupportsCertificate(&certs[i]); err == nil {
+						return &certs[i], nil
+					}
+				}
+				return &tls.Certificate{}, nil
+			},
+		}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+		ClientAuth:       RequireAndVerifyClientCert,
+		ClientCAs:        caPool,
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PKCS1WithSHA256},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+	}()
+
+	if len(info.AcceptableCAs) != 1 || !bytes.Equal(info.AcceptableCAs[0], parsed[1].RawSubject) {
+		t.Errorf("Expected the subject of the trusted certificate as acceptable CA, got %x", info.AcceptableCAs)
+	}
+	expectedSchemes := []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.PKCS1WithSHA256}
+	if !reflect.DeepEqual(info.SignatureSchemes, expectedSchemes) {
+		t.Errorf("Expected signature schemes %v, got %v", expectedSchemes, info.SignatureSchemes)
+	}
+	state := server.ConnectionState()
+	if len(state.PeerCertificates) != 1 || !bytes.Equal(state.PeerCertificates[0], certs[1].Certificate[0]) {
+		t.Error("Expected the client to present the trusted certificate")
+	}
+}
+
+func TestServerCertificate(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
+		tests := map[string]struct {
+			clientCfg *Config
+			serverCfg *Config
+			wantErr   bool
+		}{
+			"no_ca": {
+				clientCfg: &Config{},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+			"good_ca": {
+				clientCfg: &Config{RootCAs: caPool},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"no_ca_skip_verify": {
+				clientCfg: &Config{InsecureSkipVerify: true},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"good_ca_skip_verify_custom_verify_peer": {
+				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: RequireAnyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
+					if len(chain) != 0 {
+						return errNotExpectedChain
+					}
+					return nil
+				}},
+			},
+			"good_ca_verify_custom_verify_peer": {
+				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{ClientCAs: caPool, Certificates: []tls.Certificate{cert}, ClientAuth: RequireAndVerifyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
+					if len(chain) == 0 {
+						return errExpecedChain
+					}
+					return nil
+				}},
+			},
+			"good_ca_custom_verify_peer": {
+				clientCfg: &Config{
+					RootCAs: caPool,
+					VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+						return errWrongCert
+					},
+				},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+			"server_name": {
+				clientCfg: &Config{RootCAs: caPool, ServerName: certificate.Subject.CommonName},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"server_name_error": {
+				clientCfg: &Config{RootCAs: caPool, ServerName: "barfoo"},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+
+				type result struct {
+					c   *Conn
+					err error
+				}
+				srvCh := make(chan result)
+				go func() {
+					s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+					srvCh <- result{s, err}
+				}()
+
+				cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+				if err == nil {
+					_ = cli.Close()
+				}
+				if !tt.wantErr && err != nil {
+					t.Errorf("Client failed(%v)", err)
+				}
+				if tt.wantErr && err == nil {
+					t.Fatal("Error expected")
+				}
+
+				srv := <-srvCh
+				if srv.err == nil {
+					_ = srv.c.Close()
+				}
+			})
+		}
+	})
+}
+
+// generateCertificateChain returns a root CA, an intermediate CA issued by
+// the root and a leaf for serverName issued by the intermediate
+func generateCertificateChain(serverName string) (root, intermediate *x509.Certificate, leaf tls.Certificate, err error) {
+	issue := func(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+		key, err := ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		if parent == nil {
+			parent, parentKey = template, key
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		cert, err := x509.ParseCertificate(raw)
+		return cert, key, err
+	}
+	ca := func(serial int64, name string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			KeyUsage:              x509.KeyUsageCertSign,
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+	}
+
+	root, rootKey, err := issue(ca(1, "root"), nil, nil)
+	if err != nil {
+		return nil, nil, tls.Certificate{}, err
+	}
+	intermediate, intermediateKey, err := issue(ca(2, "intermediate"), root, rootKey)
+	if err != nil {
+		return nil, nil, tls.Certificate{}, err
+	}
+	leafCert, leafKey, err := issue(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}, intermediate, intermediateKey)
+	if err != nil {
+		return nil, nil, tls.Certificate{}, err
+	}
+	return root, intermediate, tls.Certificate{Certificate: [][]byte{leafCert.Raw}, PrivateKey: leafKey, Leaf: leafCert}, nil
+}
+
+func TestIntermediates(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	root, intermediate, leaf, err := generateCertificateChain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+
+	for name, tt := range map[string]struct {
+		clientIntermediates *x509.CertPool
+		serverIntermediates *x509.CertPool
+		wantErr             bool
+	}{
+		"NoIntermediates": {
+			wantErr: true,
+		},
+		"ClientIntermediates": {
+			clientIntermediates: intermediates,
+		},
+		"ServerSendsIntermediates": {
+			serverIntermediates: intermediates,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			srvCh := make(chan result)
+			go func() {
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates:  []tls.Certificate{leaf},
+					Intermediates: tt.serverIntermediates,
+				})
+				srvCh <- result{s, err}
+			}()
+
+			cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				RootCAs:       roots,
+				Intermediates: tt.clientIntermediates,
+				ServerName:    "example.com",
+			})
+			var verifyErr *CertificateVerificationError
+			switch {
+			case tt.wantErr && !errors.As(err, &verifyErr):
+				t.Errorf("Expected a certificate verification error, got '%v'", err)
+			case !tt.wantErr && err != nil:
+				t.Errorf("Client failed: %v", err)
+			}
+			if err == nil {
+				_ = cli.Close()
+			}
+
+			srv := <-srvCh
+			if srv.err == nil {
+				_ = srv.c.Close()
+			}
+		})
+	}
+}
+
+func TestSystemRootsLoadedLazily(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	root, intermediate, leaf, err := generateCertificateChain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+
+	// The system pool of the test holds nothing but the root
+	var loads int32
+	previous := systemRoots
+	systemRoots = &lazyCertPool{load: func() (*x509.CertPool, error) {
+		atomic.AddInt32(&loads, 1)
+		pool := x509.NewCertPool()
+		pool.AddCert(root)
+		return pool, nil
+	}}
+	defer func() {
+		systemRoots = previous
+	}()
+
+	handshake := func(clientConfig *Config) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		srvCh := make(chan error, 1)
+		go func() {
+			s, err := ServerWithContext(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				Certificates:  []tls.Certificate{leaf},
+				Intermediates: intermediates,
+			})
+			if err == nil {
+				_ = s.Close()
+			}
+			srvCh <- err
+		}()
+
+		cli, err := ClientWithContext(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), clientConfig)
+		if err == nil {
+			_ = cli.Close()
+		}
+		<-srvCh
+		return err
+	}
+
+	// Nothing is loaded unless a certificate is verified without RootCAs
+	if err := handshake(&Config{InsecureSkipVerify: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := handshake(&Config{RootCAs: x509.NewCertPool(), ServerName: "example.com"}); err == nil {
+		t.Fatal("Expected the handshake to fail with empty RootCAs")
+	}
+	if n := atomic.LoadInt32(&loads); n != 0 {
+		t.Fatalf("System roots were loaded %d times before they were needed", n)
+	}
+
+	// Concurrent verifications load the system roots once
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- handshake(&Config{ServerName: "example.com"})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Handshake verifying with the system roots failed: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("Expected the system roots to be loaded once, got %d", n)
+	}
+}
+
+func TestTrustedCAKeys(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// Two chains for the same name, issued by different roots. Without
+	// trusted_ca_keys the server picks the last one by name.
+	var roots []*x509.Certificate
+	var chains []tls.Certificate
+	for i := 0; i < 2; i++ {
+		root, intermediate, leaf, err := generateCertificateChain("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf.Certificate = append(leaf.Certificate, intermediate.Raw)
+		roots = append(roots, root)
+		chains = append(chains, leaf)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(roots[0])
+
+	for name, tt := range map[string]struct {
+		trustedCAKeys []*x509.Certificate
+		wantErr       bool
+	}{
+		"DefaultChain": {
+			wantErr: true,
+		},
+		"TrustedChain": {
+			trustedCAKeys: roots[:1],
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			srvCh := make(chan result)
+			go func() {
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates: chains,
+				})
+				srvCh <- result{s, err}
+			}()
+
+			cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				RootCAs:       rootCAs,
+				TrustedCAKeys: tt.trustedCAKeys,
+				ServerName:    "example.com",
+			})
+			var verifyErr *CertificateVerificationError
+			switch {
+			case tt.wantErr && !errors.As(err, &verifyErr):
+				t.Errorf("Expected a certificate verification error, got '%v'", err)
+			case !tt.wantErr && err != nil:
+				t.Errorf("Client failed: %v", err)
+			}
+			if err == nil {
+				if !bytes.Equal(cli.ConnectionState().PeerCertificates[1], chains[0].Certificate[1]) {
+					t.Error("Server did not send the chain of the trusted authority")
+				}
+				_ = cli.Close()
+			}
+
+			srv := <-srvCh
+			if srv.err == nil {
+				_ = srv.c.Close()
+			}
+		})
+	}
+}
+
+func TestCertificateVerificationError(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	for name, tt := range map[string]struct {
+		clientCfg   *Config
+		serverCfg   *Config
+		checkClient bool
+		check       func(error) bool
+	}{
+		"ServerUnknownAuthority": {
+			clientCfg:   &Config{},
+			serverCfg:   &Config{Certificates: []tls.Certificate{cert}},
+			checkClient: true,
+			check: func(err error) bool {
+				var e x509.UnknownAuthorityError
+				return errors.As(err, &e) && e.Cert != nil && e.Cert.Equal(certificate)
+			},
+		},
+		"ServerHostnameMismatch": {
+			clientCfg:   &Config{RootCAs: caPool, ServerName: "barfoo"},
+			serverCfg:   &Config{Certificates: []tls.Certificate{cert}},
+			checkClient: true,
+			check: func(err error) bool {
+				var e x509.HostnameError
+				return errors.As(err, &e) && e.Host == "barfoo"
+			},
+		},
+		"ClientUnknownAuthority": {
+			clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
+			serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: RequireAndVerifyClientCert},
+			check: func(err error) bool {
+				var e x509.UnknownAuthorityError
+				return errors.As(err, &e)
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			srvCh := make(chan result)
+			go func() {
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+				srvCh <- result{s, err}
+			}()
+
+			cli, cliErr := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+			if cliErr == nil {
+				_ = cli.Close()
+			}
+			srv := <-srvCh
+			if srv.err == nil {
+				_ = srv.c.Close()
+			}
+
+			err := srv.err
+			if tt.checkClient {
+				err = cliErr
+			}
+			var verifyErr *CertificateVerificationError
+			if !errors.As(err, &verifyErr) {
+				t.Fatalf("Expected CertificateVerificationError, got %v", err)
+			}
+			if len(verifyErr.UnverifiedCertificates) != 1 || !verifyErr.UnverifiedCertificates[0].Equal(certificate) {
+				t.Error("Unexpected UnverifiedCertificates")
+			}
+			if !tt.check(err) {
+				t.Errorf("Underlying x509 error not reachable: %v", err)
+			}
+		})
+	}
+}
+
+func TestCipherSuiteConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                     string
+		ClientCipherSuites       []CipherSuiteID
+		ServerCipherSuites       []CipherSuiteID
+		PreferServerCipherSuites bool
+		WantClientError          error
+		WantServerError          error
+		WantSelectedCipherSuite  CipherSuiteID
+	}{
+		{
+			Name:               "No CipherSuites specified",
+			ClientCipherSuites: nil,
+			ServerCipherSuites: nil,
+			WantClientError:    nil,
+			WantServerError:    nil,
+		},
+		{
+			Name:               "Invalid CipherSuite",
+			ClientCipherSuites: []CipherSuiteID{0x00},
+			ServerCipherSuites: []CipherSuiteID{0x00},
+			WantClientError:    &invalidCipherSuiteError{0x00},
+			WantServerError:    &invalidCipherSuiteError{0x00},
+		},
+		{
+			Name:                    "Valid CipherSuites specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:               "CipherSuites mismatch",
+			ClientCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			WantClientError:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			WantServerError:    errCipherSuiteNoIntersection,
+		},
+		{
+			Name:                    "Valid CipherSuites CCM specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+		},
+		{
+			Name:                    "Valid CipherSuites CCM-8 specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+		},
+		{
+			Name:                    "Valid CipherSuites ChaCha20-Poly1305 specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		{
+			Name:                    "Valid CipherSuites AES-256-GCM specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		},
+		{
+			Name:                    "Server supports subset of client suites",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		},
+		{
+			Name:                    "Client preference",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:                     "Server preference",
+			ClientCipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			ServerCipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			PreferServerCipherSuites: true,
+			WantSelectedCipherSuite:  TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.ClientCipherSuites}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites:             test.ServerCipherSuites,
+				PreferServerCipherSuites: test.PreferServerCipherSuites,
+			}, true)
+			if err == nil {
+				defer func() {
+					_ = server.Close()
+				}()
+			}
+			if !errors.Is(err, test.WantServerError) {
+				t.Errorf("TestCipherSuiteConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			}
+
+			res := <-c
+			if res.err == nil {
+				_ = server.Close()
+				_ = res.c.Close()
+			}
+			if !errors.Is(res.err, test.WantClientError) {
+				t.Errorf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			}
+			if test.WantSelectedCipherSuite != 0x00 && res.c.state.cipherSuite.ID() != test.WantSelectedCipherSuite {
+				t.Errorf("TestCipherSuiteConfiguration: Server Selected Bad Cipher Suite '%s': expected(%v) actual(%v)", test.Name, test.WantSelectedCipherSuite, res.c.state.cipherSuite.ID())
+			}
+		})
+	}
+}
+
+func TestReadRecord(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+
+	records := [][]byte{
+		[]byte("first"),
+		bytes.Repeat([]byte{0x02}, 1000),
+		[]byte("third record"),
+		[]byte("read"),
+	}
+	writeErr := make(chan error, 1)
+	go func() {
+		for _, record := range records {
+			if _, err := ca.Write(record); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+		writeErr <- nil
+	}()
+
+	for i, expected := range records[:3] {
+		actual, err := cb.ReadRecord()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("Record %d mismatch: expected(%d bytes) actual(%d bytes)", i, len(expected), len(actual))
+		}
+	}
+
+	// Read continues with the next record
+	buf := make([]byte, 100)
+	n, err := cb.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], records[3]) {
+		t.Errorf("Read mismatch: expected(%s) actual(%s)", records[3], buf[:n])
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// datagramSizeConn records the size of every datagram written to it
+type datagramSizeConn struct {
+	net.PacketConn
+
+	mu    sync.Mutex
+	sizes []int
+}
+
+func (c *datagramSizeConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.sizes = append(c.sizes, len(p))
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestMTU(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// serverDatagrams runs a handshake and returns the sizes of the datagrams the server sent
+	serverDatagrams := func(t *testing.T, mtu int) []int {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
+			c <- result{client, err}
+		}()
+
+		serverConn := &datagramSizeConn{PacketConn: dtlsnet.PacketConnFromConn(cb)}
+		server, err := testServer(ctx, serverConn, cb.RemoteAddr(), &Config{MTU: mtu}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.c.Close()
+		_ = server.Close()
+
+		serverConn.mu.Lock()
+		defer serverConn.mu.Unlock()
+		return append([]int{}, serverConn.sizes...)
+	}
+
+	const smallMTU = 200
+	defaultSizes := serverDatagrams(t, 0)
+	smallSizes := serverDatagrams(t, smallMTU)
+	if len(smallSizes) <= len(defaultSizes) {
+		t.Errorf("Expected more than %d datagrams with a %d byte MTU, got %d", len(defaultSizes), smallMTU, len(smallSizes))
+	}
+	for _, size := range smallSizes {
+		if size > smallMTU {
+			t.Errorf("Datagram of %d bytes exceeds the %d byte MTU", size, smallMTU)
+		}
+	}
+
+	t.Run("SetMTU", func(t *testing.T) {
+		ca, cb, err := pipeMemory()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = ca.Close()
+			_ = cb.Close()
+		}()
+
+		if err := ca.SetMTU(minMTU - 1); !errors.Is(err, errInvalidMTU) {
+			t.Fatalf("Expected error '%v', got '%v'", errInvalidMTU, err)
+		}
+
+		msg := &handshake.Handshake{Message: &handshake.MessageCertificate{
+			Certificate: [][]byte{bytes.Repeat([]byte{0x01}, 1000)},
+		}}
+		overhead := recordlayer.FixedHeaderSize + handshake.HeaderLength
+		fragments, err := ca.fragmentHandshake(msg, overhead)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fragments) != 1 {
+			t.Fatalf("Expected one fragment with the default MTU, got %d", len(fragments))
+		}
+
+		if err := ca.SetMTU(100); err != nil {
+			t.Fatal(err)
+		}
+		if fragments, err = ca.fragmentHandshake(msg, overhead); err != nil {
+			t.Fatal(err)
+		}
+		if len(fragments) <= 1 {
+			t.Fatalf("Expected several fragments after lowering the MTU, got %d", len(fragments))
+		}
+		for _, fragment := range fragments {
+			if len(fragment)+recordlayer.FixedHeaderSize > 100 {
+				t.Errorf("Fragment of %d bytes does not fit the MTU", len(fragment))
+			}
+		}
+	})
+}
+
+// testBadFinishedCipherSuite corrupts the verify data of the Finished it
+// sends
+type testBadFinishedCipherSuite struct {
+	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
+}
+
+func (c *testBadFinishedCipherSuite) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
+	if h, ok := pkt.Content.(*handshake.Handshake); ok && h.Message.Type() == handshake.TypeFinished {
+		raw = append([]byte{}, raw...)
+		raw[len(raw)-1] ^= 0xff
+	}
+	return c.TLSEcdheEcdsaWithAes128GcmSha256.Encrypt(pkt, raw)
+}
+
+func TestFalseStart(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// runTest holds back the server's Finished until release is called
+	runTest := func(t *testing.T, serverCipherSuites func() []CipherSuite) (client, server *Conn, release func()) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var held int32 = 1
+		ca, cb := testutil.Pipe()
+		cb.SetFilter(func(d testutil.Datagram) testutil.Action {
+			if atomic.LoadInt32(&held) == 0 {
+				return testutil.Deliver
+			}
+			pkts, err := recordlayer.UnpackDatagram(d.Data)
+			if err != nil {
+				return testutil.Deliver
+			}
+			for _, pkt := range pkts {
+				var h recordlayer.Header
+				if h.Unmarshal(pkt) == nil && h.ContentType == protocol.ContentTypeHandshake && h.Epoch == 1 {
+					return testutil.Drop
+				}
+			}
+			return testutil.Deliver
+		})
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+		go func() {
+			client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				FlightInterval:   100 * time.Millisecond,
+				EnableFalseStart: true,
+			}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{
+			CustomCipherSuites: serverCipherSuites,
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			_ = server.Close()
+			t.Fatal(res.err)
+		}
+		if res.c.isHandshakeCompletedSuccessfully() {
+			t.Error("Expected the client to return before the server's Finished")
+		}
+		return res.c, server, func() { atomic.StoreInt32(&held, 0) }
+	}
+
+	t.Run("EarlyData", func(t *testing.T) {
+		client, server, release := runTest(t, nil)
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		// Application data is sent before the server's Finished arrived
+		if _, err := client.Write([]byte("early")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "early" {
+			t.Fatalf("Expected early data, got %q", buf[:n])
+		}
+
+		// The reply is held back until the server's Finished is verified
+		if _, err = server.Write([]byte("reply")); err != nil {
+			t.Fatal(err)
+		}
+		type readResult struct {
+			data string
+			err  error
+		}
+		read := make(chan readResult, 1)
+		go func() {
+			n, err := client.Read(buf)
+			read <- readResult{string(buf[:n]), err}
+		}()
+		select {
+		case res := <-read:
+			t.Fatalf("Read returned before the server's Finished: %q, %v", res.data, res.err)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		release()
+		if res := <-read; res.err != nil {
+			t.Fatal(res.err)
+		} else if res.data != "reply" {
+			t.Fatalf("Expected reply, got %q", res.data)
+		}
+		if !client.isHandshakeCompletedSuccessfully() {
+			t.Fatal("Expected the handshake to be completed")
+		}
+	})
+
+	t.Run("BadServerFinished", func(t *testing.T) {
+		client, server, release := runTest(t, func() []CipherSuite {
+			return []CipherSuite{&testBadFinishedCipherSuite{}}
+		})
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		if _, err := client.Write([]byte("early")); err != nil {
+			t.Fatal(err)
+		}
+
+		release()
+		buf := make([]byte, 64)
+		if _, err := client.Read(buf); !errors.Is(err, errVerifyDataMismatch) {
+			t.Fatalf("Expected error '%v', got '%v'", errVerifyDataMismatch, err)
+		}
+		if _, err := client.Write([]byte("late")); !errors.Is(err, ErrConnClosed) {
+			t.Fatalf("Expected error '%v', got '%v'", ErrConnClosed, err)
+		}
+	})
+
+	t.Run("ReadDeadline", func(t *testing.T) {
+		client, server, release := runTest(t, nil)
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		// A read deadline passing before the server's Finished only fails
+		// the Read, the handshake carries on
+		if err := client.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		if _, err := client.Read(buf); !errors.Is(err, errDeadlineExceeded) {
+			t.Fatalf("Expected error '%v', got '%v'", errDeadlineExceeded, err)
+		}
+		time.Sleep(250 * time.Millisecond)
+
+		if _, err := server.Write([]byte("reply")); err != nil {
+			t.Fatal(err)
+		}
+		release()
+		if err := client.SetReadDeadline(time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "reply" {
+			t.Fatalf("Expected reply, got %q", buf[:n])
+		}
+		if !client.isHandshakeCompletedSuccessfully() {
+			t.Fatal("Expected the handshake to be completed")
+		}
+	})
+}
+
+func TestHandshakeLostFirstFlight(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := testutil.Pipe()
+	var dropped int32
+	ca.SetFilter(func(d testutil.Datagram) testutil.Action {
+		// The first ClientHello never arrives
+		if d.Index == 0 {
+			atomic.AddInt32(&dropped, 1)
+			return testutil.Drop
+		}
+		return testutil.Deliver
+	})
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	clientStats := make(chan HandshakeStats, 1)
+	go func() {
+		client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+			FlightInterval: 100 * time.Millisecond,
+			OnHandshakeComplete: func(stats HandshakeStats) {
+				clientStats <- stats
+			},
+		}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{FlightInterval: 100 * time.Millisecond}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+		_ = server.Close()
+	}()
+
+	if atomic.LoadInt32(&dropped) != 1 {
+		t.Fatal("The first flight was not dropped")
+	}
+	if stats := <-clientStats; stats.Retransmits == 0 {
+		t.Errorf("Expected the client to retransmit its first flight, stats %+v", stats)
+	}
+}
+
+func TestFragmentedCookieClientHello(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// countHandshakes counts the epoch 0 handshake records of type typ and
+	// message sequence seq in a datagram
+	countHandshakes := func(d testutil.Datagram, typ handshake.Type, seq uint16) int32 {
+		pkts, err := recordlayer.UnpackDatagram(d.Data)
+		if err != nil {
+			return 0
+		}
+		var n int32
+		for _, pkt := range pkts {
+			var h recordlayer.Header
+			if h.Unmarshal(pkt) != nil || h.ContentType != protocol.ContentTypeHandshake || h.Epoch != 0 {
+				continue
+			}
+			var hh handshake.Header
+			if hh.Unmarshal(pkt[recordlayer.FixedHeaderSize:]) == nil && hh.Type == typ && hh.MessageSequence == seq {
+				n++
+			}
+		}
+		return n
+	}
+
+	var cookieFragments, helloVerifyRequests int32
+	ca, cb := testutil.Pipe()
+	ca.SetFilter(func(d testutil.Datagram) testutil.Action {
+		atomic.AddInt32(&cookieFragments, countHandshakes(d, handshake.TypeClientHello, 1))
+		return testutil.Deliver
+	})
+	cb.SetFilter(func(d testutil.Datagram) testutil.Action {
+		atomic.AddInt32(&helloVerifyRequests, countHandshakes(d, handshake.TypeHelloVerifyRequest, 0))
+		return testutil.Deliver
+	})
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		// The MTU splits the ClientHello carrying the cookie into three records
+		client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+			MTU:          80,
+			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+	_ = server.Close()
+
+	if n := atomic.LoadInt32(&cookieFragments); n != 3 {
+		t.Fatalf("Expected the ClientHello with the cookie in 3 records, got %d", n)
+	}
+	if n := atomic.LoadInt32(&helloVerifyRequests); n != 1 {
+		t.Errorf("Expected a single HelloVerifyRequest, got %d", n)
+	}
+}
+
+func TestHandshakeFragmentSize(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const fragmentSize = 64
+
+	// The fragments of the server Certificate by offset, retransmissions
+	// send the same fragments again
+	var (
+		mu                sync.Mutex
+		certificateLength uint32
+		fragments         = map[uint32]uint32{}
+	)
+	ca, cb := testutil.Pipe()
+	cb.SetFilter(func(d testutil.Datagram) testutil.Action {
+		pkts, err := recordlayer.UnpackDatagram(d.Data)
+		if err != nil {
+			return testutil.Deliver
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, pkt := range pkts {
+			var h recordlayer.Header
+			if h.Unmarshal(pkt) != nil || h.ContentType != protocol.ContentTypeHandshake || h.Epoch != 0 {
+				continue
+			}
+			var hh handshake.Header
+			if hh.Unmarshal(pkt[recordlayer.FixedHeaderSize:]) == nil && hh.Type == handshake.TypeCertificate {
+				certificateLength = hh.Length
+				fragments[hh.FragmentOffset] = hh.FragmentLength
+			}
+		}
+		return testutil.Deliver
+	})
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{}, false)
+		c <- result{client, err}
+	}()
+
+	// The default MTU would fit the whole Certificate in one fragment
+	server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{HandshakeFragmentSize: fragmentSize}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+	_ = server.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if certificateLength <= fragmentSize {
+		t.Fatalf("Expected a Certificate larger than %d bytes, got %d bytes", fragmentSize, certificateLength)
+	}
+	if want := int(certificateLength+fragmentSize-1) / fragmentSize; len(fragments) != want {
+		t.Fatalf("Expected the Certificate in %d fragments, got %d", want, len(fragments))
+	}
+	var covered uint32
+	for offset, length := range fragments {
+		if length > fragmentSize {
+			t.Errorf("Fragment at offset %d is %d bytes, larger than %d", offset, length, fragmentSize)
+		}
+		covered += length
+	}
+	if covered != certificateLength {
+		t.Errorf("Expected fragments covering %d bytes, got %d", certificateLength, covered)
+	}
+}
+
+func TestRetransmitOnPeerRetransmission(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// The server would only retransmit after the handshake timed out, it has
+	// to answer the retransmitted ClientHello instead
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		mu           sync.Mutex
+		serverHellos int
+	)
+	ca, cb := testutil.Pipe()
+	cb.SetFilter(func(d testutil.Datagram) testutil.Action {
+		pkts, err := recordlayer.UnpackDatagram(d.Data)
+		if err != nil {
+			return testutil.Deliver
+		}
+		for _, pkt := range pkts {
+			var h recordlayer.Header
+			if h.Unmarshal(pkt) != nil || h.ContentType != protocol.ContentTypeHandshake || h.Epoch != 0 {
+				continue
+			}
+			var hh handshake.Header
+			if hh.Unmarshal(pkt[recordlayer.FixedHeaderSize:]) == nil && hh.Type == handshake.TypeServerHello {
+				mu.Lock()
+				defer mu.Unlock()
+				serverHellos++
+				if serverHellos == 1 {
+					// Lose the first ServerHello
+					return testutil.Drop
+				}
+			}
+		}
+		return testutil.Deliver
+	})
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{FlightInterval: 100 * time.Millisecond}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{FlightInterval: time.Minute}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+	_ = server.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if serverHellos < 2 {
+		t.Fatalf("Expected the server to retransmit its ServerHello, sent it %d times", serverHellos)
+	}
+}
+
+func TestRecordPadding(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const paddedLen = 256
+	message, reply := []byte("hello"), []byte("world")
+
+	for name, tt := range map[string]struct {
+		clientPadding, serverPadding func(int) int
+		wantPadding                  bool
+	}{
+		"Negotiated": {
+			clientPadding: func(int) int { return paddedLen },
+			wantPadding:   true,
+		},
+		"ClientWithoutPadding": {
+			serverPadding: func(int) int { return paddedLen },
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// Length of the last application_data datagram each side sent
+			var clientRecordLen, serverRecordLen int32
+			recordLen := func(n *int32) testutil.Filter {
+				return func(d testutil.Datagram) testutil.Action {
+					h := &recordlayer.Header{}
+					if h.Unmarshal(d.Data) == nil && h.ContentType == protocol.ContentTypeApplicationData {
+						atomic.StoreInt32(n, int32(len(d.Data)))
+					}
+					return testutil.Deliver
+				}
+			}
+			ca, cb := testutil.Pipe()
+			ca.SetFilter(recordLen(&clientRecordLen))
+			cb.SetFilter(recordLen(&serverRecordLen))
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+			go func() {
+				client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+					CipherSuites:  []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					RecordPadding: tt.clientPadding,
+				}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{
+				RecordPadding: tt.serverPadding,
+			}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
+			defer func() {
+				_ = client.Close()
+			}()
+
+			buf := make([]byte, 1024)
+			if _, err := client.Write(message); err != nil {
+				t.Fatal(err)
+			}
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], message) {
+				t.Errorf("Server read %q, expected %q", buf[:n], message)
+			}
+			if _, err := server.Write(reply); err != nil {
+				t.Fatal(err)
+			}
+			n, err = client.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], reply) {
+				t.Errorf("Client read %q, expected %q", buf[:n], reply)
+			}
+
+			// Both messages have the same length, the records differ by the
+			// padding of the client only
+			expectedDiff := int32(0)
+			if tt.wantPadding {
+				expectedDiff = paddedLen - int32(len(message))
+			}
+			if diff := atomic.LoadInt32(&clientRecordLen) - atomic.LoadInt32(&serverRecordLen); diff != expectedDiff {
+				t.Errorf("Client records exceed server records by %d bytes, expected %d", diff, expectedDiff)
+			}
+		})
+	}
+
+	for name, padded := range map[string][]byte{
+		"Truncated":      {0x00},
+		"LengthTooLarge": {0x00, 0x04, 0x01, 0x02, 0x03},
+		"NonZeroPadding": {0x00, 0x01, 0x01, 0x00, 0x01},
+	} {
+		if _, err := unpadApplicationData(padded); !errors.Is(err, errInvalidRecordPadding) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidRecordPadding, err)
+		}
+	}
+	if data, err := unpadApplicationData(padApplicationData(message, 16)); err != nil || !bytes.Equal(data, message) {
+		t.Errorf("Padded data decoded to %q (%v), expected %q", data, err, message)
+	}
+}
+
+func TestEncryptThenMAC(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	message := []byte("hello")
+	// The 5 byte message with TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA: an IV,
+	// the message padded to a block and the MAC after it, or the message
+	// with the MAC padded to two blocks
+	const (
+		encryptThenMACLen = aes.BlockSize + aes.BlockSize + sha1.Size
+		macThenEncryptLen = aes.BlockSize + 2*aes.BlockSize
+	)
+
+	for name, tt := range map[string]struct {
+		cipherSuite                  CipherSuiteID
+		disableClient, disableServer bool
+		want                         bool
+		wantRecordLen                int
+	}{
+		"Negotiated": {
+			cipherSuite:   TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			want:          true,
+			wantRecordLen: encryptThenMACLen,
+		},
+		"ClientDisabled": {
+			cipherSuite:   TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			disableClient: true,
+			wantRecordLen: macThenEncryptLen,
+		},
+		"ServerDisabled": {
+			cipherSuite:   TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			disableServer: true,
+			wantRecordLen: macThenEncryptLen,
+		},
+		"AEAD": {
+			cipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var recordLen int32
+			ca, cb := testutil.Pipe()
+			ca.SetFilter(func(d testutil.Datagram) testutil.Action {
+				h := &recordlayer.Header{}
+				if h.Unmarshal(d.Data) == nil && h.ContentType == protocol.ContentTypeApplicationData {
+					atomic.StoreInt32(&recordLen, int32(len(d.Data)-recordlayer.FixedHeaderSize))
+				}
+				return testutil.Deliver
+			})
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+			go func() {
+				client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+					CipherSuites:          []CipherSuiteID{tt.cipherSuite, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+					DisableEncryptThenMAC: tt.disableClient,
+				}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{
+				CipherSuites:          []CipherSuiteID{tt.cipherSuite},
+				DisableEncryptThenMAC: tt.disableServer,
+			}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
+			defer func() {
+				_ = client.Close()
+			}()
+
+			if client.state.encryptThenMAC != tt.want || server.state.encryptThenMAC != tt.want {
+				t.Fatalf("Expected encrypt_then_mac %v, client negotiated %v, server %v", tt.want, client.state.encryptThenMAC, server.state.encryptThenMAC)
+			}
+
+			if _, err := client.Write(message); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 1024)
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], message) {
+				t.Errorf("Server read %q, expected %q", buf[:n], message)
+			}
+			if tt.wantRecordLen != 0 {
+				if l := atomic.LoadInt32(&recordLen); l != int32(tt.wantRecordLen) {
+					t.Errorf("Expected an application data record of %d bytes, got %d", tt.wantRecordLen, l)
+				}
+			}
+		})
+	}
+}
+
+func TestRawPublicKey(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverKey, err := ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, clientKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectKey := func(want crypto.PublicKey) func(crypto.PublicKey) error {
+		return func(publicKey crypto.PublicKey) error {
+			if k, ok := publicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(want) {
+				return errWrongCert
+			}
+			return nil
+		}
+	}
+
+	for name, tt := range map[string]struct {
+		clientCfg, serverCfg               *Config
+		wantClientRawKey, wantServerRawKey bool
+		wantErr                            bool
+	}{
+		"BothSides": {
+			clientCfg: &Config{
+				RawPublicKey:       clientKey,
+				VerifyRawPublicKey: expectKey(serverKey.Public()),
+			},
+			serverCfg: &Config{
+				RawPublicKey:       serverKey,
+				VerifyRawPublicKey: expectKey(clientKey.Public()),
+				ClientAuth:         RequireAnyClientCert,
+			},
+			wantClientRawKey: true,
+			wantServerRawKey: true,
+		},
+		// A server without a raw public key falls back to X.509
+		"ServerCertificate": {
+			clientCfg: &Config{
+				VerifyRawPublicKey: expectKey(serverKey.Public()),
+				InsecureSkipVerify: true,
+			},
+			serverCfg: &Config{
+				Certificates: []tls.Certificate{certificate},
+			},
+		},
+		// A server that doesn't verify raw public keys selects X.509 for
+		// the client
+		"ClientCertificate": {
+			clientCfg: &Config{
+				RawPublicKey:       clientKey,
+				Certificates:       []tls.Certificate{certificate},
+				VerifyRawPublicKey: expectKey(serverKey.Public()),
+			},
+			serverCfg: &Config{
+				RawPublicKey: serverKey,
+				ClientAuth:   RequireAnyClientCert,
+			},
+			wantServerRawKey: true,
+		},
+		"RejectedKey": {
+			clientCfg: &Config{
+				VerifyRawPublicKey: expectKey(clientKey.Public()),
+			},
+			serverCfg: &Config{
+				RawPublicKey: serverKey,
+			},
+			wantErr: true,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			srvCh := make(chan result)
+			go func() {
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+				srvCh <- result{s, err}
+			}()
+
+			cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+			srv := <-srvCh
+			if err == nil {
+				defer func() {
+					_ = cli.Close()
+				}()
+			}
+			if srv.err == nil {
+				defer func() {
+					_ = srv.c.Close()
+				}()
+			}
+			if tt.wantErr {
+				if !errors.Is(err, errWrongCert) {
+					t.Fatalf("Expected error '%v', got '%v'", errWrongCert, err)
+				}
+				return
+			}
+			if err != nil || srv.err != nil {
+				t.Fatalf("Handshake failed, client: %v, server: %v", err, srv.err)
+			}
+
+			// Each side learns the type of the other's credential
+			if got := cli.ConnectionState().PeerRawPublicKey; got != tt.wantServerRawKey {
+				t.Errorf("Expected the client to see a server raw public key %v, got %v", tt.wantServerRawKey, got)
+			}
+			if got := srv.c.ConnectionState().PeerRawPublicKey; got != tt.wantClientRawKey {
+				t.Errorf("Expected the server to see a client raw public key %v, got %v", tt.wantClientRawKey, got)
+			}
+
+			if _, err := cli.Write([]byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 16)
+			n, err := srv.c.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "hello" {
+				t.Errorf("Server read %q, expected %q", buf[:n], "hello")
+			}
+		})
+	}
+}
+
+func TestPathMTUReduction(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// A chain of two certificates makes flight 4 exceed the path MTU
+	_, intermediate, leaf, err := generateCertificateChain("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf.Certificate = append(leaf.Certificate, intermediate.Raw)
+
+	for name, tt := range map[string]struct {
+		pathMTU  int
+		peerGone bool
+		wantErr  error
+	}{
+		"Reduced": {
+			pathMTU: 600,
+		},
+		"BelowFloor": {
+			pathMTU: minAdaptiveMTU - 100,
+			wantErr: ErrPathMTU,
+		},
+		"PeerGone": {
+			pathMTU:  600,
+			peerGone: true,
+			wantErr:  errMaxRetransmits,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			// The path drops every datagram of the server above its MTU
+			ca, cb := testutil.Pipe()
+			var serverDropped int32
+			cb.SetFilter(func(d testutil.Datagram) testutil.Action {
+				if len(d.Data) > tt.pathMTU {
+					atomic.StoreInt32(&serverDropped, 1)
+					return testutil.Drop
+				}
+				return testutil.Deliver
+			})
+			// A gone peer sends nothing after the first dropped datagram
+			ca.SetFilter(func(d testutil.Datagram) testutil.Action {
+				if tt.peerGone && atomic.LoadInt32(&serverDropped) == 1 {
+					return testutil.Drop
+				}
+				return testutil.Deliver
+			})
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+			go func() {
+				client, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+					FlightInterval: 20 * time.Millisecond,
+					MaxRetransmits: 8,
+				}, false)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, cb, ca.LocalAddr(), &Config{
+				Certificates:   []tls.Certificate{leaf},
+				FlightInterval: 20 * time.Millisecond,
+				MaxRetransmits: 6,
+			}, false)
+			res := <-c
+			if res.err == nil {
+				defer func() {
+					_ = res.c.Close()
+				}()
+			}
+
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) || !errors.Is(err, errMaxRetransmits) {
+					t.Fatalf("Expected error '%v', got '%v'", tt.wantErr, err)
+				}
+				if tt.peerGone && errors.Is(err, ErrPathMTU) {
+					t.Fatalf("Unexpected error '%v' for a gone peer", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if mtu := server.mtu(); mtu > tt.pathMTU {
+				t.Errorf("Expected the MTU to be reduced below %d, got %d", tt.pathMTU, mtu)
+			}
+		})
+	}
+}
+
+func TestHandshakeDeadline(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("Exceeded", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// The server never answers
+		ca, cb := testutil.Pipe()
+		ca.SetFilter(func(testutil.Datagram) testutil.Action { return testutil.Drop })
+		defer func() {
+			_ = cb.Close()
+		}()
+
+		start := time.Now()
+		_, err := testClient(ctx, ca, cb.LocalAddr(), &Config{
+			FlightInterval:    50 * time.Millisecond,
+			HandshakeDeadline: start.Add(200 * time.Millisecond),
+		}, false)
+		var netErr net.Error
+		if !errors.As(err, &netErr) || !netErr.Timeout() {
+			t.Fatalf("Expected a timeout error, got '%v'", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("Handshake gave up after %v, long after the deadline", elapsed)
+		}
+	})
+
+	t.Run("Read", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		deadline := time.Now().Add(500 * time.Millisecond)
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				HandshakeDeadline: deadline,
+			}, true)
+			c <- result{client, err}
+		}()
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			HandshakeDeadline: deadline,
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = server.Close()
+		}()
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		defer func() {
+			_ = res.c.Close()
+		}()
+
+		// The deadline doesn't apply once the handshake completed
+		time.Sleep(time.Until(deadline) + 100*time.Millisecond)
+		if _, err := server.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 64)
+		n, err := res.c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != "data" {
+			t.Fatalf("Expected data, got %q", buf[:n])
+		}
+	})
+}
+
+func TestWriteBuffer(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			// Larger records are split
+			RecordSizeLimit: 64,
+			// Keep reading until the server answered the close_notify
+			CloseNotifyTimeout: 5 * time.Second,
+		}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+		RecordSizeLimit: 64,
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	client := res.c
+
+	if err := client.SetWriteBufferSize(48); err != nil {
+		t.Fatal(err)
+	}
+
+	read := func(expected []byte) {
+		t.Helper()
+		buf := make([]byte, 128)
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], expected) {
+			t.Fatalf("Unexpected data %q, expected %q", buf[:n], expected)
+		}
+	}
+
+	for _, s := range []string{"foo", "bar", "baz"} {
+		if _, err := client.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := server.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Read(make([]byte, 128)); !errors.Is(err, errDeadlineExceeded) {
+		t.Fatalf("Expected buffered data not to be sent, got error '%v'", err)
+	}
+	if err := server.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	read([]byte("foobarbaz"))
+
+	// A full buffer is sent before the write that does not fit, writes larger
+	// than the buffer are sent right away in records of the peer's limit
+	first, second := bytes.Repeat([]byte{'a'}, 40), bytes.Repeat([]byte{'b'}, 100)
+	for _, p := range [][]byte{first, second} {
+		if _, err := client.Write(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read(first)
+	read(second[:64])
+	read(second[64:])
+
+	// Close sends what is left before the close_notify
+	if _, err := client.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	read([]byte("bye"))
+	if _, err := server.Read(make([]byte, 128)); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected EOF after close_notify, got error '%v'", err)
+	}
+}
+
+// blockingWriteConn blocks writes while block is set
+type blockingWriteConn struct {
+	net.PacketConn
+
+	block   int32
+	blocked chan struct{}
+	release chan struct{}
+}
+
+func (c *blockingWriteConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if atomic.LoadInt32(&c.block) == 1 {
+		c.blocked <- struct{}{}
+		<-c.release
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestWriteUnbufferedLock(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	clientConn := &blockingWriteConn{
+		PacketConn: dtlsnet.PacketConnFromConn(ca),
+		blocked:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(context.TODO(), clientConn, ca.RemoteAddr(), &Config{}, false)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	client := res.c
+
+	atomic.StoreInt32(&clientConn.block, 1)
+	writeErr := make(chan error)
+	go func() {
+		_, err := client.Write([]byte("foo"))
+		writeErr <- err
+	}()
+	<-clientConn.blocked
+
+	// Without buffering a Write blocked on the transport must not hold the
+	// lock Close takes to flush
+	if !client.writeBufferLock.TryLock() {
+		t.Fatal("Write blocked on the transport holds the write buffer lock")
+	}
+	client.writeBufferLock.Unlock()
+
+	atomic.StoreInt32(&clientConn.block, 0)
+	close(clientConn.release)
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestCoAPProfile runs handshakes with the mandatory to implement cipher
+// suites of CoAP and exchanges a message with small records.
+// https://datatracker.ietf.org/doc/html/rfc7252#section-9.1.3
+func TestCoAPProfile(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	psk := func([]byte) ([]byte, error) {
+		return []byte{0xAB, 0xC1, 0x23}, nil
+	}
+	for name, tt := range map[string]struct {
+		cipherSuite         CipherSuiteID
+		clientCfg           *Config
+		serverCfg           *Config
+		generateCertificate bool
+	}{
+		"PreSharedKey": {
+			cipherSuite: TLS_PSK_WITH_AES_128_CCM_8,
+			clientCfg:   &Config{PSK: psk, PSKIdentityHint: []byte("coap-client")},
+			serverCfg:   &Config{PSK: psk},
+		},
+		"Certificate": {
+			cipherSuite:         TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+			clientCfg:           &Config{},
+			serverCfg:           &Config{ClientAuth: RequireAnyClientCert},
+			generateCertificate: true,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			for _, cfg := range []*Config{tt.clientCfg, tt.serverCfg} {
+				cfg.CipherSuites = []CipherSuiteID{tt.cipherSuite}
+				cfg.EllipticCurves = []elliptic.Curve{elliptic.P256}
+				cfg.SignatureSchemes = []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256}
+				cfg.MTU = 256
+			}
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, tt.generateCertificate)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, tt.generateCertificate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = res.c.Close()
+				_ = server.Close()
+			}()
+
+			if id := res.c.state.cipherSuite.ID(); id != tt.cipherSuite {
+				t.Fatalf("Selected cipher suite %v, expected %v", id, tt.cipherSuite)
+			}
+
+			// A confirmable CoAP GET of /hello
+			message := []byte{0x40, 0x01, 0x04, 0xd2, 0xb5, 'h', 'e', 'l', 'l', 'o'}
+			if _, err := res.c.Write(message); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 64)
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], message) {
+				t.Fatalf("Unexpected message %x, expected %x", buf[:n], message)
+			}
+		})
+	}
+}
+
+func TestCertificateAndPSKServer(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name      string
+		ClientPSK bool
+	}{
+		{
+			Name:      "Client uses PKI",
+			ClientPSK: false,
+		},
+		{
+			Name:      "Client uses PSK",
+			ClientPSK: true,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				config := &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+				if test.ClientPSK {
+					config.PSK = func([]byte) ([]byte, error) {
+						return []byte{0x00, 0x01, 0x02}, nil
+					}
+					config.PSKIdentityHint = []byte{0x00}
+					config.CipherSuites = []CipherSuiteID{TLS_PSK_WITH_AES_128_GCM_SHA256}
+				}
+
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+				c <- result{client, err}
+			}()
+
+			config := &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256},
+				PSK: func([]byte) ([]byte, error) {
+					return []byte{0x00, 0x01, 0x02}, nil
+				},
+			}
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+			if err == nil {
+				defer func() {
+					_ = server.Close()
+				}()
+			} else {
+				t.Errorf("TestCertificateAndPSKServer: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, err)
+			}
+
+			res := <-c
+			if res.err == nil {
+				_ = server.Close()
+				_ = res.c.Close()
+			} else {
+				t.Errorf("TestCertificateAndPSKServer: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, res.err)
+			}
+		})
+	}
+}
+
+func TestPSKConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                 string
+		ClientHasCertificate bool
+		ServerHasCertificate bool
+		ClientPSK            PSKCallback
+		ServerPSK            PSKCallback
+		ClientPSKIdentity    []byte
+		ServerPSKIdentity    []byte
+		WantClientError      error
+		WantServerError      error
+	}{
+		{
+			Name:                 "PSK and no certificate specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      errNoAvailablePSKCipherSuite,
+			WantServerError:      errNoAvailablePSKCipherSuite,
+		},
+		{
+			Name:                 "PSK and certificate specified",
+			ClientHasCertificate: true,
+			ServerHasCertificate: true,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      errNoAvailablePSKCipherSuite,
+			WantServerError:      errNoAvailablePSKCipherSuite,
+		},
+		{
+			Name:                 "PSK and no identity specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    nil,
+			ServerPSKIdentity:    nil,
+			WantClientError:      errPSKAndIdentityMustBeSetForClient,
+			WantServerError:      errNoAvailablePSKCipherSuite,
+		},
+		{
+			Name:                 "No PSK and identity specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            nil,
+			ServerPSK:            nil,
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      errIdentityNoPSK,
+			WantServerError:      errIdentityNoPSK,
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{PSK: test.ClientPSK, PSKIdentityHint: test.ClientPSKIdentity}, test.ClientHasCertificate)
+			c <- result{client, err}
+		}()
+
+		_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{PSK: test.ServerPSK, PSKIdentityHint: test.ServerPSKIdentity}, test.ServerHasCertificate)
+		if err != nil || test.WantServerError != nil {
+			if !(err != nil && test.WantServerError != nil && err.Error() == test.WantServerError.Error()) {
+				t.Fatalf("TestPSKConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			}
+		}
+
+		res := <-c
+		if res.err != nil || test.WantClientError != nil {
+			if !(res.err != nil && test.WantClientError != nil && res.err.Error() == test.WantClientError.Error()) {
+				t.Fatalf("TestPSKConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			}
+		}
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookie := make([]byte, 20)
+	_, err := rand.Read(cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rand [28]byte
+	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
+
+	cipherSuites := []CipherSuite{
+		&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+		&ciphersuite.TLSEcdheRsaWithAes128GcmSha256{},
+	}
+
+	extensions := []extension.Extension{
+		&extension.SupportedSignatureAlgorithms{
+			SignatureHashAlgorithms: []signaturehash.Algorithm{
+				{Hash: hash.SHA256, Signature: signature.ECDSA},
+				{Hash: hash.SHA384, Signature: signature.ECDSA},
+				{Hash: hash.SHA512, Signature: signature.ECDSA},
+				{Hash: hash.SHA256, Signature: signature.RSA},
+				{Hash: hash.SHA384, Signature: signature.RSA},
+				{Hash: hash.SHA512, Signature: signature.RSA},
+			},
+		},
+		&extension.SupportedEllipticCurves{
+			EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+		},
+		&extension.SupportedPointFormats{
+			PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+		},
+	}
+
+	record := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			SequenceNumber: 0,
+			Version:        protocol.Version1_2,
+		},
+		Content: &handshake.Handshake{
+			// sequenceNumber and messageSequence line up, may need to be re-evaluated
+			Header: handshake.Header{
+				MessageSequence: 0,
+			},
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				Cookie:             cookie,
+				Random:             random,
+				CipherSuiteIDs:     cipherSuiteIDs(cipherSuites),
+				CompressionMethods: defaultCompressionMethods(),
+				Extensions:         extensions,
+			},
+		},
+	}
+
+	packet, err := record.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		err := ca.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Client reader
+	caReadChan := make(chan []byte, 1000)
+	go func() {
+		for {
+			data := make([]byte, 8192)
+			n, err := ca.Read(data)
+			if err != nil {
+				return
+			}
+
+			caReadChan <- data[:n]
+		}
+	}()
+
+	// Start sending ClientHello packets until server responds with first packet
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				_, err := ca.Write(packet)
+				if err != nil {
+					return
+				}
+			case <-caReadChan:
+				// Once we receive the first reply from the server, stop
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	config := &Config{
+		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		FlightInterval: 100 * time.Millisecond,
+	}
+
+	_, serverErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+	var netErr net.Error
+	if !errors.As(serverErr, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Client error exp(Temporary network error) failed(%v)", serverErr)
+	}
+
+	// Wait a little longer to ensure no additional messages have been sent by the server
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case msg := <-caReadChan:
+		t.Fatalf("Expected no additional messages from server, got: %+v", msg)
+	default:
+	}
+}
+
+func TestMinVersion(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	config := &Config{
+		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		FlightInterval: 100 * time.Millisecond,
+		MinVersion:     protocol.Version1_2,
+	}
+	random := handshake.Random{GMTUnixTime: time.Unix(500, 0)}
+
+	// expectProtocolVersionAlert reads the answer to the last record
+	expectProtocolVersionAlert := func(t *testing.T, ca net.Conn) {
+		resp := make([]byte, 1024)
+		n, err := ca.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &recordlayer.RecordLayer{}
+		if err := r.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+		if a, ok := r.Content.(*alert.Alert); !ok || a.Description != alert.ProtocolVersion {
+			t.Fatalf("Expected a protocol_version alert, got %v", r.Content)
+		}
+	}
+
+	t.Run("Server", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = ca.Close()
+		}()
+		errChan := make(chan error)
+		go func() {
+			_, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+			errChan <- err
+		}()
+
+		// A DTLS 1.0 only client
+		packet, err := (&recordlayer.RecordLayer{
+			Header: recordlayer.Header{Version: protocol.Version1_0},
+			Content: &handshake.Handshake{
+				Message: &handshake.MessageClientHello{
+					Version:            protocol.Version1_0,
+					Random:             random,
+					CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+					CompressionMethods: defaultCompressionMethods(),
+				},
+			},
+		}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ca.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+		expectProtocolVersionAlert(t, ca)
+		if err := <-errChan; !errors.Is(err, errUnsupportedProtocolVersion) {
+			t.Fatalf("Expected error '%v', got '%v'", errUnsupportedProtocolVersion, err)
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = ca.Close()
+		}()
+		errChan := make(chan error)
+		go func() {
+			_, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+			errChan <- err
+		}()
+
+		// A DTLS 1.0 only server, whose HelloVerifyRequest is accepted
+		for _, record := range []*recordlayer.RecordLayer{
+			{
+				Header: recordlayer.Header{Version: protocol.Version1_0},
+				Content: &handshake.Handshake{
+					Message: &handshake.MessageHelloVerifyRequest{
+						Version: protocol.Version1_0,
+						Cookie:  make([]byte, 20),
+					},
+				},
+			},
+			{
+				Header: recordlayer.Header{Version: protocol.Version1_0, SequenceNumber: 1},
+				Content: &handshake.Handshake{
+					Header: handshake.Header{MessageSequence: 1},
+					Message: &handshake.MessageServerHello{
+						Version:           protocol.Version1_0,
+						Random:            random,
+						CipherSuiteID:     func() *uint16 { id := uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); return &id }(),
+						CompressionMethod: defaultCompressionMethods()[0],
+					},
+				},
+			},
+		} {
+			if _, err := ca.Read(make([]byte, 1024)); err != nil {
+				t.Fatal(err)
+			}
+			packet, err := record.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ca.Write(packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expectProtocolVersionAlert(t, ca)
+		if err := <-errChan; !errors.Is(err, errUnsupportedProtocolVersion) {
+			t.Fatalf("Expected error '%v', got '%v'", errUnsupportedProtocolVersion, err)
+		}
+	})
+}
+
+func TestProtocolVersionValidation(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookie := make([]byte, 20)
+	if _, err := rand.Read(cookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var rand [28]byte
+	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
+
+	config := &Config{
+		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		FlightInterval: 100 * time.Millisecond,
+	}
+
+	t.Run("Server", func(t *testing.T) {
+		serverCases := map[string]struct {
+			records []*recordlayer.RecordLayer
+		}{
+			"ClientHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+				},
+			},
+			"SecondsClientHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version1_2,
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+					{
+						Header: recordlayer.Header{
+							Version:        protocol.Version1_2,
+							SequenceNumber: 1,
+						},
+						Content: &handshake.Handshake{
+							Header: handshake.Header{
+								MessageSequence: 1,
+							},
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+				},
+			},
+		}
+		for name, c := range serverCases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				defer func() {
+					err := ca.Close()
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+
+				var wg sync.WaitGroup
+				wg.Add(1)
+				defer wg.Wait()
+				go func() {
+					defer wg.Done()
+					if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
+						t.Errorf("Client error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
+					}
+				}()
+
+				time.Sleep(50 * time.Millisecond)
+
+				resp := make([]byte, 1024)
+				for _, record := range c.records {
+					packet, err := record.Marshal()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, werr := ca.Write(packet); werr != nil {
+						t.Fatal(werr)
+					}
+					n, rerr := ca.Read(resp[:cap(resp)])
+					if rerr != nil {
+						t.Fatal(rerr)
+					}
+					resp = resp[:n]
+				}
+
+				h := &recordlayer.Header{}
+				if err := h.Unmarshal(resp); err != nil {
+					t.Fatal("Failed to unmarshal response")
+				}
+				if h.ContentType != protocol.ContentTypeAlert {
+					t.Errorf("Peer must return alert to unsupported protocol version")
+				}
+			})
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		clientCases := map[string]struct {
+			records []*recordlayer.RecordLayer
+		}{
+			"ServerHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageHelloVerifyRequest{
+								Version: protocol.Version1_2,
+								Cookie:  cookie,
+							},
+						},
+					},
+					{
+						Header: recordlayer.Header{
+							Version:        protocol.Version1_2,
+							SequenceNumber: 1,
+						},
+						Content: &handshake.Handshake{
+							Header: handshake.Header{
+								MessageSequence: 1,
+							},
+							Message: &handshake.MessageServerHello{
+								Version:           protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Random:            random,
+								CipherSuiteID:     func() *uint16 { id := uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); return &id }(),
+								CompressionMethod: defaultCompressionMethods()[0],
+							},
+						},
+					},
+				},
+			},
+		}
+		for name, c := range clientCases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				defer func() {
+					err := ca.Close()
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+
+				var wg sync.WaitGroup
+				wg.Add(1)
+				defer wg.Wait()
+				go func() {
+					defer wg.Done()
+					if _, err := testClient(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
+						t.Errorf("Server error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
+					}
+				}()
+
+				time.Sleep(50 * time.Millisecond)
+
+				for _, record := range c.records {
+					if _, err := ca.Read(make([]byte, 1024)); err != nil {
+						t.Fatal(err)
+					}
+
+					packet, err := record.Marshal()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := ca.Write(packet); err != nil {
+						t.Fatal(err)
+					}
+				}
+				resp := make([]byte, 1024)
+				n, err := ca.Read(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp = resp[:n]
+
+				h := &recordlayer.Header{}
+				if err := h.Unmarshal(resp); err != nil {
+					t.Fatal("Failed to unmarshal response")
+				}
+				if h.ContentType != protocol.ContentTypeAlert {
+					t.Errorf("Peer must return alert to unsupported protocol version")
+				}
+			})
+		}
+	})
+}
+
+func TestFallbackSCSV(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("Downgraded", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = ca.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		serverErr := make(chan error, 1)
+		go func() {
+			_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			}, true)
+			serverErr <- err
+		}()
+
+		var rand [28]byte
+		record := &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Version: protocol.Version1_0,
+			},
+			Content: &handshake.Handshake{
+				Message: &handshake.MessageClientHello{
+					Version:            protocol.Version1_0,
+					Random:             handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand},
+					CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), fallbackSCSV},
+					CompressionMethods: defaultCompressionMethods(),
+				},
+			},
+		}
+		packet, err := record.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ca.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+
+		resp := make([]byte, 1024)
+		n, err := ca.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &recordlayer.RecordLayer{}
+		if err := r.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+		a, ok := r.Content.(*alert.Alert)
+		if !ok || a.Description != alert.InappropriateFallback {
+			t.Errorf("Expected inappropriate_fallback alert, got %v", r.Content)
+		}
+		if err := <-serverErr; !errors.Is(err, errInappropriateFallback) {
+			t.Errorf("Server error exp(%v) failed(%v)", errInappropriateFallback, err)
+		}
+	})
+
+	t.Run("SupportedVersion", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{FallbackSCSV: true}, false)
+			c <- result{client, err}
+		}()
+
+		var offered bool
+		server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			OnClientHello: func(clientHello *handshake.MessageClientHello) error {
+				for _, id := range clientHello.CipherSuiteIDs {
+					offered = offered || id == fallbackSCSV
+				}
+				return nil
+			},
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		if !offered {
+			t.Error("Client did not offer TLS_FALLBACK_SCSV")
+		}
+
+		_ = res.c.Close()
+		_ = server.Close()
+	})
+}
+
+func TestMultipleHelloVerifyRequest(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookies := [][]byte{
+		// first clientHello contains an empty cookie
+		{},
+	}
+	var packets [][]byte
+	for i := 0; i < 2; i++ {
+		cookie := make([]byte, 20)
+		if _, err := rand.Read(cookie); err != nil {
+			t.Fatal(err)
+		}
+		cookies = append(cookies, cookie)
+
+		record := &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				SequenceNumber: uint64(i),
+				Version:        protocol.Version1_2,
+			},
+			Content: &handshake.Handshake{
+				Header: handshake.Header{
+					MessageSequence: uint16(i),
+				},
+				Message: &handshake.MessageHelloVerifyRequest{
+					Version: protocol.Version1_2,
+					Cookie:  cookie,
+				},
+			},
+		}
+		packet, err := record.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		err := ca.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Wait()
+	go func() {
+		defer wg.Done()
+		_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
+	}()
+
+	for i, cookie := range cookies {
+		// read client hello
+		resp := make([]byte, 1024)
+		n, err := cb.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := &recordlayer.RecordLayer{}
+		if err := record.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+		clientHello, ok := record.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
+		if !ok {
+			t.Fatal("Failed to cast MessageClientHello")
+		}
+
+		if !bytes.Equal(clientHello.Cookie, cookie) {
+			t.Fatalf("Wrong cookie, expected: %x, got: %x", clientHello.Cookie, cookie)
+		}
+		if len(packets) <= i {
+			break
+		}
+		// write hello verify request
+		if _, err := cb.Write(packets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+}
+
+// Assert that a DTLS Server always responds with RenegotiationInfo if
+// a ClientHello contained that extension or not
+func TestRenegotationInfo(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	resp := make([]byte, 1024)
+
+	for _, testCase := range []struct {
+		Name                  string
+		SendRenegotiationInfo bool
+	}{
+		{
+			"Include RenegotiationInfo",
+			true,
+		},
+		{
+			"No RenegotiationInfo",
+			false,
+		},
+	} {
+		test := testCase
+		t.Run(test.Name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			defer func() {
+				if err := ca.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
+					t.Error(err)
+				}
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+
+			extensions := []extension.Extension{}
+			if test.SendRenegotiationInfo {
+				extensions = append(extensions, &extension.RenegotiationInfo{
+					RenegotiatedConnection: 0,
+				})
+			}
+			err := sendClientHello([]byte{}, ca, 0, extensions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := ca.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &recordlayer.RecordLayer{}
+			if err = r.Unmarshal(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
+			if !ok {
+				t.Fatal("Failed to cast MessageHelloVerifyRequest")
+			}
+
+			err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err = ca.Read(resp); err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := recordlayer.UnpackDatagram(resp[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Unmarshal(messages[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+			if !ok {
+				t.Fatal("Failed to cast MessageServerHello")
+			}
+
+			gotNegotationInfo := false
+			for _, v := range serverHello.Extensions {
+				if _, ok := v.(*extension.RenegotiationInfo); ok {
+					gotNegotationInfo = true
+				}
+			}
+
+			if !gotNegotationInfo {
+				t.Fatalf("Received ServerHello without RenegotiationInfo")
+			}
+		})
+	}
+}
+
+func TestServerNameIndicationExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name       string
+		ServerName string
+		Expected   []byte
+		IncludeSNI bool
+	}{
+		{
+			Name:       "Server name is a valid hostname",
+			ServerName: "example.com",
+			Expected:   []byte("example.com"),
+			IncludeSNI: true,
+		},
+		{
+			Name:       "Server name is an IP literal",
+			ServerName: "1.2.3.4",
+			Expected:   []byte(""),
+			IncludeSNI: false,
+		},
+		{
+			Name:       "Server name is empty",
+			ServerName: "",
+			Expected:   []byte(""),
+			IncludeSNI: false,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					ServerName: test.ServerName,
+				}
+
+				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+			}()
+
+			// Receive ClientHello
+			resp := make([]byte, 1024)
+			n, err := cb.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &recordlayer.RecordLayer{}
+			if err = r.Unmarshal(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			clientHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
+			if !ok {
+				t.Fatal("Failed to cast MessageClientHello")
+			}
+
+			gotSNI := false
+			var actualServerName string
+			for _, v := range clientHello.Extensions {
+				if _, ok := v.(*extension.ServerName); ok {
+					gotSNI = true
+					extensionServerName, ok := v.(*extension.ServerName)
+					if !ok {
+						t.Fatal("Failed to cast extension.ServerName")
+					}
+
+					actualServerName = extensionServerName.ServerName
+				}
+			}
+
+			if gotSNI != test.IncludeSNI {
+				t.Errorf("TestSNI: unexpected SNI inclusion '%s': expected(%v) actual(%v)", test.Name, test.IncludeSNI, gotSNI)
+			}
+
+			if !bytes.Equal([]byte(actualServerName), test.Expected) {
+				t.Errorf("TestSNI: server name mismatch '%s': expected(%v) actual(%v)", test.Name, test.Expected, actualServerName)
+			}
+		})
+	}
+}
+
+func TestGetCertificateServerName(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	certificateFoo, err := selfsign.GenerateSelfSignedWithDNS("foo.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificateBar, err := selfsign.GenerateSelfSignedWithDNS("bar.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificates := map[string]*tls.Certificate{
+		"foo.test": &certificateFoo,
+		"bar.test": &certificateBar,
+	}
+
+	for name, expected := range certificates {
+		name, expected := name, expected
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					ServerName:         name,
+					InsecureSkipVerify: true,
+				}, false)
+				c <- result{client, err}
+			}()
+
+			var serverNames []string
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				// Never presented, GetCertificate takes precedence
+				Certificates: []tls.Certificate{certificateBar},
+				GetCertificate: func(info *ClientHelloInfo) (*tls.Certificate, error) {
+					serverNames = append(serverNames, info.ServerName)
+					if cert, ok := certificates[info.ServerName]; ok {
+						return cert, nil
+					}
+					return &certificateFoo, nil
+				},
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = res.c.Close()
+				_ = server.Close()
+			}()
+
+			if serverNames[len(serverNames)-1] != name {
+				t.Errorf("GetCertificate called with server name %q, expected %q", serverNames[len(serverNames)-1], name)
+			}
+			peerCertificates := res.c.ConnectionState().PeerCertificates
+			if len(peerCertificates) == 0 || !bytes.Equal(peerCertificates[0], expected.Certificate[0]) {
+				t.Error("Client received the wrong certificate")
+			}
+		})
+	}
+}
+
+func TestALPNExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                   string
+		ClientProtocolNameList []string
+		ServerProtocolNameList []string
+		ExpectedProtocol       string
+		SelectedProtocol       string
+		ExpectAlertFromClient  bool
+		ExpectAlertFromServer  bool
+		Alert                  alert.Description
+	}{
+		{
+			Name:                   "Negotiate a protocol",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
+			ServerProtocolNameList: []string{"spd/1"},
+			ExpectedProtocol:       "spd/1",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Server doesn't support any",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
+			ServerProtocolNameList: []string{},
+			ExpectedProtocol:       "",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Negotiate with higher server precedence",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1", "http/3"},
+			ServerProtocolNameList: []string{"ssh/2", "http/3", "spd/1"},
+			ExpectedProtocol:       "http/3",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Empty intersection",
+			ClientProtocolNameList: []string{"http/1.1", "http/3"},
+			ServerProtocolNameList: []string{"ssh/2", "spd/1"},
+			ExpectedProtocol:       "",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  true,
+			Alert:                  alert.NoApplicationProtocol,
+		},
+		{
+			Name:                   "Multiple protocols in ServerHello",
+			ClientProtocolNameList: []string{"http/1.1"},
+			ServerProtocolNameList: []string{"http/1.1"},
+			ExpectedProtocol:       "http/1.1",
+			ExpectAlertFromClient:  true,
+			ExpectAlertFromServer:  false,
+			Alert:                  alert.InternalError,
+		},
+		{
+			Name:                   "Server selects a protocol not offered",
+			ClientProtocolNameList: []string{"http/1.1"},
+			ServerProtocolNameList: []string{"http/1.1"},
+			ExpectedProtocol:       "http/1.1",
+			SelectedProtocol:       "spd/1",
+			ExpectAlertFromClient:  true,
+			ExpectAlertFromServer:  false,
+			Alert:                  alert.NoApplicationProtocol,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					SupportedProtocols: test.ClientProtocolNameList,
+				}
+				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+			}()
+
+			// Receive ClientHello
+			resp := make([]byte, 1024)
+			n, err := cb.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel2()
+
+			ca2, cb2 := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					SupportedProtocols: test.ServerProtocolNameList,
+				}
+				if _, err2 := testServer(ctx2, dtlsnet.PacketConnFromConn(cb2), cb2.RemoteAddr(), conf, true); !errors.Is(err2, context.Canceled) {
+					if test.ExpectAlertFromServer { //nolint
+						// Assert the error type?
+					} else {
+						t.Error(err2)
+					}
+				}
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+
+			// Forward ClientHello
+			if _, err = ca2.Write(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive HelloVerify
+			resp2 := make([]byte, 1024)
+			n, err = ca2.Read(resp2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Forward HelloVerify
+			if _, err = cb.Write(resp2[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive ClientHello
+			resp3 := make([]byte, 1024)
+			n, err = cb.Read(resp3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Forward ClientHello
+			if _, err = ca2.Write(resp3[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive ServerHello
+			resp4 := make([]byte, 1024)
+			n, err = ca2.Read(resp4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := recordlayer.UnpackDatagram(resp4[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &recordlayer.RecordLayer{}
+			if err := r.Unmarshal(messages[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.ExpectAlertFromServer {
+				a, ok := r.Content.(*alert.Alert)
+				if !ok {
+					t.Fatal("Failed to cast alert.Alert")
+				}
+
+				if a.Description != test.Alert {
+					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
+				}
+			} else {
+				serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+				if !ok {
+					t.Fatal("Failed to cast handshake.MessageServerHello")
+				}
+
+				var negotiatedProtocol string
+				for _, v := range serverHello.Extensions {
+					if _, ok := v.(*extension.ALPN); ok {
+						e, ok := v.(*extension.ALPN)
+						if !ok {
+							t.Fatal("Failed to cast extension.ALPN")
+						}
+
+						negotiatedProtocol = e.ProtocolNameList[0]
+
+						// Manipulate ServerHello
+						switch {
+						case test.SelectedProtocol != "":
+							e.ProtocolNameList = []string{test.SelectedProtocol}
+						case test.ExpectAlertFromClient:
+							e.ProtocolNameList = append(e.ProtocolNameList, "oops")
+						}
+					}
+				}
+
+				if negotiatedProtocol != test.ExpectedProtocol {
+					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.ExpectedProtocol, negotiatedProtocol)
+				}
+
+				s, err := r.Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Forward ServerHello
+				if _, err = cb.Write(s); err != nil {
+					t.Fatal(err)
+				}
+
+				if test.ExpectAlertFromClient {
+					resp5 := make([]byte, 1024)
+					n, err = cb.Read(resp5)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					r2 := &recordlayer.RecordLayer{}
+					if err := r2.Unmarshal(resp5[:n]); err != nil {
+						t.Fatal(err)
+					}
+
+					a, ok := r2.Content.(*alert.Alert)
+					if !ok {
+						t.Fatal("Failed to cast alert.Alert")
+					}
+
+					if a.Description != test.Alert {
+						t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
+					}
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond) // Give some time for returned errors
+		})
+	}
+}
+
+func TestALPNMissingServerResponse(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SupportedProtocols: []string{"http/1.1"}}, true)
+		c <- result{client, err}
+	}()
+
+	// The server does not answer the ALPN extension
+	server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+	}()
+
+	if p := res.c.ConnectionState().NegotiatedProtocol; p != "" {
+		t.Fatalf("Expected no negotiated protocol, got %q", p)
+	}
+}
+
+// Make sure the supported_groups extension is not included in the ServerHello
+func TestALPNSelector(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	errRejected := errors.New("rejected") //nolint:goerr113
+
+	for _, test := range []struct {
+		Name                   string
+		ClientProtocolNameList []string
+		Selector               func([]string) (string, error)
+		ExpectedProtocol       string
+		ExpectedOffered        []string
+		WantServerError        error
+		WantClientError        error
+	}{
+		{
+			Name:                   "Select last offered protocol",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1", "http/3"},
+			Selector: func(offered []string) (string, error) {
+				return offered[len(offered)-1], nil
+			},
+			ExpectedProtocol: "http/3",
+			ExpectedOffered:  []string{"http/1.1", "spd/1", "http/3"},
+		},
+		{
+			Name:                   "Select no protocol",
+			ClientProtocolNameList: []string{"http/1.1"},
+			Selector: func([]string) (string, error) {
+				return "", nil
+			},
+			ExpectedProtocol: "",
+			ExpectedOffered:  []string{"http/1.1"},
+		},
+		{
+			Name:                   "Reject offered protocols",
+			ClientProtocolNameList: []string{"http/1.1"},
+			Selector: func([]string) (string, error) {
+				return "", errRejected
+			},
+			ExpectedOffered: []string{"http/1.1"},
+			WantServerError: errRejected,
+			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}},
+		},
+		{
+			Name:                   "Select protocol not offered",
+			ClientProtocolNameList: []string{"http/1.1"},
+			Selector: func([]string) (string, error) {
+				return "spd/1", nil
+			},
+			ExpectedOffered: []string{"http/1.1"},
+			WantServerError: errALPNSelectorUnofferedProtocol,
+			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InternalError}},
+		},
+		{
+			Name:                   "Not called without client ALPN",
+			ClientProtocolNameList: nil,
+			Selector: func([]string) (string, error) {
+				return "", errRejected
+			},
+			ExpectedProtocol: "",
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SupportedProtocols: test.ClientProtocolNameList}, true)
+				c <- result{client, err}
+			}()
+
+			var offered []string
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				ALPNSelector: func(o []string) (string, error) {
+					offered = o
+					return test.Selector(o)
+				},
+			}, true)
+			if !errors.Is(err, test.WantServerError) {
+				t.Errorf("Server error mismatch: expected(%v) actual(%v)", test.WantServerError, err)
+			}
+			if !reflect.DeepEqual(offered, test.ExpectedOffered) {
+				t.Errorf("Offered protocols mismatch: expected(%v) actual(%v)", test.ExpectedOffered, offered)
+			}
+
+			res := <-c
+			if !errors.Is(res.err, test.WantClientError) {
+				t.Errorf("Client error mismatch: expected(%v) actual(%v)", test.WantClientError, res.err)
+			}
+			if err != nil || res.err != nil {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+				return
+			}
+
+			if server.ConnectionState().NegotiatedProtocol != test.ExpectedProtocol {
+				t.Errorf("Server negotiated protocol mismatch: expected(%v) actual(%v)", test.ExpectedProtocol, server.ConnectionState().NegotiatedProtocol)
+			}
+			if res.c.ConnectionState().NegotiatedProtocol != test.ExpectedProtocol {
+				t.Errorf("Client negotiated protocol mismatch: expected(%v) actual(%v)", test.ExpectedProtocol, res.c.ConnectionState().NegotiatedProtocol)
+			}
+			_ = server.Close()
+			_ = res.c.Close()
+		})
+	}
+}
+
+func TestApplicationSettings(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                   string
+		ClientProtocolNameList []string
+		ClientSettings         map[string][]byte
+		ServerProtocolNameList []string
+		ServerSettings         map[string][]byte
+		ExpectedProtocol       string
+		ExpectedClientReceived []byte
+		ExpectedServerReceived []byte
+	}{
+		{
+			Name:                   "Negotiated",
+			ClientProtocolNameList: []string{"h2", "http/1.1"},
+			ClientSettings:         map[string][]byte{"h2": {0x01, 0x02}, "http/1.1": {0x09}},
+			ServerProtocolNameList: []string{"h2"},
+			ServerSettings:         map[string][]byte{"h2": {0x03, 0x04}},
+			ExpectedProtocol:       "h2",
+			ExpectedClientReceived: []byte{0x03, 0x04},
+			ExpectedServerReceived: []byte{0x01, 0x02},
+		},
+		{
+			Name:                   "Server without ALPS",
+			ClientProtocolNameList: []string{"h2"},
+			ClientSettings:         map[string][]byte{"h2": {0x01, 0x02}},
+			ServerProtocolNameList: []string{"h2"},
+			ExpectedProtocol:       "h2",
+		},
+		{
+			Name:                   "Client without ALPS",
+			ClientProtocolNameList: []string{"h2"},
+			ServerProtocolNameList: []string{"h2"},
+			ServerSettings:         map[string][]byte{"h2": {0x03, 0x04}},
+			ExpectedProtocol:       "h2",
+		},
+		{
+			Name:                   "No settings for the selected protocol",
+			ClientProtocolNameList: []string{"h2", "http/1.1"},
+			ClientSettings:         map[string][]byte{"h2": {0x01, 0x02}},
+			ServerProtocolNameList: []string{"http/1.1"},
+			ServerSettings:         map[string][]byte{"http/1.1": {0x03, 0x04}},
+			ExpectedProtocol:       "http/1.1",
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					SupportedProtocols:  test.ClientProtocolNameList,
+					ApplicationSettings: test.ClientSettings,
+				}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				SupportedProtocols:  test.ServerProtocolNameList,
+				ApplicationSettings: test.ServerSettings,
+			}, true)
+			res := <-c
+			if err != nil || res.err != nil {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+				t.Fatalf("Handshake failed: server(%v) client(%v)", err, res.err)
+			}
+			defer func() {
+				_ = server.Close()
+				_ = res.c.Close()
+			}()
+
+			serverState, clientState := server.ConnectionState(), res.c.ConnectionState()
+			if clientState.NegotiatedProtocol != test.ExpectedProtocol {
+				t.Errorf("Client negotiated protocol mismatch: expected(%v) actual(%v)", test.ExpectedProtocol, clientState.NegotiatedProtocol)
+			}
+			if !bytes.Equal(clientState.PeerApplicationSettings, test.ExpectedClientReceived) {
+				t.Errorf("Client application settings mismatch: expected(%v) actual(%v)", test.ExpectedClientReceived, clientState.PeerApplicationSettings)
+			}
+			if !bytes.Equal(serverState.PeerApplicationSettings, test.ExpectedServerReceived) {
+				t.Errorf("Server application settings mismatch: expected(%v) actual(%v)", test.ExpectedServerReceived, serverState.PeerApplicationSettings)
+			}
+		})
+	}
+}
+
+func TestSupportedGroupsExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("ServerHello Supported Groups", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
+				t.Error(err)
+			}
+		}()
+		extensions := []extension.Extension{
+			&extension.SupportedEllipticCurves{
+				EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+			},
+			&extension.SupportedPointFormats{
+				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+			},
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		resp := make([]byte, 1024)
+		err := sendClientHello([]byte{}, ca, 0, extensions)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Receive ServerHello
+		n, err := ca.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &recordlayer.RecordLayer{}
+		if err = r.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+
+		helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
+		if !ok {
+			t.Fatal("Failed to cast MessageHelloVerifyRequest")
+		}
+
+		err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err = ca.Read(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := recordlayer.UnpackDatagram(resp[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Unmarshal(messages[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+		if !ok {
+			t.Fatal("Failed to cast MessageServerHello")
+		}
+
+		gotGroups := false
+		for _, v := range serverHello.Extensions {
+			if _, ok := v.(*extension.SupportedEllipticCurves); ok {
+				gotGroups = true
+			}
+		}
+
+		if gotGroups {
+			t.Errorf("TestSupportedGroups: supported_groups extension was sent in ServerHello")
+		}
+	})
+}
+
+func TestSessionResume(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("resumed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
+
+		ss := &memSessStore{}
+
+		id, _ := hex.DecodeString("9b9fc92255634d9fb109febed42166717bb8ded8c738ba71bc7f2a0d9dae0306")
+		secret, _ := hex.DecodeString("2e942a37aca5241deb2295b5fcedac221c7078d2503d2b62aeb48c880d7da73c001238b708559686b9da6e829c05ead7")
+
+		s := Session{ID: id, Secret: secret, ExtendedMasterSecret: true}
+
+		ca, cb := dpipe.Pipe()
+
+		_ = ss.Set(id, s)
+		_ = ss.Set([]byte(ca.RemoteAddr().String()+"_example.com"), s)
+
+		go func() {
+			config := &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				ServerName:   "example.com",
+				SessionStore: ss,
+				MTU:          80,
+			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
+
+		config := &Config{
+			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerName:   "example.com",
+			SessionStore: ss,
+			MTU:          80,
+		}
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatalf("TestSessionResume: Server failed(%v)", err)
+		}
+
+		actualSessionID := server.ConnectionState().SessionID
+		actualMasterSecret := server.ConnectionState().masterSecret
+		if !bytes.Equal(actualSessionID, id) {
+			t.Errorf("TestSessionResumetion: SessionID Mismatch: expected(%v) actual(%v)", id, actualSessionID)
+		}
+		if !bytes.Equal(actualMasterSecret, secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", secret, actualMasterSecret)
+		}
+		if state := server.ConnectionState(); !state.resumed {
+			t.Error("TestSessionResumetion: Expected ConnectionState to report a resumed session")
+		}
+
+		defer func() {
+			_ = server.Close()
+		}()
+
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.c.Close()
+	})
+
+	t.Run("new session", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
+
+		s1 := &memSessStore{}
+		s2 := &memSessStore{}
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			config := &Config{
+				ServerName:   "example.com",
+				SessionStore: s1,
+			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
+
+		config := &Config{
+			SessionStore: s2,
+		}
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatalf("TestSessionResumetion: Server failed(%v)", err)
+		}
+
+		actualSessionID := server.ConnectionState().SessionID
+		actualMasterSecret := server.ConnectionState().masterSecret
+		ss, _ := s2.Get(actualSessionID)
+		if !bytes.Equal(actualMasterSecret, ss.Secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		}
+
+		defer func() {
+			_ = server.Close()
+		}()
+
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		cs, _ := s1.Get([]byte(ca.RemoteAddr().String() + "_example.com"))
+		if !bytes.Equal(actualMasterSecret, cs.Secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		}
+		_ = res.c.Close()
+	})
+}
+
+func TestSessionResumeExtendedMasterSecret(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	id, _ := hex.DecodeString("9b9fc92255634d9fb109febed42166717bb8ded8c738ba71bc7f2a0d9dae0306")
+	secret, _ := hex.DecodeString("2e942a37aca5241deb2295b5fcedac221c7078d2503d2b62aeb48c880d7da73c001238b708559686b9da6e829c05ead7")
+
+	for name, tt := range map[string]struct {
+		clientEMS, serverEMS bool // Whether the stored sessions used the extended master secret
+		clientCfg            ExtendedMasterSecretType
+		expectedClientErr    error
+		expectedServerErr    error
+		expectResumed        bool
+	}{
+		"ClientHelloWithoutExtendedMasterSecret": {
+			clientEMS:         true,
+			serverEMS:         true,
+			clientCfg:         DisableExtendedMasterSecret,
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+			expectedServerErr: errSessionExtendedMasterSecretMismatch,
+		},
+		"ServerHelloWithExtendedMasterSecret": {
+			clientEMS:         false,
+			serverEMS:         true,
+			expectedClientErr: errSessionExtendedMasterSecretMismatch,
+			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+		},
+		"SessionWithoutExtendedMasterSecret": {
+			clientEMS: false,
+			serverEMS: false,
+		},
+		"SessionWithExtendedMasterSecret": {
+			clientEMS:     true,
+			serverEMS:     true,
+			expectResumed: true,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result, 1)
+
+			ca, cb := dpipe.Pipe()
+			clientStore, serverStore := &memSessStore{}, &memSessStore{}
+			_ = clientStore.Set([]byte(ca.RemoteAddr().String()+"_example.com"), Session{ID: id, Secret: secret, ExtendedMasterSecret: tt.clientEMS})
+			_ = serverStore.Set(id, Session{ID: id, Secret: secret, ExtendedMasterSecret: tt.serverEMS})
+
+			go func() {
+				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:         []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					ServerName:           "example.com",
+					SessionStore:         clientStore,
+					ExtendedMasterSecret: tt.clientCfg,
+				}, false)
+				clientRes <- result{c, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				SessionStore: serverStore,
+			}, true)
+			if server != nil {
+				defer func() {
+					_ = server.Close()
+				}()
+			}
+			res := <-clientRes
+			if res.c != nil {
+				defer func() {
+					_ = res.c.Close()
+				}()
+			}
+
+			if !errors.Is(err, tt.expectedServerErr) {
+				t.Fatalf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, err)
+			}
+			if !errors.Is(res.err, tt.expectedClientErr) {
+				t.Fatalf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, res.err)
+			}
+			if err != nil {
+				return
+			}
+			if resumed := bytes.Equal(server.ConnectionState().masterSecret, secret); resumed != tt.expectResumed {
+				t.Fatalf("Session resumed %v, expected %v", resumed, tt.expectResumed)
+			}
+		})
+	}
+}
+
+func TestSessionTicket(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	clientStore := &memSessStore{}
+	sessionKey := func(ca net.Conn) []byte {
+		return []byte(ca.RemoteAddr().String() + "_example.com")
+	}
+
+	handshake := func(t *testing.T, ticketKey []byte) (client, server State, stored Session) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			config := &Config{
+				ServerName:   "example.com",
+				SessionStore: clientStore,
+			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
+
+		s, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SessionTicketKey: ticketKey}, true)
+		if err != nil {
+			t.Fatalf("Server failed(%v)", err)
+		}
+		defer func() {
+			_ = s.Close()
+		}()
+
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		defer func() {
+			_ = res.c.Close()
+		}()
+
+		stored, _ = clientStore.Get(sessionKey(ca))
+		return res.c.ConnectionState(), s.ConnectionState(), stored
+	}
+
+	key := bytes.Repeat([]byte{0x01}, sessionTicketKeyLength)
+
+	client, server, stored := handshake(t, key)
+	if len(stored.Ticket) == 0 {
+		t.Fatal("Expected the client to store a session ticket")
+	}
+	if !bytes.Equal(stored.Secret, client.masterSecret) || !bytes.Equal(client.masterSecret, server.masterSecret) {
+		t.Fatal("Stored master secret does not match the negotiated one")
+	}
+	firstSecret, firstTicket := client.masterSecret, stored.Ticket
+
+	t.Run("resumed", func(t *testing.T) {
+		client, server, stored := handshake(t, key)
+		if !bytes.Equal(client.masterSecret, firstSecret) || !bytes.Equal(server.masterSecret, firstSecret) {
+			t.Error("Expected the session to be resumed from the ticket")
+		}
+		if len(server.SessionID) == 0 || !bytes.Equal(server.SessionID, client.SessionID) {
+			t.Errorf("SessionID Mismatch: client(%x) server(%x)", client.SessionID, server.SessionID)
+		}
+		if !bytes.Equal(stored.Ticket, firstTicket) {
+			t.Error("Expected the ticket to be kept after resumption")
+		}
+	})
+
+	t.Run("rotated key", func(t *testing.T) {
+		client, server, stored := handshake(t, bytes.Repeat([]byte{0x02}, sessionTicketKeyLength))
+		if bytes.Equal(client.masterSecret, firstSecret) {
+			t.Error("Expected a full handshake with a rotated ticket key")
+		}
+		if !bytes.Equal(client.masterSecret, server.masterSecret) {
+			t.Error("Master secret mismatch")
+		}
+		if len(stored.Ticket) == 0 || bytes.Equal(stored.Ticket, firstTicket) {
+			t.Error("Expected the client to store a new session ticket")
+		}
+	})
+}
+
+type memSessStore struct {
+	sync.Map
+}
+
+func (ms *memSessStore) Set(key []byte, s Session) error {
+	k := hex.EncodeToString(key)
+	ms.Store(k, s)
+
+	return nil
+}
+
+func (ms *memSessStore) Get(key []byte) (Session, error) {
+	k := hex.EncodeToString(key)
+
+	v, ok := ms.Load(k)
+	if !ok {
+		return Session{}, nil
+	}
+
+	s, ok := v.(Session)
+	if !ok {
+		return Session{}, nil
+	}
+
+	return s, nil
+}
+
+func (ms *memSessStore) Del(key []byte) error {
+	k := hex.EncodeToString(key)
+	ms.Delete(k)
+
+	return nil
+}
+
+// Assert that the server only uses CipherSuites with a hash+signature that matches
+// the certificate. As specified in rfc5246#section-7.4.3
+func TestCipherSuiteMatchesCertificateType(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name           string
+		cipherList     []CipherSuiteID
+		expectedCipher CipherSuiteID
+		generateRSA    bool
+	}{
+		{
+			Name:           "ECDSA Certificate with RSA CipherSuite first",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			expectedCipher: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:           "RSA Certificate with ECDSA CipherSuite first",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			expectedCipher: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			generateRSA:    true,
+		},
+		{
+			Name:           "RSA Certificate with ChaCha20-Poly1305 CipherSuites",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256, TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256},
+			expectedCipher: TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			generateRSA:    true,
+		},
+		{
+			Name:           "ECDSA Certificate with AES-256-GCM CipherSuites",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			expectedCipher: TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		},
+		{
+			Name:           "RSA Certificate with AES-256-GCM CipherSuites",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			expectedCipher: TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			generateRSA:    true,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			clientErr := make(chan error, 1)
+			client := make(chan *Conn, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.cipherList}, false)
+				clientErr <- err
+				client <- c
+			}()
+
+			var (
+				priv crypto.PrivateKey
+				err  error
+			)
+
+			if test.generateRSA {
+				if priv, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if priv, err = ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			serverCert, err := selfsign.SelfSign(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites: test.cipherList,
+				Certificates: []tls.Certificate{serverCert},
+			}, false); err != nil {
+				t.Fatal(err)
+			} else if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c, err := <-client, <-clientErr; err != nil {
+				t.Fatal(err)
+			} else if err := c.Close(); err != nil {
+				t.Fatal(err)
+			} else if state := c.ConnectionState(); state.CipherSuiteID != test.expectedCipher {
+				t.Fatalf("Expected(%s) and Actual(%s) CipherSuite do not match", test.expectedCipher, state.CipherSuiteID)
+			}
+		})
+	}
+}
+
+func TestEd25519MutualAuth(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	generateEd25519Certificate := func() tls.Certificate {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := selfsign.SelfSign(priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	clientCert := generateEd25519Certificate()
+	serverCert := generateEd25519Certificate()
+
+	for name, tt := range map[string]struct {
+		clientSchemes, serverSchemes []tls.SignatureScheme
+	}{
+		"Default": {},
+		"Ed25519Only": {
+			clientSchemes: []tls.SignatureScheme{tls.Ed25519},
+			serverSchemes: []tls.SignatureScheme{tls.Ed25519},
+		},
+		// The ECDSA schemes are preferred but can't be used with an Ed25519 key
+		"ECDSAPreferred": {
+			clientSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.Ed25519},
+			serverSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256, tls.Ed25519},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					Certificates:     []tls.Certificate{clientCert},
+					SignatureSchemes: tt.clientSchemes,
+				}, false)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates:     []tls.Certificate{serverCert},
+				SignatureSchemes: tt.serverSchemes,
+				ClientAuth:       RequireAnyClientCert,
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+
+			if state := server.ConnectionState(); len(state.PeerCertificates) != 1 || !bytes.Equal(state.PeerCertificates[0], clientCert.Certificate[0]) {
+				t.Fatal("Server did not receive the client certificate")
+			}
+			if state := res.c.ConnectionState(); len(state.PeerCertificates) != 1 || !bytes.Equal(state.PeerCertificates[0], serverCert.Certificate[0]) {
+				t.Fatal("Client did not receive the server certificate")
+			}
+
+			if err := res.c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestECDSACurveSignatureScheme(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for name, tt := range map[string]struct {
+		curve        cryptoElliptic.Curve
+		expectedHash hash.Algorithm
+	}{
+		"P256": {cryptoElliptic.P256(), hash.SHA256},
+		"P384": {cryptoElliptic.P384(), hash.SHA384},
+		"P521": {cryptoElliptic.P521(), hash.SHA512},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			generateECDSACertificate := func() tls.Certificate {
+				priv, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cert, err := selfsign.SelfSign(priv)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return cert
+			}
+			clientCert := generateECDSACertificate()
+			serverCert := generateECDSACertificate()
+
+			ca, cb := dpipe.Pipe()
+
+			// Record the hash of the signatures in ServerKeyExchange and CertificateVerify
+			var (
+				hashesLock sync.Mutex
+				hashes     []hash.Algorithm
+			)
+			recordHashes := func(b []byte) {
+				records, err := recordlayer.UnpackDatagram(b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				hashesLock.Lock()
+				defer hashesLock.Unlock()
+				for _, r := range records {
+					if r[0] != byte(protocol.ContentTypeHandshake) {
+						continue
+					}
+					for body := r[recordlayer.FixedHeaderSize:]; len(body) >= handshake.HeaderLength; {
+						hdr := &handshake.Header{}
+						if err := hdr.Unmarshal(body); err != nil {
+							break
+						}
+						end := handshake.HeaderLength + int(hdr.FragmentLength)
+						if end > len(body) {
+							break
+						}
+						switch hdr.Type { //nolint:exhaustive
+						case handshake.TypeServerKeyExchange:
+							m := &handshake.MessageServerKeyExchange{KeyExchangeAlgorithm: types.KeyExchangeAlgorithmEcdhe}
+							if err := m.Unmarshal(body[handshake.HeaderLength:end]); err == nil {
+								hashes = append(hashes, m.HashAlgorithm)
+							}
+						case handshake.TypeCertificateVerify:
+							m := &handshake.MessageCertificateVerify{}
+							if err := m.Unmarshal(body[handshake.HeaderLength:end]); err == nil {
+								hashes = append(hashes, m.HashAlgorithm)
+							}
+						}
+						body = body[end:]
+					}
+				}
+			}
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(&connWithCallback{Conn: ca, onWrite: recordHashes}), ca.RemoteAddr(), &Config{
+					CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					Certificates: []tls.Certificate{clientCert},
+				}, false)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(&connWithCallback{Conn: cb, onWrite: recordHashes}), cb.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   RequireAnyClientCert,
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			if err := res.c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			hashesLock.Lock()
+			defer hashesLock.Unlock()
+			if len(hashes) < 2 {
+				t.Fatalf("Expected ServerKeyExchange and CertificateVerify, got %d signatures", len(hashes))
+			}
+			for _, h := range hashes {
+				if h != tt.expectedHash {
+					t.Fatalf("Expected signatures using %s, got %s", tt.expectedHash, h)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificateSignatureSchemes(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// Both certificates have a P-256 key, only the signatures of the
+	// certificates themselves differ
+	generate := func(signatureAlgorithm x509.SignatureAlgorithm) tls.Certificate {
+		priv, err := ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := x509.Certificate{
+			SerialNumber:       big.NewInt(1),
+			NotBefore:          time.Now(),
+			NotAfter:           time.Now().AddDate(0, 1, 0),
+			SignatureAlgorithm: signatureAlgorithm,
+			KeyUsage:           x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: priv}
+	}
+	sha256Cert := generate(x509.ECDSAWithSHA256)
+	sha384Cert := generate(x509.ECDSAWithSHA384)
+
+	for name, tt := range map[string]struct {
+		clientCertSchemes []tls.SignatureScheme
+		serverCerts       []tls.Certificate
+		wantCert          *tls.Certificate
+		wantErr           error
+	}{
+		"Unrestricted": {
+			serverCerts: []tls.Certificate{sha256Cert, sha384Cert},
+			wantCert:    &sha256Cert,
+		},
+		"SelectAcceptedChain": {
+			clientCertSchemes: []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384},
+			serverCerts:       []tls.Certificate{sha256Cert, sha384Cert},
+			wantCert:          &sha384Cert,
+		},
+		"RejectChain": {
+			clientCertSchemes: []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384},
+			serverCerts:       []tls.Certificate{sha256Cert},
+			wantErr:           errCertificateSignatureScheme,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+			ca, cb := dpipe.Pipe()
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:                []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					CertificateSignatureSchemes: tt.clientCertSchemes,
+				}, false)
+				c <- result{client, err}
+			}()
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: tt.serverCerts,
+			}, false)
+			res := <-c
+			if tt.wantErr != nil {
+				if !errors.Is(res.err, tt.wantErr) {
+					t.Errorf("Client error expected: \"%v\", got: \"%v\"", tt.wantErr, res.err)
+				}
+				if err == nil {
+					t.Error("Expected the server handshake to fail")
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.err != nil {
+				_ = server.Close()
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = res.c.Close()
+				_ = server.Close()
+			}()
+
+			peerCertificates := res.c.ConnectionState().PeerCertificates
+			if len(peerCertificates) != 1 || !bytes.Equal(peerCertificates[0], tt.wantCert.Certificate[0]) {
+				t.Error("Client received an unexpected certificate")
+			}
+		})
+	}
+}
+
+func generatePSSCertificate() (tls.Certificate, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:       big.NewInt(1),
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().AddDate(0, 1, 0),
+		SignatureAlgorithm: x509.SHA256WithRSAPSS,
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: priv}, nil
+}
+
+func TestRSAPSS(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	clientCert, err := generatePSSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCert, err := generatePSSCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clientSchemes, serverSchemes []tls.SignatureScheme
+	}{
+		"Default": {},
+		"PSSOnly": {
+			clientSchemes: []tls.SignatureScheme{tls.PSSWithSHA256},
+			serverSchemes: []tls.SignatureScheme{tls.PSSWithSHA256},
+		},
+		"PSSWithSHA512": {
+			clientSchemes: []tls.SignatureScheme{tls.PSSWithSHA512},
+			serverSchemes: []tls.SignatureScheme{tls.PSSWithSHA512},
+		},
+		// The server prefers PSS but must sign with a scheme the client offered
+		"ClientOffersPKCS1": {
+			clientSchemes: []tls.SignatureScheme{tls.PKCS1WithSHA256},
+			serverSchemes: []tls.SignatureScheme{tls.PSSWithSHA256, tls.PKCS1WithSHA256},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+					Certificates:     []tls.Certificate{clientCert},
+					SignatureSchemes: tt.clientSchemes,
+				}, false)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				Certificates:     []tls.Certificate{serverCert},
+				SignatureSchemes: tt.serverSchemes,
+				ClientAuth:       RequireAnyClientCert,
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+
+			if state := server.ConnectionState(); len(state.PeerCertificates) != 1 || !bytes.Equal(state.PeerCertificates[0], clientCert.Certificate[0]) {
+				t.Fatal("Server did not receive the client certificate")
+			}
+
+			if err := res.c.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
 	}
 }
 
-func TestClientCertificate(t *testing.T) {
+func TestRequireSCT(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	srvCert, err := selfsign.GenerateSelfSigned()
+	sctList := []byte{0x00, 0x04, 0x00, 0x02, 0x01, 0x02}
+
+	plainCert, err := generateSCTCertificate(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	srvCAPool := x509.NewCertPool()
-	srvCertificate, err := x509.ParseCertificate(srvCert.Certificate[0])
+	embeddedCert, err := generateSCTCertificate(sctList)
 	if err != nil {
 		t.Fatal(err)
 	}
-	srvCAPool.AddCert(srvCertificate)
+	extensionCert := plainCert
+	extensionCert.SignedCertificateTimestamps = [][]byte{{0x01, 0x02}}
+
+	for name, tt := range map[string]struct {
+		serverCert, clientCert       tls.Certificate
+		serverSCTs                   [][]byte
+		clientRequire, serverRequire bool
+		errClient, errServer         error
+		expectedSCTs                 [][]byte
+	}{
+		"NotRequired": {
+			serverCert: plainCert,
+			clientCert: plainCert,
+		},
+		"ServerEmbedded": {
+			serverCert:    embeddedCert,
+			clientCert:    plainCert,
+			clientRequire: true,
+			expectedSCTs:  [][]byte{{0x01, 0x02}},
+		},
+		"ServerExtension": {
+			serverCert:    extensionCert,
+			clientCert:    plainCert,
+			clientRequire: true,
+			expectedSCTs:  [][]byte{{0x01, 0x02}},
+		},
+		"ServerConfig": {
+			serverCert:   plainCert,
+			clientCert:   plainCert,
+			serverSCTs:   [][]byte{{0x03, 0x04, 0x05}, {0x06}},
+			expectedSCTs: [][]byte{{0x03, 0x04, 0x05}, {0x06}},
+		},
+		"ServerCertificateOverConfig": {
+			serverCert:    extensionCert,
+			clientCert:    plainCert,
+			serverSCTs:    [][]byte{{0x03}},
+			clientRequire: true,
+			expectedSCTs:  [][]byte{{0x01, 0x02}},
+		},
+		"ServerMissing": {
+			serverCert:    plainCert,
+			clientCert:    plainCert,
+			clientRequire: true,
+			errClient:     errNoSCT,
+			errServer:     &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}},
+		},
+		"ClientEmbedded": {
+			serverCert:    plainCert,
+			clientCert:    embeddedCert,
+			serverRequire: true,
+		},
+		"ClientMissing": {
+			serverCert:    plainCert,
+			clientCert:    plainCert,
+			serverRequire: true,
+			errClient:     &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}},
+			errServer:     errNoSCT,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
 
-	cert, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					Certificates: []tls.Certificate{tt.clientCert},
+					RequireSCT:   tt.clientRequire,
+				}, false)
+				c <- result{client, err}
+			}()
+
+			server, errServer := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				Certificates:                []tls.Certificate{tt.serverCert},
+				ClientAuth:                  RequireAnyClientCert,
+				RequireSCT:                  tt.serverRequire,
+				SignedCertificateTimestamps: tt.serverSCTs,
+			}, false)
+			res := <-c
+
+			if !errors.Is(res.err, tt.errClient) {
+				t.Fatalf("Client error exp(%v) failed(%v)", tt.errClient, res.err)
+			}
+			if !errors.Is(errServer, tt.errServer) {
+				t.Fatalf("Server error exp(%v) failed(%v)", tt.errServer, errServer)
+			}
+
+			if res.err == nil {
+				if scts := res.c.ConnectionState().SignedCertificateTimestamps; !reflect.DeepEqual(scts, tt.expectedSCTs) {
+					t.Errorf("SCTs mismatch: expected(%v) actual(%v)", tt.expectedSCTs, scts)
+				}
+				_ = res.c.Close()
+			}
+			if errServer == nil {
+				_ = server.Close()
+			}
+		})
 	}
-	certificate, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		t.Fatal(err)
+}
+
+func TestOCSPStapling(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	// Larger than the MTU, the CertificateStatus must be fragmented
+	staple := make([]byte, 2000)
+	for i := range staple {
+		staple[i] = byte(i)
 	}
-	caPool := x509.NewCertPool()
-	caPool.AddCert(certificate)
 
-	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
-		tests := map[string]struct {
-			clientCfg *Config
-			serverCfg *Config
-			wantErr   bool
-		}{
-			"NoClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-				},
-			},
-			"NoClientCert_ServerVerifyConnectionFails": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-					VerifyConnection: func(*State) error {
-						return errExample
-					},
-				},
-				wantErr: true,
-			},
-			"NoClientCert_ClientVerifyConnectionFails": {
-				clientCfg: &Config{RootCAs: srvCAPool, VerifyConnection: func(*State) error {
-					return errExample
-				}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-				},
-				wantErr: true,
-			},
-			"NoClientCert_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
-				},
-			},
-			"RequestClientCert_cert_sigscheme": { // specify signature algorithm
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
-					Certificates:     []tls.Certificate{srvCert},
-					ClientAuth:       RequestClientCert,
-				},
-			},
-			"RequestClientCert_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequestClientCert,
-				},
-			},
-			"RequestClientCert_no_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequestClientCert,
-					ClientCAs:    caPool,
-				},
-			},
-			"RequireAnyClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
-				},
-			},
-			"RequireAnyClientCert_error": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
-				},
-				wantErr: true,
+	for name, tt := range map[string]struct {
+		provider       func(*ClientHelloInfo, *tls.Certificate) ([]byte, error)
+		psk            bool
+		expectedStaple []byte
+		errServer      error
+	}{
+		"Stapled": {
+			provider: func(info *ClientHelloInfo, cert *tls.Certificate) ([]byte, error) {
+				if info.ServerName != "example.com" || cert == nil {
+					return nil, errWrongCert
+				}
+				return staple, nil
 			},
-			"VerifyClientCertIfGiven_no_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-					ClientCAs:    caPool,
-				},
+			expectedStaple: staple,
+		},
+		"NoProvider": {},
+		"NoStaple": {
+			provider: func(*ClientHelloInfo, *tls.Certificate) ([]byte, error) {
+				return nil, nil
 			},
-			"VerifyClientCertIfGiven_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-					ClientCAs:    caPool,
-				},
+		},
+		"ProviderError": {
+			provider: func(*ClientHelloInfo, *tls.Certificate) ([]byte, error) {
+				return nil, errOCSPUnavailable
 			},
-			"VerifyClientCertIfGiven_error": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-				},
-				wantErr: true,
+			errServer: errOCSPUnavailable,
+		},
+		"PSK": {
+			provider: func(*ClientHelloInfo, *tls.Certificate) ([]byte, error) {
+				return staple, nil
 			},
-			"RequireAndVerifyClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}, VerifyConnection: func(s *State) error {
-					if ok := bytes.Equal(s.PeerCertificates[0], srvCertificate.Raw); !ok {
-						return errExample
-					}
+			psk: true,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			var verifiedStaple []byte
+			clientCfg := &Config{
+				ServerName: "example.com",
+				VerifyConnection: func(s *State) error {
+					verifiedStaple = s.OCSPResponse
 					return nil
-				}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAndVerifyClientCert,
-					ClientCAs:    caPool,
-					VerifyConnection: func(s *State) error {
-						if ok := bytes.Equal(s.PeerCertificates[0], certificate.Raw); !ok {
-							return errExample
+				},
+			}
+			serverCfg := &Config{OCSPStapleProvider: tt.provider}
+			if tt.psk {
+				clientCfg.PSK = func([]byte) ([]byte, error) { return []byte{0xAB, 0xC1, 0x23}, nil }
+				clientCfg.PSKIdentityHint = []byte("Client")
+				clientCfg.CipherSuites = []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8}
+				serverCfg.PSK = clientCfg.PSK
+				serverCfg.CipherSuites = clientCfg.CipherSuites
+			}
+
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), clientCfg, false)
+				c <- result{client, err}
+			}()
+
+			server, errServer := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), serverCfg, !tt.psk)
+			if !errors.Is(errServer, tt.errServer) {
+				t.Fatalf("Server error exp(%v) failed(%v)", tt.errServer, errServer)
+			}
+			res := <-c
+			if errServer != nil {
+				if res.err == nil {
+					t.Fatal("Expected client to fail")
+				}
+				return
+			}
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+
+			if !bytes.Equal(verifiedStaple, tt.expectedStaple) {
+				t.Errorf("VerifyConnection got OCSP response of %d bytes, want %d", len(verifiedStaple), len(tt.expectedStaple))
+			}
+			state := res.c.ConnectionState()
+			if !bytes.Equal(state.OCSPResponse, tt.expectedStaple) {
+				t.Errorf("ConnectionState has OCSP response of %d bytes, want %d", len(state.OCSPResponse), len(tt.expectedStaple))
+			}
+			if tt.expectedStaple == nil && state.OCSPResponse != nil {
+				t.Error("Expected no OCSP response")
+			}
+
+			_ = res.c.Close()
+			_ = server.Close()
+		})
+	}
+}
+
+// Test that we return the proper certificate if we are serving multiple ServerNames on a single Server
+func TestMultipleServerCertificates(t *testing.T) {
+	fooCert, err := selfsign.GenerateSelfSignedWithDNS("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	barCert, err := selfsign.GenerateSelfSignedWithDNS("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPool := x509.NewCertPool()
+	for _, cert := range []tls.Certificate{fooCert, barCert} {
+		certificate, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		caPool.AddCert(certificate)
+	}
+
+	for _, test := range []struct {
+		RequestServerName string
+		ExpectedDNSName   string
+	}{
+		{
+			"foo",
+			"foo",
+		},
+		{
+			"bar",
+			"bar",
+		},
+		{
+			"invalid",
+			"foo",
+		},
+	} {
+		test := test
+		t.Run(test.RequestServerName, func(t *testing.T) {
+			clientErr := make(chan error, 2)
+			client := make(chan *Conn, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					RootCAs:    caPool,
+					ServerName: test.RequestServerName,
+					VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+						certificate, err := x509.ParseCertificate(rawCerts[0])
+						if err != nil {
+							return err
+						}
+
+						if certificate.DNSNames[0] != test.ExpectedDNSName {
+							return errWrongCert
 						}
+
 						return nil
 					},
-				},
+				}, false)
+				clientErr <- err
+				client <- c
+			}()
+
+			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{Certificates: []tls.Certificate{fooCert, barCert}}, false); err != nil {
+				t.Fatal(err)
+			} else if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c, err := <-client, <-clientErr; err != nil {
+				t.Fatal(err)
+			} else if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestServerCertificateKeyType(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ecdsaCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, err := selfsign.SelfSign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clientConfig *Config
+		expectRSA    bool
+	}{
+		"RSAClient": {
+			clientConfig: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
 			},
-			"RequireAndVerifyClientCert_callbacks": {
-				clientCfg: &Config{
-					RootCAs: srvCAPool,
-					// Certificates:   []tls.Certificate{cert},
-					GetClientCertificate: func(*CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil },
-				},
-				serverCfg: &Config{
-					GetCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) { return &srvCert, nil },
-					// Certificates:   []tls.Certificate{srvCert},
-					ClientAuth: RequireAndVerifyClientCert,
-					ClientCAs:  caPool,
-				},
+			expectRSA: true,
+		},
+		"RSASignatureSchemes": {
+			clientConfig: &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+				SignatureSchemes: []tls.SignatureScheme{tls.PKCS1WithSHA256},
 			},
-		}
-		for name, tt := range tests {
-			tt := tt
+			expectRSA: true,
+		},
+		"ECDSAClient": {
+			clientConfig: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			},
+		},
+	} {
+		tt := tt
+		// The order of the certificates must not matter
+		for _, certificates := range [][]tls.Certificate{{ecdsaCert, rsaCert}, {rsaCert, ecdsaCert}} {
+			certificates := certificates
 			t.Run(name, func(t *testing.T) {
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+
 				ca, cb := dpipe.Pipe()
 				type result struct {
 					c   *Conn
 					err error
 				}
 				c := make(chan result)
-
+				var gotRSA bool
 				go func() {
-					client, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
-					c <- result{client, err}
-				}()
-
-				server, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
-				res := <-c
-				defer func() {
-					if err == nil {
-						_ = server.Close()
-					}
-					if res.err == nil {
-						_ = res.c.Close()
+					cfg := *tt.clientConfig
+					cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+						leaf, err := x509.ParseCertificate(rawCerts[0])
+						if err != nil {
+							return err
+						}
+						_, gotRSA = leaf.PublicKey.(*rsa.PublicKey)
+						return nil
 					}
+					client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &cfg, false)
+					c <- result{client, err}
 				}()
 
-				if tt.wantErr {
-					if err != nil {
-						// Error expected, test succeeded
-						return
-					}
-					t.Error("Error expected")
-				}
+				server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates: certificates,
+				}, false)
 				if err != nil {
-					t.Errorf("Server failed(%v)", err)
+					t.Fatal(err)
 				}
-
+				res := <-c
 				if res.err != nil {
-					t.Errorf("Client failed(%v)", res.err)
-				}
-
-				actualClientCert := server.ConnectionState().PeerCertificates
-				if tt.serverCfg.ClientAuth == RequireAnyClientCert || tt.serverCfg.ClientAuth == RequireAndVerifyClientCert {
-					if actualClientCert == nil {
-						t.Errorf("Client did not provide a certificate")
-					}
-
-					var cfgCert [][]byte
-					if len(tt.clientCfg.Certificates) > 0 {
-						cfgCert = tt.clientCfg.Certificates[0].Certificate
-					}
-					if tt.clientCfg.GetClientCertificate != nil {
-						crt, err := tt.clientCfg.GetClientCertificate(&CertificateRequestInfo{})
-						if err != nil {
-							t.Errorf("Server configuration did not provide a certificate")
-						}
-						cfgCert = crt.Certificate
-					}
-					if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualClientCert[0]) {
-						t.Errorf("Client certificate was not communicated correctly")
-					}
-				}
-				if tt.serverCfg.ClientAuth == NoClientCert {
-					if actualClientCert != nil {
-						t.Errorf("Client certificate wasn't expected")
-					}
+					t.Fatal(res.err)
 				}
+				_ = res.c.Close()
+				_ = server.Close()
 
-				actualServerCert := res.c.ConnectionState().PeerCertificates
-				if actualServerCert == nil {
-					t.Errorf("Server did not provide a certificate")
-				}
-				var cfgCert [][]byte
-				if len(tt.serverCfg.Certificates) > 0 {
-					cfgCert = tt.serverCfg.Certificates[0].Certificate
-				}
-				if tt.serverCfg.GetCertificate != nil {
-					crt, err := tt.serverCfg.GetCertificate(&ClientHelloInfo{})
-					if err != nil {
-						t.Errorf("Server configuration did not provide a certificate")
-					}
-					cfgCert = crt.Certificate
-				}
-				if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualServerCert[0]) {
-					t.Errorf("Server certificate was not communicated correctly")
+				if gotRSA != tt.expectRSA {
+					t.Errorf("Expected an RSA certificate: %v, got one: %v", tt.expectRSA, gotRSA)
 				}
 			})
 		}
-	})
+	}
 }
 
-func TestConnectionID(t *testing.T) {
+func TestVerifyPeerCertificatePinning(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	clientCID := []byte{5, 77, 33, 24, 93, 27, 45, 81}
-	serverCID := []byte{64, 24, 73, 2, 17, 96, 38, 59}
-	cidEcho := func(echo []byte) func() []byte {
-		return func() []byte {
-			return echo
-		}
+	_, intermediate, pinned, err := generateCertificateChain("example.com")
+	if err != nil {
+		t.Fatal(err)
 	}
-	tests := map[string]struct {
-		clientCfg          *Config
-		serverCfg          *Config
-		clientConnectionID []byte
-		serverConnectionID []byte
+	pinned.Certificate = append(pinned.Certificate, intermediate.Raw)
+	other, err := selfsign.GenerateSelfSignedWithDNS("example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pin := sha256.Sum256(pinned.Leaf.RawSubjectPublicKeyInfo)
+
+	for name, tt := range map[string]struct {
+		certificate tls.Certificate
+		wantErr     bool
 	}{
-		"BidirectionalConnectionIDs": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
-			clientConnectionID: clientCID,
-			serverConnectionID: serverCID,
-		},
-		"BothSupportOnlyClientSends": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(nil),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
-			serverConnectionID: serverCID,
-		},
-		"BothSupportOnlyServerSends": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(nil),
-			},
-			clientConnectionID: clientCID,
-		},
-		"ClientDoesNotSupport": {
-			clientCfg: &Config{},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
-		},
-		"ServerDoesNotSupport": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{},
+		"Pinned": {
+			certificate: pinned,
 		},
-		"NeitherSupport": {
-			clientCfg: &Config{},
-			serverCfg: &Config{},
+		"OtherKey": {
+			certificate: other,
+			wantErr:     true,
 		},
-	}
-	for name, tt := range tests {
+	} {
 		tt := tt
 		t.Run(name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
 			ca, cb := dpipe.Pipe()
 			type result struct {
 				c   *Conn
 				err error
 			}
-			c := make(chan result)
-
+			srvCh := make(chan result)
 			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
-				c <- result{client, err}
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates: []tls.Certificate{tt.certificate},
+				})
+				srvCh <- result{s, err}
 			}()
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
-			if err != nil {
-				t.Fatalf("Unexpected server error: %v", err)
-			}
-			res := <-c
-			if res.err != nil {
-				t.Fatalf("Unexpected client error: %v", res.err)
+			var received [][]byte
+			cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				InsecureSkipVerify: true,
+				VerifyPeerCertificate: func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+					received = rawCerts
+					if verifiedChains != nil {
+						return errors.New("unexpected verified chains") //nolint:goerr113
+					}
+					leaf, err := x509.ParseCertificate(rawCerts[0])
+					if err != nil {
+						return err
+					}
+					if sha256.Sum256(leaf.RawSubjectPublicKeyInfo) != pin {
+						return errWrongCert
+					}
+					return nil
+				},
+			})
+			if !reflect.DeepEqual(received, tt.certificate.Certificate) {
+				t.Error("VerifyPeerCertificate did not receive the certificates as sent by the server")
 			}
-			defer func() {
-				if err == nil {
-					_ = server.Close()
+
+			srv := <-srvCh
+			if tt.wantErr {
+				if !errors.Is(err, errWrongCert) {
+					t.Errorf("Expected error '%v', got '%v'", errWrongCert, err)
 				}
-				if res.err == nil {
-					_ = res.c.Close()
+				var e *alertError
+				if !errors.As(srv.err, &e) || e.Description != alert.BadCertificate {
+					t.Errorf("Expected the server to receive a bad_certificate alert, got '%v'", srv.err)
 				}
-			}()
-
-			if !bytes.Equal(res.c.state.localConnectionID, tt.clientConnectionID) {
-				t.Errorf("Unexpected client local connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, res.c.state.localConnectionID)
-			}
-			if !bytes.Equal(res.c.state.remoteConnectionID, tt.serverConnectionID) {
-				t.Errorf("Unexpected client remote connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, res.c.state.remoteConnectionID)
+				return
 			}
-			if !bytes.Equal(server.state.localConnectionID, tt.serverConnectionID) {
-				t.Errorf("Unexpected server local connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, server.state.localConnectionID)
+			if err != nil {
+				t.Fatal(err)
 			}
-			if !bytes.Equal(server.state.remoteConnectionID, tt.clientConnectionID) {
-				t.Errorf("Unexpected server remote connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, server.state.remoteConnectionID)
+			if srv.err != nil {
+				t.Fatal(srv.err)
 			}
+			_ = cli.Close()
+			_ = srv.c.Close()
 		})
 	}
 }
 
-func TestExtendedMasterSecret(t *testing.T) {
+func TestVerifyPeerCertificateContext(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	tests := map[string]struct {
-		clientCfg         *Config
-		serverCfg         *Config
-		expectedClientErr error
-		expectedServerErr error
+	type traceKey struct{}
+
+	t.Run("Values", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.WithValue(context.Background(), traceKey{}, "trace"), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+		var peerTrace, connectionTrace interface{}
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				VerifyPeerCertificateContext: func(ctx context.Context, _ [][]byte, _ [][]*x509.Certificate) error {
+					peerTrace = ctx.Value(traceKey{})
+					return nil
+				},
+				VerifyConnectionContext: func(ctx context.Context, _ *State) error {
+					connectionTrace = ctx.Value(traceKey{})
+					return nil
+				},
+			}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.c.Close()
+		_ = server.Close()
+
+		if peerTrace != "trace" || connectionTrace != "trace" {
+			t.Errorf("Callbacks did not receive the values of the handshake context: %v, %v", peerTrace, connectionTrace)
+		}
+	})
+
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		serverCtx, serverCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer serverCancel()
+
+		ca, cb := dpipe.Pipe()
+		started := make(chan struct{})
+		aborted := make(chan error, 1)
+		clientErr := make(chan error)
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				VerifyPeerCertificateContext: func(ctx context.Context, _ [][]byte, _ [][]*x509.Certificate) error {
+					close(started)
+					// A slow verification service that only returns when
+					// the handshake is abandoned
+					<-ctx.Done()
+					aborted <- ctx.Err()
+					return ctx.Err()
+				},
+			}, true)
+			if err == nil {
+				_ = client.Close()
+			}
+			clientErr <- err
+		}()
+		go func() {
+			if server, err := testServer(serverCtx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); err == nil {
+				_ = server.Close()
+			}
+		}()
+
+		<-started
+		cancel()
+		if err := <-clientErr; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected error '%v', got '%v'", context.Canceled, err)
+		}
+		if err := <-aborted; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected the verification to be canceled, got '%v'", err)
+		}
+		serverCancel()
+	})
+}
+
+func TestEllipticCurveConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name            string
+		ConfigCurves    []elliptic.Curve
+		HadnshakeCurves []elliptic.Curve
 	}{
-		"Request_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Request_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Request_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			expectedClientErr: errClientRequiredButNoServerEMS,
-			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-		},
-		"Disable_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
+		{
+			Name:            "Curve defaulting",
+			ConfigCurves:    nil,
+			HadnshakeCurves: defaultCurves,
 		},
-		"Disable_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			expectedServerErr: errServerRequiredButNoClientEMS,
+		{
+			Name:            "Single curve",
+			ConfigCurves:    []elliptic.Curve{elliptic.X25519},
+			HadnshakeCurves: []elliptic.Curve{elliptic.X25519},
 		},
-		"Disable_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
+		{
+			Name:            "Multiple curves",
+			ConfigCurves:    []elliptic.Curve{elliptic.P384, elliptic.X25519},
+			HadnshakeCurves: []elliptic.Curve{elliptic.P384, elliptic.X25519},
 		},
-	}
-	for name, tt := range tests {
-		tt := tt
-		t.Run(name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
 
-			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
-				c <- result{client, err}
-			}()
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
+			c <- result{client, err}
+		}()
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
-			res := <-c
-			defer func() {
-				if err == nil {
-					_ = server.Close()
-				}
-				if res.err == nil {
-					_ = res.c.Close()
-				}
-			}()
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
+		if err != nil {
+			t.Fatalf("Server error: %v", err)
+		}
 
-			if !errors.Is(res.err, tt.expectedClientErr) {
-				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, res.err)
+		if len(test.ConfigCurves) == 0 && len(test.HadnshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
+			t.Fatalf("Failed to default Elliptic curves, expected %d, got: %d", len(test.HadnshakeCurves), len(server.fsm.cfg.ellipticCurves))
+		}
+
+		if len(test.ConfigCurves) != 0 {
+			if len(test.HadnshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
+				t.Fatalf("Failed to configure Elliptic curves, expect %d, got %d", len(test.HadnshakeCurves), len(server.fsm.cfg.ellipticCurves))
+			}
+			for i, c := range test.ConfigCurves {
+				if c != server.fsm.cfg.ellipticCurves[i] {
+					t.Fatalf("Failed to maintain Elliptic curve order, expected %s, got %s", c, server.fsm.cfg.ellipticCurves[i])
+				}
 			}
+		}
 
-			if !errors.Is(err, tt.expectedServerErr) {
-				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, err)
+		res := <-c
+		if res.err != nil {
+			t.Fatalf("Client error; %v", err)
+		}
+
+		defer func() {
+			err = server.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = res.c.Close()
+			if err != nil {
+				t.Fatal(err)
 			}
-		})
+		}()
 	}
 }
 
-func TestServerCertificate(t *testing.T) {
+func TestEllipticCurveX448(t *testing.T) {
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cert, err := selfsign.GenerateSelfSigned()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{EllipticCurves: []elliptic.Curve{elliptic.X448}}, true)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{EllipticCurves: []elliptic.Curve{elliptic.X448}}, true)
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("Server error: %v", err)
 	}
-	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	defer func() {
+		_ = server.Close()
+	}()
+
+	res := <-c
+	if res.err != nil {
+		t.Fatalf("Client error: %v", res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+	}()
+
+	for _, conn := range []*Conn{res.c, server} {
+		if conn.state.localKeypair.Curve != elliptic.X448 {
+			t.Errorf("Expected X448 to be negotiated, got %s", conn.state.localKeypair.Curve)
+		}
+		if len(conn.state.localKeypair.PublicKey) != 56 {
+			t.Errorf("Expected 56 byte public key, got %d", len(conn.state.localKeypair.PublicKey))
+		}
+	}
+
+	// The server does not keep its premaster secret, derive it again from the exchanged keys
+	serverPreMasterSecret, err := prf.PreMasterSecret(res.c.state.localKeypair.PublicKey, server.state.localKeypair.PrivateKey, elliptic.X448)
 	if err != nil {
 		t.Fatal(err)
 	}
-	caPool := x509.NewCertPool()
-	caPool.AddCert(certificate)
+	if len(serverPreMasterSecret) != 56 || !bytes.Equal(serverPreMasterSecret, res.c.state.preMasterSecret) {
+		t.Errorf("Premaster secret mismatch\nclient: %x\nserver: %x", res.c.state.preMasterSecret, serverPreMasterSecret)
+	}
+	if !bytes.Equal(server.state.masterSecret, res.c.state.masterSecret) {
+		t.Error("Master secret mismatch")
+	}
+}
 
-	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
-		tests := map[string]struct {
-			clientCfg *Config
-			serverCfg *Config
-			wantErr   bool
-		}{
-			"no_ca": {
-				clientCfg: &Config{},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
-			"good_ca": {
-				clientCfg: &Config{RootCAs: caPool},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"no_ca_skip_verify": {
-				clientCfg: &Config{InsecureSkipVerify: true},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"good_ca_skip_verify_custom_verify_peer": {
-				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: RequireAnyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
-					if len(chain) != 0 {
-						return errNotExpectedChain
-					}
-					return nil
-				}},
-			},
-			"good_ca_verify_custom_verify_peer": {
-				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{ClientCAs: caPool, Certificates: []tls.Certificate{cert}, ClientAuth: RequireAndVerifyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
-					if len(chain) == 0 {
-						return errExpecedChain
-					}
-					return nil
-				}},
-			},
-			"good_ca_custom_verify_peer": {
-				clientCfg: &Config{
-					RootCAs: caPool,
-					VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
-						return errWrongCert
-					},
-				},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
-			"server_name": {
-				clientCfg: &Config{RootCAs: caPool, ServerName: certificate.Subject.CommonName},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"server_name_error": {
-				clientCfg: &Config{RootCAs: caPool, ServerName: "barfoo"},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
-		}
-		for name, tt := range tests {
-			tt := tt
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
+func TestEllipticCurveP521(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-				type result struct {
-					c   *Conn
-					err error
-				}
-				srvCh := make(chan result)
-				go func() {
-					s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
-					srvCh <- result{s, err}
-				}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-				cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
-				if err == nil {
-					_ = cli.Close()
-				}
-				if !tt.wantErr && err != nil {
-					t.Errorf("Client failed(%v)", err)
-				}
-				if tt.wantErr && err == nil {
-					t.Fatal("Error expected")
-				}
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
 
-				srv := <-srvCh
-				if srv.err == nil {
-					_ = srv.c.Close()
-				}
-			})
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{EllipticCurves: []elliptic.Curve{elliptic.P521}}, true)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{EllipticCurves: []elliptic.Curve{elliptic.P521}}, true)
+	if err != nil {
+		t.Fatalf("Server error: %v", err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	res := <-c
+	if res.err != nil {
+		t.Fatalf("Client error: %v", res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+	}()
+
+	for _, conn := range []*Conn{res.c, server} {
+		if conn.state.localKeypair.Curve != elliptic.P521 {
+			t.Errorf("Expected P-521 to be negotiated, got %s", conn.state.localKeypair.Curve)
 		}
-	})
+		if len(conn.state.localKeypair.PublicKey) != 133 {
+			t.Errorf("Expected 133 byte public key, got %d", len(conn.state.localKeypair.PublicKey))
+		}
+	}
+
+	// The server does not keep its premaster secret, derive it again from the exchanged keys
+	serverPreMasterSecret, err := prf.PreMasterSecret(res.c.state.localKeypair.PublicKey, server.state.localKeypair.PrivateKey, elliptic.P521)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(serverPreMasterSecret) != 66 || !bytes.Equal(serverPreMasterSecret, res.c.state.preMasterSecret) {
+		t.Errorf("Premaster secret mismatch\nclient: %x\nserver: %x", res.c.state.preMasterSecret, serverPreMasterSecret)
+	}
+	if !bytes.Equal(server.state.masterSecret, res.c.state.masterSecret) {
+		t.Error("Master secret mismatch")
+	}
 }
 
-func TestCipherSuiteConfiguration(t *testing.T) {
+func TestConfigRand(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name                    string
-		ClientCipherSuites      []CipherSuiteID
-		ServerCipherSuites      []CipherSuiteID
-		WantClientError         error
-		WantServerError         error
-		WantSelectedCipherSuite CipherSuiteID
-	}{
-		{
-			Name:               "No CipherSuites specified",
-			ClientCipherSuites: nil,
-			ServerCipherSuites: nil,
-			WantClientError:    nil,
-			WantServerError:    nil,
-		},
-		{
-			Name:               "Invalid CipherSuite",
-			ClientCipherSuites: []CipherSuiteID{0x00},
-			ServerCipherSuites: []CipherSuiteID{0x00},
-			WantClientError:    &invalidCipherSuiteError{0x00},
-			WantServerError:    &invalidCipherSuiteError{0x00},
-		},
-		{
-			Name:                    "Valid CipherSuites specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
-		{
-			Name:               "CipherSuites mismatch",
-			ClientCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			WantClientError:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			WantServerError:    errCipherSuiteNoIntersection,
-		},
-		{
-			Name:                    "Valid CipherSuites CCM specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
-		},
-		{
-			Name:                    "Valid CipherSuites CCM-8 specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
-		},
-		{
-			Name:                    "Server supports subset of client suites",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-		},
-	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
+	captureClientHello := func(seed int64) []byte {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
+		ca, cb := dpipe.Pipe()
+		clientErr := make(chan error, 1)
+		go func() {
+			_, err := ClientWithContext(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				InsecureSkipVerify: true,
+				Rand:               mathRand.New(mathRand.NewSource(seed)), //nolint:gosec
+			})
+			clientErr <- err
+		}()
 
-			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.ClientCipherSuites}, true)
-				c <- result{client, err}
-			}()
+		buf := make([]byte, 8192)
+		n, err := cb.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: test.ServerCipherSuites}, true)
-			if err == nil {
-				defer func() {
-					_ = server.Close()
-				}()
-			}
-			if !errors.Is(err, test.WantServerError) {
-				t.Errorf("TestCipherSuiteConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
-			}
+		cancel()
+		if err := <-clientErr; err == nil {
+			t.Fatal("Expected handshake to be aborted")
+		}
+		_ = cb.Close()
 
-			res := <-c
-			if res.err == nil {
-				_ = server.Close()
-				_ = res.c.Close()
-			}
-			if !errors.Is(res.err, test.WantClientError) {
-				t.Errorf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
-			}
-			if test.WantSelectedCipherSuite != 0x00 && res.c.state.cipherSuite.ID() != test.WantSelectedCipherSuite {
-				t.Errorf("TestCipherSuiteConfiguration: Server Selected Bad Cipher Suite '%s': expected(%v) actual(%v)", test.Name, test.WantSelectedCipherSuite, res.c.state.cipherSuite.ID())
-			}
-		})
+		// gmt_unix_time is taken from the clock and not from Rand
+		gmtUnixTimeOffset := recordlayer.FixedHeaderSize + handshake.HeaderLength + 2
+		copy(buf[gmtUnixTimeOffset:gmtUnixTimeOffset+4], []byte{0, 0, 0, 0})
+		return buf[:n]
+	}
+
+	first, second := captureClientHello(1), captureClientHello(1)
+	if !bytes.Equal(first, second) {
+		t.Errorf("ClientHello differs with the same Rand\nfirst: %x\nsecond: %x", first, second)
+	}
+	if other := captureClientHello(2); bytes.Equal(first, other) {
+		t.Error("ClientHello does not depend on Rand")
 	}
 }
 
-func TestCertificateAndPSKServer(t *testing.T) {
+func TestConfigTime(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name      string
-		ClientPSK bool
+	// The certificate expired long ago according to time.Now
+	notBefore := time.Date(2010, time.January, 1, 0, 0, 0, 0, time.UTC)
+	priv, err := ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "expired"},
+		DNSNames:     []string{"expired"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: priv}
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	injectedTime := func() time.Time { return notBefore.AddDate(0, 6, 0) }
+
+	for name, tt := range map[string]struct {
+		clientTime, serverTime func() time.Time
+		expiredClient          bool
+		expiredServer          bool
 	}{
-		{
-			Name:      "Client uses PKI",
-			ClientPSK: false,
+		"InjectedClock": {
+			clientTime: injectedTime,
+			serverTime: injectedTime,
 		},
-		{
-			Name:      "Client uses PSK",
-			ClientPSK: true,
+		"ClientSystemClock": {
+			serverTime:    injectedTime,
+			expiredClient: true,
+		},
+		"ServerSystemClock": {
+			clientTime:    injectedTime,
+			expiredServer: true,
 		},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ca, cb := dpipe.Pipe()
 			type result struct {
 				c   *Conn
@@ -1619,268 +8452,238 @@ func TestCertificateAndPSKServer(t *testing.T) {
 			c := make(chan result)
 
 			go func() {
-				config := &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
-				if test.ClientPSK {
-					config.PSK = func([]byte) ([]byte, error) {
-						return []byte{0x00, 0x01, 0x02}, nil
-					}
-					config.PSKIdentityHint = []byte{0x00}
-					config.CipherSuites = []CipherSuiteID{TLS_PSK_WITH_AES_128_GCM_SHA256}
-				}
-
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+				client, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					Certificates: []tls.Certificate{cert},
+					RootCAs:      caPool,
+					ServerName:   "expired",
+					Time:         tt.clientTime,
+				})
 				c <- result{client, err}
 			}()
 
-			config := &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256},
-				PSK: func([]byte) ([]byte, error) {
-					return []byte{0x00, 0x01, 0x02}, nil
-				},
-			}
+			server, errServer := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				Certificates: []tls.Certificate{cert},
+				ClientAuth:   RequireAndVerifyClientCert,
+				ClientCAs:    caPool,
+				Time:         tt.serverTime,
+			})
+			res := <-c
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-			if err == nil {
-				defer func() {
-					_ = server.Close()
-				}()
-			} else {
-				t.Errorf("TestCertificateAndPSKServer: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, err)
+			var certErr x509.CertificateInvalidError
+			switch {
+			case tt.expiredClient:
+				if !errors.As(res.err, &certErr) || certErr.Reason != x509.Expired {
+					t.Fatalf("Client error exp(certificate expired) failed(%v)", res.err)
+				}
+			case tt.expiredServer:
+				if !errors.As(errServer, &certErr) || certErr.Reason != x509.Expired {
+					t.Fatalf("Server error exp(certificate expired) failed(%v)", errServer)
+				}
+			default:
+				if res.err != nil {
+					t.Fatal(res.err)
+				}
+				if errServer != nil {
+					t.Fatal(errServer)
+				}
 			}
 
-			res := <-c
 			if res.err == nil {
-				_ = server.Close()
 				_ = res.c.Close()
-			} else {
-				t.Errorf("TestCertificateAndPSKServer: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, res.err)
+			}
+			if errServer == nil {
+				_ = server.Close()
 			}
 		})
 	}
 }
 
-func TestPSKConfiguration(t *testing.T) {
-	// Check for leaking routines
-	report := test.CheckRoutines(t)
-	defer report()
-
-	for _, test := range []struct {
-		Name                 string
-		ClientHasCertificate bool
-		ServerHasCertificate bool
-		ClientPSK            PSKCallback
-		ServerPSK            PSKCallback
-		ClientPSKIdentity    []byte
-		ServerPSKIdentity    []byte
-		WantClientError      error
-		WantServerError      error
+func TestSkipHelloVerify(t *testing.T) {
+	for name, tt := range map[string]struct {
+		clientConnectionIDGenerator func() []byte
+		serverConnectionIDGenerator func() []byte
+		expectHelloVerify           bool
 	}{
-		{
-			Name:                 "PSK and no certificate specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errNoAvailablePSKCipherSuite,
-			WantServerError:      errNoAvailablePSKCipherSuite,
-		},
-		{
-			Name:                 "PSK and certificate specified",
-			ClientHasCertificate: true,
-			ServerHasCertificate: true,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errNoAvailablePSKCipherSuite,
-			WantServerError:      errNoAvailablePSKCipherSuite,
+		"ConnectionID": {
+			clientConnectionIDGenerator: RandomCIDGenerator(8),
+			serverConnectionIDGenerator: RandomCIDGenerator(8),
 		},
-		{
-			Name:                 "PSK and no identity specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    nil,
-			ServerPSKIdentity:    nil,
-			WantClientError:      errPSKAndIdentityMustBeSetForClient,
-			WantServerError:      errNoAvailablePSKCipherSuite,
+		"EmptyConnectionID": {
+			clientConnectionIDGenerator: OnlySendCIDGenerator(),
+			serverConnectionIDGenerator: RandomCIDGenerator(8),
+			expectHelloVerify:           true,
 		},
-		{
-			Name:                 "No PSK and identity specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            nil,
-			ServerPSK:            nil,
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errIdentityNoPSK,
-			WantServerError:      errIdentityNoPSK,
+		"NoConnectionID": {
+			expectHelloVerify: true,
 		},
 	} {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			report := test.CheckRoutines(t)
+			defer report()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-		ca, cb := dpipe.Pipe()
-		type result struct {
-			c   *Conn
-			err error
-		}
-		c := make(chan result)
+			ca, cb := dpipe.Pipe()
+			certificate, err := selfsign.GenerateSelfSigned()
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotHello := make(chan struct{})
 
-		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{PSK: test.ClientPSK, PSKIdentityHint: test.ClientPSKIdentity}, test.ClientHasCertificate)
-			c <- result{client, err}
-		}()
+			go func() {
+				server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates:            []tls.Certificate{certificate},
+					LoggerFactory:           logging.NewDefaultLoggerFactory(),
+					InsecureSkipVerifyHello: true,
+					ConnectionIDGenerator:   tt.serverConnectionIDGenerator,
+				}, false)
+				if sErr != nil {
+					t.Error(sErr)
+					return
+				}
+				buf := make([]byte, 1024)
+				if _, sErr = server.Read(buf); sErr != nil {
+					t.Error(sErr)
+				}
+				gotHello <- struct{}{}
+				if sErr = server.Close(); sErr != nil { //nolint:contextcheck
+					t.Error(sErr)
+				}
+			}()
 
-		_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{PSK: test.ServerPSK, PSKIdentityHint: test.ServerPSKIdentity}, test.ServerHasCertificate)
-		if err != nil || test.WantServerError != nil {
-			if !(err != nil && test.WantServerError != nil && err.Error() == test.WantServerError.Error()) {
-				t.Fatalf("TestPSKConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			clientConn := &clientHelloCounter{PacketConn: dtlsnet.PacketConnFromConn(ca)}
+			client, err := testClient(ctx, clientConn, ca.RemoteAddr(), &Config{
+				LoggerFactory:         logging.NewDefaultLoggerFactory(),
+				InsecureSkipVerify:    true,
+				ConnectionIDGenerator: tt.clientConnectionIDGenerator,
+			}, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotHelloVerify := len(client.state.cookie) != 0; gotHelloVerify != tt.expectHelloVerify {
+				t.Errorf("Expected HelloVerifyRequest %v, got %v", tt.expectHelloVerify, gotHelloVerify)
+			}
+			// Skipping the HelloVerifyRequest saves the round trip of the
+			// second ClientHello carrying the cookie
+			expectedClientHellos := int32(1)
+			if tt.expectHelloVerify {
+				expectedClientHellos = 2
+			}
+			if count := atomic.LoadInt32(&clientConn.count); count != expectedClientHellos {
+				t.Errorf("Expected %d ClientHello flights, got %d", expectedClientHellos, count)
+			}
+			if _, err = client.Write([]byte("hello")); err != nil {
+				t.Error(err)
+			}
+			select {
+			case <-gotHello:
+				// OK
+			case <-time.After(time.Second * 5):
+				t.Error("timeout")
 			}
-		}
 
-		res := <-c
-		if res.err != nil || test.WantClientError != nil {
-			if !(res.err != nil && test.WantClientError != nil && res.err.Error() == test.WantClientError.Error()) {
-				t.Fatalf("TestPSKConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			if err = client.Close(); err != nil {
+				t.Error(err)
 			}
-		}
+		})
 	}
 }
 
-func TestServerTimeout(t *testing.T) {
-	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
-	defer lim.Stop()
-
-	// Check for leaking routines
-	report := test.CheckRoutines(t)
-	defer report()
-
-	cookie := make([]byte, 20)
-	_, err := rand.Read(cookie)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	var rand [28]byte
-	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
-
-	cipherSuites := []CipherSuite{
-		&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
-		&ciphersuite.TLSEcdheRsaWithAes128GcmSha256{},
-	}
+// clientHelloCounter counts the ClientHello messages written to the wrapped
+// PacketConn
+type clientHelloCounter struct {
+	net.PacketConn
+	count int32
+}
 
-	extensions := []extension.Extension{
-		&extension.SupportedSignatureAlgorithms{
-			SignatureHashAlgorithms: []signaturehash.Algorithm{
-				{Hash: hash.SHA256, Signature: signature.ECDSA},
-				{Hash: hash.SHA384, Signature: signature.ECDSA},
-				{Hash: hash.SHA512, Signature: signature.ECDSA},
-				{Hash: hash.SHA256, Signature: signature.RSA},
-				{Hash: hash.SHA384, Signature: signature.RSA},
-				{Hash: hash.SHA512, Signature: signature.RSA},
-			},
-		},
-		&extension.SupportedEllipticCurves{
-			EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
-		},
-		&extension.SupportedPointFormats{
-			PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
-		},
+func (c *clientHelloCounter) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if len(p) > recordlayer.FixedHeaderSize &&
+		protocol.ContentType(p[0]) == protocol.ContentTypeHandshake &&
+		handshake.Type(p[recordlayer.FixedHeaderSize]) == handshake.TypeClientHello {
+		atomic.AddInt32(&c.count, 1)
 	}
+	return c.PacketConn.WriteTo(p, addr)
+}
 
-	record := &recordlayer.RecordLayer{
-		Header: recordlayer.Header{
-			SequenceNumber: 0,
-			Version:        protocol.Version1_2,
-		},
-		Content: &handshake.Handshake{
-			// sequenceNumber and messageSequence line up, may need to be re-evaluated
-			Header: handshake.Header{
-				MessageSequence: 0,
-			},
-			Message: &handshake.MessageClientHello{
-				Version:            protocol.Version1_2,
-				Cookie:             cookie,
-				Random:             random,
-				CipherSuiteIDs:     cipherSuiteIDs(cipherSuites),
-				CompressionMethods: defaultCompressionMethods(),
-				Extensions:         extensions,
-			},
-		},
-	}
+func TestReadBuffer(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-	packet, err := record.Marshal()
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb, err := pipeMemory()
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	ca, cb := dpipe.Pipe()
 	defer func() {
-		err := ca.Close()
-		if err != nil {
-			t.Fatal(err)
-		}
+		_ = ca.Close()
+		_ = cb.Close()
 	}()
 
-	// Client reader
-	caReadChan := make(chan []byte, 1000)
+	records := [][]byte{
+		[]byte("first"),
+		bytes.Repeat([]byte{0x02}, 1000),
+		[]byte("read"),
+	}
+	writeErr := make(chan error, 1)
 	go func() {
-		for {
-			data := make([]byte, 8192)
-			n, err := ca.Read(data)
-			if err != nil {
+		for _, record := range records {
+			if _, err := ca.Write(record); err != nil {
+				writeErr <- err
 				return
 			}
-
-			caReadChan <- data[:n]
 		}
+		writeErr <- nil
 	}()
 
-	// Start sending ClientHello packets until server responds with first packet
-	go func() {
-		for {
-			select {
-			case <-time.After(10 * time.Millisecond):
-				_, err := ca.Write(packet)
-				if err != nil {
-					return
-				}
-			case <-caReadChan:
-				// Once we receive the first reply from the server, stop
-				return
-			}
+	for i, expected := range records[:2] {
+		actual, err := cb.ReadBuffer()
+		if err != nil {
+			t.Fatal(err)
 		}
-	}()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
-	defer cancel()
+		if !bytes.Equal(actual, expected) {
+			t.Errorf("Record %d mismatch: expected(%d bytes) actual(%d bytes)", i, len(expected), len(actual))
+		}
+		if cb.lentReadBuffer == nil {
+			t.Fatal("Expected the buffer to be lent until the next read")
+		}
+	}
 
-	config := &Config{
-		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		FlightInterval: 100 * time.Millisecond,
+	// Read recycles the buffer of the last ReadBuffer
+	buf := make([]byte, 100)
+	n, err := cb.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], records[2]) {
+		t.Errorf("Read mismatch: expected(%s) actual(%s)", records[2], buf[:n])
+	}
+	if cb.lentReadBuffer != nil {
+		t.Error("Expected Read to recycle the buffer of ReadBuffer")
 	}
 
-	_, serverErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-	var netErr net.Error
-	if !errors.As(serverErr, &netErr) || !netErr.Timeout() {
-		t.Fatalf("Client error exp(Temporary network error) failed(%v)", serverErr)
+	if err := <-writeErr; err != nil {
+		t.Fatal(err)
 	}
+}
 
-	// Wait a little longer to ensure no additional messages have been sent by the server
-	time.Sleep(300 * time.Millisecond)
-	select {
-	case msg := <-caReadChan:
-		t.Fatalf("Expected no additional messages from server, got: %+v", msg)
-	default:
+type connWithCallback struct {
+	net.Conn
+	onWrite func([]byte)
+}
+
+func (c *connWithCallback) Write(b []byte) (int, error) {
+	if c.onWrite != nil {
+		c.onWrite(b)
 	}
+	return c.Conn.Write(b)
 }
 
-func TestProtocolVersionValidation(t *testing.T) {
+func TestConnectionStateVersion(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -1889,227 +8692,141 @@ func TestProtocolVersionValidation(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cookie := make([]byte, 20)
-	if _, err := rand.Read(cookie); err != nil {
+	ca, cb, err := pipeMemory()
+	if err != nil {
 		t.Fatal(err)
 	}
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
 
-	var rand [28]byte
-	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
-
-	config := &Config{
-		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		FlightInterval: 100 * time.Millisecond,
-	}
-
-	t.Run("Server", func(t *testing.T) {
-		serverCases := map[string]struct {
-			records []*recordlayer.RecordLayer
-		}{
-			"ClientHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-				},
-			},
-			"SecondsClientHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version1_2,
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-					{
-						Header: recordlayer.Header{
-							Version:        protocol.Version1_2,
-							SequenceNumber: 1,
-						},
-						Content: &handshake.Handshake{
-							Header: handshake.Header{
-								MessageSequence: 1,
-							},
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-				},
-			},
+	for name, c := range map[string]*Conn{"client": ca, "server": cb} {
+		if v := c.ConnectionState().Version; !v.Equal(protocol.Version1_2) {
+			t.Errorf("%s: version mismatch: expected(%v) actual(%v)", name, protocol.Version1_2, v)
 		}
-		for name, c := range serverCases {
-			c := c
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-				defer func() {
-					err := ca.Close()
-					if err != nil {
-						t.Error(err)
-					}
-				}()
-
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
+	}
 
-				var wg sync.WaitGroup
-				wg.Add(1)
-				defer wg.Wait()
-				go func() {
-					defer wg.Done()
-					if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
-						t.Errorf("Client error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
-					}
-				}()
+	// Pretend the handshake is still in progress
+	ca.handshakeCompletedSuccessfully.Store(struct{ bool }{false})
+	if v := ca.ConnectionState().Version; v != (protocol.Version{}) {
+		t.Errorf("Expected zero version before handshake completion, got %v", v)
+	}
+	ca.handshakeCompletedSuccessfully.Store(struct{ bool }{true})
 
-				time.Sleep(50 * time.Millisecond)
+	// The version survives serialization
+	raw, err := cb.state.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored State
+	if err := restored.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !restored.Version.Equal(protocol.Version1_2) {
+		t.Errorf("Restored version mismatch: expected(%v) actual(%v)", protocol.Version1_2, restored.Version)
+	}
+}
 
-				resp := make([]byte, 1024)
-				for _, record := range c.records {
-					packet, err := record.Marshal()
-					if err != nil {
-						t.Fatal(err)
-					}
-					if _, werr := ca.Write(packet); werr != nil {
-						t.Fatal(werr)
-					}
-					n, rerr := ca.Read(resp[:cap(resp)])
-					if rerr != nil {
-						t.Fatal(rerr)
-					}
-					resp = resp[:n]
-				}
+func TestConnectionStateJSON(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-				h := &recordlayer.Header{}
-				if err := h.Unmarshal(resp); err != nil {
-					t.Fatal("Failed to unmarshal response")
-				}
-				if h.ContentType != protocol.ContentTypeAlert {
-					t.Errorf("Peer must return alert to unsupported protocol version")
-				}
-			})
-		}
-	})
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-	t.Run("Client", func(t *testing.T) {
-		clientCases := map[string]struct {
-			records []*recordlayer.RecordLayer
-		}{
-			"ServerHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageHelloVerifyRequest{
-								Version: protocol.Version1_2,
-								Cookie:  cookie,
-							},
-						},
-					},
-					{
-						Header: recordlayer.Header{
-							Version:        protocol.Version1_2,
-							SequenceNumber: 1,
-						},
-						Content: &handshake.Handshake{
-							Header: handshake.Header{
-								MessageSequence: 1,
-							},
-							Message: &handshake.MessageServerHello{
-								Version:           protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Random:            random,
-								CipherSuiteID:     func() *uint16 { id := uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); return &id }(),
-								CompressionMethod: defaultCompressionMethods()[0],
-							},
-						},
-					},
-				},
-			},
-		}
-		for name, c := range clientCases {
-			c := c
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-				defer func() {
-					err := ca.Close()
-					if err != nil {
-						t.Error(err)
-					}
-				}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
+	ca, cb := dpipe.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
 
-				var wg sync.WaitGroup
-				wg.Add(1)
-				defer wg.Wait()
-				go func() {
-					defer wg.Done()
-					if _, err := testClient(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
-						t.Errorf("Server error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
-					}
-				}()
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			CipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerName:         "example.com",
+			SupportedProtocols: []string{"h2"},
+		}, true)
+		c <- result{client, err}
+	}()
 
-				time.Sleep(50 * time.Millisecond)
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+		CipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SupportedProtocols: []string{"h2"},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+		_ = server.Close()
+	}()
 
-				for _, record := range c.records {
-					if _, err := ca.Read(make([]byte, 1024)); err != nil {
-						t.Fatal(err)
-					}
+	state := res.c.ConnectionState()
+	raw, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-					packet, err := record.Marshal()
-					if err != nil {
-						t.Fatal(err)
-					}
-					if _, err := ca.Write(packet); err != nil {
-						t.Fatal(err)
-					}
-				}
-				resp := make([]byte, 1024)
-				n, err := ca.Read(resp)
-				if err != nil {
-					t.Fatal(err)
-				}
-				resp = resp[:n]
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(raw, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]interface{}{
+		"cipher_suite":           "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+		"version":                "DTLS 1.2",
+		"server_name":            "example.com",
+		"negotiated_protocol":    "h2",
+		"resumed":                false,
+		"extended_master_secret": true,
+		"connection_id":          false,
+	} {
+		if actual := decoded[key]; actual != expected {
+			t.Errorf("%s mismatch: expected(%v) actual(%v)", key, expected, actual)
+		}
+	}
+	if certs, ok := decoded["peer_certificates"].([]interface{}); !ok || len(certs) != 1 {
+		t.Errorf("Expected one peer certificate subject, got %v", decoded["peer_certificates"])
+	}
 
-				h := &recordlayer.Header{}
-				if err := h.Unmarshal(resp); err != nil {
-					t.Fatal("Failed to unmarshal response")
-				}
-				if h.ContentType != protocol.ContentTypeAlert {
-					t.Errorf("Peer must return alert to unsupported protocol version")
-				}
-			})
+	// The server reports the name the client asked for
+	serverState := server.ConnectionState()
+	serverRaw, err := json.Marshal(&serverState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(serverRaw, []byte(`"server_name":"example.com"`)) {
+		t.Errorf("Expected server name in %s", serverRaw)
+	}
+
+	// No form of the master secret may leak
+	for _, secret := range [][]byte{
+		state.masterSecret,
+		[]byte(hex.EncodeToString(state.masterSecret)),
+		[]byte(base64.StdEncoding.EncodeToString(state.masterSecret)),
+	} {
+		if bytes.Contains(raw, secret) {
+			t.Fatalf("Master secret found in %s", raw)
 		}
-	})
+	}
+	for _, key := range []string{"master_secret", "MasterSecret", "masterSecret"} {
+		if _, ok := decoded[key]; ok {
+			t.Fatalf("Unexpected %s field in %s", key, raw)
+		}
+	}
 }
 
-func TestMultipleHelloVerifyRequest(t *testing.T) {
+func TestCloseNotifyTimeout(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2118,196 +8835,201 @@ func TestMultipleHelloVerifyRequest(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cookies := [][]byte{
-		// first clientHello contains an empty cookie
-		{},
-	}
-	var packets [][]byte
-	for i := 0; i < 2; i++ {
-		cookie := make([]byte, 20)
-		if _, err := rand.Read(cookie); err != nil {
-			t.Fatal(err)
-		}
-		cookies = append(cookies, cookie)
+	const timeout = 200 * time.Millisecond
 
-		record := &recordlayer.RecordLayer{
-			Header: recordlayer.Header{
-				SequenceNumber: uint64(i),
-				Version:        protocol.Version1_2,
-			},
-			Content: &handshake.Handshake{
-				Header: handshake.Header{
-					MessageSequence: uint16(i),
-				},
-				Message: &handshake.MessageHelloVerifyRequest{
-					Version: protocol.Version1_2,
-					Cookie:  cookie,
-				},
-			},
+	handshake := func(t *testing.T) (*Conn, *Conn) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
 		}
-		packet, err := record.Marshal()
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CloseNotifyTimeout: timeout}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
 		if err != nil {
 			t.Fatal(err)
 		}
-		packets = append(packets, packet)
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.c, server
 	}
 
-	ca, cb := dpipe.Pipe()
-	defer func() {
-		err := ca.Close()
-		if err != nil {
-			t.Error(err)
+	// Nobody calls Read on the server, so its read loop blocks delivering
+	// the second record and never sees the close_notify queued behind it
+	stallReader := func(t *testing.T, c *Conn) {
+		for i := 0; i < 2; i++ {
+			if _, err := c.Write([]byte("data")); err != nil {
+				t.Fatal(err)
+			}
 		}
-	}()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+	}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	defer wg.Wait()
-	go func() {
-		defer wg.Done()
-		_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
-	}()
+	t.Run("Acknowledged", func(t *testing.T) {
+		client, server := handshake(t)
+		defer func() {
+			_ = server.Close()
+		}()
 
-	for i, cookie := range cookies {
-		// read client hello
-		resp := make([]byte, 1024)
-		n, err := cb.Read(resp)
-		if err != nil {
+		start := time.Now()
+		if err := client.Close(); err != nil {
 			t.Fatal(err)
 		}
-		record := &recordlayer.RecordLayer{}
-		if err := record.Unmarshal(resp[:n]); err != nil {
-			t.Fatal(err)
+		if elapsed := time.Since(start); elapsed >= timeout {
+			t.Errorf("Close waited for the timeout although close_notify was acknowledged (%v)", elapsed)
 		}
-		clientHello, ok := record.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
-		if !ok {
-			t.Fatal("Failed to cast MessageClientHello")
+
+		// Close is idempotent and does not wait again
+		start = time.Now()
+		if err := client.Close(); !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Second Close must return %v, got %v", ErrConnClosed, err)
+		}
+		if elapsed := time.Since(start); elapsed >= timeout {
+			t.Errorf("Second Close must not wait (%v)", elapsed)
 		}
+		if _, err := client.Write([]byte("data")); !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Write must return %v after close, got %v", ErrConnClosed, err)
+		}
+	})
 
-		if !bytes.Equal(clientHello.Cookie, cookie) {
-			t.Fatalf("Wrong cookie, expected: %x, got: %x", clientHello.Cookie, cookie)
+	t.Run("NotAcknowledged", func(t *testing.T) {
+		client, server := handshake(t)
+		defer func() {
+			_ = server.Close()
+		}()
+
+		stallReader(t, client)
+
+		start := time.Now()
+		if err := client.Close(); !errors.Is(err, ErrCloseNotifyTimeout) {
+			t.Fatalf("Close must return %v, got %v", ErrCloseNotifyTimeout, err)
 		}
-		if len(packets) <= i {
-			break
+		if elapsed := time.Since(start); elapsed < timeout {
+			t.Errorf("Close returned before the timeout (%v)", elapsed)
 		}
-		// write hello verify request
-		if _, err := cb.Write(packets[i]); err != nil {
-			t.Fatal(err)
+		if !client.isConnectionClosed() {
+			t.Error("Connection must be closed after the timeout")
 		}
-	}
-	cancel()
+	})
+
+	t.Run("WriteDuringClose", func(t *testing.T) {
+		client, server := handshake(t)
+		defer func() {
+			_ = server.Close()
+		}()
+		stallReader(t, client)
+
+		closed := make(chan error)
+		go func() {
+			closed <- client.Close()
+		}()
+
+		// Writes fail as soon as close_notify was sent, without waiting for the timeout
+		for !client.isConnectionClosing() {
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := client.Write([]byte("data")); !errors.Is(err, ErrConnClosed) {
+			t.Errorf("Write must return %v while closing, got %v", ErrConnClosed, err)
+		}
+		if client.isConnectionClosed() {
+			t.Error("Write must not wait for Close to complete")
+		}
+		<-closed
+	})
 }
 
-// Assert that a DTLS Server always responds with RenegotiationInfo if
-// a ClientHello contained that extension or not
-func TestRenegotationInfo(t *testing.T) {
+func TestOnHandshakeComplete(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(10 * time.Second)
+	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	resp := make([]byte, 1024)
-
-	for _, testCase := range []struct {
-		Name                  string
-		SendRenegotiationInfo bool
-	}{
-		{
-			"Include RenegotiationInfo",
-			true,
-		},
-		{
-			"No RenegotiationInfo",
-			false,
-		},
-	} {
-		test := testCase
-		t.Run(test.Name, func(t *testing.T) {
-			ca, cb := dpipe.Pipe()
-			defer func() {
-				if err := ca.Close(); err != nil {
-					t.Error(err)
-				}
-			}()
-
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-
-			go func() {
-				if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
-					t.Error(err)
-				}
-			}()
+	clientStore := &memSessStore{}
+	ticketKey := bytes.Repeat([]byte{0x01}, sessionTicketKeyLength)
 
-			time.Sleep(50 * time.Millisecond)
+	handshake := func(t *testing.T) (client, server HandshakeStats) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-			extensions := []extension.Extension{}
-			if test.SendRenegotiationInfo {
-				extensions = append(extensions, &extension.RenegotiationInfo{
-					RenegotiatedConnection: 0,
-				})
-			}
-			err := sendClientHello([]byte{}, ca, 0, extensions)
-			if err != nil {
-				t.Fatal(err)
-			}
-			n, err := ca.Read(resp)
-			if err != nil {
-				t.Fatal(err)
-			}
-			r := &recordlayer.RecordLayer{}
-			if err = r.Unmarshal(resp[:n]); err != nil {
-				t.Fatal(err)
-			}
+		clientStats := make(chan HandshakeStats, 1)
+		serverStats := make(chan HandshakeStats, 1)
 
-			helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
-			if !ok {
-				t.Fatal("Failed to cast MessageHelloVerifyRequest")
-			}
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
 
-			err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if n, err = ca.Read(resp); err != nil {
-				t.Fatal(err)
+		ca, cb := dpipe.Pipe()
+		go func() {
+			config := &Config{
+				CipherSuites:        []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				SessionStore:        clientStore,
+				OnHandshakeComplete: func(s HandshakeStats) { clientStats <- s },
 			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
 
-			messages, err := recordlayer.UnpackDatagram(resp[:n])
-			if err != nil {
-				t.Fatal(err)
-			}
+		s, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			CipherSuites:        []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			SessionTicketKey:    ticketKey,
+			OnHandshakeComplete: func(s HandshakeStats) { serverStats <- s },
+		}, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = s.Close()
+		}()
 
-			if err := r.Unmarshal(messages[0]); err != nil {
-				t.Fatal(err)
-			}
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.c.Close()
 
-			serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-			if !ok {
-				t.Fatal("Failed to cast MessageServerHello")
-			}
+		return <-clientStats, <-serverStats
+	}
 
-			gotNegotationInfo := false
-			for _, v := range serverHello.Extensions {
-				if _, ok := v.(*extension.RenegotiationInfo); ok {
-					gotNegotationInfo = true
-				}
-			}
+	check := func(t *testing.T, name string, s HandshakeStats, resumed bool) {
+		if s.CipherSuiteID != TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			t.Errorf("%s: cipher suite mismatch: %v", name, s.CipherSuiteID)
+		}
+		if s.Resumed != resumed {
+			t.Errorf("%s: expected resumed=%v, got %v", name, resumed, s.Resumed)
+		}
+		if s.Flights == 0 || s.Duration <= 0 {
+			t.Errorf("%s: expected flights and duration to be recorded, got %+v", name, s)
+		}
+	}
 
-			if !gotNegotationInfo {
-				t.Fatalf("Received ServerHello without RenegotiationInfo")
-			}
-		})
+	client, server := handshake(t)
+	check(t, "full client", client, false)
+	check(t, "full server", server, false)
+	fullClientFlights := client.Flights
+
+	client, server = handshake(t)
+	check(t, "resumed client", client, true)
+	check(t, "resumed server", server, true)
+	if client.Flights >= fullClientFlights {
+		t.Errorf("Expected fewer flights when resuming: full(%d) resumed(%d)", fullClientFlights, client.Flights)
 	}
 }
 
-func TestServerNameIndicationExtension(t *testing.T) {
+func TestOnClientHello(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2316,87 +9038,100 @@ func TestServerNameIndicationExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name       string
-		ServerName string
-		Expected   []byte
-		IncludeSNI bool
+	errRejected := errors.New("rejected ClientHello")
+
+	for name, tt := range map[string]struct {
+		skipHelloVerify bool
+		reject          bool
+		expectedCalls   int
 	}{
-		{
-			Name:       "Server name is a valid hostname",
-			ServerName: "example.com",
-			Expected:   []byte("example.com"),
-			IncludeSNI: true,
-		},
-		{
-			Name:       "Server name is an IP literal",
-			ServerName: "1.2.3.4",
-			Expected:   []byte(""),
-			IncludeSNI: false,
-		},
-		{
-			Name:       "Server name is empty",
-			ServerName: "",
-			Expected:   []byte(""),
-			IncludeSNI: false,
-		},
+		"Accept":                {expectedCalls: 2},
+		"AcceptSkipHelloVerify": {skipHelloVerify: true, expectedCalls: 1},
+		"Reject":                {reject: true, expectedCalls: 1},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result, 1)
+
+			// The hello verify phase is only skipped for clients using
+			// connection IDs
+			var connectionIDGenerator func() []byte
+			if tt.skipHelloVerify {
+				connectionIDGenerator = RandomCIDGenerator(8)
+			}
+
 			ca, cb := dpipe.Pipe()
 			go func() {
-				conf := &Config{
-					ServerName: test.ServerName,
-				}
-
-				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					CipherSuites:          []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+					ServerName:            "example.com",
+					ConnectionIDGenerator: connectionIDGenerator,
+				}, true)
+				clientRes <- result{c, err}
 			}()
 
-			// Receive ClientHello
-			resp := make([]byte, 1024)
-			n, err := cb.Read(resp)
-			if err != nil {
-				t.Fatal(err)
-			}
-			r := &recordlayer.RecordLayer{}
-			if err = r.Unmarshal(resp[:n]); err != nil {
-				t.Fatal(err)
-			}
-
-			clientHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
-			if !ok {
-				t.Fatal("Failed to cast MessageClientHello")
-			}
+			var calls int
+			server, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				InsecureSkipVerifyHello: tt.skipHelloVerify,
+				ConnectionIDGenerator:   connectionIDGenerator,
+				OnClientHello: func(h *handshake.MessageClientHello) error {
+					calls++
+
+					var serverName string
+					for _, e := range h.Extensions {
+						if sni, ok := e.(*extension.ServerName); ok {
+							serverName = sni.ServerName
+						}
+					}
+					if serverName != "example.com" {
+						t.Errorf("Expected ServerName example.com, got %q", serverName)
+					}
+					if !reflect.DeepEqual(h.CipherSuiteIDs, []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)}) {
+						t.Errorf("Unexpected cipher suites offered %v", h.CipherSuiteIDs)
+					}
 
-			gotSNI := false
-			var actualServerName string
-			for _, v := range clientHello.Extensions {
-				if _, ok := v.(*extension.ServerName); ok {
-					gotSNI = true
-					extensionServerName, ok := v.(*extension.ServerName)
-					if !ok {
-						t.Fatal("Failed to cast extension.ServerName")
+					if tt.reject {
+						return errRejected
 					}
+					return nil
+				},
+			}, true)
+			res := <-clientRes
 
-					actualServerName = extensionServerName.ServerName
+			if tt.reject {
+				if !errors.Is(errServer, errRejected) {
+					t.Fatalf("Server error exp(%v) failed(%v)", errRejected, errServer)
 				}
+				expectedAlert := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}}
+				if !errors.Is(res.err, expectedAlert) {
+					t.Fatalf("Client error exp(%v) failed(%v)", expectedAlert, res.err)
+				}
+			} else {
+				if errServer != nil {
+					t.Fatal(errServer)
+				}
+				if res.err != nil {
+					t.Fatal(res.err)
+				}
+				_ = res.c.Close()
+				_ = server.Close()
 			}
 
-			if gotSNI != test.IncludeSNI {
-				t.Errorf("TestSNI: unexpected SNI inclusion '%s': expected(%v) actual(%v)", test.Name, test.IncludeSNI, gotSNI)
-			}
-
-			if !bytes.Equal([]byte(actualServerName), test.Expected) {
-				t.Errorf("TestSNI: server name mismatch '%s': expected(%v) actual(%v)", test.Name, test.Expected, actualServerName)
+			if calls != tt.expectedCalls {
+				t.Errorf("Expected OnClientHello to be called %d times, got %d", tt.expectedCalls, calls)
 			}
 		})
 	}
 }
 
-func TestALPNExtension(t *testing.T) {
+func TestRequestClientCertificate(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2405,222 +9140,110 @@ func TestALPNExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name                   string
-		ClientProtocolNameList []string
-		ServerProtocolNameList []string
-		ExpectedProtocol       string
-		ExpectAlertFromClient  bool
-		ExpectAlertFromServer  bool
-		Alert                  alert.Description
+	clientCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clientCertificates []tls.Certificate
+		postHandshakeAuth  bool
+		expectedErr        error
 	}{
-		{
-			Name:                   "Negotiate a protocol",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
-			ServerProtocolNameList: []string{"spd/1"},
-			ExpectedProtocol:       "spd/1",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
-		},
-		{
-			Name:                   "Server doesn't support any",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
-			ServerProtocolNameList: []string{},
-			ExpectedProtocol:       "",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
-		},
-		{
-			Name:                   "Negotiate with higher server precedence",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1", "http/3"},
-			ServerProtocolNameList: []string{"ssh/2", "http/3", "spd/1"},
-			ExpectedProtocol:       "http/3",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
+		"Certificate": {
+			clientCertificates: []tls.Certificate{clientCert},
+			postHandshakeAuth:  true,
 		},
-		{
-			Name:                   "Empty intersection",
-			ClientProtocolNameList: []string{"http/1.1", "http/3"},
-			ServerProtocolNameList: []string{"ssh/2", "spd/1"},
-			ExpectedProtocol:       "",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  true,
-			Alert:                  alert.NoApplicationProtocol,
+		"NoCertificate": {
+			postHandshakeAuth: true,
+			expectedErr:       errNoClientCertificate,
 		},
-		{
-			Name:                   "Multiple protocols in ServerHello",
-			ClientProtocolNameList: []string{"http/1.1"},
-			ServerProtocolNameList: []string{"http/1.1"},
-			ExpectedProtocol:       "http/1.1",
-			ExpectAlertFromClient:  true,
-			ExpectAlertFromServer:  false,
-			Alert:                  alert.InternalError,
+		"NotOffered": {
+			clientCertificates: []tls.Certificate{clientCert},
+			expectedErr:        errPostHandshakeAuthNotOffered,
 		},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ca, cb := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					SupportedProtocols: test.ClientProtocolNameList,
-				}
-				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
-			}()
-
-			// Receive ClientHello
-			resp := make([]byte, 1024)
-			n, err := cb.Read(resp)
-			if err != nil {
-				t.Fatal(err)
+			type result struct {
+				c   *Conn
+				err error
 			}
+			c := make(chan result)
 
-			ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel2()
-
-			ca2, cb2 := dpipe.Pipe()
 			go func() {
-				conf := &Config{
-					SupportedProtocols: test.ServerProtocolNameList,
-				}
-				if _, err2 := testServer(ctx2, dtlsnet.PacketConnFromConn(cb2), cb2.RemoteAddr(), conf, true); !errors.Is(err2, context.Canceled) {
-					if test.ExpectAlertFromServer { //nolint
-						// Assert the error type?
-					} else {
-						t.Error(err2)
-					}
-				}
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					Certificates:            tt.clientCertificates,
+					InsecureSkipVerify:      true,
+					EnablePostHandshakeAuth: tt.postHandshakeAuth,
+				}, false)
+				c <- result{client, err}
 			}()
 
-			time.Sleep(50 * time.Millisecond)
-
-			// Forward ClientHello
-			if _, err = ca2.Write(resp[:n]); err != nil {
-				t.Fatal(err)
-			}
-
-			// Receive HelloVerify
-			resp2 := make([]byte, 1024)
-			n, err = ca2.Read(resp2)
+			var verified [][]byte
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+					verified = rawCerts
+					return nil
+				},
+			}, true)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			// Forward HelloVerify
-			if _, err = cb.Write(resp2[:n]); err != nil {
-				t.Fatal(err)
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
 			}
+			client := res.c
 
-			// Receive ClientHello
-			resp3 := make([]byte, 1024)
-			n, err = cb.Read(resp3)
-			if err != nil {
-				t.Fatal(err)
+			if len(server.ConnectionState().PeerCertificates) != 0 {
+				t.Fatal("Server received a client certificate during the handshake")
+			}
+			if server.state.remotePostHandshakeAuth != tt.postHandshakeAuth {
+				t.Fatalf("Expected post_handshake_auth recorded %v, got %v", tt.postHandshakeAuth, server.state.remotePostHandshakeAuth)
+			}
+			if err := client.RequestClientCertificate(); !errors.Is(err, errClientCertificateRequestOnClient) {
+				t.Fatalf("Expected error '%v', got '%v'", errClientCertificateRequestOnClient, err)
 			}
 
-			// Forward ClientHello
-			if _, err = ca2.Write(resp3[:n]); err != nil {
-				t.Fatal(err)
+			if err := server.RequestClientCertificate(); !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error '%v', got '%v'", tt.expectedErr, err)
+			}
+			if tt.expectedErr == nil {
+				if state := server.ConnectionState(); len(state.PeerCertificates) != 1 || !bytes.Equal(state.PeerCertificates[0], clientCert.Certificate[0]) {
+					t.Fatal("Server did not receive the client certificate")
+				}
+				if len(verified) != 1 || !bytes.Equal(verified[0], clientCert.Certificate[0]) {
+					t.Fatal("VerifyPeerCertificate was not called with the client certificate")
+				}
 			}
 
-			// Receive ServerHello
-			resp4 := make([]byte, 1024)
-			n, err = ca2.Read(resp4)
-			if err != nil {
+			// The connection keeps working afterwards
+			if _, err := client.Write([]byte("hello")); err != nil {
 				t.Fatal(err)
 			}
-
-			messages, err := recordlayer.UnpackDatagram(resp4[:n])
+			buf := make([]byte, 8)
+			n, err := server.Read(buf)
 			if err != nil {
 				t.Fatal(err)
 			}
+			if string(buf[:n]) != "hello" {
+				t.Fatalf("Unexpected data %q", buf[:n])
+			}
 
-			r := &recordlayer.RecordLayer{}
-			if err := r.Unmarshal(messages[0]); err != nil {
+			if err := client.Close(); err != nil {
 				t.Fatal(err)
 			}
-
-			if test.ExpectAlertFromServer {
-				a, ok := r.Content.(*alert.Alert)
-				if !ok {
-					t.Fatal("Failed to cast alert.Alert")
-				}
-
-				if a.Description != test.Alert {
-					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
-				}
-			} else {
-				serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-				if !ok {
-					t.Fatal("Failed to cast handshake.MessageServerHello")
-				}
-
-				var negotiatedProtocol string
-				for _, v := range serverHello.Extensions {
-					if _, ok := v.(*extension.ALPN); ok {
-						e, ok := v.(*extension.ALPN)
-						if !ok {
-							t.Fatal("Failed to cast extension.ALPN")
-						}
-
-						negotiatedProtocol = e.ProtocolNameList[0]
-
-						// Manipulate ServerHello
-						if test.ExpectAlertFromClient {
-							e.ProtocolNameList = append(e.ProtocolNameList, "oops")
-						}
-					}
-				}
-
-				if negotiatedProtocol != test.ExpectedProtocol {
-					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.ExpectedProtocol, negotiatedProtocol)
-				}
-
-				s, err := r.Marshal()
-				if err != nil {
-					t.Fatal(err)
-				}
-
-				// Forward ServerHello
-				if _, err = cb.Write(s); err != nil {
-					t.Fatal(err)
-				}
-
-				if test.ExpectAlertFromClient {
-					resp5 := make([]byte, 1024)
-					n, err = cb.Read(resp5)
-					if err != nil {
-						t.Fatal(err)
-					}
-
-					r2 := &recordlayer.RecordLayer{}
-					if err := r2.Unmarshal(resp5[:n]); err != nil {
-						t.Fatal(err)
-					}
-
-					a, ok := r2.Content.(*alert.Alert)
-					if !ok {
-						t.Fatal("Failed to cast alert.Alert")
-					}
-
-					if a.Description != test.Alert {
-						t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
-					}
-				}
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
 			}
-
-			time.Sleep(50 * time.Millisecond) // Give some time for returned errors
 		})
 	}
 }
 
-// Make sure the supported_groups extension is not included in the ServerHello
-func TestSupportedGroupsExtension(t *testing.T) {
+func TestCookieGeneratorVerifier(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2629,84 +9252,153 @@ func TestSupportedGroupsExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	t.Run("ServerHello Supported Groups", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		ca, cb := dpipe.Pipe()
-		go func() {
-			if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
-				t.Error(err)
-			}
-		}()
-		extensions := []extension.Extension{
-			&extension.SupportedEllipticCurves{
-				EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
-			},
-			&extension.SupportedPointFormats{
-				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
-			},
+	// Stateless cookies shared by a fleet of servers
+	secret := []byte("cookie secret shared by the fleet")
+	generateCookie := func(clientAddr net.Addr) ([]byte, error) {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(clientAddr.String()))
+		return mac.Sum(nil), nil
+	}
+	verifyCookie := func(clientAddr net.Addr, cookie []byte) error {
+		expected, _ := generateCookie(clientAddr)
+		if !hmac.Equal(expected, cookie) {
+			return errCookieMismatch
 		}
+		return nil
+	}
+	extensions := []extension.Extension{
+		&extension.SupportedEllipticCurves{
+			EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+		},
+	}
 
-		time.Sleep(50 * time.Millisecond)
-
-		resp := make([]byte, 1024)
-		err := sendClientHello([]byte{}, ca, 0, extensions)
+	readHandshake := func(t *testing.T, conn net.Conn) handshake.Message {
+		resp := make([]byte, 8192)
+		n, err := conn.Read(resp)
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		// Receive ServerHello
-		n, err := ca.Read(resp)
+		messages, err := recordlayer.UnpackDatagram(resp[:n])
 		if err != nil {
 			t.Fatal(err)
 		}
 		r := &recordlayer.RecordLayer{}
-		if err = r.Unmarshal(resp[:n]); err != nil {
+		if err := r.Unmarshal(messages[0]); err != nil {
 			t.Fatal(err)
 		}
-
-		helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
+		h, ok := r.Content.(*handshake.Handshake)
 		if !ok {
-			t.Fatal("Failed to cast MessageHelloVerifyRequest")
+			t.Fatalf("Expected a handshake message, got %T", r.Content)
 		}
+		return h.Message
+	}
 
-		err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
+	t.Run("Valid", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{InsecureSkipVerify: true}, false)
+			c <- result{client, err}
+		}()
+
+		var generated, verified int
+		server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			CookieGenerator: func(clientAddr net.Addr) ([]byte, error) {
+				generated++
+				return generateCookie(clientAddr)
+			},
+			CookieVerifier: func(clientAddr net.Addr, cookie []byte) error {
+				verified++
+				return verifyCookie(clientAddr, cookie)
+			},
+		}, true)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if n, err = ca.Read(resp); err != nil {
-			t.Fatal(err)
+
+		res := <-c
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		if generated != 1 || verified != 1 {
+			t.Fatalf("Expected one generated and one verified cookie, got %d and %d", generated, verified)
 		}
+		_ = res.c.Close()
+		_ = server.Close()
+	})
 
-		messages, err := recordlayer.UnpackDatagram(resp[:n])
-		if err != nil {
+	t.Run("Tampered", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		serverErr := make(chan error, 1)
+		go func() {
+			_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CookieGenerator: generateCookie,
+				CookieVerifier:  verifyCookie,
+			}, true)
+			serverErr <- err
+		}()
+
+		if err := sendClientHello([]byte{}, ca, 0, extensions); err != nil {
 			t.Fatal(err)
 		}
+		helloVerifyRequest, ok := readHandshake(t, ca).(*handshake.MessageHelloVerifyRequest)
+		if !ok {
+			t.Fatal("Failed to cast MessageHelloVerifyRequest")
+		}
+		expected, _ := generateCookie(cb.RemoteAddr())
+		if !bytes.Equal(helloVerifyRequest.Cookie, expected) {
+			t.Fatal("HelloVerifyRequest does not carry the generated cookie")
+		}
 
-		if err := r.Unmarshal(messages[0]); err != nil {
+		helloVerifyRequest.Cookie[0] ^= 0xff
+		if err := sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions); err != nil {
 			t.Fatal(err)
 		}
-
-		serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-		if !ok {
-			t.Fatal("Failed to cast MessageServerHello")
+		if err := <-serverErr; !errors.Is(err, errCookieMismatch) {
+			t.Fatalf("Expected error '%v', got '%v'", errCookieMismatch, err)
 		}
+	})
 
-		gotGroups := false
-		for _, v := range serverHello.Extensions {
-			if _, ok := v.(*extension.SupportedEllipticCurves); ok {
-				gotGroups = true
-			}
+	// A ClientHello with a cookie issued by another server skips the
+	// HelloVerifyRequest
+	t.Run("Stateless", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		serverErr := make(chan error, 1)
+		go func() {
+			_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CookieGenerator: generateCookie,
+				CookieVerifier:  verifyCookie,
+			}, true)
+			serverErr <- err
+		}()
+
+		cookie, _ := generateCookie(cb.RemoteAddr())
+		if err := sendClientHello(cookie, ca, 0, extensions); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := readHandshake(t, ca).(*handshake.MessageServerHello); !ok {
+			t.Fatal("Failed to cast MessageServerHello")
 		}
 
-		if gotGroups {
-			t.Errorf("TestSupportedGroups: supported_groups extension was sent in ServerHello")
+		cancel()
+		if err := <-serverErr; !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected error '%v', got '%v'", context.Canceled, err)
 		}
 	})
 }
 
-func TestSessionResume(t *testing.T) {
+func TestStreamTransport(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2715,161 +9407,294 @@ func TestSessionResume(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	t.Run("resumed", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		type result struct {
-			c   *Conn
-			err error
-		}
-		clientRes := make(chan result, 1)
+	// A stream keeps no datagram boundaries
+	ca, cb := net.Pipe()
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromStream(ca), ca.RemoteAddr(), &Config{}, true)
+		c <- result{client, err}
+	}()
+
+	server, err := testServer(ctx, dtlsnet.PacketConnFromStream(cb), cb.RemoteAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+	}()
+
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = res.c.Close()
+	}()
+
+	// Application data keeps its record boundaries as well
+	message := bytes.Repeat([]byte("stream"), 100)
+	go func() {
+		_, _ = res.c.Write(message)
+	}()
+	buf := make([]byte, 1024)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], message) {
+		t.Errorf("Expected the client message, got %d bytes", n)
+	}
+}
+
+func TestPrematureChangeCipherSpec(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		_ = ca.Close()
+	}()
+	errChan := make(chan error)
+	go func() {
+		_, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+		errChan <- err
+	}()
+
+	// The server did not send a ServerHello yet
+	raw, err := (&recordlayer.RecordLayer{
+		Header:  recordlayer.Header{Version: protocol.Version1_2},
+		Content: &protocol.ChangeCipherSpec{},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ca.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := ca.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &recordlayer.RecordLayer{}
+	if err := r.Unmarshal(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if a, ok := r.Content.(*alert.Alert); !ok || a.Level != alert.Fatal || a.Description != alert.UnexpectedMessage {
+		t.Fatalf("Expected a fatal unexpected_message alert, got %v", r.Content)
+	}
+	if err := <-errChan; !errors.Is(err, errUnexpectedChangeCipherSpec) {
+		t.Fatalf("Expected error '%v', got '%v'", errUnexpectedChangeCipherSpec, err)
+	}
+}
 
-		ss := &memSessStore{}
+// keyLogRecorder fails the test if Write is called concurrently
+type keyLogRecorder struct {
+	t       *testing.T
+	writing int32
+	buf     bytes.Buffer
+}
 
-		id, _ := hex.DecodeString("9b9fc92255634d9fb109febed42166717bb8ded8c738ba71bc7f2a0d9dae0306")
-		secret, _ := hex.DecodeString("2e942a37aca5241deb2295b5fcedac221c7078d2503d2b62aeb48c880d7da73c001238b708559686b9da6e829c05ead7")
+func (w *keyLogRecorder) Write(p []byte) (int, error) {
+	if !atomic.CompareAndSwapInt32(&w.writing, 0, 1) {
+		w.t.Error("Concurrent write to the KeyLogWriter")
+	}
+	defer atomic.StoreInt32(&w.writing, 0)
+	return w.buf.Write(p)
+}
 
-		s := Session{ID: id, Secret: secret}
+func TestKeyLogWriter(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-		ca, cb := dpipe.Pipe()
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-		_ = ss.Set(id, s)
-		_ = ss.Set([]byte(ca.RemoteAddr().String()+"_example.com"), s)
+	const connections = 4
+	keyLog := &keyLogRecorder{t: t}
 
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		wg.Add(1)
 		go func() {
-			config := &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-				ServerName:   "example.com",
-				SessionStore: ss,
-				MTU:          100,
+			defer wg.Done()
+			ca, cb := dpipe.Pipe()
+			errChan := make(chan error)
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{KeyLogWriter: keyLog}, true)
+				if err == nil {
+					_ = client.Close()
+				}
+				errChan <- err
+			}()
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{KeyLogWriter: keyLog}, true)
+			if err != nil {
+				t.Error(err)
+			} else {
+				_ = server.Close()
+			}
+			if err := <-errChan; err != nil {
+				t.Error(err)
 			}
-			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
-			clientRes <- result{c, err}
 		}()
+	}
+	wg.Wait()
 
-		config := &Config{
-			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerName:   "example.com",
-			SessionStore: ss,
-			MTU:          100,
-		}
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-		if err != nil {
-			t.Fatalf("TestSessionResume: Server failed(%v)", err)
-		}
-
-		actualSessionID := server.ConnectionState().SessionID
-		actualMasterSecret := server.ConnectionState().masterSecret
-		if !bytes.Equal(actualSessionID, id) {
-			t.Errorf("TestSessionResumetion: SessionID Mismatch: expected(%v) actual(%v)", id, actualSessionID)
+	// Both sides of every connection log the same line
+	lines := strings.Split(strings.TrimSuffix(keyLog.buf.String(), "\n"), "\n")
+	if len(lines) != 2*connections {
+		t.Fatalf("Expected %d lines, got %d", 2*connections, len(lines))
+	}
+	seen := map[string]int{}
+	for _, line := range lines {
+		fields := strings.Split(line, " ")
+		if len(fields) != 3 || fields[0] != "CLIENT_RANDOM" {
+			t.Fatalf("Unexpected line %q", line)
 		}
-		if !bytes.Equal(actualMasterSecret, secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", secret, actualMasterSecret)
+		// The client random and the master secret in lower case hex
+		for i, n := range []int{handshake.RandomLength, 48} {
+			if b, err := hex.DecodeString(fields[i+1]); err != nil || len(b) != n || strings.ToLower(fields[i+1]) != fields[i+1] {
+				t.Fatalf("Unexpected field %q in line %q", fields[i+1], line)
+			}
 		}
-
-		defer func() {
-			_ = server.Close()
-		}()
-
-		res := <-clientRes
-		if res.err != nil {
-			t.Fatal(res.err)
+		seen[line]++
+	}
+	for line, n := range seen {
+		if n != 2 {
+			t.Fatalf("Expected line %q twice, got %d", line, n)
 		}
-		_ = res.c.Close()
-	})
-
-	t.Run("new session", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	}
 
+	// On a resumed handshake both sides log the random of the ClientHello
+	clientStore, serverStore := &memSessStore{}, &memSessStore{}
+	for _, resumed := range []bool{false, true} {
+		clientLog, serverLog := &keyLogRecorder{t: t}, &keyLogRecorder{t: t}
+		ca, cb := dpipe.Pipe()
 		type result struct {
 			c   *Conn
 			err error
 		}
 		clientRes := make(chan result, 1)
-
-		s1 := &memSessStore{}
-		s2 := &memSessStore{}
-
-		ca, cb := dpipe.Pipe()
 		go func() {
-			config := &Config{
+			c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
 				ServerName:   "example.com",
-				SessionStore: s1,
-			}
-			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+				SessionStore: clientStore,
+				KeyLogWriter: clientLog,
+			}, true)
 			clientRes <- result{c, err}
 		}()
-
-		config := &Config{
-			SessionStore: s2,
-		}
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+		server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			SessionStore: serverStore,
+			KeyLogWriter: serverLog,
+		}, true)
 		if err != nil {
-			t.Fatalf("TestSessionResumetion: Server failed(%v)", err)
-		}
-
-		actualSessionID := server.ConnectionState().SessionID
-		actualMasterSecret := server.ConnectionState().masterSecret
-		ss, _ := s2.Get(actualSessionID)
-		if !bytes.Equal(actualMasterSecret, ss.Secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+			t.Fatal(err)
 		}
-
-		defer func() {
-			_ = server.Close()
-		}()
-
 		res := <-clientRes
 		if res.err != nil {
 			t.Fatal(res.err)
 		}
-		cs, _ := s1.Get([]byte(ca.RemoteAddr().String() + "_example.com"))
-		if !bytes.Equal(actualMasterSecret, cs.Secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		if server.ConnectionState().resumed != resumed {
+			t.Fatalf("Expected resumed %v, got %v", resumed, server.ConnectionState().resumed)
 		}
-		_ = res.c.Close()
-	})
-}
-
-type memSessStore struct {
-	sync.Map
-}
-
-func (ms *memSessStore) Set(key []byte, s Session) error {
-	k := hex.EncodeToString(key)
-	ms.Store(k, s)
 
-	return nil
+		items := server.handshakeCache.pull(handshakeCachePullRule{handshake.TypeClientHello, 0, true, false})
+		if items[0] == nil {
+			t.Fatal("ClientHello not found")
+		}
+		h := &handshake.Handshake{}
+		if err := h.Unmarshal(items[0].data); err != nil {
+			t.Fatal(err)
+		}
+		clientHello, ok := h.Message.(*handshake.MessageClientHello)
+		if !ok {
+			t.Fatalf("Unexpected message %T", h.Message)
+		}
+		random := clientHello.Random.MarshalFixed()
+		expected := fmt.Sprintf("CLIENT_RANDOM %x %x\n", random, server.ConnectionState().masterSecret)
+		for name, keyLog := range map[string]*keyLogRecorder{"client": clientLog, "server": serverLog} {
+			if actual := keyLog.buf.String(); actual != expected {
+				t.Errorf("%s: Unexpected key log (resumed %v): expected(%q) actual(%q)", name, resumed, expected, actual)
+			}
+		}
+		_ = res.c.Close()
+		_ = server.Close()
+	}
 }
 
-func (ms *memSessStore) Get(key []byte) (Session, error) {
-	k := hex.EncodeToString(key)
+func TestAppDataQueueLimit(t *testing.T) {
+	const limit = 1024
+	c := &Conn{
+		log:                  logging.NewDefaultLoggerFactory().NewLogger("dtls"),
+		maxAppDataQueueBytes: limit,
+	}
 
-	v, ok := ms.Load(k)
-	if !ok {
-		return Session{}, nil
+	record := func(contentType protocol.ContentType, seq uint64) []byte {
+		h := &recordlayer.Header{
+			ContentType:    contentType,
+			ContentLen:     100,
+			Version:        protocol.Version1_2,
+			Epoch:          1,
+			SequenceNumber: seq,
+		}
+		raw, err := h.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(raw, make([]byte, 100)...)
 	}
 
-	s, ok := v.(Session)
-	if !ok {
-		return Session{}, nil
+	// A peer floods us with application data of the next epoch, its
+	// Finished follows
+	for seq := uint64(0); seq < 100; seq++ {
+		if _, _, err := c.handleIncomingPacket(context.TODO(), record(protocol.ContentTypeApplicationData, seq), nil, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := c.handleIncomingPacket(context.TODO(), record(protocol.ContentTypeHandshake, 100), nil, true); err != nil {
+		t.Fatal(err)
 	}
 
-	return s, nil
+	appData := limit / (recordlayer.FixedHeaderSize + 100)
+	if c.queuedAppDataBytes > limit {
+		t.Fatalf("Queued %d bytes of application data, limit is %d", c.queuedAppDataBytes, limit)
+	}
+	if len(c.encryptedPackets) != appData+1 {
+		t.Fatalf("Expected %d queued records, got %d", appData+1, len(c.encryptedPackets))
+	}
 }
 
-func (ms *memSessStore) Del(key []byte) error {
-	k := hex.EncodeToString(key)
-	ms.Delete(k)
+// readCountingPacketConn counts the datagrams read from the PacketConn
+type readCountingPacketConn struct {
+	net.PacketConn
+	reads int32
+}
 
-	return nil
+func (c *readCountingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	if err == nil {
+		atomic.AddInt32(&c.reads, 1)
+	}
+	return n, addr, err
 }
 
-// Assert that the server only uses CipherSuites with a hash+signature that matches
-// the certificate. As specified in rfc5246#section-7.4.3
-func TestCipherSuiteMatchesCertificateType(t *testing.T) {
+func TestSlowReaderBackpressure(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2878,298 +9703,345 @@ func TestCipherSuiteMatchesCertificateType(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name           string
-		cipherList     []CipherSuiteID
-		expectedCipher CipherSuiteID
-		generateRSA    bool
-	}{
-		{
-			Name:           "ECDSA Certificate with RSA CipherSuite first",
-			cipherList:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			expectedCipher: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
-		{
-			Name:           "RSA Certificate with ECDSA CipherSuite first",
-			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
-			expectedCipher: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			generateRSA:    true,
-		},
-	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			clientErr := make(chan error, 1)
-			client := make(chan *Conn, 1)
-
-			ca, cb := dpipe.Pipe()
-			go func() {
-				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.cipherList}, false)
-				clientErr <- err
-				client <- c
-			}()
-
-			var (
-				priv crypto.PrivateKey
-				err  error
-			)
-
-			if test.generateRSA {
-				if priv, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
-					t.Fatal(err)
-				}
-			} else {
-				if priv, err = ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader); err != nil {
-					t.Fatal(err)
-				}
-			}
-
-			serverCert, err := selfsign.SelfSign(priv)
-			if err != nil {
-				t.Fatal(err)
-			}
-
-			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-				CipherSuites: test.cipherList,
-				Certificates: []tls.Certificate{serverCert},
-			}, false); err != nil {
-				t.Fatal(err)
-			} else if err = s.Close(); err != nil {
-				t.Fatal(err)
-			}
-
-			if c, err := <-client, <-clientErr; err != nil {
-				t.Fatal(err)
-			} else if err := c.Close(); err != nil {
-				t.Fatal(err)
-			} else if c.ConnectionState().cipherSuite.ID() != test.expectedCipher {
-				t.Fatalf("Expected(%s) and Actual(%s) CipherSuite do not match", test.expectedCipher, c.ConnectionState().cipherSuite.ID())
-			}
-		})
+	ca, cb := dpipe.Pipe()
+	serverConn := &readCountingPacketConn{PacketConn: dtlsnet.PacketConnFromConn(cb)}
+	type result struct {
+		c   *Conn
+		err error
 	}
-}
-
-// Test that we return the proper certificate if we are serving multiple ServerNames on a single Server
-func TestMultipleServerCertificates(t *testing.T) {
-	fooCert, err := selfsign.GenerateSelfSignedWithDNS("foo")
+	c := make(chan result)
+	go func() {
+		client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, true)
+		c <- result{client, err}
+	}()
+	server, err := testServer(context.TODO(), serverConn, cb.RemoteAddr(), &Config{}, true)
 	if err != nil {
 		t.Fatal(err)
 	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
 
-	barCert, err := selfsign.GenerateSelfSignedWithDNS("bar")
-	if err != nil {
-		t.Fatal(err)
+	const records = 50
+	handshakeReads := atomic.LoadInt32(&serverConn.reads)
+	for i := 0; i < records; i++ {
+		if _, err := res.c.Write([]byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
 	}
+	time.Sleep(100 * time.Millisecond)
 
-	caPool := x509.NewCertPool()
-	for _, cert := range []tls.Certificate{fooCert, barCert} {
-		certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	// One record waits for Read, one is blocked on being handed over
+	if reads := atomic.LoadInt32(&serverConn.reads) - handshakeReads; reads > 2 {
+		t.Fatalf("Read %d datagrams without the application reading", reads)
+	}
+
+	// Nothing was lost
+	buf := make([]byte, 8)
+	for i := 0; i < records; i++ {
+		n, err := server.Read(buf)
 		if err != nil {
 			t.Fatal(err)
 		}
-		caPool.AddCert(certificate)
+		if n != 1 || buf[0] != byte(i) {
+			t.Fatalf("Unexpected record %v, expected %d", buf[:n], i)
+		}
 	}
+	_ = res.c.Close()
+	_ = server.Close()
+}
+
+func TestRecordLayerVersion(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-	for _, test := range []struct {
-		RequestServerName string
-		ExpectedDNSName   string
+	for name, tt := range map[string]struct {
+		version  protocol.Version
+		expected protocol.Version
 	}{
-		{
-			"foo",
-			"foo",
-		},
-		{
-			"bar",
-			"bar",
+		"Default": {
+			expected: protocol.Version1_2,
 		},
-		{
-			"invalid",
-			"foo",
+		"DTLS1.0": {
+			version:  protocol.Version1_0,
+			expected: protocol.Version1_0,
 		},
 	} {
-		test := test
-		t.Run(test.RequestServerName, func(t *testing.T) {
-			clientErr := make(chan error, 2)
-			client := make(chan *Conn, 1)
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				versions []protocol.Version
+			)
+			recordVersions := func(b []byte) {
+				records, err := recordlayer.UnpackDatagram(b)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				for _, record := range records {
+					h := &recordlayer.Header{}
+					if err := h.Unmarshal(record); err != nil {
+						t.Error(err)
+						return
+					}
+					versions = append(versions, h.Version)
+				}
+			}
 
 			ca, cb := dpipe.Pipe()
-			go func() {
-				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
-					RootCAs:    caPool,
-					ServerName: test.RequestServerName,
-					VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-						certificate, err := x509.ParseCertificate(rawCerts[0])
-						if err != nil {
-							return err
-						}
-
-						if certificate.DNSNames[0] != test.ExpectedDNSName {
-							return errWrongCert
-						}
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
 
-						return nil
-					},
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(&connWithCallback{Conn: ca, onWrite: recordVersions}), ca.RemoteAddr(), &Config{
+					InsecureSkipVerify: true,
+					RecordLayerVersion: tt.version,
 				}, false)
-				clientErr <- err
-				client <- c
+				c <- result{client, err}
 			}()
 
-			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{Certificates: []tls.Certificate{fooCert, barCert}}, false); err != nil {
-				t.Fatal(err)
-			} else if err = s.Close(); err != nil {
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+			if err != nil {
 				t.Fatal(err)
 			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
 
-			if c, err := <-client, <-clientErr; err != nil {
+			// Encrypted records are covered as well
+			if _, err := res.c.Write([]byte("hello")); err != nil {
 				t.Fatal(err)
-			} else if err := c.Close(); err != nil {
+			}
+			buf := make([]byte, 8)
+			if _, err := server.Read(buf); err != nil {
 				t.Fatal(err)
 			}
+
+			mu.Lock()
+			if len(versions) == 0 {
+				t.Error("No records were written")
+			}
+			for _, v := range versions {
+				if !v.Equal(tt.expected) {
+					t.Errorf("Record written with version %v, expected %v", v, tt.expected)
+				}
+			}
+			mu.Unlock()
+
+			_ = res.c.Close()
+			_ = server.Close()
 		})
 	}
 }
 
-func TestEllipticCurveConfiguration(t *testing.T) {
+func TestRenegotiate(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name            string
-		ConfigCurves    []elliptic.Curve
-		HadnshakeCurves []elliptic.Curve
-	}{
-		{
-			Name:            "Curve defaulting",
-			ConfigCurves:    nil,
-			HadnshakeCurves: defaultCurves,
-		},
-		{
-			Name:            "Single curve",
-			ConfigCurves:    []elliptic.Curve{elliptic.X25519},
-			HadnshakeCurves: []elliptic.Curve{elliptic.X25519},
-		},
-		{
-			Name:            "Multiple curves",
-			ConfigCurves:    []elliptic.Curve{elliptic.P384, elliptic.X25519},
-			HadnshakeCurves: []elliptic.Curve{elliptic.P384, elliptic.X25519},
-		},
+	for name, serverInitiated := range map[string]bool{
+		"Client": false,
+		"Server": true,
 	} {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		serverInitiated := serverInitiated
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
 
-		ca, cb := dpipe.Pipe()
-		type result struct {
-			c   *Conn
-			err error
-		}
-		c := make(chan result)
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					InsecureSkipVerify: true,
+				}, false)
+				c <- result{client, err}
+			}()
 
-		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
-			c <- result{client, err}
-		}()
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
 
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
-		if err != nil {
-			t.Fatalf("Server error: %v", err)
-		}
+			keyingMaterial, err := client.ExportKeyingMaterial("EXPORTER-test", nil, 16)
+			if err != nil {
+				t.Fatal(err)
+			}
 
-		if len(test.ConfigCurves) == 0 && len(test.HadnshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
-			t.Fatalf("Failed to default Elliptic curves, expected %d, got: %d", len(test.HadnshakeCurves), len(server.fsm.cfg.ellipticCurves))
-		}
+			// Echo application data while the handshake is running
+			go func() {
+				buf := make([]byte, 64)
+				for {
+					n, err := server.Read(buf)
+					if err != nil {
+						return
+					}
+					if _, err := server.Write(buf[:n]); err != nil {
+						return
+					}
+				}
+			}()
 
-		if len(test.ConfigCurves) != 0 {
-			if len(test.HadnshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
-				t.Fatalf("Failed to configure Elliptic curves, expect %d, got %d", len(test.HadnshakeCurves), len(server.fsm.cfg.ellipticCurves))
-			}
-			for i, c := range test.ConfigCurves {
-				if c != server.fsm.cfg.ellipticCurves[i] {
-					t.Fatalf("Failed to maintain Elliptic curve order, expected %s, got %s", c, server.fsm.cfg.ellipticCurves[i])
+			renegotiated := make(chan error, 1)
+			go func() {
+				if serverInitiated {
+					renegotiated <- server.Renegotiate()
+				} else {
+					renegotiated <- client.Renegotiate()
+				}
+			}()
+
+			echo := func(i int) {
+				msg := []byte(fmt.Sprintf("ping %d", i))
+				if _, err := client.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 64)
+				n, err := client.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf[:n], msg) {
+					t.Fatalf("Unexpected data %q, expected %q", buf[:n], msg)
 				}
 			}
-		}
 
-		res := <-c
-		if res.err != nil {
-			t.Fatalf("Client error; %v", err)
-		}
+			i := 0
+			for done := false; !done; i++ {
+				echo(i)
+				select {
+				case err := <-renegotiated:
+					if err != nil {
+						t.Fatal(err)
+					}
+					done = true
+				default:
+				}
+			}
+			// The new keys are used afterwards
+			for j := 0; j < 3; j++ {
+				echo(i + j)
+			}
 
-		defer func() {
-			err = server.Close()
+			if epoch := client.state.getLocalEpoch(); epoch != 2 {
+				t.Fatalf("Expected the client to be in epoch 2, got %d", epoch)
+			}
+			renegotiatedKeyingMaterial, err := client.ExportKeyingMaterial("EXPORTER-test", nil, 16)
 			if err != nil {
 				t.Fatal(err)
 			}
-			err = res.c.Close()
-			if err != nil {
+			if bytes.Equal(keyingMaterial, renegotiatedKeyingMaterial) {
+				t.Fatal("Renegotiation did not change the keys")
+			}
+
+			if err := client.Close(); err != nil {
 				t.Fatal(err)
 			}
-		}()
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
 	}
 }
 
-func TestSkipHelloVerify(t *testing.T) {
+func TestRenegotiateSpoofedVerifyData(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
-	ca, cb := dpipe.Pipe()
-	certificate, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
-	}
-	gotHello := make(chan struct{})
+	for name, tt := range map[string]struct {
+		spoof       func(client, server *Conn)
+		expectedErr error
+	}{
+		// A man-in-the-middle splicing its own handshake into the
+		// connection can't present the client's verify_data
+		"ClientHello": {
+			spoof: func(client, _ *Conn) {
+				client.state.clientVerifyData[0] ^= 0xff
+			},
+			expectedErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+		},
+		"ServerHello": {
+			spoof: func(_, server *Conn) {
+				server.state.serverVerifyData[0] ^= 0xff
+			},
+			expectedErr: errRenegotiationInfoMismatch,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
 
-	go func() {
-		server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-			Certificates:            []tls.Certificate{certificate},
-			LoggerFactory:           logging.NewDefaultLoggerFactory(),
-			InsecureSkipVerifyHello: true,
-		}, false)
-		if sErr != nil {
-			t.Error(sErr)
-			return
-		}
-		buf := make([]byte, 1024)
-		if _, sErr = server.Read(buf); sErr != nil {
-			t.Error(sErr)
-		}
-		gotHello <- struct{}{}
-		if sErr = server.Close(); sErr != nil { //nolint:contextcheck
-			t.Error(sErr)
-		}
-	}()
+			go func() {
+				client, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					InsecureSkipVerify: true,
+				}, false)
+				c <- result{client, err}
+			}()
 
-	client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
-		LoggerFactory:      logging.NewDefaultLoggerFactory(),
-		InsecureSkipVerify: true,
-	}, false)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if _, err = client.Write([]byte("hello")); err != nil {
-		t.Error(err)
-	}
-	select {
-	case <-gotHello:
-		// OK
-	case <-time.After(time.Second * 5):
-		t.Error("timeout")
-	}
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
 
-	if err = client.Close(); err != nil {
-		t.Error(err)
-	}
-}
+			tt.spoof(client, server)
 
-type connWithCallback struct {
-	net.Conn
-	onWrite func([]byte)
-}
+			err = client.Renegotiate()
+			var expectedAlert *alertError
+			if errors.As(tt.expectedErr, &expectedAlert) {
+				var e *alertError
+				if !errors.As(err, &e) || e.Description != expectedAlert.Description {
+					t.Fatalf("Expected error '%v', got '%v'", tt.expectedErr, err)
+				}
+			} else if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error '%v', got '%v'", tt.expectedErr, err)
+			}
 
-func (c *connWithCallback) Write(b []byte) (int, error) {
-	if c.onWrite != nil {
-		c.onWrite(b)
+			// Both sides abandon the connection
+			buf := make([]byte, 8)
+			if _, err := server.Read(buf); err == nil {
+				t.Fatal("Server connection is still usable")
+			}
+
+			_ = client.Close()
+			_ = server.Close()
+		})
 	}
-	return c.Conn.Write(b)
 }
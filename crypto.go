@@ -13,10 +13,12 @@ import (
 	"encoding/asn1"
 	"encoding/binary"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
-	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
 )
 
 type ecdsaSignature struct {
@@ -43,8 +45,9 @@ func valueKeyMessage(clientRandom, serverRandom, publicKey []byte, namedCurve el
 // hash/signature algorithm pair that appears in that extension
 //
 // https://tools.ietf.org/html/rfc5246#section-7.4.2
-func generateKeySignature(clientRandom, serverRandom, publicKey []byte, namedCurve elliptic.Curve, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
+func generateKeySignature(clientRandom, serverRandom, publicKey []byte, namedCurve elliptic.Curve, privateKey crypto.PrivateKey, signatureHashAlgorithm signaturehash.Algorithm) ([]byte, error) {
 	msg := valueKeyMessage(clientRandom, serverRandom, publicKey, namedCurve)
+	hashAlgorithm := signatureHashAlgorithm.DigestAlgorithm()
 	switch p := privateKey.(type) {
 	case ed25519.PrivateKey:
 		// https://crypto.stackexchange.com/a/55483
@@ -53,24 +56,40 @@ func generateKeySignature(clientRandom, serverRandom, publicKey []byte, namedCur
 		hashed := hashAlgorithm.Digest(msg)
 		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
 	case *rsa.PrivateKey:
-		hashed := hashAlgorithm.Digest(msg)
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return signRSA(p, msg, signatureHashAlgorithm)
 	}
 
 	return nil, errKeySignatureGenerateUnimplemented
 }
 
-func verifyKeySignature(message, remoteKeySignature []byte, hashAlgorithm hash.Algorithm, rawCertificates [][]byte) error { //nolint:dupl
+// peerPublicKey returns the public key of the leaf certificate of
+// rawCertificates, or the key itself if it holds a raw public key
+func peerPublicKey(rawCertificates [][]byte, rawPublicKey bool) (crypto.PublicKey, error) {
 	if len(rawCertificates) == 0 {
-		return errLengthMismatch
+		return nil, errLengthMismatch
+	}
+	if rawPublicKey {
+		return x509.ParsePKIXPublicKey(rawCertificates[0])
 	}
 	certificate, err := x509.ParseCertificate(rawCertificates[0])
+	if err != nil {
+		return nil, err
+	}
+	return certificate.PublicKey, nil
+}
+
+func verifyKeySignature(message, remoteKeySignature []byte, signatureHashAlgorithm signaturehash.Algorithm, rawCertificates [][]byte, rawPublicKey bool) error { //nolint:dupl
+	publicKey, err := peerPublicKey(rawCertificates, rawPublicKey)
 	if err != nil {
 		return err
 	}
 
-	switch p := certificate.PublicKey.(type) {
+	switch p := publicKey.(type) {
 	case ed25519.PublicKey:
+		// Ed25519 signs the message itself, a scheme naming a digest can't apply
+		if signatureHashAlgorithm.Signature != signature.Ed25519 {
+			return errKeySignatureMismatch
+		}
 		if ok := ed25519.Verify(p, message, remoteKeySignature); !ok {
 			return errKeySignatureMismatch
 		}
@@ -83,19 +102,13 @@ func verifyKeySignature(message, remoteKeySignature []byte, hashAlgorithm hash.A
 		if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
 			return errInvalidECDSASignature
 		}
-		hashed := hashAlgorithm.Digest(message)
+		hashed := signatureHashAlgorithm.DigestAlgorithm().Digest(message)
 		if !ecdsa.Verify(p, hashed, ecdsaSig.R, ecdsaSig.S) {
 			return errKeySignatureMismatch
 		}
 		return nil
 	case *rsa.PublicKey:
-		switch certificate.SignatureAlgorithm {
-		case x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA:
-			hashed := hashAlgorithm.Digest(message)
-			return rsa.VerifyPKCS1v15(p, hashAlgorithm.CryptoHash(), hashed, remoteKeySignature)
-		default:
-			return errKeySignatureVerifyUnimplemented
-		}
+		return verifyRSA(p, message, remoteKeySignature, signatureHashAlgorithm)
 	}
 
 	return errKeySignatureVerifyUnimplemented
@@ -109,7 +122,7 @@ func verifyKeySignature(message, remoteKeySignature []byte, hashAlgorithm hash.A
 // CertificateVerify message is sent to explicitly verify possession of
 // the private key in the certificate.
 // https://tools.ietf.org/html/rfc5246#section-7.3
-func generateCertificateVerify(handshakeBodies []byte, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
+func generateCertificateVerify(handshakeBodies []byte, privateKey crypto.PrivateKey, signatureHashAlgorithm signaturehash.Algorithm) ([]byte, error) {
 	if p, ok := privateKey.(ed25519.PrivateKey); ok {
 		// https://pkg.go.dev/crypto/ed25519#PrivateKey.Sign
 		// Sign signs the given message with priv. Ed25519 performs two passes over
@@ -117,29 +130,28 @@ func generateCertificateVerify(handshakeBodies []byte, privateKey crypto.Private
 		return p.Sign(rand.Reader, handshakeBodies, crypto.Hash(0))
 	}
 
-	hashed := hashAlgorithm.Digest(handshakeBodies)
-
 	switch p := privateKey.(type) {
 	case *ecdsa.PrivateKey:
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		hashAlgorithm := signatureHashAlgorithm.DigestAlgorithm()
+		return p.Sign(rand.Reader, hashAlgorithm.Digest(handshakeBodies), hashAlgorithm.CryptoHash())
 	case *rsa.PrivateKey:
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return signRSA(p, handshakeBodies, signatureHashAlgorithm)
 	}
 
 	return nil, errInvalidSignatureAlgorithm
 }
 
-func verifyCertificateVerify(handshakeBodies []byte, hashAlgorithm hash.Algorithm, remoteKeySignature []byte, rawCertificates [][]byte) error { //nolint:dupl
-	if len(rawCertificates) == 0 {
-		return errLengthMismatch
-	}
-	certificate, err := x509.ParseCertificate(rawCertificates[0])
+func verifyCertificateVerify(handshakeBodies []byte, signatureHashAlgorithm signaturehash.Algorithm, remoteKeySignature []byte, rawCertificates [][]byte, rawPublicKey bool) error { //nolint:dupl
+	publicKey, err := peerPublicKey(rawCertificates, rawPublicKey)
 	if err != nil {
 		return err
 	}
 
-	switch p := certificate.PublicKey.(type) {
+	switch p := publicKey.(type) {
 	case ed25519.PublicKey:
+		if signatureHashAlgorithm.Signature != signature.Ed25519 {
+			return errKeySignatureMismatch
+		}
 		if ok := ed25519.Verify(p, handshakeBodies, remoteKeySignature); !ok {
 			return errKeySignatureMismatch
 		}
@@ -152,24 +164,50 @@ func verifyCertificateVerify(handshakeBodies []byte, hashAlgorithm hash.Algorith
 		if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
 			return errInvalidECDSASignature
 		}
-		hash := hashAlgorithm.Digest(handshakeBodies)
+		hash := signatureHashAlgorithm.DigestAlgorithm().Digest(handshakeBodies)
 		if !ecdsa.Verify(p, hash, ecdsaSig.R, ecdsaSig.S) {
 			return errKeySignatureMismatch
 		}
 		return nil
 	case *rsa.PublicKey:
-		switch certificate.SignatureAlgorithm {
-		case x509.SHA1WithRSA, x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA:
-			hash := hashAlgorithm.Digest(handshakeBodies)
-			return rsa.VerifyPKCS1v15(p, hashAlgorithm.CryptoHash(), hash, remoteKeySignature)
-		default:
-			return errKeySignatureVerifyUnimplemented
-		}
+		return verifyRSA(p, handshakeBodies, remoteKeySignature, signatureHashAlgorithm)
 	}
 
 	return errKeySignatureVerifyUnimplemented
 }
 
+// pssOptions use a salt as long as the digest, as required for the
+// rsa_pss_rsae schemes
+// https://tools.ietf.org/html/rfc8446#section-4.2.3
+func pssOptions(signatureHashAlgorithm signaturehash.Algorithm) *rsa.PSSOptions {
+	return &rsa.PSSOptions{
+		SaltLength: rsa.PSSSaltLengthEqualsHash,
+		Hash:       signatureHashAlgorithm.DigestAlgorithm().CryptoHash(),
+	}
+}
+
+func signRSA(privateKey *rsa.PrivateKey, message []byte, signatureHashAlgorithm signaturehash.Algorithm) ([]byte, error) {
+	hashAlgorithm := signatureHashAlgorithm.DigestAlgorithm()
+	hashed := hashAlgorithm.Digest(message)
+	if signatureHashAlgorithm.IsPSS() {
+		return privateKey.Sign(rand.Reader, hashed, pssOptions(signatureHashAlgorithm))
+	}
+	return privateKey.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+}
+
+func verifyRSA(publicKey *rsa.PublicKey, message, remoteKeySignature []byte, signatureHashAlgorithm signaturehash.Algorithm) error {
+	hashAlgorithm := signatureHashAlgorithm.DigestAlgorithm()
+	hashed := hashAlgorithm.Digest(message)
+	switch {
+	case signatureHashAlgorithm.IsPSS():
+		return rsa.VerifyPSS(publicKey, hashAlgorithm.CryptoHash(), hashed, remoteKeySignature, pssOptions(signatureHashAlgorithm))
+	case signatureHashAlgorithm.Signature == signature.RSA:
+		return rsa.VerifyPKCS1v15(publicKey, hashAlgorithm.CryptoHash(), hashed, remoteKeySignature)
+	default:
+		return errKeySignatureVerifyUnimplemented
+	}
+}
+
 func loadCerts(rawCertificates [][]byte) ([]*x509.Certificate, error) {
 	if len(rawCertificates) == 0 {
 		return nil, errLengthMismatch
@@ -186,38 +224,112 @@ func loadCerts(rawCertificates [][]byte) ([]*x509.Certificate, error) {
 	return certs, nil
 }
 
-func verifyClientCert(rawCertificates [][]byte, roots *x509.CertPool) (chains [][]*x509.Certificate, err error) {
-	certificate, err := loadCerts(rawCertificates)
-	if err != nil {
-		return nil, err
-	}
+// intermediatePool returns the certificates sent after the leaf together
+// with the configured intermediates
+func intermediatePool(certificate []*x509.Certificate, intermediates *x509.CertPool) *x509.CertPool {
 	intermediateCAPool := x509.NewCertPool()
+	if intermediates != nil {
+		intermediateCAPool = intermediates.Clone()
+	}
 	for _, cert := range certificate[1:] {
 		intermediateCAPool.AddCert(cert)
 	}
+	return intermediateCAPool
+}
+
+func verifyClientCert(rawCertificates [][]byte, roots, intermediates *x509.CertPool, now time.Time) (chains [][]*x509.Certificate, err error) {
+	certificate, err := loadCerts(rawCertificates)
+	if err != nil {
+		return nil, err
+	}
 	opts := x509.VerifyOptions{
 		Roots:         roots,
-		CurrentTime:   time.Now(),
-		Intermediates: intermediateCAPool,
+		CurrentTime:   now,
+		Intermediates: intermediatePool(certificate, intermediates),
 		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
 	}
-	return certificate[0].Verify(opts)
+	chains, err = certificate[0].Verify(opts)
+	if err != nil {
+		return nil, &CertificateVerificationError{UnverifiedCertificates: certificate, Err: err}
+	}
+	return chains, nil
+}
+
+// lazyCertPool loads a certificate pool when it is first needed and keeps
+// it, loading concurrently is safe and happens once
+type lazyCertPool struct {
+	load func() (*x509.CertPool, error)
+
+	once sync.Once
+	pool *x509.CertPool
+	err  error
+}
+
+func (l *lazyCertPool) get() (*x509.CertPool, error) {
+	l.once.Do(func() {
+		l.pool, l.err = l.load()
+	})
+	return l.pool, l.err
 }
 
-func verifyServerCert(rawCertificates [][]byte, roots *x509.CertPool, serverName string) (chains [][]*x509.Certificate, err error) {
+// systemRoots are the roots of the host, used to verify server certificates
+// without Config.RootCAs. Connections which never verify a certificate do
+// not pay for loading them.
+var systemRoots = &lazyCertPool{load: x509.SystemCertPool} //nolint:gochecknoglobals
+
+func verifyServerCert(rawCertificates [][]byte, roots, intermediates *x509.CertPool, serverName string, now time.Time) (chains [][]*x509.Certificate, err error) {
 	certificate, err := loadCerts(rawCertificates)
 	if err != nil {
 		return nil, err
 	}
-	intermediateCAPool := x509.NewCertPool()
-	for _, cert := range certificate[1:] {
-		intermediateCAPool.AddCert(cert)
+	if roots == nil {
+		if roots, err = systemRoots.get(); err != nil {
+			return nil, &CertificateVerificationError{UnverifiedCertificates: certificate, Err: err}
+		}
 	}
 	opts := x509.VerifyOptions{
 		Roots:         roots,
-		CurrentTime:   time.Now(),
+		CurrentTime:   now,
 		DNSName:       serverName,
-		Intermediates: intermediateCAPool,
+		Intermediates: intermediatePool(certificate, intermediates),
+	}
+	chains, err = certificate[0].Verify(opts)
+	if err != nil {
+		return nil, &CertificateVerificationError{UnverifiedCertificates: certificate, Err: err}
+	}
+	return chains, nil
+}
+
+// completeCertificateChain appends the intermediates between the leaf and
+// the last of intermediates it chains to, if rawCertificates holds nothing
+// but the leaf
+func completeCertificateChain(rawCertificates [][]byte, intermediates *x509.CertPool, now time.Time) [][]byte {
+	if len(rawCertificates) != 1 {
+		return rawCertificates
+	}
+	leaf, err := x509.ParseCertificate(rawCertificates[0])
+	if err != nil {
+		return rawCertificates
+	}
+	// The roots are up to the peer, end the chain at any intermediate
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         intermediates,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return rawCertificates
+	}
+	longest := chains[0]
+	for _, chain := range chains[1:] {
+		if len(chain) > len(longest) {
+			longest = chain
+		}
+	}
+	completed := append([][]byte{}, rawCertificates...)
+	for _, cert := range longest[1:] {
+		completed = append(completed, cert.Raw)
 	}
-	return certificate[0].Verify(opts)
+	return completed
 }
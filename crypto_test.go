@@ -5,12 +5,17 @@ package dtls
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"testing"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
+	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
 )
 
 // nolint: gosec
@@ -68,10 +73,48 @@ func TestGenerateKeySignature(t *testing.T) {
 		0x87, 0x5e, 0x5c, 0x36, 0x75, 0x86,
 	}
 
-	signature, err := generateKeySignature(clientRandom, serverRandom, publicKey, elliptic.X25519, key, hash.SHA256)
+	signature, err := generateKeySignature(clientRandom, serverRandom, publicKey, elliptic.X25519, key, signaturehash.Algorithm{Hash: hash.SHA256, Signature: signature.RSA})
 	if err != nil {
 		t.Error(err)
 	} else if !bytes.Equal(expectedSignature, signature) {
 		t.Errorf("Signature generation failed \nexp % 02x \nactual % 02x ", expectedSignature, signature)
 	}
 }
+
+func TestEd25519CertificateVerify(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := selfsign.SelfSign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handshakeBodies := []byte("handshake messages")
+	ed25519Scheme := signaturehash.Algorithm{Hash: hash.Ed25519, Signature: signature.Ed25519}
+
+	sig, err := generateCertificateVerify(handshakeBodies, priv, ed25519Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ed25519 signs the message directly, not a digest of it
+	if !ed25519.Verify(priv.Public().(ed25519.PublicKey), handshakeBodies, sig) {
+		t.Fatal("CertificateVerify is not a signature over the handshake messages")
+	}
+
+	if err := verifyCertificateVerify(handshakeBodies, ed25519Scheme, sig, cert.Certificate, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyCertificateVerify([]byte("other messages"), ed25519Scheme, sig, cert.Certificate, false); err != errKeySignatureMismatch { //nolint:errorlint
+		t.Fatalf("Expected %v for modified messages, got %v", errKeySignatureMismatch, err)
+	}
+
+	ecdsaScheme := signaturehash.Algorithm{Hash: hash.SHA256, Signature: signature.ECDSA}
+	if err := verifyCertificateVerify(handshakeBodies, ecdsaScheme, sig, cert.Certificate, false); err != errKeySignatureMismatch { //nolint:errorlint
+		t.Fatalf("Expected %v for a scheme not matching the key, got %v", errKeySignatureMismatch, err)
+	}
+	if err := verifyKeySignature(handshakeBodies, sig, ecdsaScheme, cert.Certificate, false); err != errKeySignatureMismatch { //nolint:errorlint
+		t.Fatalf("Expected %v for a scheme not matching the key, got %v", errKeySignatureMismatch, err)
+	}
+}
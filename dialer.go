@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+const defaultDialerMaxSessions = 64
+
+// Dialer establishes DTLS connections and keeps the resumption state of the
+// servers it connected to, so that subsequent dials to the same remote
+// attempt an abbreviated handshake.
+// A Dialer is safe for concurrent use.
+type Dialer struct {
+	// Config is used for every connection. If Config.SessionStore is set it
+	// is used instead of the Dialer's own session cache.
+	Config *Config
+
+	// MaxSessions bounds the number of cached sessions. When the limit is
+	// reached the least recently used session is evicted.
+	// Defaults to 64.
+	MaxSessions int
+
+	once     sync.Once
+	sessions *MemorySessionStore
+}
+
+// DialContext connects to the address on the named network and establishes
+// a DTLS connection on top. Network must be "udp", "udp4" or "udp6".
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (*Conn, error) {
+	if d.Config == nil {
+		return nil, errNoConfigProvided
+	}
+
+	rAddr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := *d.Config
+	if config.SessionStore == nil {
+		config.SessionStore = d.sessionStore()
+	}
+
+	return DialWithContext(ctx, network, rAddr, &config)
+}
+
+func (d *Dialer) sessionStore() *MemorySessionStore {
+	d.once.Do(func() {
+		maxSessions := d.MaxSessions
+		if maxSessions <= 0 {
+			maxSessions = defaultDialerMaxSessions
+		}
+		d.sessions = NewMemorySessionStore(maxSessions)
+	})
+	return d.sessions
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestDialerResumesSession(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates: []tls.Certificate{cert},
+		SessionStore: &memSessStore{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+	defer func() {
+		_ = listener.Close()
+		wg.Wait()
+	}()
+
+	d := &Dialer{
+		Config: &Config{InsecureSkipVerify: true},
+	}
+
+	dial := func() State {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		conn, err := d.DialContext(ctx, "udp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		return conn.ConnectionState()
+	}
+
+	first := dial()
+	second := dial()
+	if len(first.SessionID) == 0 || !bytes.Equal(first.SessionID, second.SessionID) {
+		t.Errorf("Expected the session to be resumed: first(%x) second(%x)", first.SessionID, second.SessionID)
+	}
+	if !bytes.Equal(first.masterSecret, second.masterSecret) {
+		t.Error("Expected the master secret to be reused")
+	}
+
+	if d.Config.SessionStore != nil {
+		t.Error("Dialer must not modify its Config")
+	}
+}
@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Object identifiers of PBES2 https://tools.ietf.org/html/rfc8018#appendix-C
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}     //nolint:gochecknoglobals
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}     //nolint:gochecknoglobals
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}         //nolint:gochecknoglobals
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}         //nolint:gochecknoglobals
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}        //nolint:gochecknoglobals
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}        //nolint:gochecknoglobals
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}  //nolint:gochecknoglobals
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22} //nolint:gochecknoglobals
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42} //nolint:gochecknoglobals
+)
+
+// https://tools.ietf.org/html/rfc5958#section-3
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// https://tools.ietf.org/html/rfc8018#appendix-A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// https://tools.ietf.org/html/rfc8018#appendix-A.2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// LoadKeyPairWithPassword parses a PEM encoded certificate chain and private
+// key like tls.X509KeyPair. A private key in an ENCRYPTED PRIVATE KEY block is
+// decrypted with password first, keys encrypted with PBES2 using PBKDF2 and
+// AES-CBC are supported. Unencrypted keys are accepted as well.
+func LoadKeyPairWithPassword(certPEM, keyPEM []byte, password string) (*tls.Certificate, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errInvalidPrivateKeyPEM
+	}
+	if block.Type == "ENCRYPTED PRIVATE KEY" {
+		der, err := decryptPKCS8PrivateKey(block.Bytes, []byte(password))
+		if err != nil {
+			return nil, err
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// decryptPKCS8PrivateKey returns the DER encoded PKCS #8 private key of an
+// EncryptedPrivateKeyInfo
+func decryptPKCS8PrivateKey(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) != 0 {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, errUnsupportedPrivateKeyEncryption
+	}
+
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, errUnsupportedPrivateKeyEncryption
+	}
+	var kdfParams pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdfParams); err != nil {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+
+	var prf func() hash.Hash
+	switch alg := kdfParams.PRF.Algorithm; {
+	case len(alg) == 0, alg.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case alg.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	case alg.Equal(oidHMACWithSHA384):
+		prf = sha512.New384
+	case alg.Equal(oidHMACWithSHA512):
+		prf = sha512.New
+	default:
+		return nil, errUnsupportedPrivateKeyEncryption
+	}
+
+	var keyLen int
+	switch alg := params.EncryptionScheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		keyLen = 16
+	case alg.Equal(oidAES192CBC):
+		keyLen = 24
+	case alg.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, errUnsupportedPrivateKeyEncryption
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+	if kdfParams.IterationCount <= 0 || (kdfParams.KeyLength != 0 && kdfParams.KeyLength != keyLen) {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+	encrypted := info.EncryptedData
+	if len(encrypted) == 0 || len(encrypted)%aes.BlockSize != 0 {
+		return nil, errInvalidEncryptedPrivateKey
+	}
+
+	key := pbkdf2.Key(password, kdfParams.Salt, kdfParams.IterationCount, keyLen, prf)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+
+	// A wrong password turns the padding and the key into garbage
+	padding := int(decrypted[len(decrypted)-1])
+	if padding == 0 || padding > aes.BlockSize {
+		return nil, ErrIncorrectPassword
+	}
+	expected := make([]byte, padding)
+	for i := range expected {
+		expected[i] = byte(padding)
+	}
+	if !bytes.Equal(decrypted[len(decrypted)-padding:], expected) {
+		return nil, ErrIncorrectPassword
+	}
+	decrypted = decrypted[:len(decrypted)-padding]
+	if _, err := x509.ParsePKCS8PrivateKey(decrypted); err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	return decrypted, nil
+}
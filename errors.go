@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"context"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
@@ -18,46 +19,121 @@ import (
 // Typed errors
 var (
 	ErrConnClosed = &FatalError{Err: errors.New("conn is closed")} //nolint:goerr113
+	// ErrCloseNotifyTimeout is returned by Close when the peer did not
+	// acknowledge our close_notify within Config.CloseNotifyTimeout.
+	ErrCloseNotifyTimeout = &TimeoutError{Err: errors.New("close_notify was not acknowledged by the peer")} //nolint:goerr113
+	// ErrIncorrectPassword is returned by LoadKeyPairWithPassword when the
+	// private key can't be decrypted with the password.
+	ErrIncorrectPassword = &FatalError{Err: errors.New("incorrect password for encrypted private key")} //nolint:goerr113
+	// ErrPathMTU is matched by the error of a handshake which failed
+	// though the MTU of its unanswered flight was reduced, while the peer
+	// kept retransmitting its own flight. This hints that the datagrams were
+	// dropped for exceeding the path MTU rather than the peer going away.
+	ErrPathMTU = &TimeoutError{Err: errors.New("handshake flight was not answered, the path MTU is probably too small")} //nolint:goerr113
+	// ErrIdleTimeout is returned by reads of a connection closed because
+	// the peer sent nothing within Config.IdleTimeout.
+	ErrIdleTimeout = &TimeoutError{Err: errors.New("connection was idle for too long")} //nolint:goerr113
 
-	errDeadlineExceeded   = &TimeoutError{Err: fmt.Errorf("read/write timeout: %w", context.DeadlineExceeded)}
-	errInvalidContentType = &TemporaryError{Err: errors.New("invalid content type")} //nolint:goerr113
-
-	errBufferTooSmall               = &TemporaryError{Err: errors.New("buffer is too small")}                                        //nolint:goerr113
-	errContextUnsupported           = &TemporaryError{Err: errors.New("context is not supported for ExportKeyingMaterial")}          //nolint:goerr113
-	errHandshakeInProgress          = &TemporaryError{Err: errors.New("handshake is in progress")}                                   //nolint:goerr113
-	errReservedExportKeyingMaterial = &TemporaryError{Err: errors.New("ExportKeyingMaterial can not be used with a reserved label")} //nolint:goerr113
-	errApplicationDataEpochZero     = &TemporaryError{Err: errors.New("ApplicationData with epoch of 0")}                            //nolint:goerr113
-	errUnhandledContextType         = &TemporaryError{Err: errors.New("unhandled contentType")}                                      //nolint:goerr113
-
-	errCertificateVerifyNoCertificate    = &FatalError{Err: errors.New("client sent certificate verify but we have no certificate to verify")}                      //nolint:goerr113
-	errCipherSuiteNoIntersection         = &FatalError{Err: errors.New("client+server do not support any shared cipher suites")}                                    //nolint:goerr113
-	errClientCertificateNotVerified      = &FatalError{Err: errors.New("client sent certificate but did not verify it")}                                            //nolint:goerr113
-	errClientCertificateRequired         = &FatalError{Err: errors.New("server required client verification, but got none")}                                        //nolint:goerr113
-	errClientNoMatchingSRTPProfile       = &FatalError{Err: errors.New("server responded with SRTP Profile we do not support")}                                     //nolint:goerr113
-	errClientRequiredButNoServerEMS      = &FatalError{Err: errors.New("client required Extended Master Secret extension, but server does not support it")}         //nolint:goerr113
-	errCookieMismatch                    = &FatalError{Err: errors.New("client+server cookie does not match")}                                                      //nolint:goerr113
-	errIdentityNoPSK                     = &FatalError{Err: errors.New("PSK Identity Hint provided but PSK is nil")}                                                //nolint:goerr113
-	errInvalidCertificate                = &FatalError{Err: errors.New("no certificate provided")}                                                                  //nolint:goerr113
-	errInvalidCipherSuite                = &FatalError{Err: errors.New("invalid or unknown cipher suite")}                                                          //nolint:goerr113
-	errInvalidECDSASignature             = &FatalError{Err: errors.New("ECDSA signature contained zero or negative values")}                                        //nolint:goerr113
-	errInvalidPrivateKey                 = &FatalError{Err: errors.New("invalid private key type")}                                                                 //nolint:goerr113
-	errInvalidSignatureAlgorithm         = &FatalError{Err: errors.New("invalid signature algorithm")}                                                              //nolint:goerr113
-	errKeySignatureMismatch              = &FatalError{Err: errors.New("expected and actual key signature do not match")}                                           //nolint:goerr113
-	errNilNextConn                       = &FatalError{Err: errors.New("Conn can not be created with a nil nextConn")}                                              //nolint:goerr113
-	errNoAvailableCipherSuites           = &FatalError{Err: errors.New("connection can not be created, no CipherSuites satisfy this Config")}                       //nolint:goerr113
-	errNoAvailablePSKCipherSuite         = &FatalError{Err: errors.New("connection can not be created, pre-shared key present but no compatible CipherSuite")}      //nolint:goerr113
-	errNoAvailableCertificateCipherSuite = &FatalError{Err: errors.New("connection can not be created, certificate present but no compatible CipherSuite")}         //nolint:goerr113
-	errNoAvailableSignatureSchemes       = &FatalError{Err: errors.New("connection can not be created, no SignatureScheme satisfy this Config")}                    //nolint:goerr113
-	errNoCertificates                    = &FatalError{Err: errors.New("no certificates configured")}                                                               //nolint:goerr113
-	errNoConfigProvided                  = &FatalError{Err: errors.New("no config provided")}                                                                       //nolint:goerr113
-	errNoSupportedEllipticCurves         = &FatalError{Err: errors.New("client requested zero or more elliptic curves that are not supported by the server")}       //nolint:goerr113
-	errUnsupportedProtocolVersion        = &FatalError{Err: errors.New("unsupported protocol version")}                                                             //nolint:goerr113
-	errPSKAndIdentityMustBeSetForClient  = &FatalError{Err: errors.New("PSK and PSK Identity Hint must both be set for client")}                                    //nolint:goerr113
-	errRequestedButNoSRTPExtension       = &FatalError{Err: errors.New("SRTP support was requested but server did not respond with use_srtp extension")}            //nolint:goerr113
-	errServerNoMatchingSRTPProfile       = &FatalError{Err: errors.New("client requested SRTP but we have no matching profiles")}                                   //nolint:goerr113
-	errServerRequiredButNoClientEMS      = &FatalError{Err: errors.New("server requires the Extended Master Secret extension, but the client does not support it")} //nolint:goerr113
-	errVerifyDataMismatch                = &FatalError{Err: errors.New("expected and actual verify data does not match")}                                           //nolint:goerr113
-	errNotAcceptableCertificateChain     = &FatalError{Err: errors.New("certificate chain is not signed by an acceptable CA")}                                      //nolint:goerr113
+	errDeadlineExceeded         = &TimeoutError{Err: fmt.Errorf("read/write timeout: %w", context.DeadlineExceeded)}
+	errMaxRetransmits           = &TimeoutError{Err: errors.New("handshake flight was retransmitted too many times")} //nolint:goerr113
+	errHeartbeatTimeout         = &TimeoutError{Err: errors.New("no heartbeat response received")}                    //nolint:goerr113
+	errPostHandshakeAuthTimeout = &TimeoutError{Err: errors.New("client did not answer the certificate request")}     //nolint:goerr113
+	errHelloRequestTimeout      = &TimeoutError{Err: errors.New("client did not answer the hello request")}           //nolint:goerr113
+	errInvalidContentType       = &TemporaryError{Err: errors.New("invalid content type")}                            //nolint:goerr113
+
+	errBufferTooSmall                   = &TemporaryError{Err: errors.New("buffer is too small")}                                        //nolint:goerr113
+	errContextTooLong                   = &TemporaryError{Err: errors.New("context is too long for ExportKeyingMaterial")}               //nolint:goerr113
+	errInvalidSessionTicket             = &TemporaryError{Err: errors.New("invalid session ticket")}                                     //nolint:goerr113
+	errSessionTicketExpired             = &TemporaryError{Err: errors.New("session ticket has expired")}                                 //nolint:goerr113
+	errSessionTicketUnknownKey          = &TemporaryError{Err: errors.New("session ticket was encrypted with an unknown key")}           //nolint:goerr113
+	errHeartbeatNotAllowed              = &TemporaryError{Err: errors.New("peer does not allow heartbeat requests")}                     //nolint:goerr113
+	errHeartbeatPayloadTooLarge         = &TemporaryError{Err: errors.New("heartbeat payload is too large")}                             //nolint:goerr113
+	errHandshakeInProgress              = &TemporaryError{Err: errors.New("handshake is in progress")}                                   //nolint:goerr113
+	errClientCertificateRequestOnClient = &TemporaryError{Err: errors.New("only a server can request a client certificate")}             //nolint:goerr113
+	errPostHandshakeAuthNotOffered      = &TemporaryError{Err: errors.New("client did not offer post-handshake authentication")}         //nolint:goerr113
+	errNoClientCertificate              = &TemporaryError{Err: errors.New("client did not provide a certificate")}                       //nolint:goerr113
+	errRenegotiationInProgress          = &TemporaryError{Err: errors.New("renegotiation already in progress")}                          //nolint:goerr113
+	errRenegotiationNotSupported        = &TemporaryError{Err: errors.New("peer does not support secure renegotiation")}                 //nolint:goerr113
+	errReservedExportKeyingMaterial     = &TemporaryError{Err: errors.New("ExportKeyingMaterial can not be used with a reserved label")} //nolint:goerr113
+	errUnsupportedChannelBinding        = &TemporaryError{Err: errors.New("unsupported channel binding type")}                           //nolint:goerr113
+	errChannelBindingRequiresEMS        = &TemporaryError{Err: errors.New("tls-exporter requires the extended master secret")}           //nolint:goerr113
+	errApplicationDataEpochZero         = &TemporaryError{Err: errors.New("ApplicationData with epoch of 0")}                            //nolint:goerr113
+	errRecordSizeLimitExceeded          = &TemporaryError{Err: errors.New("record exceeds the advertised record size limit")}            //nolint:goerr113
+	errUnhandledContextType             = &TemporaryError{Err: errors.New("unhandled contentType")}                                      //nolint:goerr113
+
+	errALPNSelectorUnofferedProtocol       = &FatalError{Err: errors.New("ALPNSelector selected a protocol the client did not offer")}                                //nolint:goerr113
+	errCertificateVerifyNoCertificate      = &FatalError{Err: errors.New("client sent certificate verify but we have no certificate to verify")}                      //nolint:goerr113
+	errCipherSuiteNoIntersection           = &FatalError{Err: errors.New("client+server do not support any shared cipher suites")}                                    //nolint:goerr113
+	errClientCertificateNotVerified        = &FatalError{Err: errors.New("client sent certificate but did not verify it")}                                            //nolint:goerr113
+	errClientCertificateRequired           = &FatalError{Err: errors.New("server required client verification, but got none")}                                        //nolint:goerr113
+	errClientUnofferedALPS                 = &FatalError{Err: errors.New("server sent application settings we did not offer")}                                        //nolint:goerr113
+	errClientUnofferedALPNProtocol         = &FatalError{Err: errors.New("server selected an application protocol we did not offer")}                                 //nolint:goerr113
+	errClientNoMatchingSRTPProfile         = &FatalError{Err: errors.New("server responded with SRTP Profile we do not support")}                                     //nolint:goerr113
+	errClientRequiredButNoServerEMS        = &FatalError{Err: errors.New("client required Extended Master Secret extension, but server does not support it")}         //nolint:goerr113
+	errSessionExtendedMasterSecretMismatch = &FatalError{Err: errors.New("extended master secret support does not match the resumed session")}                        //nolint:goerr113
+	errCookieMismatch                      = &FatalError{Err: errors.New("client+server cookie does not match")}                                                      //nolint:goerr113
+	errCookieExpired                       = &FatalError{Err: errors.New("cookie has expired")}                                                                       //nolint:goerr113
+	errIdentityNoPSK                       = &FatalError{Err: errors.New("PSK Identity Hint provided but PSK is nil")}                                                //nolint:goerr113
+	errNoSCT                               = &FatalError{Err: errors.New("peer certificate has no signed certificate timestamps")}                                    //nolint:goerr113
+	errInvalidSCTList                      = &FatalError{Err: errors.New("invalid signed certificate timestamp list")}                                                //nolint:goerr113
+	errUnexpectedChangeCipherSpec          = &FatalError{Err: errors.New("ChangeCipherSpec received before its expected point")}                                      //nolint:goerr113
+	errClientUnofferedCertificateType      = &FatalError{Err: errors.New("server selected a certificate type we did not offer")}                                      //nolint:goerr113
+	errNoCommonCertificateType             = &FatalError{Err: errors.New("client offered no supported certificate type")}                                             //nolint:goerr113
+	errUnexpectedCertificateStatus         = &FatalError{Err: errors.New("server sent CertificateStatus without status_request extension")}                           //nolint:goerr113
+	errInvalidCertificateStatusType        = &FatalError{Err: errors.New("invalid certificate status type")}                                                          //nolint:goerr113
+	errInvalidCertificate                  = &FatalError{Err: errors.New("no certificate provided")}                                                                  //nolint:goerr113
+	errInvalidCipherSuite                  = &FatalError{Err: errors.New("invalid or unknown cipher suite")}                                                          //nolint:goerr113
+	errInvalidECDSASignature               = &FatalError{Err: errors.New("ECDSA signature contained zero or negative values")}                                        //nolint:goerr113
+	errInvalidHeartbeatMode                = &FatalError{Err: errors.New("invalid heartbeat mode")}                                                                   //nolint:goerr113
+	errInvalidVersionRange                 = &FatalError{Err: errors.New("MinVersion and MaxVersion must be DTLS 1.0 or 1.2 and include DTLS 1.2")}                   //nolint:goerr113
+	errInvalidRecordLayerVersion           = &FatalError{Err: errors.New("record layer version must be DTLS 1.0 or 1.2")}                                             //nolint:goerr113
+	errHeartbeatNotNegotiated              = &FatalError{Err: errors.New("received heartbeat message without negotiating the extension")}                             //nolint:goerr113
+	errInvalidMaxConcurrentHandshakes      = &FatalError{Err: errors.New("max concurrent handshakes must not be negative")}                                           //nolint:goerr113
+	errInvalidRateLimit                    = &FatalError{Err: errors.New("rate limit and its window must not be negative")}                                           //nolint:goerr113
+	errRenegotiationInfoMismatch           = &FatalError{Err: errors.New("renegotiation_info does not match the previous handshake")}                                 //nolint:goerr113
+	errInvalidSessionTicketKey             = &FatalError{Err: errors.New("session ticket key must be 32 bytes")}                                                      //nolint:goerr113
+	errInvalidMaxFragmentLength            = &FatalError{Err: errors.New("max fragment length must be one of 512, 1024, 2048 or 4096")}                               //nolint:goerr113
+	errMaxFragmentLengthMismatch           = &FatalError{Err: errors.New("server responded with a max fragment length we did not request")}                           //nolint:goerr113
+	errInvalidStatelessCookieSecret        = &FatalError{Err: errors.New("stateless cookie secret must be at least 16 bytes")}                                        //nolint:goerr113
+	errInvalidCookieLifetime               = &FatalError{Err: errors.New("cookie lifetime must not be negative")}                                                     //nolint:goerr113
+	errClientHelloNotCached                = &FatalError{Err: errors.New("ClientHello of the handshake is not cached")}                                               //nolint:goerr113
+	errStatelessCookieConflict             = &FatalError{Err: errors.New("stateless cookies can not be combined with cookie hooks or skipping HelloVerify")}          //nolint:goerr113
+	errInvalidHandshakeFragmentSize        = &FatalError{Err: errors.New("handshake fragment size must not be negative")}                                             //nolint:goerr113
+	errInvalidMTU                          = &FatalError{Err: errors.New("MTU must be larger than the record and handshake headers")}                                 //nolint:goerr113
+	errInvalidRecordPadding                = &FatalError{Err: errors.New("invalid record padding")}                                                                   //nolint:goerr113
+	errInvalidRecordSizeLimit              = &FatalError{Err: errors.New("record size limit must be between 64 and 16384")}                                           //nolint:goerr113
+	errInvalidPrivateKey                   = &FatalError{Err: errors.New("invalid private key type")}                                                                 //nolint:goerr113
+	errInvalidPrivateKeyPEM                = &FatalError{Err: errors.New("failed to find a PEM block in the private key")}                                            //nolint:goerr113
+	errInvalidEncryptedPrivateKey          = &FatalError{Err: errors.New("invalid encrypted private key")}                                                            //nolint:goerr113
+	errUnsupportedPrivateKeyEncryption     = &FatalError{Err: errors.New("private key encryption is not PBES2 with PBKDF2 and AES-CBC")}                              //nolint:goerr113
+	errInvalidSignatureAlgorithm           = &FatalError{Err: errors.New("invalid signature algorithm")}                                                              //nolint:goerr113
+	errKeySignatureMismatch                = &FatalError{Err: errors.New("expected and actual key signature do not match")}                                           //nolint:goerr113
+	errMigrationWithoutConnectionID        = &FatalError{Err: errors.New("connection can't migrate without a connection ID from the peer")}                           //nolint:goerr113
+	errReusePortUnsupported                = &FatalError{Err: errors.New("SO_REUSEPORT is not supported on this platform")}                                           //nolint:goerr113
+	errNilNextConn                         = &FatalError{Err: errors.New("Conn can not be created with a nil nextConn")}                                              //nolint:goerr113
+	errNoAvailableCipherSuites             = &FatalError{Err: errors.New("connection can not be created, no CipherSuites satisfy this Config")}                       //nolint:goerr113
+	errNoAvailablePSKCipherSuite           = &FatalError{Err: errors.New("connection can not be created, pre-shared key present but no compatible CipherSuite")}      //nolint:goerr113
+	errNoAvailableCertificateCipherSuite   = &FatalError{Err: errors.New("connection can not be created, certificate present but no compatible CipherSuite")}         //nolint:goerr113
+	errNoAvailableSignatureSchemes         = &FatalError{Err: errors.New("connection can not be created, no SignatureScheme satisfy this Config")}                    //nolint:goerr113
+	errNoCertificates                      = &FatalError{Err: errors.New("no certificates configured")}                                                               //nolint:goerr113
+	errNoConfigProvided                    = &FatalError{Err: errors.New("no config provided")}                                                                       //nolint:goerr113
+	errNoSupportedEllipticCurves           = &FatalError{Err: errors.New("client requested zero or more elliptic curves that are not supported by the server")}       //nolint:goerr113
+	errInappropriateFallback               = &FatalError{Err: errors.New("client sent TLS_FALLBACK_SCSV with a version lower than supported")}                        //nolint:goerr113
+	errUnsupportedProtocolVersion          = &FatalError{Err: errors.New("unsupported protocol version")}                                                             //nolint:goerr113
+	errPSKAndIdentityMustBeSetForClient    = &FatalError{Err: errors.New("PSK and PSK Identity Hint must both be set for client")}                                    //nolint:goerr113
+	errRequestedButNoSRTPExtension         = &FatalError{Err: errors.New("SRTP support was requested but server did not respond with use_srtp extension")}            //nolint:goerr113
+	errNoSRTPProtectionProfile             = &FatalError{Err: errors.New("no SRTP protection profile was negotiated")}                                                //nolint:goerr113
+	errUnknownPRFHash                      = &FatalError{Err: errors.New("PRF hash of the cipher suite is unknown")}                                                  //nolint:goerr113
+	errUnknownKeyLengths                   = &FatalError{Err: errors.New("key lengths of the cipher suite are unknown")}                                              //nolint:goerr113
+	errCipherSuiteHashMismatch             = &FatalError{Err: errors.New("cipher suite derives its keys with a different hash than its HashFunc")}                    //nolint:goerr113
+	errServerNoMatchingSRTPProfile         = &FatalError{Err: errors.New("client requested SRTP but we have no matching profiles")}                                   //nolint:goerr113
+	errServerRequiredButNoClientEMS        = &FatalError{Err: errors.New("server requires the Extended Master Secret extension, but the client does not support it")} //nolint:goerr113
+	errInvalidVerifyDataLength             = &FatalError{Err: errors.New("verify data length does not match the cipher suite")}                                       //nolint:goerr113
+	errVerifyDataMismatch                  = &FatalError{Err: errors.New("expected and actual verify data does not match")}                                           //nolint:goerr113
+	errNotAcceptableCertificateChain       = &FatalError{Err: errors.New("certificate chain is not signed by an acceptable CA")}                                      //nolint:goerr113
+	errCertificateSignatureScheme          = &FatalError{Err: errors.New("certificate is signed with a signature scheme that is not accepted")}                       //nolint:goerr113
+	errUnsupportedStateVersion             = &FatalError{Err: errors.New("serialized state has an unsupported format version")}                                       //nolint:goerr113
+	errStateCipherSuiteMismatch            = &FatalError{Err: errors.New("cipher suite of the serialized state is not enabled in the Config")}                        //nolint:goerr113
 
 	errInvalidFlight                     = &InternalError{Err: errors.New("invalid flight number")}                           //nolint:goerr113
 	errKeySignatureGenerateUnimplemented = &InternalError{Err: errors.New("unable to generate key signature, unimplemented")} //nolint:goerr113
@@ -83,8 +159,55 @@ type TemporaryError = protocol.TemporaryError
 // TimeoutError indicates that the request was timed out.
 type TimeoutError = protocol.TimeoutError
 
-// HandshakeError indicates that the handshake failed.
-type HandshakeError = protocol.HandshakeError
+// HandshakeError indicates that the handshake failed. It is returned by the
+// handshake of Dial, Client, Server and Accept of a Listener and can be
+// inspected with errors.As.
+type HandshakeError struct {
+	// Err is the cause of the failure.
+	Err error
+	// Alert is the fatal alert received from or sent to the peer, nil if the
+	// handshake failed without one, e.g. on a timeout.
+	Alert *alert.Alert
+	// Flight names the flight the handshake failed in, e.g. "Flight 4".
+	Flight string
+}
+
+// Timeout implements net.Error.Timeout()
+func (e *HandshakeError) Timeout() bool {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// Temporary implements net.Error.Temporary()
+func (e *HandshakeError) Temporary() bool {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		return netErr.Temporary() //nolint
+	}
+	return false
+}
+
+// Unwrap implements Go1.13 error unwrapper.
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// As keeps errors.As with a *protocol.HandshakeError target working.
+func (e *HandshakeError) As(target interface{}) bool {
+	if t, ok := target.(**protocol.HandshakeError); ok {
+		*t = &protocol.HandshakeError{Err: e.Err}
+		return true
+	}
+	return false
+}
+
+func (e *HandshakeError) Error() string {
+	if e.Flight == "" {
+		return fmt.Sprintf("handshake error: %v", e.Err)
+	}
+	return fmt.Sprintf("handshake error in %s: %v", e.Flight, e.Err)
+}
 
 // errInvalidCipherSuite indicates an attempt at using an unsupported cipher suite.
 type invalidCipherSuiteError struct {
@@ -103,6 +226,37 @@ func (e *invalidCipherSuiteError) Is(err error) bool {
 	return false
 }
 
+// pathMTUError wraps the cause of a failed handshake whose MTU was reduced
+type pathMTUError struct {
+	err error
+}
+
+func (e *pathMTUError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrPathMTU.Err, e.err)
+}
+
+func (e *pathMTUError) Unwrap() error { return e.err }
+
+func (e *pathMTUError) Is(err error) bool { return err == ErrPathMTU } //nolint:goerr113
+
+// CertificateVerificationError is returned when the peer certificate chain
+// could not be verified against the configured roots. The underlying
+// x509 error (for example x509.UnknownAuthorityError, x509.HostnameError or
+// x509.CertificateInvalidError) can be retrieved with errors.As.
+type CertificateVerificationError struct {
+	// UnverifiedCertificates are the certificates sent by the peer, leaf first.
+	UnverifiedCertificates []*x509.Certificate
+	Err                    error
+}
+
+func (e *CertificateVerificationError) Error() string {
+	return fmt.Sprintf("failed to verify certificate: %v", e.Err)
+}
+
+func (e *CertificateVerificationError) Unwrap() error {
+	return e.Err
+}
+
 // errAlert wraps DTLS alert notification as an error
 type alertError struct {
 	*alert.Alert
@@ -116,6 +270,13 @@ func (e *alertError) IsFatalOrCloseNotify() bool {
 	return e.Level == alert.Fatal || e.Description == alert.CloseNotify
 }
 
+// closesConnection reports whether the received alert ends the connection.
+// Warnings leave it open, except a user_canceled aborting the handshake.
+// https://datatracker.ietf.org/doc/html/rfc5246#section-7.2.2
+func (e *alertError) closesConnection(handshakeCompleted bool) bool {
+	return e.IsFatalOrCloseNotify() || (!handshakeCompleted && e.Description == alert.UserCanceled)
+}
+
 func (e *alertError) Is(err error) bool {
 	var other *alertError
 	if errors.As(err, &other) {
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package main implements an example of two DTLS listeners sharing a port
+// with SO_REUSEPORT, e.g. one per core.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrian38/dtls/v2"
+	"github.com/adrian38/dtls/v2/examples/util"
+	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+)
+
+const clients = 8
+
+func main() {
+	// Prepare the IP to listen on
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4444}
+
+	// Generate a certificate and private key to secure the connection
+	certificate, genErr := selfsign.GenerateSelfSigned()
+	util.Check(genErr)
+
+	//
+	// Everything below is the pion-DTLS API! Thanks for using it ❤️.
+	//
+
+	config := &dtls.Config{
+		Certificates:         []tls.Certificate{certificate},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	// Bind both listeners to the same port, the kernel distributes the
+	// clients among them
+	lc := dtls.ListenConfig{ReusePort: true}
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		listener, err := lc.Listen("udp", addr, config)
+		util.Check(err)
+		defer func() {
+			util.Check(listener.Close())
+		}()
+
+		i := i
+		go func() {
+			for {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				fmt.Printf("Listener %d accepted %s\n", i, conn.RemoteAddr())
+				_ = conn.Close()
+				wg.Done()
+			}
+		}()
+	}
+	fmt.Println("Listening")
+
+	// Every client uses its own source port
+	wg.Add(clients)
+	for i := 0; i < clients; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		conn, err := dtls.DialWithContext(ctx, "udp", addr, &dtls.Config{
+			InsecureSkipVerify:   true,
+			ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+		})
+		cancel()
+		util.Check(err)
+		util.Check(conn.Close())
+	}
+	wg.Wait()
+}
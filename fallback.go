@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+)
+
+// fallbackSCSV signals that a client retries with a lower protocol version
+// than it supports https://tools.ietf.org/html/rfc7507#section-2
+const fallbackSCSV = 0x5600
+
+// clientHelloCipherSuiteIDs returns the cipher suites offered in ClientHello
+func clientHelloCipherSuiteIDs(cfg *handshakeConfig) []uint16 {
+	ids := cipherSuiteIDs(cfg.localCipherSuites)
+	if cfg.fallbackSCSV {
+		ids = append(ids, fallbackSCSV)
+	}
+	return ids
+}
+
+// verifyFallbackSCSV aborts the handshake of a client that fell back to a
+// lower version than the highest one we support, DTLS 1.2
+// https://tools.ietf.org/html/rfc7507#section-3
+func verifyFallbackSCSV(clientHello *handshake.MessageClientHello) (*alert.Alert, error) {
+	if clientHello.Version.Equal(protocol.Version1_2) {
+		return nil, nil //nolint:nilnil
+	}
+	for _, id := range clientHello.CipherSuiteIDs {
+		if id == fallbackSCSV {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.InappropriateFallback}, errInappropriateFallback
+		}
+	}
+	return nil, nil //nolint:nilnil
+}
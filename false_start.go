@@ -0,0 +1,107 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"sync/atomic"
+)
+
+// falseStartCipherSuite reports whether application data may be protected
+// by c before the server's Finished was verified. Only the built-in suites
+// with a forward secret key exchange and an AEAD cipher qualify.
+// https://datatracker.ietf.org/doc/html/rfc7918#section-3
+func falseStartCipherSuite(c CipherSuite) bool {
+	return c.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe) &&
+		!supportsEncryptThenMAC(c) &&
+		cipherSuiteForID(c.ID(), nil) != nil
+}
+
+// startFalseStart lets the application send data once the client sent its
+// Finished. Data received until the server's Finished is verified is held
+// back from Read.
+func (c *Conn) startFalseStart() {
+	c.falseStartDone = make(chan struct{})
+	atomic.StoreInt32(&c.falseStartPending, 1)
+}
+
+func (c *Conn) isFalseStartPending() bool {
+	return atomic.LoadInt32(&c.falseStartPending) == 1
+}
+
+// finishFalseStart ends a false start once the server's Finished was
+// verified, or with the error the handshake failed with
+func (c *Conn) finishFalseStart(err error) {
+	c.falseStartLock.Lock()
+	defer c.falseStartLock.Unlock()
+
+	if !c.isFalseStartPending() {
+		return
+	}
+	atomic.StoreInt32(&c.falseStartPending, 0)
+	c.falseStartErr = err
+	if err != nil {
+		for _, data := range c.falseStartData {
+			putReadBuffer(data)
+		}
+		c.falseStartData = nil
+	}
+	close(c.falseStartDone)
+}
+
+// bufferFalseStartData holds back application data received before the
+// server's Finished was verified, it returns false once it was
+func (c *Conn) bufferFalseStartData(data *[]byte) bool {
+	c.falseStartLock.Lock()
+	defer c.falseStartLock.Unlock()
+
+	if !c.isFalseStartPending() {
+		return false
+	}
+	c.falseStartData = append(c.falseStartData, data)
+	return true
+}
+
+// nextFalseStartRecord waits for a false started handshake to complete and
+// returns the application data held back meanwhile, in the order it was
+// received. It returns nil once all of it was read.
+func (c *Conn) nextFalseStartRecord() (*[]byte, error) {
+	if c.falseStartDone == nil {
+		return nil, nil //nolint:nilnil
+	}
+	select {
+	case <-c.falseStartDone:
+	case <-c.readDeadline.Done():
+		return nil, errDeadlineExceeded
+	}
+
+	c.falseStartLock.Lock()
+	defer c.falseStartLock.Unlock()
+
+	if c.falseStartErr != nil {
+		return nil, c.falseStartErr
+	}
+	if len(c.falseStartData) == 0 {
+		return nil, nil //nolint:nilnil
+	}
+	data := c.falseStartData[0]
+	c.falseStartData = c.falseStartData[1:]
+	return data, nil
+}
+
+// awaitFalseStart runs the handshake to its end after handshake returned
+// early for a false start. The connection is closed if the handshake fails,
+// Read then returns its error.
+func (c *Conn) awaitFalseStart(firstErr <-chan error, done <-chan struct{}) {
+	defer c.handshakeLoopsFinished.Done()
+
+	select {
+	case <-done:
+	case err := <-firstErr:
+		if err = c.translateHandshakeCtxError(err); err == nil {
+			err = ErrConnClosed
+		}
+		c.finishFalseStart(err)
+		_ = c.close(false)
+	}
+}
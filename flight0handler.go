@@ -4,29 +4,47 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
+	"io"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
-	"github.com/adrian38/dtls/v2/pkg/protocol"
 	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
 	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 )
 
 func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
-	seq, msgs, ok := cache.fullPullMap(0, state.cipherSuite,
+	// A ClientHello answering a HelloVerifyRequest sent by a stateless
+	// listener or another server does not start at message sequence zero, the
+	// ServerHello then takes its message sequence
+	startSeq := 0
+	if cfg.cookieVerifier != nil && !cfg.isRenegotiation() {
+		if clientHelloSeq, ok := cache.messageSequence(handshake.TypeClientHello, cfg.initialEpoch, true); ok {
+			startSeq = clientHelloSeq
+		}
+	}
+	seq, msgs, ok := cache.fullPullMap(startSeq, state.cipherSuite,
 		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 	)
 	if !ok {
 		// No valid message received. Keep reading
 		return 0, nil, nil
 	}
+	cfg.setHandshakeSendSequence(state, startSeq)
 
 	// Connection Identifiers must be negotiated afresh on session resumption.
 	// https://datatracker.ietf.org/doc/html/rfc9146#name-the-connection_id-extension
-	state.localConnectionID = nil
-	state.remoteConnectionID = nil
+	state.sessionTicketNegotiated = false
+	if !cfg.isRenegotiation() {
+		state.localConnectionID = nil
+		state.remoteConnectionID = nil
+		state.localHeartbeatMode = 0
+		state.remoteHeartbeatMode = 0
+		state.maxFragmentLength = 0
+		state.recordPadding = false
+		state.remotePostHandshakeAuth = false
+	}
 
 	state.handshakeRecvSequence = seq
 
@@ -37,24 +55,34 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
 	}
 
-	if !clientHello.Version.Equal(protocol.Version1_2) {
+	if a, err := verifyFallbackSCSV(clientHello); err != nil {
+		return 0, a, err
+	}
+	if !cfg.acceptsVersion(clientHello.Version) {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
 	}
 
-	state.remoteRandom = clientHello.Random
-
-	cipherSuites := []CipherSuite{}
-	for _, id := range clientHello.CipherSuiteIDs {
-		if c := cipherSuiteForID(CipherSuiteID(id), cfg.customCipherSuites); c != nil {
-			cipherSuites = append(cipherSuites, c)
+	if cfg.onClientHello != nil {
+		if err := cfg.onClientHello(clientHello); err != nil {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
 		}
 	}
 
-	if state.cipherSuite, ok = findMatchingCipherSuite(cipherSuites, cfg.localCipherSuites); !ok {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errCipherSuiteNoIntersection
-	}
+	state.remoteRandom = clientHello.Random
+
+	var (
+		sessionTicket           []byte
+		remoteRenegotiationInfo *extension.RenegotiationInfo
+		remoteEncryptThenMAC    bool
+
+		remoteClientCertificateTypes []extension.CertificateType
+		remoteServerCertificateTypes []extension.CertificateType
+	)
 
 	for _, val := range clientHello.Extensions {
+		if cfg.isRenegotiation() && isPerConnectionExtension(val) {
+			continue
+		}
 		switch e := val.(type) {
 		case *extension.SupportedEllipticCurves:
 			if len(e.EllipticCurves) == 0 {
@@ -62,7 +90,9 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 			}
 			state.namedCurve = e.EllipticCurves[0]
 		case *extension.UseSRTP:
-			profile, ok := findMatchingSRTPProfile(e.ProtectionProfiles, cfg.localSRTPProtectionProfiles)
+			// The server's order of preference decides
+			state.remoteSRTPProtectionProfiles = e.ProtectionProfiles
+			profile, ok := findMatchingSRTPProfile(cfg.localSRTPProtectionProfiles, e.ProtectionProfiles)
 			if !ok {
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errServerNoMatchingSRTPProfile
 			}
@@ -71,22 +101,105 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 			if cfg.extendedMasterSecret != DisableExtendedMasterSecret {
 				state.extendedMasterSecret = true
 			}
+		case *extension.EncryptThenMAC:
+			remoteEncryptThenMAC = true
+		case *extension.SupportedSignatureAlgorithms:
+			state.remoteSignatureSchemes = e.SignatureHashAlgorithms
+		case *extension.SignedCertificateTimestamp:
+			state.remoteRequestedSCT = true
+		case *extension.StatusRequest:
+			state.remoteRequestedOCSP = e.StatusType == extension.CertificateStatusTypeOCSP
+		case *extension.PostHandshakeAuth:
+			state.remotePostHandshakeAuth = true
+		case *extension.TrustedCAKeys:
+			state.remoteTrustedAuthorities = e.TrustedAuthorities
 		case *extension.ServerName:
 			state.serverName = e.ServerName // remote server name
 		case *extension.ALPN:
 			state.peerSupportedProtocols = e.ProtocolNameList
+		case *extension.ApplicationSettings:
+			state.remoteApplicationSettings = e
 		case *extension.ConnectionID:
 			// Only set connection ID to be sent if server supports connection
 			// IDs.
 			if cfg.connectionIDGenerator != nil {
 				state.remoteConnectionID = e.CID
 			}
+		case *extension.MaxFragmentLength:
+			state.maxFragmentLength = e.Length
+		case *extension.RecordSizeLimit:
+			limit, a, err := parseRecordSizeLimit(e.RecordSizeLimit)
+			if err != nil {
+				return 0, a, err
+			}
+			state.remoteRecordSizeLimit = limit
+			state.localRecordSizeLimit = cfg.recordSizeLimit
+			if state.localRecordSizeLimit == 0 {
+				state.localRecordSizeLimit = maxRecordSizeLimit
+			}
+		case *extension.SessionTicket:
+			sessionTicket = e.Ticket
+			state.sessionTicketNegotiated = len(cfg.sessionTicketKey) > 0
+		case *extension.Heartbeat:
+			if cfg.heartbeatMode != 0 {
+				state.localHeartbeatMode = cfg.heartbeatMode
+				state.remoteHeartbeatMode = e.Mode
+			}
+		case *extension.RecordPadding:
+			state.recordPadding = true
+		case *extension.ClientCertificateType:
+			remoteClientCertificateTypes = e.CertificateTypes
+		case *extension.ServerCertificateType:
+			remoteServerCertificateTypes = e.CertificateTypes
+		case *extension.RenegotiationInfo:
+			remoteRenegotiationInfo = e
+		}
+	}
+
+	if a, err := verifyClientRenegotiationInfo(clientHello, remoteRenegotiationInfo, state, cfg); err != nil {
+		return 0, a, err
+	}
+
+	cipherSuites := []CipherSuite{}
+	supportedCipherSuites := []CipherSuite{}
+	for _, id := range clientHello.CipherSuiteIDs {
+		if c := cipherSuiteForID(CipherSuiteID(id), cfg.customCipherSuites); c != nil {
+			cipherSuites = append(cipherSuites, c)
+			if cfg.canAuthenticate(c, state.remoteSignatureSchemes) {
+				supportedCipherSuites = append(supportedCipherSuites, c)
+			}
+		}
+	}
+
+	selectCipherSuite := findMatchingCipherSuite
+	if cfg.preferServerCipherSuites {
+		selectCipherSuite = findPreferredCipherSuite
+	}
+	// Prefer the cipher suites one of our certificates can authenticate with
+	// the signature schemes of the client
+	if state.cipherSuite, ok = selectCipherSuite(supportedCipherSuites, cfg.localCipherSuites); !ok {
+		if state.cipherSuite, ok = selectCipherSuite(cipherSuites, cfg.localCipherSuites); !ok {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errCipherSuiteNoIntersection
 		}
 	}
+	// The extension is only confirmed for CBC cipher suites
+	// https://datatracker.ietf.org/doc/html/rfc7366#section-3
+	state.encryptThenMAC = remoteEncryptThenMAC && !cfg.disableEncryptThenMAC && supportsEncryptThenMAC(state.cipherSuite)
+
+	if a, err := negotiateCertificateTypes(state, cfg, remoteClientCertificateTypes, remoteServerCertificateTypes); err != nil {
+		return 0, a, err
+	}
+	cache.setRawPublicKeys(state.rawPublicKey(true), state.rawPublicKey(false))
+
+	// record_size_limit replaces max_fragment_length when both are offered
+	// https://datatracker.ietf.org/doc/html/rfc8449#section-5
+	if state.remoteRecordSizeLimit != 0 && state.maxFragmentLength != 0 {
+		state.maxFragmentLength = 0
+	}
 
 	// If the client doesn't support connection IDs, the server should not
 	// expect one to be sent.
-	if state.remoteConnectionID == nil {
+	if state.remoteConnectionID == nil && state.localConnectionID != nil {
 		state.localConnectionID = nil
 	}
 
@@ -96,60 +209,150 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 
 	if state.localKeypair == nil {
 		var err error
-		state.localKeypair, err = elliptic.GenerateKeypair(state.namedCurve)
+		state.localKeypair, err = elliptic.GenerateKeypairFrom(state.namedCurve, cfg.rand)
 		if err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, err
 		}
 	}
 
-	// nextFlight := flight2
+	// The client was already verified by the previous handshake
+	if cfg.isRenegotiation() {
+		return flight4, nil, nil
+	}
 
-	// if cfg.insecureSkipHelloVerify {
-	// 	nextFlight = flight4
-	// }
+	nextFlight := flight2
+
+	switch {
+	case cfg.insecureSkipHelloVerify && len(state.remoteConnectionID) > 0:
+		// The cookie exchange is only skipped for clients sending a non-empty
+		// connection ID, any other client is verified as usual. A zero-length
+		// connection ID means the client does not want to be addressed by one.
+		nextFlight = flight4
+	case cfg.cookieVerifier != nil && len(clientHello.Cookie) > 0:
+		// The cookie may have been issued by another server sharing the
+		// cookie secret, skip the HelloVerifyRequest if it is valid
+		random := clientHello.Random.MarshalFixed()
+		if err := cfg.cookieVerifier(clientHello.Cookie, random[:]); err != nil {
+			cfg.log.Debugf("[handshake] reject cookie: %v", err)
+		} else {
+			nextFlight = flight4
+		}
+	}
+	if nextFlight == flight2 && cfg.statelessCookieGenerator != nil {
+		random := clientHello.Random.MarshalFixed()
+		state.cookie = cfg.statelessCookieGenerator(random[:])
+	}
 
-	// return handleHelloResume(clientHello.SessionID, state, cfg, nextFlight)
-	return flight2, nil, nil
+	return handleHelloResume(clientHello.SessionID, sessionTicket, state, cfg, nextFlight)
+}
+
+// verifyClientRenegotiationInfo checks the renegotiation_info of a ClientHello,
+// it must be empty in the initial handshake and carry the client's verify_data
+// of the previous handshake when renegotiating
+// https://tools.ietf.org/html/rfc5746#section-3.6
+func verifyClientRenegotiationInfo(clientHello *handshake.MessageClientHello, remoteRenegotiationInfo *extension.RenegotiationInfo, state *State, cfg *handshakeConfig) (*alert.Alert, error) {
+	if cfg.isRenegotiation() {
+		if remoteRenegotiationInfo == nil || !bytes.Equal(remoteRenegotiationInfo.VerifyData, state.clientVerifyData) {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+		}
+		return nil, nil //nolint:nilnil
+	}
+	if remoteRenegotiationInfo != nil && len(remoteRenegotiationInfo.VerifyData) > 0 {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+	}
+	state.secureRenegotiation = remoteRenegotiationInfo != nil
+	for _, id := range clientHello.CipherSuiteIDs {
+		if id == renegotiationInfoSCSV {
+			state.secureRenegotiation = true
+		}
+	}
+	return nil, nil //nolint:nilnil
 }
 
-func handleHelloResume(sessionID []byte, state *State, cfg *handshakeConfig, next flightVal) (flightVal, *alert.Alert, error) {
+func handleHelloResume(sessionID, sessionTicket []byte, state *State, cfg *handshakeConfig, next flightVal) (flightVal, *alert.Alert, error) {
+	// A client presenting a ticket sends a session ID to detect whether the
+	// ticket was accepted, any problem with the ticket falls back to a full handshake.
+	// https://tools.ietf.org/html/rfc5077#section-3.4
+	//
+	// A session using the extended master secret must not be resumed without
+	// it, a session not using it is only resumed without it.
+	// https://tools.ietf.org/html/rfc7627#section-5.3
+	if len(sessionID) > 0 && len(sessionTicket) > 0 && len(cfg.sessionTicketKey) > 0 {
+		s, err := decryptSessionTicket(cfg.sessionTicketKey, sessionTicket, cfg.sessionTicketLifetime, cfg.now())
+		switch {
+		case err != nil:
+			cfg.log.Debugf("[handshake] reject session ticket: %v", err)
+		case s.extendedMasterSecret && !state.extendedMasterSecret:
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errSessionExtendedMasterSecretMismatch
+		case s.cipherSuiteID != state.cipherSuite.ID() || s.extendedMasterSecret != state.extendedMasterSecret:
+			cfg.log.Debugf("[handshake] reject session ticket: session parameters do not match")
+		default:
+			cfg.log.Tracef("[handshake] resume session from ticket: %x", sessionID)
+			return resumeSession(sessionID, s.masterSecret, state, cfg)
+		}
+	}
+
 	if len(sessionID) > 0 && cfg.sessionStore != nil {
 		if s, err := cfg.sessionStore.Get(sessionID); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		} else if s.ID != nil {
-			cfg.log.Tracef("[handshake] resume session: %x", sessionID)
-
-			state.SessionID = sessionID
-			state.masterSecret = s.Secret
-
-			if err := state.initCipherSuite(); err != nil {
-				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			switch {
+			case s.ExtendedMasterSecret && !state.extendedMasterSecret:
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errSessionExtendedMasterSecretMismatch
+			case !s.ExtendedMasterSecret && state.extendedMasterSecret:
+				cfg.log.Debugf("[handshake] reject session: session did not use the extended master secret")
+			default:
+				cfg.log.Tracef("[handshake] resume session: %x", sessionID)
+				return resumeSession(sessionID, s.Secret, state, cfg)
 			}
-
-			clientRandom := state.localRandom.MarshalFixed()
-			cfg.writeKeyLog(keyLogLabelTLS12, clientRandom[:], state.masterSecret)
-
-			return flight4b, nil, nil
 		}
 	}
 	return next, nil, nil
 }
 
-func flight0Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+func resumeSession(sessionID, masterSecret []byte, state *State, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
+	state.SessionID = sessionID
+	state.masterSecret = masterSecret
+	// No new ticket is issued on an abbreviated handshake
+	state.sessionTicketNegotiated = false
+
+	if err := state.initCipherSuite(); err != nil {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	}
+
+	clientRandom := state.remoteRandom.MarshalFixed()
+	cfg.writeKeyLog(keyLogLabelTLS12, clientRandom[:], state.masterSecret)
+
+	return flight4b, nil, nil
+}
+
+func flight0Generate(_ context.Context, _ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	// Initialize
-	if !cfg.insecureSkipHelloVerify {
+	switch {
+	case cfg.isRenegotiation():
+	case cfg.statelessCookieGenerator != nil:
+		// The cookie is bound to the client random of the ClientHello
+		state.cookie = nil
+	case cfg.cookieGenerator != nil:
+		var err error
+		if state.cookie, err = cfg.cookieGenerator(); err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+	default:
 		state.cookie = make([]byte, cookieLength)
-		if _, err := rand.Read(state.cookie); err != nil {
+		if _, err := io.ReadFull(cfg.rand, state.cookie); err != nil {
 			return nil, nil, err
 		}
 	}
 
-	var zeroEpoch uint16
-	state.localEpoch.Store(zeroEpoch)
-	state.remoteEpoch.Store(zeroEpoch)
+	if !cfg.isRenegotiation() {
+		var zeroEpoch uint16
+		state.localEpoch.Store(zeroEpoch)
+		state.remoteEpoch.Store(zeroEpoch)
+	}
 	state.namedCurve = defaultNamedCurve
 
-	if err := state.localRandom.Populate(); err != nil {
+	if err := state.localRandom.PopulateFrom(cfg.rand); err != nil {
 		return nil, nil, err
 	}
 
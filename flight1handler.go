@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"context"
+	"io"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/protocol"
@@ -46,14 +47,31 @@ func flight1Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
 }
 
-func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
-	var zeroEpoch uint16
-	state.localEpoch.Store(zeroEpoch)
-	state.remoteEpoch.Store(zeroEpoch)
+// localApplicationSettings returns the ALPS extension listing the settings of
+// the offered ALPN protocols, nil if none of them has settings.
+func localApplicationSettings(cfg *handshakeConfig) *extension.ApplicationSettings {
+	alps := &extension.ApplicationSettings{}
+	for _, p := range cfg.supportedProtocols {
+		if settings, ok := cfg.applicationSettings[p]; ok {
+			alps.Entries = append(alps.Entries, extension.ApplicationSettingsEntry{Protocol: p, Settings: settings})
+		}
+	}
+	if len(alps.Entries) == 0 {
+		return nil
+	}
+	return alps
+}
+
+func flight1Generate(_ context.Context, c flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+	if !cfg.isRenegotiation() {
+		var zeroEpoch uint16
+		state.localEpoch.Store(zeroEpoch)
+		state.remoteEpoch.Store(zeroEpoch)
+	}
 	state.namedCurve = defaultNamedCurve
 	state.cookie = nil
 
-	if err := state.localRandom.Populate(); err != nil {
+	if err := state.localRandom.PopulateFrom(cfg.rand); err != nil {
 		return nil, nil, err
 	}
 
@@ -61,9 +79,12 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		&extension.SupportedSignatureAlgorithms{
 			SignatureHashAlgorithms: cfg.localSignatureSchemes,
 		},
-		&extension.RenegotiationInfo{
-			RenegotiatedConnection: 0,
-		},
+		renegotiationInfo(state, cfg),
+	}
+	if len(cfg.localCertSignatureSchemes) > 0 {
+		extensions = append(extensions, &extension.SignatureAlgorithmsCert{
+			SignatureHashAlgorithms: cfg.localCertSignatureSchemes,
+		})
 	}
 
 	var setEllipticCurveCryptographyClientHelloExtensions bool
@@ -98,6 +119,17 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		})
 	}
 
+	if offersEncryptThenMAC(cfg) {
+		extensions = append(extensions, &extension.EncryptThenMAC{})
+	}
+
+	if cfg.postHandshakeAuth {
+		extensions = append(extensions, &extension.PostHandshakeAuth{})
+	}
+
+	extensions = append(extensions, clientCertificateTypeExtensions(cfg)...)
+
+	state.serverName = cfg.serverName
 	if len(cfg.serverName) > 0 {
 		extensions = append(extensions, &extension.ServerName{ServerName: cfg.serverName})
 	}
@@ -106,22 +138,74 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		extensions = append(extensions, &extension.ALPN{ProtocolNameList: cfg.supportedProtocols})
 	}
 
-	if cfg.sessionStore != nil {
+	if alps := localApplicationSettings(cfg); alps != nil {
+		extensions = append(extensions, alps)
+	}
+
+	if cfg.recordSizeLimit != 0 {
+		extensions = append(extensions, &extension.RecordSizeLimit{RecordSizeLimit: cfg.recordSizeLimit})
+	}
+
+	if cfg.maxFragmentLength != 0 {
+		extensions = append(extensions, &extension.MaxFragmentLength{Length: cfg.maxFragmentLength})
+	}
+
+	if cfg.heartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: cfg.heartbeatMode})
+	}
+
+	if cfg.recordPadding != nil {
+		extensions = append(extensions, &extension.RecordPadding{})
+	}
+
+	// SCTs are requested whenever the server may send a certificate so they
+	// can be reported in the State
+	if cfg.requireSCT || cfg.localPSKCallback == nil {
+		extensions = append(extensions, &extension.SignedCertificateTimestamp{})
+	}
+
+	// The server's certificate is only sent if no PSK is used
+	if cfg.localPSKCallback == nil {
+		extensions = append(extensions, &extension.StatusRequest{StatusType: extension.CertificateStatusTypeOCSP})
+		if len(cfg.trustedAuthorities) > 0 {
+			extensions = append(extensions, &extension.TrustedCAKeys{TrustedAuthorities: cfg.trustedAuthorities})
+		}
+	}
+
+	// A renegotiation always is a full handshake
+	if cfg.sessionStore != nil && !cfg.isRenegotiation() {
 		cfg.log.Tracef("[handshake] try to resume session")
-		if s, err := cfg.sessionStore.Get(c.sessionKey()); err != nil {
+		s, err := cfg.sessionStore.Get(c.sessionKey())
+		switch {
+		case err != nil:
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
-		} else if s.ID != nil {
+		case len(s.Ticket) > 0:
+			cfg.log.Tracef("[handshake] get saved session ticket")
+
+			// A fresh session ID is echoed by the server if it accepts the ticket
+			// https://tools.ietf.org/html/rfc5077#section-3.4
+			state.SessionID = make([]byte, sessionLength)
+			if _, err := io.ReadFull(cfg.rand, state.SessionID); err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			state.sessionTicket = s.Ticket
+			state.masterSecret = s.Secret
+			state.sessionExtendedMasterSecret = s.ExtendedMasterSecret
+		case s.ID != nil:
 			cfg.log.Tracef("[handshake] get saved session: %x", s.ID)
 
 			state.SessionID = s.ID
 			state.masterSecret = s.Secret
+			state.sessionExtendedMasterSecret = s.ExtendedMasterSecret
 		}
+
+		extensions = append(extensions, &extension.SessionTicket{Ticket: state.sessionTicket})
 	}
 
 	// If we have a connection ID generator, use it. The CID may be zero length,
 	// in which case we are just requesting that the server send us a CID to
 	// use.
-	if cfg.connectionIDGenerator != nil {
+	if cfg.connectionIDGenerator != nil && !cfg.isRenegotiation() {
 		state.localConnectionID = cfg.connectionIDGenerator()
 		// The presence of a generator indicates support for connection IDs. We
 		// use the presence of a non-nil local CID in flight 3 to determine
@@ -145,7 +229,7 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 						SessionID:          state.SessionID,
 						Cookie:             state.cookie,
 						Random:             state.localRandom,
-						CipherSuiteIDs:     cipherSuiteIDs(cfg.localCipherSuites),
+						CipherSuiteIDs:     clientHelloCipherSuiteIDs(cfg),
 						CompressionMethods: defaultCompressionMethods(),
 						Extensions:         extensions,
 					},
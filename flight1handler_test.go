@@ -5,11 +5,13 @@ package dtls
 
 import (
 	"context"
+	"crypto/rand"
 	"testing"
 	"time"
 
 	"github.com/adrian38/dtls/v2/internal/ciphersuite"
 	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/pion/logging"
 	"github.com/pion/transport/v3/test"
@@ -25,6 +27,9 @@ func (f *flight1TestMockFlightConn) recvHandshake() <-chan chan struct{}
 func (f *flight1TestMockFlightConn) setLocalEpoch(uint16)                          {}
 func (f *flight1TestMockFlightConn) handleQueuedPackets(context.Context) error     { return nil }
 func (f *flight1TestMockFlightConn) sessionKey() []byte                            { return nil }
+func (f *flight1TestMockFlightConn) handshakeDeadline() <-chan struct{}            { return nil }
+func (f *flight1TestMockFlightConn) reduceMTU() bool                               { return false }
+func (f *flight1TestMockFlightConn) peerRetransmitted() bool                       { return false }
 
 type flight1TestMockCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -282,3 +287,31 @@ func TestFlight1_Process_ServerHelloLateArrival(t *testing.T) {
 		t.Fatal(alt.String())
 	}
 }
+
+func TestFlight1GeneratePostHandshakeAuth(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := &handshakeConfig{
+			localCipherSuites: []CipherSuite{cipherSuiteForID(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, nil)},
+			postHandshakeAuth: enabled,
+			rand:              rand.Reader,
+			log:               logging.NewDefaultLoggerFactory().NewLogger("dtls"),
+		}
+		pkts, _, err := flight1Generate(context.TODO(), &flight1TestMockFlightConn{}, &State{}, newHandshakeCache(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientHello, ok := pkts[0].record.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
+		if !ok {
+			t.Fatal("Expected a ClientHello")
+		}
+		var found bool
+		for _, e := range clientHello.Extensions {
+			if _, ok := e.(*extension.PostHandshakeAuth); ok {
+				found = true
+			}
+		}
+		if found != enabled {
+			t.Errorf("Expected post_handshake_auth offered %v, got %v", enabled, found)
+		}
+	}
+}
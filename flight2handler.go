@@ -31,21 +31,32 @@ func flight2Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
 	}
 
-	if !clientHello.Version.Equal(protocol.Version1_2) {
+	if !cfg.acceptsVersion(clientHello.Version) {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
 	}
 
 	if len(clientHello.Cookie) == 0 {
 		return 0, nil, nil
 	}
-	if !bytes.Equal(state.cookie, clientHello.Cookie) {
+	if cfg.cookieVerifier != nil {
+		random := clientHello.Random.MarshalFixed()
+		if err := cfg.cookieVerifier(clientHello.Cookie, random[:]); err != nil {
+			cfg.log.Debugf("[handshake] reject cookie: %v", err)
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.AccessDenied}, errCookieMismatch
+		}
+	} else if !bytes.Equal(state.cookie, clientHello.Cookie) {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.AccessDenied}, errCookieMismatch
 	}
+	if cfg.onClientHello != nil {
+		if err := cfg.onClientHello(clientHello); err != nil {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+		}
+	}
 	return flight4, nil, nil
 }
 
-func flight2Generate(_ flightConn, state *State, _ *handshakeCache, _ *handshakeConfig) ([]*packet, *alert.Alert, error) {
-	state.handshakeSendSequence = 0
+func flight2Generate(_ context.Context, _ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+	cfg.setHandshakeSendSequence(state, 0)
 	return []*packet{
 		{
 			record: &recordlayer.RecordLayer{
@@ -40,16 +40,42 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	_, msgs, ok = cache.fullPullMap(state.handshakeRecvSequence, state.cipherSuite,
 		handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 	)
+	var ocspStapleNegotiated bool
 	if !ok {
 		// Don't have enough messages. Keep reading
 		return 0, nil, nil
 	}
 
 	if h, msgOk := msgs[handshake.TypeServerHello].(*handshake.MessageServerHello); msgOk {
-		if !h.Version.Equal(protocol.Version1_2) {
+		if !cfg.acceptsVersion(h.Version) {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
 		}
+		state.Version = h.Version
+		state.sessionTicketNegotiated = false
+		if !cfg.isRenegotiation() {
+			state.localHeartbeatMode = 0
+			state.remoteHeartbeatMode = 0
+			state.maxFragmentLength = 0
+			state.recordPadding = false
+		}
+		state.remoteSCTs = nil
+		state.SignedCertificateTimestamps = nil
+		state.OCSPResponse = nil
+		// No protocol is negotiated if the server omits the ALPN extension
+		// https://tools.ietf.org/html/rfc7301#section-3.2
+		state.NegotiatedProtocol = ""
+		state.PeerApplicationSettings = nil
+		var (
+			remoteRenegotiationInfo   *extension.RenegotiationInfo
+			remoteApplicationSettings *extension.ApplicationSettings
+			remoteEncryptThenMAC      bool
+			clientCertificateType     *extension.CertificateType
+			serverCertificateType     *extension.CertificateType
+		)
 		for _, v := range h.Extensions {
+			if cfg.isRenegotiation() && isPerConnectionExtension(v) {
+				continue
+			}
 			switch e := v.(type) {
 			case *extension.UseSRTP:
 				profile, found := findMatchingSRTPProfile(e.ProtectionProfiles, cfg.localSRTPProtectionProfiles)
@@ -65,6 +91,9 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 				if len(e.ProtocolNameList) > 1 { // This should be exactly 1, the zero case is handle when unmarshalling
 					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, extension.ErrALPNInvalidFormat // Meh, internal error?
 				}
+				if !isOfferedProtocol(e.ProtocolNameList[0], cfg.supportedProtocols) {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}, errClientUnofferedALPNProtocol
+				}
 				state.NegotiatedProtocol = e.ProtocolNameList[0]
 			case *extension.ConnectionID:
 				// Only set connection ID to be sent if client supports connection
@@ -72,11 +101,89 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 				if cfg.connectionIDGenerator != nil {
 					state.remoteConnectionID = e.CID
 				}
+			case *extension.SessionTicket:
+				// The server will send a NewSessionTicket before its Finished
+				if cfg.sessionStore != nil {
+					state.sessionTicketNegotiated = true
+				}
+			case *extension.Heartbeat:
+				// Ignore the extension if we didn't offer it
+				if cfg.heartbeatMode != 0 {
+					state.localHeartbeatMode = cfg.heartbeatMode
+					state.remoteHeartbeatMode = e.Mode
+				}
+			case *extension.MaxFragmentLength:
+				// The server must echo the value we requested
+				// https://tools.ietf.org/html/rfc6066#section-4
+				if e.Length != cfg.maxFragmentLength {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errMaxFragmentLengthMismatch
+				}
+				state.maxFragmentLength = e.Length
+			case *extension.SignedCertificateTimestamp:
+				state.remoteSCTs = e.Timestamps
+			case *extension.StatusRequest:
+				// The server will send a CertificateStatus after its Certificate
+				ocspStapleNegotiated = cfg.localPSKCallback == nil
+			case *extension.RecordSizeLimit:
+				// Ignore the limit if we didn't advertise one
+				if cfg.recordSizeLimit != 0 {
+					limit, a, err := parseRecordSizeLimit(e.RecordSizeLimit)
+					if err != nil {
+						return 0, a, err
+					}
+					state.remoteRecordSizeLimit = limit
+					state.localRecordSizeLimit = cfg.recordSizeLimit
+				}
+			case *extension.RecordPadding:
+				// Ignore the extension if we didn't offer it
+				if cfg.recordPadding != nil {
+					state.recordPadding = true
+				}
+			case *extension.EncryptThenMAC:
+				remoteEncryptThenMAC = true
+			case *extension.ClientCertificateType:
+				if !e.Selected {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errClientUnofferedCertificateType
+				}
+				clientCertificateType = &e.CertificateTypes[0]
+			case *extension.ServerCertificateType:
+				if !e.Selected {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errClientUnofferedCertificateType
+				}
+				serverCertificateType = &e.CertificateTypes[0]
+			case *extension.RenegotiationInfo:
+				remoteRenegotiationInfo = e
+			case *extension.ApplicationSettings:
+				remoteApplicationSettings = e
 			}
 		}
+		if a, err := verifyServerRenegotiationInfo(remoteRenegotiationInfo, state, cfg); err != nil {
+			return 0, a, err
+		}
+		if a, err := verifySelectedCertificateType(clientCertificateType, offeredClientCertificateTypes(cfg)); err != nil {
+			return 0, a, err
+		}
+		if a, err := verifySelectedCertificateType(serverCertificateType, offeredServerCertificateTypes(cfg)); err != nil {
+			return 0, a, err
+		}
+		state.clientCertificateType = clientCertificateType
+		state.serverCertificateType = serverCertificateType
+		cache.setRawPublicKeys(state.rawPublicKey(true), state.rawPublicKey(false))
+		if remoteApplicationSettings != nil {
+			// The server answers with the settings of the selected protocol
+			// only, which we must have sent settings for
+			if len(remoteApplicationSettings.Entries) != 1 {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errClientUnofferedALPS
+			}
+			entry := remoteApplicationSettings.Entries[0]
+			if _, ok := cfg.applicationSettings[entry.Protocol]; !ok || entry.Protocol != state.NegotiatedProtocol {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errClientUnofferedALPS
+			}
+			state.PeerApplicationSettings = entry.Settings
+		}
 		// If the server doesn't support connection IDs, the client should not
 		// expect one to be sent.
-		if state.remoteConnectionID == nil {
+		if state.remoteConnectionID == nil && state.localConnectionID != nil {
 			state.localConnectionID = nil
 		}
 
@@ -99,9 +206,17 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 
 		state.cipherSuite = selectedCipherSuite
 		state.remoteRandom = h.Random
+		// A confirmation for an AEAD cipher suite is ignored
+		state.encryptThenMAC = remoteEncryptThenMAC && offersEncryptThenMAC(cfg) && supportsEncryptThenMAC(selectedCipherSuite)
 		cfg.log.Tracef("[handshake] use cipher suite: %s", selectedCipherSuite.String())
 
 		if len(h.SessionID) > 0 && bytes.Equal(state.SessionID, h.SessionID) {
+			// The server must negotiate the extended master secret exactly if
+			// the resumed session used it
+			// https://tools.ietf.org/html/rfc7627#section-5.3
+			if state.extendedMasterSecret != state.sessionExtendedMasterSecret {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errSessionExtendedMasterSecretMismatch
+			}
 			return handleResumption(ctx, c, state, cache, cfg)
 		}
 
@@ -112,6 +227,15 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 			}
 		}
 
+		// The server rejected our ticket, it may issue a new one with this handshake
+		if len(state.sessionTicket) > 0 {
+			cfg.log.Tracef("[handshake] clean rejected session ticket")
+			if err := cfg.sessionStore.Del(c.sessionKey()); err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			state.sessionTicket = nil
+		}
+
 		if cfg.sessionStore == nil {
 			state.SessionID = []byte{}
 		} else {
@@ -129,6 +253,7 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	} else {
 		seq, msgs, ok = cache.fullPullMap(state.handshakeRecvSequence+1, state.cipherSuite,
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, true},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, true},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, true},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -142,10 +267,23 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 
 	if h, ok := msgs[handshake.TypeCertificate].(*handshake.MessageCertificate); ok {
 		state.PeerCertificates = h.Certificate
+		state.PeerRawPublicKey = h.RawPublicKey
 	} else if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.NoCertificate}, errInvalidCertificate
 	}
 
+	// A server that doesn't support stapling sends no CertificateStatus and
+	// leaves OCSPResponse nil https://tools.ietf.org/html/rfc6066#section-8
+	if h, ok := msgs[handshake.TypeCertificateStatus].(*handshake.MessageCertificateStatus); ok {
+		if !ocspStapleNegotiated {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errUnexpectedCertificateStatus
+		}
+		if h.StatusType != extension.CertificateStatusTypeOCSP {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errInvalidCertificateStatusType
+		}
+		state.OCSPResponse = h.Response
+	}
+
 	if h, ok := msgs[handshake.TypeServerKeyExchange].(*handshake.MessageServerKeyExchange); ok {
 		alertPtr, err := handleServerKeyExchange(c, state, cfg, h)
 		if err != nil {
@@ -161,6 +299,25 @@ func flight3Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	return flight5, nil, nil
 }
 
+// verifyServerRenegotiationInfo checks the renegotiation_info of a ServerHello,
+// it must be empty in the initial handshake and carry the verify_data of both
+// peers of the previous handshake when renegotiating
+// https://tools.ietf.org/html/rfc5746#section-3.5
+func verifyServerRenegotiationInfo(remoteRenegotiationInfo *extension.RenegotiationInfo, state *State, cfg *handshakeConfig) (*alert.Alert, error) {
+	if cfg.isRenegotiation() {
+		expected := append(append([]byte{}, state.clientVerifyData...), state.serverVerifyData...)
+		if remoteRenegotiationInfo == nil || !bytes.Equal(remoteRenegotiationInfo.VerifyData, expected) {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+		}
+		return nil, nil //nolint:nilnil
+	}
+	if remoteRenegotiationInfo != nil && len(remoteRenegotiationInfo.VerifyData) > 0 {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+	}
+	state.secureRenegotiation = remoteRenegotiationInfo != nil
+	return nil, nil //nolint:nilnil
+}
+
 func handleResumption(ctx context.Context, c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
 	if err := state.initCipherSuite(); err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
@@ -192,8 +349,8 @@ func handleResumption(ctx context.Context, c flightConn, state *State, cache *ha
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if a, err := verifyFinished(finished, expectedVerifyData); err != nil {
+		return 0, a, err
 	}
 
 	clientRandom := state.localRandom.MarshalFixed()
@@ -217,7 +374,7 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 		case types.KeyExchangeAlgorithmPsk:
 			state.preMasterSecret = prf.PSKPreMasterSecret(psk)
 		case (types.KeyExchangeAlgorithmEcdhe | types.KeyExchangeAlgorithmPsk):
-			if state.localKeypair, err = elliptic.GenerateKeypair(h.NamedCurve); err != nil {
+			if state.localKeypair, err = elliptic.GenerateKeypairFrom(h.NamedCurve, cfg.rand); err != nil {
 				return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 			}
 			state.preMasterSecret, err = prf.EcdhePSKPreMasterSecret(psk, h.PublicKey, state.localKeypair.PrivateKey, state.localKeypair.Curve)
@@ -228,7 +385,7 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 			return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errInvalidCipherSuite
 		}
 	} else {
-		if state.localKeypair, err = elliptic.GenerateKeypair(h.NamedCurve); err != nil {
+		if state.localKeypair, err = elliptic.GenerateKeypairFrom(h.NamedCurve, cfg.rand); err != nil {
 			return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
 
@@ -240,19 +397,22 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 	return nil, nil //nolint:nilnil
 }
 
-func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+func flight3Generate(_ context.Context, _ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	extensions := []extension.Extension{
 		&extension.SupportedSignatureAlgorithms{
 			SignatureHashAlgorithms: cfg.localSignatureSchemes,
 		},
-		&extension.RenegotiationInfo{
-			RenegotiatedConnection: 0,
-		},
+		renegotiationInfo(state, cfg),
+	}
+	if len(cfg.localCertSignatureSchemes) > 0 {
+		extensions = append(extensions, &extension.SignatureAlgorithmsCert{
+			SignatureHashAlgorithms: cfg.localCertSignatureSchemes,
+		})
 	}
 	if state.namedCurve != 0 {
 		extensions = append(extensions, []extension.Extension{
 			&extension.SupportedEllipticCurves{
-				EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+				EllipticCurves: cfg.ellipticCurves,
 			},
 			&extension.SupportedPointFormats{
 				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
@@ -273,6 +433,16 @@ func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		})
 	}
 
+	if offersEncryptThenMAC(cfg) {
+		extensions = append(extensions, &extension.EncryptThenMAC{})
+	}
+
+	if cfg.postHandshakeAuth {
+		extensions = append(extensions, &extension.PostHandshakeAuth{})
+	}
+
+	extensions = append(extensions, clientCertificateTypeExtensions(cfg)...)
+
 	if len(cfg.serverName) > 0 {
 		extensions = append(extensions, &extension.ServerName{ServerName: cfg.serverName})
 	}
@@ -281,12 +451,50 @@ func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		extensions = append(extensions, &extension.ALPN{ProtocolNameList: cfg.supportedProtocols})
 	}
 
+	if alps := localApplicationSettings(cfg); alps != nil {
+		extensions = append(extensions, alps)
+	}
+
+	if cfg.recordSizeLimit != 0 {
+		extensions = append(extensions, &extension.RecordSizeLimit{RecordSizeLimit: cfg.recordSizeLimit})
+	}
+
+	if cfg.maxFragmentLength != 0 {
+		extensions = append(extensions, &extension.MaxFragmentLength{Length: cfg.maxFragmentLength})
+	}
+
+	if cfg.heartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: cfg.heartbeatMode})
+	}
+
+	if cfg.recordPadding != nil {
+		extensions = append(extensions, &extension.RecordPadding{})
+	}
+
+	// SCTs are requested whenever the server may send a certificate so they
+	// can be reported in the State
+	if cfg.requireSCT || cfg.localPSKCallback == nil {
+		extensions = append(extensions, &extension.SignedCertificateTimestamp{})
+	}
+
+	// The server's certificate is only sent if no PSK is used
+	if cfg.localPSKCallback == nil {
+		extensions = append(extensions, &extension.StatusRequest{StatusType: extension.CertificateStatusTypeOCSP})
+		if len(cfg.trustedAuthorities) > 0 {
+			extensions = append(extensions, &extension.TrustedCAKeys{TrustedAuthorities: cfg.trustedAuthorities})
+		}
+	}
+
 	// If we sent a connection ID on the first ClientHello, send it on the
 	// second.
 	if state.localConnectionID != nil {
 		extensions = append(extensions, &extension.ConnectionID{CID: state.localConnectionID})
 	}
 
+	if cfg.sessionStore != nil {
+		extensions = append(extensions, &extension.SessionTicket{Ticket: state.sessionTicket})
+	}
+
 	return []*packet{
 		{
 			record: &recordlayer.RecordLayer{
@@ -299,7 +507,7 @@ func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 						SessionID:          state.SessionID,
 						Cookie:             state.cookie,
 						Random:             state.localRandom,
-						CipherSuiteIDs:     cipherSuiteIDs(cfg.localCipherSuites),
+						CipherSuiteIDs:     clientHelloCipherSuiteIDs(cfg),
 						CompressionMethods: defaultCompressionMethods(),
 						Extensions:         extensions,
 					},
@@ -4,7 +4,6 @@
 package dtls
 
 import (
-	"bytes"
 	"context"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
@@ -39,20 +38,18 @@ func flight4bParse(_ context.Context, _ flightConn, state *State, cache *handsha
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if a, err := verifyFinished(finished, expectedVerifyData); err != nil {
+		return 0, a, err
 	}
 
 	// Other party may re-transmit the last flight. Keep state to be flight4b.
 	return flight4b, nil, nil
 }
 
-func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+func flight4bGenerate(_ context.Context, _ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	var pkts []*packet
 
-	extensions := []extension.Extension{&extension.RenegotiationInfo{
-		RenegotiatedConnection: 0,
-	}}
+	extensions := []extension.Extension{renegotiationInfo(state, cfg)}
 	if (cfg.extendedMasterSecret == RequestExtendedMasterSecret ||
 		cfg.extendedMasterSecret == RequireExtendedMasterSecret) && state.extendedMasterSecret {
 		extensions = append(extensions, &extension.UseExtendedMasterSecret{
@@ -65,9 +62,9 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 		})
 	}
 
-	selectedProto, err := extension.ALPNProtocolSelection(cfg.supportedProtocols, state.peerSupportedProtocols)
+	selectedProto, a, err := selectApplicationProtocol(state, cfg)
 	if err != nil {
-		return nil, &alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}, err
+		return nil, a, err
 	}
 	if selectedProto != "" {
 		extensions = append(extensions, &extension.ALPN{
@@ -75,11 +72,35 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 		})
 		state.NegotiatedProtocol = selectedProto
 	}
+	if alps := negotiateApplicationSettings(state, cfg, selectedProto); alps != nil {
+		extensions = append(extensions, alps)
+	}
+
+	if state.localRecordSizeLimit != 0 {
+		extensions = append(extensions, &extension.RecordSizeLimit{RecordSizeLimit: state.localRecordSizeLimit})
+	}
+
+	if state.maxFragmentLength != 0 {
+		extensions = append(extensions, &extension.MaxFragmentLength{Length: state.maxFragmentLength})
+	}
+
+	if state.localHeartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: state.localHeartbeatMode})
+	}
+
+	if state.recordPadding {
+		extensions = append(extensions, &extension.RecordPadding{})
+	}
+
+	if state.encryptThenMAC {
+		extensions = append(extensions, &extension.EncryptThenMAC{})
+	}
 
+	state.Version = protocol.Version1_2
 	cipherSuiteID := uint16(state.cipherSuite.ID())
 	serverHello := &handshake.Handshake{
 		Message: &handshake.MessageServerHello{
-			Version:           protocol.Version1_2,
+			Version:           state.Version,
 			Random:            state.localRandom,
 			SessionID:         state.SessionID,
 			CipherSuiteID:     &cipherSuiteID,
@@ -88,7 +109,7 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 		},
 	}
 
-	serverHello.Header.MessageSequence = uint16(state.handshakeSendSequence)
+	serverHello.Header.MessageSequence = cfg.handshakeSendSequence(state)
 
 	if len(state.localVerifyData) == 0 {
 		plainText := cache.pullAndMerge(
@@ -5,10 +5,10 @@ package dtls
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
+	"io"
 
-	"github.com/adrian38/dtls/v2/internal/ciphersuite"
 	"github.com/adrian38/dtls/v2/pkg/crypto/clientcertificate"
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
@@ -39,6 +39,7 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 
 	if h, hasCert := msgs[handshake.TypeCertificate].(*handshake.MessageCertificate); hasCert {
 		state.PeerCertificates = h.Certificate
+		state.PeerRawPublicKey = h.RawPublicKey
 		// If the client offer its certificate, just disable session resumption.
 		// Otherwise, we have to store the certificate identitfication and expire time.
 		// And we have to check whether this certificate expired, revoked or changed.
@@ -56,6 +57,7 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -75,24 +77,42 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoAvailableSignatureSchemes
 		}
 
-		if err := verifyCertificateVerify(plainText, h.HashAlgorithm, h.Signature, state.PeerCertificates); err != nil {
+		if err := verifyCertificateVerify(plainText, signaturehash.Algorithm{Hash: h.HashAlgorithm, Signature: h.SignatureAlgorithm}, h.Signature, state.PeerCertificates, state.PeerRawPublicKey); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 		}
-		var chains [][]*x509.Certificate
-		var err error
-		var verified bool
-		if cfg.clientAuth >= VerifyClientCertIfGiven {
-			if chains, err = verifyClientCert(state.PeerCertificates, cfg.clientCAs); err != nil {
+		if state.PeerRawPublicKey {
+			// A raw public key has no chain to verify
+			if err := verifyPeerRawPublicKey(cfg, state.PeerCertificates); err != nil {
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 			}
-			verified = true
-		}
-		if cfg.verifyPeerCertificate != nil {
-			if err := cfg.verifyPeerCertificate(state.PeerCertificates, chains); err != nil {
-				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+			state.peerCertificatesVerified = true
+		} else {
+			if cfg.requireSCT {
+				if err := verifySCTs(state.PeerCertificates, nil); err != nil {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+			}
+			if len(cfg.localCertSignatureSchemes) > 0 {
+				if err := verifyCertificateSignatureSchemes(state.PeerCertificates, cfg.localCertSignatureSchemes); err != nil {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
 			}
+			var chains [][]*x509.Certificate
+			var err error
+			var verified bool
+			if cfg.clientAuth >= VerifyClientCertIfGiven {
+				if chains, err = verifyClientCert(state.PeerCertificates, cfg.clientCAs, cfg.intermediates, cfg.now()); err != nil {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+				verified = true
+			}
+			if cfg.verifyPeerCertificate != nil {
+				if err := cfg.verifyPeerCertificate(ctx, state.PeerCertificates, chains); err != nil {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+			}
+			state.peerCertificatesVerified = verified
 		}
-		state.peerCertificatesVerified = verified
 	} else if state.PeerCertificates != nil {
 		// A certificate was received, but we haven't seen a CertificateVerify
 		// keep reading until we receive one
@@ -146,6 +166,7 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 			}
 		}
 
+		state.applyEncryptThenMAC()
 		if err := state.cipherSuite.Init(state.masterSecret, clientRandom[:], serverRandom[:], false); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
@@ -154,8 +175,9 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 
 	if len(state.SessionID) > 0 {
 		s := Session{
-			ID:     state.SessionID,
-			Secret: state.masterSecret,
+			ID:                   state.SessionID,
+			Secret:               state.masterSecret,
+			ExtendedMasterSecret: state.extendedMasterSecret,
 		}
 		cfg.log.Tracef("[handshake] save new session: %x", s.ID)
 		if err := cfg.sessionStore.Set(state.SessionID, s); err != nil {
@@ -177,13 +199,33 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	}
 	state.handshakeRecvSequence = seq
 
-	if _, ok = msgs[handshake.TypeFinished].(*handshake.MessageFinished); !ok {
+	finished, ok := msgs[handshake.TypeFinished].(*handshake.MessageFinished)
+	if !ok {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
 	}
+	plainText := cache.pullAndMerge(
+		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeClientKeyExchange, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeCertificateVerify, cfg.initialEpoch, true, false},
+	)
+	expectedVerifyData, err := prf.VerifyDataClient(state.masterSecret, plainText, state.cipherSuite.HashFunc())
+	if err != nil {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	}
+	if a, err := verifyFinished(finished, expectedVerifyData); err != nil {
+		return 0, a, err
+	}
 
 	if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeAnonymous {
 		if cfg.verifyConnection != nil {
-			if err := cfg.verifyConnection(state.clone()); err != nil {
+			if err := cfg.verifyConnection(ctx, state.clone()); err != nil {
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 			}
 		}
@@ -210,7 +252,7 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 		// go to flight6
 	}
 	if cfg.verifyConnection != nil {
-		if err := cfg.verifyConnection(state.clone()); err != nil {
+		if err := cfg.verifyConnection(ctx, state.clone()); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 		}
 	}
@@ -218,10 +260,8 @@ func flight4Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	return flight6, nil, nil
 }
 
-func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) { //nolint:gocognit
-	extensions := []extension.Extension{&extension.RenegotiationInfo{
-		RenegotiatedConnection: 0,
-	}}
+func flight4Generate(_ context.Context, _ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) { //nolint:gocognit
+	extensions := []extension.Extension{renegotiationInfo(state, cfg)}
 	if (cfg.extendedMasterSecret == RequestExtendedMasterSecret ||
 		cfg.extendedMasterSecret == RequireExtendedMasterSecret) && state.extendedMasterSecret {
 		extensions = append(extensions, &extension.UseExtendedMasterSecret{
@@ -239,9 +279,9 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		})
 	}
 
-	selectedProto, err := extension.ALPNProtocolSelection(cfg.supportedProtocols, state.peerSupportedProtocols)
+	selectedProto, a, err := selectApplicationProtocol(state, cfg)
 	if err != nil {
-		return nil, &alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}, err
+		return nil, a, err
 	}
 	if selectedProto != "" {
 		extensions = append(extensions, &extension.ALPN{
@@ -249,6 +289,9 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		})
 		state.NegotiatedProtocol = selectedProto
 	}
+	if alps := negotiateApplicationSettings(state, cfg, selectedProto); alps != nil {
+		extensions = append(extensions, alps)
+	}
 
 	// If we have a connection ID generator, we are willing to use connection
 	// IDs. We already know whether the client supports connection IDs from
@@ -259,16 +302,95 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		extensions = append(extensions, &extension.ConnectionID{CID: state.localConnectionID})
 	}
 
+	if state.localRecordSizeLimit != 0 {
+		extensions = append(extensions, &extension.RecordSizeLimit{RecordSizeLimit: state.localRecordSizeLimit})
+	}
+
+	if state.maxFragmentLength != 0 {
+		extensions = append(extensions, &extension.MaxFragmentLength{Length: state.maxFragmentLength})
+	}
+
+	if state.localHeartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: state.localHeartbeatMode})
+	}
+
+	if state.recordPadding {
+		extensions = append(extensions, &extension.RecordPadding{})
+	}
+
+	if state.encryptThenMAC {
+		extensions = append(extensions, &extension.EncryptThenMAC{})
+	}
+
+	extensions = append(extensions, selectedCertificateTypeExtensions(state)...)
+
+	// An empty SessionTicket extension announces the NewSessionTicket message
+	// https://tools.ietf.org/html/rfc5077#section-3.2
+	if state.sessionTicketNegotiated {
+		extensions = append(extensions, &extension.SessionTicket{})
+	}
+
+	// The certificate is needed up front to deliver its SCTs in ServerHello
+	// and to announce a stapled OCSP response
+	var certificate *tls.Certificate
+	var ocspStaple []byte
+	switch {
+	case state.rawPublicKey(false):
+		var err error
+		if certificate, err = rawPublicKeyCertificate(cfg); err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+	case state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate:
+		clientHelloInfo, err := newClientHelloInfo(cache, cfg.initialEpoch)
+		if err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		selected := state.cipherSuite.ID()
+		clientHelloInfo.selectedCipherSuite = &selected
+		if certificate, err = cfg.getCertificate(clientHelloInfo); err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+		}
+
+		// An empty trusted_ca_keys extension tells that the chain matches
+		// one of the trusted authorities https://tools.ietf.org/html/rfc6066#section-6
+		if len(state.remoteTrustedAuthorities) > 0 && matchesTrustedAuthorities(certificate, state.remoteTrustedAuthorities) {
+			extensions = append(extensions, &extension.TrustedCAKeys{})
+		}
+
+		// https://tools.ietf.org/html/rfc6962#section-3.3.1
+		scts := certificate.SignedCertificateTimestamps
+		if len(scts) == 0 {
+			scts = cfg.signedCertificateTimestamps
+		}
+		if state.remoteRequestedSCT && len(scts) > 0 {
+			extensions = append(extensions, &extension.SignedCertificateTimestamp{
+				Timestamps: scts,
+			})
+		}
+
+		// An empty status_request extension announces the CertificateStatus
+		// message https://tools.ietf.org/html/rfc6066#section-8
+		if state.remoteRequestedOCSP && cfg.ocspStapleProvider != nil {
+			if ocspStaple, err = cfg.ocspStapleProvider(clientHelloInfo, certificate); err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			if len(ocspStaple) > 0 {
+				extensions = append(extensions, &extension.StatusRequest{})
+			}
+		}
+	}
+
 	var pkts []*packet
 	cipherSuiteID := uint16(state.cipherSuite.ID())
 
 	if cfg.sessionStore != nil {
 		state.SessionID = make([]byte, sessionLength)
-		if _, err := rand.Read(state.SessionID); err != nil {
+		if _, err := io.ReadFull(cfg.rand, state.SessionID); err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
 	}
 
+	state.Version = protocol.Version1_2
 	pkts = append(pkts, &packet{
 		record: &recordlayer.RecordLayer{
 			Header: recordlayer.Header{
@@ -276,7 +398,7 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 			},
 			Content: &handshake.Handshake{
 				Message: &handshake.MessageServerHello{
-					Version:           protocol.Version1_2,
+					Version:           state.Version,
 					Random:            state.localRandom,
 					SessionID:         state.SessionID,
 					CipherSuiteID:     &cipherSuiteID,
@@ -289,14 +411,10 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 
 	switch {
 	case state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate:
-		certificate, err := cfg.getCertificate(&ClientHelloInfo{
-			ServerName:   state.serverName,
-			CipherSuites: []ciphersuite.ID{state.cipherSuite.ID()},
-		})
-		if err != nil {
-			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+		rawCertificates := certificate.Certificate
+		if cfg.intermediates != nil && !state.rawPublicKey(false) {
+			rawCertificates = completeCertificateChain(rawCertificates, cfg.intermediates, cfg.now())
 		}
-
 		pkts = append(pkts, &packet{
 			record: &recordlayer.RecordLayer{
 				Header: recordlayer.Header{
@@ -304,22 +422,39 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 				},
 				Content: &handshake.Handshake{
 					Message: &handshake.MessageCertificate{
-						Certificate: certificate.Certificate,
+						Certificate:  rawCertificates,
+						RawPublicKey: state.rawPublicKey(false),
 					},
 				},
 			},
 		})
 
+		if len(ocspStaple) > 0 {
+			pkts = append(pkts, &packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Version: protocol.Version1_2,
+					},
+					Content: &handshake.Handshake{
+						Message: &handshake.MessageCertificateStatus{
+							StatusType: extension.CertificateStatusTypeOCSP,
+							Response:   ocspStaple,
+						},
+					},
+				},
+			})
+		}
+
 		serverRandom := state.localRandom.MarshalFixed()
 		clientRandom := state.remoteRandom.MarshalFixed()
 
 		// Find compatible signature scheme
-		signatureHashAlgo, err := signaturehash.SelectSignatureScheme(cfg.localSignatureSchemes, certificate.PrivateKey)
+		signatureHashAlgo, err := signaturehash.SelectSignatureScheme(offeredSignatureSchemes(cfg.localSignatureSchemes, state.remoteSignatureSchemes), certificate.PrivateKey)
 		if err != nil {
-			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, err
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoAvailableSignatureSchemes
 		}
 
-		signature, err := generateKeySignature(clientRandom[:], serverRandom[:], state.localKeypair.PublicKey, state.namedCurve, certificate.PrivateKey, signatureHashAlgo.Hash)
+		signature, err := generateKeySignature(clientRandom[:], serverRandom[:], state.localKeypair.PublicKey, state.namedCurve, certificate.PrivateKey, signatureHashAlgo)
 		if err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
@@ -409,3 +544,74 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 
 	return pkts, nil, nil
 }
+
+// selectApplicationProtocol picks the ALPN protocol to answer the client with,
+// using cfg.alpnSelector when configured.
+func selectApplicationProtocol(state *State, cfg *handshakeConfig) (string, *alert.Alert, error) {
+	if cfg.alpnSelector == nil {
+		selectedProto, err := extension.ALPNProtocolSelection(cfg.supportedProtocols, state.peerSupportedProtocols)
+		if err != nil {
+			return "", &alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}, err
+		}
+		return selectedProto, nil, nil
+	}
+
+	if len(state.peerSupportedProtocols) == 0 {
+		return "", nil, nil
+	}
+	selectedProto, err := cfg.alpnSelector(append([]string{}, state.peerSupportedProtocols...))
+	if err != nil {
+		return "", &alert.Alert{Level: alert.Fatal, Description: alert.NoApplicationProtocol}, err
+	}
+	if selectedProto == "" {
+		return "", nil, nil
+	}
+	for _, p := range state.peerSupportedProtocols {
+		if p == selectedProto {
+			return selectedProto, nil, nil
+		}
+	}
+	return "", &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, errALPNSelectorUnofferedProtocol
+}
+
+// negotiateApplicationSettings returns the ALPS answer for the selected
+// protocol and keeps the settings of the client, it returns nil unless both
+// the client and cfg have settings for the protocol.
+func negotiateApplicationSettings(state *State, cfg *handshakeConfig, selectedProto string) *extension.ApplicationSettings {
+	state.PeerApplicationSettings = nil
+	if selectedProto == "" || state.remoteApplicationSettings == nil {
+		return nil
+	}
+	remote, ok := state.remoteApplicationSettings.Lookup(selectedProto)
+	if !ok {
+		return nil
+	}
+	local, ok := cfg.applicationSettings[selectedProto]
+	if !ok {
+		return nil
+	}
+	state.PeerApplicationSettings = remote
+	return &extension.ApplicationSettings{Entries: []extension.ApplicationSettingsEntry{
+		{Protocol: selectedProto, Settings: local},
+	}}
+}
+
+// offeredSignatureSchemes filters local by the schemes the client listed in
+// signature_algorithms, keeping our order of preference. A client that sent
+// no signature_algorithms gets our full list.
+func offeredSignatureSchemes(local, remote []signaturehash.Algorithm) []signaturehash.Algorithm {
+	if len(remote) == 0 {
+		return local
+	}
+
+	out := []signaturehash.Algorithm{}
+	for _, l := range local {
+		for _, r := range remote {
+			if l == r {
+				out = append(out, l)
+				break
+			}
+		}
+	}
+	return out
+}
@@ -24,6 +24,9 @@ func (f *flight4TestMockFlightConn) recvHandshake() <-chan chan struct{}
 func (f *flight4TestMockFlightConn) setLocalEpoch(uint16)                          {}
 func (f *flight4TestMockFlightConn) handleQueuedPackets(context.Context) error     { return nil }
 func (f *flight4TestMockFlightConn) sessionKey() []byte                            { return nil }
+func (f *flight4TestMockFlightConn) handshakeDeadline() <-chan struct{}            { return nil }
+func (f *flight4TestMockFlightConn) reduceMTU() bool                               { return false }
+func (f *flight4TestMockFlightConn) peerRetransmitted() bool                       { return false }
 
 type flight4TestMockCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -4,9 +4,9 @@
 package dtls
 
 import (
-	"bytes"
 	"context"
 	"crypto"
+	"crypto/tls"
 	"crypto/x509"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
@@ -18,14 +18,28 @@ import (
 )
 
 func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
-	_, msgs, ok := cache.fullPullMap(state.handshakeRecvSequence, state.cipherSuite,
-		handshakeCachePullRule{handshake.TypeFinished, cfg.initialEpoch + 1, false, false},
-	)
+	rules := []handshakeCachePullRule{
+		{handshake.TypeFinished, cfg.initialEpoch + 1, false, false},
+	}
+	if state.sessionTicketNegotiated {
+		rules = append([]handshakeCachePullRule{
+			{handshake.TypeNewSessionTicket, cfg.initialEpoch, false, false},
+		}, rules...)
+	}
+	_, msgs, ok := cache.fullPullMap(state.handshakeRecvSequence, state.cipherSuite, rules...)
 	if !ok {
 		// No valid message received. Keep reading
 		return 0, nil, nil
 	}
 
+	if state.sessionTicketNegotiated {
+		newSessionTicket, ok := msgs[handshake.TypeNewSessionTicket].(*handshake.MessageNewSessionTicket)
+		if !ok {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
+		}
+		state.sessionTicket = newSessionTicket.Ticket
+	}
+
 	var finished *handshake.MessageFinished
 	if finished, ok = msgs[handshake.TypeFinished].(*handshake.MessageFinished); !ok {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
@@ -34,6 +48,7 @@ func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshak
 		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 		handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -41,20 +56,23 @@ func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshak
 		handshakeCachePullRule{handshake.TypeClientKeyExchange, cfg.initialEpoch, true, false},
 		handshakeCachePullRule{handshake.TypeCertificateVerify, cfg.initialEpoch, true, false},
 		handshakeCachePullRule{handshake.TypeFinished, cfg.initialEpoch + 1, true, false},
+		handshakeCachePullRule{handshake.TypeNewSessionTicket, cfg.initialEpoch, false, false},
 	)
 
 	expectedVerifyData, err := prf.VerifyDataServer(state.masterSecret, plainText, state.cipherSuite.HashFunc())
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if a, err := verifyFinished(finished, expectedVerifyData); err != nil {
+		return 0, a, err
 	}
 
-	if len(state.SessionID) > 0 {
+	if len(state.SessionID) > 0 || len(state.sessionTicket) > 0 {
 		s := Session{
-			ID:     state.SessionID,
-			Secret: state.masterSecret,
+			ID:                   state.SessionID,
+			Secret:               state.masterSecret,
+			Ticket:               state.sessionTicket,
+			ExtendedMasterSecret: state.extendedMasterSecret,
 		}
 		cfg.log.Tracef("[handshake] save new session: %x", s.ID)
 		if err := cfg.sessionStore.Set(c.sessionKey(), s); err != nil {
@@ -65,7 +83,7 @@ func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshak
 	return flight5, nil, nil
 }
 
-func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) { //nolint:gocognit
+func flight5Generate(ctx context.Context, c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) { //nolint:gocognit
 	var privateKey crypto.PrivateKey
 	var pkts []*packet
 	if state.remoteRequestedCertificate {
@@ -74,13 +92,17 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 		if !ok {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errClientCertificateRequired
 		}
-		reqInfo := CertificateRequestInfo{}
-		if r, ok := msgs[handshake.TypeCertificateRequest].(*handshake.MessageCertificateRequest); ok {
-			reqInfo.AcceptableCAs = r.CertificateAuthoritiesNames
-		} else {
+		r, ok := msgs[handshake.TypeCertificateRequest].(*handshake.MessageCertificateRequest)
+		if !ok {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errClientCertificateRequired
 		}
-		certificate, err := cfg.getClientCertificate(&reqInfo)
+		var certificate *tls.Certificate
+		var err error
+		if state.rawPublicKey(true) {
+			certificate, err = rawPublicKeyCertificate(cfg)
+		} else {
+			certificate, err = cfg.getClientCertificate(newCertificateRequestInfo(r))
+		}
 		if err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
 		}
@@ -98,7 +120,8 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 					},
 					Content: &handshake.Handshake{
 						Message: &handshake.MessageCertificate{
-							Certificate: certificate.Certificate,
+							Certificate:  certificate.Certificate,
+							RawPublicKey: state.rawPublicKey(true),
 						},
 					},
 				},
@@ -108,6 +131,12 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 	clientKeyExchange := &handshake.MessageClientKeyExchange{}
 	if cfg.localPSKCallback == nil {
 		clientKeyExchange.PublicKey = state.localKeypair.PublicKey
+	} else if cfg.localPSKIdentityCallback != nil {
+		identity, err := cfg.localPSKIdentityCallback(state.IdentityHint)
+		if err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		clientKeyExchange.IdentityHint = identity
 	} else {
 		clientKeyExchange.IdentityHint = cfg.localPSKIdentityHint
 	}
@@ -158,7 +187,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 
 	// Append not-yet-sent packets
 	merged := []byte{}
-	seqPred := uint16(state.handshakeSendSequence)
+	seqPred := cfg.handshakeSendSequence(state)
 	for _, p := range pkts {
 		h, ok := p.record.Content.(*handshake.Handshake)
 		if !ok {
@@ -173,7 +202,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 		merged = append(merged, raw...)
 	}
 
-	if alertPtr, err := initializeCipherSuite(state, cache, cfg, serverKeyExchange, merged); err != nil {
+	if alertPtr, err := initializeCipherSuite(ctx, state, cache, cfg, serverKeyExchange, merged); err != nil {
 		return nil, alertPtr, err
 	}
 
@@ -185,6 +214,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -199,7 +229,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, err
 		}
 
-		certVerify, err := generateCertificateVerify(plainText, privateKey, signatureHashAlgo.Hash)
+		certVerify, err := generateCertificateVerify(plainText, privateKey, signatureHashAlgo)
 		if err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
@@ -249,6 +279,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -286,7 +317,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 	return pkts, nil, nil
 }
 
-func initializeCipherSuite(state *State, cache *handshakeCache, cfg *handshakeConfig, h *handshake.MessageServerKeyExchange, sendingPlainText []byte) (*alert.Alert, error) { //nolint:gocognit
+func initializeCipherSuite(ctx context.Context, state *State, cache *handshakeCache, cfg *handshakeConfig, h *handshake.MessageServerKeyExchange, sendingPlainText []byte) (*alert.Alert, error) { //nolint:gocognit
 	if state.cipherSuite.IsInitialized() {
 		return nil, nil //nolint
 	}
@@ -328,27 +359,49 @@ func initializeCipherSuite(state *State, cache *handshakeCache, cfg *handshakeCo
 		}
 
 		expectedMsg := valueKeyMessage(clientRandom[:], serverRandom[:], h.PublicKey, h.NamedCurve)
-		if err = verifyKeySignature(expectedMsg, h.Signature, h.HashAlgorithm, state.PeerCertificates); err != nil {
+		if err = verifyKeySignature(expectedMsg, h.Signature, signaturehash.Algorithm{Hash: h.HashAlgorithm, Signature: h.SignatureAlgorithm}, state.PeerCertificates, state.PeerRawPublicKey); err != nil {
 			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 		}
-		var chains [][]*x509.Certificate
-		if !cfg.insecureSkipVerify {
-			if chains, err = verifyServerCert(state.PeerCertificates, cfg.rootCAs, cfg.serverName); err != nil {
+		if state.PeerRawPublicKey {
+			// A raw public key has no chain, host name or SCTs to verify
+			if err = verifyPeerRawPublicKey(cfg, state.PeerCertificates); err != nil {
 				return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 			}
-		}
-		if cfg.verifyPeerCertificate != nil {
-			if err = cfg.verifyPeerCertificate(state.PeerCertificates, chains); err != nil {
-				return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		} else {
+			if cfg.requireSCT {
+				if err = verifySCTs(state.PeerCertificates, state.remoteSCTs); err != nil {
+					return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+			}
+			if len(cfg.localCertSignatureSchemes) > 0 {
+				if err = verifyCertificateSignatureSchemes(state.PeerCertificates, cfg.localCertSignatureSchemes); err != nil {
+					return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+			}
+			// Malformed embedded SCTs are only fatal if SCTs are required
+			if scts, sctErr := peerSCTs(state.PeerCertificates, state.remoteSCTs); sctErr == nil {
+				state.SignedCertificateTimestamps = scts
+			}
+			var chains [][]*x509.Certificate
+			if !cfg.insecureSkipVerify {
+				if chains, err = verifyServerCert(state.PeerCertificates, cfg.rootCAs, cfg.intermediates, cfg.serverName, cfg.now()); err != nil {
+					return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
+			}
+			if cfg.verifyPeerCertificate != nil {
+				if err = cfg.verifyPeerCertificate(ctx, state.PeerCertificates, chains); err != nil {
+					return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+				}
 			}
 		}
 	}
 	if cfg.verifyConnection != nil {
-		if err = cfg.verifyConnection(state.clone()); err != nil {
+		if err = cfg.verifyConnection(ctx, state.clone()); err != nil {
 			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 		}
 	}
 
+	state.applyEncryptThenMAC()
 	if err = state.cipherSuite.Init(state.masterSecret, clientRandom[:], serverRandom[:], true); err != nil {
 		return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"context"
+	"time"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
 	"github.com/adrian38/dtls/v2/pkg/protocol"
@@ -30,9 +31,45 @@ func flight6Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 	return flight6, nil, nil
 }
 
-func flight6Generate(_ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
+func flight6Generate(_ context.Context, _ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	var pkts []*packet
 
+	// NewSessionTicket is sent before ChangeCipherSpec
+	// https://tools.ietf.org/html/rfc5077#section-3.3
+	var newSessionTicket *handshake.Handshake
+	if state.sessionTicketNegotiated {
+		if state.sessionTicket == nil {
+			ticket, err := encryptSessionTicket(cfg.sessionTicketKey, cfg.rand, &sessionTicketState{
+				createdAt:            cfg.now(),
+				cipherSuiteID:        state.cipherSuite.ID(),
+				extendedMasterSecret: state.extendedMasterSecret,
+				masterSecret:         state.masterSecret,
+			})
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			state.sessionTicket = ticket
+		}
+
+		newSessionTicket = &handshake.Handshake{
+			Message: &handshake.MessageNewSessionTicket{
+				TicketLifetimeHint: uint32(cfg.sessionTicketLifetime / time.Second),
+				Ticket:             state.sessionTicket,
+			},
+		}
+		newSessionTicket.Header.MessageSequence = cfg.handshakeSendSequence(state)
+
+		pkts = append(pkts,
+			&packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Version: protocol.Version1_2,
+					},
+					Content: newSessionTicket,
+				},
+			})
+	}
+
 	pkts = append(pkts,
 		&packet{
 			record: &recordlayer.RecordLayer{
@@ -48,6 +85,7 @@ func flight6Generate(_ flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -56,6 +94,13 @@ func flight6Generate(_ flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeCertificateVerify, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeFinished, cfg.initialEpoch + 1, true, false},
 		)
+		if newSessionTicket != nil {
+			raw, err := newSessionTicket.Marshal()
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			plainText = append(plainText, raw...)
+		}
 
 		var err error
 		state.localVerifyData, err = prf.VerifyDataServer(state.masterSecret, plainText, state.cipherSuite.HashFunc())
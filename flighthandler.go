@@ -4,16 +4,18 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
 
 	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 )
 
 // Parse received handshakes and return next flightVal
 type flightParser func(context.Context, flightConn, *State, *handshakeCache, *handshakeConfig) (flightVal, *alert.Alert, error)
 
 // Generate flights
-type flightGenerator func(flightConn, *State, *handshakeCache, *handshakeConfig) ([]*packet, *alert.Alert, error)
+type flightGenerator func(context.Context, flightConn, *State, *handshakeCache, *handshakeConfig) ([]*packet, *alert.Alert, error)
 
 func (f flightVal) getFlightParser() (flightParser, error) {
 	switch f {
@@ -66,3 +68,17 @@ func (f flightVal) getFlightGenerator() (gen flightGenerator, retransmit bool, e
 		return nil, false, errInvalidFlight
 	}
 }
+
+// verifyFinished compares the verify_data of a Finished message with the PRF
+// output of the negotiated cipher suite, verify_data of another length can not
+// be decoded
+// https://tools.ietf.org/html/rfc5246#section-7.4.9
+func verifyFinished(finished *handshake.MessageFinished, expectedVerifyData []byte) (*alert.Alert, error) {
+	if len(finished.VerifyData) != len(expectedVerifyData) {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.DecodeError}, errInvalidVerifyDataLength
+	}
+	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	}
+	return nil, nil //nolint:nilnil
+}
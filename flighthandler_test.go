@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/internal/ciphersuite"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+)
+
+// A Finished with verify_data of the wrong length is answered with a
+// decode_error alert
+func TestFinishedVerifyDataLength(t *testing.T) {
+	for _, length := range []int{0, 1, 11, 13, 64} {
+		length := length
+		t.Run(fmt.Sprintf("%dBytes", length), func(t *testing.T) {
+			state := &State{
+				cipherSuite:  &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+				masterSecret: make([]byte, 48),
+			}
+			cache := newHandshakeCache()
+			finished := &handshake.Handshake{
+				Header:  handshake.Header{MessageSequence: 2},
+				Message: &handshake.MessageFinished{VerifyData: make([]byte, length)},
+			}
+			raw, err := finished.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cache.push(raw, 1, 2, handshake.TypeFinished, true)
+			state.handshakeRecvSequence = 2
+
+			_, a, err := flight4bParse(context.TODO(), &flight4TestMockFlightConn{}, state, cache, &handshakeConfig{})
+			if !errors.Is(err, errInvalidVerifyDataLength) {
+				t.Fatalf("Expected error '%v', got '%v'", errInvalidVerifyDataLength, err)
+			}
+			if a == nil || a.Level != alert.Fatal || a.Description != alert.DecodeError {
+				t.Fatalf("Expected fatal decode_error alert, got %v", a)
+			}
+		})
+	}
+}
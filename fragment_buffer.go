@@ -4,13 +4,26 @@
 package dtls
 
 import (
+	"sync/atomic"
+
 	"github.com/adrian38/dtls/v2/pkg/protocol"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/logging"
 )
 
-// 2 megabytes
-const fragmentBufferMaxSize = 2000000
+// Default limit of handshake bytes buffered for reassembly, see
+// Config.MaxHandshakeBufferSize
+const defaultFragmentBufferMaxSize = 64 * 1024
+
+// FragmentStats counts the handshake fragments a Conn received
+type FragmentStats struct {
+	// Reassembled fragments were part of a complete handshake message
+	Reassembled uint64
+	// Dropped fragments were retransmissions of messages that were already
+	// reassembled or duplicates that were not needed to rebuild a message
+	Dropped uint64
+}
 
 type fragment struct {
 	recordLayerHeader recordlayer.Header
@@ -23,50 +36,73 @@ type fragmentBuffer struct {
 	cache map[uint16][]*fragment
 
 	currentMessageSequenceNumber uint16
+
+	// adoptClientHello is set on a server verifying cookies statelessly, the
+	// first handshake message may then be a ClientHello answering a
+	// HelloVerifyRequest sent by someone else, which does not have the
+	// message sequence zero. It is cleared with the first handshake message.
+	adoptClientHello bool
+
+	// total size of the buffered fragments and the limit it may not exceed
+	size, maxSize int
+
+	// retransmittedFlight is set by push when the final fragment of the last
+	// popped message arrives again, the peer then retransmitted the flight
+	// it ended. It is cleared by the caller.
+	retransmittedFlight bool
+
+	// accessed atomically, see stats
+	reassembled, dropped uint64
+
+	log logging.LeveledLogger
 }
 
-func newFragmentBuffer() *fragmentBuffer {
-	return &fragmentBuffer{cache: map[uint16][]*fragment{}}
+func newFragmentBuffer(maxSize int, log logging.LeveledLogger) *fragmentBuffer {
+	return &fragmentBuffer{cache: map[uint16][]*fragment{}, maxSize: maxSize, log: log}
 }
 
-// current total size of buffer
-func (f *fragmentBuffer) size() int {
-	size := 0
-	for i := range f.cache {
-		for j := range f.cache[i] {
-			size += len(f.cache[i][j].data)
-		}
+// stats may be called concurrently with push and pop
+func (f *fragmentBuffer) stats() FragmentStats {
+	return FragmentStats{
+		Reassembled: atomic.LoadUint64(&f.reassembled),
+		Dropped:     atomic.LoadUint64(&f.dropped),
 	}
-	return size
+}
+
+// reset drops everything buffered, message sequence numbers restart at zero
+// with every handshake
+func (f *fragmentBuffer) reset() {
+	f.cache = map[uint16][]*fragment{}
+	f.currentMessageSequenceNumber = 0
+	f.size = 0
+	f.retransmittedFlight = false
 }
 
 // Attempts to push a DTLS packet to the fragmentBuffer
 // when it returns true it means the fragmentBuffer has inserted and the buffer shouldn't be handled
 // when an error returns it is fatal, and the DTLS connection should be stopped
 func (f *fragmentBuffer) push(buf []byte) (bool, error) {
-	if f.size()+len(buf) >= fragmentBufferMaxSize {
-		return false, errFragmentBufferOverflow
-	}
-
-	frag := new(fragment)
-	if err := frag.recordLayerHeader.Unmarshal(buf); err != nil {
+	var recordLayerHeader recordlayer.Header
+	if err := recordLayerHeader.Unmarshal(buf); err != nil {
 		return false, err
 	}
 
 	// fragment isn't a handshake, we don't need to handle it
-	if frag.recordLayerHeader.ContentType != protocol.ContentTypeHandshake {
+	if recordLayerHeader.ContentType != protocol.ContentTypeHandshake {
 		return false, nil
 	}
 
+	if f.size+len(buf)-recordlayer.FixedHeaderSize > f.maxSize {
+		return false, errFragmentBufferOverflow
+	}
+
+	frag := &fragment{recordLayerHeader: recordLayerHeader}
+
 	for buf = buf[recordlayer.FixedHeaderSize:]; len(buf) != 0; frag = new(fragment) {
 		if err := frag.handshakeHeader.Unmarshal(buf); err != nil {
 			return false, err
 		}
 
-		if _, ok := f.cache[frag.handshakeHeader.MessageSequence]; !ok {
-			f.cache[frag.handshakeHeader.MessageSequence] = []*fragment{}
-		}
-
 		// end index should be the length of handshake header but if the handshake
 		// was fragmented, we should keep them all
 		end := int(handshake.HeaderLength + frag.handshakeHeader.Length)
@@ -74,15 +110,50 @@ func (f *fragmentBuffer) push(buf []byte) (bool, error) {
 			end = size
 		}
 
+		f.log.Debugf("fragment: epoch %d seq %d type %s offset %d length %d of %d",
+			recordLayerHeader.Epoch, frag.handshakeHeader.MessageSequence, frag.handshakeHeader.Type,
+			frag.handshakeHeader.FragmentOffset, frag.handshakeHeader.FragmentLength, frag.handshakeHeader.Length)
+
+		if f.adoptClientHello {
+			f.adoptClientHello = false
+			if frag.handshakeHeader.Type == handshake.TypeClientHello && len(f.cache) == 0 {
+				f.currentMessageSequenceNumber = frag.handshakeHeader.MessageSequence
+			}
+		}
+
+		// Retransmissions of messages that were already popped can never be
+		// popped again, don't let them occupy the buffer
+		if frag.handshakeHeader.MessageSequence < f.currentMessageSequenceNumber {
+			f.log.Debugf("fragment: dropped seq %d, already reassembled", frag.handshakeHeader.MessageSequence)
+			atomic.AddUint64(&f.dropped, 1)
+			if frag.handshakeHeader.MessageSequence+1 == f.currentMessageSequenceNumber &&
+				frag.handshakeHeader.FragmentOffset+frag.handshakeHeader.FragmentLength == frag.handshakeHeader.Length {
+				f.retransmittedFlight = true
+			}
+			buf = buf[end:]
+			continue
+		}
+
+		if _, ok := f.cache[frag.handshakeHeader.MessageSequence]; !ok {
+			f.cache[frag.handshakeHeader.MessageSequence] = []*fragment{}
+		}
+
 		// Discard all headers, when rebuilding the packet we will re-build
 		frag.data = append([]byte{}, buf[handshake.HeaderLength:end]...)
 		f.cache[frag.handshakeHeader.MessageSequence] = append(f.cache[frag.handshakeHeader.MessageSequence], frag)
+		f.size += len(frag.data)
 		buf = buf[end:]
 	}
 
 	return true, nil
 }
 
+// pending reports whether fragments of the next message to pop are buffered,
+// that message is then still being reassembled
+func (f *fragmentBuffer) pending() bool {
+	return len(f.cache[f.currentMessageSequenceNumber]) > 0
+}
+
 func (f *fragmentBuffer) pop() (content []byte, epoch uint16) {
 	frags, ok := f.cache[f.currentMessageSequenceNumber]
 	if !ok {
@@ -93,6 +164,7 @@ func (f *fragmentBuffer) pop() (content []byte, epoch uint16) {
 	var appendMessage func(targetOffset uint32) bool
 
 	rawMessage := []byte{}
+	used := 0
 	appendMessage = func(targetOffset uint32) bool {
 		for _, f := range frags {
 			if f.handshakeHeader.FragmentOffset == targetOffset {
@@ -104,6 +176,7 @@ func (f *fragmentBuffer) pop() (content []byte, epoch uint16) {
 				}
 
 				rawMessage = append(f.data, rawMessage...)
+				used++
 				return true
 			}
 		}
@@ -126,6 +199,12 @@ func (f *fragmentBuffer) pop() (content []byte, epoch uint16) {
 
 	messageEpoch := frags[0].recordLayerHeader.Epoch
 
+	for _, frag := range frags {
+		f.size -= len(frag.data)
+	}
+	f.log.Debugf("fragment: reassembled seq %d from %d of %d fragments", firstHeader.MessageSequence, used, len(frags))
+	atomic.AddUint64(&f.reassembled, uint64(used))
+	atomic.AddUint64(&f.dropped, uint64(len(frags)-used))
 	delete(f.cache, f.currentMessageSequenceNumber)
 	f.currentMessageSequenceNumber++
 	return append(rawHeader, rawMessage...), messageEpoch
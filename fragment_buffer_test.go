@@ -7,6 +7,9 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/pion/logging"
 )
 
 func TestFragmentBuffer(t *testing.T) {
@@ -92,7 +95,7 @@ func TestFragmentBuffer(t *testing.T) {
 			Epoch: 0,
 		},
 	} {
-		fragmentBuffer := newFragmentBuffer()
+		fragmentBuffer := newFragmentBuffer(defaultFragmentBufferMaxSize, logging.NewDefaultLoggerFactory().NewLogger("dtls"))
 		for _, frag := range test.In {
 			status, err := fragmentBuffer.push(frag)
 			if err != nil {
@@ -119,16 +122,90 @@ func TestFragmentBuffer(t *testing.T) {
 }
 
 func TestFragmentBuffer_Overflow(t *testing.T) {
-	fragmentBuffer := newFragmentBuffer()
+	fragmentBuffer := newFragmentBuffer(64, logging.NewDefaultLoggerFactory().NewLogger("dtls"))
 
 	// Push a buffer that doesn't exceed size limits
 	if _, err := fragmentBuffer.push([]byte{0x16, 0xfe, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0F, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0xfe, 0xff, 0x00}); err != nil {
 		t.Fatal(err)
 	}
 
-	// Allocate a buffer that exceeds cache size
-	largeBuffer := make([]byte, fragmentBufferMaxSize)
-	if _, err := fragmentBuffer.push(largeBuffer); !errors.Is(err, errFragmentBufferOverflow) {
-		t.Fatalf("Pushing a large buffer returned (%s) expected(%s)", err, errFragmentBufferOverflow)
+	// First fragment of a message that is much larger than the buffer, it
+	// stays buffered as the rest never arrives
+	fragment := func(sequence byte, length int) []byte {
+		buf := []byte{
+			0x16, 0xfe, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(handshake.HeaderLength + length),
+			0x0b, 0x00, 0x10, 0x00, 0x00, sequence, 0x00, 0x00, 0x00, 0x00, 0x00, byte(length),
+		}
+		return append(buf, make([]byte, length)...)
+	}
+	if _, err := fragmentBuffer.push(fragment(1, 40)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fragmentBuffer.push(fragment(2, 40)); !errors.Is(err, errFragmentBufferOverflow) {
+		t.Fatalf("Pushing past the limit returned (%s) expected(%s)", err, errFragmentBufferOverflow)
+	}
+
+	// Non handshake records are not buffered and never overflow
+	if ok, err := fragmentBuffer.push([]byte{0x17, 0xfe, 0xfd, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00}); ok || err != nil {
+		t.Fatalf("Application data was buffered (%v) or failed (%v)", ok, err)
+	}
+}
+
+func TestFragmentBuffer_Size(t *testing.T) {
+	fragmentBuffer := newFragmentBuffer(defaultFragmentBufferMaxSize, logging.NewDefaultLoggerFactory().NewLogger("dtls"))
+	message := []byte{0x16, 0xfe, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0F, 0x03, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03, 0xfe, 0xff, 0x00}
+
+	if _, err := fragmentBuffer.push(message); err != nil {
+		t.Fatal(err)
+	}
+	if fragmentBuffer.size != 3 {
+		t.Fatalf("Unexpected buffered size %d", fragmentBuffer.size)
+	}
+	if out, _ := fragmentBuffer.pop(); out == nil {
+		t.Fatal("Message was not reassembled")
+	}
+	if fragmentBuffer.size != 0 {
+		t.Fatalf("Popped message is still counted, size %d", fragmentBuffer.size)
+	}
+
+	// A retransmission of the popped message is accepted but not kept
+	if ok, err := fragmentBuffer.push(message); !ok || err != nil {
+		t.Fatalf("Retransmission was not accepted (%v, %v)", ok, err)
+	}
+	if fragmentBuffer.size != 0 {
+		t.Fatalf("Retransmission is buffered, size %d", fragmentBuffer.size)
+	}
+}
+
+func TestFragmentBuffer_Stats(t *testing.T) {
+	fragmentBuffer := newFragmentBuffer(defaultFragmentBufferMaxSize, logging.NewDefaultLoggerFactory().NewLogger("dtls"))
+	fragments := [][]byte{
+		{0x16, 0xfe, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x81, 0x0b, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x01, 0x02, 0x03, 0x04},
+		{0x16, 0xfe, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x81, 0x0b, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x05, 0x00, 0x00, 0x05, 0x05, 0x06, 0x07, 0x08, 0x09},
+		{0x16, 0xfe, 0xfd, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x81, 0x0b, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x0A, 0x00, 0x00, 0x05, 0x0A, 0x0B, 0x0C, 0x0D, 0x0E},
+	}
+
+	// The second fragment arrives twice
+	for _, frag := range append(fragments, fragments[1]) {
+		if _, err := fragmentBuffer.push(frag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := fragmentBuffer.stats(); stats != (FragmentStats{}) {
+		t.Fatalf("Fragments were counted before reassembly %+v", stats)
+	}
+	if out, _ := fragmentBuffer.pop(); out == nil {
+		t.Fatal("Message was not reassembled")
+	}
+	if stats := fragmentBuffer.stats(); stats != (FragmentStats{Reassembled: 3, Dropped: 1}) {
+		t.Fatalf("Unexpected stats after reassembly %+v", stats)
+	}
+
+	// Retransmission of the reassembled message
+	if _, err := fragmentBuffer.push(fragments[0]); err != nil {
+		t.Fatal(err)
+	}
+	if stats := fragmentBuffer.stats(); stats != (FragmentStats{Reassembled: 3, Dropped: 2}) {
+		t.Fatalf("Unexpected stats after retransmission %+v", stats)
 	}
 }
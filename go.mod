@@ -1,11 +1,13 @@
 module github.com/adrian38/dtls/v2
 
 require (
+	github.com/cloudflare/circl v1.3.7 // only dh/x448, for the X448 curve
 	github.com/pion/logging v0.2.2
 	github.com/pion/transport/v3 v3.0.2
 	github.com/stretchr/testify v1.9.0
 	golang.org/x/crypto v0.21.0
 	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.18.0
 )
 
 require (
@@ -28,6 +28,11 @@ type handshakeCachePullRule struct {
 type handshakeCache struct {
 	cache []*handshakeCacheItem
 	mu    sync.Mutex
+
+	// Whether the Certificate of the client or of the server carries a raw
+	// public key, which changes how it is parsed
+	clientRawPublicKey bool
+	serverRawPublicKey bool
 }
 
 func newHandshakeCache() *handshakeCache {
@@ -47,6 +52,25 @@ func (h *handshakeCache) push(data []byte, epoch, messageSequence uint16, typ ha
 	})
 }
 
+// reset drops all messages, a renegotiation starts a new transcript
+func (h *handshakeCache) reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cache = nil
+	h.clientRawPublicKey = false
+	h.serverRawPublicKey = false
+}
+
+// setRawPublicKeys records the certificate types negotiated with RFC 7250
+func (h *handshakeCache) setRawPublicKeys(client, server bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clientRawPublicKey = client
+	h.serverRawPublicKey = server
+}
+
 // returns a list handshakes that match the requested rules
 // the list will contain null entries for rules that can't be satisfied
 // multiple entries may match a rule, but only the last match is returned (ie ClientHello with cookies)
@@ -71,6 +95,21 @@ func (h *handshakeCache) pull(rules ...handshakeCachePullRule) []*handshakeCache
 	return out
 }
 
+// messageSequence returns the message sequence of the last cached message of
+// type typ matching epoch and isClient
+func (h *handshakeCache) messageSequence(typ handshake.Type, epoch uint16, isClient bool) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	seq, ok := 0, false
+	for _, c := range h.cache {
+		if c.typ == typ && c.isClient == isClient && c.epoch == epoch && (!ok || int(c.messageSequence) > seq) {
+			seq, ok = int(c.messageSequence), true
+		}
+	}
+	return seq, ok
+}
+
 // fullPullMap pulls all handshakes between rules[0] to rules[len(rules)-1] as map.
 func (h *handshakeCache) fullPullMap(startSeq int, cipherSuite CipherSuite, rules ...handshakeCachePullRule) (int, map[handshake.Type]handshake.Message, bool) {
 	h.mu.Lock()
@@ -110,6 +149,7 @@ func (h *handshakeCache) fullPullMap(startSeq int, cipherSuite CipherSuite, rule
 		}
 		rawHandshake := &handshake.Handshake{
 			KeyExchangeAlgorithm: keyExchangeAlgorithm,
+			RawPublicKey:         (i.isClient && h.clientRawPublicKey) || (!i.isClient && h.serverRawPublicKey),
 		}
 		if err := rawHandshake.Unmarshal(i.data); err != nil {
 			return startSeq, nil, false
@@ -150,6 +190,7 @@ func (h *handshakeCache) sessionHash(hf prf.HashFunc, epoch uint16, additional .
 		handshakeCachePullRule{handshake.TypeClientHello, epoch, true, false},
 		handshakeCachePullRule{handshake.TypeServerHello, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificate, epoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerKeyExchange, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificateRequest, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerHelloDone, epoch, false, false},
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import "time"
+
+// HandshakeStats describes a completed handshake, see Config.OnHandshakeComplete.
+type HandshakeStats struct {
+	// Duration is the time from preparing the first flight until the
+	// handshake finished.
+	Duration time.Duration
+	// Flights is the number of flights prepared by this side, not counting
+	// retransmissions.
+	Flights int
+	// Retransmits is the number of flight retransmissions caused by timeouts.
+	Retransmits int
+	// CipherSuiteID is the negotiated cipher suite.
+	CipherSuiteID CipherSuiteID
+	// Resumed is set if the handshake resumed a previous session.
+	Resumed bool
+}
+
+func (s *handshakeFSM) stats() HandshakeStats {
+	stats := HandshakeStats{
+		Flights:     s.flightCount,
+		Retransmits: s.retransmits,
+		Resumed:     s.resumed,
+	}
+	if !s.startTime.IsZero() {
+		stats.Duration = time.Since(s.startTime)
+	}
+	if s.state.cipherSuite != nil {
+		stats.CipherSuiteID = s.state.cipherSuite.ID()
+	}
+	return stats
+}
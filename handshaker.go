@@ -5,16 +5,20 @@ package dtls
 
 import (
 	"context"
+	"crypto"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
 	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/pion/logging"
 )
@@ -54,6 +58,10 @@ import (
 //              Read retransmit
 //           Retransmit last flight
 
+// mtuReductionRetransmits is the number of retransmissions of a flight
+// after which its MTU is halved
+const mtuReductionRetransmits = 2
+
 type handshakeState uint8
 
 const (
@@ -85,48 +93,110 @@ type handshakeFSM struct {
 	currentFlight flightVal
 	flights       []*packet
 	retransmit    bool
+	attempt       int // Number of retransmissions of the current flight
 	state         *State
 	cache         *handshakeCache
 	cfg           *handshakeConfig
 	closed        chan struct{}
+
+	// renegotiate moves a finished handshake back to the first flight
+	renegotiate   chan struct{}
+	renegotiating bool
+
+	// nextLocalEpoch is applied once the flight carrying the
+	// ChangeCipherSpec was written, so application data sent meanwhile
+	// cannot overtake it
+	nextLocalEpoch uint16
+
+	// Reported through HandshakeStats
+	startTime   time.Time
+	flightCount int
+	retransmits int
+	resumed     bool
+
+	// mtuReductions counts the MTU reductions made for the current flight
+	mtuReductions int
+	// mtuReduced tells that the current flight went unanswered even after
+	// reducing the MTU, while the peer kept retransmitting its own. It is
+	// accessed atomically as the Conn reads it once the handshake failed.
+	mtuReduced int32
 }
 
 type handshakeConfig struct {
 	localPSKCallback            PSKCallback
+	localPSKIdentityCallback    func([]byte) ([]byte, error)
 	localPSKIdentityHint        []byte
-	localCipherSuites           []CipherSuite             // Available CipherSuites
+	localCipherSuites           []CipherSuite // Available CipherSuites
+	preferServerCipherSuites    bool
 	localSignatureSchemes       []signaturehash.Algorithm // Available signature schemes
+	localCertSignatureSchemes   []signaturehash.Algorithm // Signature schemes accepted in peer certificates, empty accepts all
 	extendedMasterSecret        ExtendedMasterSecretType  // Policy for the Extended Master Support extension
 	localSRTPProtectionProfiles []SRTPProtectionProfile   // Available SRTPProtectionProfiles, if empty no SRTP support
 	serverName                  string
 	supportedProtocols          []string
+	alpnSelector                func(offered []string) (string, error)
+	applicationSettings         map[string][]byte
 	clientAuth                  ClientAuthType // If we are a client should we request a client certificate
 	localCertificates           []tls.Certificate
+	localRawPublicKey           crypto.PrivateKey
+	verifyRawPublicKey          func(crypto.PublicKey) error
 	nameToCertificate           map[string]*tls.Certificate
 	insecureSkipVerify          bool
-	verifyPeerCertificate       func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
-	verifyConnection            func(*State) error
+	verifyPeerCertificate       func(ctx context.Context, rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	verifyConnection            func(context.Context, *State) error
+	requireSCT                  bool
+	signedCertificateTimestamps [][]byte
+	fallbackSCSV                bool
 	sessionStore                SessionStore
+	sessionTicketKey            []byte
+	sessionTicketLifetime       time.Duration
 	rootCAs                     *x509.CertPool
 	clientCAs                   *x509.CertPool
+	intermediates               *x509.CertPool
+	trustedAuthorities          []extension.TrustedAuthority
 	retransmitInterval          time.Duration
+	retransmitBackoff           func(attempt int) time.Duration
+	maxRetransmits              int
+	falseStart                  bool
+	postHandshakeAuth           bool
 	customCipherSuites          func() []CipherSuite
 	ellipticCurves              []elliptic.Curve
 	insecureSkipHelloVerify     bool
 	connectionIDGenerator       func() []byte
+	recordSizeLimit             uint16
+	maxFragmentLength           FragmentLength
+	heartbeatMode               HeartbeatMode
+	recordPadding               func(plaintextLen int) int
+	disableEncryptThenMAC       bool
+	onHandshakeComplete         func(HandshakeStats)
+	onClientHello               func(*handshake.MessageClientHello) error
+	cookieGenerator             func() ([]byte, error)
+	cookieVerifier              func(cookie, clientRandom []byte) error
+	statelessCookieGenerator    func(clientRandom []byte) []byte
+	rand                        io.Reader
+	now                         func() time.Time
 
-	onFlightState func(flightVal, handshakeState)
-	log           logging.LeveledLogger
-	keyLogWriter  io.Writer
+	onFlightState  func(flightVal, handshakeState)
+	onRenegotiated func()
+	log            logging.LeveledLogger
+	keyLogWriter   io.Writer
 
 	localGetCertificate       func(*ClientHelloInfo) (*tls.Certificate, error)
+	ocspStapleProvider        func(*ClientHelloInfo, *tls.Certificate) ([]byte, error)
 	localGetClientCertificate func(*CertificateRequestInfo) (*tls.Certificate, error)
 
+	minVersion protocol.Version
+	maxVersion protocol.Version
+
 	initialEpoch uint16
 
-	mu sync.Mutex
+	mu sync.Mutex // Guards State.handshakeSendSequence
 }
 
+// keyLogMu serializes the writes of all connections, which may share one
+// KeyLogWriter
+var keyLogMu sync.Mutex //nolint:gochecknoglobals
+
 type flightConn interface {
 	notify(ctx context.Context, level alert.Level, desc alert.Description) error
 	writePackets(context.Context, []*packet) error
@@ -134,20 +204,81 @@ type flightConn interface {
 	setLocalEpoch(epoch uint16)
 	handleQueuedPackets(context.Context) error
 	sessionKey() []byte
+	handshakeDeadline() <-chan struct{}
+	reduceMTU() bool
+	peerRetransmitted() bool
+}
+
+// retransmitDelay returns how long to wait before the next retransmission
+// of a flight that has already been retransmitted attempt times.
+func (c *handshakeConfig) retransmitDelay(attempt int) time.Duration {
+	if c.retransmitBackoff != nil {
+		if d := c.retransmitBackoff(attempt); d > 0 {
+			return d
+		}
+	}
+	return c.retransmitInterval
 }
 
+// writeKeyLog writes a line in NSS key log format, once the master secret of
+// a handshake is known
 func (c *handshakeConfig) writeKeyLog(label string, clientRandom, secret []byte) {
 	if c.keyLogWriter == nil {
 		return
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
 	_, err := c.keyLogWriter.Write([]byte(fmt.Sprintf("%s %x %x\n", label, clientRandom, secret)))
 	if err != nil {
 		c.log.Debugf("failed to write key log file: %s", err)
 	}
 }
 
+// acceptsVersion reports whether the version of a ClientHello or ServerHello
+// can be negotiated, DTLS 1.2 is the only one implemented. A zero
+// minVersion or maxVersion does not limit the version.
+func (c *handshakeConfig) acceptsVersion(v protocol.Version) bool {
+	if !v.Equal(protocol.Version1_2) {
+		return false
+	}
+	n := dtlsVersionNumber(v)
+	return (c.minVersion == (protocol.Version{}) || n >= dtlsVersionNumber(c.minVersion)) &&
+		(c.maxVersion == (protocol.Version{}) || n <= dtlsVersionNumber(c.maxVersion))
+}
+
+// isRenegotiation reports whether the handshake runs on top of an
+// established connection, its messages are then sent in the epoch of the
+// previous handshake
+func (c *handshakeConfig) isRenegotiation() bool {
+	return c.initialEpoch > 0
+}
+
+// handshakeSendSequence returns the message_seq of the next handshake message.
+// Post-handshake messages are numbered outside of the flights, by the
+// goroutine calling RequestClientCertificate or the one reading records, so
+// the counter is guarded by mu.
+func (c *handshakeConfig) handshakeSendSequence(state *State) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return uint16(state.handshakeSendSequence)
+}
+
+// nextHandshakeSendSequence returns the message_seq of the next handshake
+// message and advances the counter
+func (c *handshakeConfig) nextHandshakeSendSequence(state *State) uint16 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seq := state.handshakeSendSequence
+	state.handshakeSendSequence++
+	return uint16(seq)
+}
+
+func (c *handshakeConfig) setHandshakeSendSequence(state *State, seq int) {
+	c.mu.Lock()
+	state.handshakeSendSequence = seq
+	c.mu.Unlock()
+}
+
 func srvCliStr(isClient bool) string {
 	if isClient {
 		return "client"
@@ -165,6 +296,7 @@ func newHandshakeFSM(
 		cache:         cache,
 		cfg:           cfg,
 		closed:        make(chan struct{}),
+		renegotiate:   make(chan struct{}),
 	}
 }
 
@@ -178,6 +310,12 @@ func (s *handshakeFSM) Run(ctx context.Context, c flightConn, initialState hands
 		if s.cfg.onFlightState != nil {
 			s.cfg.onFlightState(s.currentFlight, state)
 		}
+		if state == handshakeFinished && s.renegotiating {
+			s.renegotiating = false
+			if s.cfg.onRenegotiated != nil {
+				s.cfg.onRenegotiated()
+			}
+		}
 		var err error
 		switch state {
 		case handshakePreparing:
@@ -203,6 +341,17 @@ func (s *handshakeFSM) Done() <-chan struct{} {
 
 func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeState, error) {
 	s.flights = nil
+	s.attempt = 0
+	s.mtuReductions = 0
+	atomic.StoreInt32(&s.mtuReduced, 0)
+	if s.startTime.IsZero() {
+		s.startTime = time.Now()
+	}
+	s.flightCount++
+	if s.currentFlight == flight4b || s.currentFlight == flight5b {
+		s.resumed = true
+		s.state.resumed = true
+	}
 	// Prepare flights
 	var (
 		a    *alert.Alert
@@ -214,7 +363,7 @@ func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeStat
 		err = errFlight
 		a = &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}
 	} else {
-		pkts, a, err = gen(c, s.state, s.cache, s.cfg)
+		pkts, a, err = gen(ctx, c, s.state, s.cache, s.cfg)
 		s.retransmit = retransmit
 	}
 	if a != nil {
@@ -233,26 +382,55 @@ func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeStat
 	nextEpoch := epoch
 	for _, p := range s.flights {
 		p.record.Header.Epoch += epoch
+		if p.record.Header.Epoch > 0 && p.record.Content.ContentType() != protocol.ContentTypeChangeCipherSpec {
+			// Messages of a renegotiation are protected by the previous handshake,
+			// the cipher suites never protect ChangeCipherSpec
+			p.shouldEncrypt = true
+		}
 		if p.record.Header.Epoch > nextEpoch {
 			nextEpoch = p.record.Header.Epoch
 		}
 		if h, ok := p.record.Content.(*handshake.Handshake); ok {
-			h.Header.MessageSequence = uint16(s.state.handshakeSendSequence)
-			s.state.handshakeSendSequence++
+			h.Header.MessageSequence = s.cfg.nextHandshakeSendSequence(s.state)
 		}
 	}
 	if epoch != nextEpoch {
 		s.cfg.log.Tracef("[handshake:%s] -> changeCipherSpec (epoch: %d)", srvCliStr(s.state.isClient), nextEpoch)
-		c.setLocalEpoch(nextEpoch)
+		s.nextLocalEpoch = nextEpoch
 	}
 	return handshakeSending, nil
 }
 
+// prepareRenegotiation restarts the handshake from the first flight, the
+// new handshake is sent in the current epoch.
+func (s *handshakeFSM) prepareRenegotiation() {
+	s.cfg.initialEpoch = s.state.getLocalEpoch()
+	s.state.resetForRenegotiation()
+	s.cfg.setHandshakeSendSequence(s.state, 0)
+	if s.state.isClient {
+		s.currentFlight = flight1
+	} else {
+		s.currentFlight = flight0
+	}
+	s.renegotiating = true
+	s.startTime = time.Time{}
+	s.flightCount = 0
+	s.retransmits = 0
+	s.resumed = false
+}
+
 func (s *handshakeFSM) send(ctx context.Context, c flightConn) (handshakeState, error) {
+	// The flight answers whatever the peer retransmitted so far
+	c.peerRetransmitted()
+
 	// Send flights
 	if err := c.writePackets(ctx, s.flights); err != nil {
 		return handshakeErrored, err
 	}
+	if s.nextLocalEpoch != 0 {
+		c.setLocalEpoch(s.nextLocalEpoch)
+		s.nextLocalEpoch = 0
+	}
 
 	if s.currentFlight.isLastSendFlight() {
 		return handshakeFinished, nil
@@ -269,7 +447,8 @@ func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState,
 		return handshakeErrored, errFlight
 	}
 
-	retransmitTimer := time.NewTimer(s.cfg.retransmitInterval)
+	retransmitTimer := time.NewTimer(s.cfg.retransmitDelay(s.attempt))
+	defer retransmitTimer.Stop()
 	for {
 		select {
 		case done := <-c.recvHandshake():
@@ -286,6 +465,18 @@ func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState,
 				return handshakeErrored, err
 			}
 			if nextFlight == 0 {
+				// The peer did not receive our flight and retransmitted its
+				// own, answer it right away instead of waiting for the timer
+				// https://datatracker.ietf.org/doc/html/rfc6347#section-4.2.4
+				if s.retransmit && c.peerRetransmitted() {
+					if s.mtuReductions > 0 {
+						// The peer is still there, so the smaller datagrams
+						// of its flight get through while ours do not
+						atomic.StoreInt32(&s.mtuReduced, 1)
+					}
+					s.cfg.log.Tracef("[handshake:%s] peer retransmitted its flight, retransmitting %s", srvCliStr(s.state.isClient), s.currentFlight.String())
+					return handshakeSending, nil
+				}
 				break
 			}
 			s.cfg.log.Tracef("[handshake:%s] %s -> %s", srvCliStr(s.state.isClient), s.currentFlight.String(), nextFlight.String())
@@ -299,7 +490,17 @@ func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState,
 			if !s.retransmit {
 				return handshakeWaiting, nil
 			}
+			if s.cfg.maxRetransmits > 0 && s.attempt >= s.cfg.maxRetransmits {
+				return handshakeErrored, errMaxRetransmits
+			}
+			s.attempt++
+			s.retransmits++
+			if s.attempt%mtuReductionRetransmits == 0 && c.reduceMTU() {
+				s.mtuReductions++
+			}
 			return handshakeSending, nil
+		case <-c.handshakeDeadline():
+			return handshakeErrored, errDeadlineExceeded
 		case <-ctx.Done():
 			return handshakeErrored, ctx.Err()
 		}
@@ -340,6 +541,11 @@ func (s *handshakeFSM) finish(ctx context.Context, c flightConn) (handshakeState
 		// Retransmit last flight
 		return handshakeSending, nil
 
+	case <-s.renegotiate:
+		retransmitTimer.Stop()
+		s.prepareRenegotiation()
+		return handshakePreparing, nil
+
 	case <-ctx.Done():
 		return handshakeErrored, ctx.Err()
 	}
@@ -6,9 +6,12 @@ package dtls
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"errors"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -18,6 +21,7 @@ import (
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
 	"github.com/pion/logging"
+	"github.com/pion/transport/v3/deadline"
 	"github.com/pion/transport/v3/test"
 )
 
@@ -44,6 +48,35 @@ func TestWriteKeyLog(t *testing.T) {
 	cfg.writeKeyLog("LABEL", []byte{0xAA, 0xBB, 0xCC}, []byte{0xDD, 0xEE, 0xFF})
 }
 
+// Post-handshake messages are numbered concurrently to the flights
+func TestHandshakeSendSequenceConcurrent(t *testing.T) {
+	cfg := &handshakeConfig{}
+	state := &State{}
+
+	const goroutines, messages = 4, 100
+	seen := make([]int32, goroutines*messages)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				atomic.AddInt32(&seen[cfg.nextHandshakeSendSequence(state)], 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	for seq, n := range seen {
+		if n != 1 {
+			t.Fatalf("message_seq %d used %d times", seq, n)
+		}
+	}
+	if seq := cfg.handshakeSendSequence(state); seq != goroutines*messages {
+		t.Fatalf("Expected next message_seq %d, got %d", goroutines*messages, seq)
+	}
+}
+
 func TestHandshaker(t *testing.T) {
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
@@ -282,6 +315,7 @@ func TestHandshaker(t *testing.T) {
 						}
 					},
 					retransmitInterval: nonZeroRetransmitInterval,
+					rand:               rand.Reader,
 				}
 
 				fsm := newHandshakeFSM(&ca.state, ca.handshakeCache, cfg, flight1)
@@ -315,6 +349,7 @@ func TestHandshaker(t *testing.T) {
 						}
 					},
 					retransmitInterval: nonZeroRetransmitInterval,
+					rand:               rand.Reader,
 				}
 
 				fsm := newHandshakeFSM(&cb.state, cb.handshakeCache, cfg, flight0)
@@ -337,6 +372,142 @@ func TestHandshaker(t *testing.T) {
 	}
 }
 
+func TestHandshakerMaxRetransmits(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const maxRetransmits = 3
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	cntClientHello := 0
+	ca, _ := flightTestPipe(ctx, TestEndpoint{
+		// Drop everything, the server never answers
+		Filter: func(p *packet) bool {
+			if h, ok := p.record.Content.(*handshake.Handshake); ok {
+				if _, ok := h.Message.(*handshake.MessageClientHello); ok {
+					cntClientHello++
+				}
+			}
+			return false
+		},
+	}, TestEndpoint{})
+	ca.state.isClient = true
+
+	cipherSuites, err := parseCipherSuites(nil, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var attempts []int
+	cfg := &handshakeConfig{
+		localCipherSuites:     cipherSuites,
+		ellipticCurves:        defaultCurves,
+		localSignatureSchemes: signaturehash.Algorithms(),
+		log:                   logging.NewDefaultLoggerFactory().NewLogger("dtls"),
+		retransmitInterval:    time.Hour,
+		retransmitBackoff: func(attempt int) time.Duration {
+			attempts = append(attempts, attempt)
+			return 10 * time.Millisecond
+		},
+		maxRetransmits: maxRetransmits,
+		rand:           rand.Reader,
+	}
+
+	fsm := newHandshakeFSM(&ca.state, ca.handshakeCache, cfg, flight1)
+	if err := fsm.Run(ctx, ca, handshakePreparing); !errors.Is(err, errMaxRetransmits) {
+		t.Fatalf("Expected error '%v', got '%v'", errMaxRetransmits, err)
+	}
+	cancel()
+
+	if cntClientHello != maxRetransmits+1 {
+		t.Errorf("Expected ClientHello to be sent %d times, got %d", maxRetransmits+1, cntClientHello)
+	}
+	if expected := []int{0, 1, 2, 3}; !reflect.DeepEqual(attempts, expected) {
+		t.Errorf("Expected backoff attempts %v, got %v", expected, attempts)
+	}
+	if stats := fsm.stats(); stats.Retransmits != maxRetransmits || stats.Flights != 1 {
+		t.Errorf("Expected %d retransmits of 1 flight, got %+v", maxRetransmits, stats)
+	}
+}
+
+func TestHandshakerDeadline(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cipherSuites, err := parseCipherSuites(nil, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clearDeadline bool
+		expectedErr   error
+	}{
+		"Exceeded": {
+			expectedErr: errDeadlineExceeded,
+		},
+		// Clearing the deadline resumes retransmitting until the limit is hit
+		"Cleared": {
+			clearDeadline: true,
+			expectedErr:   errMaxRetransmits,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+			defer cancel()
+
+			ca, _ := flightTestPipe(ctx, TestEndpoint{
+				// Drop everything, the server never answers
+				Filter: func(*packet) bool { return false },
+			}, TestEndpoint{})
+			ca.state.isClient = true
+
+			cfg := &handshakeConfig{
+				localCipherSuites:     cipherSuites,
+				ellipticCurves:        defaultCurves,
+				localSignatureSchemes: signaturehash.Algorithms(),
+				log:                   logging.NewDefaultLoggerFactory().NewLogger("dtls"),
+				retransmitInterval:    50 * time.Millisecond,
+				maxRetransmits:        5,
+				rand:                  rand.Reader,
+			}
+
+			ca.deadline.Set(time.Now().Add(100 * time.Millisecond))
+			if tt.clearDeadline {
+				clearTimer := time.AfterFunc(30*time.Millisecond, func() {
+					ca.deadline.Set(time.Time{})
+				})
+				defer clearTimer.Stop()
+			}
+
+			start := time.Now()
+			fsm := newHandshakeFSM(&ca.state, ca.handshakeCache, cfg, flight1)
+			err := fsm.Run(ctx, ca, handshakePreparing)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error '%v', got '%v'", tt.expectedErr, err)
+			}
+			if tt.clearDeadline {
+				if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+					t.Fatalf("Handshake gave up after %v, before the cleared deadline", elapsed)
+				}
+			} else {
+				var netErr interface{ Timeout() bool }
+				if !errors.As(err, &netErr) || !netErr.Timeout() {
+					t.Fatalf("Expected a timeout error, got '%v'", err)
+				}
+				if stats := fsm.stats(); stats.Retransmits == 0 || stats.Retransmits >= cfg.maxRetransmits {
+					t.Fatalf("Expected the deadline to stop retransmitting, got %+v", stats)
+				}
+			}
+		})
+	}
+}
+
 type packetFilter func(p *packet) bool
 
 type TestEndpoint struct {
@@ -359,6 +530,7 @@ func flightTestPipe(ctx context.Context, clientEndpoint TestEndpoint, serverEndp
 			done:           ctx.Done(),
 			filter:         clientEndpoint.Filter,
 			delay:          clientEndpoint.Delay,
+			deadline:       deadline.New(),
 		}, &flightTestConn{
 			handshakeCache: cb,
 			otherEndCache:  ca,
@@ -367,6 +539,7 @@ func flightTestPipe(ctx context.Context, clientEndpoint TestEndpoint, serverEndp
 			done:           ctx.Done(),
 			filter:         serverEndpoint.Filter,
 			delay:          serverEndpoint.Delay,
+			deadline:       deadline.New(),
 		}
 }
 
@@ -383,6 +556,8 @@ type flightTestConn struct {
 
 	otherEndCache *handshakeCache
 	otherEndRecv  chan chan struct{}
+
+	deadline *deadline.Deadline
 }
 
 func (c *flightTestConn) recvHandshake() <-chan chan struct{} {
@@ -445,3 +620,15 @@ func (c *flightTestConn) handleQueuedPackets(context.Context) error {
 func (c *flightTestConn) sessionKey() []byte {
 	return nil
 }
+
+func (c *flightTestConn) handshakeDeadline() <-chan struct{} {
+	return c.deadline.Done()
+}
+
+func (c *flightTestConn) reduceMTU() bool {
+	return false
+}
+
+func (c *flightTestConn) peerRetransmitted() bool {
+	return false
+}
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+// HeartbeatMode is advertised with the heartbeat extension and tells the
+// peer whether it is allowed to send HeartbeatRequest messages.
+// https://tools.ietf.org/html/rfc6520#section-2
+type HeartbeatMode = extension.HeartbeatMode
+
+const (
+	HeartbeatModePeerAllowedToSend    HeartbeatMode = extension.HeartbeatModePeerAllowedToSend    // nolint:revive
+	HeartbeatModePeerNotAllowedToSend HeartbeatMode = extension.HeartbeatModePeerNotAllowedToSend // nolint:revive
+)
+
+const (
+	// maxHeartbeatPayloadLength keeps a HeartbeatMessage within a single record.
+	// https://tools.ietf.org/html/rfc6520#section-4
+	maxHeartbeatPayloadLength = maxRecordSizeLimit - 3 - protocol.HeartbeatMinPaddingLength
+
+	// defaultHeartbeatMaxRetransmits bounds Heartbeat when MaxRetransmits is not set
+	defaultHeartbeatMaxRetransmits = 5
+)
+
+// Heartbeat sends a HeartbeatRequest carrying payload and waits for the
+// matching HeartbeatResponse. The request is retransmitted using the same
+// timers as handshake flights and fails with a timeout error once
+// MaxRetransmits (or 5 if not set) retransmissions are unanswered or the
+// write deadline expires. Only one request is in flight at a time.
+func (c *Conn) Heartbeat(payload []byte) error {
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	if c.state.remoteHeartbeatMode != HeartbeatModePeerAllowedToSend {
+		return errHeartbeatNotAllowed
+	}
+	if len(payload) > maxHeartbeatPayloadLength ||
+		(c.state.outgoingRecordLimit() != 0 && len(payload)+3+protocol.HeartbeatMinPaddingLength > c.state.outgoingRecordLimit()) {
+		return errHeartbeatPayloadTooLarge
+	}
+
+	// There must be at most one HeartbeatRequest in flight at a time
+	// https://tools.ietf.org/html/rfc6520#section-3
+	c.heartbeatLock.Lock()
+	defer c.heartbeatLock.Unlock()
+
+	// Drop a late response to an abandoned request
+	select {
+	case <-c.heartbeatResponse:
+	default:
+	}
+
+	maxRetransmits := c.fsm.cfg.maxRetransmits
+	if maxRetransmits <= 0 {
+		maxRetransmits = defaultHeartbeatMaxRetransmits
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := c.writeHeartbeat(protocol.HeartbeatMessageTypeRequest, payload); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(c.fsm.cfg.retransmitDelay(attempt))
+	wait:
+		for {
+			select {
+			case response := <-c.heartbeatResponse:
+				// Responses to other requests are discarded silently
+				if bytes.Equal(response, payload) {
+					timer.Stop()
+					return nil
+				}
+			case <-timer.C:
+				break wait
+			case <-c.writeDeadline.Done():
+				timer.Stop()
+				return errDeadlineExceeded
+			case <-c.closed.Done():
+				timer.Stop()
+				return ErrConnClosed
+			}
+		}
+
+		if attempt >= maxRetransmits {
+			return errHeartbeatTimeout
+		}
+	}
+}
+
+func (c *Conn) writeHeartbeat(typ protocol.HeartbeatMessageType, payload []byte) error {
+	padding := make([]byte, protocol.HeartbeatMinPaddingLength)
+	if _, err := rand.Read(padding); err != nil {
+		return err
+	}
+
+	return c.writePackets(c.writeDeadline, []*packet{
+		{
+			record: &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Epoch:   c.state.getLocalEpoch(),
+					Version: protocol.Version1_2,
+				},
+				Content: &protocol.Heartbeat{
+					Type:    typ,
+					Payload: payload,
+					Padding: padding,
+				},
+			},
+			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
+			shouldEncrypt: true,
+		},
+	})
+}
+
+// handleHeartbeat answers HeartbeatRequests and hands HeartbeatResponses to a
+// pending Heartbeat call.
+func (c *Conn) handleHeartbeat(h *protocol.Heartbeat) (*alert.Alert, error) {
+	if c.state.localHeartbeatMode == 0 {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errHeartbeatNotNegotiated
+	}
+
+	switch h.Type {
+	case protocol.HeartbeatMessageTypeRequest:
+		if c.state.localHeartbeatMode != HeartbeatModePeerAllowedToSend {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errHeartbeatNotAllowed
+		}
+		if err := c.writeHeartbeat(protocol.HeartbeatMessageTypeResponse, h.Payload); err != nil {
+			c.log.Debugf("failed to send heartbeat response: %v", err)
+		}
+	case protocol.HeartbeatMessageTypeResponse:
+		select {
+		case c.heartbeatResponse <- h.Payload:
+		default:
+			c.log.Debug("discarded unexpected heartbeat response")
+		}
+	default:
+		// Unknown message types must be discarded silently
+		c.log.Debugf("discarded heartbeat of unknown type %d", h.Type)
+	}
+	return nil, nil
+}
@@ -26,8 +26,14 @@ func newAes128Ccm(clientCertificateType clientcertificate.Type, id ID, psk bool,
 	}
 }
 
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *Aes128Ccm) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 0, 16, 4
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *Aes128Ccm) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
-	const prfKeyLen = 16
+	_, prfKeyLen, _ := c.KeyLengths()
 	return c.AesCcm.Init(masterSecret, clientRandom, serverRandom, isClient, prfKeyLen)
 }
@@ -55,6 +55,11 @@ func (c *AesCcm) HashFunc() func() hash.Hash {
 	return sha256.New
 }
 
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *AesCcm) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
 // AuthenticationType controls what authentication method is using during the handshake
 func (c *AesCcm) AuthenticationType() AuthenticationType {
 	if c.psk {
@@ -47,6 +47,12 @@ func (i ID) String() string {
 		return "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
 	case TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
 		return "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256"
+	case TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256:
+		return "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256"
+	case TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256:
+		return "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"
+	case TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256:
+		return "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"
 	default:
 		return fmt.Sprintf("unknown(%v)", uint16(i))
 	}
@@ -68,6 +74,10 @@ const (
 	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA ID = 0xc00a //nolint:revive,stylecheck
 	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA   ID = 0xc014 //nolint:revive,stylecheck
 
+	// ChaCha20-Poly1305-SHA256
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 ID = 0xcca9 //nolint:revive,stylecheck
+	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256   ID = 0xcca8 //nolint:revive,stylecheck
+
 	TLS_PSK_WITH_AES_128_CCM        ID = 0xc0a4 //nolint:revive,stylecheck
 	TLS_PSK_WITH_AES_128_CCM_8      ID = 0xc0a8 //nolint:revive,stylecheck
 	TLS_PSK_WITH_AES_256_CCM_8      ID = 0xc0a9 //nolint:revive,stylecheck
@@ -75,6 +85,7 @@ const (
 	TLS_PSK_WITH_AES_128_CBC_SHA256 ID = 0x00ae //nolint:revive,stylecheck
 
 	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 ID = 0xC037 //nolint:revive,stylecheck
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 ID = 0xd001 //nolint:revive,stylecheck
 )
 
 // AuthenticationType controls what authentication method is using during the handshake
@@ -49,6 +49,11 @@ func (c *TLSEcdheEcdsaWithAes128GcmSha256) HashFunc() func() hash.Hash {
 	return sha256.New
 }
 
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *TLSEcdheEcdsaWithAes128GcmSha256) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
 // AuthenticationType controls what authentication method is using during the handshake
 func (c *TLSEcdheEcdsaWithAes128GcmSha256) AuthenticationType() AuthenticationType {
 	return AuthenticationTypeCertificate
@@ -76,13 +81,15 @@ func (c *TLSEcdheEcdsaWithAes128GcmSha256) init(masterSecret, clientRandom, serv
 	return err
 }
 
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *TLSEcdheEcdsaWithAes128GcmSha256) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 0, 16, 4
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdheEcdsaWithAes128GcmSha256) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
-	const (
-		prfMacLen = 0
-		prfKeyLen = 16
-		prfIvLen  = 4
-	)
+	prfMacLen, prfKeyLen, prfIvLen := c.KeyLengths()
 
 	return c.init(masterSecret, clientRandom, serverRandom, isClient, prfMacLen, prfKeyLen, prfIvLen, c.HashFunc())
 }
@@ -18,7 +18,8 @@ import (
 
 // TLSEcdheEcdsaWithAes256CbcSha represents a TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA CipherSuite
 type TLSEcdheEcdsaWithAes256CbcSha struct {
-	cbc atomic.Value // *cryptoCBC
+	cbc            atomic.Value // *cryptoCBC
+	encryptThenMAC bool
 }
 
 // CertificateType returns what type of certficate this CipherSuite exchanges
@@ -50,6 +51,11 @@ func (c *TLSEcdheEcdsaWithAes256CbcSha) HashFunc() func() hash.Hash {
 	return sha256.New
 }
 
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *TLSEcdheEcdsaWithAes256CbcSha) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
 // AuthenticationType controls what authentication method is using during the handshake
 func (c *TLSEcdheEcdsaWithAes256CbcSha) AuthenticationType() AuthenticationType {
 	return AuthenticationTypeCertificate
@@ -61,28 +67,40 @@ func (c *TLSEcdheEcdsaWithAes256CbcSha) IsInitialized() bool {
 	return c.cbc.Load() != nil
 }
 
+// SetEncryptThenMAC selects the record processing of the encrypt_then_mac
+// extension for the next Init
+func (c *TLSEcdheEcdsaWithAes256CbcSha) SetEncryptThenMAC(enabled bool) {
+	c.encryptThenMAC = enabled
+}
+
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *TLSEcdheEcdsaWithAes256CbcSha) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 20, 32, 16
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdheEcdsaWithAes256CbcSha) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
-	const (
-		prfMacLen = 20
-		prfKeyLen = 32
-		prfIvLen  = 16
-	)
+	prfMacLen, prfKeyLen, prfIvLen := c.KeyLengths()
 
 	keys, err := prf.GenerateEncryptionKeys(masterSecret, clientRandom, serverRandom, prfMacLen, prfKeyLen, prfIvLen, c.HashFunc())
 	if err != nil {
 		return err
 	}
 
+	newCBC := ciphersuite.NewCBC
+	if c.encryptThenMAC {
+		newCBC = ciphersuite.NewCBCEncryptThenMAC
+	}
 	var cbc *ciphersuite.CBC
 	if isClient {
-		cbc, err = ciphersuite.NewCBC(
+		cbc, err = newCBC(
 			keys.ClientWriteKey, keys.ClientWriteIV, keys.ClientMACKey,
 			keys.ServerWriteKey, keys.ServerWriteIV, keys.ServerMACKey,
 			sha1.New,
 		)
 	} else {
-		cbc, err = ciphersuite.NewCBC(
+		cbc, err = newCBC(
 			keys.ServerWriteKey, keys.ServerWriteIV, keys.ServerMACKey,
 			keys.ClientWriteKey, keys.ClientWriteIV, keys.ClientMACKey,
 			sha1.New,
@@ -8,7 +8,7 @@ import (
 	"hash"
 )
 
-// TLSEcdheEcdsaWithAes256GcmSha384  represents a TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 CipherSuite
+// TLSEcdheEcdsaWithAes256GcmSha384 represents a TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 CipherSuite
 type TLSEcdheEcdsaWithAes256GcmSha384 struct {
 	TLSEcdheEcdsaWithAes128GcmSha256
 }
@@ -27,13 +27,20 @@ func (c *TLSEcdheEcdsaWithAes256GcmSha384) HashFunc() func() hash.Hash {
 	return sha512.New384
 }
 
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *TLSEcdheEcdsaWithAes256GcmSha384) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *TLSEcdheEcdsaWithAes256GcmSha384) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 0, 32, 4
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdheEcdsaWithAes256GcmSha384) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
-	const (
-		prfMacLen = 0
-		prfKeyLen = 32
-		prfIvLen  = 4
-	)
+	prfMacLen, prfKeyLen, prfIvLen := c.KeyLengths()
 
 	return c.init(masterSecret, clientRandom, serverRandom, isClient, prfMacLen, prfKeyLen, prfIvLen, c.HashFunc())
 }
@@ -0,0 +1,115 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sync/atomic"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/ciphersuite"
+	"github.com/adrian38/dtls/v2/pkg/crypto/clientcertificate"
+	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+// TLSEcdheEcdsaWithChaCha20Poly1305Sha256 represents a TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256 CipherSuite
+type TLSEcdheEcdsaWithChaCha20Poly1305Sha256 struct {
+	aead atomic.Value // *ciphersuite.ChaCha20Poly1305
+}
+
+// CertificateType returns what type of certficate this CipherSuite exchanges
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) CertificateType() clientcertificate.Type {
+	return clientcertificate.ECDSASign
+}
+
+// KeyExchangeAlgorithm controls what key exchange algorithm is using during the handshake
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) KeyExchangeAlgorithm() KeyExchangeAlgorithm {
+	return KeyExchangeAlgorithmEcdhe
+}
+
+// ECC uses Elliptic Curve Cryptography
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) ECC() bool {
+	return true
+}
+
+// ID returns the ID of the CipherSuite
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) ID() ID {
+	return TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256
+}
+
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) String() string {
+	return "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256"
+}
+
+// HashFunc returns the hashing func for this CipherSuite
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) HashFunc() func() hash.Hash {
+	return sha256.New
+}
+
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
+// AuthenticationType controls what authentication method is using during the handshake
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) AuthenticationType() AuthenticationType {
+	return AuthenticationTypeCertificate
+}
+
+// IsInitialized returns if the CipherSuite has keying material and can
+// encrypt/decrypt packets
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) IsInitialized() bool {
+	return c.aead.Load() != nil
+}
+
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) init(masterSecret, clientRandom, serverRandom []byte, isClient bool, prfMacLen, prfKeyLen, prfIvLen int, hashFunc func() hash.Hash) error {
+	keys, err := prf.GenerateEncryptionKeys(masterSecret, clientRandom, serverRandom, prfMacLen, prfKeyLen, prfIvLen, hashFunc)
+	if err != nil {
+		return err
+	}
+
+	var aead *ciphersuite.ChaCha20Poly1305
+	if isClient {
+		aead, err = ciphersuite.NewChaCha20Poly1305(keys.ClientWriteKey, keys.ClientWriteIV, keys.ServerWriteKey, keys.ServerWriteIV)
+	} else {
+		aead, err = ciphersuite.NewChaCha20Poly1305(keys.ServerWriteKey, keys.ServerWriteIV, keys.ClientWriteKey, keys.ClientWriteIV)
+	}
+	c.aead.Store(aead)
+	return err
+}
+
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 0, 32, 12
+}
+
+// Init initializes the internal Cipher with keying material
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
+	prfMacLen, prfKeyLen, prfIvLen := c.KeyLengths()
+
+	return c.init(masterSecret, clientRandom, serverRandom, isClient, prfMacLen, prfKeyLen, prfIvLen, c.HashFunc())
+}
+
+// Encrypt encrypts a single TLS RecordLayer
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
+	cipherSuite, ok := c.aead.Load().(*ciphersuite.ChaCha20Poly1305)
+	if !ok {
+		return nil, fmt.Errorf("%w, unable to encrypt", errCipherSuiteNotInit)
+	}
+
+	return cipherSuite.Encrypt(pkt, raw)
+}
+
+// Decrypt decrypts a single TLS RecordLayer
+func (c *TLSEcdheEcdsaWithChaCha20Poly1305Sha256) Decrypt(h recordlayer.Header, raw []byte) ([]byte, error) {
+	cipherSuite, ok := c.aead.Load().(*ciphersuite.ChaCha20Poly1305)
+	if !ok {
+		return nil, fmt.Errorf("%w, unable to decrypt", errCipherSuiteNotInit)
+	}
+
+	return cipherSuite.Decrypt(h, raw)
+}
@@ -17,7 +17,8 @@ import (
 
 // TLSEcdhePskWithAes128CbcSha256 implements the TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 CipherSuite
 type TLSEcdhePskWithAes128CbcSha256 struct {
-	cbc atomic.Value // *cryptoCBC
+	cbc            atomic.Value // *cryptoCBC
+	encryptThenMAC bool
 }
 
 // NewTLSEcdhePskWithAes128CbcSha256 creates TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 cipher.
@@ -54,6 +55,11 @@ func (c *TLSEcdhePskWithAes128CbcSha256) HashFunc() func() hash.Hash {
 	return sha256.New
 }
 
+// PRFHashFunc returns the hashing func Init derives the keys with
+func (c *TLSEcdhePskWithAes128CbcSha256) PRFHashFunc() func() hash.Hash {
+	return c.HashFunc()
+}
+
 // AuthenticationType controls what authentication method is using during the handshake
 func (c *TLSEcdhePskWithAes128CbcSha256) AuthenticationType() AuthenticationType {
 	return AuthenticationTypePreSharedKey
@@ -65,28 +71,40 @@ func (c *TLSEcdhePskWithAes128CbcSha256) IsInitialized() bool {
 	return c.cbc.Load() != nil
 }
 
+// SetEncryptThenMAC selects the record processing of the encrypt_then_mac
+// extension for the next Init
+func (c *TLSEcdhePskWithAes128CbcSha256) SetEncryptThenMAC(enabled bool) {
+	c.encryptThenMAC = enabled
+}
+
+// KeyLengths returns the lengths of the MAC key, encryption key and IV
+// derived for this CipherSuite
+func (c *TLSEcdhePskWithAes128CbcSha256) KeyLengths() (macLen, keyLen, ivLen int) {
+	return 32, 16, 16
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdhePskWithAes128CbcSha256) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
-	const (
-		prfMacLen = 32
-		prfKeyLen = 16
-		prfIvLen  = 16
-	)
+	prfMacLen, prfKeyLen, prfIvLen := c.KeyLengths()
 
 	keys, err := prf.GenerateEncryptionKeys(masterSecret, clientRandom, serverRandom, prfMacLen, prfKeyLen, prfIvLen, c.HashFunc())
 	if err != nil {
 		return err
 	}
 
+	newCBC := ciphersuite.NewCBC
+	if c.encryptThenMAC {
+		newCBC = ciphersuite.NewCBCEncryptThenMAC
+	}
 	var cbc *ciphersuite.CBC
 	if isClient {
-		cbc, err = ciphersuite.NewCBC(
+		cbc, err = newCBC(
 			keys.ClientWriteKey, keys.ClientWriteIV, keys.ClientMACKey,
 			keys.ServerWriteKey, keys.ServerWriteIV, keys.ServerMACKey,
 			c.HashFunc(),
 		)
 	} else {
-		cbc, err = ciphersuite.NewCBC(
+		cbc, err = newCBC(
 			keys.ServerWriteKey, keys.ServerWriteIV, keys.ServerMACKey,
 			keys.ClientWriteKey, keys.ClientWriteIV, keys.ClientMACKey,
 			c.HashFunc(),
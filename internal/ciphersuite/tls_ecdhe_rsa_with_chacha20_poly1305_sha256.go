@@ -0,0 +1,25 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import "github.com/adrian38/dtls/v2/pkg/crypto/clientcertificate"
+
+// TLSEcdheRsaWithChaCha20Poly1305Sha256 implements the TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256 CipherSuite
+type TLSEcdheRsaWithChaCha20Poly1305Sha256 struct {
+	TLSEcdheEcdsaWithChaCha20Poly1305Sha256
+}
+
+// CertificateType returns what type of certificate this CipherSuite exchanges
+func (c *TLSEcdheRsaWithChaCha20Poly1305Sha256) CertificateType() clientcertificate.Type {
+	return clientcertificate.RSASign
+}
+
+// ID returns the ID of the CipherSuite
+func (c *TLSEcdheRsaWithChaCha20Poly1305Sha256) ID() ID {
+	return TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256
+}
+
+func (c *TLSEcdheRsaWithChaCha20Poly1305Sha256) String() string {
+	return "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256"
+}
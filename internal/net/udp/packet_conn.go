@@ -22,6 +22,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	idtlsnet "github.com/adrian38/dtls/v2/internal/net"
@@ -155,6 +156,31 @@ type ListenConfig struct {
 	// the identifier is not already associated with the connection, it will be
 	// added.
 	ConnectionIdentifier func([]byte) (string, bool)
+
+	// Control is called after creating the socket and before binding it, see
+	// net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+}
+
+func (lc *ListenConfig) listenUDP(network string, laddr *net.UDPAddr) (*net.UDPConn, error) {
+	if lc.Control == nil {
+		return net.ListenUDP(network, laddr)
+	}
+	var address string
+	if laddr != nil {
+		address = laddr.String()
+	}
+	nlc := net.ListenConfig{Control: lc.Control}
+	conn, err := nlc.ListenPacket(context.Background(), network, address)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		_ = conn.Close()
+		return nil, net.UnknownNetworkError(network)
+	}
+	return udpConn, nil
 }
 
 // Listen creates a new listener based on the ListenConfig.
@@ -163,7 +189,7 @@ func (lc *ListenConfig) Listen(network string, laddr *net.UDPAddr) (dtlsnet.Pack
 		lc.Backlog = defaultListenBacklog
 	}
 
-	conn, err := net.ListenUDP(network, laddr)
+	conn, err := lc.listenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
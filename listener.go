@@ -4,7 +4,12 @@
 package dtls
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
 
 	"github.com/adrian38/dtls/v2/internal/net/udp"
 	dtlsnet "github.com/adrian38/dtls/v2/pkg/net"
@@ -14,11 +19,31 @@ import (
 
 // Listen creates a DTLS listener
 func Listen(network string, laddr *net.UDPAddr, config *Config) (net.Listener, error) {
+	return (&ListenConfig{}).Listen(network, laddr, config)
+}
+
+// ListenConfig contains options for the UDP socket of a DTLS listener,
+// analogous to net.ListenConfig.
+type ListenConfig struct {
+	// Control is called after creating the socket and before binding it, e.g.
+	// to set socket options, see net.ListenConfig.
+	Control func(network, address string, c syscall.RawConn) error
+
+	// ReusePort sets SO_REUSEPORT on the socket, so that several listeners,
+	// e.g. one per core, can bind the same port. The kernel distributes the
+	// datagrams among them by the address of the client, a client that
+	// changes its address may reach another listener. ReusePort is only
+	// supported on Unix systems.
+	ReusePort bool
+}
+
+// Listen creates a DTLS listener on a socket with the options of lc
+func (lc *ListenConfig) Listen(network string, laddr *net.UDPAddr, config *Config) (net.Listener, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, err
 	}
 
-	lc := udp.ListenConfig{
+	ulc := udp.ListenConfig{
 		AcceptFilter: func(packet []byte) bool {
 			pkts, err := recordlayer.UnpackDatagram(packet)
 			if err != nil || len(pkts) < 1 {
@@ -34,17 +59,35 @@ func Listen(network string, laddr *net.UDPAddr, config *Config) (net.Listener, e
 	// If connection ID support is enabled, then they must be supported in
 	// routing.
 	if config.ConnectionIDGenerator != nil {
-		lc.DatagramRouter = cidDatagramRouter(len(config.ConnectionIDGenerator()))
-		lc.ConnectionIdentifier = cidConnIdentifier()
+		ulc.DatagramRouter = cidDatagramRouter(len(config.ConnectionIDGenerator()))
+		ulc.ConnectionIdentifier = cidConnIdentifier()
+	}
+	if lc.Control != nil || lc.ReusePort {
+		ulc.Control = lc.control
 	}
-	parent, err := lc.Listen(network, laddr)
+	parent, err := ulc.Listen(network, laddr)
 	if err != nil {
 		return nil, err
 	}
-	return &listener{
-		config: config,
-		parent: parent,
-	}, nil
+	return newListener(parent, config), nil
+}
+
+func (lc *ListenConfig) control(network, address string, c syscall.RawConn) error {
+	if lc.Control != nil {
+		if err := lc.Control(network, address, c); err != nil {
+			return err
+		}
+	}
+	if !lc.ReusePort {
+		return nil
+	}
+	var err error
+	if controlErr := c.Control(func(fd uintptr) {
+		err = setReusePort(fd)
+	}); controlErr != nil {
+		return controlErr
+	}
+	return err
 }
 
 // NewListener creates a DTLS listener which accepts connections from an inner Listener.
@@ -53,16 +96,133 @@ func NewListener(inner dtlsnet.PacketListener, config *Config) (net.Listener, er
 		return nil, err
 	}
 
-	return &listener{
-		config: config,
-		parent: inner,
-	}, nil
+	return newListener(inner, config), nil
+}
+
+// ContextListener is a net.Listener whose Accept can be canceled. The
+// listeners returned by Listen and NewListener implement it.
+type ContextListener interface {
+	net.Listener
+
+	// AcceptContext waits for and returns the next connection to the
+	// listener, like Accept. It returns early with an error wrapping
+	// ctx.Err() when ctx is done, the handshake of the connection being
+	// accepted is aborted as well.
+	AcceptContext(ctx context.Context) (net.Conn, error)
+}
+
+// DrainListener is a ContextListener which can stop accepting connections
+// while the accepted ones stay usable, e.g. for rolling deploys. The
+// listeners returned by Listen and NewListener implement it.
+type DrainListener interface {
+	ContextListener
+
+	// Drain stops accepting connections and returns once no new handshake
+	// will be started. Blocked and later Accept calls return an error,
+	// handshakes already started by Accept complete. Close has to be called
+	// still, it doesn't close the accepted connections either.
+	Drain() error
+
+	// ActiveConns returns the number of accepted connections which are not
+	// closed yet, including those still handshaking.
+	ActiveConns() int
 }
 
 // listener represents a DTLS listener
 type listener struct {
 	config *Config
 	parent dtlsnet.PacketListener
+
+	// A single loop accepts from parent, so that a canceled AcceptContext
+	// doesn't lose the connection accepted after it gave up
+	acceptOnce sync.Once
+	accepted   chan acceptedConn
+	acceptDone chan struct{}
+	acceptErr  error
+	closeOnce  sync.Once
+	closed     chan struct{}
+
+	// handshakes holds a token for every running handshake, it is nil if
+	// Config.MaxConcurrentHandshakes is not set
+	handshakes chan struct{}
+
+	// rateLimiter is nil if new handshakes are not rate limited
+	rateLimiter HandshakeRateLimiter
+
+	// statelessCookies is nil unless Config.StatelessCookieSecret is set
+	statelessCookies *statelessCookies
+
+	activeConns int32 // Accepted connections which are not closed, accessed atomically
+}
+
+type acceptedConn struct {
+	conn  net.PacketConn
+	raddr net.Addr
+}
+
+func newListener(parent dtlsnet.PacketListener, config *Config) *listener {
+	l := &listener{
+		config:     config,
+		parent:     parent,
+		accepted:   make(chan acceptedConn),
+		acceptDone: make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	if config.MaxConcurrentHandshakes > 0 {
+		l.handshakes = make(chan struct{}, config.MaxConcurrentHandshakes)
+	}
+	switch {
+	case config.HandshakeRateLimiter != nil:
+		l.rateLimiter = config.HandshakeRateLimiter
+	case config.RateLimitPerSource > 0:
+		l.rateLimiter = NewSourceRateLimiter(config.RateLimitPerSource, config.RateLimitWindow)
+	}
+	if len(config.StatelessCookieSecret) > 0 {
+		l.statelessCookies = newStatelessCookies(config.StatelessCookieSecret, config.Time, config.CookieLifetime)
+	}
+	return l
+}
+
+func (l *listener) acceptLoop() {
+	defer close(l.acceptDone)
+	for {
+		c, raddr, err := l.parent.Accept()
+		if err != nil {
+			l.acceptErr = err
+			return
+		}
+		if l.statelessCookies != nil {
+			// Clients are answered without any handshake state until they
+			// return a valid cookie
+			datagram, ok := l.statelessCookies.answerHello(c, raddr)
+			if !ok {
+				_ = c.Close()
+				continue
+			}
+			c = &replayPacketConn{PacketConn: c, datagram: datagram, raddr: raddr}
+		}
+		if !l.acquireHandshake() {
+			// Closing the connection drops its ClientHello without answering
+			// it, the client is accepted again once it retransmits
+			_ = c.Close()
+			continue
+		}
+		// Asked only once a slot is free, ClientHellos dropped for the
+		// concurrency limit don't count against the rate limit
+		if l.rateLimiter != nil && !l.rateLimiter.Allow(raddr) {
+			l.releaseHandshake()
+			_ = c.Close()
+			continue
+		}
+		select {
+		case l.accepted <- acceptedConn{c, raddr}:
+		case <-l.closed:
+			l.releaseHandshake()
+			_ = c.Close()
+			l.acceptErr = net.ErrClosed
+			return
+		}
+	}
 }
 
 // Accept waits for and returns the next connection to the listener.
@@ -70,20 +230,115 @@ type listener struct {
 // Connection handshake will timeout using ConnectContextMaker in the Config.
 // If you want to specify the timeout duration, set ConnectContextMaker.
 func (l *listener) Accept() (net.Conn, error) {
-	c, raddr, err := l.parent.Accept()
+	return l.AcceptContext(context.Background())
+}
+
+// AcceptContext implements ContextListener. The handshake is bounded by both
+// ctx and ConnectContextMaker in the Config.
+func (l *listener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	l.acceptOnce.Do(func() {
+		go l.acceptLoop()
+	})
+
+	var a acceptedConn
+	select {
+	case a = <-l.accepted:
+	case <-l.acceptDone:
+		return nil, l.acceptErr
+	case <-ctx.Done():
+		return nil, fmt.Errorf("accept: %w", ctx.Err())
+	}
+
+	hsCtx, cancel := l.config.connectContextMaker()
+	defer cancel()
+	hsCtx, cancelHs := context.WithCancel(hsCtx)
+	defer cancelHs()
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelHs()
+		case <-hsCtx.Done():
+		}
+	}()
+
+	defer l.releaseHandshake()
+	atomic.AddInt32(&l.activeConns, 1)
+	tracked := &trackedPacketConn{PacketConn: a.conn, onClose: func() {
+		atomic.AddInt32(&l.activeConns, -1)
+	}}
+	c, err := ServerWithContext(hsCtx, tracked, a.raddr, l.config)
 	if err != nil {
+		tracked.untrack()
 		return nil, err
 	}
-	return Server(c, raddr, l.config)
+	return c, nil
+}
+
+// acquireHandshake takes a slot for a new handshake, it returns false if
+// Config.MaxConcurrentHandshakes handshakes are already running
+func (l *listener) acquireHandshake() bool {
+	if l.handshakes == nil {
+		return true
+	}
+	select {
+	case l.handshakes <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *listener) releaseHandshake() {
+	if l.handshakes != nil {
+		<-l.handshakes
+	}
 }
 
 // Close closes the listener.
 // Any blocked Accept operations will be unblocked and return errors.
 // Already Accepted connections are not closed.
 func (l *listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
 	return l.parent.Close()
 }
 
+// Drain implements DrainListener.
+func (l *listener) Drain() error {
+	err := l.Close()
+	// Wait for the ClientHello being answered or handed to Accept
+	l.acceptOnce.Do(func() {
+		go l.acceptLoop()
+	})
+	<-l.acceptDone
+	return err
+}
+
+// ActiveConns implements DrainListener.
+func (l *listener) ActiveConns() int {
+	return int(atomic.LoadInt32(&l.activeConns))
+}
+
+// trackedPacketConn calls onClose once the connection of an accepted Conn is
+// closed
+type trackedPacketConn struct {
+	net.PacketConn
+
+	once    sync.Once
+	onClose func()
+}
+
+func (c *trackedPacketConn) Close() error {
+	err := c.PacketConn.Close()
+	c.untrack()
+	return err
+}
+
+func (c *trackedPacketConn) untrack() {
+	c.once.Do(c.onClose)
+}
+
 // Addr returns the listener's network address.
 func (l *listener) Addr() net.Addr {
 	return l.parent.Addr()
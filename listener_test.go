@@ -0,0 +1,1041 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/selfsign"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v3/test"
+)
+
+func listenLocal(t *testing.T) ContextListener {
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates: []tls.Certificate{cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, ok := l.(ContextListener)
+	if !ok {
+		t.Fatal("Listener does not implement ContextListener")
+	}
+	return cl
+}
+
+func TestListenerAcceptContext(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	listener := listenLocal(t)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := listener.AcceptContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected error '%v', got '%v'", context.DeadlineExceeded, err)
+	}
+
+	// The listener keeps working after a canceled Accept
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		client, err := DialWithContext(ctx, "udp", listener.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true})
+		c <- result{client, err}
+	}()
+
+	server, err := listener.AcceptContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+	_ = server.Close()
+}
+
+func TestListenerAcceptContextAbortsHandshake(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	listener := listenLocal(t)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	// Start a handshake with a ClientHello and never answer the server
+	client, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	clientHello := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{Version: protocol.Version1_2},
+		Content: &handshake.Handshake{
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+				CompressionMethods: defaultCompressionMethods(),
+			},
+		},
+	}
+	raw, err := clientHello.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	accepted := make(chan error)
+	go func() {
+		_, err := listener.AcceptContext(ctx)
+		accepted <- err
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-accepted:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected error '%v', got '%v'", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Canceling the context did not abort the handshake")
+	}
+}
+
+func TestListenerMaxConcurrentHandshakes(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:            []tls.Certificate{cert},
+		MaxConcurrentHandshakes: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, ok := l.(ContextListener)
+	if !ok {
+		t.Fatal("Listener does not implement ContextListener")
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	clientHello := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{Version: protocol.Version1_2},
+		Content: &handshake.Handshake{
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+				CompressionMethods: defaultCompressionMethods(),
+			},
+		},
+	}
+	raw, err := clientHello.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sendClientHello starts a handshake that is never completed
+	sendClientHello := func() *net.UDPConn {
+		client, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Without the limit the second Accept would handshake with the second
+	// client
+	accepted := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := listener.AcceptContext(ctx)
+			accepted <- err
+		}()
+	}
+
+	first := sendClientHello()
+	defer func() {
+		_ = first.Close()
+	}()
+	buf := make([]byte, 1500)
+	if err := first.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := first.Read(buf); err != nil {
+		t.Fatalf("Expected HelloVerifyRequest, got error '%v'", err)
+	}
+
+	// The only slot is taken, the second ClientHello is not answered
+	second := sendClientHello()
+	defer func() {
+		_ = second.Close()
+	}()
+	if err := second.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	var netErr net.Error
+	if _, err := second.Read(buf); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected the ClientHello to be dropped, got error '%v'", err)
+	}
+
+	// Aborting the first handshake frees its slot
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-accepted; !errors.Is(err, context.Canceled) {
+			t.Fatalf("Expected error '%v', got '%v'", context.Canceled, err)
+		}
+	}
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		client, err := DialWithContext(ctx, "udp", listener.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true})
+		c <- result{client, err}
+	}()
+
+	server, err := listener.AcceptContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+	_ = server.Close()
+}
+
+// addressRateLimiter allows one handshake per remote address, including the
+// port, so clients on the same host are limited independently
+type addressRateLimiter struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (r *addressRateLimiter) Allow(raddr net.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls[raddr.String()]++
+	return r.calls[raddr.String()] == 1
+}
+
+func TestListenerRateLimit(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := &addressRateLimiter{calls: map[string]int{}}
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:         []tls.Certificate{cert},
+		HandshakeRateLimiter: limiter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, ok := l.(ContextListener)
+	if !ok {
+		t.Fatal("Listener does not implement ContextListener")
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	clientHello := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{Version: protocol.Version1_2},
+		Content: &handshake.Handshake{
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+				CompressionMethods: defaultCompressionMethods(),
+			},
+		},
+	}
+	raw, err := clientHello.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial := func() *net.UDPConn {
+		client, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	// answered sends a ClientHello and reports whether the server answered
+	answered := func(client *net.UDPConn, timeout time.Duration) bool {
+		if _, err := client.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			t.Fatal(err)
+		}
+		var netErr net.Error
+		_, err := client.Read(make([]byte, 1500))
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return false
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return true
+	}
+	// accept runs an Accept until the returned function aborts it
+	accept := func() func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		accepted := make(chan error)
+		go func() {
+			_, err := listener.AcceptContext(ctx)
+			accepted <- err
+		}()
+		return func() {
+			cancel()
+			if err := <-accepted; !errors.Is(err, context.Canceled) {
+				t.Fatalf("Expected error '%v', got '%v'", context.Canceled, err)
+			}
+		}
+	}
+
+	first := dial()
+	defer func() {
+		_ = first.Close()
+	}()
+	abort := accept()
+	if !answered(first, 5*time.Second) {
+		t.Fatal("Expected HelloVerifyRequest for the first ClientHello")
+	}
+	abort()
+
+	// A new handshake from the same address exceeds the limit
+	if answered(first, 200*time.Millisecond) {
+		t.Fatal("Expected the excess ClientHello to be dropped")
+	}
+
+	// Another address is not affected
+	second := dial()
+	defer func() {
+		_ = second.Close()
+	}()
+	abort = accept()
+	if !answered(second, 5*time.Second) {
+		t.Fatal("Expected HelloVerifyRequest for another address")
+	}
+	abort()
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if calls := limiter.calls[first.LocalAddr().String()]; calls != 2 {
+		t.Errorf("Expected the limiter to be asked twice for the first address, got %d", calls)
+	}
+}
+
+func TestListenerStatelessCookie(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := make(chan HandshakeStats, 1)
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:          []tls.Certificate{cert},
+		StatelessCookieSecret: []byte("0123456789abcdef"),
+		OnHandshakeComplete: func(s HandshakeStats) {
+			stats <- s
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	accepted := make(chan result)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			buf := make([]byte, 16)
+			var n int
+			if n, err = c.Read(buf); err == nil {
+				_, err = c.Write(buf[:n])
+			}
+		}
+		accepted <- result{c, err}
+	}()
+
+	client, err := Dial("udp", l.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("Expected echo 'ping', got '%s'", buf[:n])
+	}
+
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	_ = res.c.Close()
+
+	// The client accepts the first flight of the server Conn, which continues
+	// the message and record sequence of the HelloVerifyRequest
+	if s := <-stats; s.Retransmits != 0 {
+		t.Errorf("Expected no retransmissions, got %d", s.Retransmits)
+	}
+	if cookie := client.state.cookie; len(cookie) != statelessCookieLength {
+		t.Errorf("Expected a %d byte stateless cookie, got %d bytes", statelessCookieLength, len(cookie))
+	}
+}
+
+func TestListenerStatelessCookieExpired(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	const lifetime = 10 * time.Second
+	var elapsed int64 // accessed atomically
+	start := time.Now()
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:          []tls.Certificate{cert},
+		StatelessCookieSecret: []byte("0123456789abcdef"),
+		CookieLifetime:        lifetime,
+		Time: func() time.Time {
+			return start.Add(time.Duration(atomic.LoadInt64(&elapsed)))
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ClientHellos are answered while Accept waits for a valid cookie
+	acceptErr := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		acceptErr <- err
+	}()
+	defer func() {
+		_ = l.Close()
+		<-acceptErr
+	}()
+
+	client, err := net.DialUDP("udp", nil, l.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// helloVerify sends a ClientHello with cookie and returns the cookie of
+	// the HelloVerifyRequest answering it
+	buf := make([]byte, 1500)
+	helloVerify := func(seq uint64, cookie []byte) []byte {
+		hello := &handshake.MessageClientHello{
+			Version:            protocol.Version1_2,
+			Cookie:             cookie,
+			CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+			CompressionMethods: defaultCompressionMethods(),
+		}
+		raw, err := (&recordlayer.RecordLayer{
+			Header:  recordlayer.Header{Version: protocol.Version1_2, SequenceNumber: seq},
+			Content: &handshake.Handshake{Message: hello},
+		}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(raw); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var r recordlayer.RecordLayer
+		if err := r.Unmarshal(buf[:n]); err != nil {
+			t.Fatal(err)
+		}
+		h, ok := r.Content.(*handshake.Handshake)
+		if !ok {
+			t.Fatalf("Expected handshake, got %T", r.Content)
+		}
+		hvr, ok := h.Message.(*handshake.MessageHelloVerifyRequest)
+		if !ok {
+			t.Fatalf("Expected HelloVerifyRequest, got %T", h.Message)
+		}
+		return append([]byte{}, hvr.Cookie...)
+	}
+
+	cookie := helloVerify(0, nil)
+
+	// The cookie expired before the client answered, a new one is issued
+	atomic.StoreInt64(&elapsed, int64(lifetime+time.Second))
+	reissued := helloVerify(1, cookie)
+	if bytes.Equal(cookie, reissued) {
+		t.Fatal("Expected a new cookie for the expired one")
+	}
+	random := (&handshake.Random{}).MarshalFixed()
+	if err := newStatelessCookies([]byte("0123456789abcdef"), func() time.Time {
+		return start.Add(lifetime + time.Second)
+	}, lifetime).verify(client.LocalAddr(), random[:], reissued); err != nil {
+		t.Fatalf("Expected the new cookie to be valid, got '%v'", err)
+	}
+}
+
+func TestListenerStatelessCookieFlood(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:          []tls.Certificate{cert},
+		StatelessCookieSecret: []byte("0123456789abcdef"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, ok := l.(ContextListener)
+	if !ok {
+		t.Fatal("Listener does not implement ContextListener")
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	acceptErr := make(chan error)
+	go func() {
+		_, err := listener.AcceptContext(ctx)
+		acceptErr <- err
+	}()
+
+	client, err := net.DialUDP("udp", nil, listener.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	// flood sends ClientHellos with distinct randoms and never answers the
+	// HelloVerifyRequests
+	buf := make([]byte, 1500)
+	flood := func(from, to int) {
+		for i := from; i < to; i++ {
+			hello := &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				CipherSuiteIDs:     []uint16{uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+				CompressionMethods: defaultCompressionMethods(),
+			}
+			hello.Random.RandomBytes[0], hello.Random.RandomBytes[1] = byte(i), byte(i>>8)
+			raw, err := (&recordlayer.RecordLayer{
+				Header:  recordlayer.Header{Version: protocol.Version1_2, SequenceNumber: uint64(i)},
+				Content: &handshake.Handshake{Message: hello},
+			}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := client.Write(raw); err != nil {
+				t.Fatal(err)
+			}
+			if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var r recordlayer.RecordLayer
+			if err := r.Unmarshal(buf[:n]); err != nil {
+				t.Fatal(err)
+			}
+			h, ok := r.Content.(*handshake.Handshake)
+			if !ok {
+				t.Fatalf("Expected handshake, got %T", r.Content)
+			}
+			hvr, ok := h.Message.(*handshake.MessageHelloVerifyRequest)
+			if !ok {
+				t.Fatalf("Expected HelloVerifyRequest, got %T", h.Message)
+			}
+			if len(hvr.Cookie) != statelessCookieLength {
+				t.Fatalf("Expected a %d byte cookie, got %d bytes", statelessCookieLength, len(hvr.Cookie))
+			}
+			if r.Header.SequenceNumber != uint64(i) {
+				t.Fatalf("Expected the record sequence number %d of the ClientHello, got %d", i, r.Header.SequenceNumber)
+			}
+		}
+	}
+	heapInUse := func() uint64 {
+		runtime.GC()
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return m.HeapInuse
+	}
+
+	flood(0, 100)
+	goroutines := runtime.NumGoroutine()
+	before := heapInUse()
+	flood(100, 2100)
+	after := heapInUse()
+
+	if after > before && after-before > 1<<20 {
+		t.Errorf("Expected the heap to stay flat, it grew from %d to %d bytes", before, after)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("Expected no goroutines for unverified clients, got %d instead of %d", n, goroutines)
+	}
+
+	cancel()
+	if err := <-acceptErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected error '%v', got '%v'", context.Canceled, err)
+	}
+}
+
+func TestConnSetTransport(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, &Config{
+		Certificates:          []tls.Certificate{cert},
+		ConnectionIDGenerator: RandomCIDGenerator(8),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	type result struct {
+		addrs []net.Addr
+		err   error
+	}
+	served := make(chan result)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			served <- result{nil, err}
+			return
+		}
+		defer func() {
+			_ = c.Close()
+		}()
+		var addrs []net.Addr
+		buf := make([]byte, 16)
+		for i := 0; i < 2; i++ {
+			var n int
+			if n, err = c.Read(buf); err != nil {
+				break
+			}
+			addrs = append(addrs, c.RemoteAddr())
+			if _, err = c.Write(buf[:n]); err != nil {
+				break
+			}
+		}
+		served <- result{addrs, err}
+	}()
+
+	openSocket := func() net.PacketConn {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pc
+	}
+	echo := func(c *Conn, msg string) {
+		if _, err := c.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 16)
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != msg {
+			t.Fatalf("Expected echo '%s', got '%s'", msg, buf[:n])
+		}
+	}
+
+	oldSocket := openSocket()
+	defer func() {
+		_ = oldSocket.Close()
+	}()
+	client, err := Client(oldSocket, l.Addr(), &Config{
+		InsecureSkipVerify:    true,
+		ConnectionIDGenerator: OnlySendCIDGenerator(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	echo(client, "before")
+
+	newSocket := openSocket()
+	if err := client.SetTransport(newSocket); err != nil {
+		t.Fatal(err)
+	}
+	if client.LocalAddr().String() != newSocket.LocalAddr().String() {
+		t.Errorf("Expected local address %s, got %s", newSocket.LocalAddr(), client.LocalAddr())
+	}
+	// Nothing may depend on the previous socket anymore
+	_ = oldSocket.Close()
+	echo(client, "after")
+
+	res := <-served
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.addrs[0].String() != oldSocket.LocalAddr().String() {
+		t.Errorf("Expected remote address %s before the migration, got %s", oldSocket.LocalAddr(), res.addrs[0])
+	}
+	if res.addrs[1].String() != newSocket.LocalAddr().String() {
+		t.Errorf("Expected remote address %s after the migration, got %s", newSocket.LocalAddr(), res.addrs[1])
+	}
+
+	if err := client.SetTransport(nil); !errors.Is(err, errNilNextConn) {
+		t.Errorf("Expected error '%v', got '%v'", errNilNextConn, err)
+	}
+}
+
+func TestConnSetTransportWithoutConnectionID(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	l := listenLocal(t)
+	defer func() {
+		_ = l.Close()
+	}()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			// Complete the handshake of the server side
+			_, _ = c.Read(make([]byte, 16))
+		}
+		accepted <- c
+	}()
+
+	client, err := Dial("udp", l.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = pc.Close()
+	}()
+	if err := client.SetTransport(pc); !errors.Is(err, errMigrationWithoutConnectionID) {
+		t.Errorf("Expected error '%v', got '%v'", errMigrationWithoutConnectionID, err)
+	}
+
+	_ = client.Close()
+	if c := <-accepted; c != nil {
+		_ = c.Close()
+	}
+}
+
+func TestListenConfigReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_REUSEPORT semantics are only tested on Linux")
+	}
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{Certificates: []tls.Certificate{cert}}
+
+	var controlled int
+	lc := ListenConfig{
+		ReusePort: true,
+		Control: func(string, string, syscall.RawConn) error {
+			controlled++
+			return nil
+		},
+	}
+	first, err := lc.Listen("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = first.Close()
+	}()
+	laddr, ok := first.Addr().(*net.UDPAddr)
+	if !ok {
+		t.Fatalf("Expected a %T, got %T", laddr, first.Addr())
+	}
+
+	if l, err := Listen("udp", laddr, config); err == nil {
+		_ = l.Close()
+		t.Fatal("Expected binding the port without SO_REUSEPORT to fail")
+	}
+
+	second, err := lc.Listen("udp", laddr, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = second.Close()
+	if controlled != 2 {
+		t.Errorf("Expected Control to be called for both listeners, got %d calls", controlled)
+	}
+
+	errControl := errors.New("control failed") //nolint:goerr113
+	lc.Control = func(string, string, syscall.RawConn) error { return errControl }
+	if _, err := lc.Listen("udp", laddr, config); !errors.Is(err, errControl) {
+		t.Errorf("Expected error '%v', got '%v'", errControl, err)
+	}
+}
+
+func TestListenerDrain(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	l := listenLocal(t)
+	defer func() {
+		_ = l.Close()
+	}()
+	dl, ok := l.(DrainListener)
+	if !ok {
+		t.Fatalf("Expected the listener to implement DrainListener")
+	}
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		c, err := l.Accept()
+		accepted <- result{c, err}
+	}()
+	client, err := Dial("udp", l.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	server := res.c
+
+	if err := dl.Drain(); err != nil {
+		t.Fatal(err)
+	}
+	if n := dl.ActiveConns(); n != 1 {
+		t.Errorf("Expected 1 active connection, got %d", n)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Fatal("Expected Accept to fail after Drain")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if c, err := DialWithContext(ctx, "udp", l.Addr().(*net.UDPAddr), &Config{InsecureSkipVerify: true}); err == nil {
+		_ = c.Close()
+		t.Fatal("Expected no handshake after Drain")
+	}
+
+	// The accepted connection keeps transferring data
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := server.Write(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Errorf("Expected echo 'ping', got '%s'", buf[:n])
+	}
+
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := dl.ActiveConns(); n != 0 {
+		t.Errorf("Expected no active connections, got %d", n)
+	}
+}
+
+func TestSourceRateLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	limiter, ok := NewSourceRateLimiter(2, time.Second).(*sourceRateLimiter)
+	if !ok {
+		t.Fatal("Unexpected limiter type")
+	}
+	limiter.now = func() time.Time { return now }
+
+	a1 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1000}
+	a2 := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 2000}
+	b := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1000}
+
+	for i, tt := range []struct {
+		advance time.Duration
+		addr    net.Addr
+		allowed bool
+	}{
+		{0, a1, true},
+		// The port doesn't matter
+		{0, a2, true},
+		{0, a1, false},
+		{0, b, true},
+		{500 * time.Millisecond, a2, false},
+		// A new window starts
+		{500 * time.Millisecond, a1, true},
+	} {
+		now = now.Add(tt.advance)
+		if allowed := limiter.Allow(tt.addr); allowed != tt.allowed {
+			t.Errorf("%d: Allow(%v) expected(%v) actual(%v)", i, tt.addr, tt.allowed, allowed)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+)
+
+// FragmentLength is a maximum record plaintext length a client can request
+// with the max_fragment_length extension.
+// https://tools.ietf.org/html/rfc6066#section-4
+type FragmentLength = extension.FragmentLength
+
+const (
+	FragmentLength512  FragmentLength = extension.FragmentLength512  // nolint:revive
+	FragmentLength1024 FragmentLength = extension.FragmentLength1024 // nolint:revive
+	FragmentLength2048 FragmentLength = extension.FragmentLength2048 // nolint:revive
+	FragmentLength4096 FragmentLength = extension.FragmentLength4096 // nolint:revive
+)
+
+// outgoingRecordLimit returns the largest plaintext we may put in a record
+// sent to the peer, or 0 if there is no limit.
+func (s *State) outgoingRecordLimit() int {
+	if limit := s.maxFragmentLength.Size(); limit != 0 {
+		return limit
+	}
+	return int(s.remoteRecordSizeLimit)
+}
+
+// incomingRecordLimit returns the largest plaintext the peer may put in a
+// protected record, or 0 if there is no limit.
+func (s *State) incomingRecordLimit() int {
+	if limit := s.maxFragmentLength.Size(); limit != 0 {
+		return limit
+	}
+	return int(s.localRecordSizeLimit)
+}
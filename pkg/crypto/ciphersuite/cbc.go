@@ -29,6 +29,7 @@ type CBC struct {
 	writeCBC, readCBC cbcMode
 	writeMac, readMac []byte
 	h                 prf.HashFunc
+	encryptThenMAC    bool
 }
 
 // NewCBC creates a DTLS CBC Cipher
@@ -63,8 +64,23 @@ func NewCBC(localKey, localWriteIV, localMac, remoteKey, remoteWriteIV, remoteMa
 	}, nil
 }
 
+// NewCBCEncryptThenMAC creates a DTLS CBC Cipher which MACs the encrypted
+// records, as negotiated by the encrypt_then_mac extension
+// https://datatracker.ietf.org/doc/html/rfc7366
+func NewCBCEncryptThenMAC(localKey, localWriteIV, localMac, remoteKey, remoteWriteIV, remoteMac []byte, h prf.HashFunc) (*CBC, error) {
+	c, err := NewCBC(localKey, localWriteIV, localMac, remoteKey, remoteWriteIV, remoteMac, h)
+	if err != nil {
+		return nil, err
+	}
+	c.encryptThenMAC = true
+	return c, nil
+}
+
 // Encrypt encrypt a DTLS RecordLayer message
 func (c *CBC) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
+	if c.encryptThenMAC {
+		return c.encryptThenMACEncrypt(pkt, raw)
+	}
 	payload := raw[pkt.Header.Size():]
 	raw = raw[:pkt.Header.Size()]
 	blockSize := c.writeCBC.BlockSize()
@@ -126,6 +142,8 @@ func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 	case h.ContentType == protocol.ContentTypeChangeCipherSpec:
 		// Nothing to encrypt with ChangeCipherSpec
 		return in, nil
+	case c.encryptThenMAC:
+		return c.encryptThenMACDecrypt(h, in)
 	case len(body)%blockSize != 0 || len(body) < blockSize+util.Max(mac.Size()+1, blockSize):
 		return nil, errNotEnoughRoomForNonce
 	}
@@ -167,6 +185,78 @@ func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 	return append(in[:h.Size()], body[:dataEnd]...), nil
 }
 
+// encryptThenMACEncrypt encrypts the padded payload and appends the MAC over
+// the IV and the ciphertext, RFC 7366 Section 3
+func (c *CBC) encryptThenMACEncrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
+	payload := raw[pkt.Header.Size():]
+	raw = raw[:pkt.Header.Size()]
+	blockSize := c.writeCBC.BlockSize()
+
+	padding := make([]byte, blockSize-len(payload)%blockSize)
+	paddingLen := len(padding)
+	for i := 0; i < paddingLen; i++ {
+		padding[i] = byte(paddingLen - 1)
+	}
+	payload = append(payload, padding...)
+
+	iv := make([]byte, blockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	c.writeCBC.SetIV(iv)
+	c.writeCBC.CryptBlocks(payload, payload)
+	payload = append(iv, payload...)
+
+	mac, err := c.recordMAC(&pkt.Header, payload, c.writeMac)
+	if err != nil {
+		return nil, err
+	}
+	raw = append(append(raw, payload...), mac...)
+
+	// Update recordLayer size to include IV+Padding+MAC
+	binary.BigEndian.PutUint16(raw[pkt.Header.Size()-2:], uint16(len(raw)-pkt.Header.Size()))
+
+	return raw, nil
+}
+
+// encryptThenMACDecrypt verifies the MAC before decrypting the record, RFC
+// 7366 Section 3
+func (c *CBC) encryptThenMACDecrypt(h recordlayer.Header, in []byte) ([]byte, error) {
+	blockSize := c.readCBC.BlockSize()
+	macSize := c.h().Size()
+	body := in[h.Size():]
+	if len(body) < 2*blockSize+macSize || (len(body)-macSize)%blockSize != 0 {
+		return nil, errNotEnoughRoomForNonce
+	}
+
+	ciphertext := body[:len(body)-macSize]
+	actualMAC, err := c.recordMAC(&h, ciphertext, c.readMac)
+	if err != nil || !hmac.Equal(actualMAC, body[len(body)-macSize:]) {
+		return nil, errInvalidMAC
+	}
+
+	c.readCBC.SetIV(ciphertext[:blockSize])
+	plaintext := ciphertext[blockSize:]
+	c.readCBC.CryptBlocks(plaintext, plaintext)
+
+	// The record is authentic, its padding may be checked in variable time
+	paddingLen, paddingGood := examinePadding(plaintext)
+	if paddingGood != 255 {
+		return nil, errInvalidMAC
+	}
+
+	return append(in[:h.Size()], plaintext[:len(plaintext)-paddingLen]...), nil
+}
+
+// recordMAC calculates the MAC over the record data and the fields of its
+// header, the length is the one of data
+func (c *CBC) recordMAC(h *recordlayer.Header, data, key []byte) ([]byte, error) {
+	if h.ContentType == protocol.ContentTypeConnectionID {
+		return c.hmacCID(h.Epoch, h.SequenceNumber, h.Version, data, key, c.h, h.ConnectionID)
+	}
+	return c.hmac(h.Epoch, h.SequenceNumber, h.ContentType, h.Version, data, key, c.h)
+}
+
 func (c *CBC) hmac(epoch uint16, sequenceNumber uint64, contentType protocol.ContentType, protocolVersion protocol.Version, payload []byte, key []byte, hf func() hash.Hash) ([]byte, error) {
 	h := hmac.New(hf, key)
 
@@ -190,14 +280,10 @@ func (c *CBC) hmac(epoch uint16, sequenceNumber uint64, contentType protocol.Con
 }
 
 // hmacCID calculates a MAC according to
-// https://datatracker.ietf.org/doc/html/rfc9146#section-5.1
+// https://datatracker.ietf.org/doc/html/rfc9146#section-5.1, or to
+// https://datatracker.ietf.org/doc/html/rfc9146#section-5.2 with the IV and
+// ciphertext as payload
 func (c *CBC) hmacCID(epoch uint16, sequenceNumber uint64, protocolVersion protocol.Version, payload []byte, key []byte, hf func() hash.Hash, cid []byte) ([]byte, error) {
-	// Must unmarshal inner plaintext in orde to perform MAC.
-	ip := &recordlayer.InnerPlaintext{}
-	if err := ip.Unmarshal(payload); err != nil {
-		return nil, err
-	}
-
 	h := hmac.New(hf, key)
 
 	var msg cryptobyte.Builder
@@ -212,10 +298,9 @@ func (c *CBC) hmacCID(epoch uint16, sequenceNumber uint64, protocolVersion proto
 	util.AddUint48(&msg, sequenceNumber)
 	msg.AddBytes(cid)
 	msg.AddUint16(uint16(len(payload)))
-	msg.AddBytes(ip.Content)
-	msg.AddUint8(uint8(ip.RealType))
-	msg.AddBytes(make([]byte, ip.Zeros))
 
+	// The payload, e.g. the DTLSInnerPlaintext with its content, real_type
+	// and zeros, follows the length exactly once
 	if _, err := h.Write(msg.BytesOrPanic()); err != nil {
 		return nil, err
 	}
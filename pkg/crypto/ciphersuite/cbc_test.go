@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+func TestCBCEncryptThenMAC(t *testing.T) {
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	macKey := bytes.Repeat([]byte{0x0b}, sha256.Size)
+	iv := bytes.Repeat([]byte{0xa0}, aes.BlockSize)
+	header := []byte{0x17, 0xfe, 0xfd, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}
+
+	// The padded plaintext is encrypted, the MAC covers the header fields,
+	// the length of IV and ciphertext, the IV and the ciphertext, RFC 7366
+	// Section 3
+	ciphertext := append([]byte("hello"), bytes.Repeat([]byte{0x0a}, 11)...)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	body := append(append([]byte{}, iv...), ciphertext...)
+
+	mac := hmac.New(sha256.New, macKey)
+	_, _ = mac.Write(header[3:])
+	_, _ = mac.Write(header[:3])
+	_, _ = mac.Write([]byte{0x00, byte(len(body))})
+	_, _ = mac.Write(body)
+	body = append(body, mac.Sum(nil)...)
+
+	record := append(append([]byte{}, header...), 0x00, byte(len(body)))
+	record = append(record, body...)
+
+	c, err := NewCBCEncryptThenMAC(key, iv, macKey, key, iv, macKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := c.Decrypt(recordlayer.Header{}, append([]byte{}, record...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[recordlayer.FixedHeaderSize:], []byte("hello")) {
+		t.Fatalf("Unexpected plaintext %v", decrypted[recordlayer.FixedHeaderSize:])
+	}
+
+	// Encrypt uses a random IV, its output must still decrypt
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			ContentType:    protocol.ContentTypeApplicationData,
+			Version:        protocol.Version1_2,
+			Epoch:          1,
+			SequenceNumber: 5,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("hello")},
+	}
+	raw, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := c.Encrypt(pkt, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted) != len(record) {
+		t.Fatalf("Unexpected record length %d", len(encrypted))
+	}
+	if decrypted, err = c.Decrypt(recordlayer.Header{}, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[recordlayer.FixedHeaderSize:], []byte("hello")) {
+		t.Fatalf("Unexpected plaintext %v", decrypted[recordlayer.FixedHeaderSize:])
+	}
+
+	// MAC-then-encrypt cannot read the record
+	macThenEncrypt, err := NewCBC(key, iv, macKey, key, iv, macKey, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := macThenEncrypt.Decrypt(recordlayer.Header{}, append([]byte{}, record...)); err == nil {
+		t.Fatal("Expected MAC-then-encrypt decryption to fail")
+	}
+
+	// A modified ciphertext must fail authentication before decryption
+	record[recordlayer.FixedHeaderSize+aes.BlockSize]++
+	if _, err := c.Decrypt(recordlayer.Header{}, record); !errors.Is(err, errInvalidMAC) {
+		t.Fatalf("Expected error '%v', got '%v'", errInvalidMAC, err)
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+func TestCCMEncryptDecrypt(t *testing.T) {
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	iv := []byte{0xa0, 0xa1, 0xa2, 0xa3}
+	header := []byte{0x17, 0xfe, 0xfd, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}
+	explicitNonce := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}
+
+	for _, test := range []struct {
+		name       string
+		tagLen     CCMTagLen
+		ciphertext []byte
+	}{
+		{
+			name:   "CCM",
+			tagLen: CCMTagLength,
+			ciphertext: []byte{
+				0x44, 0x98, 0x62, 0x5b, 0xc0, 0x73, 0xd5, 0x7b, 0x65, 0xa6, 0x2b, 0x87,
+				0x45, 0xed, 0x10, 0xca, 0x40, 0xdb, 0xa7, 0x0d, 0x72,
+			},
+		},
+		{
+			// The tag length is part of the CCM input, so the shortened tag is
+			// not a prefix of the 16 byte one
+			name:   "CCM_8",
+			tagLen: CCMTagLength8,
+			ciphertext: []byte{
+				0x44, 0x98, 0x62, 0x5b, 0xc0, 0xbd, 0xfd, 0xed, 0xef, 0x2c, 0xbf, 0x7c,
+				0x99,
+			},
+		},
+	} {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewCCM(test.tagLen, key, iv, key, iv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Record with nonce iv || epoch || sequence number, RFC 6655 Section 3
+			record := append(append([]byte{}, header...), 0x00, byte(len(explicitNonce)+len(test.ciphertext)))
+			record = append(append(record, explicitNonce...), test.ciphertext...)
+
+			decrypted, err := c.Decrypt(recordlayer.Header{}, append([]byte{}, record...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted[recordlayer.FixedHeaderSize:], []byte("hello")) {
+				t.Fatalf("Unexpected plaintext %v", decrypted[recordlayer.FixedHeaderSize:])
+			}
+
+			// Encrypt uses a random explicit nonce, its output must still decrypt
+			pkt := &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					ContentType:    protocol.ContentTypeApplicationData,
+					Version:        protocol.Version1_2,
+					Epoch:          1,
+					SequenceNumber: 5,
+				},
+				Content: &protocol.ApplicationData{Data: []byte("hello")},
+			}
+			raw, err := pkt.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted, err := c.Encrypt(pkt, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(encrypted) != recordlayer.FixedHeaderSize+len(explicitNonce)+len("hello")+int(test.tagLen) {
+				t.Fatalf("Unexpected record length %d", len(encrypted))
+			}
+			if decrypted, err = c.Decrypt(recordlayer.Header{}, encrypted); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(decrypted[recordlayer.FixedHeaderSize:], []byte("hello")) {
+				t.Fatalf("Unexpected plaintext %v", decrypted[recordlayer.FixedHeaderSize:])
+			}
+
+			// A modified tag must fail authentication
+			record[len(record)-1]++
+			if _, err := c.Decrypt(recordlayer.Header{}, record); err == nil {
+				t.Fatal("Expected decryption to fail with modified tag")
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const chacha20Poly1305TagLength = 16
+
+// ChaCha20Poly1305 Provides an API to Encrypt/Decrypt DTLS 1.2 Packets
+// https://datatracker.ietf.org/doc/html/rfc7905
+type ChaCha20Poly1305 struct {
+	localAEAD, remoteAEAD       cipher.AEAD
+	localWriteIV, remoteWriteIV []byte
+}
+
+// NewChaCha20Poly1305 creates a DTLS ChaCha20-Poly1305 Cipher
+func NewChaCha20Poly1305(localKey, localWriteIV, remoteKey, remoteWriteIV []byte) (*ChaCha20Poly1305, error) {
+	localAEAD, err := chacha20poly1305.New(localKey)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteAEAD, err := chacha20poly1305.New(remoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ChaCha20Poly1305{
+		localAEAD:     localAEAD,
+		localWriteIV:  localWriteIV,
+		remoteAEAD:    remoteAEAD,
+		remoteWriteIV: remoteWriteIV,
+	}, nil
+}
+
+// chacha20Poly1305Nonce builds the per-record nonce as described in RFC 7905 Section 2.
+// The 64-bit record sequence number (epoch and sequence number in DTLS) is
+// left-padded to the IV length and XORed with the write IV.
+func chacha20Poly1305Nonce(iv []byte, h *recordlayer.Header) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	binary.BigEndian.PutUint64(nonce[4:], h.SequenceNumber)
+	binary.BigEndian.PutUint16(nonce[4:], h.Epoch)
+	for i := range nonce {
+		nonce[i] ^= iv[i]
+	}
+	return nonce
+}
+
+// Encrypt encrypt a DTLS RecordLayer message
+func (c *ChaCha20Poly1305) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
+	payload := raw[pkt.Header.Size():]
+	raw = raw[:pkt.Header.Size()]
+
+	nonce := chacha20Poly1305Nonce(c.localWriteIV, &pkt.Header)
+
+	var additionalData []byte
+	if pkt.Header.ContentType == protocol.ContentTypeConnectionID {
+		additionalData = generateAEADAdditionalDataCID(&pkt.Header, len(payload))
+	} else {
+		additionalData = generateAEADAdditionalData(&pkt.Header, len(payload))
+	}
+	encryptedPayload := c.localAEAD.Seal(nil, nonce, payload, additionalData)
+	r := make([]byte, len(raw)+len(encryptedPayload))
+	copy(r, raw)
+	copy(r[len(raw):], encryptedPayload)
+
+	// Update recordLayer size to include the authentication tag
+	binary.BigEndian.PutUint16(r[pkt.Header.Size()-2:], uint16(len(r)-pkt.Header.Size()))
+	return r, nil
+}
+
+// Decrypt decrypts a DTLS RecordLayer message
+func (c *ChaCha20Poly1305) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
+	err := h.Unmarshal(in)
+	switch {
+	case err != nil:
+		return nil, err
+	case h.ContentType == protocol.ContentTypeChangeCipherSpec:
+		// Nothing to encrypt with ChangeCipherSpec
+		return in, nil
+	case len(in) < (chacha20Poly1305TagLength + h.Size()):
+		return nil, errNotEnoughRoomForNonce
+	}
+
+	nonce := chacha20Poly1305Nonce(c.remoteWriteIV, &h)
+	out := in[h.Size():]
+
+	var additionalData []byte
+	if h.ContentType == protocol.ContentTypeConnectionID {
+		additionalData = generateAEADAdditionalDataCID(&h, len(out)-chacha20Poly1305TagLength)
+	} else {
+		additionalData = generateAEADAdditionalData(&h, len(out)-chacha20Poly1305TagLength)
+	}
+	out, err = c.remoteAEAD.Open(out[:0], nonce, out, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDecryptPacket, err) //nolint:errorlint
+	}
+	return append(in[:h.Size()], out...), nil
+}
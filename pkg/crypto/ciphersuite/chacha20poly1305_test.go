@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+func TestChaCha20Poly1305EncryptDecrypt(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	iv := []byte{0x07, 0x00, 0x00, 0x00, 0x40, 0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47}
+
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			ContentType:    protocol.ContentTypeApplicationData,
+			Version:        protocol.Version1_2,
+			Epoch:          1,
+			SequenceNumber: 0x0102030405,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("hello")},
+	}
+	raw, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expected output for a nonce of iv XOR (epoch << 48 | sequence number), RFC 7905 Section 2
+	expectedCiphertext := []byte{
+		0x0c, 0x63, 0xf9, 0xe5, 0xc3, 0xf7, 0x04, 0x60, 0x2b, 0xf2, 0x60, 0x47,
+		0x7f, 0xff, 0x98, 0x6f, 0xba, 0x0b, 0x0f, 0x10, 0x31,
+	}
+
+	c, err := NewChaCha20Poly1305(key, iv, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := c.Encrypt(pkt, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encrypted[pkt.Header.Size():], expectedCiphertext) {
+		t.Fatalf("Unexpected ciphertext\nwant: %#v\ngot: %#v", expectedCiphertext, encrypted[pkt.Header.Size():])
+	}
+
+	decrypted, err := c.Decrypt(recordlayer.Header{}, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[pkt.Header.Size():], []byte("hello")) {
+		t.Fatalf("Unexpected plaintext %v", decrypted[pkt.Header.Size():])
+	}
+
+	// Tampering with the sequence number must change the nonce and fail authentication
+	encrypted[recordlayer.FixedHeaderSize-3]++
+	if _, err := c.Decrypt(recordlayer.Header{}, encrypted); err == nil {
+		t.Fatal("Expected decryption to fail with modified sequence number")
+	}
+}
@@ -6,6 +6,10 @@ package ciphersuite
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
 	"testing"
 
 	"github.com/adrian38/dtls/v2/pkg/protocol"
@@ -53,3 +57,109 @@ func TestGenerateAEADAdditionalDataCID(t *testing.T) {
 		})
 	}
 }
+
+// cidRecordVector is a record protected with the DTLSInnerPlaintext
+// "hello" || application_data of RFC 9146 Section 5, with every input of
+// the additional data resp. MAC listed field by field as in the RFC
+const (
+	cidVectorEpoch          = 1
+	cidVectorSequenceNumber = 0x0102030405
+)
+
+var (
+	cidVectorCID = []byte{0xde, 0xad, 0xbe, 0xef}
+	// ContentType tls12_cid, version, epoch, sequence_number and cid of the
+	// DTLSCiphertext, the length is added per cipher
+	cidVectorHeader = []byte{
+		0x19, 0xfe, 0xfd, 0x00, 0x01, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05,
+		0xde, 0xad, 0xbe, 0xef,
+	}
+	cidVectorInnerPlaintext = []byte{'h', 'e', 'l', 'l', 'o', 0x17}
+)
+
+// cidVectorAdditionalData returns the input of the AEAD additional data resp.
+// the MAC, RFC 9146 Section 5.1 and 5.3, for an inner plaintext of length n
+func cidVectorAdditionalData(n int) []byte {
+	return []byte{
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // seq_num_placeholder
+		0x19,       // tls12_cid
+		0x04,       // cid_length
+		0x19,       // tls12_cid
+		0xfe, 0xfd, // DTLSCiphertext.version
+		0x00, 0x01, // epoch
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, // sequence_number
+		0xde, 0xad, 0xbe, 0xef, // cid
+		byte(n >> 8), byte(n), // length_of_DTLSInnerPlaintext
+	}
+}
+
+func cidVectorRecord(body []byte) []byte {
+	record := append([]byte{}, cidVectorHeader...)
+	record = append(record, byte(len(body)>>8), byte(len(body)))
+	return append(record, body...)
+}
+
+func TestConnectionIDRecordDecrypt(t *testing.T) {
+	key := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	header := recordlayer.Header{ConnectionID: make([]byte, len(cidVectorCID))}
+
+	t.Run("GCM", func(t *testing.T) {
+		iv := []byte{0xa0, 0xa1, 0xa2, 0xa3}
+		explicitNonce := []byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x05}
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := aead.Seal(nil, append(append([]byte{}, iv...), explicitNonce...), cidVectorInnerPlaintext, cidVectorAdditionalData(len(cidVectorInnerPlaintext)))
+
+		c, err := NewGCM(key, iv, key, iv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := c.Decrypt(header, cidVectorRecord(append(explicitNonce, sealed...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted[header.Size():], cidVectorInnerPlaintext) {
+			t.Fatalf("Unexpected inner plaintext\nwant: %#v\ngot: %#v", cidVectorInnerPlaintext, decrypted[header.Size():])
+		}
+	})
+
+	t.Run("CBC", func(t *testing.T) {
+		iv := make([]byte, aes.BlockSize)
+		macKey := bytes.Repeat([]byte{0x0b}, sha256.Size)
+
+		mac := hmac.New(sha256.New, macKey)
+		_, _ = mac.Write(cidVectorAdditionalData(len(cidVectorInnerPlaintext)))
+		_, _ = mac.Write(cidVectorInnerPlaintext)
+		body := append(append([]byte{}, cidVectorInnerPlaintext...), mac.Sum(nil)...)
+		paddingLen := aes.BlockSize - len(body)%aes.BlockSize
+		body = append(body, bytes.Repeat([]byte{byte(paddingLen - 1)}, paddingLen)...)
+
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(body, body)
+
+		c, err := NewCBC(key, iv, macKey, key, iv, macKey, sha256.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := c.Decrypt(header, cidVectorRecord(append(append([]byte{}, iv...), body...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decrypted[header.Size():], cidVectorInnerPlaintext) {
+			t.Fatalf("Unexpected inner plaintext\nwant: %#v\ngot: %#v", cidVectorInnerPlaintext, decrypted[header.Size():])
+		}
+	})
+}
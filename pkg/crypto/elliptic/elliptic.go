@@ -9,7 +9,11 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 
+	// Neither the standard library nor golang.org/x/crypto implement X448,
+	// the dependency is limited to this package and its field arithmetic
+	"github.com/cloudflare/circl/dh/x448"
 	"golang.org/x/crypto/curve25519"
 )
 
@@ -58,7 +62,9 @@ type Curve uint16
 const (
 	P256   Curve = 0x0017
 	P384   Curve = 0x0018
+	P521   Curve = 0x0019
 	X25519 Curve = 0x001d
+	X448   Curve = 0x001e
 )
 
 func (c Curve) String() string {
@@ -67,8 +73,12 @@ func (c Curve) String() string {
 		return "P-256"
 	case P384:
 		return "P-384"
+	case P521:
+		return "P-521"
 	case X25519:
 		return "X25519"
+	case X448:
+		return "X448"
 	}
 	return fmt.Sprintf("%#x", uint16(c))
 }
@@ -77,17 +87,25 @@ func (c Curve) String() string {
 func Curves() map[Curve]bool {
 	return map[Curve]bool{
 		X25519: true,
+		X448:   true,
 		P256:   true,
 		P384:   true,
+		P521:   true,
 	}
 }
 
 // GenerateKeypair generates a keypair for the given Curve
 func GenerateKeypair(c Curve) (*Keypair, error) {
+	return GenerateKeypairFrom(c, rand.Reader)
+}
+
+// GenerateKeypairFrom generates a keypair for the given Curve using rand as
+// the source of entropy
+func GenerateKeypairFrom(c Curve, rand io.Reader) (*Keypair, error) {
 	switch c { //nolint:revive
 	case X25519:
 		tmp := make([]byte, 32)
-		if _, err := rand.Read(tmp); err != nil {
+		if _, err := io.ReadFull(rand, tmp); err != nil {
 			return nil, err
 		}
 
@@ -96,17 +114,27 @@ func GenerateKeypair(c Curve) (*Keypair, error) {
 
 		curve25519.ScalarBaseMult(&public, &private)
 		return &Keypair{X25519, public[:], private[:]}, nil
+	case X448:
+		var public, private x448.Key
+		if _, err := io.ReadFull(rand, private[:]); err != nil {
+			return nil, err
+		}
+
+		x448.KeyGen(&public, &private)
+		return &Keypair{X448, public[:], private[:]}, nil
 	case P256:
-		return ellipticCurveKeypair(P256, elliptic.P256(), elliptic.P256())
+		return ellipticCurveKeypair(P256, elliptic.P256(), elliptic.P256(), rand)
 	case P384:
-		return ellipticCurveKeypair(P384, elliptic.P384(), elliptic.P384())
+		return ellipticCurveKeypair(P384, elliptic.P384(), elliptic.P384(), rand)
+	case P521:
+		return ellipticCurveKeypair(P521, elliptic.P521(), elliptic.P521(), rand)
 	default:
 		return nil, errInvalidNamedCurve
 	}
 }
 
-func ellipticCurveKeypair(nc Curve, c1, c2 elliptic.Curve) (*Keypair, error) {
-	privateKey, x, y, err := elliptic.GenerateKey(c1, rand.Reader)
+func ellipticCurveKeypair(nc Curve, c1, c2 elliptic.Curve, rand io.Reader) (*Keypair, error) {
+	privateKey, x, y, err := elliptic.GenerateKey(c1, rand)
 	if err != nil {
 		return nil, err
 	}
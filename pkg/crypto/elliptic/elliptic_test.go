@@ -11,8 +11,10 @@ func TestString(t *testing.T) {
 		out string
 	}{
 		{X25519, "X25519"},
+		{X448, "X448"},
 		{P256, "P-256"},
 		{P384, "P-384"},
+		{P521, "P-521"},
 		{0, "0x0"},
 	}
 
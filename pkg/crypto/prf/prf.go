@@ -15,6 +15,7 @@ import ( //nolint:gci
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/cloudflare/circl/dh/x448"
 	"golang.org/x/crypto/curve25519"
 )
 
@@ -40,7 +41,10 @@ type EncryptionKeys struct {
 	ServerWriteIV  []byte
 }
 
-var errInvalidNamedCurve = &protocol.FatalError{Err: errors.New("invalid named curve")} //nolint:goerr113
+var (
+	errInvalidNamedCurve = &protocol.FatalError{Err: errors.New("invalid named curve")}     //nolint:goerr113
+	errInvalidX448Key    = &protocol.FatalError{Err: errors.New("invalid X448 public key")} //nolint:goerr113
+)
 
 func (e *EncryptionKeys) String() string {
 	return fmt.Sprintf(`encryptionKeys:
@@ -110,15 +114,36 @@ func PreMasterSecret(publicKey, privateKey []byte, curve elliptic.Curve) ([]byte
 	switch curve {
 	case elliptic.X25519:
 		return curve25519.X25519(privateKey, publicKey)
+	case elliptic.X448:
+		return x448PreMasterSecret(publicKey, privateKey)
 	case elliptic.P256:
 		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P256(), ellipticStdlib.P256())
 	case elliptic.P384:
 		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P384(), ellipticStdlib.P384())
+	case elliptic.P521:
+		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P521(), ellipticStdlib.P521())
 	default:
 		return nil, errInvalidNamedCurve
 	}
 }
 
+func x448PreMasterSecret(publicKey, privateKey []byte) ([]byte, error) {
+	if len(publicKey) != x448.Size || len(privateKey) != x448.Size {
+		return nil, errInvalidX448Key
+	}
+
+	var public, private, shared x448.Key
+	copy(public[:], publicKey)
+	copy(private[:], privateKey)
+
+	// Shared fails for low-order points, which would yield an all-zero secret
+	// RFC 7748 Section 6.2
+	if !x448.Shared(&shared, &private, &public) {
+		return nil, errInvalidX448Key
+	}
+	return shared[:], nil
+}
+
 func ellipticCurvePreMasterSecret(publicKey, privateKey []byte, c1, c2 ellipticStdlib.Curve) ([]byte, error) {
 	x, y := ellipticStdlib.Unmarshal(c1, publicKey)
 	if x == nil || y == nil {
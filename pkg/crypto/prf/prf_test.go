@@ -6,6 +6,8 @@ package prf
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -25,6 +27,27 @@ func TestPreMasterSecret(t *testing.T) {
 	}
 }
 
+func TestPreMasterSecretX448(t *testing.T) {
+	// RFC 7748 Section 6.2
+	privateKey, _ := hex.DecodeString("9a8f4925d1519f5775cf46b04b5800d4ee9ee8bae8bc5565d498c28dd9c9baf574a9419744897391006382a6f127ab1d9ac2d8c0a598726b")
+	publicKey, _ := hex.DecodeString("3eb7a829b0cd20f5bcfc0b599b6feccf6da4627107bdb0d4f345b43027d8b972fc3e34fb4232a13ca706dcb57aec3dae07bdc1c67bf33609")
+	expectedPreMasterSecret, _ := hex.DecodeString("07fff4181ac6cc95ec1c16a94a0f74d12da232ce40a77552281d282bb60c0b56fd2464c335543936521c24403085d59a449a5037514a879d")
+
+	preMasterSecret, err := PreMasterSecret(publicKey, privateKey, elliptic.X448)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(expectedPreMasterSecret, preMasterSecret) {
+		t.Fatalf("PremasterSecret exp: % 02x actual: % 02x", expectedPreMasterSecret, preMasterSecret)
+	}
+
+	if _, err := PreMasterSecret(publicKey[:32], privateKey, elliptic.X448); !errors.Is(err, errInvalidX448Key) {
+		t.Fatalf("Expected error for short public key, got %v", err)
+	}
+	if _, err := PreMasterSecret(make([]byte, 56), privateKey, elliptic.X448); !errors.Is(err, errInvalidX448Key) {
+		t.Fatalf("Expected error for low-order public key, got %v", err)
+	}
+}
+
 func TestMasterSecret(t *testing.T) {
 	preMasterSecret := []byte{0xdf, 0x4a, 0x29, 0x1b, 0xaa, 0x1e, 0xb7, 0xcf, 0xa6, 0x93, 0x4b, 0x29, 0xb4, 0x74, 0xba, 0xad, 0x26, 0x97, 0xe2, 0x9f, 0x1f, 0x92, 0x0d, 0xcc, 0x77, 0xc8, 0xa0, 0xa0, 0x88, 0x44, 0x76, 0x24}
 	clientRandom := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f}
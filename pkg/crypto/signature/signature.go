@@ -14,6 +14,13 @@ const (
 	RSA       Algorithm = 1
 	ECDSA     Algorithm = 3
 	Ed25519   Algorithm = 7
+
+	// RSASSA-PSS with an rsaEncryption public key, RFC 8446 Section 4.2.3.
+	// These share the hash byte with Ed25519 and carry the hash in the
+	// signature byte instead.
+	RSA_PSS_RSAE_SHA256 Algorithm = 4 //nolint:revive,stylecheck
+	RSA_PSS_RSAE_SHA384 Algorithm = 5 //nolint:revive,stylecheck
+	RSA_PSS_RSAE_SHA512 Algorithm = 6 //nolint:revive,stylecheck
 )
 
 // Algorithms returns all implemented Signature Algorithms
@@ -23,5 +30,9 @@ func Algorithms() map[Algorithm]struct{} {
 		RSA:       {},
 		ECDSA:     {},
 		Ed25519:   {},
+
+		RSA_PSS_RSAE_SHA256: {},
+		RSA_PSS_RSAE_SHA384: {},
+		RSA_PSS_RSAE_SHA512: {},
 	}
 }
@@ -8,6 +8,7 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rsa"
 	"crypto/tls"
 	"fmt"
@@ -31,6 +32,9 @@ func Algorithms() []Algorithm {
 		{hash.SHA256, signature.ECDSA},
 		{hash.SHA384, signature.ECDSA},
 		{hash.SHA512, signature.ECDSA},
+		{hash.Ed25519, signature.RSA_PSS_RSAE_SHA256},
+		{hash.Ed25519, signature.RSA_PSS_RSAE_SHA384},
+		{hash.Ed25519, signature.RSA_PSS_RSAE_SHA512},
 		{hash.SHA256, signature.RSA},
 		{hash.SHA384, signature.RSA},
 		{hash.SHA512, signature.RSA},
@@ -38,8 +42,20 @@ func Algorithms() []Algorithm {
 	}
 }
 
-// SelectSignatureScheme returns most preferred and compatible scheme.
+// SelectSignatureScheme returns most preferred and compatible scheme. For
+// ECDSA keys the scheme with the hash matching the key's curve is picked when
+// available, as some peers only accept that pairing.
 func SelectSignatureScheme(sigs []Algorithm, privateKey crypto.PrivateKey) (Algorithm, error) {
+	if p, ok := privateKey.(*ecdsa.PrivateKey); ok {
+		if h, ok := curveHash(p.Curve); ok {
+			for _, ss := range sigs {
+				if ss.Signature == signature.ECDSA && ss.Hash == h {
+					return ss, nil
+				}
+			}
+		}
+	}
+
 	for _, ss := range sigs {
 		if ss.isCompatible(privateKey) {
 			return ss, nil
@@ -48,14 +64,63 @@ func SelectSignatureScheme(sigs []Algorithm, privateKey crypto.PrivateKey) (Algo
 	return Algorithm{}, errNoAvailableSignatureSchemes
 }
 
+// curveHash returns the hash paired with an ECDSA curve by the TLS 1.3
+// ecdsa_secp*r1_sha* schemes.
+// https://tools.ietf.org/html/rfc8446#section-4.2.3
+func curveHash(curve elliptic.Curve) (hash.Algorithm, bool) {
+	switch curve {
+	case elliptic.P256():
+		return hash.SHA256, true
+	case elliptic.P384():
+		return hash.SHA384, true
+	case elliptic.P521():
+		return hash.SHA512, true
+	default:
+		return hash.None, false
+	}
+}
+
+// IsPSS reports whether the scheme is one of the rsa_pss_rsae schemes.
+func (a Algorithm) IsPSS() bool {
+	if a.Hash != hash.Ed25519 {
+		return false
+	}
+	switch a.Signature {
+	case signature.RSA_PSS_RSAE_SHA256, signature.RSA_PSS_RSAE_SHA384, signature.RSA_PSS_RSAE_SHA512:
+		return true
+	default:
+		return false
+	}
+}
+
+// DigestAlgorithm returns the hash applied to the signed content. For the
+// RSA-PSS schemes the hash is carried by the signature byte.
+func (a Algorithm) DigestAlgorithm() hash.Algorithm {
+	if !a.IsPSS() {
+		return a.Hash
+	}
+	switch a.Signature {
+	case signature.RSA_PSS_RSAE_SHA384:
+		return hash.SHA384
+	case signature.RSA_PSS_RSAE_SHA512:
+		return hash.SHA512
+	default:
+		return hash.SHA256
+	}
+}
+
 // isCompatible checks that given private key is compatible with the signature scheme.
 func (a *Algorithm) isCompatible(privateKey crypto.PrivateKey) bool {
-	switch privateKey.(type) {
+	switch p := privateKey.(type) {
 	case ed25519.PrivateKey:
 		return a.Signature == signature.Ed25519
 	case *ecdsa.PrivateKey:
 		return a.Signature == signature.ECDSA
 	case *rsa.PrivateKey:
+		if a.IsPSS() {
+			// PSS with a salt as long as the digest needs emLen >= 2*hLen+2
+			return p.Size() >= 2*a.DigestAlgorithm().CryptoHash().Size()+2
+		}
 		return a.Signature == signature.RSA
 	default:
 		return false
@@ -79,6 +144,12 @@ func ParseSignatureSchemes(sigs []tls.SignatureScheme, insecureHashes bool) ([]A
 		if _, ok := hash.Algorithms()[h]; !ok || (ok && h == hash.None) {
 			return nil, fmt.Errorf("SignatureScheme %04x: %w", ss, errInvalidHashAlgorithm)
 		}
+		switch sig {
+		case signature.RSA_PSS_RSAE_SHA256, signature.RSA_PSS_RSAE_SHA384, signature.RSA_PSS_RSAE_SHA512:
+			if h != hash.Ed25519 {
+				return nil, fmt.Errorf("SignatureScheme %04x: %w", ss, errInvalidHashAlgorithm)
+			}
+		}
 		if h.Insecure() && !insecureHashes {
 			continue
 		}
@@ -4,6 +4,10 @@
 package signaturehash
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/tls"
 	"errors"
 	"reflect"
@@ -29,6 +33,9 @@ func TestParseSignatureSchemes(t *testing.T) {
 				tls.PKCS1WithSHA384,
 				tls.PKCS1WithSHA512,
 				tls.Ed25519,
+				tls.PSSWithSHA256,
+				tls.PSSWithSHA384,
+				tls.PSSWithSHA512,
 			},
 			expected: []Algorithm{
 				{hash.SHA256, signature.ECDSA},
@@ -38,6 +45,9 @@ func TestParseSignatureSchemes(t *testing.T) {
 				{hash.SHA384, signature.RSA},
 				{hash.SHA512, signature.RSA},
 				{hash.Ed25519, signature.Ed25519},
+				{hash.Ed25519, signature.RSA_PSS_RSAE_SHA256},
+				{hash.Ed25519, signature.RSA_PSS_RSAE_SHA384},
+				{hash.Ed25519, signature.RSA_PSS_RSAE_SHA512},
 			},
 			insecureHashes: false,
 			err:            nil,
@@ -60,6 +70,15 @@ func TestParseSignatureSchemes(t *testing.T) {
 			insecureHashes: false,
 			err:            errInvalidHashAlgorithm,
 		},
+		"InvalidPSSHashAlgorithm": {
+			input: []tls.SignatureScheme{
+				tls.PSSWithSHA256, // Valid
+				0x0404,            // Invalid: PSS signature byte with SHA-256
+			},
+			expected:       nil,
+			insecureHashes: false,
+			err:            errInvalidHashAlgorithm,
+		},
 		"InsecureHashAlgorithmDenied": {
 			input: []tls.SignatureScheme{
 				tls.ECDSAWithP256AndSHA256, // Valid
@@ -105,3 +124,80 @@ func TestParseSignatureSchemes(t *testing.T) {
 		})
 	}
 }
+
+func TestSelectSignatureSchemePSS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A 1024 bit key is too short for PSS with SHA-512 and a salt as long as the digest
+	sigs := []Algorithm{
+		{hash.Ed25519, signature.RSA_PSS_RSAE_SHA512},
+		{hash.Ed25519, signature.RSA_PSS_RSAE_SHA384},
+		{hash.SHA256, signature.RSA},
+	}
+	selected, err := SelectSignatureScheme(sigs, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if selected != sigs[1] {
+		t.Fatalf("Expected %+v, got %+v", sigs[1], selected)
+	}
+	if !selected.IsPSS() || selected.DigestAlgorithm() != hash.SHA384 {
+		t.Fatalf("Expected a PSS scheme digesting with SHA-384, got %+v", selected)
+	}
+
+	if (Algorithm{hash.SHA256, signature.RSA}).IsPSS() {
+		t.Fatal("rsa_pkcs1_sha256 must not be reported as PSS")
+	}
+}
+
+func TestSelectSignatureSchemeECDSACurve(t *testing.T) {
+	for name, tt := range map[string]struct {
+		curve    elliptic.Curve
+		sigs     []Algorithm
+		expected Algorithm
+	}{
+		"P256": {
+			curve:    elliptic.P256(),
+			sigs:     Algorithms(),
+			expected: Algorithm{hash.SHA256, signature.ECDSA},
+		},
+		"P384": {
+			curve:    elliptic.P384(),
+			sigs:     Algorithms(),
+			expected: Algorithm{hash.SHA384, signature.ECDSA},
+		},
+		"P521": {
+			curve:    elliptic.P521(),
+			sigs:     Algorithms(),
+			expected: Algorithm{hash.SHA512, signature.ECDSA},
+		},
+		// Without the matching scheme the most preferred ECDSA scheme is used
+		"P384NoMatch": {
+			curve: elliptic.P384(),
+			sigs: []Algorithm{
+				{hash.SHA256, signature.RSA},
+				{hash.SHA256, signature.ECDSA},
+				{hash.SHA512, signature.ECDSA},
+			},
+			expected: Algorithm{hash.SHA256, signature.ECDSA},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			key, err := ecdsa.GenerateKey(tt.curve, rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected, err := SelectSignatureScheme(tt.sigs, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if selected != tt.expected {
+				t.Fatalf("Expected %+v, got %+v", tt.expected, selected)
+			}
+		})
+	}
+}
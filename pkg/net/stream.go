@@ -0,0 +1,119 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// streamFrameHeaderSize is the size of the length prefix of every datagram
+const streamFrameHeaderSize = 2
+
+// MaxStreamDatagramSize is the largest datagram a PacketConn returned by
+// PacketConnFromStream can send.
+const MaxStreamDatagramSize = 1<<16 - 1
+
+var errStreamDatagramTooLarge = errors.New("datagram is too large for the length prefix") //nolint:goerr113
+
+// PacketConnFromStream converts a stream oriented net.Conn, like a TCP
+// connection, into a net.PacketConn. Every datagram is written with a 2 byte
+// big-endian length prefix, so that the datagram boundaries DTLS relies on
+// survive the stream. ReadFrom returns one datagram per call, a datagram
+// larger than the buffer is truncated like a UDP socket does. A read
+// interrupted by a deadline continues where it stopped with the next call.
+func PacketConnFromStream(conn net.Conn) net.PacketConn {
+	return &streamPacketConn{conn: conn}
+}
+
+// streamPacketConn wraps a stream oriented net.Conn and implements
+// net.PacketConn
+type streamPacketConn struct {
+	conn net.Conn
+
+	writeMu sync.Mutex
+
+	// The frame being read, kept across reads interrupted by deadlines
+	readMu     sync.Mutex
+	header     [streamFrameHeaderSize]byte
+	headerRead int
+	frame      []byte
+	frameRead  int
+}
+
+// ReadFrom reads the next datagram from the underlying net.Conn and returns
+// its remote address.
+func (p *streamPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+
+	for p.headerRead < streamFrameHeaderSize {
+		n, err := p.conn.Read(p.header[p.headerRead:])
+		p.headerRead += n
+		if err != nil {
+			return 0, p.conn.RemoteAddr(), err
+		}
+		if p.headerRead == streamFrameHeaderSize {
+			p.frame = make([]byte, binary.BigEndian.Uint16(p.header[:]))
+			p.frameRead = 0
+		}
+	}
+	for p.frameRead < len(p.frame) {
+		n, err := p.conn.Read(p.frame[p.frameRead:])
+		p.frameRead += n
+		if err != nil {
+			return 0, p.conn.RemoteAddr(), err
+		}
+	}
+
+	n := copy(b, p.frame)
+	p.headerRead, p.frame = 0, nil
+	return n, p.conn.RemoteAddr(), nil
+}
+
+// WriteTo writes b with its length prefix to the underlying net.Conn.
+func (p *streamPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	if len(b) > MaxStreamDatagramSize {
+		return 0, errStreamDatagramTooLarge
+	}
+	frame := make([]byte, streamFrameHeaderSize+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[streamFrameHeaderSize:], b)
+
+	// A single Write keeps concurrent datagrams from interleaving
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := p.conn.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the underlying net.Conn.
+func (p *streamPacketConn) Close() error {
+	return p.conn.Close()
+}
+
+// LocalAddr returns the local address of the underlying net.Conn.
+func (p *streamPacketConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+// SetDeadline sets the deadline on the underlying net.Conn.
+func (p *streamPacketConn) SetDeadline(t time.Time) error {
+	return p.conn.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline on the underlying net.Conn.
+func (p *streamPacketConn) SetReadDeadline(t time.Time) error {
+	return p.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline on the underlying net.Conn.
+func (p *streamPacketConn) SetWriteDeadline(t time.Time) error {
+	return p.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketConnFromStream(t *testing.T) {
+	ca, cb := net.Pipe()
+	a, b := PacketConnFromStream(ca), PacketConnFromStream(cb)
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	go func() {
+		for _, datagram := range []string{"first", "", "second"} {
+			if _, err := a.WriteTo([]byte(datagram), nil); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 16)
+	for _, expected := range []string{"first", "", "second"} {
+		n, addr, err := b.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != expected {
+			t.Fatalf("Expected datagram '%s', got '%s'", expected, buf[:n])
+		}
+		if addr != cb.RemoteAddr() {
+			t.Fatalf("Expected address %v, got %v", cb.RemoteAddr(), addr)
+		}
+	}
+
+	if _, err := a.WriteTo(make([]byte, MaxStreamDatagramSize+1), nil); !errors.Is(err, errStreamDatagramTooLarge) {
+		t.Fatalf("Expected error '%v', got '%v'", errStreamDatagramTooLarge, err)
+	}
+}
+
+func TestPacketConnFromStreamDeadline(t *testing.T) {
+	ca, cb := net.Pipe()
+	defer func() {
+		_ = ca.Close()
+	}()
+	b := PacketConnFromStream(cb)
+	defer func() {
+		_ = b.Close()
+	}()
+
+	// The read times out in the middle of a datagram
+	written := make(chan error)
+	go func() {
+		_, err := ca.Write([]byte{0x00, 0x04, 'p', 'i'})
+		written <- err
+	}()
+	if err := b.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	var netErr net.Error
+	if _, _, err := b.ReadFrom(buf); !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Expected timeout, got '%v'", err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+
+	// The next read completes the datagram, truncated to the buffer
+	go func() {
+		_, err := ca.Write([]byte{'n', 'g'})
+		written <- err
+	}()
+	if err := b.SetReadDeadline(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	n, _, err := b.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pin" {
+		t.Fatalf("Expected truncated datagram 'pin', got '%s'", buf[:n])
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+}
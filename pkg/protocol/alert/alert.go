@@ -60,6 +60,7 @@ const (
 	ProtocolVersion        Description = 70
 	InsufficientSecurity   Description = 71
 	InternalError          Description = 80
+	InappropriateFallback  Description = 86
 	UserCanceled           Description = 90
 	NoRenegotiation        Description = 100
 	UnsupportedExtension   Description = 110
@@ -112,6 +113,8 @@ func (d Description) String() string {
 		return "InsufficientSecurity"
 	case InternalError:
 		return "InternalError"
+	case InappropriateFallback:
+		return "InappropriateFallback"
 	case UserCanceled:
 		return "UserCanceled"
 	case NoRenegotiation:
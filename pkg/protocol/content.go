@@ -14,6 +14,7 @@ const (
 	ContentTypeAlert            ContentType = 21
 	ContentTypeHandshake        ContentType = 22
 	ContentTypeApplicationData  ContentType = 23
+	ContentTypeHeartbeat        ContentType = 24
 	ContentTypeConnectionID     ContentType = 25
 )
 
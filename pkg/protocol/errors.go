@@ -10,8 +10,10 @@ import (
 )
 
 var (
-	errBufferTooSmall    = &TemporaryError{Err: errors.New("buffer is too small")} //nolint:goerr113
-	errInvalidCipherSpec = &FatalError{Err: errors.New("cipher spec invalid")}     //nolint:goerr113
+	errBufferTooSmall           = &TemporaryError{Err: errors.New("buffer is too small")}                            //nolint:goerr113
+	errInvalidCipherSpec        = &FatalError{Err: errors.New("cipher spec invalid")}                                //nolint:goerr113
+	errHeartbeatPaddingTooShort = &InternalError{Err: errors.New("heartbeat padding is too short")}                  //nolint:goerr113
+	errHeartbeatPayloadLength   = &TemporaryError{Err: errors.New("heartbeat payload length exceeds record length")} //nolint:goerr113
 )
 
 // FatalError indicates that the DTLS connection is no longer available.
@@ -36,7 +38,9 @@ type TimeoutError struct {
 	Err error
 }
 
-// HandshakeError indicates that the handshake failed.
+// HandshakeError indicates that the handshake failed. The handshake of
+// dtls.Conn returns a dtls.HandshakeError, which also carries the alert and
+// the flight of the failure and converts to this type with errors.As.
 type HandshakeError struct {
 	Err error
 }
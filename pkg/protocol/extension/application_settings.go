@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// ApplicationSettingsEntry carries the settings of one ALPN protocol
+type ApplicationSettingsEntry struct {
+	Protocol string
+	Settings []byte
+}
+
+// ApplicationSettings is the application-layer protocol settings (ALPS)
+// extension. The TLS 1.3 draft sends the settings in EncryptedExtensions,
+// DTLS 1.2 has no such message, so the client lists the settings of every
+// protocol it offered with ALPN in ClientHello and the server answers in
+// ServerHello with the settings of the protocol it selected.
+//
+//	struct {
+//	  opaque protocol<1..2^8-1>;
+//	  opaque settings<0..2^16-1>;
+//	} ApplicationSettingsEntry;
+//
+//	struct {
+//	  ApplicationSettingsEntry entries<2..2^16-1>;
+//	} ApplicationSettings;
+//
+// https://datatracker.ietf.org/doc/html/draft-vvv-tls-alps
+type ApplicationSettings struct {
+	Entries []ApplicationSettingsEntry
+}
+
+// TypeValue returns the extension TypeValue
+func (a ApplicationSettings) TypeValue() TypeValue {
+	return ApplicationSettingsTypeValue
+}
+
+// Marshal encodes the extension
+func (a *ApplicationSettings) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(a.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, e := range a.Entries {
+				e := e // Satisfy range scope lint
+				b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes([]byte(e.Protocol))
+				})
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(e.Settings)
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (a *ApplicationSettings) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != a.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData, list cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) ||
+		!extData.ReadUint16LengthPrefixed(&list) || !extData.Empty() || list.Empty() {
+		return errInvalidALPSFormat
+	}
+	for !list.Empty() {
+		var protocol, settings cryptobyte.String
+		if !list.ReadUint8LengthPrefixed(&protocol) || protocol.Empty() ||
+			!list.ReadUint16LengthPrefixed(&settings) {
+			return errInvalidALPSFormat
+		}
+		a.Entries = append(a.Entries, ApplicationSettingsEntry{
+			Protocol: string(protocol),
+			Settings: append([]byte{}, settings...),
+		})
+	}
+	return nil
+}
+
+// Lookup returns the settings of protocol and whether the extension has an
+// entry for it
+func (a *ApplicationSettings) Lookup(protocol string) ([]byte, bool) {
+	for _, e := range a.Entries {
+		if e.Protocol == protocol {
+			return e.Settings, true
+		}
+	}
+	return nil, false
+}
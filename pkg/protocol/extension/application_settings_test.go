@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplicationSettings(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw       []byte
+		extension *ApplicationSettings
+	}{
+		"ClientHello": {
+			raw: []byte{
+				0x44, 0x69, 0x00, 0x0e, 0x00, 0x0c,
+				0x02, 0x68, 0x32, 0x00, 0x02, 0x01, 0x02,
+				0x02, 0x68, 0x33, 0x00, 0x00,
+			},
+			extension: &ApplicationSettings{Entries: []ApplicationSettingsEntry{
+				{Protocol: "h2", Settings: []byte{0x01, 0x02}},
+				{Protocol: "h3", Settings: []byte{}},
+			}},
+		},
+		"ServerHello": {
+			raw: []byte{
+				0x44, 0x69, 0x00, 0x09, 0x00, 0x07,
+				0x02, 0x68, 0x32, 0x00, 0x02, 0x03, 0x04,
+			},
+			extension: &ApplicationSettings{Entries: []ApplicationSettingsEntry{
+				{Protocol: "h2", Settings: []byte{0x03, 0x04}},
+			}},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("ApplicationSettings marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			e := &ApplicationSettings{}
+			if err := e.Unmarshal(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e, tt.extension) {
+				t.Errorf("ApplicationSettings unmarshal: got %#v, want %#v", e, tt.extension)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"Empty":         {0x44, 0x69, 0x00, 0x02, 0x00, 0x00},
+		"EmptyProtocol": {0x44, 0x69, 0x00, 0x05, 0x00, 0x03, 0x00, 0x00, 0x00},
+		"TrailingData":  {0x44, 0x69, 0x00, 0x08, 0x00, 0x05, 0x02, 0x68, 0x32, 0x00, 0x00, 0x00},
+		"Truncated":     {0x44, 0x69, 0x00, 0x07, 0x00, 0x05, 0x02, 0x68, 0x32, 0x00, 0x01},
+	} {
+		if err := (&ApplicationSettings{}).Unmarshal(raw); !errors.Is(err, errInvalidALPSFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidALPSFormat, err)
+		}
+	}
+
+	settings, ok := (&ApplicationSettings{Entries: []ApplicationSettingsEntry{
+		{Protocol: "h2", Settings: []byte{0x01}},
+	}}).Lookup("h2")
+	if !ok || !reflect.DeepEqual(settings, []byte{0x01}) {
+		t.Errorf("Lookup: got %#v, %v", settings, ok)
+	}
+	if _, ok := (&ApplicationSettings{}).Lookup("h2"); ok {
+		t.Error("Lookup: found a protocol without entry")
+	}
+}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// CertificateType is the format of the credential a peer sends in its
+// Certificate message
+//
+// https://tools.ietf.org/html/rfc7250#section-3
+type CertificateType uint8
+
+// CertificateType enums
+const (
+	CertificateTypeX509         CertificateType = 0
+	CertificateTypeRawPublicKey CertificateType = 2
+)
+
+// ClientCertificateType is a TLS extension that negotiates the format of
+// the client's Certificate. The client lists the types it can send in
+// ClientHello, in order of preference, the server replies with the type it
+// selected.
+//
+//	struct {
+//	  select(ClientOrServerExtension) {
+//	    case client:
+//	      CertificateType client_certificate_types<1..2^8-1>;
+//	    case server:
+//	      CertificateType client_certificate_type;
+//	  }
+//	} ClientCertTypeExtension;
+//
+// https://tools.ietf.org/html/rfc7250#section-3
+type ClientCertificateType struct {
+	CertificateTypes []CertificateType
+
+	// Selected is set for the ServerHello form of the extension, which
+	// carries exactly one of CertificateTypes
+	Selected bool
+}
+
+// TypeValue returns the extension TypeValue
+func (c ClientCertificateType) TypeValue() TypeValue {
+	return ClientCertificateTypeTypeValue
+}
+
+// Marshal encodes the extension
+func (c *ClientCertificateType) Marshal() ([]byte, error) {
+	return marshalCertificateTypes(c.TypeValue(), c.CertificateTypes, c.Selected)
+}
+
+// Unmarshal populates the extension from encoded data
+func (c *ClientCertificateType) Unmarshal(data []byte) error {
+	var err error
+	c.CertificateTypes, c.Selected, err = unmarshalCertificateTypes(c.TypeValue(), data)
+	return err
+}
+
+// ServerCertificateType is a TLS extension that negotiates the format of
+// the server's Certificate. The client lists the types it can process in
+// ClientHello, in order of preference, the server replies with the type it
+// selected.
+//
+// https://tools.ietf.org/html/rfc7250#section-3
+type ServerCertificateType struct {
+	CertificateTypes []CertificateType
+
+	// Selected is set for the ServerHello form of the extension, which
+	// carries exactly one of CertificateTypes
+	Selected bool
+}
+
+// TypeValue returns the extension TypeValue
+func (s ServerCertificateType) TypeValue() TypeValue {
+	return ServerCertificateTypeTypeValue
+}
+
+// Marshal encodes the extension
+func (s *ServerCertificateType) Marshal() ([]byte, error) {
+	return marshalCertificateTypes(s.TypeValue(), s.CertificateTypes, s.Selected)
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *ServerCertificateType) Unmarshal(data []byte) error {
+	var err error
+	s.CertificateTypes, s.Selected, err = unmarshalCertificateTypes(s.TypeValue(), data)
+	return err
+}
+
+func marshalCertificateTypes(typeValue TypeValue, types []CertificateType, selected bool) ([]byte, error) {
+	if len(types) == 0 || len(types) > 255 || (selected && len(types) != 1) {
+		return nil, errInvalidCertificateTypeFormat
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(typeValue))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if selected {
+			b.AddUint8(uint8(types[0]))
+			return
+		}
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, t := range types {
+				b.AddUint8(uint8(t))
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+func unmarshalCertificateTypes(typeValue TypeValue, data []byte) ([]CertificateType, bool, error) {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != typeValue {
+		return nil, false, errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return nil, false, errInvalidCertificateTypeFormat
+	}
+
+	// The list of ClientHello can't be empty, a single byte is the type
+	// selected by ServerHello
+	if len(extData) == 1 {
+		return []CertificateType{CertificateType(extData[0])}, true, nil
+	}
+
+	var list cryptobyte.String
+	if !extData.ReadUint8LengthPrefixed(&list) || list.Empty() || !extData.Empty() {
+		return nil, false, errInvalidCertificateTypeFormat
+	}
+	types := make([]CertificateType, 0, len(list))
+	for _, t := range list {
+		types = append(types, CertificateType(t))
+	}
+	return types, false, nil
+}
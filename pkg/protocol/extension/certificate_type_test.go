@@ -0,0 +1,67 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCertificateType(t *testing.T) {
+	for name, tt := range map[string]struct {
+		extension Extension
+		raw       []byte
+	}{
+		"ClientHelloClient": {
+			extension: &ClientCertificateType{CertificateTypes: []CertificateType{CertificateTypeRawPublicKey, CertificateTypeX509}},
+			raw:       []byte{0x00, 0x13, 0x00, 0x03, 0x02, 0x02, 0x00},
+		},
+		"ServerHelloClient": {
+			extension: &ClientCertificateType{CertificateTypes: []CertificateType{CertificateTypeRawPublicKey}, Selected: true},
+			raw:       []byte{0x00, 0x13, 0x00, 0x01, 0x02},
+		},
+		"ClientHelloServer": {
+			extension: &ServerCertificateType{CertificateTypes: []CertificateType{CertificateTypeX509}},
+			raw:       []byte{0x00, 0x14, 0x00, 0x02, 0x01, 0x00},
+		},
+		"ServerHelloServer": {
+			extension: &ServerCertificateType{CertificateTypes: []CertificateType{CertificateTypeX509}, Selected: true},
+			raw:       []byte{0x00, 0x14, 0x00, 0x01, 0x00},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Fatalf("Marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			extensions, err := Unmarshal(append([]byte{0x00, byte(len(raw))}, raw...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(extensions) != 1 || !reflect.DeepEqual(extensions[0], tt.extension) {
+				t.Fatalf("Unmarshal: got %#v, want %#v", extensions, tt.extension)
+			}
+		})
+	}
+
+	if _, err := (&ServerCertificateType{CertificateTypes: []CertificateType{CertificateTypeRawPublicKey, CertificateTypeX509}, Selected: true}).Marshal(); !errors.Is(err, errInvalidCertificateTypeFormat) {
+		t.Errorf("Expected error %v for several selected types, got %v", errInvalidCertificateTypeFormat, err)
+	}
+	for name, raw := range map[string][]byte{
+		"Empty":     {0x00, 0x14, 0x00, 0x00},
+		"EmptyList": {0x00, 0x14, 0x00, 0x02, 0x00, 0x00},
+		"Truncated": {0x00, 0x14, 0x00, 0x03, 0x03, 0x02, 0x00},
+		"Trailing":  {0x00, 0x14, 0x00, 0x03, 0x01, 0x02, 0x00},
+	} {
+		if err := (&ServerCertificateType{}).Unmarshal(raw); !errors.Is(err, errInvalidCertificateTypeFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidCertificateTypeFormat, err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// EncryptThenMAC is an empty extension requesting that records of CBC
+// cipher suites are MACed after encryption instead of before. The client
+// offers it in ClientHello, the server confirms it in ServerHello only if
+// it selected a CBC cipher suite.
+//
+// https://datatracker.ietf.org/doc/html/rfc7366
+type EncryptThenMAC struct{}
+
+// TypeValue returns the extension TypeValue
+func (e EncryptThenMAC) TypeValue() TypeValue {
+	return EncryptThenMACTypeValue
+}
+
+// Marshal encodes the extension
+func (e *EncryptThenMAC) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(e.TypeValue()))
+	b.AddUint16(0)
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (e *EncryptThenMAC) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != e.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) || !extData.Empty() {
+		return errInvalidEncryptThenMACFormat
+	}
+	return nil
+}
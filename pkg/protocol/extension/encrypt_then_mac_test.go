@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestEncryptThenMAC(t *testing.T) {
+	rawEncryptThenMAC := []byte{0x00, 0x16, 0x00, 0x00}
+
+	raw, err := (&EncryptThenMAC{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(raw, rawEncryptThenMAC) {
+		t.Errorf("EncryptThenMAC marshal: got %#v, want %#v", raw, rawEncryptThenMAC)
+	}
+	if err := (&EncryptThenMAC{}).Unmarshal(rawEncryptThenMAC); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string][]byte{
+		"NotEmpty":  {0x00, 0x16, 0x00, 0x01, 0x00},
+		"Truncated": {0x00, 0x16, 0x00},
+	} {
+		if err := (&EncryptThenMAC{}).Unmarshal(raw); !errors.Is(err, errInvalidEncryptThenMACFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidEncryptThenMACFormat, err)
+		}
+	}
+}
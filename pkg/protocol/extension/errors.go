@@ -11,11 +11,26 @@ import (
 
 var (
 	// ErrALPNInvalidFormat is raised when the ALPN format is invalid
-	ErrALPNInvalidFormat    = &protocol.FatalError{Err: errors.New("invalid alpn format")}                             //nolint:goerr113
-	errALPNNoAppProto       = &protocol.FatalError{Err: errors.New("no application protocol")}                         //nolint:goerr113
-	errBufferTooSmall       = &protocol.TemporaryError{Err: errors.New("buffer is too small")}                         //nolint:goerr113
-	errInvalidExtensionType = &protocol.FatalError{Err: errors.New("invalid extension type")}                          //nolint:goerr113
-	errInvalidSNIFormat     = &protocol.FatalError{Err: errors.New("invalid server name format")}                      //nolint:goerr113
-	errInvalidCIDFormat     = &protocol.FatalError{Err: errors.New("invalid connection ID format")}                    //nolint:goerr113
-	errLengthMismatch       = &protocol.InternalError{Err: errors.New("data length and declared length do not match")} //nolint:goerr113
+	ErrALPNInvalidFormat              = &protocol.FatalError{Err: errors.New("invalid alpn format")}                             //nolint:goerr113
+	errALPNNoAppProto                 = &protocol.FatalError{Err: errors.New("no application protocol")}                         //nolint:goerr113
+	errBufferTooSmall                 = &protocol.TemporaryError{Err: errors.New("buffer is too small")}                         //nolint:goerr113
+	errInvalidExtensionType           = &protocol.FatalError{Err: errors.New("invalid extension type")}                          //nolint:goerr113
+	errInvalidSNIFormat               = &protocol.FatalError{Err: errors.New("invalid server name format")}                      //nolint:goerr113
+	errInvalidCIDFormat               = &protocol.FatalError{Err: errors.New("invalid connection ID format")}                    //nolint:goerr113
+	errInvalidRecordSizeLimitFormat   = &protocol.FatalError{Err: errors.New("invalid record size limit format")}                //nolint:goerr113
+	errInvalidSessionTicketFormat     = &protocol.FatalError{Err: errors.New("invalid session ticket format")}                   //nolint:goerr113
+	errInvalidHeartbeatFormat         = &protocol.FatalError{Err: errors.New("invalid heartbeat format")}                        //nolint:goerr113
+	errInvalidHeartbeatMode           = &protocol.FatalError{Err: errors.New("invalid heartbeat mode")}                          //nolint:goerr113
+	errInvalidStatusRequestFormat     = &protocol.FatalError{Err: errors.New("invalid status request format")}                   //nolint:goerr113
+	errInvalidTrustedCAKeysFormat     = &protocol.FatalError{Err: errors.New("invalid trusted CA keys format")}                  //nolint:goerr113
+	errInvalidCertificateTypeFormat   = &protocol.FatalError{Err: errors.New("invalid certificate type format")}                 //nolint:goerr113
+	errInvalidEncryptThenMACFormat    = &protocol.FatalError{Err: errors.New("invalid encrypt then mac format")}                 //nolint:goerr113
+	errInvalidPostHandshakeAuthFormat = &protocol.FatalError{Err: errors.New("invalid post handshake auth format")}              //nolint:goerr113
+	errInvalidRecordPaddingFormat     = &protocol.FatalError{Err: errors.New("invalid record padding format")}                   //nolint:goerr113
+	errInvalidALPSFormat              = &protocol.FatalError{Err: errors.New("invalid application settings format")}             //nolint:goerr113
+	errInvalidSCTFormat               = &protocol.FatalError{Err: errors.New("invalid signed certificate timestamp format")}     //nolint:goerr113
+	errInvalidMaxFragmentLengthFormat = &protocol.FatalError{Err: errors.New("invalid max fragment length format")}              //nolint:goerr113
+	errInvalidMaxFragmentLength       = &protocol.FatalError{Err: errors.New("invalid max fragment length")}                     //nolint:goerr113
+	errInvalidRenegotiationInfoFormat = &protocol.FatalError{Err: errors.New("invalid renegotiation info format")}               //nolint:goerr113
+	errLengthMismatch                 = &protocol.InternalError{Err: errors.New("data length and declared length do not match")} //nolint:goerr113
 )
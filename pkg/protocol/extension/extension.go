@@ -14,14 +14,28 @@ type TypeValue uint16
 // TypeValue constants
 const (
 	ServerNameTypeValue                   TypeValue = 0
+	MaxFragmentLengthTypeValue            TypeValue = 1
+	TrustedCAKeysTypeValue                TypeValue = 3
+	StatusRequestTypeValue                TypeValue = 5
 	SupportedEllipticCurvesTypeValue      TypeValue = 10
 	SupportedPointFormatsTypeValue        TypeValue = 11
 	SupportedSignatureAlgorithmsTypeValue TypeValue = 13
 	UseSRTPTypeValue                      TypeValue = 14
+	HeartbeatTypeValue                    TypeValue = 15
 	ALPNTypeValue                         TypeValue = 16
+	SignedCertificateTimestampTypeValue   TypeValue = 18
+	ClientCertificateTypeTypeValue        TypeValue = 19
+	ServerCertificateTypeTypeValue        TypeValue = 20
+	EncryptThenMACTypeValue               TypeValue = 22
 	UseExtendedMasterSecretTypeValue      TypeValue = 23
+	RecordSizeLimitTypeValue              TypeValue = 28
+	SessionTicketTypeValue                TypeValue = 35
+	PostHandshakeAuthTypeValue            TypeValue = 49
+	SignatureAlgorithmsCertTypeValue      TypeValue = 50
 	ConnectionIDTypeValue                 TypeValue = 54
+	ApplicationSettingsTypeValue          TypeValue = 17513
 	RenegotiationInfoTypeValue            TypeValue = 65281
+	RecordPaddingTypeValue                TypeValue = 65282
 )
 
 // Extension represents a single TLS extension
@@ -63,22 +77,50 @@ func Unmarshal(buf []byte) ([]Extension, error) {
 		switch TypeValue(binary.BigEndian.Uint16(buf[offset:])) {
 		case ServerNameTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &ServerName{})
+		case MaxFragmentLengthTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &MaxFragmentLength{})
+		case TrustedCAKeysTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &TrustedCAKeys{})
+		case StatusRequestTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &StatusRequest{})
 		case SupportedEllipticCurvesTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &SupportedEllipticCurves{})
 		case SupportedPointFormatsTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &SupportedPointFormats{})
 		case SupportedSignatureAlgorithmsTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &SupportedSignatureAlgorithms{})
+		case PostHandshakeAuthTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &PostHandshakeAuth{})
+		case SignatureAlgorithmsCertTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &SignatureAlgorithmsCert{})
 		case UseSRTPTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &UseSRTP{})
 		case ALPNTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &ALPN{})
+		case SignedCertificateTimestampTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &SignedCertificateTimestamp{})
+		case ClientCertificateTypeTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &ClientCertificateType{})
+		case ServerCertificateTypeTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &ServerCertificateType{})
 		case UseExtendedMasterSecretTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &UseExtendedMasterSecret{})
+		case RecordPaddingTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &RecordPadding{})
+		case EncryptThenMACTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &EncryptThenMAC{})
 		case RenegotiationInfoTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &RenegotiationInfo{})
+		case ApplicationSettingsTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &ApplicationSettings{})
 		case ConnectionIDTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &ConnectionID{})
+		case RecordSizeLimitTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &RecordSizeLimit{})
+		case SessionTicketTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &SessionTicket{})
+		case HeartbeatTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &Heartbeat{})
 		default:
 		}
 		if err != nil {
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// HeartbeatMode indicates whether the sender of the extension is willing
+// to receive HeartbeatRequest messages.
+type HeartbeatMode uint8
+
+// HeartbeatMode enums
+const (
+	HeartbeatModePeerAllowedToSend    HeartbeatMode = 1
+	HeartbeatModePeerNotAllowedToSend HeartbeatMode = 2
+)
+
+// Heartbeat is a TLS extension that negotiates support for the
+// Heartbeat protocol.
+//
+// https://tools.ietf.org/html/rfc6520#section-2
+type Heartbeat struct {
+	Mode HeartbeatMode
+}
+
+// TypeValue returns the extension TypeValue
+func (h Heartbeat) TypeValue() TypeValue {
+	return HeartbeatTypeValue
+}
+
+// Marshal encodes the extension
+func (h *Heartbeat) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(h.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(uint8(h.Mode))
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (h *Heartbeat) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != h.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var (
+		extData cryptobyte.String
+		mode    uint8
+	)
+	if !val.ReadUint16LengthPrefixed(&extData) ||
+		!extData.ReadUint8(&mode) ||
+		!extData.Empty() {
+		return errInvalidHeartbeatFormat
+	}
+
+	// Unknown modes must be rejected with an illegal_parameter alert
+	switch h.Mode = HeartbeatMode(mode); h.Mode {
+	case HeartbeatModePeerAllowedToSend, HeartbeatModePeerNotAllowedToSend:
+		return nil
+	default:
+		return errInvalidHeartbeatMode
+	}
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHeartbeat(t *testing.T) {
+	rawHeartbeat := []byte{0x00, 0x0f, 0x00, 0x01, 0x01}
+	parsedHeartbeat := &Heartbeat{
+		Mode: HeartbeatModePeerAllowedToSend,
+	}
+
+	raw, err := parsedHeartbeat.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(raw, rawHeartbeat) {
+		t.Errorf("heartbeat marshal: got %#v, want %#v", raw, rawHeartbeat)
+	}
+
+	roundtrip := &Heartbeat{}
+	if err := roundtrip.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(roundtrip, parsedHeartbeat) {
+		t.Errorf("heartbeat unmarshal: got %#v, want %#v", roundtrip, parsedHeartbeat)
+	}
+
+	for _, invalid := range [][]byte{
+		{0x00, 0x0f, 0x00, 0x00},
+		{0x00, 0x0f, 0x00, 0x02, 0x01, 0x00},
+		{0x00, 0x0f, 0x00, 0x01},
+	} {
+		if err := (&Heartbeat{}).Unmarshal(invalid); !errors.Is(err, errInvalidHeartbeatFormat) {
+			t.Errorf("heartbeat unmarshal %#v: expected(%v) actual(%v)", invalid, errInvalidHeartbeatFormat, err)
+		}
+	}
+
+	if err := (&Heartbeat{}).Unmarshal([]byte{0x00, 0x0f, 0x00, 0x01, 0x03}); !errors.Is(err, errInvalidHeartbeatMode) {
+		t.Errorf("heartbeat unmarshal: expected(%v) actual(%v)", errInvalidHeartbeatMode, err)
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// FragmentLength is the code of a maximum fragment length as sent in the
+// max_fragment_length extension.
+type FragmentLength uint8
+
+// FragmentLength enums
+const (
+	FragmentLength512  FragmentLength = 1
+	FragmentLength1024 FragmentLength = 2
+	FragmentLength2048 FragmentLength = 3
+	FragmentLength4096 FragmentLength = 4
+)
+
+// Size returns the maximum plaintext length in bytes for the code, or 0 if
+// the code is unknown.
+func (l FragmentLength) Size() int {
+	if l < FragmentLength512 || l > FragmentLength4096 {
+		return 0
+	}
+	return 1 << (8 + l)
+}
+
+// MaxFragmentLength is a TLS extension used by constrained clients to
+// negotiate a smaller maximum record plaintext length.
+//
+// https://tools.ietf.org/html/rfc6066#section-4
+type MaxFragmentLength struct {
+	Length FragmentLength
+}
+
+// TypeValue returns the extension TypeValue
+func (m MaxFragmentLength) TypeValue() TypeValue {
+	return MaxFragmentLengthTypeValue
+}
+
+// Marshal encodes the extension
+func (m *MaxFragmentLength) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(m.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8(uint8(m.Length))
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (m *MaxFragmentLength) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != m.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var (
+		extData cryptobyte.String
+		length  uint8
+	)
+	if !val.ReadUint16LengthPrefixed(&extData) ||
+		!extData.ReadUint8(&length) ||
+		!extData.Empty() {
+		return errInvalidMaxFragmentLengthFormat
+	}
+
+	// Unknown values must be rejected with an illegal_parameter alert
+	if m.Length = FragmentLength(length); m.Length.Size() == 0 {
+		return errInvalidMaxFragmentLength
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMaxFragmentLength(t *testing.T) {
+	rawExtension := []byte{0x00, 0x01, 0x00, 0x01, 0x01}
+	parsedExtension := &MaxFragmentLength{Length: FragmentLength512}
+
+	raw, err := parsedExtension.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(raw, rawExtension) {
+		t.Errorf("MaxFragmentLength marshal: got %#v, want %#v", raw, rawExtension)
+	}
+
+	m := &MaxFragmentLength{}
+	if err := m.Unmarshal(rawExtension); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m, parsedExtension) {
+		t.Errorf("MaxFragmentLength unmarshal: got %#v, want %#v", m, parsedExtension)
+	}
+
+	for l, size := range map[FragmentLength]int{
+		FragmentLength512:  512,
+		FragmentLength1024: 1024,
+		FragmentLength2048: 2048,
+		FragmentLength4096: 4096,
+		0:                  0,
+		5:                  0,
+	} {
+		if l.Size() != size {
+			t.Errorf("FragmentLength(%d).Size(): got %d, want %d", l, l.Size(), size)
+		}
+	}
+
+	if err := (&MaxFragmentLength{}).Unmarshal([]byte{0x00, 0x01, 0x00, 0x01, 0x05}); !errors.Is(err, errInvalidMaxFragmentLength) {
+		t.Errorf("Expected error %v, got %v", errInvalidMaxFragmentLength, err)
+	}
+	if err := (&MaxFragmentLength{}).Unmarshal([]byte{0x00, 0x01, 0x00, 0x02, 0x01, 0x01}); !errors.Is(err, errInvalidMaxFragmentLengthFormat) {
+		t.Errorf("Expected error %v, got %v", errInvalidMaxFragmentLengthFormat, err)
+	}
+}
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// PostHandshakeAuth is an empty extension a client sends in ClientHello to
+// indicate that it is willing to answer a CertificateRequest after the
+// handshake. The server does not reply with it.
+//
+// https://datatracker.ietf.org/doc/html/rfc8446#section-4.2.6
+type PostHandshakeAuth struct{}
+
+// TypeValue returns the extension TypeValue
+func (p PostHandshakeAuth) TypeValue() TypeValue {
+	return PostHandshakeAuthTypeValue
+}
+
+// Marshal encodes the extension
+func (p *PostHandshakeAuth) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(p.TypeValue()))
+	b.AddUint16(0)
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (p *PostHandshakeAuth) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != p.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) || !extData.Empty() {
+		return errInvalidPostHandshakeAuthFormat
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPostHandshakeAuth(t *testing.T) {
+	rawPostHandshakeAuth := []byte{0x00, 0x31, 0x00, 0x00}
+
+	raw, err := (&PostHandshakeAuth{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(raw, rawPostHandshakeAuth) {
+		t.Errorf("PostHandshakeAuth marshal: got %#v, want %#v", raw, rawPostHandshakeAuth)
+	}
+
+	extensions, err := Unmarshal(append([]byte{0x00, byte(len(raw))}, raw...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(extensions) != 1 || !reflect.DeepEqual(extensions[0], &PostHandshakeAuth{}) {
+		t.Fatalf("Unmarshal: got %#v, want %#v", extensions, &PostHandshakeAuth{})
+	}
+
+	for name, raw := range map[string][]byte{
+		"NotEmpty":  {0x00, 0x31, 0x00, 0x01, 0x00},
+		"Truncated": {0x00, 0x31, 0x00},
+	} {
+		if err := (&PostHandshakeAuth{}).Unmarshal(raw); !errors.Is(err, errInvalidPostHandshakeAuthFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidPostHandshakeAuthFormat, err)
+		}
+	}
+}
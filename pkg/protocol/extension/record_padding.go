@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// RecordPadding is an empty extension announcing that application_data
+// records carry their plaintext length followed by the plaintext and zero
+// padding. DTLS 1.2 records have no padding of their own, so both peers
+// must agree on the format: the client offers it in ClientHello and the
+// server confirms it in ServerHello.
+//
+//	struct {
+//	  uint16 length;
+//	  opaque content[length];
+//	  uint8 zeros[padding_length];
+//	} PaddedApplicationData;
+//
+// It uses a value of the private use range.
+type RecordPadding struct{}
+
+// TypeValue returns the extension TypeValue
+func (r RecordPadding) TypeValue() TypeValue {
+	return RecordPaddingTypeValue
+}
+
+// Marshal encodes the extension
+func (r *RecordPadding) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(r.TypeValue()))
+	b.AddUint16(0)
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (r *RecordPadding) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != r.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) || !extData.Empty() {
+		return errInvalidRecordPaddingFormat
+	}
+	return nil
+}
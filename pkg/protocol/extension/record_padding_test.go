@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecordPadding(t *testing.T) {
+	rawRecordPadding := []byte{0xff, 0x02, 0x00, 0x00}
+
+	raw, err := (&RecordPadding{}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(raw, rawRecordPadding) {
+		t.Errorf("RecordPadding marshal: got %#v, want %#v", raw, rawRecordPadding)
+	}
+	if err := (&RecordPadding{}).Unmarshal(rawRecordPadding); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, raw := range map[string][]byte{
+		"NotEmpty":  {0xff, 0x02, 0x00, 0x01, 0x00},
+		"Truncated": {0xff, 0x02, 0x00},
+	} {
+		if err := (&RecordPadding{}).Unmarshal(raw); !errors.Is(err, errInvalidRecordPaddingFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidRecordPaddingFormat, err)
+		}
+	}
+}
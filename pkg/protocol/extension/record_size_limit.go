@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// RecordSizeLimit is a TLS extension that allows an endpoint to limit the size
+// of protected records it is willing to receive.
+//
+// https://tools.ietf.org/html/rfc8449
+type RecordSizeLimit struct {
+	// Maximum plaintext size of a protected record
+	RecordSizeLimit uint16
+}
+
+// TypeValue returns the extension TypeValue
+func (r RecordSizeLimit) TypeValue() TypeValue {
+	return RecordSizeLimitTypeValue
+}
+
+// Marshal encodes the extension
+func (r *RecordSizeLimit) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(r.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint16(r.RecordSizeLimit)
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (r *RecordSizeLimit) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != r.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) ||
+		!extData.ReadUint16(&r.RecordSizeLimit) ||
+		!extData.Empty() {
+		return errInvalidRecordSizeLimitFormat
+	}
+	return nil
+}
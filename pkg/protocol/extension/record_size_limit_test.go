@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecordSizeLimit(t *testing.T) {
+	rawRecordSizeLimit := []byte{0x00, 0x1c, 0x00, 0x02, 0x01, 0x00}
+	parsedRecordSizeLimit := &RecordSizeLimit{
+		RecordSizeLimit: 256,
+	}
+
+	raw, err := parsedRecordSizeLimit.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(raw, rawRecordSizeLimit) {
+		t.Errorf("recordSizeLimit marshal: got %#v, want %#v", raw, rawRecordSizeLimit)
+	}
+
+	roundtrip := &RecordSizeLimit{}
+	if err := roundtrip.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(roundtrip, parsedRecordSizeLimit) {
+		t.Errorf("recordSizeLimit unmarshal: got %#v, want %#v", roundtrip, parsedRecordSizeLimit)
+	}
+
+	for _, invalid := range [][]byte{
+		{0x00, 0x1c, 0x00, 0x01, 0x01},
+		{0x00, 0x1c, 0x00, 0x03, 0x01, 0x00, 0x00},
+		{0x00, 0x1c, 0x00, 0x02, 0x01},
+	} {
+		if err := (&RecordSizeLimit{}).Unmarshal(invalid); !errors.Is(err, errInvalidRecordSizeLimitFormat) {
+			t.Errorf("recordSizeLimit unmarshal %#v: expected(%v) actual(%v)", invalid, errInvalidRecordSizeLimitFormat, err)
+		}
+	}
+}
@@ -7,6 +7,9 @@ import "encoding/binary"
 
 const (
 	renegotiationInfoHeaderSize = 5
+
+	// renegotiated_connection<0..255>
+	maxRenegotiatedConnectionLength = 255
 )
 
 // RenegotiationInfo allows a Client/Server to
@@ -15,6 +18,11 @@ const (
 // https://tools.ietf.org/html/rfc5746
 type RenegotiationInfo struct {
 	RenegotiatedConnection uint8
+
+	// VerifyData is the renegotiated_connection field, it is empty in the
+	// initial handshake and holds the verify_data of the Finished messages of
+	// the previous handshake when renegotiating
+	VerifyData []byte
 }
 
 // TypeValue returns the extension TypeValue
@@ -24,12 +32,18 @@ func (r RenegotiationInfo) TypeValue() TypeValue {
 
 // Marshal encodes the extension
 func (r *RenegotiationInfo) Marshal() ([]byte, error) {
-	out := make([]byte, renegotiationInfoHeaderSize)
+	if len(r.VerifyData) > maxRenegotiatedConnectionLength {
+		return nil, errInvalidRenegotiationInfoFormat
+	}
+	out := make([]byte, renegotiationInfoHeaderSize, renegotiationInfoHeaderSize+len(r.VerifyData))
 
 	binary.BigEndian.PutUint16(out, uint16(r.TypeValue()))
-	binary.BigEndian.PutUint16(out[2:], uint16(1)) // length
+	binary.BigEndian.PutUint16(out[2:], uint16(1+len(r.VerifyData))) // length
 	out[4] = r.RenegotiatedConnection
-	return out, nil
+	if len(r.VerifyData) > 0 {
+		out[4] = uint8(len(r.VerifyData))
+	}
+	return append(out, r.VerifyData...), nil
 }
 
 // Unmarshal populates the extension from encoded data
@@ -41,6 +55,13 @@ func (r *RenegotiationInfo) Unmarshal(data []byte) error {
 	}
 
 	r.RenegotiatedConnection = data[4]
+	if len(data) < renegotiationInfoHeaderSize+int(data[4]) {
+		return errBufferTooSmall
+	}
+	r.VerifyData = nil
+	if data[4] > 0 {
+		r.VerifyData = append([]byte{}, data[renegotiationInfoHeaderSize:renegotiationInfoHeaderSize+int(data[4])]...)
+	}
 
 	return nil
 }
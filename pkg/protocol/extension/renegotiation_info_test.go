@@ -3,7 +3,11 @@
 
 package extension
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
 
 func TestRenegotiationInfo(t *testing.T) {
 	extension := RenegotiationInfo{RenegotiatedConnection: 0}
@@ -23,3 +27,28 @@ func TestRenegotiationInfo(t *testing.T) {
 		t.Errorf("extensionRenegotiationInfo marshal: got %d expected %d", newExtension.RenegotiatedConnection, extension.RenegotiatedConnection)
 	}
 }
+
+func TestRenegotiationInfoVerifyData(t *testing.T) {
+	extension := RenegotiationInfo{VerifyData: []byte{0x01, 0x02, 0x03, 0x04}}
+
+	raw, err := extension.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []byte{0xff, 0x01, 0x00, 0x05, 0x04, 0x01, 0x02, 0x03, 0x04}
+	if !bytes.Equal(raw, expect) {
+		t.Fatalf("extensionRenegotiationInfo marshal: got %#v expected %#v", raw, expect)
+	}
+
+	newExtension := RenegotiationInfo{}
+	if err = newExtension.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(newExtension.VerifyData, extension.VerifyData) {
+		t.Errorf("extensionRenegotiationInfo unmarshal: got %#v expected %#v", newExtension.VerifyData, extension.VerifyData)
+	}
+
+	if err = newExtension.Unmarshal(raw[:len(raw)-1]); !errors.Is(err, errBufferTooSmall) {
+		t.Errorf("expected error %v, got %v", errBufferTooSmall, err)
+	}
+}
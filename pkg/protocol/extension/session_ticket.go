@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// SessionTicket is a TLS extension used to request and present tickets for
+// stateless session resumption. An empty ticket indicates support for the
+// mechanism without resuming a session.
+//
+// https://tools.ietf.org/html/rfc5077#section-3.2
+type SessionTicket struct {
+	Ticket []byte
+}
+
+// TypeValue returns the extension TypeValue
+func (s SessionTicket) TypeValue() TypeValue {
+	return SessionTicketTypeValue
+}
+
+// Marshal encodes the extension
+func (s *SessionTicket) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(s.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(s.Ticket)
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *SessionTicket) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errInvalidSessionTicketFormat
+	}
+	s.Ticket = make([]byte, len(extData))
+	copy(s.Ticket, extData)
+	return nil
+}
@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSessionTicket(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw       []byte
+		extension *SessionTicket
+	}{
+		"Empty": {
+			raw:       []byte{0x00, 0x23, 0x00, 0x00},
+			extension: &SessionTicket{Ticket: []byte{}},
+		},
+		"Ticket": {
+			raw:       []byte{0x00, 0x23, 0x00, 0x03, 0x01, 0x02, 0x03},
+			extension: &SessionTicket{Ticket: []byte{0x01, 0x02, 0x03}},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("SessionTicket marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			s := &SessionTicket{}
+			if err := s.Unmarshal(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s, tt.extension) {
+				t.Errorf("SessionTicket unmarshal: got %#v, want %#v", s, tt.extension)
+			}
+		})
+	}
+
+	if err := (&SessionTicket{}).Unmarshal([]byte{0x00, 0x23, 0x00, 0x05, 0x01}); !errors.Is(err, errInvalidSessionTicketFormat) {
+		t.Errorf("Expected error %v, got %v", errInvalidSessionTicketFormat, err)
+	}
+}
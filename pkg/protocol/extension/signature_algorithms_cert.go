@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+)
+
+// SignatureAlgorithmsCert lists the SignatureHash Algorithms a peer accepts
+// in the signatures of certificates, if they differ from the ones of
+// SupportedSignatureAlgorithms. It has the same format.
+//
+// https://tools.ietf.org/html/rfc8446#section-4.2.3
+type SignatureAlgorithmsCert struct {
+	SignatureHashAlgorithms []signaturehash.Algorithm
+}
+
+// TypeValue returns the extension TypeValue
+func (s SignatureAlgorithmsCert) TypeValue() TypeValue {
+	return SignatureAlgorithmsCertTypeValue
+}
+
+// Marshal encodes the extension
+func (s *SignatureAlgorithmsCert) Marshal() ([]byte, error) {
+	return marshalSignatureHashAlgorithms(s.TypeValue(), s.SignatureHashAlgorithms), nil
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *SignatureAlgorithmsCert) Unmarshal(data []byte) error {
+	algorithms, err := unmarshalSignatureHashAlgorithms(s.TypeValue(), data)
+	s.SignatureHashAlgorithms = append(s.SignatureHashAlgorithms, algorithms...)
+	return err
+}
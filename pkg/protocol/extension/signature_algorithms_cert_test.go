@@ -0,0 +1,49 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/hash"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signature"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+)
+
+func TestExtensionSignatureAlgorithmsCert(t *testing.T) {
+	rawExtensionSignatureAlgorithmsCert := []byte{
+		0x00, 0x32,
+		0x00, 0x06,
+		0x00, 0x04,
+		0x05, 0x03,
+		0x04, 0x01,
+	}
+	parsedExtensionSignatureAlgorithmsCert := &SignatureAlgorithmsCert{
+		SignatureHashAlgorithms: []signaturehash.Algorithm{
+			{Hash: hash.SHA384, Signature: signature.ECDSA},
+			{Hash: hash.SHA256, Signature: signature.RSA},
+		},
+	}
+
+	raw, err := parsedExtensionSignatureAlgorithmsCert.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(raw, rawExtensionSignatureAlgorithmsCert) {
+		t.Fatalf("extensionSignatureAlgorithmsCert marshal: got %#v, want %#v", raw, rawExtensionSignatureAlgorithmsCert)
+	}
+
+	extensions, err := Unmarshal(append([]byte{0x00, byte(len(raw))}, raw...))
+	if err != nil {
+		t.Fatal(err)
+	} else if len(extensions) != 1 || !reflect.DeepEqual(extensions[0], parsedExtensionSignatureAlgorithmsCert) {
+		t.Errorf("extensionSignatureAlgorithmsCert unmarshal: got %#v, want %#v", extensions, parsedExtensionSignatureAlgorithmsCert)
+	}
+
+	// The list of signature_algorithms has a different type
+	if err := (&SignatureAlgorithmsCert{}).Unmarshal(append([]byte{0x00, 0x0d}, raw[2:]...)); !errors.Is(err, errInvalidExtensionType) {
+		t.Errorf("Expected error %v, got %v", errInvalidExtensionType, err)
+	}
+}
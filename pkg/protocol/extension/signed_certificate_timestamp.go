@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// SignedCertificateTimestamp is a TLS extension used by clients to request
+// Signed Certificate Timestamps and by servers to deliver them. A client
+// sends the extension with no timestamps.
+//
+// https://tools.ietf.org/html/rfc6962#section-3.3.1
+type SignedCertificateTimestamp struct {
+	Timestamps [][]byte
+}
+
+// TypeValue returns the extension TypeValue
+func (s SignedCertificateTimestamp) TypeValue() TypeValue {
+	return SignedCertificateTimestampTypeValue
+}
+
+// Marshal encodes the extension
+func (s *SignedCertificateTimestamp) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(s.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if len(s.Timestamps) == 0 {
+			return
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, sct := range s.Timestamps {
+				sct := sct // Satisfy range scope lint
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(sct)
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *SignedCertificateTimestamp) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errInvalidSCTFormat
+	}
+	if extData.Empty() {
+		return nil
+	}
+
+	var list cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&list) || list.Empty() || !extData.Empty() {
+		return errInvalidSCTFormat
+	}
+	for !list.Empty() {
+		var sct cryptobyte.String
+		if !list.ReadUint16LengthPrefixed(&sct) || sct.Empty() {
+			return errInvalidSCTFormat
+		}
+		s.Timestamps = append(s.Timestamps, append([]byte{}, sct...))
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSignedCertificateTimestamp(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw       []byte
+		extension *SignedCertificateTimestamp
+	}{
+		"Request": {
+			raw:       []byte{0x00, 0x12, 0x00, 0x00},
+			extension: &SignedCertificateTimestamp{},
+		},
+		"Timestamps": {
+			raw: []byte{
+				0x00, 0x12, 0x00, 0x0b,
+				0x00, 0x09,
+				0x00, 0x02, 0x01, 0x02,
+				0x00, 0x03, 0x03, 0x04, 0x05,
+			},
+			extension: &SignedCertificateTimestamp{Timestamps: [][]byte{{0x01, 0x02}, {0x03, 0x04, 0x05}}},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("SignedCertificateTimestamp marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			s := &SignedCertificateTimestamp{}
+			if err := s.Unmarshal(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s, tt.extension) {
+				t.Errorf("SignedCertificateTimestamp unmarshal: got %#v, want %#v", s, tt.extension)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"EmptyList":      {0x00, 0x12, 0x00, 0x02, 0x00, 0x00},
+		"EmptyTimestamp": {0x00, 0x12, 0x00, 0x04, 0x00, 0x02, 0x00, 0x00},
+		"Truncated":      {0x00, 0x12, 0x00, 0x05, 0x00, 0x03, 0x00, 0x02, 0x01},
+	} {
+		if err := (&SignedCertificateTimestamp{}).Unmarshal(raw); !errors.Is(err, errInvalidSCTFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidSCTFormat, err)
+		}
+	}
+}
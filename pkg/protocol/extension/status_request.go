@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// CertificateStatusType is the type of certificate status requested in the
+// status_request extension and delivered in the CertificateStatus message
+//
+// https://tools.ietf.org/html/rfc6066#section-8
+type CertificateStatusType uint8
+
+// CertificateStatusType enums
+const (
+	CertificateStatusTypeOCSP CertificateStatusType = 1
+)
+
+// StatusRequest is a TLS extension used by clients to request a stapled
+// OCSP response. A server accepting the request replies with the extension
+// carrying no data, StatusType is zero then.
+//
+//	struct {
+//	  CertificateStatusType status_type;
+//	  select (status_type) {
+//	    case ocsp: OCSPStatusRequest;
+//	  } request;
+//	} CertificateStatusRequest;
+//
+//	struct {
+//	  ResponderID responder_id_list<0..2^16-1>;
+//	  Extensions  request_extensions;
+//	} OCSPStatusRequest;
+//
+// https://tools.ietf.org/html/rfc6066#section-8
+type StatusRequest struct {
+	StatusType CertificateStatusType
+
+	// ResponderIDList lists the DER encoded ResponderIDs of the OCSP
+	// responders trusted by the client, empty means the responders are known
+	// to the server
+	ResponderIDList [][]byte
+
+	// RequestExtensions holds the DER encoded OCSP request extensions
+	RequestExtensions []byte
+}
+
+// TypeValue returns the extension TypeValue
+func (s StatusRequest) TypeValue() TypeValue {
+	return StatusRequestTypeValue
+}
+
+// Marshal encodes the extension
+func (s *StatusRequest) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(s.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if s.StatusType == 0 {
+			return
+		}
+		b.AddUint8(uint8(s.StatusType))
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, id := range s.ResponderIDList {
+				id := id // Satisfy range scope lint
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(id)
+				})
+			}
+		})
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			b.AddBytes(s.RequestExtensions)
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *StatusRequest) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errInvalidStatusRequestFormat
+	}
+	if extData.Empty() {
+		return nil
+	}
+
+	var statusType uint8
+	if !extData.ReadUint8(&statusType) || statusType == 0 {
+		return errInvalidStatusRequestFormat
+	}
+	s.StatusType = CertificateStatusType(statusType)
+	if s.StatusType != CertificateStatusTypeOCSP {
+		// The request of an unknown status type can't be parsed
+		return nil
+	}
+
+	var list, requestExtensions cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&list) ||
+		!extData.ReadUint16LengthPrefixed(&requestExtensions) ||
+		!extData.Empty() {
+		return errInvalidStatusRequestFormat
+	}
+	for !list.Empty() {
+		var id cryptobyte.String
+		if !list.ReadUint16LengthPrefixed(&id) || id.Empty() {
+			return errInvalidStatusRequestFormat
+		}
+		s.ResponderIDList = append(s.ResponderIDList, append([]byte{}, id...))
+	}
+	if !requestExtensions.Empty() {
+		s.RequestExtensions = append([]byte{}, requestExtensions...)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestStatusRequest(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw       []byte
+		extension *StatusRequest
+	}{
+		"Request": {
+			raw:       []byte{0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00},
+			extension: &StatusRequest{StatusType: CertificateStatusTypeOCSP},
+		},
+		"Response": {
+			raw:       []byte{0x00, 0x05, 0x00, 0x00},
+			extension: &StatusRequest{},
+		},
+		"ResponderIDs": {
+			raw: []byte{
+				0x00, 0x05, 0x00, 0x0e,
+				0x01,
+				0x00, 0x07,
+				0x00, 0x02, 0x01, 0x02,
+				0x00, 0x01, 0x03,
+				0x00, 0x02, 0x04, 0x05,
+			},
+			extension: &StatusRequest{
+				StatusType:        CertificateStatusTypeOCSP,
+				ResponderIDList:   [][]byte{{0x01, 0x02}, {0x03}},
+				RequestExtensions: []byte{0x04, 0x05},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("StatusRequest marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			s := &StatusRequest{}
+			if err := s.Unmarshal(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(s, tt.extension) {
+				t.Errorf("StatusRequest unmarshal: got %#v, want %#v", s, tt.extension)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"ZeroStatusType":  {0x00, 0x05, 0x00, 0x01, 0x00},
+		"Truncated":       {0x00, 0x05, 0x00, 0x03, 0x01, 0x00, 0x00},
+		"EmptyResponder":  {0x00, 0x05, 0x00, 0x07, 0x01, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00},
+		"TrailingData":    {0x00, 0x05, 0x00, 0x06, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+		"ShortExtensions": {0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x01},
+	} {
+		if err := (&StatusRequest{}).Unmarshal(raw); !errors.Is(err, errInvalidStatusRequestFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidStatusRequestFormat, err)
+		}
+	}
+}
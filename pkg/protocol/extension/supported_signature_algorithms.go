@@ -30,38 +30,54 @@ func (s SupportedSignatureAlgorithms) TypeValue() TypeValue {
 
 // Marshal encodes the extension
 func (s *SupportedSignatureAlgorithms) Marshal() ([]byte, error) {
+	return marshalSignatureHashAlgorithms(s.TypeValue(), s.SignatureHashAlgorithms), nil
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *SupportedSignatureAlgorithms) Unmarshal(data []byte) error {
+	algorithms, err := unmarshalSignatureHashAlgorithms(s.TypeValue(), data)
+	s.SignatureHashAlgorithms = append(s.SignatureHashAlgorithms, algorithms...)
+	return err
+}
+
+// marshalSignatureHashAlgorithms encodes an extension made of a list of
+// SignatureAndHashAlgorithm, like signature_algorithms and
+// signature_algorithms_cert
+func marshalSignatureHashAlgorithms(typeValue TypeValue, algorithms []signaturehash.Algorithm) []byte {
 	out := make([]byte, supportedSignatureAlgorithmsHeaderSize)
 
-	binary.BigEndian.PutUint16(out, uint16(s.TypeValue()))
-	binary.BigEndian.PutUint16(out[2:], uint16(2+(len(s.SignatureHashAlgorithms)*2)))
-	binary.BigEndian.PutUint16(out[4:], uint16(len(s.SignatureHashAlgorithms)*2))
-	for _, v := range s.SignatureHashAlgorithms {
+	binary.BigEndian.PutUint16(out, uint16(typeValue))
+	binary.BigEndian.PutUint16(out[2:], uint16(2+(len(algorithms)*2)))
+	binary.BigEndian.PutUint16(out[4:], uint16(len(algorithms)*2))
+	for _, v := range algorithms {
 		out = append(out, []byte{0x00, 0x00}...)
 		out[len(out)-2] = byte(v.Hash)
 		out[len(out)-1] = byte(v.Signature)
 	}
 
-	return out, nil
+	return out
 }
 
-// Unmarshal populates the extension from encoded data
-func (s *SupportedSignatureAlgorithms) Unmarshal(data []byte) error {
+// unmarshalSignatureHashAlgorithms decodes the list of an extension written
+// by marshalSignatureHashAlgorithms, unknown algorithms are skipped
+func unmarshalSignatureHashAlgorithms(typeValue TypeValue, data []byte) ([]signaturehash.Algorithm, error) {
 	if len(data) <= supportedSignatureAlgorithmsHeaderSize {
-		return errBufferTooSmall
-	} else if TypeValue(binary.BigEndian.Uint16(data)) != s.TypeValue() {
-		return errInvalidExtensionType
+		return nil, errBufferTooSmall
+	} else if TypeValue(binary.BigEndian.Uint16(data)) != typeValue {
+		return nil, errInvalidExtensionType
 	}
 
 	algorithmCount := int(binary.BigEndian.Uint16(data[4:]) / 2)
 	if supportedSignatureAlgorithmsHeaderSize+(algorithmCount*2) > len(data) {
-		return errLengthMismatch
+		return nil, errLengthMismatch
 	}
+	var algorithms []signaturehash.Algorithm
 	for i := 0; i < algorithmCount; i++ {
 		supportedHashAlgorithm := hash.Algorithm(data[supportedSignatureAlgorithmsHeaderSize+(i*2)])
 		supportedSignatureAlgorithm := signature.Algorithm(data[supportedSignatureAlgorithmsHeaderSize+(i*2)+1])
 		if _, ok := hash.Algorithms()[supportedHashAlgorithm]; ok {
 			if _, ok := signature.Algorithms()[supportedSignatureAlgorithm]; ok {
-				s.SignatureHashAlgorithms = append(s.SignatureHashAlgorithms, signaturehash.Algorithm{
+				algorithms = append(algorithms, signaturehash.Algorithm{
 					Hash:      supportedHashAlgorithm,
 					Signature: supportedSignatureAlgorithm,
 				})
@@ -69,5 +85,5 @@ func (s *SupportedSignatureAlgorithms) Unmarshal(data []byte) error {
 		}
 	}
 
-	return nil
+	return algorithms, nil
 }
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"crypto/sha1" //nolint:gosec
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// TrustedAuthorityIdentifierType tells how a TrustedAuthority identifies a
+// certificate authority
+//
+// https://tools.ietf.org/html/rfc6066#section-6
+type TrustedAuthorityIdentifierType uint8
+
+// TrustedAuthorityIdentifierType enums
+const (
+	TrustedAuthorityPreAgreed    TrustedAuthorityIdentifierType = 0
+	TrustedAuthorityKeySHA1Hash  TrustedAuthorityIdentifierType = 1
+	TrustedAuthorityX509Name     TrustedAuthorityIdentifierType = 2
+	TrustedAuthorityCertSHA1Hash TrustedAuthorityIdentifierType = 3
+)
+
+// TrustedAuthority identifies a certificate authority trusted by the client
+type TrustedAuthority struct {
+	IdentifierType TrustedAuthorityIdentifierType
+
+	// Identifier holds the SHA-1 hash of the key or the certificate of the
+	// authority, or its DER encoded X.501 name. It is empty for
+	// pre-agreed authorities.
+	Identifier []byte
+}
+
+// TrustedCAKeys is a TLS extension used by clients to name the certificate
+// authorities they trust. A server that used it to select its certificate
+// chain replies with the extension carrying no data.
+//
+//	struct {
+//	  IdentifierType identifier_type;
+//	  select (identifier_type) {
+//	    case pre_agreed: struct {};
+//	    case key_sha1_hash: SHA1Hash;
+//	    case x509_name: DistinguishedName;
+//	    case cert_sha1_hash: SHA1Hash;
+//	  } identifier;
+//	} TrustedAuthority;
+//
+//	struct {
+//	  TrustedAuthority trusted_authorities_list<0..2^16-1>;
+//	} TrustedAuthorities;
+//
+// https://tools.ietf.org/html/rfc6066#section-6
+type TrustedCAKeys struct {
+	TrustedAuthorities []TrustedAuthority
+}
+
+// TypeValue returns the extension TypeValue
+func (t TrustedCAKeys) TypeValue() TypeValue {
+	return TrustedCAKeysTypeValue
+}
+
+// Marshal encodes the extension
+func (t *TrustedCAKeys) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(t.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if len(t.TrustedAuthorities) == 0 {
+			return
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, a := range t.TrustedAuthorities {
+				a := a // Satisfy range scope lint
+				b.AddUint8(uint8(a.IdentifierType))
+				switch a.IdentifierType {
+				case TrustedAuthorityPreAgreed:
+				case TrustedAuthorityX509Name:
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(a.Identifier)
+					})
+				default:
+					b.AddBytes(a.Identifier)
+				}
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (t *TrustedCAKeys) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+	var extension uint16
+	val.ReadUint16(&extension)
+	if TypeValue(extension) != t.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errInvalidTrustedCAKeysFormat
+	}
+	if extData.Empty() {
+		return nil
+	}
+
+	var list cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&list) || !extData.Empty() {
+		return errInvalidTrustedCAKeysFormat
+	}
+	for !list.Empty() {
+		var identifierType uint8
+		if !list.ReadUint8(&identifierType) {
+			return errInvalidTrustedCAKeysFormat
+		}
+		a := TrustedAuthority{IdentifierType: TrustedAuthorityIdentifierType(identifierType)}
+		var identifier cryptobyte.String
+		switch a.IdentifierType {
+		case TrustedAuthorityPreAgreed:
+		case TrustedAuthorityKeySHA1Hash, TrustedAuthorityCertSHA1Hash:
+			if !list.ReadBytes((*[]byte)(&identifier), sha1.Size) {
+				return errInvalidTrustedCAKeysFormat
+			}
+		case TrustedAuthorityX509Name:
+			if !list.ReadUint16LengthPrefixed(&identifier) || identifier.Empty() {
+				return errInvalidTrustedCAKeysFormat
+			}
+		default:
+			// The length of an unknown identifier is unknown as well
+			return errInvalidTrustedCAKeysFormat
+		}
+		if len(identifier) > 0 {
+			a.Identifier = append([]byte{}, identifier...)
+		}
+		t.TrustedAuthorities = append(t.TrustedAuthorities, a)
+	}
+	return nil
+}
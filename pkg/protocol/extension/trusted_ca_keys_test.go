@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTrustedCAKeys(t *testing.T) {
+	keyHash := bytes.Repeat([]byte{0x01}, 20)
+	for name, tt := range map[string]struct {
+		raw       []byte
+		extension *TrustedCAKeys
+	}{
+		"Response": {
+			raw:       []byte{0x00, 0x03, 0x00, 0x00},
+			extension: &TrustedCAKeys{},
+		},
+		"Authorities": {
+			raw: append(append([]byte{
+				0x00, 0x03, 0x00, 0x1e,
+				0x00, 0x1c,
+				0x00,
+				0x01,
+			}, keyHash...),
+				0x02, 0x00, 0x03, 0x30, 0x01, 0x00,
+			),
+			extension: &TrustedCAKeys{
+				TrustedAuthorities: []TrustedAuthority{
+					{IdentifierType: TrustedAuthorityPreAgreed},
+					{IdentifierType: TrustedAuthorityKeySHA1Hash, Identifier: keyHash},
+					{IdentifierType: TrustedAuthorityX509Name, Identifier: []byte{0x30, 0x01, 0x00}},
+				},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.extension.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("TrustedCAKeys marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			e := &TrustedCAKeys{}
+			if err := e.Unmarshal(tt.raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(e, tt.extension) {
+				t.Errorf("TrustedCAKeys unmarshal: got %#v, want %#v", e, tt.extension)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"Truncated":    {0x00, 0x03, 0x00, 0x05, 0x00, 0x03, 0x01, 0x02},
+		"ShortHash":    {0x00, 0x03, 0x00, 0x04, 0x00, 0x02, 0x03, 0x01},
+		"EmptyName":    {0x00, 0x03, 0x00, 0x05, 0x00, 0x03, 0x02, 0x00, 0x00},
+		"UnknownType":  {0x00, 0x03, 0x00, 0x03, 0x00, 0x01, 0x04},
+		"TrailingData": {0x00, 0x03, 0x00, 0x04, 0x00, 0x01, 0x00, 0x00},
+	} {
+		if err := (&TrustedCAKeys{}).Unmarshal(raw); !errors.Is(err, errInvalidTrustedCAKeysFormat) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidTrustedCAKeysFormat, err)
+		}
+	}
+}
@@ -20,6 +20,7 @@ const (
 	TypeClientHello        Type = 1
 	TypeServerHello        Type = 2
 	TypeHelloVerifyRequest Type = 3
+	TypeNewSessionTicket   Type = 4
 	TypeCertificate        Type = 11
 	TypeServerKeyExchange  Type = 12
 	TypeCertificateRequest Type = 13
@@ -27,6 +28,7 @@ const (
 	TypeCertificateVerify  Type = 15
 	TypeClientKeyExchange  Type = 16
 	TypeFinished           Type = 20
+	TypeCertificateStatus  Type = 22
 )
 
 // String returns the string representation of this type
@@ -40,6 +42,8 @@ func (t Type) String() string {
 		return "ServerHello"
 	case TypeHelloVerifyRequest:
 		return "HelloVerifyRequest"
+	case TypeNewSessionTicket:
+		return "NewSessionTicket"
 	case TypeCertificate:
 		return "TypeCertificate"
 	case TypeServerKeyExchange:
@@ -54,6 +58,8 @@ func (t Type) String() string {
 		return "ClientKeyExchange"
 	case TypeFinished:
 		return "Finished"
+	case TypeCertificateStatus:
+		return "CertificateStatus"
 	}
 	return ""
 }
@@ -76,6 +82,10 @@ type Handshake struct {
 	Message Message
 
 	KeyExchangeAlgorithm types.KeyExchangeAlgorithm
+
+	// RawPublicKey tells whether a Certificate carries a raw public key, see
+	// MessageCertificate
+	RawPublicKey bool
 }
 
 // ContentType returns what kind of content this message is carying
@@ -122,15 +132,19 @@ func (h *Handshake) Unmarshal(data []byte) error {
 
 	switch Type(data[0]) {
 	case TypeHelloRequest:
-		return errNotImplemented
+		h.Message = &MessageHelloRequest{}
 	case TypeClientHello:
 		h.Message = &MessageClientHello{}
 	case TypeHelloVerifyRequest:
 		h.Message = &MessageHelloVerifyRequest{}
+	case TypeNewSessionTicket:
+		h.Message = &MessageNewSessionTicket{}
 	case TypeServerHello:
 		h.Message = &MessageServerHello{}
 	case TypeCertificate:
-		h.Message = &MessageCertificate{}
+		h.Message = &MessageCertificate{RawPublicKey: h.RawPublicKey}
+	case TypeCertificateStatus:
+		h.Message = &MessageCertificateStatus{}
 	case TypeServerKeyExchange:
 		h.Message = &MessageServerKeyExchange{KeyExchangeAlgorithm: h.KeyExchangeAlgorithm}
 	case TypeCertificateRequest:
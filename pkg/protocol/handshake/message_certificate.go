@@ -13,6 +13,13 @@ import (
 // https://tools.ietf.org/html/rfc5246#section-7.4.2
 type MessageCertificate struct {
 	Certificate [][]byte
+
+	// RawPublicKey is set if the raw public key certificate type was
+	// negotiated, Certificate then holds a single DER encoded
+	// SubjectPublicKeyInfo instead of a certificate chain
+	//
+	// https://tools.ietf.org/html/rfc7250#section-3
+	RawPublicKey bool
 }
 
 // Type returns the Handshake Type
@@ -26,6 +33,15 @@ const (
 
 // Marshal encodes the Handshake
 func (m *MessageCertificate) Marshal() ([]byte, error) {
+	if m.RawPublicKey {
+		if len(m.Certificate) != 1 || len(m.Certificate[0]) == 0 {
+			return nil, errInvalidRawPublicKey
+		}
+		out := make([]byte, handshakeMessageCertificateLengthFieldSize, handshakeMessageCertificateLengthFieldSize+len(m.Certificate[0]))
+		util.PutBigEndianUint24(out, uint32(len(m.Certificate[0])))
+		return append(out, m.Certificate[0]...), nil
+	}
+
 	out := make([]byte, handshakeMessageCertificateLengthFieldSize)
 
 	for _, r := range m.Certificate {
@@ -52,6 +68,14 @@ func (m *MessageCertificate) Unmarshal(data []byte) error {
 		return errLengthMismatch
 	}
 
+	if m.RawPublicKey {
+		if len(data) == handshakeMessageCertificateLengthFieldSize {
+			return errInvalidRawPublicKey
+		}
+		m.Certificate = [][]byte{append([]byte{}, data[handshakeMessageCertificateLengthFieldSize:]...)}
+		return nil
+	}
+
 	offset := handshakeMessageCertificateLengthFieldSize
 	for offset < len(data) {
 		certificateLen := int(util.BigEndianUint24(data[offset:]))
@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"github.com/adrian38/dtls/v2/internal/util"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+)
+
+// MessageCertificateStatus is sent by the server right after its
+// Certificate to deliver the OCSP response stapled for the certificate. It
+// is only sent if the server replied to the status_request extension.
+//
+//	struct {
+//	  CertificateStatusType status_type;
+//	  select (status_type) {
+//	    case ocsp: OCSPResponse;
+//	  } response;
+//	} CertificateStatus;
+//
+//	opaque OCSPResponse<1..2^24-1>;
+//
+// https://tools.ietf.org/html/rfc6066#section-8
+type MessageCertificateStatus struct {
+	StatusType extension.CertificateStatusType
+
+	// Response is the DER encoded OCSP response
+	Response []byte
+}
+
+const certificateStatusHeaderLength = 4
+
+// Type returns the Handshake Type
+func (m MessageCertificateStatus) Type() Type {
+	return TypeCertificateStatus
+}
+
+// Marshal encodes the Handshake
+func (m *MessageCertificateStatus) Marshal() ([]byte, error) {
+	if len(m.Response) == 0 || len(m.Response) > 0xffffff {
+		return nil, errInvalidOCSPResponseLength
+	}
+
+	out := make([]byte, certificateStatusHeaderLength+len(m.Response))
+	out[0] = byte(m.StatusType)
+	util.PutBigEndianUint24(out[1:], uint32(len(m.Response)))
+	copy(out[certificateStatusHeaderLength:], m.Response)
+
+	return out, nil
+}
+
+// Unmarshal populates the message from encoded data
+func (m *MessageCertificateStatus) Unmarshal(data []byte) error {
+	if len(data) < certificateStatusHeaderLength {
+		return errBufferTooSmall
+	}
+
+	m.StatusType = extension.CertificateStatusType(data[0])
+	responseLength := int(util.BigEndianUint24(data[1:]))
+	if len(data) != certificateStatusHeaderLength+responseLength {
+		return errLengthMismatch
+	} else if responseLength == 0 {
+		return errInvalidOCSPResponseLength
+	}
+
+	m.Response = append([]byte{}, data[certificateStatusHeaderLength:]...)
+	return nil
+}
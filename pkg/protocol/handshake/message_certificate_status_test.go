@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+)
+
+func TestHandshakeMessageCertificateStatus(t *testing.T) {
+	rawCertificateStatus := []byte{0x01, 0x00, 0x00, 0x03, 0x30, 0x01, 0x02}
+	parsedCertificateStatus := &MessageCertificateStatus{
+		StatusType: extension.CertificateStatusTypeOCSP,
+		Response:   []byte{0x30, 0x01, 0x02},
+	}
+
+	c := &MessageCertificateStatus{}
+	if err := c.Unmarshal(rawCertificateStatus); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedCertificateStatus) {
+		t.Errorf("handshakeMessageCertificateStatus unmarshal: got %#v, want %#v", c, parsedCertificateStatus)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawCertificateStatus) {
+		t.Errorf("handshakeMessageCertificateStatus marshal: got %#v, want %#v", raw, rawCertificateStatus)
+	}
+
+	if err := (&MessageCertificateStatus{}).Unmarshal(rawCertificateStatus[:6]); !errors.Is(err, errLengthMismatch) {
+		t.Errorf("Truncated response: expected error %v, got %v", errLengthMismatch, err)
+	}
+	if err := (&MessageCertificateStatus{}).Unmarshal([]byte{0x01, 0x00, 0x00, 0x00}); !errors.Is(err, errInvalidOCSPResponseLength) {
+		t.Errorf("Empty response: expected error %v, got %v", errInvalidOCSPResponseLength, err)
+	}
+	if _, err := (&MessageCertificateStatus{StatusType: extension.CertificateStatusTypeOCSP}).Marshal(); !errors.Is(err, errInvalidOCSPResponseLength) {
+		t.Errorf("Marshal empty response: expected error %v, got %v", errInvalidOCSPResponseLength, err)
+	}
+}
@@ -5,6 +5,7 @@ package handshake
 
 import (
 	"crypto/x509"
+	"errors"
 	"reflect"
 	"testing"
 )
@@ -100,3 +101,50 @@ func TestEmptyHandshakeMessageCertificate(t *testing.T) {
 		t.Errorf("handshakeMessageCertificate unmarshal: got %#v, want %#v", c, expectedCertificate)
 	}
 }
+
+func TestRawPublicKeyHandshakeMessageCertificate(t *testing.T) {
+	// An Ed25519 SubjectPublicKeyInfo
+	spki := []byte{
+		0x30, 0x2a, 0x30, 0x05, 0x06, 0x03, 0x2b, 0x65, 0x70, 0x03, 0x21, 0x00,
+		0xd7, 0x5a, 0x98, 0x01, 0x82, 0xb1, 0x0a, 0xb7, 0xd5, 0x4b, 0xfe, 0xd3,
+		0xc9, 0x64, 0x07, 0x3a, 0x0e, 0xe1, 0x72, 0xf3, 0xda, 0xa6, 0x23, 0x25,
+		0xaf, 0x02, 0x1a, 0x68, 0xf7, 0x07, 0x51, 0x1a,
+	}
+	rawCertificate := append([]byte{0x00, 0x00, byte(len(spki))}, spki...)
+
+	raw, err := (&MessageCertificate{Certificate: [][]byte{spki}, RawPublicKey: true}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(raw, rawCertificate) {
+		t.Errorf("handshakeMessageCertificate marshal: got %#v, want %#v", raw, rawCertificate)
+	}
+
+	// The handshake decides how to parse the body
+	h := &Handshake{RawPublicKey: true}
+	if err = h.Unmarshal(append([]byte{
+		byte(TypeCertificate), 0x00, 0x00, byte(len(raw)), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, byte(len(raw)),
+	}, raw...)); err != nil {
+		t.Fatal(err)
+	}
+	expected := &MessageCertificate{Certificate: [][]byte{spki}, RawPublicKey: true}
+	if !reflect.DeepEqual(h.Message, expected) {
+		t.Errorf("handshakeMessageCertificate unmarshal: got %#v, want %#v", h.Message, expected)
+	}
+	if err = (&MessageCertificate{}).Unmarshal(rawCertificate); err == nil {
+		t.Error("Expected a raw public key not to parse as certificate chain")
+	}
+
+	for name, m := range map[string]*MessageCertificate{
+		"NoKey":    {RawPublicKey: true},
+		"EmptyKey": {Certificate: [][]byte{{}}, RawPublicKey: true},
+		"TwoKeys":  {Certificate: [][]byte{spki, spki}, RawPublicKey: true},
+	} {
+		if _, err := m.Marshal(); !errors.Is(err, errInvalidRawPublicKey) {
+			t.Errorf("%s: expected error %v, got %v", name, errInvalidRawPublicKey, err)
+		}
+	}
+	if err := (&MessageCertificate{RawPublicKey: true}).Unmarshal([]byte{0x00, 0x00, 0x00}); !errors.Is(err, errInvalidRawPublicKey) {
+		t.Errorf("Expected error %v, got %v", errInvalidRawPublicKey, err)
+	}
+}
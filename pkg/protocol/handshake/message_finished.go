@@ -7,6 +7,8 @@ package handshake
 // this message is the first one protected with the just
 // negotiated algorithms, keys, and secrets.  Recipients of Finished
 // messages MUST verify that the contents are correct.
+// The length of VerifyData depends on the negotiated cipher suite, it is 12
+// bytes for all cipher suites of DTLS 1.2, so it is checked by the receiver.
 //
 // https://tools.ietf.org/html/rfc5246#section-7.4.9
 type MessageFinished struct {
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+// MessageHelloRequest is sent by the server to ask the client to start
+// a renegotiation, it has no content and is not part of the handshake
+// transcript
+//
+// https://tools.ietf.org/html/rfc5246#section-7.4.1.1
+type MessageHelloRequest struct{}
+
+// Type returns the Handshake Type
+func (m MessageHelloRequest) Type() Type {
+	return TypeHelloRequest
+}
+
+// Marshal encodes the Handshake
+func (m *MessageHelloRequest) Marshal() ([]byte, error) {
+	return []byte{}, nil
+}
+
+// Unmarshal populates the message from encoded data
+func (m *MessageHelloRequest) Unmarshal([]byte) error {
+	return nil
+}
@@ -0,0 +1,28 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeMessageHelloRequest(t *testing.T) {
+	rawHelloRequest := []byte{}
+	parsedHelloRequest := &MessageHelloRequest{}
+
+	c := &MessageHelloRequest{}
+	if err := c.Unmarshal(rawHelloRequest); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedHelloRequest) {
+		t.Errorf("handshakeMessageHelloRequest unmarshal: got %#v, want %#v", c, parsedHelloRequest)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawHelloRequest) {
+		t.Errorf("handshakeMessageHelloRequest marshal: got %#v, want %#v", raw, rawHelloRequest)
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"encoding/binary"
+)
+
+// MessageNewSessionTicket is sent by the server during the handshake to
+// deliver a ticket that the client can present to resume the session
+// without the server keeping per-session state.
+//
+//	struct {
+//	  uint32 ticket_lifetime_hint;
+//	  opaque ticket<0..2^16-1>;
+//	} NewSessionTicket;
+//
+// https://tools.ietf.org/html/rfc5077#section-3.3
+type MessageNewSessionTicket struct {
+	// TicketLifetimeHint is the number of seconds the ticket should be
+	// stored by the client, zero means unspecified.
+	TicketLifetimeHint uint32
+	Ticket             []byte
+}
+
+const newSessionTicketHeaderLength = 6
+
+// Type returns the Handshake Type
+func (m MessageNewSessionTicket) Type() Type {
+	return TypeNewSessionTicket
+}
+
+// Marshal encodes the Handshake
+func (m *MessageNewSessionTicket) Marshal() ([]byte, error) {
+	if len(m.Ticket) > 0xffff {
+		return nil, errSessionTicketTooLong
+	}
+
+	out := make([]byte, newSessionTicketHeaderLength+len(m.Ticket))
+	binary.BigEndian.PutUint32(out, m.TicketLifetimeHint)
+	binary.BigEndian.PutUint16(out[4:], uint16(len(m.Ticket)))
+	copy(out[newSessionTicketHeaderLength:], m.Ticket)
+
+	return out, nil
+}
+
+// Unmarshal populates the message from encoded data
+func (m *MessageNewSessionTicket) Unmarshal(data []byte) error {
+	if len(data) < newSessionTicketHeaderLength {
+		return errBufferTooSmall
+	}
+
+	m.TicketLifetimeHint = binary.BigEndian.Uint32(data)
+	ticketLength := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) != newSessionTicketHeaderLength+ticketLength {
+		return errLengthMismatch
+	}
+
+	m.Ticket = append([]byte{}, data[newSessionTicketHeaderLength:]...)
+	return nil
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeMessageNewSessionTicket(t *testing.T) {
+	rawNewSessionTicket := []byte{
+		0x00, 0x01, 0x51, 0x80, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef,
+	}
+	parsedNewSessionTicket := &MessageNewSessionTicket{
+		TicketLifetimeHint: 86400,
+		Ticket:             []byte{0xde, 0xad, 0xbe, 0xef},
+	}
+
+	c := &MessageNewSessionTicket{}
+	if err := c.Unmarshal(rawNewSessionTicket); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedNewSessionTicket) {
+		t.Errorf("handshakeMessageNewSessionTicket unmarshal: got %#v, want %#v", c, parsedNewSessionTicket)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawNewSessionTicket) {
+		t.Errorf("handshakeMessageNewSessionTicket marshal: got %#v, want %#v", raw, rawNewSessionTicket)
+	}
+
+	if err := c.Unmarshal(rawNewSessionTicket[:8]); !errors.Is(err, errLengthMismatch) {
+		t.Errorf("Expected error %v, got %v", errLengthMismatch, err)
+	}
+	if err := c.Unmarshal(rawNewSessionTicket[:4]); !errors.Is(err, errBufferTooSmall) {
+		t.Errorf("Expected error %v, got %v", errBufferTooSmall, err)
+	}
+}
@@ -6,6 +6,7 @@ package handshake
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"io"
 	"time"
 )
 
@@ -42,10 +43,16 @@ func (r *Random) UnmarshalFixed(data [RandomLength]byte) {
 // Populate fills the handshakeRandom with random values
 // may be called multiple times
 func (r *Random) Populate() error {
+	return r.PopulateFrom(rand.Reader)
+}
+
+// PopulateFrom fills the handshakeRandom with values read from rand
+// may be called multiple times
+func (r *Random) PopulateFrom(rand io.Reader) error {
 	r.GMTUnixTime = time.Now()
 
 	tmp := make([]byte, RandomBytesLength)
-	_, err := rand.Read(tmp)
+	_, err := io.ReadFull(rand, tmp)
 	copy(r.RandomBytes[:], tmp)
 
 	return err
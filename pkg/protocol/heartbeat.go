@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package protocol
+
+import "encoding/binary"
+
+const (
+	heartbeatHeaderLength = 3
+
+	// HeartbeatMinPaddingLength is the minimum amount of random padding
+	// every HeartbeatMessage carries.
+	HeartbeatMinPaddingLength = 16
+)
+
+// HeartbeatMessageType is the type of a HeartbeatMessage
+type HeartbeatMessageType uint8
+
+// HeartbeatMessageType enums
+const (
+	HeartbeatMessageTypeRequest  HeartbeatMessageType = 1
+	HeartbeatMessageTypeResponse HeartbeatMessageType = 2
+)
+
+// Heartbeat is used to check that the peer is still alive without
+// application data.
+// https://tools.ietf.org/html/rfc6520#section-4
+type Heartbeat struct {
+	Type    HeartbeatMessageType
+	Payload []byte
+	Padding []byte
+}
+
+// ContentType returns the ContentType of this content
+func (h Heartbeat) ContentType() ContentType {
+	return ContentTypeHeartbeat
+}
+
+// Marshal encodes the Heartbeat to binary
+func (h *Heartbeat) Marshal() ([]byte, error) {
+	if len(h.Padding) < HeartbeatMinPaddingLength {
+		return nil, errHeartbeatPaddingTooShort
+	}
+	if len(h.Payload) > 0xffff {
+		return nil, errHeartbeatPayloadLength
+	}
+
+	out := make([]byte, heartbeatHeaderLength, heartbeatHeaderLength+len(h.Payload)+len(h.Padding))
+	out[0] = byte(h.Type)
+	binary.BigEndian.PutUint16(out[1:], uint16(len(h.Payload)))
+	out = append(out, h.Payload...)
+	return append(out, h.Padding...), nil
+}
+
+// Unmarshal populates the Heartbeat from binary. The declared payload length is
+// checked against the record length, so a message claiming more payload
+// than it carries is rejected instead of being over-read.
+func (h *Heartbeat) Unmarshal(data []byte) error {
+	if len(data) < heartbeatHeaderLength {
+		return errBufferTooSmall
+	}
+
+	payloadLength := int(binary.BigEndian.Uint16(data[1:]))
+	if heartbeatHeaderLength+payloadLength+HeartbeatMinPaddingLength > len(data) {
+		return errHeartbeatPayloadLength
+	}
+
+	h.Type = HeartbeatMessageType(data[0])
+	h.Payload = append([]byte{}, data[heartbeatHeaderLength:heartbeatHeaderLength+payloadLength]...)
+	h.Padding = append([]byte{}, data[heartbeatHeaderLength+payloadLength:]...)
+	return nil
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHeartbeatRoundTrip(t *testing.T) {
+	h := &Heartbeat{
+		Type:    HeartbeatMessageTypeRequest,
+		Payload: []byte{0x01, 0x02, 0x03},
+		Padding: bytes.Repeat([]byte{0xAA}, HeartbeatMinPaddingLength),
+	}
+	raw, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := append([]byte{0x01, 0x00, 0x03, 0x01, 0x02, 0x03}, bytes.Repeat([]byte{0xAA}, HeartbeatMinPaddingLength)...)
+	if !bytes.Equal(raw, expected) {
+		t.Errorf("Heartbeat marshal: got %#v, want %#v", raw, expected)
+	}
+
+	var hNew Heartbeat
+	if err := hNew.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h, &hNew) {
+		t.Errorf("Heartbeat round trip: got %#v, want %#v", hNew, h)
+	}
+}
+
+func TestHeartbeatInvalid(t *testing.T) {
+	if _, err := (&Heartbeat{Padding: make([]byte, HeartbeatMinPaddingLength-1)}).Marshal(); !errors.Is(err, errHeartbeatPaddingTooShort) {
+		t.Errorf("Marshal expected(%v) actual(%v)", errHeartbeatPaddingTooShort, err)
+	}
+
+	for name, test := range map[string]struct {
+		raw []byte
+		err error
+	}{
+		"TooShort": {
+			raw: []byte{0x01, 0x00},
+			err: errBufferTooSmall,
+		},
+		"PayloadLengthBeyondRecord": {
+			// Declares 0x4000 bytes of payload but carries only 16 bytes
+			raw: append([]byte{0x01, 0x40, 0x00}, make([]byte, 16)...),
+			err: errHeartbeatPayloadLength,
+		},
+		"MissingPadding": {
+			raw: append([]byte{0x01, 0x00, 0x02, 0x01, 0x02}, make([]byte, HeartbeatMinPaddingLength-1)...),
+			err: errHeartbeatPayloadLength,
+		},
+	} {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if err := (&Heartbeat{}).Unmarshal(test.raw); !errors.Is(err, test.err) {
+				t.Errorf("Unmarshal expected(%v) actual(%v)", test.err, err)
+			}
+		})
+	}
+}
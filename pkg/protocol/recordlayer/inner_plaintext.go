@@ -37,7 +37,8 @@ func (p *InnerPlaintext) Unmarshal(data []byte) error {
 		}
 		i--
 	}
-	if i == 0 {
+	// A payload of zeros lacks the real_type, the content may be empty
+	if i < 0 {
 		return errBufferTooSmall
 	}
 	p.RealType = protocol.ContentType(data[i])
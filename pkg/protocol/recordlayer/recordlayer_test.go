@@ -93,3 +93,40 @@ func TestRecordLayerRoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestInnerPlaintextUnmarshal(t *testing.T) {
+	for _, test := range []struct {
+		Name      string
+		Data      []byte
+		Want      *InnerPlaintext
+		WantError error
+	}{
+		{
+			Name: "Zeros",
+			Data: []byte{'h', 'i', 0x17, 0x00, 0x00},
+			Want: &InnerPlaintext{Content: []byte{'h', 'i'}, RealType: protocol.ContentTypeApplicationData, Zeros: 2},
+		},
+		{
+			Name: "EmptyContent",
+			Data: []byte{0x17},
+			Want: &InnerPlaintext{Content: []byte{}, RealType: protocol.ContentTypeApplicationData},
+		},
+		{
+			Name:      "OnlyZeros",
+			Data:      []byte{0x00, 0x00},
+			WantError: errBufferTooSmall,
+		},
+		{
+			Name:      "Empty",
+			Data:      []byte{},
+			WantError: errBufferTooSmall,
+		},
+	} {
+		p := &InnerPlaintext{}
+		if err := p.Unmarshal(test.Data); !errors.Is(err, test.WantError) {
+			t.Errorf("Unexpected Error %q: exp: %v got: %v", test.Name, test.WantError, err)
+		} else if err == nil && !reflect.DeepEqual(p, test.Want) {
+			t.Errorf("%q InnerPlaintext unmarshal: got %#v, want %#v", test.Name, p, test.Want)
+		}
+	}
+}
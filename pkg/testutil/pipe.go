@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package testutil provides an in-memory datagram transport to write
+// reproducible DTLS tests, including tests of lost, duplicated, reordered and
+// delayed datagrams.
+package testutil
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/transport/v3/deadline"
+)
+
+// inboxSize is the number of datagrams queued for an endpoint, further
+// datagrams are dropped like a full socket buffer would
+const inboxSize = 1024
+
+// Action is what happens to a datagram written to a PacketConn
+type Action int
+
+// Action enums
+const (
+	// Deliver passes the datagram to the peer
+	Deliver Action = iota
+	// Drop discards the datagram
+	Drop
+	// Duplicate delivers the datagram twice
+	Duplicate
+	// Reorder holds the datagram back until the next datagram was delivered
+	Reorder
+)
+
+// Datagram is a datagram written to a PacketConn
+type Datagram struct {
+	// Index counts the datagrams written to the PacketConn, starting at zero
+	Index int
+	Data  []byte
+}
+
+// Filter decides the fate of each datagram written to a PacketConn. Data must
+// not be modified.
+type Filter func(Datagram) Action
+
+// Addr is the address of a PacketConn
+type Addr string
+
+// Network returns the network name of the pipe
+func (a Addr) Network() string {
+	return "pipe"
+}
+
+// String returns the address of the endpoint
+func (a Addr) String() string {
+	return string(a)
+}
+
+// PacketConn is one endpoint of a Pipe, it implements net.PacketConn
+type PacketConn struct {
+	addr Addr
+	peer *PacketConn
+
+	inbox     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	readDeadline *deadline.Deadline
+
+	mu      sync.Mutex
+	filter  Filter
+	delay   time.Duration
+	written int
+	held    [][]byte
+}
+
+// Pipe returns two connected in-memory PacketConns. Each datagram written to
+// one of them is read by the other one, unless a Filter says otherwise.
+func Pipe() (*PacketConn, *PacketConn) {
+	a := newPacketConn("pipe-a")
+	b := newPacketConn("pipe-b")
+	a.peer, b.peer = b, a
+	return a, b
+}
+
+func newPacketConn(addr Addr) *PacketConn {
+	return &PacketConn{
+		addr:         addr,
+		inbox:        make(chan []byte, inboxSize),
+		closed:       make(chan struct{}),
+		readDeadline: deadline.New(),
+	}
+}
+
+// SetFilter sets the Filter applied to the datagrams written to c, nil
+// delivers all of them
+func (c *PacketConn) SetFilter(f Filter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.filter = f
+}
+
+// SetDelay sets how long the datagrams written to c take to arrive at the peer
+func (c *PacketConn) SetDelay(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delay = d
+}
+
+// ReadFrom reads the next datagram delivered to c. A datagram larger than p is
+// truncated.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.Done():
+		return 0, nil, errTimeout
+	default:
+	}
+
+	select {
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	case <-c.readDeadline.Done():
+		return 0, nil, errTimeout
+	case datagram := <-c.inbox:
+		return copy(p, datagram), c.peer.addr, nil
+	}
+}
+
+// WriteTo sends p to the peer, addr is ignored
+func (c *PacketConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	datagram := append([]byte{}, p...)
+
+	c.mu.Lock()
+	action := Deliver
+	if c.filter != nil {
+		action = c.filter(Datagram{Index: c.written, Data: datagram})
+	}
+	c.written++
+
+	var deliver [][]byte
+	switch action {
+	case Drop:
+	case Duplicate:
+		deliver = [][]byte{datagram, datagram}
+	case Reorder:
+		c.held = append(c.held, datagram)
+	default:
+		deliver = [][]byte{datagram}
+	}
+	if len(deliver) > 0 {
+		deliver = append(deliver, c.held...)
+		c.held = nil
+	}
+	delay := c.delay
+	c.mu.Unlock()
+
+	if delay > 0 {
+		time.AfterFunc(delay, func() {
+			c.peer.enqueue(deliver)
+		})
+	} else {
+		c.peer.enqueue(deliver)
+	}
+	return len(p), nil
+}
+
+// enqueue queues datagrams to be read from c
+func (c *PacketConn) enqueue(datagrams [][]byte) {
+	for _, datagram := range datagrams {
+		select {
+		case <-c.closed:
+			return
+		case c.inbox <- datagram:
+		default:
+		}
+	}
+}
+
+// Close closes c, the peer stays open
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
+
+// LocalAddr returns the address of c
+func (c *PacketConn) LocalAddr() net.Addr {
+	return c.addr
+}
+
+// RemoteAddr returns the address of the peer of c
+func (c *PacketConn) RemoteAddr() net.Addr {
+	return c.peer.addr
+}
+
+// SetDeadline sets the read deadline, writes never block
+func (c *PacketConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of ReadFrom
+func (c *PacketConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes never block
+func (c *PacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+var errTimeout = &timeoutError{} //nolint:gochecknoglobals
+
+// timeoutError is returned by ReadFrom once the read deadline passed
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "i/o timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
@@ -0,0 +1,144 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package testutil
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, c *PacketConn) []string {
+	t.Helper()
+
+	var datagrams []string
+	buf := make([]byte, 64)
+	for {
+		if err := c.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		n, addr, err := c.ReadFrom(buf)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return datagrams
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if addr.String() != "pipe-a" {
+			t.Fatalf("Unexpected source address %v", addr)
+		}
+		datagrams = append(datagrams, string(buf[:n]))
+	}
+}
+
+func TestPipe(t *testing.T) {
+	for name, tt := range map[string]struct {
+		filter   Filter
+		expected []string
+	}{
+		"Deliver": {
+			expected: []string{"0", "1", "2"},
+		},
+		"Drop": {
+			filter: func(d Datagram) Action {
+				if d.Index == 1 {
+					return Drop
+				}
+				return Deliver
+			},
+			expected: []string{"0", "2"},
+		},
+		"Duplicate": {
+			filter: func(d Datagram) Action {
+				if string(d.Data) == "0" {
+					return Duplicate
+				}
+				return Deliver
+			},
+			expected: []string{"0", "0", "1", "2"},
+		},
+		"Reorder": {
+			filter: func(d Datagram) Action {
+				if d.Index == 0 {
+					return Reorder
+				}
+				return Deliver
+			},
+			expected: []string{"1", "0", "2"},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			a, b := Pipe()
+			defer func() {
+				_ = a.Close()
+				_ = b.Close()
+			}()
+
+			a.SetFilter(tt.filter)
+			for _, datagram := range []string{"0", "1", "2"} {
+				if _, err := a.WriteTo([]byte(datagram), b.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			actual := readAll(t, b)
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("Datagram mismatch: expected(%v) actual(%v)", tt.expected, actual)
+			}
+			for i := range actual {
+				if actual[i] != tt.expected[i] {
+					t.Fatalf("Datagram mismatch: expected(%v) actual(%v)", tt.expected, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestPipeDelay(t *testing.T) {
+	a, b := Pipe()
+	defer func() {
+		_ = a.Close()
+		_ = b.Close()
+	}()
+
+	const delay = 100 * time.Millisecond
+	a.SetDelay(delay)
+
+	start := time.Now()
+	if _, err := a.WriteTo([]byte("delayed"), b.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, _, err := b.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("Datagram arrived after %v, expected at least %v", elapsed, delay)
+	}
+}
+
+func TestPipeClose(t *testing.T) {
+	a, b := Pipe()
+	defer func() {
+		_ = b.Close()
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, _, err := a.ReadFrom(make([]byte, 16))
+		readErr <- err
+	}()
+
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-readErr; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expected error '%v', got '%v'", net.ErrClosed, err)
+	}
+	if _, err := a.WriteTo([]byte("closed"), b.LocalAddr()); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Expected error '%v', got '%v'", net.ErrClosed, err)
+	}
+}
@@ -0,0 +1,336 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"crypto/x509"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/crypto/clientcertificate"
+	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+const (
+	// postHandshakeAuthLabel derives the value binding a post-handshake
+	// CertificateVerify to this connection
+	postHandshakeAuthLabel        = "EXPORTER-dtls-post-handshake-auth"
+	postHandshakeAuthBinderLength = 32
+
+	// defaultPostHandshakeAuthMaxRetransmits bounds RequestClientCertificate
+	// when MaxRetransmits is not set
+	defaultPostHandshakeAuthMaxRetransmits = 5
+)
+
+// RequestClientCertificate asks the client of an established connection for
+// its certificate and waits until the client proves possession of the key.
+// The certificate is verified according to ClientAuth, ClientCAs and
+// VerifyPeerCertificate in the Config, as it would be during the handshake,
+// and is then reported in ConnectionState.PeerCertificates.
+//
+// DTLS 1.2 has no post-handshake authentication, the CertificateRequest is
+// sent in the application data epoch and is only understood by clients using
+// this package. The CertificateVerify signs keying material exported from
+// the connection followed by the CertificateRequest and Certificate messages.
+//
+// The request is retransmitted using the same timers as handshake flights.
+// The client must have enabled Config.EnablePostHandshakeAuth, which it
+// advertises with the post_handshake_auth extension.
+// If the client has no certificate errNoClientCertificate is returned and
+// the connection stays usable. If the certificate can't be verified a fatal
+// alert is sent and the connection must not be used anymore.
+func (c *Conn) RequestClientCertificate() error {
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	if c.state.isClient {
+		return errClientCertificateRequestOnClient
+	}
+	if !c.state.remotePostHandshakeAuth {
+		return errPostHandshakeAuthNotOffered
+	}
+
+	c.postHandshakeLock.Lock()
+	defer c.postHandshakeLock.Unlock()
+
+	// Drop messages left over from an abandoned request
+	for len(c.postHandshakeMessages) > 0 {
+		<-c.postHandshakeMessages
+	}
+
+	cfg := c.fsm.cfg
+	var certificateAuthorities [][]byte
+	if cfg.clientCAs != nil {
+		// nolint:staticcheck // ignoring tlsCert.RootCAs.Subjects is deprecated ERR because cert does not come from SystemCertPool and it's ok if certificate authorities is empty.
+		certificateAuthorities = cfg.clientCAs.Subjects()
+	}
+	request := &handshake.Handshake{
+		Header: handshake.Header{
+			MessageSequence: cfg.nextHandshakeSendSequence(&c.state),
+		},
+		Message: &handshake.MessageCertificateRequest{
+			CertificateTypes:            []clientcertificate.Type{clientcertificate.RSASign, clientcertificate.ECDSASign},
+			SignatureHashAlgorithms:     cfg.localSignatureSchemes,
+			CertificateAuthoritiesNames: certificateAuthorities,
+		},
+	}
+	rawRequest, err := request.Marshal()
+	if err != nil {
+		return err
+	}
+
+	maxRetransmits := cfg.maxRetransmits
+	if maxRetransmits <= 0 {
+		maxRetransmits = defaultPostHandshakeAuthMaxRetransmits
+	}
+
+	var (
+		certificate    *handshake.MessageCertificate
+		rawCertificate []byte
+	)
+	for attempt := 0; ; attempt++ {
+		if err := c.writePackets(c.writeDeadline, []*packet{c.postHandshakePacket(request)}); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(cfg.retransmitDelay(attempt))
+	wait:
+		for {
+			select {
+			case raw := <-c.postHandshakeMessages:
+				h := &handshake.Handshake{}
+				if err := h.Unmarshal(raw); err != nil {
+					c.log.Debugf("discarded broken post-handshake message: %v", err)
+					continue
+				}
+				switch m := h.Message.(type) {
+				case *handshake.MessageCertificate:
+					if certificate != nil {
+						continue
+					}
+					if len(m.Certificate) == 0 {
+						timer.Stop()
+						return errNoClientCertificate
+					}
+					certificate, rawCertificate = m, raw
+				case *handshake.MessageCertificateVerify:
+					timer.Stop()
+					if certificate == nil {
+						_ = c.notify(context.Background(), alert.Fatal, alert.UnexpectedMessage)
+						return errCertificateVerifyNoCertificate
+					}
+					if a, err := c.verifyPostHandshakeCertificate(certificate, m, append(rawRequest, rawCertificate...)); err != nil {
+						_ = c.notify(context.Background(), a.Level, a.Description)
+						return err
+					}
+					return nil
+				default:
+				}
+			case <-timer.C:
+				break wait
+			case <-c.writeDeadline.Done():
+				timer.Stop()
+				return errDeadlineExceeded
+			case <-c.closed.Done():
+				timer.Stop()
+				return ErrConnClosed
+			}
+		}
+
+		if attempt >= maxRetransmits {
+			return errPostHandshakeAuthTimeout
+		}
+	}
+}
+
+func (c *Conn) verifyPostHandshakeCertificate(certificate *handshake.MessageCertificate, certificateVerify *handshake.MessageCertificateVerify, messages []byte) (*alert.Alert, error) {
+	cfg := c.fsm.cfg
+
+	// Verify that the pair of hash algorithm and signiture is listed.
+	var validSignatureScheme bool
+	for _, ss := range cfg.localSignatureSchemes {
+		if ss.Hash == certificateVerify.HashAlgorithm && ss.Signature == certificateVerify.SignatureAlgorithm {
+			validSignatureScheme = true
+			break
+		}
+	}
+	if !validSignatureScheme {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoAvailableSignatureSchemes
+	}
+
+	plainText, err := c.postHandshakeAuthTranscript(messages)
+	if err != nil {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	}
+	if err := verifyCertificateVerify(plainText, signaturehash.Algorithm{Hash: certificateVerify.HashAlgorithm, Signature: certificateVerify.SignatureAlgorithm}, certificateVerify.Signature, certificate.Certificate, false); err != nil {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+	}
+	if cfg.requireSCT {
+		if err := verifySCTs(certificate.Certificate, nil); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		}
+	}
+	if len(cfg.localCertSignatureSchemes) > 0 {
+		if err := verifyCertificateSignatureSchemes(certificate.Certificate, cfg.localCertSignatureSchemes); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		}
+	}
+	var chains [][]*x509.Certificate
+	var verified bool
+	if cfg.clientAuth >= VerifyClientCertIfGiven {
+		if chains, err = verifyClientCert(certificate.Certificate, cfg.clientCAs, cfg.intermediates, cfg.now()); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		}
+		verified = true
+	}
+	if cfg.verifyPeerCertificate != nil {
+		if err := cfg.verifyPeerCertificate(c.writeDeadline, certificate.Certificate, chains); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		}
+	}
+
+	c.lock.Lock()
+	c.state.PeerCertificates = certificate.Certificate
+	c.state.peerCertificatesVerified = verified
+	c.lock.Unlock()
+	return nil, nil
+}
+
+// handlePostHandshakeMessage processes a handshake message received after the
+// handshake completed. A client answers a CertificateRequest, a server hands
+// the answer to a pending RequestClientCertificate.
+func (c *Conn) handlePostHandshakeMessage(raw []byte, epoch uint16, header *handshake.Header) {
+	if epoch == 0 {
+		c.log.Debugf("discarded unprotected post-handshake %s", header.Type)
+		return
+	}
+
+	switch {
+	case c.state.isClient && c.fsm.cfg.postHandshakeAuth && header.Type == handshake.TypeCertificateRequest:
+		h := &handshake.Handshake{}
+		if err := h.Unmarshal(raw); err != nil {
+			c.log.Debugf("discarded broken post-handshake message: %v", err)
+			return
+		}
+		request, ok := h.Message.(*handshake.MessageCertificateRequest)
+		if !ok {
+			return
+		}
+		pkts, err := c.postHandshakeCertificate(raw, request)
+		if err != nil {
+			c.log.Debugf("failed to answer post-handshake CertificateRequest: %v", err)
+			return
+		}
+		c.postHandshakeResponse = pkts
+		if err := c.writePackets(c.writeDeadline, pkts); err != nil {
+			c.log.Debugf("failed to send post-handshake certificate: %v", err)
+		}
+	case !c.state.isClient && (header.Type == handshake.TypeCertificate || header.Type == handshake.TypeCertificateVerify):
+		select {
+		case c.postHandshakeMessages <- raw:
+		default:
+			c.log.Debugf("discarded unexpected post-handshake %s", header.Type)
+		}
+	default:
+		c.log.Debugf("discarded unexpected post-handshake %s", header.Type)
+	}
+}
+
+// resendPostHandshakeResponse answers a retransmitted CertificateRequest,
+// the retransmission itself is dropped as a duplicate by the fragmentBuffer.
+func (c *Conn) resendPostHandshakeResponse() {
+	if c.postHandshakeResponse == nil {
+		return
+	}
+	if err := c.writePackets(c.writeDeadline, c.postHandshakeResponse); err != nil {
+		c.log.Debugf("failed to resend post-handshake certificate: %v", err)
+	}
+}
+
+// postHandshakeCertificate builds the Certificate and CertificateVerify
+// answering a post-handshake CertificateRequest. An empty Certificate is sent
+// if no acceptable certificate is configured.
+func (c *Conn) postHandshakeCertificate(rawRequest []byte, request *handshake.MessageCertificateRequest) ([]*packet, error) {
+	certificate, err := c.fsm.cfg.getClientCertificate(newCertificateRequestInfo(request))
+	if err != nil {
+		return nil, err
+	}
+
+	rawCertificates := certificate.Certificate
+	var signatureHashAlgo signaturehash.Algorithm
+	if len(rawCertificates) > 0 {
+		if signatureHashAlgo, err = signaturehash.SelectSignatureScheme(request.SignatureHashAlgorithms, certificate.PrivateKey); err != nil {
+			c.log.Debugf("declining post-handshake CertificateRequest: %v", err)
+			rawCertificates = nil
+		}
+	}
+
+	certificateMessage := &handshake.Handshake{
+		Header: handshake.Header{
+			MessageSequence: c.fsm.cfg.nextHandshakeSendSequence(&c.state),
+		},
+		Message: &handshake.MessageCertificate{
+			Certificate: rawCertificates,
+		},
+	}
+	rawCertificate, err := certificateMessage.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	pkts := []*packet{c.postHandshakePacket(certificateMessage)}
+	if len(rawCertificates) == 0 {
+		return pkts, nil
+	}
+
+	plainText, err := c.postHandshakeAuthTranscript(append(append([]byte{}, rawRequest...), rawCertificate...))
+	if err != nil {
+		return nil, err
+	}
+	signature, err := generateCertificateVerify(plainText, certificate.PrivateKey, signatureHashAlgo)
+	if err != nil {
+		return nil, err
+	}
+	pkts = append(pkts, c.postHandshakePacket(&handshake.Handshake{
+		Header: handshake.Header{
+			MessageSequence: c.fsm.cfg.nextHandshakeSendSequence(&c.state),
+		},
+		Message: &handshake.MessageCertificateVerify{
+			HashAlgorithm:      signatureHashAlgo.Hash,
+			SignatureAlgorithm: signatureHashAlgo.Signature,
+			Signature:          signature,
+		},
+	}))
+	return pkts, nil
+}
+
+// postHandshakeAuthTranscript is the content signed by a post-handshake
+// CertificateVerify
+func (c *Conn) postHandshakeAuthTranscript(messages []byte) ([]byte, error) {
+	binder, err := c.state.ExportKeyingMaterial(postHandshakeAuthLabel, nil, postHandshakeAuthBinderLength)
+	if err != nil {
+		return nil, err
+	}
+	return append(binder, messages...), nil
+}
+
+func (c *Conn) postHandshakePacket(h *handshake.Handshake) *packet {
+	return &packet{
+		record: &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Epoch:   c.state.getLocalEpoch(),
+				Version: protocol.Version1_2,
+			},
+			Content: h,
+		},
+		shouldWrapCID: len(c.state.remoteConnectionID) > 0,
+		shouldEncrypt: true,
+	}
+}
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultRateLimitWindow is used if Config.RateLimitWindow is not set
+const defaultRateLimitWindow = time.Second
+
+// HandshakeRateLimiter decides whether a listener starts a new handshake with
+// a client. It is asked once for the first ClientHello of every client, before
+// the cookie exchange, or for the ClientHello with a valid cookie if
+// Config.StatelessCookieSecret is set. An implementation may share its state
+// with other servers.
+type HandshakeRateLimiter interface {
+	// Allow reports whether a handshake with raddr may start, the
+	// ClientHello is dropped without an answer if it returns false.
+	Allow(raddr net.Addr) bool
+}
+
+// NewSourceRateLimiter returns a HandshakeRateLimiter which allows limit new
+// handshakes per source IP address in every window.
+func NewSourceRateLimiter(limit int, window time.Duration) HandshakeRateLimiter {
+	if window <= 0 {
+		window = defaultRateLimitWindow
+	}
+	return &sourceRateLimiter{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		counts: map[string]int{},
+	}
+}
+
+// sourceRateLimiter counts handshakes per source IP address in fixed windows,
+// the counts are dropped once a window ended
+type sourceRateLimiter struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	counts      map[string]int
+}
+
+func (r *sourceRateLimiter) Allow(raddr net.Addr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.windowStart) >= r.window {
+		r.windowStart = now
+		r.counts = map[string]int{}
+	}
+
+	source := sourceAddress(raddr)
+	if r.counts[source] >= r.limit {
+		return false
+	}
+	r.counts[source]++
+	return true
+}
+
+// sourceAddress returns the IP address of raddr, a client can pick any port
+func sourceAddress(raddr net.Addr) string {
+	if udpAddr, ok := raddr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	if host, _, err := net.SplitHostPort(raddr.String()); err == nil {
+		return host
+	}
+	return raddr.String()
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+)
+
+// clientCertificateTypeExtensions returns the client_certificate_type and
+// server_certificate_type extensions of a ClientHello. They are omitted if
+// only X.509 certificates are supported, which is the default.
+// https://tools.ietf.org/html/rfc7250#section-4.1
+func clientCertificateTypeExtensions(cfg *handshakeConfig) []extension.Extension {
+	var extensions []extension.Extension
+	if types := offeredClientCertificateTypes(cfg); types != nil {
+		extensions = append(extensions, &extension.ClientCertificateType{CertificateTypes: types})
+	}
+	if types := offeredServerCertificateTypes(cfg); types != nil {
+		extensions = append(extensions, &extension.ServerCertificateType{CertificateTypes: types})
+	}
+	return extensions
+}
+
+// offeredClientCertificateTypes returns the types a client can send its
+// Certificate with, nil if it has no raw public key
+func offeredClientCertificateTypes(cfg *handshakeConfig) []extension.CertificateType {
+	if cfg.localRawPublicKey == nil {
+		return nil
+	}
+	types := []extension.CertificateType{extension.CertificateTypeRawPublicKey}
+	if len(cfg.localCertificates) > 0 || cfg.localGetClientCertificate != nil {
+		types = append(types, extension.CertificateTypeX509)
+	}
+	return types
+}
+
+// offeredServerCertificateTypes returns the types of server Certificate a
+// client accepts, nil if it doesn't accept raw public keys
+func offeredServerCertificateTypes(cfg *handshakeConfig) []extension.CertificateType {
+	if cfg.verifyRawPublicKey == nil {
+		return nil
+	}
+	return []extension.CertificateType{extension.CertificateTypeRawPublicKey, extension.CertificateTypeX509}
+}
+
+// negotiateCertificateTypes selects the certificate types of a server from
+// the types offered by the client. The client's type is only selected if a
+// client certificate is requested.
+// https://tools.ietf.org/html/rfc7250#section-4.2
+func negotiateCertificateTypes(state *State, cfg *handshakeConfig, clientTypes, serverTypes []extension.CertificateType) (*alert.Alert, error) {
+	state.clientCertificateType = nil
+	state.serverCertificateType = nil
+	if state.cipherSuite.AuthenticationType() != CipherSuiteAuthenticationTypeCertificate {
+		return nil, nil //nolint:nilnil
+	}
+
+	if len(serverTypes) > 0 {
+		t, ok := selectCertificateType(serverTypes, cfg.localRawPublicKey != nil)
+		if !ok {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.UnsupportedCertificate}, errNoCommonCertificateType
+		}
+		state.serverCertificateType = &t
+	}
+	if len(clientTypes) > 0 && cfg.clientAuth > NoClientCert {
+		t, ok := selectCertificateType(clientTypes, cfg.verifyRawPublicKey != nil)
+		if !ok {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.UnsupportedCertificate}, errNoCommonCertificateType
+		}
+		state.clientCertificateType = &t
+	}
+	return nil, nil //nolint:nilnil
+}
+
+// selectCertificateType picks a raw public key if rawPublicKey is set and it
+// was offered, X.509 otherwise
+func selectCertificateType(offered []extension.CertificateType, rawPublicKey bool) (extension.CertificateType, bool) {
+	if rawPublicKey && containsCertificateType(offered, extension.CertificateTypeRawPublicKey) {
+		return extension.CertificateTypeRawPublicKey, true
+	}
+	return extension.CertificateTypeX509, containsCertificateType(offered, extension.CertificateTypeX509)
+}
+
+func containsCertificateType(types []extension.CertificateType, t extension.CertificateType) bool {
+	for _, c := range types {
+		if c == t {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedCertificateTypeExtensions returns the extensions of a ServerHello
+// confirming the negotiated certificate types
+func selectedCertificateTypeExtensions(state *State) []extension.Extension {
+	var extensions []extension.Extension
+	if state.clientCertificateType != nil {
+		extensions = append(extensions, &extension.ClientCertificateType{
+			CertificateTypes: []extension.CertificateType{*state.clientCertificateType},
+			Selected:         true,
+		})
+	}
+	if state.serverCertificateType != nil {
+		extensions = append(extensions, &extension.ServerCertificateType{
+			CertificateTypes: []extension.CertificateType{*state.serverCertificateType},
+			Selected:         true,
+		})
+	}
+	return extensions
+}
+
+// verifySelectedCertificateType checks that the type a server selected was
+// offered by the client
+func verifySelectedCertificateType(selected *extension.CertificateType, offered []extension.CertificateType) (*alert.Alert, error) {
+	if selected != nil && !containsCertificateType(offered, *selected) {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errClientUnofferedCertificateType
+	}
+	return nil, nil //nolint:nilnil
+}
+
+// rawPublicKeyCertificate returns the raw public key of cfg as a certificate
+// whose only entry is its DER encoded SubjectPublicKeyInfo
+func rawPublicKeyCertificate(cfg *handshakeConfig) (*tls.Certificate, error) {
+	signer, ok := cfg.localRawPublicKey.(crypto.Signer)
+	if !ok {
+		return nil, errInvalidPrivateKey
+	}
+	spki, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{Certificate: [][]byte{spki}, PrivateKey: cfg.localRawPublicKey}, nil
+}
+
+// verifyPeerRawPublicKey passes the raw public key the peer authenticated
+// with to VerifyRawPublicKey
+func verifyPeerRawPublicKey(cfg *handshakeConfig, rawCertificates [][]byte) error {
+	publicKey, err := peerPublicKey(rawCertificates, true)
+	if err != nil {
+		return err
+	}
+	return cfg.verifyRawPublicKey(publicKey)
+}
@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"encoding/binary"
+)
+
+// recordPaddingHeaderSize is the length prefix of padded application data
+const recordPaddingHeaderSize = 2
+
+// padApplicationData returns data prefixed by its length and followed by
+// zeros up to paddedLen bytes, see extension.RecordPadding
+func padApplicationData(data []byte, paddedLen int) []byte {
+	if paddedLen < len(data) {
+		paddedLen = len(data)
+	}
+	out := make([]byte, recordPaddingHeaderSize+paddedLen)
+	binary.BigEndian.PutUint16(out, uint16(len(data)))
+	copy(out[recordPaddingHeaderSize:], data)
+	return out
+}
+
+// unpadApplicationData returns the data of a record padded by
+// padApplicationData, the padding must be zeros
+func unpadApplicationData(padded []byte) ([]byte, error) {
+	if len(padded) < recordPaddingHeaderSize {
+		return nil, errInvalidRecordPadding
+	}
+	n := int(binary.BigEndian.Uint16(padded))
+	if n > len(padded)-recordPaddingHeaderSize {
+		return nil, errInvalidRecordPadding
+	}
+	for _, b := range padded[recordPaddingHeaderSize+n:] {
+		if b != 0 {
+			return nil, errInvalidRecordPadding
+		}
+	}
+	return padded[recordPaddingHeaderSize : recordPaddingHeaderSize+n], nil
+}
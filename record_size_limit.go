@@ -0,0 +1,30 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+)
+
+const (
+	// minRecordSizeLimit is the smallest value a peer may advertise.
+	// https://datatracker.ietf.org/doc/html/rfc8449#section-4
+	minRecordSizeLimit = 64
+	// maxRecordSizeLimit is the largest plaintext size of a DTLS 1.2 record.
+	maxRecordSizeLimit = 1 << 14
+)
+
+// parseRecordSizeLimit validates a record_size_limit sent by the peer and
+// returns the limit that has to be applied to records sent to it.
+func parseRecordSizeLimit(limit uint16) (uint16, *alert.Alert, error) {
+	if limit < minRecordSizeLimit {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errInvalidRecordSizeLimit
+	}
+	// A value larger than the protocol limit only signals support for the
+	// maximum record size.
+	if limit > maxRecordSizeLimit {
+		limit = maxRecordSizeLimit
+	}
+	return limit, nil, nil
+}
@@ -0,0 +1,310 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/alert"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+const (
+	// defaultHelloRequestMaxRetransmits bounds how often a server repeats
+	// its HelloRequest when MaxRetransmits is not set
+	defaultHelloRequestMaxRetransmits = 5
+
+	// renegotiationInfoSCSV may be offered instead of the renegotiation_info
+	// extension https://tools.ietf.org/html/rfc5746#section-3.3
+	renegotiationInfoSCSV = 0x00ff
+)
+
+// epochCipherSuite keeps the keys of the epoch a renegotiation started in,
+// records of that epoch are still sent and received while the new keys are
+// negotiated
+type epochCipherSuite struct {
+	epoch       uint16
+	cipherSuite CipherSuite
+}
+
+// Renegotiate runs a new full handshake on the established connection and
+// returns once it completed, replacing the keys of the connection.
+// Application data can still be sent and received while the handshake is
+// running.
+//
+// A client sends a new ClientHello, a server sends a HelloRequest asking the
+// client to start the renegotiation. The new handshake is bound to the
+// previous one with the renegotiation_info extension (RFC 5746), so both peers
+// must have sent it in the initial handshake. Renegotiation is not possible
+// once connection IDs are in use.
+//
+// If the handshake fails a fatal alert is sent and the connection is closed.
+func (c *Conn) Renegotiate() error {
+	if c.isConnectionClosed() || c.isConnectionClosing() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	if !c.canRenegotiate() {
+		return errRenegotiationNotSupported
+	}
+
+	done := make(chan error, 1)
+	c.renegotiationLock.Lock()
+	if c.renegotiating || c.renegotiationDone != nil {
+		c.renegotiationLock.Unlock()
+		return errRenegotiationInProgress
+	}
+	c.renegotiationDone = done
+	c.renegotiationLock.Unlock()
+
+	if c.state.isClient {
+		// A HelloRequest may have started the renegotiation meanwhile, its
+		// result is reported to done as well
+		if err := c.startRenegotiation(); err != nil && !errors.Is(err, errRenegotiationInProgress) {
+			c.finishRenegotiation(err)
+		}
+		select {
+		case err := <-done:
+			return err
+		case <-c.closed.Done():
+			return ErrConnClosed
+		}
+	}
+
+	cfg := c.fsm.cfg
+	maxRetransmits := cfg.maxRetransmits
+	if maxRetransmits <= 0 {
+		maxRetransmits = defaultHelloRequestMaxRetransmits
+	}
+	helloRequest := &handshake.Handshake{Message: &handshake.MessageHelloRequest{}}
+	for attempt := 0; ; attempt++ {
+		// The HelloRequest is repeated until the client's ClientHello starts the
+		// renegotiation
+		if !c.isRenegotiating() {
+			if attempt > maxRetransmits {
+				c.renegotiationLock.Lock()
+				started := c.renegotiating
+				if !started {
+					c.renegotiationDone = nil
+				}
+				c.renegotiationLock.Unlock()
+				if !started {
+					return errHelloRequestTimeout
+				}
+			} else if err := c.writePackets(c.writeDeadline, []*packet{c.postHandshakePacket(helloRequest)}); err != nil {
+				c.finishRenegotiation(err)
+				return <-done
+			}
+		}
+
+		timer := time.NewTimer(cfg.retransmitDelay(attempt))
+		select {
+		case err := <-done:
+			timer.Stop()
+			return err
+		case <-timer.C:
+		case <-c.closed.Done():
+			timer.Stop()
+			return ErrConnClosed
+		}
+	}
+}
+
+// canRenegotiate reports whether the peer agreed to secure renegotiation.
+// Connection IDs are negotiated per handshake and may not change while
+// records protected by the previous handshake are still in flight.
+func (c *Conn) canRenegotiate() bool {
+	return c.state.secureRenegotiation && c.state.localConnectionID == nil && c.state.remoteConnectionID == nil
+}
+
+func (c *Conn) isRenegotiating() bool {
+	c.renegotiationLock.Lock()
+	defer c.renegotiationLock.Unlock()
+	return c.renegotiating
+}
+
+// startRenegotiation moves the finished handshake back to its first flight.
+// The handshake messages of the previous handshake are dropped and the keys
+// of the current epoch are kept for the records that are still in flight.
+func (c *Conn) startRenegotiation() error {
+	c.renegotiationLock.Lock()
+	if c.renegotiating {
+		c.renegotiationLock.Unlock()
+		return errRenegotiationInProgress
+	}
+	c.renegotiating = true
+	c.renegotiationLock.Unlock()
+
+	c.previousCipherSuite.Store(epochCipherSuite{
+		epoch:       c.state.getLocalEpoch(),
+		cipherSuite: c.state.cipherSuite,
+	})
+	c.handshakeCache.reset()
+	atomic.StoreInt32(&c.resetFragmentBuffer, 1)
+
+	select {
+	case c.fsm.renegotiate <- struct{}{}:
+		c.log.Tracef("[handshake:%s] start renegotiation", srvCliStr(c.state.isClient))
+		return nil
+	case <-c.fsm.Done():
+		return ErrConnClosed
+	}
+}
+
+// finishRenegotiation reports the result of a renegotiation to Renegotiate
+func (c *Conn) finishRenegotiation(err error) {
+	c.renegotiationLock.Lock()
+	defer c.renegotiationLock.Unlock()
+
+	c.renegotiating = false
+	if c.renegotiationDone != nil {
+		c.renegotiationDone <- err
+		c.renegotiationDone = nil
+	}
+}
+
+// handleRenegotiationRecord looks at handshake records received after the
+// handshake completed. A client starts a renegotiation when it receives a
+// HelloRequest, which is consumed here as it is not part of any handshake.
+// A server starts a renegotiation when it receives a ClientHello in the
+// current epoch, the ClientHello is then handled by the handshake.
+func (c *Conn) handleRenegotiationRecord(buf []byte, epoch uint16) (bool, *alert.Alert) {
+	if len(buf) <= recordlayer.FixedHeaderSize || protocol.ContentType(buf[0]) != protocol.ContentTypeHandshake {
+		return false, nil
+	}
+
+	switch typ := handshake.Type(buf[recordlayer.FixedHeaderSize]); {
+	case c.state.isClient && typ == handshake.TypeHelloRequest:
+		// HelloRequests are ignored during a handshake and retransmissions
+		// may arrive after the renegotiation completed
+		// https://tools.ietf.org/html/rfc5246#section-7.4.1.1
+		if !c.isHandshakeCompletedSuccessfully() || c.isRenegotiating() || epoch != c.state.getRemoteEpoch() {
+			return true, nil
+		}
+		if !c.canRenegotiate() {
+			return true, &alert.Alert{Level: alert.Warning, Description: alert.NoRenegotiation}
+		}
+		if err := c.startRenegotiation(); err != nil {
+			c.log.Debugf("failed to start renegotiation: %v", err)
+		}
+		return true, nil
+	case !c.state.isClient && typ == handshake.TypeClientHello:
+		if epoch == 0 || !c.isHandshakeCompletedSuccessfully() || c.isRenegotiating() || epoch != c.state.getRemoteEpoch() {
+			return false, nil
+		}
+		if !c.canRenegotiate() {
+			return true, &alert.Alert{Level: alert.Warning, Description: alert.NoRenegotiation}
+		}
+		if err := c.startRenegotiation(); err != nil {
+			c.log.Debugf("failed to start renegotiation: %v", err)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cipherSuiteForEpoch returns the CipherSuite protecting records of epoch
+func (c *Conn) cipherSuiteForEpoch(epoch uint16) CipherSuite {
+	if previous, ok := c.previousCipherSuite.Load().(epochCipherSuite); ok && previous.epoch == epoch {
+		return previous.cipherSuite
+	}
+	return c.state.cipherSuite
+}
+
+// saveVerifyData keeps the verify_data of both Finished messages of the
+// handshake that just completed for the next renegotiation
+func (c *Conn) saveVerifyData() {
+	epoch := c.fsm.cfg.initialEpoch + 1
+	items := c.handshakeCache.pull(
+		handshakeCachePullRule{handshake.TypeFinished, epoch, true, false},
+		handshakeCachePullRule{handshake.TypeFinished, epoch, false, false},
+	)
+	verifyData := make([][]byte, len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		h := &handshake.Handshake{}
+		if err := h.Unmarshal(item.data); err != nil {
+			continue
+		}
+		if finished, ok := h.Message.(*handshake.MessageFinished); ok {
+			verifyData[i] = finished.VerifyData
+		}
+	}
+	c.state.clientVerifyData, c.state.serverVerifyData = verifyData[0], verifyData[1]
+}
+
+// renegotiationFailed closes a connection whose renegotiation failed
+func (c *Conn) renegotiationFailed(err error) {
+	if errors.Is(err, context.Canceled) {
+		err = ErrConnClosed
+	}
+	c.finishRenegotiation(err)
+	_ = c.close(false) //nolint:contextcheck
+}
+
+// renegotiationInfo builds the renegotiation_info extension, it is empty in
+// the initial handshake and carries the verify_data of the previous one when
+// renegotiating https://tools.ietf.org/html/rfc5746#section-3.4
+func renegotiationInfo(state *State, cfg *handshakeConfig) *extension.RenegotiationInfo {
+	switch {
+	case !cfg.isRenegotiation():
+		return &extension.RenegotiationInfo{}
+	case state.isClient:
+		return &extension.RenegotiationInfo{VerifyData: state.clientVerifyData}
+	default:
+		return &extension.RenegotiationInfo{
+			VerifyData: append(append([]byte{}, state.clientVerifyData...), state.serverVerifyData...),
+		}
+	}
+}
+
+// isPerConnectionExtension reports whether ext negotiates how records are
+// sent on the connection. Records are sent while a renegotiation is running, so
+// these keep the values of the initial handshake.
+func isPerConnectionExtension(ext extension.Extension) bool {
+	switch ext.(type) {
+	case *extension.MaxFragmentLength, *extension.RecordSizeLimit, *extension.Heartbeat, *extension.ConnectionID, *extension.RecordPadding, *extension.PostHandshakeAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// resetForRenegotiation clears what the previous handshake left behind, the
+// keys and verify_data stay until the new handshake replaces them.
+func (s *State) resetForRenegotiation() {
+	s.handshakeRecvSequence = 0
+	s.cookie = nil
+	s.SessionID = nil
+	s.sessionTicket = nil
+	s.sessionTicketNegotiated = false
+	s.resumed = false
+	s.localKeypair = nil
+	s.preMasterSecret = nil
+	s.extendedMasterSecret = false
+	s.encryptThenMAC = false
+	s.sessionExtendedMasterSecret = false
+	s.remoteCertRequestAlgs = nil
+	s.remoteRequestedOCSP = false
+	s.remoteTrustedAuthorities = nil
+	s.remoteSRTPProtectionProfiles = nil
+	s.remoteApplicationSettings = nil
+	s.remoteRequestedCertificate = false
+	s.localCertificatesVerify = nil
+	s.localVerifyData = nil
+	s.localKeySignature = nil
+	s.peerCertificatesVerified = false
+	s.clientCertificateType = nil
+	s.serverCertificateType = nil
+}
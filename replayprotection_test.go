@@ -4,6 +4,7 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
 	"net"
 	"reflect"
@@ -12,6 +13,7 @@ import (
 	"testing"
 	"time"
 
+	dtlsnet "github.com/adrian38/dtls/v2/pkg/net"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
 )
@@ -140,3 +142,137 @@ func TestReplayProtection(t *testing.T) {
 		}
 	}
 }
+
+// capturePacketConn keeps the datagrams written while capture is set instead
+// of sending them
+type capturePacketConn struct {
+	net.PacketConn
+
+	mu       sync.Mutex
+	capture  bool
+	captured [][]byte
+}
+
+func (c *capturePacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.capture {
+		c.captured = append(c.captured, append([]byte{}, p...))
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+func TestReplayProtectionWindow(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const replayProtectionWindow = 4
+
+	for name, tt := range map[string]struct {
+		order    []int
+		received []byte
+		dropped  uint64
+	}{
+		"InWindow": {
+			order:    []int{5, 3, 4, 2},
+			received: []byte{5, 3, 4, 2},
+		},
+		"LeftOfWindow": {
+			order:    []int{9, 5, 6, 1},
+			received: []byte{9, 6},
+			dropped:  2,
+		},
+		"Duplicate": {
+			order:    []int{2, 2, 3, 2, 3},
+			received: []byte{2, 3},
+			dropped:  3,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			clientConn := &capturePacketConn{PacketConn: dtlsnet.PacketConnFromConn(ca)}
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+			go func() {
+				client, err := testClient(context.TODO(), clientConn, ca.RemoteAddr(), &Config{}, true)
+				c <- result{client, err}
+			}()
+			server, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				ReplayProtectionWindow: replayProtectionWindow,
+			}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			client := res.c
+
+			// Records of message i get consecutive sequence numbers
+			clientConn.mu.Lock()
+			clientConn.capture = true
+			clientConn.mu.Unlock()
+			for i := 0; i < 10; i++ {
+				if _, err := client.Write([]byte{byte(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			clientConn.mu.Lock()
+			captured := clientConn.captured
+			clientConn.capture = false
+			clientConn.mu.Unlock()
+			if len(captured) != 10 {
+				t.Fatalf("Expected 10 records, got %d", len(captured))
+			}
+
+			for _, i := range tt.order {
+				if _, err := ca.Write(captured[i]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var received []byte
+			buf := make([]byte, 16)
+			for len(received) < len(tt.received) {
+				n, err := server.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				received = append(received, buf[:n]...)
+			}
+			if !bytes.Equal(received, tt.received) {
+				t.Errorf("Received messages %v, expected %v", received, tt.received)
+			}
+
+			// Nothing else may arrive, a last record makes sure all were handled
+			if _, err := client.Write([]byte{0xff}); err != nil {
+				t.Fatal(err)
+			}
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf[:n], []byte{0xff}) {
+				t.Errorf("Received unexpected message %v", buf[:n])
+			}
+			if dropped := server.DroppedReplays(); dropped != tt.dropped {
+				t.Errorf("Dropped %d replayed records, expected %d", dropped, tt.dropped)
+			}
+
+			_ = client.Close()
+			_ = server.Close()
+		})
+	}
+}
@@ -10,6 +10,16 @@ import (
 
 // Resume imports an already established dtls connection using a specific dtls state
 func Resume(state *State, conn net.PacketConn, rAddr net.Addr, config *Config) (*Conn, error) {
+	// A deserialized State only knows the built-in cipher suites, a custom
+	// suite of the same ID derives its keys differently
+	if config != nil && config.CustomCipherSuites != nil {
+		for _, c := range config.CustomCipherSuites() {
+			if c.ID() == state.CipherSuiteID {
+				state.cipherSuite = c
+				break
+			}
+		}
+	}
 	if err := state.initCipherSuite(); err != nil {
 		return nil, err
 	}
@@ -20,3 +30,23 @@ func Resume(state *State, conn net.PacketConn, rAddr net.Addr, config *Config) (
 
 	return c, nil
 }
+
+// ResumeConn imports a connection from a state exported with Conn.MarshalState,
+// for instance by a previous process sharing the same socket. The cipher suite
+// of the state must be enabled in config.
+func ResumeConn(conn net.PacketConn, rAddr net.Addr, state []byte, config *Config) (*Conn, error) {
+	s := &State{}
+	if err := s.UnmarshalBinary(state); err != nil {
+		return nil, err
+	}
+	if config != nil {
+		cipherSuites, err := parseCipherSuites(config.CipherSuites, config.CustomCipherSuites, config.includeCertificateSuites(), config.PSK != nil)
+		if err != nil {
+			return nil, err
+		}
+		if !containsCipherSuite(s.CipherSuiteID, cipherSuites) {
+			return nil, errStateCipherSuiteMismatch
+		}
+	}
+	return Resume(s, conn, rAddr, config)
+}
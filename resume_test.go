@@ -6,6 +6,7 @@ package dtls
 import (
 	"bytes"
 	"crypto/tls"
+	"encoding/gob"
 	"errors"
 	"fmt"
 	"net"
@@ -157,6 +158,225 @@ func DoTestResume(t *testing.T, newLocal, newRemote func(net.PacketConn, net.Add
 	}
 }
 
+func TestResumeConn(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localConn1, rc1 := net.Pipe()
+	localConn2, rc2 := net.Pipe()
+	remoteConn := &backupConn{curr: rc1, next: rc2}
+
+	config := &Config{
+		Certificates:          []tls.Certificate{certificate},
+		InsecureSkipVerify:    true,
+		CipherSuites:          []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		ConnectionIDGenerator: RandomCIDGenerator(8),
+	}
+
+	const messages = 3
+	errChan := make(chan error, 1)
+	go func() {
+		remote, errR := Server(dtlsnet.PacketConnFromConn(remoteConn), remoteConn.RemoteAddr(), config)
+		if errR != nil {
+			errChan <- errR
+			return
+		}
+		defer func() {
+			_ = remote.Close()
+		}()
+
+		// Echo one message before and the rest after the restart
+		for i := 0; i < 1+messages; i++ {
+			recv := make([]byte, 1024)
+			n, errR := remote.Read(recv)
+			if errR != nil {
+				errChan <- errR
+				return
+			}
+			if _, errR = remote.Write(recv[:n]); errR != nil {
+				errChan <- errR
+				return
+			}
+		}
+		errChan <- nil
+	}()
+
+	echo := func(c *Conn, message []byte) {
+		if _, err := c.Write(message); err != nil {
+			fatal(t, errChan, err)
+		}
+		recv := make([]byte, 1024)
+		n, err := c.Read(recv)
+		if err != nil {
+			fatal(t, errChan, err)
+		}
+		if !bytes.Equal(message, recv[:n]) {
+			fatal(t, errChan, fmt.Errorf("%w: %s != %s", errMessageMissmatch, message, recv[:n]))
+		}
+	}
+
+	local, err := Client(dtlsnet.PacketConnFromConn(localConn1), localConn1.RemoteAddr(), config)
+	if err != nil {
+		fatal(t, errChan, err)
+	}
+	echo(local, []byte("before restart"))
+
+	state, err := local.MarshalState()
+	if err != nil {
+		fatal(t, errChan, err)
+	}
+	// The old process goes away without a close_notify
+	if err = localConn1.Close(); err != nil {
+		fatal(t, errChan, err)
+	}
+	defer func() {
+		_ = local.Close()
+	}()
+
+	resumed, err := ResumeConn(dtlsnet.PacketConnFromConn(localConn2), localConn2.RemoteAddr(), state, config)
+	if err != nil {
+		fatal(t, errChan, err)
+	}
+	defer func() {
+		_ = resumed.Close()
+	}()
+
+	before, after := local.ConnectionState(), resumed.ConnectionState()
+	if !bytes.Equal(before.localConnectionID, after.localConnectionID) || !bytes.Equal(before.remoteConnectionID, after.remoteConnectionID) {
+		fatal(t, errChan, errors.New("connection IDs were not restored")) //nolint:goerr113
+	}
+	if after.getLocalEpoch() != 1 || after.CipherSuiteID != TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+		fatal(t, errChan, fmt.Errorf("unexpected resumed state: epoch %d, cipher suite %s", after.getLocalEpoch(), after.CipherSuiteID)) //nolint:goerr113
+	}
+
+	for i := 0; i < messages; i++ {
+		echo(resumed, []byte(fmt.Sprintf("after restart %d", i)))
+	}
+
+	if err = <-errChan; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResumeConnInvalidState(t *testing.T) {
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{
+		Certificates: []tls.Certificate{certificate},
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}
+
+	marshal := func(serialized serializedState) []byte {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(serialized); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	for name, tt := range map[string]struct {
+		state       []byte
+		expectedErr error
+	}{
+		"UnknownFormatVersion": {
+			state: marshal(serializedState{
+				FormatVersion: serializedStateVersion + 1,
+				CipherSuiteID: uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+				MasterSecret:  make([]byte, 48),
+			}),
+			expectedErr: errUnsupportedStateVersion,
+		},
+		"UnknownCipherSuite": {
+			state: marshal(serializedState{
+				FormatVersion: serializedStateVersion,
+				CipherSuiteID: 0xFFFF,
+				MasterSecret:  make([]byte, 48),
+			}),
+			expectedErr: &invalidCipherSuiteError{0xFFFF},
+		},
+		"CipherSuiteNotEnabled": {
+			state: marshal(serializedState{
+				FormatVersion: serializedStateVersion,
+				LocalEpoch:    1,
+				RemoteEpoch:   1,
+				CipherSuiteID: uint16(TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA),
+				MasterSecret:  make([]byte, 48),
+				IsClient:      true,
+			}),
+			expectedErr: errStateCipherSuiteMismatch,
+		},
+		"LegacyFormatVersion": {
+			state: marshal(serializedState{
+				LocalEpoch:    1,
+				RemoteEpoch:   1,
+				CipherSuiteID: uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+				MasterSecret:  make([]byte, 48),
+				IsClient:      true,
+			}),
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, _ := net.Pipe()
+			defer func() {
+				_ = ca.Close()
+			}()
+
+			conn, err := ResumeConn(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.state, config)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("Expected error '%v', got '%v'", tt.expectedErr, err)
+			}
+			if conn != nil {
+				_ = conn.Close()
+			}
+		})
+	}
+}
+
+func TestResumeCipherSuiteNotEnabled(t *testing.T) {
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state := &State{}
+	state.deserialize(serializedState{
+		LocalEpoch:    1,
+		RemoteEpoch:   1,
+		CipherSuiteID: uint16(TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA),
+		MasterSecret:  make([]byte, 48),
+		IsClient:      true,
+	})
+
+	ca, _ := net.Pipe()
+	defer func() {
+		_ = ca.Close()
+	}()
+
+	// Unlike ResumeConn, Resume doesn't check the cipher suite against the
+	// Config
+	conn, err := Resume(state, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+		Certificates: []tls.Certificate{certificate},
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+}
+
 type backupConn struct {
 	curr net.Conn
 	next net.Conn
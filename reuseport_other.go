@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+// For systems without SO_REUSEPORT.
+// Build targets must be inverse of reuseport_unix.go
+
+package dtls
+
+func setReusePort(uintptr) error {
+	return errReusePortUnsupported
+}
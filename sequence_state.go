@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"sync/atomic"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+// SequenceState is a snapshot of the record layer counters of a Conn, see
+// Conn.DebugSequenceState
+type SequenceState struct {
+	// LocalEpoch is the epoch of the records sent next
+	LocalEpoch uint16
+	// LocalSequenceNumber is the sequence number of the next record sent in
+	// LocalEpoch
+	LocalSequenceNumber uint64
+
+	// RemoteEpoch is the epoch the peer is expected to send in
+	RemoteEpoch uint16
+	// RemoteSequenceNumber follows the highest sequence number received in
+	// RemoteEpoch, it is zero until a record of RemoteEpoch was received
+	RemoteSequenceNumber uint64
+}
+
+// DebugSequenceState returns the current epochs and sequence numbers of both
+// directions, which helps to diagnose records that fail to decrypt. It is a
+// snapshot that is outdated as soon as another record is sent or received,
+// it is meant for logging rather than to drive the protocol.
+func (c *Conn) DebugSequenceState() SequenceState {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	s := SequenceState{
+		LocalEpoch:  c.state.getLocalEpoch(),
+		RemoteEpoch: c.state.getRemoteEpoch(),
+	}
+	if int(s.LocalEpoch) < len(c.state.localSequenceNumber) {
+		s.LocalSequenceNumber = atomic.LoadUint64(&c.state.localSequenceNumber[s.LocalEpoch])
+	}
+	if atomic.LoadInt32(&c.remoteRecordReceived) != 0 {
+		latest := atomic.LoadUint64(&c.latestRemoteRecord)
+		if uint16(latest>>48) == s.RemoteEpoch {
+			s.RemoteSequenceNumber = latest&recordlayer.MaxSequenceNumber + 1
+		}
+	}
+	return s
+}
+
+// markLatestRemoteRecord records the epoch and sequence number of the
+// received record with the highest sequence number, older epochs don't
+// replace a newer one
+func (c *Conn) markLatestRemoteRecord(epoch uint16, sequenceNumber uint64) {
+	if atomic.LoadInt32(&c.remoteRecordReceived) != 0 && uint16(atomic.LoadUint64(&c.latestRemoteRecord)>>48) > epoch {
+		return
+	}
+	atomic.StoreUint64(&c.latestRemoteRecord, uint64(epoch)<<48|sequenceNumber)
+	atomic.StoreInt32(&c.remoteRecordReceived, 1)
+}
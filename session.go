@@ -9,6 +9,12 @@ type Session struct {
 	ID []byte
 	// Secret store session master secret
 	Secret []byte
+	// Ticket store the session ticket issued by the server, if any
+	Ticket []byte
+	// ExtendedMasterSecret store whether the master secret was derived with
+	// the extended master secret, the session is only resumed by handshakes
+	// negotiating the same https://tools.ietf.org/html/rfc7627#section-5.3
+	ExtendedMasterSecret bool
 }
 
 // SessionStore defines methods needed for session resumption.
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// MemorySessionStore is an in-memory SessionStore bound in size, which
+// evicts the least recently used session when it is full.
+// A MemorySessionStore is safe for concurrent use.
+type MemorySessionStore struct {
+	// TTL, if positive, is how long a session is kept after it was set,
+	// expired sessions are no longer returned by Get. It must be set before
+	// the store is used.
+	TTL time.Duration
+
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+	now        func() time.Time
+}
+
+type memorySessionEntry struct {
+	key     string
+	session Session
+	expires time.Time
+}
+
+// NewMemorySessionStore returns a MemorySessionStore keeping at most
+// maxEntries sessions. If maxEntries is not positive the number of sessions
+// is not bound.
+func NewMemorySessionStore(maxEntries int) *MemorySessionStore {
+	return &MemorySessionStore{
+		maxEntries: maxEntries,
+		order:      list.New(),
+		entries:    map[string]*list.Element{},
+		now:        time.Now,
+	}
+}
+
+// Set saves a session under key, evicting the least recently used session if
+// the store is full.
+func (s *MemorySessionStore) Set(key []byte, session Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expires time.Time
+	if s.TTL > 0 {
+		expires = s.now().Add(s.TTL)
+	}
+
+	if e, ok := s.entries[string(key)]; ok {
+		entry := e.Value.(*memorySessionEntry) //nolint:forcetypeassert
+		entry.session, entry.expires = session, expires
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[string(key)] = s.order.PushFront(&memorySessionEntry{key: string(key), session: session, expires: expires})
+	for s.maxEntries > 0 && s.order.Len() > s.maxEntries {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Get returns the session saved under key, or an empty Session if there is
+// none or it expired.
+func (s *MemorySessionStore) Get(key []byte) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[string(key)]
+	if !ok {
+		return Session{}, nil
+	}
+	entry := e.Value.(*memorySessionEntry) //nolint:forcetypeassert
+	if !entry.expires.IsZero() && !s.now().Before(entry.expires) {
+		s.remove(e)
+		return Session{}, nil
+	}
+	s.order.MoveToFront(e)
+	return entry.session, nil
+}
+
+// Del removes the session saved under key.
+func (s *MemorySessionStore) Del(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[string(key)]; ok {
+		s.remove(e)
+	}
+	return nil
+}
+
+// Len returns the number of sessions held, including expired sessions that
+// were not looked up since they expired.
+func (s *MemorySessionStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+func (s *MemorySessionStore) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.entries, e.Value.(*memorySessionEntry).key) //nolint:forcetypeassert
+}
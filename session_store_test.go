@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	s := NewMemorySessionStore(2)
+
+	_ = s.Set([]byte("a"), Session{ID: []byte{1}})
+	_ = s.Set([]byte("b"), Session{ID: []byte{2}})
+
+	// Touch "a" so that "b" becomes the least recently used
+	if got, _ := s.Get([]byte("a")); !bytes.Equal(got.ID, []byte{1}) {
+		t.Fatalf("Unexpected session %v", got)
+	}
+	_ = s.Set([]byte("c"), Session{ID: []byte{3}})
+
+	if got, _ := s.Get([]byte("b")); got.ID != nil {
+		t.Errorf("Expected least recently used session to be evicted, got %v", got)
+	}
+	for key, id := range map[string]byte{"a": 1, "c": 3} {
+		if got, _ := s.Get([]byte(key)); !bytes.Equal(got.ID, []byte{id}) {
+			t.Errorf("Session %s: expected ID %v, got %v", key, id, got.ID)
+		}
+	}
+
+	_ = s.Del([]byte("a"))
+	if got, _ := s.Get([]byte("a")); got.ID != nil {
+		t.Errorf("Expected deleted session to be gone, got %v", got)
+	}
+}
+
+func TestMemorySessionStoreCapacity(t *testing.T) {
+	s := NewMemorySessionStore(8)
+	for i := 0; i < 100; i++ {
+		_ = s.Set([]byte(fmt.Sprint(i)), Session{ID: []byte{byte(i)}})
+	}
+	if n := s.Len(); n != 8 {
+		t.Fatalf("Expected 8 sessions, got %d", n)
+	}
+	for i := 0; i < 100; i++ {
+		got, _ := s.Get([]byte(fmt.Sprint(i)))
+		if kept := i >= 92; kept != (got.ID != nil) {
+			t.Errorf("Session %d: expected kept %v, got %v", i, kept, got)
+		}
+	}
+}
+
+func TestMemorySessionStoreExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewMemorySessionStore(2)
+	s.TTL = time.Minute
+	s.now = func() time.Time { return now }
+
+	_ = s.Set([]byte("a"), Session{ID: []byte{1}})
+	now = now.Add(30 * time.Second)
+	_ = s.Set([]byte("b"), Session{ID: []byte{2}})
+
+	now = now.Add(30 * time.Second)
+	if got, _ := s.Get([]byte("a")); got.ID != nil {
+		t.Errorf("Expected the stale session to expire, got %v", got)
+	}
+	if got, _ := s.Get([]byte("b")); !bytes.Equal(got.ID, []byte{2}) {
+		t.Errorf("Expected the fresh session, got %v", got)
+	}
+	if n := s.Len(); n != 1 {
+		t.Errorf("Expected the expired session to be removed, %d sessions left", n)
+	}
+
+	// Setting a session again renews it
+	_ = s.Set([]byte("b"), Session{ID: []byte{3}})
+	now = now.Add(45 * time.Second)
+	if got, _ := s.Get([]byte("b")); !bytes.Equal(got.ID, []byte{3}) {
+		t.Errorf("Expected the renewed session, got %v", got)
+	}
+}
+
+func TestMemorySessionStoreConcurrency(t *testing.T) {
+	s := NewMemorySessionStore(16)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := []byte(fmt.Sprint(i*100 + j%32))
+				_ = s.Set(key, Session{ID: key})
+				if got, _ := s.Get(key); got.ID != nil && !bytes.Equal(got.ID, key) {
+					t.Errorf("Session %s: got %v", key, got)
+				}
+				if j%10 == 0 {
+					_ = s.Del(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if n := s.Len(); n > 16 {
+		t.Errorf("Expected at most 16 sessions, got %d", n)
+	}
+}
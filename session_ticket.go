@@ -0,0 +1,147 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"crypto/subtle"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	sessionTicketKeyLength       = 32
+	sessionTicketKeyNameLength   = 16
+	sessionTicketNonceLength     = 12
+	sessionTicketStateVersion    = 1
+	defaultSessionTicketLifetime = 7 * 24 * time.Hour
+)
+
+// sessionTicketState is the session state the server encrypts into a ticket
+// https://tools.ietf.org/html/rfc5077#section-4
+type sessionTicketState struct {
+	createdAt            time.Time
+	cipherSuiteID        CipherSuiteID
+	extendedMasterSecret bool
+	masterSecret         []byte
+}
+
+func (s *sessionTicketState) marshal() ([]byte, error) {
+	var ems uint8
+	if s.extendedMasterSecret {
+		ems = 1
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(sessionTicketStateVersion)
+	b.AddUint64(uint64(s.createdAt.Unix()))
+	b.AddUint16(uint16(s.cipherSuiteID))
+	b.AddUint8(ems)
+	b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddBytes(s.masterSecret)
+	})
+	return b.Bytes()
+}
+
+func (s *sessionTicketState) unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+
+	var (
+		version, ems  uint8
+		createdAt     uint64
+		cipherSuiteID uint16
+		masterSecret  cryptobyte.String
+	)
+	if !val.ReadUint8(&version) || version != sessionTicketStateVersion ||
+		!val.ReadUint64(&createdAt) ||
+		!val.ReadUint16(&cipherSuiteID) ||
+		!val.ReadUint8(&ems) ||
+		!val.ReadUint8LengthPrefixed(&masterSecret) ||
+		!val.Empty() {
+		return errInvalidSessionTicket
+	}
+
+	s.createdAt = time.Unix(int64(createdAt), 0)
+	s.cipherSuiteID = CipherSuiteID(cipherSuiteID)
+	s.extendedMasterSecret = ems == 1
+	s.masterSecret = append([]byte{}, masterSecret...)
+	return nil
+}
+
+// sessionTicketKeys derives the key name placed in front of every ticket and
+// the AES key used to seal the ticket contents.
+func sessionTicketKeys(key []byte) (keyName []byte, aead cipher.AEAD, err error) {
+	if len(key) != sessionTicketKeyLength {
+		return nil, nil, errInvalidSessionTicketKey
+	}
+
+	h := sha512.Sum512(key)
+	block, err := aes.NewCipher(h[sessionTicketKeyNameLength : sessionTicketKeyNameLength+16])
+	if err != nil {
+		return nil, nil, err
+	}
+	if aead, err = cipher.NewGCM(block); err != nil {
+		return nil, nil, err
+	}
+	return h[:sessionTicketKeyNameLength], aead, nil
+}
+
+// encryptSessionTicket seals s into a ticket of the form
+// key_name || nonce || AES-GCM(state).
+func encryptSessionTicket(key []byte, rand io.Reader, s *sessionTicketState) ([]byte, error) {
+	keyName, aead, err := sessionTicketKeys(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := s.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	ticket := make([]byte, sessionTicketKeyNameLength+sessionTicketNonceLength, sessionTicketKeyNameLength+sessionTicketNonceLength+len(plaintext)+aead.Overhead())
+	copy(ticket, keyName)
+	nonce := ticket[sessionTicketKeyNameLength:]
+	if _, err := io.ReadFull(rand, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(ticket, nonce, plaintext, keyName), nil
+}
+
+// decryptSessionTicket opens a ticket created by encryptSessionTicket. Tickets
+// sealed with a different key or older than lifetime are rejected.
+func decryptSessionTicket(key, ticket []byte, lifetime time.Duration, now time.Time) (*sessionTicketState, error) {
+	keyName, aead, err := sessionTicketKeys(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ticket) < sessionTicketKeyNameLength+sessionTicketNonceLength+aead.Overhead() {
+		return nil, errInvalidSessionTicket
+	}
+	if subtle.ConstantTimeCompare(keyName, ticket[:sessionTicketKeyNameLength]) != 1 {
+		return nil, errSessionTicketUnknownKey
+	}
+
+	nonce := ticket[sessionTicketKeyNameLength : sessionTicketKeyNameLength+sessionTicketNonceLength]
+	plaintext, err := aead.Open(nil, nonce, ticket[sessionTicketKeyNameLength+sessionTicketNonceLength:], keyName)
+	if err != nil {
+		return nil, errInvalidSessionTicket
+	}
+
+	s := &sessionTicketState{}
+	if err := s.unmarshal(plaintext); err != nil {
+		return nil, err
+	}
+
+	if now.Before(s.createdAt) || now.Sub(s.createdAt) > lifetime {
+		return nil, errSessionTicketExpired
+	}
+	return s, nil
+}
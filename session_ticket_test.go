@@ -0,0 +1,69 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSessionTicketEncryption(t *testing.T) {
+	key := bytes.Repeat([]byte{0xAB}, sessionTicketKeyLength)
+	now := time.Unix(1700000000, 0)
+	state := &sessionTicketState{
+		createdAt:            now,
+		cipherSuiteID:        TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		extendedMasterSecret: true,
+		masterSecret:         bytes.Repeat([]byte{0x42}, 48),
+	}
+
+	ticket, err := encryptSessionTicket(key, rand.Reader, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		s, err := decryptSessionTicket(key, ticket, time.Hour, now.Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !s.createdAt.Equal(state.createdAt) || s.cipherSuiteID != state.cipherSuiteID ||
+			s.extendedMasterSecret != state.extendedMasterSecret || !bytes.Equal(s.masterSecret, state.masterSecret) {
+			t.Errorf("Ticket state mismatch: expected(%v) actual(%v)", state, s)
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		if _, err := decryptSessionTicket(key, ticket, time.Hour, now.Add(2*time.Hour)); !errors.Is(err, errSessionTicketExpired) {
+			t.Errorf("Expected error: %v, got: %v", errSessionTicketExpired, err)
+		}
+	})
+
+	t.Run("RotatedKey", func(t *testing.T) {
+		otherKey := bytes.Repeat([]byte{0xCD}, sessionTicketKeyLength)
+		if _, err := decryptSessionTicket(otherKey, ticket, time.Hour, now); !errors.Is(err, errSessionTicketUnknownKey) {
+			t.Errorf("Expected error: %v, got: %v", errSessionTicketUnknownKey, err)
+		}
+	})
+
+	t.Run("Tampered", func(t *testing.T) {
+		tampered := append([]byte{}, ticket...)
+		tampered[len(tampered)-1] ^= 0xFF
+		if _, err := decryptSessionTicket(key, tampered, time.Hour, now); !errors.Is(err, errInvalidSessionTicket) {
+			t.Errorf("Expected error: %v, got: %v", errInvalidSessionTicket, err)
+		}
+		if _, err := decryptSessionTicket(key, ticket[:8], time.Hour, now); !errors.Is(err, errInvalidSessionTicket) {
+			t.Errorf("Expected error: %v, got: %v", errInvalidSessionTicket, err)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		if _, err := encryptSessionTicket(key[:16], rand.Reader, state); !errors.Is(err, errInvalidSessionTicketKey) {
+			t.Errorf("Expected error: %v, got: %v", errInvalidSessionTicketKey, err)
+		}
+	})
+}
@@ -15,3 +15,23 @@ const (
 	SRTP_AEAD_AES_128_GCM       SRTPProtectionProfile = extension.SRTP_AEAD_AES_128_GCM       // nolint:revive,stylecheck
 	SRTP_AEAD_AES_256_GCM       SRTPProtectionProfile = extension.SRTP_AEAD_AES_256_GCM       // nolint:revive,stylecheck
 )
+
+// srtpKeyingMaterialLabel is the exporter label of the SRTP keying material
+// https://tools.ietf.org/html/rfc5764#section-4.2
+const srtpKeyingMaterialLabel = "EXTRACTOR-dtls_srtp"
+
+// srtpKeyingMaterialLength returns the master key and master salt lengths of
+// profile https://tools.ietf.org/html/rfc5764#section-4.1.2
+// https://tools.ietf.org/html/rfc7714#section-14.2
+func srtpKeyingMaterialLength(profile SRTPProtectionProfile) (keyLen, saltLen int, ok bool) {
+	switch profile {
+	case SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32:
+		return 16, 14, true
+	case SRTP_AEAD_AES_128_GCM:
+		return 16, 12, true
+	case SRTP_AEAD_AES_256_GCM:
+		return 32, 12, true
+	default:
+		return 0, 0, false
+	}
+}
@@ -5,12 +5,18 @@ package dtls
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"math"
 	"sync/atomic"
 
 	"github.com/adrian38/dtls/v2/pkg/crypto/elliptic"
 	"github.com/adrian38/dtls/v2/pkg/crypto/prf"
 	"github.com/adrian38/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
 	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
 	"github.com/pion/transport/v3/replaydetector"
 )
@@ -29,6 +35,28 @@ type State struct {
 	IdentityHint          []byte
 	SessionID             []byte
 
+	// PeerRawPublicKey is set if the peer authenticated with a raw public key
+	// instead of a certificate, PeerCertificates then holds its DER encoded
+	// SubjectPublicKeyInfo https://tools.ietf.org/html/rfc7250
+	PeerRawPublicKey bool
+
+	// OCSPResponse is the DER encoded OCSP response stapled by the server,
+	// nil if the server didn't staple one
+	OCSPResponse []byte
+
+	// SignedCertificateTimestamps are the SCTs of the server certificate, as
+	// delivered in the signed_certificate_timestamp extension or embedded in
+	// the certificate. Only set on the client.
+	SignedCertificateTimestamps [][]byte
+
+	// PeerApplicationSettings are the ALPS settings the peer sent for
+	// NegotiatedProtocol. It is nil if the settings weren't exchanged or are
+	// empty.
+	PeerApplicationSettings []byte
+
+	// Version is the protocol version agreed in ServerHello
+	Version protocol.Version
+
 	// Connection Identifiers must be negotiated afresh on session resumption.
 	// https://datatracker.ietf.org/doc/html/rfc9146#name-the-connection_id-extension
 
@@ -47,6 +75,17 @@ type State struct {
 
 	preMasterSecret      []byte
 	extendedMasterSecret bool
+	// sessionExtendedMasterSecret is set when the session a client tries to
+	// resume used the extended master secret
+	sessionExtendedMasterSecret bool
+
+	// sessionTicketNegotiated is set when the server is going to send a
+	// NewSessionTicket message in this handshake.
+	sessionTicketNegotiated bool
+	// sessionTicket is the ticket presented by a client or issued by a server.
+	sessionTicket []byte
+	// resumed is set if the handshake resumed a previous session
+	resumed bool
 
 	namedCurve                 elliptic.Curve
 	localKeypair               *elliptic.Keypair
@@ -55,39 +94,111 @@ type State struct {
 	handshakeRecvSequence      int
 	serverName                 string
 	remoteCertRequestAlgs      []signaturehash.Algorithm
-	remoteRequestedCertificate bool   // Did we get a CertificateRequest
-	localCertificatesVerify    []byte // cache CertificateVerify
-	localVerifyData            []byte // cached VerifyData
-	localKeySignature          []byte // cached keySignature
+	remoteSignatureSchemes     []signaturehash.Algorithm // signature_algorithms offered in ClientHello
+	remoteRequestedSCT         bool                      // Did the client send signed_certificate_timestamp
+	remoteSCTs                 [][]byte                  // SCTs delivered in ServerHello
+	remoteRequestedOCSP        bool                      // Did the client send status_request
+	remotePostHandshakeAuth    bool                      // Did the client send post_handshake_auth
+	remoteRequestedCertificate bool                      // Did we get a CertificateRequest
+	localCertificatesVerify    []byte                    // cache CertificateVerify
+	localVerifyData            []byte                    // cached VerifyData
+	localKeySignature          []byte                    // cached keySignature
 	peerCertificatesVerified   bool
+	remoteTrustedAuthorities   []extension.TrustedAuthority // trusted_ca_keys sent by the client
 
 	replayDetector []replaydetector.ReplayDetector
 
 	peerSupportedProtocols []string
 	NegotiatedProtocol     string
+
+	// remoteSRTPProtectionProfiles are the profiles offered in use_srtp
+	remoteSRTPProtectionProfiles []SRTPProtectionProfile
+
+	// remoteApplicationSettings are the settings offered by the client
+	remoteApplicationSettings *extension.ApplicationSettings
+
+	// record_size_limit values, zero if not negotiated
+	localRecordSizeLimit  uint16 // Limit we advertised, enforced on incoming records
+	remoteRecordSizeLimit uint16 // Limit the peer advertised, enforced on outgoing records
+
+	// max_fragment_length applied in both directions, zero if not negotiated
+	maxFragmentLength FragmentLength
+
+	// heartbeat modes, zero if not negotiated
+	localHeartbeatMode  HeartbeatMode // Whether we accept HeartbeatRequests
+	remoteHeartbeatMode HeartbeatMode // Whether the peer accepts HeartbeatRequests
+
+	// recordPadding is set if application data records are padded, see
+	// extension.RecordPadding
+	recordPadding bool
+
+	// encryptThenMAC is set if records of the CBC cipher suite are MACed
+	// after encryption, see extension.EncryptThenMAC
+	encryptThenMAC bool
+
+	// Certificate types selected through the client_certificate_type and
+	// server_certificate_type extensions, nil if the extension is absent
+	clientCertificateType *extension.CertificateType
+	serverCertificateType *extension.CertificateType
+
+	// verify_data of the Finished messages of the last completed handshake,
+	// a renegotiation is bound to them through the renegotiation_info extension
+	// https://tools.ietf.org/html/rfc5746#section-3.1
+	clientVerifyData, serverVerifyData []byte
+	// secureRenegotiation is set if the peer sent renegotiation_info in the
+	// initial handshake
+	secureRenegotiation bool
 }
 
+// serializedStateVersion is bumped whenever serializedState changes in a way
+// older releases can't decode correctly. States without a FormatVersion were
+// written by releases predating it and decode as version 0.
+//
+//	1: FormatVersion, record size limits and max_fragment_length
+//	2: RecordPadding
+//	3: EncryptThenMAC
+//	4: PeerRawPublicKey
+//	5: RemotePostHandshakeAuth
+const serializedStateVersion = 5
+
 type serializedState struct {
-	LocalEpoch            uint16
-	RemoteEpoch           uint16
-	LocalRandom           [handshake.RandomLength]byte
-	RemoteRandom          [handshake.RandomLength]byte
-	CipherSuiteID         uint16
-	MasterSecret          []byte
-	SequenceNumber        uint64
-	SRTPProtectionProfile uint16
-	PeerCertificates      [][]byte
-	IdentityHint          []byte
-	SessionID             []byte
-	LocalConnectionID     []byte
-	RemoteConnectionID    []byte
-	IsClient              bool
+	FormatVersion               uint8
+	LocalEpoch                  uint16
+	RemoteEpoch                 uint16
+	LocalRandom                 [handshake.RandomLength]byte
+	RemoteRandom                [handshake.RandomLength]byte
+	CipherSuiteID               uint16
+	MasterSecret                []byte
+	SequenceNumber              uint64
+	SRTPProtectionProfile       uint16
+	PeerCertificates            [][]byte
+	PeerRawPublicKey            bool
+	IdentityHint                []byte
+	SessionID                   []byte
+	OCSPResponse                []byte
+	SignedCertificateTimestamps [][]byte
+	LocalConnectionID           []byte
+	RemoteConnectionID          []byte
+	IsClient                    bool
+	PeerApplicationSettings     []byte
+	Version                     protocol.Version
+	LocalRecordSizeLimit        uint16
+	RemoteRecordSizeLimit       uint16
+	MaxFragmentLength           uint8
+	ServerName                  string
+	ExtendedMasterSecret        bool
+	Resumed                     bool
+	RecordPadding               bool
+	EncryptThenMAC              bool
+	RemotePostHandshakeAuth     bool
 }
 
 func (s *State) clone() *State {
 	serialized := s.serialize()
 	state := &State{}
 	state.deserialize(*serialized)
+	// NegotiatedProtocol isn't part of the serialized state
+	state.NegotiatedProtocol = s.NegotiatedProtocol
 
 	return state
 }
@@ -99,20 +210,35 @@ func (s *State) serialize() *serializedState {
 
 	epoch := s.getLocalEpoch()
 	return &serializedState{
-		LocalEpoch:            s.getLocalEpoch(),
-		RemoteEpoch:           s.getRemoteEpoch(),
-		CipherSuiteID:         uint16(s.cipherSuite.ID()),
-		MasterSecret:          s.masterSecret,
-		SequenceNumber:        atomic.LoadUint64(&s.localSequenceNumber[epoch]),
-		LocalRandom:           localRnd,
-		RemoteRandom:          remoteRnd,
-		SRTPProtectionProfile: uint16(s.getSRTPProtectionProfile()),
-		PeerCertificates:      s.PeerCertificates,
-		IdentityHint:          s.IdentityHint,
-		SessionID:             s.SessionID,
-		LocalConnectionID:     s.localConnectionID,
-		RemoteConnectionID:    s.remoteConnectionID,
-		IsClient:              s.isClient,
+		FormatVersion:               serializedStateVersion,
+		LocalEpoch:                  s.getLocalEpoch(),
+		RemoteEpoch:                 s.getRemoteEpoch(),
+		CipherSuiteID:               uint16(s.cipherSuite.ID()),
+		MasterSecret:                s.masterSecret,
+		SequenceNumber:              atomic.LoadUint64(&s.localSequenceNumber[epoch]),
+		LocalRandom:                 localRnd,
+		RemoteRandom:                remoteRnd,
+		SRTPProtectionProfile:       uint16(s.getSRTPProtectionProfile()),
+		PeerCertificates:            s.PeerCertificates,
+		PeerRawPublicKey:            s.PeerRawPublicKey,
+		IdentityHint:                s.IdentityHint,
+		SessionID:                   s.SessionID,
+		OCSPResponse:                s.OCSPResponse,
+		SignedCertificateTimestamps: s.SignedCertificateTimestamps,
+		LocalConnectionID:           s.localConnectionID,
+		RemoteConnectionID:          s.remoteConnectionID,
+		IsClient:                    s.isClient,
+		PeerApplicationSettings:     s.PeerApplicationSettings,
+		Version:                     s.Version,
+		LocalRecordSizeLimit:        s.localRecordSizeLimit,
+		RemoteRecordSizeLimit:       s.remoteRecordSizeLimit,
+		MaxFragmentLength:           uint8(s.maxFragmentLength),
+		ServerName:                  s.serverName,
+		ExtendedMasterSecret:        s.extendedMasterSecret,
+		Resumed:                     s.resumed,
+		RecordPadding:               s.recordPadding,
+		EncryptThenMAC:              s.encryptThenMAC,
+		RemotePostHandshakeAuth:     s.remotePostHandshakeAuth,
 	}
 }
 
@@ -149,6 +275,7 @@ func (s *State) deserialize(serialized serializedState) {
 
 	// Set remote certificate
 	s.PeerCertificates = serialized.PeerCertificates
+	s.PeerRawPublicKey = serialized.PeerRawPublicKey
 
 	s.IdentityHint = serialized.IdentityHint
 
@@ -157,6 +284,24 @@ func (s *State) deserialize(serialized serializedState) {
 	s.remoteConnectionID = serialized.RemoteConnectionID
 
 	s.SessionID = serialized.SessionID
+
+	s.OCSPResponse = serialized.OCSPResponse
+	s.SignedCertificateTimestamps = serialized.SignedCertificateTimestamps
+
+	s.PeerApplicationSettings = serialized.PeerApplicationSettings
+
+	s.Version = serialized.Version
+
+	s.localRecordSizeLimit = serialized.LocalRecordSizeLimit
+	s.remoteRecordSizeLimit = serialized.RemoteRecordSizeLimit
+	s.maxFragmentLength = FragmentLength(serialized.MaxFragmentLength)
+
+	s.serverName = serialized.ServerName
+	s.extendedMasterSecret = serialized.ExtendedMasterSecret
+	s.resumed = serialized.Resumed
+	s.recordPadding = serialized.RecordPadding
+	s.encryptThenMAC = serialized.EncryptThenMAC
+	s.remotePostHandshakeAuth = serialized.RemotePostHandshakeAuth
 }
 
 func (s *State) initCipherSuite() error {
@@ -166,6 +311,7 @@ func (s *State) initCipherSuite() error {
 
 	localRandom := s.localRandom.MarshalFixed()
 	remoteRandom := s.remoteRandom.MarshalFixed()
+	s.applyEncryptThenMAC()
 
 	var err error
 	if s.isClient {
@@ -179,6 +325,24 @@ func (s *State) initCipherSuite() error {
 	return nil
 }
 
+// rawPublicKey reports whether the Certificate of the client or of the server
+// carries a raw public key
+func (s *State) rawPublicKey(client bool) bool {
+	t := s.serverCertificateType
+	if client {
+		t = s.clientCertificateType
+	}
+	return t != nil && *t == extension.CertificateTypeRawPublicKey
+}
+
+// applyEncryptThenMAC passes the negotiated record processing to a CBC
+// cipher suite before it is initialized
+func (s *State) applyEncryptThenMAC() {
+	if c, ok := s.cipherSuite.(encryptThenMACCipherSuite); ok {
+		c.SetEncryptThenMAC(s.encryptThenMAC)
+	}
+}
+
 // MarshalBinary is a binary.BinaryMarshaler.MarshalBinary implementation
 func (s *State) MarshalBinary() ([]byte, error) {
 	serialized := s.serialize()
@@ -198,21 +362,82 @@ func (s *State) UnmarshalBinary(data []byte) error {
 	if err := enc.Decode(&serialized); err != nil {
 		return err
 	}
+	if serialized.FormatVersion > serializedStateVersion {
+		return errUnsupportedStateVersion
+	}
 
 	s.deserialize(serialized)
+	if s.cipherSuite == nil {
+		return &invalidCipherSuiteError{s.CipherSuiteID}
+	}
 
 	return s.initCipherSuite()
 }
 
+// jsonState is the JSON form of State, it describes the negotiated
+// connection and never carries key material
+type jsonState struct {
+	CipherSuite            string   `json:"cipher_suite"`
+	Version                string   `json:"version"`
+	PeerCertificates       []string `json:"peer_certificates,omitempty"`
+	PeerRawPublicKey       bool     `json:"peer_raw_public_key,omitempty"`
+	ServerName             string   `json:"server_name,omitempty"`
+	NegotiatedProtocol     string   `json:"negotiated_protocol,omitempty"`
+	Resumed                bool     `json:"resumed"`
+	ExtendedMasterSecret   bool     `json:"extended_master_secret"`
+	ConnectionIDNegotiated bool     `json:"connection_id"`
+}
+
+// MarshalJSON is a json.Marshaler.MarshalJSON implementation. It reports the
+// cipher suite by name and the subjects of the peer certificates, secrets such
+// as the master secret are not included. It has a value receiver so the State
+// returned by Conn.ConnectionState can be marshaled directly.
+func (s State) MarshalJSON() ([]byte, error) {
+	j := jsonState{
+		CipherSuite:            CipherSuiteName(s.CipherSuiteID),
+		Version:                versionName(s.Version),
+		ServerName:             s.serverName,
+		NegotiatedProtocol:     s.NegotiatedProtocol,
+		Resumed:                s.resumed,
+		ExtendedMasterSecret:   s.extendedMasterSecret,
+		ConnectionIDNegotiated: s.localConnectionID != nil || s.remoteConnectionID != nil,
+		PeerRawPublicKey:       s.PeerRawPublicKey,
+	}
+	for _, raw := range s.PeerCertificates {
+		if s.PeerRawPublicKey {
+			break
+		}
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		j.PeerCertificates = append(j.PeerCertificates, cert.Subject.String())
+	}
+	return json.Marshal(j)
+}
+
+// versionName returns the name of a DTLS protocol version
+func versionName(v protocol.Version) string {
+	switch {
+	case v.Equal(protocol.Version1_2):
+		return "DTLS 1.2"
+	case v.Equal(protocol.Version1_0):
+		return "DTLS 1.0"
+	default:
+		return fmt.Sprintf("0x%02x%02x", v.Major, v.Minor)
+	}
+}
+
 // ExportKeyingMaterial returns length bytes of exported key material in a new
 // slice as defined in RFC 5705.
 // This allows protocols to use DTLS for key establishment, but
-// then use some of the keying material for their own purposes
+// then use some of the keying material for their own purposes.
+// If context is nil, it is not used as part of the seed.
 func (s *State) ExportKeyingMaterial(label string, context []byte, length int) ([]byte, error) {
 	if s.getLocalEpoch() == 0 {
 		return nil, errHandshakeInProgress
-	} else if len(context) != 0 {
-		return nil, errContextUnsupported
+	} else if len(context) > math.MaxUint16 {
+		return nil, errContextTooLong
 	} else if _, ok := invalidKeyingLabels()[label]; ok {
 		return nil, errReservedExportKeyingMaterial
 	}
@@ -226,6 +451,10 @@ func (s *State) ExportKeyingMaterial(label string, context []byte, length int) (
 	} else {
 		seed = append(append(seed, remoteRandom[:]...), localRandom[:]...)
 	}
+	if context != nil {
+		seed = append(seed, byte(len(context)>>8), byte(len(context)))
+		seed = append(seed, context...)
+	}
 	return prf.PHash(s.masterSecret, seed, length, s.cipherSuite.HashFunc())
 }
 
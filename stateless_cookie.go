@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol"
+	"github.com/adrian38/dtls/v2/pkg/protocol/handshake"
+	"github.com/adrian38/dtls/v2/pkg/protocol/recordlayer"
+)
+
+const (
+	// minStatelessCookieSecretLength is the minimum length of
+	// Config.StatelessCookieSecret
+	minStatelessCookieSecretLength = 16
+
+	// defaultStatelessCookieLifetime is how long a client may take to answer
+	// a HelloVerifyRequest carrying a stateless cookie, see
+	// Config.CookieLifetime
+	defaultStatelessCookieLifetime = time.Minute
+
+	// statelessCookieLength is the length of the timestamp and the HMAC
+	statelessCookieLength = 8 + sha256.Size
+
+	// statelessHelloTimeout bounds the wait of a listener for the first
+	// datagram of an accepted connection
+	statelessHelloTimeout = time.Second
+)
+
+// statelessCookies issues and verifies cookies which carry everything needed
+// to verify them: the time they were issued and an HMAC over that time, the
+// client address and the client random of the ClientHello they answer.
+// https://datatracker.ietf.org/doc/html/rfc6347#section-4.2.1
+type statelessCookies struct {
+	secret   []byte
+	now      func() time.Time
+	lifetime time.Duration
+}
+
+func newStatelessCookies(secret []byte, now func() time.Time, lifetime time.Duration) *statelessCookies {
+	if now == nil {
+		now = time.Now
+	}
+	if lifetime == 0 {
+		lifetime = defaultStatelessCookieLifetime
+	}
+	return &statelessCookies{secret: secret, now: now, lifetime: lifetime}
+}
+
+// generate returns a cookie for the ClientHello with clientRandom sent by raddr
+func (s *statelessCookies) generate(raddr net.Addr, clientRandom []byte) []byte {
+	cookie := make([]byte, 8, statelessCookieLength)
+	binary.BigEndian.PutUint64(cookie, uint64(s.now().Unix()))
+	return append(cookie, s.mac(cookie[:8], raddr, clientRandom)...)
+}
+
+// verify checks a cookie of the ClientHello with clientRandom sent by raddr
+func (s *statelessCookies) verify(raddr net.Addr, clientRandom, cookie []byte) error {
+	if len(cookie) != statelessCookieLength {
+		return errCookieMismatch
+	}
+	if !hmac.Equal(cookie[8:], s.mac(cookie[:8], raddr, clientRandom)) {
+		return errCookieMismatch
+	}
+	issued := time.Unix(int64(binary.BigEndian.Uint64(cookie)), 0)
+	if age := s.now().Sub(issued); age < -time.Second || age > s.lifetime {
+		return fmt.Errorf("%w: issued %v ago, lifetime %v", errCookieExpired, age, s.lifetime)
+	}
+	return nil
+}
+
+func (s *statelessCookies) mac(timestamp []byte, raddr net.Addr, clientRandom []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	_, _ = h.Write(timestamp)
+	_, _ = h.Write(clientRandom)
+	_, _ = h.Write([]byte(raddr.String()))
+	return h.Sum(nil)
+}
+
+// answerHello reads the first datagram of a connection accepted by a
+// listener. A ClientHello with a valid cookie is returned to be read again by
+// the handshake, any other ClientHello is answered with a HelloVerifyRequest
+// carrying a new cookie and dropped together with the connection, which
+// holds no handshake state yet.
+func (s *statelessCookies) answerHello(c net.PacketConn, raddr net.Addr) ([]byte, bool) {
+	if err := c.SetReadDeadline(time.Now().Add(statelessHelloTimeout)); err != nil {
+		return nil, false
+	}
+	buf := make([]byte, inboundBufferSize)
+	n, _, err := c.ReadFrom(buf)
+	if err != nil {
+		return nil, false
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		return nil, false
+	}
+
+	header, clientHello, ok := parseInitialClientHello(buf[:n])
+	if !ok {
+		return nil, false
+	}
+	random := clientHello.Random.MarshalFixed()
+	if len(clientHello.Cookie) > 0 && s.verify(raddr, random[:], clientHello.Cookie) == nil {
+		return buf[:n], true
+	}
+
+	// The HelloVerifyRequest repeats the record sequence number of the
+	// ClientHello, so that the server keeps no sequence number state
+	helloVerifyRequest := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version:        protocol.Version1_2,
+			SequenceNumber: header.SequenceNumber,
+		},
+		Content: &handshake.Handshake{
+			Message: &handshake.MessageHelloVerifyRequest{
+				Version: protocol.Version1_2,
+				Cookie:  s.generate(raddr, random[:]),
+			},
+		},
+	}
+	raw, err := helloVerifyRequest.Marshal()
+	if err != nil {
+		return nil, false
+	}
+	_, _ = c.WriteTo(raw, raddr)
+	return nil, false
+}
+
+// parseInitialClientHello parses an unfragmented ClientHello in the first
+// record of an epoch 0 datagram
+func parseInitialClientHello(datagram []byte) (*recordlayer.Header, *handshake.MessageClientHello, bool) {
+	pkts, err := recordlayer.UnpackDatagram(datagram)
+	if err != nil || len(pkts) < 1 {
+		return nil, nil, false
+	}
+	header := &recordlayer.Header{}
+	if err := header.Unmarshal(pkts[0]); err != nil || header.ContentType != protocol.ContentTypeHandshake || header.Epoch != 0 {
+		return nil, nil, false
+	}
+	var hh handshake.Header
+	if err := hh.Unmarshal(pkts[0][recordlayer.FixedHeaderSize:]); err != nil {
+		return nil, nil, false
+	}
+	body := pkts[0][recordlayer.FixedHeaderSize+handshake.HeaderLength:]
+	if hh.Type != handshake.TypeClientHello || hh.FragmentOffset != 0 || hh.FragmentLength != hh.Length || int(hh.Length) != len(body) {
+		return nil, nil, false
+	}
+	clientHello := &handshake.MessageClientHello{}
+	if err := clientHello.Unmarshal(body); err != nil {
+		return nil, nil, false
+	}
+	return header, clientHello, true
+}
+
+// replayPacketConn returns a datagram that was already read from the
+// PacketConn once more before reading from the PacketConn again
+type replayPacketConn struct {
+	net.PacketConn
+
+	mu       sync.Mutex
+	datagram []byte
+	raddr    net.Addr
+}
+
+func (c *replayPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	if datagram := c.datagram; datagram != nil {
+		c.datagram = nil
+		c.mu.Unlock()
+		return copy(p, datagram), c.raddr, nil
+	}
+	c.mu.Unlock()
+	return c.PacketConn.ReadFrom(p)
+}
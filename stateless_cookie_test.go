@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatelessCookies(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	cookies := newStatelessCookies([]byte("0123456789abcdef"), func() time.Time { return now }, 0)
+	raddr := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5684}
+	random := make([]byte, 32)
+
+	cookie := cookies.generate(raddr, random)
+	if len(cookie) != statelessCookieLength {
+		t.Fatalf("Expected a %d byte cookie, got %d bytes", statelessCookieLength, len(cookie))
+	}
+	tampered := append([]byte{}, cookie...)
+	tampered[len(tampered)-1] ^= 0xff
+
+	for name, tt := range map[string]struct {
+		raddr    net.Addr
+		random   []byte
+		cookie   []byte
+		age      time.Duration
+		lifetime time.Duration
+		expected error
+	}{
+		"Valid": {
+			raddr:  raddr,
+			random: random,
+			cookie: cookie,
+			age:    defaultStatelessCookieLifetime,
+		},
+		"Expired": {
+			raddr:    raddr,
+			random:   random,
+			cookie:   cookie,
+			age:      defaultStatelessCookieLifetime + time.Second,
+			expected: errCookieExpired,
+		},
+		"ValidLifetime": {
+			raddr:    raddr,
+			random:   random,
+			cookie:   cookie,
+			age:      5 * time.Minute,
+			lifetime: 5 * time.Minute,
+		},
+		"ExpiredLifetime": {
+			raddr:    raddr,
+			random:   random,
+			cookie:   cookie,
+			age:      11 * time.Second,
+			lifetime: 10 * time.Second,
+			expected: errCookieExpired,
+		},
+		"OtherAddress": {
+			raddr:    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 5685},
+			random:   random,
+			cookie:   cookie,
+			expected: errCookieMismatch,
+		},
+		"OtherRandom": {
+			raddr:    raddr,
+			random:   append([]byte{1}, random[1:]...),
+			cookie:   cookie,
+			expected: errCookieMismatch,
+		},
+		"Tampered": {
+			raddr:    raddr,
+			random:   random,
+			cookie:   tampered,
+			expected: errCookieMismatch,
+		},
+		"Truncated": {
+			raddr:    raddr,
+			random:   random,
+			cookie:   cookie[:len(cookie)-1],
+			expected: errCookieMismatch,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			verifier := newStatelessCookies([]byte("0123456789abcdef"), func() time.Time { return now.Add(tt.age) }, tt.lifetime)
+			if err := verifier.verify(tt.raddr, tt.random, tt.cookie); !errors.Is(err, tt.expected) {
+				t.Fatalf("Expected error '%v', got '%v'", tt.expected, err)
+			}
+		})
+	}
+
+	// Servers of a cluster must share the secret
+	other := newStatelessCookies([]byte("fedcba9876543210"), func() time.Time { return now }, 0)
+	if err := other.verify(raddr, random, cookie); !errors.Is(err, errCookieMismatch) {
+		t.Fatalf("Expected error '%v', got '%v'", errCookieMismatch, err)
+	}
+}
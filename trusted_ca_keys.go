@@ -0,0 +1,87 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+
+	"github.com/adrian38/dtls/v2/pkg/protocol/extension"
+)
+
+// trustedAuthoritiesFromCertificates returns the key_sha1_hash identifiers
+// of the certificate authorities a client advertises in trusted_ca_keys
+func trustedAuthoritiesFromCertificates(certs []*x509.Certificate) []extension.TrustedAuthority {
+	var authorities []extension.TrustedAuthority
+	for _, cert := range certs {
+		if hash := keySHA1Hash(cert); hash != nil {
+			authorities = append(authorities, extension.TrustedAuthority{
+				IdentifierType: extension.TrustedAuthorityKeySHA1Hash,
+				Identifier:     hash,
+			})
+		}
+	}
+	return authorities
+}
+
+// keySHA1Hash returns the SHA-1 hash of the public key of cert. It is the
+// hash of the modulus for RSA keys and of the subjectPublicKey for others.
+// https://tools.ietf.org/html/rfc6066#section-6
+func keySHA1Hash(cert *x509.Certificate) []byte {
+	var key []byte
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		key = pub.N.Bytes()
+	} else {
+		var spki struct {
+			Algorithm asn1.RawValue
+			PublicKey asn1.BitString
+		}
+		if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+			return nil
+		}
+		key = spki.PublicKey.RightAlign()
+	}
+	hash := sha1.Sum(key) //nolint:gosec
+	return hash[:]
+}
+
+// matchesTrustedAuthorities reports whether any certificate of the chain is
+// issued by, or is, one of the authorities a client advertised
+func matchesTrustedAuthorities(chain *tls.Certificate, authorities []extension.TrustedAuthority) bool {
+	for i, raw := range chain.Certificate {
+		cert := chain.Leaf
+		if i != 0 || cert == nil {
+			var err error
+			if cert, err = x509.ParseCertificate(raw); err != nil {
+				return false
+			}
+		}
+		for _, a := range authorities {
+			if matchesTrustedAuthority(cert, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchesTrustedAuthority(cert *x509.Certificate, a extension.TrustedAuthority) bool {
+	switch a.IdentifierType {
+	case extension.TrustedAuthorityKeySHA1Hash:
+		// The issuer of the last certificate of a chain is usually not part
+		// of it, its key is only known by the authority key identifier
+		return bytes.Equal(a.Identifier, keySHA1Hash(cert)) || bytes.Equal(a.Identifier, cert.AuthorityKeyId)
+	case extension.TrustedAuthorityX509Name:
+		return bytes.Equal(a.Identifier, cert.RawIssuer) || bytes.Equal(a.Identifier, cert.RawSubject)
+	case extension.TrustedAuthorityCertSHA1Hash:
+		hash := sha1.Sum(cert.Raw) //nolint:gosec
+		return bytes.Equal(a.Identifier, hash[:])
+	default:
+		return false
+	}
+}
@@ -3,6 +3,11 @@
 
 package dtls
 
+import (
+	"context"
+	"time"
+)
+
 func findMatchingSRTPProfile(a, b []SRTPProtectionProfile) (SRTPProtectionProfile, bool) {
 	for _, aProfile := range a {
 		for _, bProfile := range b {
@@ -25,6 +30,30 @@ func findMatchingCipherSuite(a, b []CipherSuite) (CipherSuite, bool) {
 	return nil, false
 }
 
+// findPreferredCipherSuite returns the suite of offered that comes first in
+// preferred
+func findPreferredCipherSuite(offered, preferred []CipherSuite) (CipherSuite, bool) {
+	for _, pSuite := range preferred {
+		for _, oSuite := range offered {
+			if oSuite.ID() == pSuite.ID() {
+				return oSuite, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// isOfferedProtocol reports whether protocol is one of the offered ALPN
+// protocols
+func isOfferedProtocol(protocol string, offered []string) bool {
+	for _, o := range offered {
+		if o == protocol {
+			return true
+		}
+	}
+	return false
+}
+
 func splitBytes(bytes []byte, splitLen int) [][]byte {
 	splitBytes := make([][]byte, 0)
 	numBytes := len(bytes)
@@ -39,3 +68,20 @@ func splitBytes(bytes []byte, splitLen int) [][]byte {
 
 	return splitBytes
 }
+
+// valueOnlyContext keeps the values of a context but is never canceled
+type valueOnlyContext struct {
+	context.Context //nolint:containedctx
+}
+
+func (valueOnlyContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (valueOnlyContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (valueOnlyContext) Err() error {
+	return nil
+}